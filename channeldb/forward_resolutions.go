@@ -0,0 +1,174 @@
+package channeldb
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/boltdb/bolt"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+var (
+	// forwardResolutionBucket is the name of the bucket within which we
+	// store the settles and fails of forwarded HTLC's which have yet to be
+	// committed to by the incoming channel. Each resolution is keyed by
+	// the short channel ID of the incoming channel, followed by the ID of
+	// the HTLC within it.
+	forwardResolutionBucket = []byte("forward-resolutions")
+)
+
+// ForwardResolution is the settle or fail of a forwarded HTLC which has yet
+// to be committed to by the channel over which the HTLC was received.
+type ForwardResolution struct {
+	// IncomingChanID is the channel over which the resolved HTLC was
+	// received.
+	IncomingChanID lnwire.ShortChannelID
+
+	// IncomingHTLCID is the ID of the resolved HTLC within the incoming
+	// channel.
+	IncomingHTLCID uint64
+
+	// Amount is the value of the resolved HTLC.
+	Amount lnwire.MilliSatoshi
+
+	// Preimage is the preimage of the HTLC's payment hash if it was
+	// settled. If nil, then the HTLC was failed.
+	Preimage *[32]byte
+
+	// FailReason is the encrypted reason for the failure of the HTLC.
+	FailReason []byte
+}
+
+// ResolutionLog is a persistent log of the settles and fails of forwarded
+// HTLC's, which allows them to be replayed to the incoming channel should we
+// crash before it has committed to them.
+type ResolutionLog struct {
+	db *DB
+}
+
+// NewResolutionLog returns a new instance of the resolution log.
+func (d *DB) NewResolutionLog() *ResolutionLog {
+	return &ResolutionLog{
+		db: d,
+	}
+}
+
+// resolutionKey returns the key under which the resolution of the target
+// incoming HTLC is stored.
+func resolutionKey(chanID lnwire.ShortChannelID, htlcID uint64) []byte {
+	var key [16]byte
+	byteOrder.PutUint64(key[:8], chanID.ToUint64())
+	byteOrder.PutUint64(key[8:], htlcID)
+	return key[:]
+}
+
+// AddResolution persists the passed resolution, replacing any prior
+// resolution for the same incoming HTLC.
+func (r *ResolutionLog) AddResolution(resolution *ForwardResolution) error {
+	var b bytes.Buffer
+	if err := serializeForwardResolution(&b, resolution); err != nil {
+		return err
+	}
+
+	return r.db.Batch(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(
+			forwardResolutionBucket,
+		)
+		if err != nil {
+			return err
+		}
+
+		key := resolutionKey(
+			resolution.IncomingChanID, resolution.IncomingHTLCID,
+		)
+		return bucket.Put(key, b.Bytes())
+	})
+}
+
+// RemoveResolution removes the resolution for the target incoming HTLC, if
+// any.
+func (r *ResolutionLog) RemoveResolution(chanID lnwire.ShortChannelID,
+	htlcID uint64) error {
+
+	return r.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(forwardResolutionBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete(resolutionKey(chanID, htlcID))
+	})
+}
+
+// FetchResolutions returns all persisted resolutions.
+func (r *ResolutionLog) FetchResolutions() ([]*ForwardResolution, error) {
+	var resolutions []*ForwardResolution
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(forwardResolutionBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			resolution, err := deserializeForwardResolution(
+				bytes.NewReader(v),
+			)
+			if err != nil {
+				return err
+			}
+
+			resolutions = append(resolutions, resolution)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resolutions, nil
+}
+
+func serializeForwardResolution(w io.Writer, r *ForwardResolution) error {
+	err := writeElements(
+		w, r.IncomingChanID, r.IncomingHTLCID, r.Amount,
+		r.Preimage != nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	if r.Preimage != nil {
+		return writeElement(w, *r.Preimage)
+	}
+
+	return writeElement(w, r.FailReason)
+}
+
+func deserializeForwardResolution(r io.Reader) (*ForwardResolution, error) {
+	resolution := &ForwardResolution{}
+
+	var settled bool
+	err := readElements(
+		r, &resolution.IncomingChanID, &resolution.IncomingHTLCID,
+		&resolution.Amount, &settled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if settled {
+		var preimage [32]byte
+		if err := readElement(r, &preimage); err != nil {
+			return nil, err
+		}
+		resolution.Preimage = &preimage
+
+		return resolution, nil
+	}
+
+	if err := readElement(r, &resolution.FailReason); err != nil {
+		return nil, err
+	}
+
+	return resolution, nil
+}
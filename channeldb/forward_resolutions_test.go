@@ -0,0 +1,87 @@
+package channeldb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestResolutionLog tests that settles and fails of forwarded HTLC's are
+// persisted, replaced, and removed from the resolution log.
+func TestResolutionLog(t *testing.T) {
+	t.Parallel()
+
+	cdb, cleanUp, err := makeTestDB()
+	if err != nil {
+		t.Fatalf("unable to make test database: %v", err)
+	}
+	defer cleanUp()
+
+	resLog := cdb.NewResolutionLog()
+
+	// Before any resolutions are added, none should be returned.
+	resolutions, err := resLog.FetchResolutions()
+	if err != nil {
+		t.Fatalf("unable to fetch resolutions: %v", err)
+	}
+	if len(resolutions) != 0 {
+		t.Fatalf("expected no resolutions, got %v", len(resolutions))
+	}
+
+	chanID := lnwire.NewShortChanIDFromInt(1)
+	preimage := [32]byte{1}
+	settle := &ForwardResolution{
+		IncomingChanID: chanID,
+		IncomingHTLCID: 0,
+		Amount:         1000,
+		Preimage:       &preimage,
+	}
+	fail := &ForwardResolution{
+		IncomingChanID: chanID,
+		IncomingHTLCID: 1,
+		Amount:         2000,
+		FailReason:     []byte("reason"),
+	}
+	for _, resolution := range []*ForwardResolution{settle, fail} {
+		if err := resLog.AddResolution(resolution); err != nil {
+			t.Fatalf("unable to add resolution: %v", err)
+		}
+	}
+
+	resolutions, err = resLog.FetchResolutions()
+	if err != nil {
+		t.Fatalf("unable to fetch resolutions: %v", err)
+	}
+	expected := []*ForwardResolution{settle, fail}
+	if !reflect.DeepEqual(resolutions, expected) {
+		t.Fatalf("expected resolutions %v, got %v", expected,
+			resolutions)
+	}
+
+	// Adding a resolution for the same HTLC should replace the prior one.
+	replacement := &ForwardResolution{
+		IncomingChanID: chanID,
+		IncomingHTLCID: 1,
+		Amount:         2000,
+		FailReason:     []byte("other reason"),
+	}
+	if err := resLog.AddResolution(replacement); err != nil {
+		t.Fatalf("unable to add resolution: %v", err)
+	}
+
+	// Once the settle is removed, only the replaced fail should remain.
+	if err := resLog.RemoveResolution(chanID, 0); err != nil {
+		t.Fatalf("unable to remove resolution: %v", err)
+	}
+
+	resolutions, err = resLog.FetchResolutions()
+	if err != nil {
+		t.Fatalf("unable to fetch resolutions: %v", err)
+	}
+	expected = []*ForwardResolution{replacement}
+	if !reflect.DeepEqual(resolutions, expected) {
+		t.Fatalf("expected resolutions %v, got %v", expected,
+			resolutions)
+	}
+}
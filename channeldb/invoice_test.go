@@ -66,6 +66,7 @@ func TestInvoiceWorkflow(t *testing.T) {
 	fakeInvoice.PaymentRequest = []byte("")
 	copy(fakeInvoice.Terms.PaymentPreimage[:], rev[:])
 	fakeInvoice.Terms.Value = lnwire.NewMSatFromSatoshis(10000)
+	fakeInvoice.ReviewRequired = true
 
 	// Add the invoice to the database, this should succeed as there aren't
 	// any existing invoices within the database with the same payment
@@ -155,3 +156,92 @@ func TestInvoiceWorkflow(t *testing.T) {
 		}
 	}
 }
+
+// TestBatchSettleInvoices tests that a batch of invoices can be settled within
+// a single call, with each invoice reporting its own outcome, and that
+// invoices which have already been settled are left untouched.
+func TestBatchSettleInvoices(t *testing.T) {
+	t.Parallel()
+
+	db, cleanUp, err := makeTestDB()
+	defer cleanUp()
+	if err != nil {
+		t.Fatalf("unable to make test db: %v", err)
+	}
+
+	const numInvoices = 5
+	amt := lnwire.NewMSatFromSatoshis(1000)
+	paymentHashes := make([][32]byte, 0, numInvoices+1)
+	for i := 0; i < numInvoices; i++ {
+		invoice, err := randInvoice(amt)
+		if err != nil {
+			t.Fatalf("unable to create invoice: %v", err)
+		}
+		if err := db.AddInvoice(invoice); err != nil {
+			t.Fatalf("unable to add invoice %v", err)
+		}
+
+		paymentHashes = append(paymentHashes,
+			sha256.Sum256(invoice.Terms.PaymentPreimage[:]))
+	}
+
+	// Settle the first invoice ahead of the batch, so we can ensure its
+	// settle date isn't overwritten.
+	if err := db.SettleInvoice(paymentHashes[0]); err != nil {
+		t.Fatalf("unable to settle invoice: %v", err)
+	}
+	settled, err := db.LookupInvoice(paymentHashes[0])
+	if err != nil {
+		t.Fatalf("unable to fetch invoice: %v", err)
+	}
+
+	// We'll also include a hash for which no invoice exists in the
+	// middle of the batch.
+	var unknownHash [32]byte
+	batch := append([][32]byte{}, paymentHashes[:2]...)
+	batch = append(batch, unknownHash)
+	batch = append(batch, paymentHashes[2:]...)
+
+	settleErrs, err := db.BatchSettleInvoices(batch)
+	if err != nil {
+		t.Fatalf("unable to settle batch: %v", err)
+	}
+	if len(settleErrs) != len(batch) {
+		t.Fatalf("expected %v errors, got %v", len(batch),
+			len(settleErrs))
+	}
+
+	for i, settleErr := range settleErrs {
+		if i == 2 {
+			if settleErr != ErrInvoiceNotFound {
+				t.Fatalf("expected ErrInvoiceNotFound, got %v",
+					settleErr)
+			}
+			continue
+		}
+		if settleErr != nil {
+			t.Fatalf("unable to settle invoice %v: %v", i,
+				settleErr)
+		}
+	}
+
+	for _, paymentHash := range paymentHashes {
+		invoice, err := db.LookupInvoice(paymentHash)
+		if err != nil {
+			t.Fatalf("unable to fetch invoice: %v", err)
+		}
+		if !invoice.Terms.Settled {
+			t.Fatalf("invoice %x should be settled", paymentHash[:])
+		}
+	}
+
+	resettled, err := db.LookupInvoice(paymentHashes[0])
+	if err != nil {
+		t.Fatalf("unable to fetch invoice: %v", err)
+	}
+	if !resettled.SettleDate.Equal(settled.SettleDate) {
+		t.Fatalf("settle date of previously settled invoice was "+
+			"overwritten: %v vs %v", settled.SettleDate,
+			resettled.SettleDate)
+	}
+}
@@ -110,6 +110,11 @@ type Invoice struct {
 	// TODO(roasbeef): later allow for multiple terms to fulfill the final
 	// invoice: payment fragmentation, etc.
 	Terms ContractTerm
+
+	// ReviewRequired indicates that HTLC's paying to this invoice must be
+	// approved by an operator before the invoice is settled. Until then,
+	// they're held by the exit hop.
+	ReviewRequired bool
 }
 
 func validateInvoice(i *Invoice) error {
@@ -284,6 +289,59 @@ func (d *DB) SettleInvoice(paymentHash [32]byte) error {
 	})
 }
 
+// BatchSettleInvoices attempts to mark each of the invoices corresponding to
+// the passed payment hashes as fully settled, within a single database
+// transaction. The returned slice holds the outcome for each payment hash, in
+// the order they were passed, such that the failure of a single invoice
+// doesn't prevent the remainder from being settled. Invoices which have
+// already been settled are left untouched. A non-nil error is only returned
+// if the transaction itself fails, in which case no invoices are settled.
+func (d *DB) BatchSettleInvoices(paymentHashes [][32]byte) ([]error, error) {
+	var settleErrs []error
+	err := d.Update(func(tx *bolt.Tx) error {
+		settleErrs = make([]error, len(paymentHashes))
+
+		invoices, err := tx.CreateBucketIfNotExists(invoiceBucket)
+		if err != nil {
+			return err
+		}
+		invoiceIndex, err := invoices.CreateBucketIfNotExists(invoiceIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		for i, paymentHash := range paymentHashes {
+			invoiceNum := invoiceIndex.Get(paymentHash[:])
+			if invoiceNum == nil {
+				settleErrs[i] = ErrInvoiceNotFound
+				continue
+			}
+
+			invoice, err := fetchInvoice(invoiceNum, invoices)
+			if err != nil {
+				settleErrs[i] = err
+				continue
+			}
+
+			// In order to remain idempotent, we won't overwrite
+			// the settle date of an invoice which has already
+			// been settled.
+			if invoice.Terms.Settled {
+				continue
+			}
+
+			settleErrs[i] = settleInvoice(invoices, invoiceNum)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return settleErrs, nil
+}
+
 func putInvoice(invoices *bolt.Bucket, invoiceIndex *bolt.Bucket,
 	i *Invoice, invoiceNum uint32) error {
 
@@ -361,7 +419,7 @@ func serializeInvoice(w io.Writer, i *Invoice) error {
 		return err
 	}
 
-	return nil
+	return binary.Write(w, byteOrder, i.ReviewRequired)
 }
 
 func fetchInvoice(invoiceNum []byte, invoices *bolt.Bucket) (*Invoice, error) {
@@ -423,6 +481,13 @@ func deserializeInvoice(r io.Reader) (*Invoice, error) {
 		return nil, err
 	}
 
+	// Invoices written before the review flag was introduced end here,
+	// in which case no review is required.
+	err = binary.Read(r, byteOrder, &invoice.ReviewRequired)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
 	return invoice, nil
 }
 
@@ -168,9 +168,57 @@ type config struct {
 
 	Profile string `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65535"`
 
-	DebugHTLC          bool `long:"debughtlc" description:"Activate the debug htlc mode. With the debug HTLC mode, all payments sent use a pre-determined R-Hash. Additionally, all HTLCs sent to a node with the debug HTLC R-Hash are immediately settled in the next available state transition."`
-	HodlHTLC           bool `long:"hodlhtlc" description:"Activate the hodl HTLC mode.  With hodl HTLC mode, all incoming HTLCs will be accepted by the receiving node, but no attempt will be made to settle the payment with the sender."`
-	MaxPendingChannels int  `long:"maxpendingchannels" description:"The maximum number of incoming pending channels permitted per peer."`
+	DebugHTLC          bool   `long:"debughtlc" description:"Activate the debug htlc mode. With the debug HTLC mode, all payments sent use a pre-determined R-Hash. Additionally, all HTLCs sent to a node with the debug HTLC R-Hash are immediately settled in the next available state transition."`
+	HodlHTLC           bool   `long:"hodlhtlc" description:"Activate the hodl HTLC mode.  With hodl HTLC mode, all incoming HTLCs will be accepted by the receiving node, but no attempt will be made to settle the payment with the sender."`
+	MaxPendingChannels int    `long:"maxpendingchannels" description:"The maximum number of incoming pending channels permitted per peer."`
+	MaxLinksPerPeer    uint32 `long:"maxlinksperpeer" description:"The maximum number of active channels permitted per peer within the htlc switch. A value of 0 disables the limit."`
+
+	RejectStressDust   bool  `long:"rejectstressdust" description:"Reject forwarded HTLCs which would become dust if the commitment fee rate were to rise by duststressheadroom."`
+	DustStressHeadroom int64 `long:"duststressheadroom" description:"The increase in the commitment fee rate, in sat/vbyte, under which forwarded HTLCs are checked when rejectstressdust is set. A value of 0 uses the default of 50 sat/vbyte."`
+
+	LinkWarmUp time.Duration `long:"linkwarmup" description:"The maximum time after reconnecting to a peer during which its channels won't be used to forward HTLCs, ending early once the channel state is confirmed to be in sync. A value of 0 disables the warm-up."`
+
+	ResolutionBatchDelay time.Duration `long:"resolutionbatchdelay" description:"The maximum time settles and fails of incoming HTLCs are aggregated for before signing a commitment covering them. Values above 1s are capped. A value of 0 disables aggregation."`
+	ResolutionBatchSize  uint32        `long:"resolutionbatchsize" description:"The number of aggregated settles and fails after which a commitment is signed without waiting for resolutionbatchdelay. A value of 0 uses the link's batch size."`
+
+	ForwardVolumeWindow time.Duration `long:"forwardvolumewindow" description:"The duration of the window over which the total value of settled forwards is capped by maxforwardvolume. A value of 0 disables the limit."`
+	MaxForwardVolume    int64         `long:"maxforwardvolume" description:"The maximum total value, in satoshis, of forwards settled within each forwardvolumewindow, after which forwards are failed until the window rolls over. Locally initiated payments are exempt. A value of 0 disables the limit."`
+
+	FailureDelayMin time.Duration `long:"failuredelaymin" description:"The minimum randomized delay applied before failing back payments to us with an unknown payment hash, or an incorrect amount or expiry, hiding which of them occurred from timing probes."`
+	FailureDelayMax time.Duration `long:"failuredelaymax" description:"The maximum randomized delay applied before failing back payments to us with an unknown payment hash, or an incorrect amount or expiry. Values above 2s are capped. A value of 0 disables the delay."`
+	ForwardJitter   time.Duration `long:"forwardjitter" description:"The maximum randomized delay applied before propagating the settle or fail of a forwarded payment back to its incoming channel, obscuring how far downstream it was resolved. Values above 2s are capped. A value of 0 disables the jitter."`
+
+	SoftReserve int64 `long:"softreserve" description:"The amount, in satoshis, of each channel's local balance above the channel reserve which is withheld from forwarded HTLCs, keeping it available for our own payments. A value of 0 disables the soft reserve."`
+
+	MinClaimCostMultiple float64 `long:"minclaimcostmultiple" description:"Reject payments to us whose amount is below this multiple of the estimated on-chain cost of claiming them at the current commitment fee rate, as they can't be economically enforced. A value of 0 disables the check."`
+
+	PolicyDriftThreshold float64       `long:"policydriftthreshold" description:"The change, in percent, of a channel's base fee or fee rate since its last channel update, past which a new channel update is gossiped automatically. A value of 0 disables automatic channel updates."`
+	PolicyDriftInterval  time.Duration `long:"policydriftinterval" description:"The minimum time between two automatic channel updates for a channel."`
+
+	MaxDownstreamFailLatency time.Duration `long:"maxdownstreamfaillatency" description:"The time a peer may take to settle or fail an HTLC forwarded to it before its channel stops forwarding new HTLCs until the overdue HTLCs are resolved. A value of 0 uses the default of 10m."`
+
+	MaxOverflowWait time.Duration `long:"maxoverflowwait" description:"The maximum time a forward to a channel without a free HTLC slot waits for one to become available before it's failed back. The wait is further limited to a fraction of the HTLC's remaining CLTV budget. A value of 0 lets forwards wait until a slot becomes available."`
+
+	MinChannelAge time.Duration `long:"minchannelage" description:"The minimum time since a channel's funding transaction confirmed before it's used to forward HTLCs, estimated from the number of blocks mined since. Locally initiated payments are exempt. A value of 0 disables the check."`
+
+	UnderpaymentGrace lnwire.MilliSatoshi `long:"underpaymentgrace" description:"The amount in millisatoshi by which an HTLC paying one of our invoices may fall short of the invoice amount while still settling it. A value of 0 requires the full invoice amount to be paid."`
+
+	MaintenanceWindows []string `long:"maintenancewindow" description:"A weekly recurring window during which all new forwards are declined, of the form \"<weekday> <HH:MM> <duration>\" in UTC, e.g. \"Sat 02:00 2h\". In-flight HTLCs are left to resolve, and locally initiated payments are exempt. May be specified multiple times."`
+
+	DecisionHistorySize int `long:"decisionhistorysize" description:"The number of the most recent forwarding decisions retained in memory for debugging. Values above 100000 are capped. A value of 0 uses the default of 1000."`
+
+	LinkWatchdogInterval   time.Duration `long:"linkwatchdoginterval" description:"The interval at which each channel link is checked to still be processing requests. A value of 0 disables the watchdog."`
+	LinkWatchdogTimeout    time.Duration `long:"linkwatchdogtimeout" description:"The time a channel link has to respond to the watchdog before it's considered wedged. A value of 0 uses the default of 1m."`
+	LinkWatchdogDisconnect bool          `long:"linkwatchdogdisconnect" description:"If true, the peer of a wedged channel link is disconnected, restarting the link once the peer reconnects. Otherwise, wedged links are only logged."`
+
+	ForwardBandwidthBuffer lnwire.MilliSatoshi `long:"forwardbandwidthbuffer" description:"The amount in millisatoshi by which the bandwidth of a channel should exceed a forward for the channel to be preferred over other channels to the same peer. Channels within the buffer are only used if no other channel has room. A value of 0 disables the preference."`
+
+	ChanUpdateInterval   time.Duration `long:"chanupdateinterval" description:"The minimum time between two channel updates broadcast for the same channel. Policy changes arriving faster are coalesced, and only the most recent one is broadcast once allowed. Policy changes made through updatechanpolicy bypass the limit. The limit is opt-in: with the default value of 0, updates aren't spaced out. A value of 1m is in line with common gossip norms."`
+	MaxChanUpdatesPerDay int           `long:"maxchanupdatesperday" description:"The maximum number of channel updates broadcast for the same channel within 24 hours. The cap is opt-in: with the default value of 0, the number of updates isn't capped. A value of 10 is in line with common gossip norms."`
+
+	MaxHTLCAmount lnwire.MilliSatoshi `long:"maxhtlcamount" description:"The sanity ceiling in millisatoshi on the amount of any HTLC offered over our channels, whether forwarded or locally initiated. HTLCs exceeding it are failed with a permanent channel failure and logged critically. A value of 0 uses the total supply of bitcoin."`
+
+	LocalPaymentFailovers int `long:"localpaymentfailovers" description:"The number of times a payment sent by us which fails at our own channel, before reaching the first hop, is resent over another channel to the same peer. A value of 0 disables the failover."`
 
 	Bitcoin      *chainConfig    `group:"Bitcoin" namespace:"bitcoin"`
 	BtcdMode     *btcdConfig     `group:"btcd" namespace:"btcd"`
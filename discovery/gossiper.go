@@ -51,6 +51,10 @@ type chanPolicyUpdateRequest struct {
 	targetChans []wire.OutPoint
 	newSchema   routing.ChannelPolicy
 
+	// bypassLimit indicates that the channel updates crafted for this
+	// request should be broadcast regardless of the UpdateRateLimit.
+	bypassLimit bool
+
 	errResp chan error
 }
 
@@ -121,6 +125,11 @@ type Config struct {
 	// TODO(roasbeef): extract ann crafting + sign from fundingMgr into
 	// here?
 	AnnSigner lnwallet.MessageSigner
+
+	// UpdateRateLimit limits the rate at which channel updates are
+	// emitted for each of our channels. If zero, then channel updates
+	// aren't rate limited.
+	UpdateRateLimit ChannelUpdateRateLimit
 }
 
 // AuthenticatedGossiper is a subsystem which is responsible for receiving
@@ -196,6 +205,10 @@ type AuthenticatedGossiper struct {
 	rejectMtx     sync.RWMutex
 	recentRejects map[uint64]struct{}
 
+	// updateLimiter enforces the UpdateRateLimit on the channel updates
+	// emitted for our channels.
+	updateLimiter *chanUpdateLimiter
+
 	sync.Mutex
 }
 
@@ -218,6 +231,7 @@ func New(cfg Config, selfKey *btcec.PublicKey) (*AuthenticatedGossiper, error) {
 		waitingProofs:           storage,
 		channelMtx:              multimutex.NewMutex(),
 		recentRejects:           make(map[uint64]struct{}),
+		updateLimiter:           newChanUpdateLimiter(cfg.UpdateRateLimit),
 	}, nil
 }
 
@@ -336,13 +350,37 @@ func (d *AuthenticatedGossiper) SynchronizeNode(pub *btcec.PublicKey) error {
 // source node. Policy updates are done in two stages: first, the
 // AuthenticatedGossiper ensures the update has been committed by dependent
 // sub-systems, then it signs and broadcasts new updates to the network.
+//
+// The broadcast of the new updates is subject to the UpdateRateLimit: the
+// update of a channel which would exceed it is deferred until it's allowed.
 func (d *AuthenticatedGossiper) PropagateChanPolicyUpdate(
 	newSchema routing.ChannelPolicy, chanPoints ...wire.OutPoint) error {
 
+	return d.propagateChanPolicyUpdate(newSchema, false, chanPoints...)
+}
+
+// PropagateManualChanPolicyUpdate is identical to PropagateChanPolicyUpdate,
+// but the new updates bypass the UpdateRateLimit. It's meant for policy
+// changes explicitly requested by the user, which should be reflected in the
+// network as soon as possible. The updates still count towards the limit of
+// subsequent ones.
+func (d *AuthenticatedGossiper) PropagateManualChanPolicyUpdate(
+	newSchema routing.ChannelPolicy, chanPoints ...wire.OutPoint) error {
+
+	return d.propagateChanPolicyUpdate(newSchema, true, chanPoints...)
+}
+
+// propagateChanPolicyUpdate hands a policy update request to the
+// networkHandler and waits for it to be processed.
+func (d *AuthenticatedGossiper) propagateChanPolicyUpdate(
+	newSchema routing.ChannelPolicy, bypassLimit bool,
+	chanPoints ...wire.OutPoint) error {
+
 	errChan := make(chan error, 1)
 	policyUpdate := &chanPolicyUpdateRequest{
 		targetChans: chanPoints,
 		newSchema:   newSchema,
+		bypassLimit: bypassLimit,
 		errResp:     errChan,
 	}
 
@@ -1078,8 +1116,15 @@ func (d *AuthenticatedGossiper) retransmitStaleChannels() error {
 
 		// If it's been a full day since we've re-broadcasted the
 		// channel, add the channel to the set of edges we need to
-		// update.
-		if timeElapsed >= broadcastInterval {
+		// update, unless the update rate limit doesn't allow it yet.
+		// In that case, it'll be retransmitted on a later attempt.
+		if timeElapsed < broadcastInterval {
+			return nil
+		}
+		allowed, _ := d.updateLimiter.allow(
+			info.ChannelPoint, time.Now(),
+		)
+		if allowed {
 			edgesToUpdate = append(edgesToUpdate, updateTuple{
 				info: info,
 				edge: edge,
@@ -1161,6 +1206,28 @@ func (d *AuthenticatedGossiper) processChanPolicyUpdate(
 			return nil
 		}
 
+		// Unless the request bypasses the rate limit, the update of a
+		// channel which would exceed it is deferred, in which case
+		// neither the graph nor the network learn about the new policy
+		// until it's allowed. A manual update supersedes any policy
+		// still pending for the channel.
+		now := time.Now()
+		if policyUpdate.bypassLimit {
+			d.updateLimiter.takePending(info.ChannelPoint)
+			d.updateLimiter.record(info.ChannelPoint, now)
+		} else {
+			allowed, next := d.updateLimiter.allow(
+				info.ChannelPoint, now,
+			)
+			if !allowed {
+				d.deferPolicyUpdate(
+					info.ChannelPoint,
+					policyUpdate.newSchema, next,
+				)
+				return nil
+			}
+		}
+
 		// Apply the new fee schema to the edge.
 		edge.FeeBaseMSat = policyUpdate.newSchema.BaseFee
 		edge.FeeProportionalMillionths = lnwire.MilliSatoshi(
@@ -0,0 +1,189 @@
+package discovery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/routing"
+	"github.com/roasbeef/btcd/wire"
+)
+
+// updateLimitWindow is the window over which the MaxPerDay limit of a
+// ChannelUpdateRateLimit applies.
+const updateLimitWindow = 24 * time.Hour
+
+// ChannelUpdateRateLimit limits the rate at which the gossiper emits channel
+// updates for each of our channels. Policy updates which would exceed it are
+// deferred, and only the most recent deferred policy of a channel is
+// broadcast once the limit allows. Channel updates emitted on behalf of a
+// manual policy change bypass the limit.
+type ChannelUpdateRateLimit struct {
+	// MinInterval is the minimum time between two channel updates for
+	// the same channel. If zero, then updates aren't spaced out.
+	MinInterval time.Duration
+
+	// MaxPerDay is the maximum number of channel updates emitted for the
+	// same channel within any 24 hour window. If zero, then the number of
+	// updates isn't capped.
+	MaxPerDay int
+}
+
+// chanUpdateLimiter enforces a ChannelUpdateRateLimit, and tracks the policy
+// updates deferred due to it.
+type chanUpdateLimiter struct {
+	sync.Mutex
+
+	limit ChannelUpdateRateLimit
+
+	// sent holds the times at which updates were emitted for each
+	// channel within the last 24 hours, oldest first.
+	sent map[wire.OutPoint][]time.Time
+
+	// pending holds the most recent deferred policy of each channel. A
+	// flush of the policy is scheduled once it's first deferred.
+	pending map[wire.OutPoint]routing.ChannelPolicy
+
+	// suppressed is the number of channel updates which were deferred or
+	// skipped due to the limit.
+	suppressed uint64
+}
+
+// newChanUpdateLimiter creates a new limiter enforcing the passed limit.
+func newChanUpdateLimiter(limit ChannelUpdateRateLimit) *chanUpdateLimiter {
+	return &chanUpdateLimiter{
+		limit:   limit,
+		sent:    make(map[wire.OutPoint][]time.Time),
+		pending: make(map[wire.OutPoint]routing.ChannelPolicy),
+	}
+}
+
+// enabled returns true if the limiter restricts updates at all.
+func (c *chanUpdateLimiter) enabled() bool {
+	return c.limit.MinInterval > 0 || c.limit.MaxPerDay > 0
+}
+
+// allow returns true if an update for the passed channel may be emitted at
+// the passed time, recording the update if so. Otherwise, the update is
+// counted as suppressed, and the time at which the next update will be
+// allowed is returned.
+func (c *chanUpdateLimiter) allow(chanPoint wire.OutPoint,
+	now time.Time) (bool, time.Time) {
+
+	if !c.enabled() {
+		return true, now
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Discard the updates which have fallen out of the window.
+	sent := c.sent[chanPoint]
+	for len(sent) > 0 && now.Sub(sent[0]) >= updateLimitWindow {
+		sent = sent[1:]
+	}
+
+	next := now
+	if len(sent) > 0 {
+		if t := sent[len(sent)-1].Add(c.limit.MinInterval); t.After(next) {
+			next = t
+		}
+	}
+	if c.limit.MaxPerDay > 0 && len(sent) >= c.limit.MaxPerDay {
+		idx := len(sent) - c.limit.MaxPerDay
+		if t := sent[idx].Add(updateLimitWindow); t.After(next) {
+			next = t
+		}
+	}
+
+	if next.After(now) {
+		c.sent[chanPoint] = sent
+		c.suppressed++
+		return false, next
+	}
+
+	c.sent[chanPoint] = append(sent, now)
+	return true, now
+}
+
+// record records an update for the passed channel emitted at the passed time
+// without checking the limit, such that it counts towards the limit of
+// future updates.
+func (c *chanUpdateLimiter) record(chanPoint wire.OutPoint, now time.Time) {
+	if !c.enabled() {
+		return
+	}
+
+	c.Lock()
+	c.sent[chanPoint] = append(c.sent[chanPoint], now)
+	c.Unlock()
+}
+
+// deferPolicy stores the passed policy as the pending policy of the channel,
+// replacing any previously deferred one. True is returned if no policy was
+// pending yet, in which case the caller should schedule a flush.
+func (c *chanUpdateLimiter) deferPolicy(chanPoint wire.OutPoint,
+	policy routing.ChannelPolicy) bool {
+
+	c.Lock()
+	defer c.Unlock()
+
+	_, scheduled := c.pending[chanPoint]
+	c.pending[chanPoint] = policy
+
+	return !scheduled
+}
+
+// takePending removes and returns the pending policy of the channel, if any.
+func (c *chanUpdateLimiter) takePending(
+	chanPoint wire.OutPoint) (routing.ChannelPolicy, bool) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	policy, ok := c.pending[chanPoint]
+	delete(c.pending, chanPoint)
+
+	return policy, ok
+}
+
+// numSuppressed returns the number of channel updates suppressed so far.
+func (c *chanUpdateLimiter) numSuppressed() uint64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.suppressed
+}
+
+// SuppressedChannelUpdates returns the number of channel updates for our
+// channels which were deferred or skipped due to the UpdateRateLimit.
+func (d *AuthenticatedGossiper) SuppressedChannelUpdates() uint64 {
+	return d.updateLimiter.numSuppressed()
+}
+
+// deferPolicyUpdate defers the passed policy of a channel until the update
+// rate limit allows a new channel update for it at the passed time. Only the
+// most recent policy deferred for the channel is broadcast.
+func (d *AuthenticatedGossiper) deferPolicyUpdate(chanPoint wire.OutPoint,
+	policy routing.ChannelPolicy, next time.Time) {
+
+	log.Debugf("Deferring channel update for ChannelPoint(%v) until %v "+
+		"due to rate limit", chanPoint, next)
+
+	if !d.updateLimiter.deferPolicy(chanPoint, policy) {
+		return
+	}
+
+	time.AfterFunc(time.Until(next), func() {
+		policy, ok := d.updateLimiter.takePending(chanPoint)
+		if !ok {
+			return
+		}
+
+		err := d.PropagateChanPolicyUpdate(policy, chanPoint)
+		if err != nil {
+			log.Errorf("Unable to propagate deferred policy "+
+				"update for ChannelPoint(%v): %v", chanPoint,
+				err)
+		}
+	})
+}
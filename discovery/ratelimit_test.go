@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/routing"
+	"github.com/roasbeef/btcd/wire"
+)
+
+// TestChanUpdateLimiter tests that the channel update limiter enforces both
+// the minimum interval and the daily cap per channel, coalesces deferred
+// policies, and counts the suppressed updates.
+func TestChanUpdateLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := newChanUpdateLimiter(ChannelUpdateRateLimit{
+		MinInterval: time.Minute,
+		MaxPerDay:   3,
+	})
+
+	chanA := wire.OutPoint{Index: 0}
+	chanB := wire.OutPoint{Index: 1}
+	start := time.Unix(1500000000, 0)
+
+	// The first update of a channel is always allowed.
+	if ok, _ := limiter.allow(chanA, start); !ok {
+		t.Fatalf("first update should be allowed")
+	}
+
+	// A second update within the minimum interval should be suppressed
+	// until the interval has passed, while other channels are unaffected.
+	ok, next := limiter.allow(chanA, start.Add(time.Second))
+	if ok {
+		t.Fatalf("update within min interval should be suppressed")
+	}
+	if !next.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected next update at %v, got %v",
+			start.Add(time.Minute), next)
+	}
+	if ok, _ := limiter.allow(chanB, start.Add(time.Second)); !ok {
+		t.Fatalf("update of other channel should be allowed")
+	}
+
+	// Once the daily cap has been reached, the next update should only be
+	// allowed once the oldest update falls out of the window.
+	if ok, _ := limiter.allow(chanA, start.Add(time.Minute)); !ok {
+		t.Fatalf("update after min interval should be allowed")
+	}
+	limiter.record(chanA, start.Add(2*time.Minute))
+
+	ok, next = limiter.allow(chanA, start.Add(time.Hour))
+	if ok {
+		t.Fatalf("update beyond daily cap should be suppressed")
+	}
+	if !next.Equal(start.Add(updateLimitWindow)) {
+		t.Fatalf("expected next update at %v, got %v",
+			start.Add(updateLimitWindow), next)
+	}
+	if ok, _ := limiter.allow(chanA, start.Add(updateLimitWindow)); !ok {
+		t.Fatalf("update after window should be allowed")
+	}
+
+	if limiter.numSuppressed() != 2 {
+		t.Fatalf("expected 2 suppressed updates, got %v",
+			limiter.numSuppressed())
+	}
+
+	// Only the first deferred policy of a channel should schedule a
+	// flush, and only the most recent one should be returned.
+	if !limiter.deferPolicy(chanA, routing.ChannelPolicy{TimeLockDelta: 1}) {
+		t.Fatalf("first deferred policy should schedule a flush")
+	}
+	if limiter.deferPolicy(chanA, routing.ChannelPolicy{TimeLockDelta: 2}) {
+		t.Fatalf("second deferred policy shouldn't schedule a flush")
+	}
+	policy, ok := limiter.takePending(chanA)
+	if !ok || policy.TimeLockDelta != 2 {
+		t.Fatalf("expected most recent pending policy, got %v", policy)
+	}
+	if _, ok := limiter.takePending(chanA); ok {
+		t.Fatalf("pending policy should have been removed")
+	}
+
+	// A limiter without any limit should allow every update.
+	unlimited := newChanUpdateLimiter(ChannelUpdateRateLimit{})
+	for i := 0; i < 10; i++ {
+		if ok, _ := unlimited.allow(chanA, start); !ok {
+			t.Fatalf("unlimited limiter should allow all updates")
+		}
+	}
+}
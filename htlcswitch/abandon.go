@@ -0,0 +1,132 @@
+package htlcswitch
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// abandonChannelCmd is a message sent to the switch in order to abandon a
+// channel along with its in-flight HTLC's.
+type abandonChannelCmd struct {
+	chanID lnwire.ChannelID
+
+	err chan error
+}
+
+// AbandonChannel gives up on all HTLC's in flight over the target channel,
+// for use when recovering a channel for which we no longer hold the
+// commitment state, e.g. after restoring it from a static channel backup. All
+// HTLC's we've forwarded over the channel are failed back upstream with a
+// permanent channel failure, and circuits of HTLC's received over it are
+// dropped, as they can no longer be settled or failed. The channel's link is
+// then removed from the switch and stopped.
+//
+// NOTE: This is a recovery tool, which gives up on the channel's HTLC's
+// regardless of whether they may still be claimed on-chain.
+func (s *Switch) AbandonChannel(chanID lnwire.ChannelID) error {
+	cmd := &abandonChannelCmd{
+		chanID: chanID,
+		err:    make(chan error, 1),
+	}
+
+	select {
+	case s.linkControl <- cmd:
+	case <-s.quit:
+		return errors.New("htlc switch was stopped")
+	}
+
+	select {
+	case err := <-cmd.err:
+		return err
+	case <-s.quit:
+		return errors.New("htlc switch was stopped")
+	}
+}
+
+// abandonChannel fails back the circuits of the HTLC's forwarded over the
+// target channel, drops those of the HTLC's received over it, then removes
+// and stops its link.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) abandonChannel(chanID lnwire.ChannelID) error {
+	link, ok := s.linkIndex[chanID]
+	if !ok {
+		return ErrChannelLinkNotFound
+	}
+	shortChanID := link.ShortChanID()
+
+	log.Criticalf("Abandoning channel chan_id=%v (short_chan_id=%v), "+
+		"giving up on all of its in-flight HTLCs", chanID, shortChanID)
+
+	// We'll first remove the link from all indexes, such that no further
+	// HTLC's are forwarded over the channel.
+	delete(s.linkIndex, chanID)
+	delete(s.forwardingIndex, shortChanID)
+	for alias, target := range s.chanIDAliases {
+		if target == shortChanID {
+			delete(s.chanIDAliases, alias)
+		}
+	}
+
+	peerPub := link.Peer().PubKey()
+	delete(s.interfaceIndex[peerPub], link)
+	if len(s.interfaceIndex[peerPub]) == 0 {
+		delete(s.interfaceIndex, peerPub)
+	}
+
+	var numFailed, numDropped int
+	for _, circuit := range s.circuits.circuitList() {
+		switch {
+		// We forwarded this HTLC over the abandoned channel, so we'll
+		// fail it back as if it had been resolved on-chain, which
+		// fails it with a permanent channel failure.
+		case circuit.OutgoingChanID == shortChanID:
+			log.Warnf("Failing back abandoned HTLC(%x) forwarded "+
+				"over %v: (%s, %d) <-> (%s, %d)",
+				circuit.PaymentHash, shortChanID,
+				circuit.IncomingChanID, circuit.IncomingHTLCID,
+				circuit.OutgoingChanID, circuit.OutgoingHTLCID)
+
+			err := s.handlePacketForward(&htlcPacket{
+				outgoingChanID: circuit.OutgoingChanID,
+				outgoingHTLCID: circuit.OutgoingHTLCID,
+				isResolution:   true,
+				htlc:           &lnwire.UpdateFailHTLC{},
+			})
+			if err != nil {
+				log.Errorf("Unable to fail back abandoned "+
+					"HTLC(%x): %v", circuit.PaymentHash, err)
+				continue
+			}
+			numFailed++
+
+		// We received this HTLC over the abandoned channel, so it can
+		// no longer be resolved, and we'll drop its circuit.
+		case circuit.IncomingChanID == shortChanID:
+			log.Warnf("Dropping circuit of abandoned HTLC(%x) "+
+				"received over %v: (%s, %d) <-> (%s, %d)",
+				circuit.PaymentHash, shortChanID,
+				circuit.IncomingChanID, circuit.IncomingHTLCID,
+				circuit.OutgoingChanID, circuit.OutgoingHTLCID)
+
+			err := s.circuits.Remove(
+				circuit.OutgoingChanID, circuit.OutgoingHTLCID,
+			)
+			if err != nil {
+				log.Errorf("Unable to remove circuit of "+
+					"abandoned HTLC(%x): %v",
+					circuit.PaymentHash, err)
+				continue
+			}
+			numDropped++
+		}
+	}
+
+	link.Stop()
+
+	log.Criticalf("Abandoned channel chan_id=%v: failed back %v "+
+		"forwarded HTLCs, dropped %v received HTLCs", chanID,
+		numFailed, numDropped)
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestSwitchAbandonChannel tests that abandoning a channel fails the HTLC's
+// forwarded over it back upstream with a permanent channel failure, drops the
+// circuits of HTLC's received over it, and removes its link from the switch.
+func TestSwitchAbandonChannel(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	// We'll forward one HTLC over Bob's channel, and receive another over
+	// it, such that a circuit references the channel in each direction.
+	forward := func(from, to *mockChannelLink) {
+		err := s.forward(&htlcPacket{
+			incomingChanID: from.ShortChanID(),
+			incomingHTLCID: 0,
+			outgoingChanID: to.ShortChanID(),
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1,
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to forward htlc: %v", err)
+		}
+
+		select {
+		case <-to.packets:
+		case <-time.After(time.Second):
+			t.Fatalf("htlc was not forwarded")
+		}
+	}
+	forward(aliceChannelLink, bobChannelLink)
+	forward(bobChannelLink, aliceChannelLink)
+
+	if s.circuits.pending() != 2 {
+		t.Fatalf("expected 2 circuits, got %v", s.circuits.pending())
+	}
+
+	if err := s.AbandonChannel(chanID2); err != nil {
+		t.Fatalf("unable to abandon channel: %v", err)
+	}
+
+	// The HTLC forwarded over Bob's channel should be failed back to
+	// Alice with a permanent channel failure.
+	select {
+	case pkt := <-aliceChannelLink.packets:
+		fail, ok := pkt.htlc.(*lnwire.UpdateFailHTLC)
+		if !ok {
+			t.Fatalf("expected fail, got %T", pkt.htlc)
+		}
+		failure, err := lnwire.DecodeFailure(
+			bytes.NewReader(fail.Reason), 0,
+		)
+		if err != nil {
+			t.Fatalf("unable to decode failure: %v", err)
+		}
+		if failure.Code() != lnwire.CodePermanentChannelFailure {
+			t.Fatalf("expected permanent channel failure, got %v",
+				failure.Code())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("abandoned htlc was not failed back")
+	}
+
+	if s.circuits.pending() != 0 {
+		t.Fatalf("expected no circuits, got %v", s.circuits.pending())
+	}
+
+	// Bob's link should no longer be known to the switch.
+	if _, err := s.GetLink(chanID2); err != ErrChannelLinkNotFound {
+		t.Fatalf("expected link to be removed, got %v", err)
+	}
+	if _, err := s.GetLinksByInterface(bobPeer.PubKey()); err == nil {
+		t.Fatalf("expected no links for bob")
+	}
+	if err := s.AbandonChannel(chanID2); err != ErrChannelLinkNotFound {
+		t.Fatalf("expected ErrChannelLinkNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package htlcswitch
+
+import "sync"
+
+// forwardingACL restricts the set of peers that HTLC's may be forwarded
+// to/from. A peer is permitted to forward if it isn't on the deny-list, and,
+// if an allow-list has been set, it's on the allow-list.
+type forwardingACL struct {
+	sync.RWMutex
+
+	// allow is the set of peers permitted to forward. If nil, then all
+	// peers which aren't denied are permitted.
+	allow map[[33]byte]struct{}
+
+	// deny is the set of peers which aren't permitted to forward.
+	deny map[[33]byte]struct{}
+}
+
+// newForwardingACL creates a new forwardingACL which permits all peers.
+func newForwardingACL() *forwardingACL {
+	return &forwardingACL{
+		deny: make(map[[33]byte]struct{}),
+	}
+}
+
+// set atomically replaces the allow and deny lists of the ACL. A nil
+// allow-list removes any restriction imposed by a prior allow-list.
+func (a *forwardingACL) set(allow, deny [][33]byte) {
+	var allowSet map[[33]byte]struct{}
+	if allow != nil {
+		allowSet = make(map[[33]byte]struct{}, len(allow))
+		for _, peer := range allow {
+			allowSet[peer] = struct{}{}
+		}
+	}
+
+	denySet := make(map[[33]byte]struct{}, len(deny))
+	for _, peer := range deny {
+		denySet[peer] = struct{}{}
+	}
+
+	a.Lock()
+	a.allow = allowSet
+	a.deny = denySet
+	a.Unlock()
+}
+
+// permitted returns true if the target peer is allowed to forward HTLC's.
+func (a *forwardingACL) permitted(peer [33]byte) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	if _, ok := a.deny[peer]; ok {
+		return false
+	}
+
+	if a.allow == nil {
+		return true
+	}
+
+	_, ok := a.allow[peer]
+	return ok
+}
+
+// SetForwardingACL replaces the lists of peers, identified by the serialized
+// compressed form of their public keys, which HTLC's may be forwarded to/from.
+// Forwards whose incoming or outgoing peer is on the deny-list are failed
+// back. If the allow-list is non-nil, then forwards are only permitted if both
+// the incoming and outgoing peer are on it, with the deny-list taking
+// precedence. Passing a nil allow-list and an empty deny-list permits all
+// forwards. Locally initiated payments, and HTLC's for which we're the exit
+// hop, aren't subject to the lists.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) SetForwardingACL(allow, deny [][33]byte) {
+	log.Infof("Updating forwarding ACL: allowed=%v (restricted=%v), "+
+		"denied=%v", len(allow), allow != nil, len(deny))
+
+	s.forwardingACL.set(allow, deny)
+}
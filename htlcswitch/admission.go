@@ -0,0 +1,61 @@
+package htlcswitch
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+var (
+	// ErrLinkNotAccepting is returned by CanAddHTLC if the link isn't
+	// accepting new HTLC's, as it's being cleared for a cooperative close,
+	// quiesced, or is pending force close.
+	ErrLinkNotAccepting = errors.New("link isn't accepting new htlcs")
+
+	// ErrInsufficientBandwidth is returned by CanAddHTLC if the HTLC
+	// exceeds the link's bandwidth available to local HTLC's.
+	ErrInsufficientBandwidth = errors.New("insufficient link bandwidth")
+
+	// ErrNoFreeSlot is returned by CanAddHTLC if our outgoing HTLC's
+	// already fill all of the slots the remote party allows within the
+	// commitment transaction.
+	ErrNoFreeSlot = errors.New("no free htlc slot in commitment")
+)
+
+// CanAddHTLC returns true if a locally initiated HTLC of the passed amount
+// could currently be added to the link. Otherwise, an error describing the
+// first constraint the HTLC would violate is returned. The link's own
+// admission checks are carried out first: whether it's accepting new HTLC's,
+// its bandwidth, and whether it has a free slot. A commitment already holding
+// the maximum number of our HTLC's is therefore reported as ErrNoFreeSlot.
+// Afterwards, the channel state machine validates the HTLC against the
+// commitment, in the same way as if it were added, returning
+// lnwallet.ErrMaxPendingAmount if the maximum value in flight would be
+// exceeded, lnwallet.ErrBelowChanReserve if the commitment fee of the
+// resulting commitment would dip our balance below the channel reserve, and
+// lnwallet.ErrBelowMinHTLC if the amount is below the minimum HTLC value. The
+// link doesn't limit its dust exposure, so dust HTLC's are admitted like any
+// other.
+//
+// NOTE: The result is only a snapshot of the link's state, which may change
+// by the time the HTLC is sent.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) CanAddHTLC(amt lnwire.MilliSatoshi) (bool, error) {
+	if l.isClearingForClose() || l.isQuiescing() || l.isPendingClose() {
+		return false, ErrLinkNotAccepting
+	}
+
+	if amt > l.LocalBandwidth() {
+		return false, ErrInsufficientBandwidth
+	}
+
+	if !l.HasFreeSlot() {
+		return false, ErrNoFreeSlot
+	}
+
+	if err := l.channel.ValidateAddHTLC(amt); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
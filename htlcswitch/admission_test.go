@@ -0,0 +1,75 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkCanAddHTLC tests that CanAddHTLC reports the first
+// constraint an HTLC would violate for each of the link's admission checks.
+func TestChannelLinkCanAddHTLC(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	const chanReserve = btcutil.SatoshiPerBitcoin * 1
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(
+		chanAmt, chanReserve,
+	)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	localCfg := &aliceLink.channel.State().LocalChanCfg
+	amt := lnwire.NewMSatFromSatoshis(10000)
+
+	assertCanAdd := func(amt lnwire.MilliSatoshi, expectedErr error) {
+		ok, err := aliceLink.CanAddHTLC(amt)
+		if err != expectedErr {
+			t.Fatalf("expected error %v for htlc of %v, got %v",
+				expectedErr, amt, err)
+		}
+		if ok != (expectedErr == nil) {
+			t.Fatalf("expected CanAddHTLC to return %v for htlc "+
+				"of %v", expectedErr == nil, amt)
+		}
+	}
+
+	assertCanAdd(amt, nil)
+
+	// An HTLC exceeding the bandwidth available to local HTLC's can't be
+	// added.
+	assertCanAdd(aliceLink.LocalBandwidth()+1, ErrInsufficientBandwidth)
+
+	// An HTLC using up all of our bandwidth would increase the commitment
+	// fee, leaving no headroom to pay for it without dipping below the
+	// channel reserve.
+	assertCanAdd(aliceLink.LocalBandwidth(), lnwallet.ErrBelowChanReserve)
+
+	// The maximum value in flight and the minimum HTLC value are enforced
+	// as when adding the HTLC.
+	maxPending := localCfg.MaxPendingAmount
+	localCfg.MaxPendingAmount = amt - 1
+	assertCanAdd(amt, lnwallet.ErrMaxPendingAmount)
+	localCfg.MaxPendingAmount = maxPending
+
+	minHtlc := localCfg.MinHTLC
+	localCfg.MinHTLC = amt + 1
+	assertCanAdd(amt, lnwallet.ErrBelowMinHTLC)
+	localCfg.MinHTLC = minHtlc
+
+	// Once our outgoing HTLC's fill all of the slots the remote party
+	// allows, there's no free slot for another one.
+	maxHtlcs := localCfg.MaxAcceptedHtlcs
+	localCfg.MaxAcceptedHtlcs = 0
+	assertCanAdd(amt, ErrNoFreeSlot)
+	localCfg.MaxAcceptedHtlcs = maxHtlcs
+
+	// Finally, a link pending close doesn't accept any HTLC's.
+	aliceLink.MarkPendingClose()
+	assertCanAdd(amt, ErrLinkNotAccepting)
+}
@@ -0,0 +1,51 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// AtRiskExposure returns the total value of the non-dust HTLC's on our
+// current commitment transaction, in either direction. Each of them would
+// need to be resolved on-chain were the channel force closed now. Dust HTLC's
+// aren't included, as they have no output of their own.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) AtRiskExposure() lnwire.MilliSatoshi {
+	var exposure lnwire.MilliSatoshi
+	for _, htlc := range l.channel.StateSnapshot().Htlcs {
+		// Dust HTLC's are trimmed from the commitment, and so don't
+		// have an output index.
+		if htlc.OutputIndex < 0 {
+			continue
+		}
+
+		exposure += htlc.Amt
+	}
+
+	return exposure
+}
+
+// exceedsMaxAtRiskExposure returns true if offering an HTLC of the passed
+// amount would push our at-risk exposure past the MaxAtRiskExposure of the
+// link's forwarding policy. As whether the HTLC is trimmed as dust depends on
+// the fee rate of the commitment that will eventually include it, its amount
+// is counted in full.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) exceedsMaxAtRiskExposure(amt lnwire.MilliSatoshi) bool {
+	maxExposure := l.cfg.FwrdingPolicy.MaxAtRiskExposure
+	if maxExposure == 0 {
+		return false
+	}
+
+	exposure := l.AtRiskExposure()
+	if exposure+amt <= maxExposure {
+		return false
+	}
+
+	log.Infof("ChannelLink(%v) rejecting downstream htlc of %v, at-risk "+
+		"exposure of %v would exceed cap of %v", l, amt, exposure,
+		maxExposure)
+
+	return true
+}
@@ -0,0 +1,90 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkMaxAtRiskExposure tests that a link reports the value of the
+// non-dust HTLC's on its commitment as its at-risk exposure, and that it
+// doesn't offer HTLC's which would push the exposure past the cap of its
+// forwarding policy.
+func TestChannelLinkMaxAtRiskExposure(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	if exposure := aliceLink.AtRiskExposure(); exposure != 0 {
+		t.Fatalf("expected no exposure, got %v", exposure)
+	}
+
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: ForwardingPolicy{MaxAtRiskExposure: htlcAmt * 3 / 2},
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+
+	var mockBlob [lnwire.OnionPacketSize]byte
+	sendHtlc := func() {
+		_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+	}
+
+	// The first HTLC fits within the cap, and should be offered to Bob.
+	// Once it's locked in, it counts towards the exposure.
+	sendHtlc()
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("htlc was not offered")
+	}
+	addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	if _, err := bobChannel.ReceiveHTLC(addHtlc); err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+	if err := updateState(batchTick, aliceLink, bobChannel, true); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	if exposure := aliceLink.AtRiskExposure(); exposure != htlcAmt {
+		t.Fatalf("expected exposure of %v, got %v", htlcAmt, exposure)
+	}
+	state := aliceLink.CommitmentState()
+	if state.AtRiskExposure != htlcAmt {
+		t.Fatalf("expected exposure of %v within commitment state, "+
+			"got %v", htlcAmt, state.AtRiskExposure)
+	}
+
+	// The second would exceed the cap, so it shouldn't be offered.
+	sendHtlc()
+	select {
+	case msg := <-aliceMsgs:
+		t.Fatalf("expected no message, got %T", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if exposure := aliceLink.AtRiskExposure(); exposure != htlcAmt {
+		t.Fatalf("expected exposure of %v, got %v", htlcAmt, exposure)
+	}
+}
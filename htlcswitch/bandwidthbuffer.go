@@ -0,0 +1,46 @@
+package htlcswitch
+
+import "github.com/lightningnetwork/lnd/lnwire"
+
+// selectDestination picks the link from the given links to the next peer
+// over which a forward of the passed amount should be sent. Links whose
+// bandwidth exceeds the amount by at least the configured BandwidthBuffer are
+// preferred, as a nearly exhausted link is likely to fail the forward once
+// fees and the in-flight HTLCs of other forwards are accounted for. A
+// marginal link is only used if none of the links to the peer has a
+// comfortable margin. If no link can carry the amount, then nil is returned.
+func (s *Switch) selectDestination(links []ChannelLink,
+	amt lnwire.MilliSatoshi) ChannelLink {
+
+	var marginal ChannelLink
+	for _, link := range links {
+		// We'll skip any links that aren't yet eligible for
+		// forwarding, or are reserved for receiving.
+		if !link.EligibleToForward() ||
+			!link.ChannelRole().canCarry() {
+
+			continue
+		}
+
+		bandwidth := link.Bandwidth()
+		if bandwidth < amt {
+			continue
+		}
+
+		if bandwidth-amt >= s.cfg.BandwidthBuffer {
+			return link
+		}
+
+		if marginal == nil {
+			marginal = link
+		}
+	}
+
+	if marginal != nil {
+		log.Debugf("Forwarding %v over ChannelLink(%v) within the "+
+			"bandwidth buffer of %v", amt, marginal.ShortChanID(),
+			s.cfg.BandwidthBuffer)
+	}
+
+	return marginal
+}
@@ -0,0 +1,103 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/wire"
+)
+
+// TestSwitchBandwidthBuffer tests that a link to the next peer whose bandwidth
+// only marginally exceeds a forward is passed over in favor of a healthier
+// link to the same peer, and that it's still used once it's the only link
+// able to carry the forward.
+func TestSwitchBandwidthBuffer(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{
+		BandwidthBuffer: 500,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	marginalLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	healthyLink := newMockChannelLink(
+		s, lnwire.NewChanIDFromOutPoint(wire.NewOutPoint(hash1, 3)),
+		lnwire.NewShortChanIDFromInt(3), bobPeer, true,
+	)
+	marginalLink.bandwidth = 1100
+	healthyLink.bandwidth = 5000
+	for _, link := range []*mockChannelLink{
+		aliceLink, marginalLink, healthyLink,
+	} {
+		if err := s.AddLink(link); err != nil {
+			t.Fatalf("unable to add link: %v", err)
+		}
+	}
+
+	var htlcID uint64
+	assertForwardedOver := func(amt lnwire.MilliSatoshi,
+		expected *mockChannelLink) {
+
+		preimage := [sha256.Size]byte{byte(htlcID)}
+		rhash := fastsha256.Sum256(preimage[:])
+		packet := &htlcPacket{
+			incomingChanID: aliceLink.ShortChanID(),
+			incomingHTLCID: htlcID,
+			outgoingChanID: marginalLink.ShortChanID(),
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      amt,
+			},
+		}
+		htlcID++
+
+		if err := s.forward(packet); err != nil {
+			t.Fatalf("unable to forward htlc: %v", err)
+		}
+
+		// The packet's outgoing channel is the one it was addressed
+		// to, so we'll tell which link carried it by the link it was
+		// delivered to.
+		var sentOver *mockChannelLink
+		select {
+		case <-marginalLink.packets:
+			sentOver = marginalLink
+		case <-healthyLink.packets:
+			sentOver = healthyLink
+		case <-time.After(time.Second):
+			t.Fatalf("forward of %v wasn't sent over %v", amt,
+				expected.ShortChanID())
+		}
+		if sentOver != expected {
+			t.Fatalf("forward sent over %v, expected %v",
+				sentOver.ShortChanID(), expected.ShortChanID())
+		}
+	}
+
+	// Both links can carry the forward, but only the healthy one has
+	// room to spare beyond the buffer, so it should be chosen each time,
+	// regardless of the order in which the links are considered.
+	for i := 0; i < 5; i++ {
+		assertForwardedOver(1000, healthyLink)
+	}
+
+	// Once the healthy link can no longer carry the forward, the marginal
+	// link is the only option left and should be used despite the buffer.
+	healthyLink.bandwidth = 0
+	assertForwardedOver(1000, marginalLink)
+}
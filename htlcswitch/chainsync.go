@@ -0,0 +1,61 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// chainSyncPollInterval is the interval at which a local payment waiting for
+// the node to sync to the chain re-checks its sync status.
+const chainSyncPollInterval = time.Second
+
+// ErrChainNotSynced is returned when sending a payment while the node isn't
+// synced to the chain, if the switch is configured to fail such payments
+// rather than waiting for the node to sync.
+var ErrChainNotSynced = errors.New("node not synced to chain")
+
+// ChainSyncSource reports whether the node is synced to the tip of the chain.
+// While it isn't, our notion of the current block height is stale, so the
+// CLTV safety margins of new forwards can't be enforced reliably.
+type ChainSyncSource interface {
+	// IsSynced returns true if the node is synced to the tip of the
+	// chain. As it's consulted for every new forward, it MUST be cheap.
+	IsSynced() bool
+}
+
+// chainSynced returns true if the node is synced to the chain, or if the
+// switch hasn't been given a ChainSync source.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) chainSynced() bool {
+	return s.cfg.ChainSync == nil || s.cfg.ChainSync.IsSynced()
+}
+
+// waitForChainSync blocks until the node is synced to the chain, or returns
+// ErrChainNotSynced right away if the switch is configured to fail local
+// payments while it isn't.
+func (s *Switch) waitForChainSync() error {
+	if s.chainSynced() {
+		return nil
+	}
+	if s.cfg.FailLocalPaymentsUnsynced {
+		return ErrChainNotSynced
+	}
+
+	log.Infof("Waiting for node to sync to chain before sending payment")
+
+	ticker := time.NewTicker(chainSyncPollInterval)
+	defer ticker.Stop()
+
+	for !s.chainSynced() {
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			return errors.New("htlc switch have been stopped " +
+				"while waiting for chain sync")
+		}
+	}
+
+	return nil
+}
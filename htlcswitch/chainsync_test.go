@@ -0,0 +1,215 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// mockChainSync is a ChainSyncSource whose sync status is toggled manually.
+type mockChainSync struct {
+	synced int32
+}
+
+func (m *mockChainSync) IsSynced() bool {
+	return atomic.LoadInt32(&m.synced) == 1
+}
+
+func (m *mockChainSync) setSynced(synced bool) {
+	var v int32
+	if synced {
+		v = 1
+	}
+	atomic.StoreInt32(&m.synced, v)
+}
+
+// TestSwitchChainNotSynced tests that new forwards are failed back while the
+// node isn't synced to the chain, while HTLC's already in flight are still
+// resolved, and that local payments either wait for the node to sync or fail
+// fast.
+func TestSwitchChainNotSynced(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	chainSync := &mockChainSync{synced: 1}
+	s := New(Config{
+		ChainSync: chainSync,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	// forward sends an HTLC from Alice to Bob, and returns true if it
+	// reached Bob, or false if it was failed back.
+	forward := func(htlcID uint64) bool {
+		s.forward(&htlcPacket{
+			incomingChanID: aliceChanID,
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1000,
+			},
+		})
+
+		select {
+		case <-bobChannelLink.packets:
+			return true
+		case pkt := <-aliceChannelLink.packets:
+			if _, ok := pkt.htlc.(*lnwire.UpdateFailHTLC); !ok {
+				t.Fatalf("expected fail, got %T", pkt.htlc)
+			}
+			return false
+		case <-time.After(time.Second):
+			t.Fatalf("htlc %v was neither forwarded nor failed",
+				htlcID)
+		}
+		return false
+	}
+
+	settle := func(outgoingID uint64) {
+		err := s.forward(&htlcPacket{
+			outgoingChanID: bobChanID,
+			outgoingHTLCID: outgoingID,
+			htlc: &lnwire.UpdateFulfillHTLC{
+				PaymentPreimage: preimage,
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to settle htlc: %v", err)
+		}
+	}
+
+	// While synced, the forward reaches Bob.
+	if !forward(0) {
+		t.Fatalf("forward failed while synced")
+	}
+
+	// Once the node falls behind the chain, new forwards are failed
+	// back, while the forward in flight is still settled.
+	chainSync.setSynced(false)
+	if forward(1) {
+		t.Fatalf("forward wasn't failed while not synced")
+	}
+
+	settle(0)
+	select {
+	case pkt := <-aliceChannelLink.packets:
+		if _, ok := pkt.htlc.(*lnwire.UpdateFulfillHTLC); !ok {
+			t.Fatalf("expected settle, got %T", pkt.htlc)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("settle wasn't propagated to alice")
+	}
+
+	// A local payment waits until the node has synced.
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := s.SendHTLC(
+			bobPeer.PubKey(), &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1000,
+			}, newMockDeobfuscator(),
+		)
+		errChan <- err
+	}()
+
+	select {
+	case <-bobChannelLink.packets:
+		t.Fatalf("payment sent while not synced")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	chainSync.setSynced(true)
+	select {
+	case <-bobChannelLink.packets:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("payment wasn't sent once synced")
+	}
+
+	settle(1)
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Fatalf("payment failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("payment result not received")
+	}
+
+	// With the switch configured to fail fast, a local payment fails
+	// right away while the node isn't synced.
+	s.cfg.FailLocalPaymentsUnsynced = true
+	chainSync.setSynced(false)
+
+	_, err := s.SendHTLC(
+		bobPeer.PubKey(), &lnwire.UpdateAddHTLC{
+			PaymentHash: rhash,
+			Amount:      1000,
+		}, newMockDeobfuscator(),
+	)
+	if err != ErrChainNotSynced {
+		t.Fatalf("expected ErrChainNotSynced, got %v", err)
+	}
+
+	// Once synced, forwards are accepted again.
+	chainSync.setSynced(true)
+	if !forward(2) {
+		t.Fatalf("forward failed once synced")
+	}
+}
+
+// TestChannelLinkChainNotSynced tests that a link isn't eligible to forward
+// while the node isn't synced to the chain.
+func TestChannelLinkChainNotSynced(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	chainSync := &mockChainSync{}
+	aliceLink.cfg.Switch.cfg.ChainSync = chainSync
+
+	reason := aliceLink.IneligibleReason()
+	if reason != IneligibleChainNotSynced {
+		t.Fatalf("expected link to be ineligible as not synced, "+
+			"got %v", reason)
+	}
+	if usableForLocalPayment(reason) {
+		t.Fatalf("link shouldn't be usable while not synced")
+	}
+
+	chainSync.setSynced(true)
+	if reason := aliceLink.IneligibleReason(); reason != IneligibleNone {
+		t.Fatalf("expected link to be eligible, got %v", reason)
+	}
+}
@@ -0,0 +1,30 @@
+package htlcswitch
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// channelAge returns the time elapsed since the link's funding transaction
+// was confirmed. As the link only knows the height at which the funding
+// transaction confirmed, the age is estimated from the number of blocks mined
+// since, assuming the expected block interval.
+func (l *channelLink) channelAge() time.Duration {
+	confHeight := l.ShortChanID().BlockHeight
+	bestHeight := atomic.LoadUint32(&l.bestHeight)
+	if bestHeight <= confHeight {
+		return 0
+	}
+
+	return time.Duration(bestHeight-confHeight) * expectedBlockInterval
+}
+
+// isTooYoung returns true if the link's channel hasn't yet reached the
+// configured minimum age, and should therefore not carry any forwards.
+func (l *channelLink) isTooYoung() bool {
+	if l.cfg.MinChannelAge == 0 {
+		return false
+	}
+
+	return l.channelAge() < l.cfg.MinChannelAge
+}
@@ -0,0 +1,53 @@
+package htlcswitch
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkMinChannelAge tests that a link whose channel hasn't yet
+// reached the minimum channel age isn't eligible to forward, while remaining
+// usable for local payments, and that it becomes eligible once enough blocks
+// have been mined.
+func TestChannelLinkMinChannelAge(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	confHeight := aliceLink.ShortChanID().BlockHeight
+	atomic.StoreUint32(&aliceLink.bestHeight, confHeight+5)
+
+	if reason := aliceLink.IneligibleReason(); reason != IneligibleNone {
+		t.Fatalf("expected link to be eligible without a minimum "+
+			"age, got %v", reason)
+	}
+
+	// With a minimum age of ten blocks, the link is too young to forward,
+	// but may still carry local payments.
+	aliceLink.cfg.MinChannelAge = 10 * expectedBlockInterval
+	reason := aliceLink.IneligibleReason()
+	if reason != IneligibleTooYoung {
+		t.Fatalf("expected link to be too young, got %v", reason)
+	}
+	if aliceLink.EligibleToForward() {
+		t.Fatalf("link shouldn't be eligible to forward")
+	}
+	if !usableForLocalPayment(reason) {
+		t.Fatalf("too young link should be usable for local payments")
+	}
+
+	// Once the channel has reached the minimum age, the link is eligible
+	// to forward.
+	atomic.StoreUint32(&aliceLink.bestHeight, confHeight+10)
+	if reason := aliceLink.IneligibleReason(); reason != IneligibleNone {
+		t.Fatalf("expected link to be eligible, got %v", reason)
+	}
+}
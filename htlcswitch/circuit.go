@@ -67,13 +67,19 @@ type CircuitMap struct {
 	mtx       sync.RWMutex
 	circuits  map[circuitKey]*PaymentCircuit
 	hashIndex map[[32]byte]map[PaymentCircuit]struct{}
+
+	// incomingCounts tracks the number of circuits sourced by each
+	// incoming channel. Circuits for locally initiated payments aren't
+	// counted.
+	incomingCounts map[lnwire.ShortChannelID]int
 }
 
 // NewCircuitMap creates a new instance of the CircuitMap.
 func NewCircuitMap() *CircuitMap {
 	return &CircuitMap{
-		circuits:  make(map[circuitKey]*PaymentCircuit),
-		hashIndex: make(map[[32]byte]map[PaymentCircuit]struct{}),
+		circuits:       make(map[circuitKey]*PaymentCircuit),
+		hashIndex:      make(map[[32]byte]map[PaymentCircuit]struct{}),
+		incomingCounts: make(map[lnwire.ShortChannelID]int),
 	}
 }
 
@@ -117,7 +123,14 @@ func (cm *CircuitMap) Add(circuit *PaymentCircuit) error {
 		chanID: circuit.OutgoingChanID,
 		htlcID: circuit.OutgoingHTLCID,
 	}
+
+	// If we're replacing an existing circuit, then it no longer counts
+	// towards the circuits of its incoming channel.
+	if prev, ok := cm.circuits[key]; ok {
+		cm.decIncoming(prev.IncomingChanID)
+	}
 	cm.circuits[key] = circuit
+	cm.incIncoming(circuit.IncomingChanID)
 
 	// Add circuit to the hash index.
 	if _, ok := cm.hashIndex[circuit.PaymentHash]; !ok {
@@ -144,6 +157,7 @@ func (cm *CircuitMap) Remove(chanID lnwire.ShortChannelID, htlcID uint64) error
 		return errors.Errorf("Can't find circuit for HTLC %v", key)
 	}
 	delete(cm.circuits, key)
+	cm.decIncoming(circuit.IncomingChanID)
 
 	// Remove circuit from hash index.
 	circuitsWithHash, ok := cm.hashIndex[circuit.PaymentHash]
@@ -164,6 +178,60 @@ func (cm *CircuitMap) Remove(chanID lnwire.ShortChannelID, htlcID uint64) error
 	return nil
 }
 
+// UpdateShortChanID atomically replaces all references to the old short
+// channel ID, on either side of a circuit, with the new one. This is used to
+// migrate in-flight circuits once a re-org relocates the funding output of a
+// channel. The number of circuits updated is returned.
+func (cm *CircuitMap) UpdateShortChanID(oldID,
+	newID lnwire.ShortChannelID) int {
+
+	cm.mtx.Lock()
+	defer cm.mtx.Unlock()
+
+	// A blank short channel ID is used by the incoming side of locally
+	// initiated payments, so it never refers to an actual channel.
+	if oldID == newID || oldID == (lnwire.ShortChannelID{}) {
+		return 0
+	}
+
+	var updated int
+	for key, circuit := range cm.circuits {
+		if circuit.IncomingChanID != oldID &&
+			circuit.OutgoingChanID != oldID {
+
+			continue
+		}
+
+		// As the hash index is keyed by the circuit itself, we'll
+		// replace the circuit within it as well.
+		circuitsWithHash := cm.hashIndex[circuit.PaymentHash]
+		delete(circuitsWithHash, *circuit)
+
+		c := *circuit
+		if c.IncomingChanID == oldID {
+			c.IncomingChanID = newID
+		}
+		if c.OutgoingChanID == oldID {
+			c.OutgoingChanID = newID
+			delete(cm.circuits, key)
+			key.chanID = newID
+		}
+
+		cm.circuits[key] = &c
+		if circuitsWithHash != nil {
+			circuitsWithHash[c] = struct{}{}
+		}
+		updated++
+	}
+
+	if count, ok := cm.incomingCounts[oldID]; ok {
+		delete(cm.incomingCounts, oldID)
+		cm.incomingCounts[newID] += count
+	}
+
+	return updated
+}
+
 // pending returns number of circuits which are waiting for to be completed
 // (settle/fail responses to be received).
 func (cm *CircuitMap) pending() int {
@@ -172,3 +240,68 @@ func (cm *CircuitMap) pending() int {
 	cm.mtx.RUnlock()
 	return count
 }
+
+// circuitList returns a copy of all pending circuits.
+func (cm *CircuitMap) circuitList() []*PaymentCircuit {
+	cm.mtx.RLock()
+	defer cm.mtx.RUnlock()
+
+	circuits := make([]*PaymentCircuit, 0, len(cm.circuits))
+	for _, circuit := range cm.circuits {
+		c := *circuit
+		circuits = append(circuits, &c)
+	}
+
+	return circuits
+}
+
+// incIncoming increments the number of circuits sourced by the passed
+// incoming channel.
+//
+// NOTE: The circuit map's mutex MUST be held when calling this method.
+func (cm *CircuitMap) incIncoming(chanID lnwire.ShortChannelID) {
+	// A blank incoming channel indicates a locally initiated payment.
+	if chanID == (lnwire.ShortChannelID{}) {
+		return
+	}
+
+	cm.incomingCounts[chanID]++
+}
+
+// decIncoming decrements the number of circuits sourced by the passed
+// incoming channel.
+//
+// NOTE: The circuit map's mutex MUST be held when calling this method.
+func (cm *CircuitMap) decIncoming(chanID lnwire.ShortChannelID) {
+	if chanID == (lnwire.ShortChannelID{}) {
+		return
+	}
+
+	cm.incomingCounts[chanID]--
+	if cm.incomingCounts[chanID] <= 0 {
+		delete(cm.incomingCounts, chanID)
+	}
+}
+
+// NumIncoming returns the number of pending circuits sourced by the target
+// incoming channel.
+func (cm *CircuitMap) NumIncoming(chanID lnwire.ShortChannelID) int {
+	cm.mtx.RLock()
+	count := cm.incomingCounts[chanID]
+	cm.mtx.RUnlock()
+	return count
+}
+
+// IncomingCounts returns the number of pending circuits sourced by each
+// incoming channel with at least one pending circuit.
+func (cm *CircuitMap) IncomingCounts() map[lnwire.ShortChannelID]int {
+	cm.mtx.RLock()
+	defer cm.mtx.RUnlock()
+
+	counts := make(map[lnwire.ShortChannelID]int, len(cm.incomingCounts))
+	for chanID, count := range cm.incomingCounts {
+		counts[chanID] = count
+	}
+
+	return counts
+}
@@ -153,3 +153,176 @@ func TestCircuitMap(t *testing.T) {
 			"hash1: expecected %d, got %d", 0, len(circuits))
 	}
 }
+
+// TestCircuitMapIncomingCounts ensures that the circuit map tracks the number
+// of circuits sourced by each incoming channel, ignoring those of locally
+// initiated payments.
+func TestCircuitMapIncomingCounts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		chan1 = lnwire.NewShortChanIDFromInt(1)
+		chan2 = lnwire.NewShortChanIDFromInt(2)
+		chan3 = lnwire.NewShortChanIDFromInt(3)
+	)
+
+	circuitMap := htlcswitch.NewCircuitMap()
+
+	// Add two circuits sourced by the first channel, one sourced by the
+	// second, and a locally initiated one.
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		IncomingChanID: chan1,
+		IncomingHTLCID: 0,
+		OutgoingChanID: chan3,
+		OutgoingHTLCID: 0,
+	})
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		IncomingChanID: chan1,
+		IncomingHTLCID: 1,
+		OutgoingChanID: chan3,
+		OutgoingHTLCID: 1,
+	})
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		IncomingChanID: chan2,
+		IncomingHTLCID: 0,
+		OutgoingChanID: chan3,
+		OutgoingHTLCID: 2,
+	})
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		OutgoingChanID: chan3,
+		OutgoingHTLCID: 3,
+	})
+
+	counts := circuitMap.IncomingCounts()
+	if len(counts) != 2 {
+		t.Fatalf("expected counts for 2 channels, got %v", len(counts))
+	}
+	if counts[chan1] != 2 || circuitMap.NumIncoming(chan1) != 2 {
+		t.Fatalf("expected 2 circuits for chan1, got %v", counts[chan1])
+	}
+	if counts[chan2] != 1 || circuitMap.NumIncoming(chan2) != 1 {
+		t.Fatalf("expected 1 circuit for chan2, got %v", counts[chan2])
+	}
+
+	// Replacing a circuit should move it to the count of its new incoming
+	// channel.
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		IncomingChanID: chan2,
+		IncomingHTLCID: 1,
+		OutgoingChanID: chan3,
+		OutgoingHTLCID: 1,
+	})
+	if circuitMap.NumIncoming(chan1) != 1 {
+		t.Fatalf("expected 1 circuit for chan1, got %v",
+			circuitMap.NumIncoming(chan1))
+	}
+	if circuitMap.NumIncoming(chan2) != 2 {
+		t.Fatalf("expected 2 circuits for chan2, got %v",
+			circuitMap.NumIncoming(chan2))
+	}
+
+	// Once all circuits of a channel have been removed, it should no
+	// longer be reported.
+	if err := circuitMap.Remove(chan3, 0); err != nil {
+		t.Fatalf("unable to remove circuit: %v", err)
+	}
+	counts = circuitMap.IncomingCounts()
+	if _, ok := counts[chan1]; ok {
+		t.Fatalf("chan1 shouldn't have any circuits, got %v",
+			counts[chan1])
+	}
+}
+
+// TestCircuitMapUpdateShortChanID ensures that updating a short channel ID
+// within the circuit map migrates all circuits referencing it on either side,
+// while leaving those of locally initiated payments untouched.
+func TestCircuitMapUpdateShortChanID(t *testing.T) {
+	t.Parallel()
+
+	var hash [32]byte
+	hash[0] = 1
+
+	var (
+		chan1   = lnwire.NewShortChanIDFromInt(1)
+		chan2   = lnwire.NewShortChanIDFromInt(2)
+		newChan = lnwire.NewShortChanIDFromInt(10)
+	)
+
+	circuitMap := htlcswitch.NewCircuitMap()
+
+	// Add a circuit with the migrated channel on its incoming side, one
+	// with it on its outgoing side, and a locally initiated one.
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		PaymentHash:    hash,
+		IncomingChanID: chan1,
+		IncomingHTLCID: 0,
+		OutgoingChanID: chan2,
+		OutgoingHTLCID: 0,
+	})
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		PaymentHash:    hash,
+		IncomingChanID: chan2,
+		IncomingHTLCID: 1,
+		OutgoingChanID: chan1,
+		OutgoingHTLCID: 0,
+	})
+	circuitMap.Add(&htlcswitch.PaymentCircuit{
+		PaymentHash:    hash,
+		OutgoingChanID: chan1,
+		OutgoingHTLCID: 1,
+	})
+
+	// A blank short channel ID should never be migrated.
+	if n := circuitMap.UpdateShortChanID(
+		lnwire.ShortChannelID{}, newChan,
+	); n != 0 {
+		t.Fatalf("expected no circuits to be updated, got %v", n)
+	}
+
+	if n := circuitMap.UpdateShortChanID(chan1, newChan); n != 3 {
+		t.Fatalf("expected 3 circuits to be updated, got %v", n)
+	}
+
+	if circuit := circuitMap.LookupByHTLC(chan1, 0); circuit != nil {
+		t.Fatalf("circuit still found under old channel: %v", circuit)
+	}
+	circuit := circuitMap.LookupByHTLC(newChan, 0)
+	if circuit == nil || circuit.IncomingChanID != chan2 {
+		t.Fatalf("circuit not found under new channel: %v", circuit)
+	}
+	circuit = circuitMap.LookupByHTLC(newChan, 1)
+	if circuit == nil || circuit.IncomingChanID != (lnwire.ShortChannelID{}) {
+		t.Fatalf("local circuit not found under new channel: %v",
+			circuit)
+	}
+	circuit = circuitMap.LookupByHTLC(chan2, 0)
+	if circuit == nil || circuit.IncomingChanID != newChan {
+		t.Fatalf("incoming channel of circuit not updated: %v",
+			circuit)
+	}
+
+	if circuitMap.NumIncoming(chan1) != 0 ||
+		circuitMap.NumIncoming(newChan) != 1 {
+
+		t.Fatalf("incoming count not migrated: %v",
+			circuitMap.IncomingCounts())
+	}
+
+	// The hash index should reflect the updated circuits.
+	for _, circuit := range circuitMap.LookupByPaymentHash(hash) {
+		if circuit.IncomingChanID == chan1 ||
+			circuit.OutgoingChanID == chan1 {
+
+			t.Fatalf("hash index references old channel: %v",
+				circuit)
+		}
+	}
+
+	// Removing the migrated circuits should succeed.
+	if err := circuitMap.Remove(newChan, 0); err != nil {
+		t.Fatalf("unable to remove circuit: %v", err)
+	}
+	if err := circuitMap.Remove(chan2, 0); err != nil {
+		t.Fatalf("unable to remove circuit: %v", err)
+	}
+}
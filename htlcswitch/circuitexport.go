@@ -0,0 +1,245 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// circuitExportVersion is the current version of the serialization
+	// format produced by ExportCircuits.
+	circuitExportVersion uint8 = 1
+)
+
+var (
+	// ErrUnknownCircuitExportVersion is returned when attempting to
+	// import circuits serialized with an unsupported format version.
+	ErrUnknownCircuitExportVersion = errors.New("unknown circuit export " +
+		"version")
+)
+
+// circuitIncomingKey identifies a circuit by the incoming HTLC that it
+// forwards, which must be unique across all circuits.
+type circuitIncomingKey struct {
+	chanID lnwire.ShortChannelID
+	htlcID uint64
+}
+
+// ExportCircuits serializes the set of pending circuits in a versioned format,
+// allowing them to be restored with ImportCircuits on a new instance of the
+// switch, e.g. when migrating a node to new hardware. The exported circuits
+// are only meaningful alongside the channel state they were exported with, so
+// the channel database MUST be migrated together with them.
+//
+// NOTE: The error encrypters of the circuits are ephemeral and aren't
+// exported. As a result, failures returned through imported circuits are
+// passed back without an additional layer of onion encryption.
+func (s *Switch) ExportCircuits() ([]byte, error) {
+	circuits := s.circuits.circuitList()
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.BigEndian, circuitExportVersion); err != nil {
+		return nil, err
+	}
+	numCircuits := uint32(len(circuits))
+	if err := binary.Write(&b, binary.BigEndian, numCircuits); err != nil {
+		return nil, err
+	}
+
+	for _, circuit := range circuits {
+		if err := serializeCircuit(&b, circuit); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("Exported %v pending circuits", len(circuits))
+
+	return b.Bytes(), nil
+}
+
+// importCircuitsCmd is a message sent to the switch in order to import a set
+// of previously exported circuits.
+type importCircuitsCmd struct {
+	circuits []*PaymentCircuit
+
+	err chan error
+}
+
+// ImportCircuits restores a set of circuits previously serialized by
+// ExportCircuits. The circuits are validated against the links currently
+// loaded by the switch: a circuit referencing a channel without an active
+// link is considered stale, and a circuit whose incoming or outgoing HTLC is
+// already claimed by another circuit is considered conflicting. If any
+// circuit is stale or conflicting, then the import is rejected as a whole and
+// no circuits are added. Circuits should be imported after the links of the
+// migrated channels have been added, but before they begin forwarding.
+//
+// NOTE: This function is synchronous and will block until either the
+// circuits have been imported, or the switch shuts down.
+func (s *Switch) ImportCircuits(data []byte) error {
+	circuits, err := deserializeCircuits(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	cmd := &importCircuitsCmd{
+		circuits: circuits,
+		err:      make(chan error, 1),
+	}
+
+	select {
+	case s.linkControl <- cmd:
+	case <-s.quit:
+		return errors.New("htlc switch was stopped")
+	}
+
+	select {
+	case err := <-cmd.err:
+		return err
+	case <-s.quit:
+		return errors.New("htlc switch was stopped")
+	}
+}
+
+// importCircuits validates the passed circuits against the loaded links and
+// the pending circuits, adding them to the circuit map only if they're all
+// valid.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) importCircuits(circuits []*PaymentCircuit) error {
+	outgoing := make(map[circuitKey]struct{})
+	incoming := make(map[circuitIncomingKey]struct{})
+	for _, circuit := range s.circuits.circuitList() {
+		outgoing[circuitKey{
+			chanID: circuit.OutgoingChanID,
+			htlcID: circuit.OutgoingHTLCID,
+		}] = struct{}{}
+
+		if circuit.IncomingChanID != (lnwire.ShortChannelID{}) {
+			incoming[circuitIncomingKey{
+				chanID: circuit.IncomingChanID,
+				htlcID: circuit.IncomingHTLCID,
+			}] = struct{}{}
+		}
+	}
+
+	for _, circuit := range circuits {
+		// A blank incoming channel indicates a locally initiated
+		// payment, which has no incoming link.
+		isLocal := circuit.IncomingChanID == (lnwire.ShortChannelID{})
+		if !isLocal {
+			_, err := s.getLinkByShortID(circuit.IncomingChanID)
+			if err != nil {
+				return errors.Errorf("stale circuit: incoming "+
+					"channel %v isn't loaded",
+					circuit.IncomingChanID)
+			}
+		}
+		_, err := s.getLinkByShortID(circuit.OutgoingChanID)
+		if err != nil {
+			return errors.Errorf("stale circuit: outgoing "+
+				"channel %v isn't loaded", circuit.OutgoingChanID)
+		}
+
+		outKey := circuitKey{
+			chanID: circuit.OutgoingChanID,
+			htlcID: circuit.OutgoingHTLCID,
+		}
+		if _, ok := outgoing[outKey]; ok {
+			return errors.Errorf("conflicting circuit for "+
+				"outgoing HTLC %v", outKey.String())
+		}
+		outgoing[outKey] = struct{}{}
+
+		if isLocal {
+			continue
+		}
+
+		inKey := circuitIncomingKey{
+			chanID: circuit.IncomingChanID,
+			htlcID: circuit.IncomingHTLCID,
+		}
+		if _, ok := incoming[inKey]; ok {
+			return errors.Errorf("conflicting circuit for "+
+				"incoming HTLC (Chan ID=%s, HTLC ID=%d)",
+				inKey.chanID, inKey.htlcID)
+		}
+		incoming[inKey] = struct{}{}
+	}
+
+	for _, circuit := range circuits {
+		s.circuits.Add(circuit)
+	}
+
+	log.Infof("Imported %v circuits", len(circuits))
+
+	return nil
+}
+
+// serializeCircuit writes the routing information of the passed circuit to
+// the writer.
+func serializeCircuit(w io.Writer, circuit *PaymentCircuit) error {
+	if _, err := w.Write(circuit.PaymentHash[:]); err != nil {
+		return err
+	}
+
+	fields := []uint64{
+		circuit.IncomingChanID.ToUint64(),
+		circuit.IncomingHTLCID,
+		circuit.OutgoingChanID.ToUint64(),
+		circuit.OutgoingHTLCID,
+	}
+	for _, field := range fields {
+		if err := binary.Write(w, binary.BigEndian, field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deserializeCircuits reads a set of circuits serialized by ExportCircuits
+// from the reader.
+func deserializeCircuits(r io.Reader) ([]*PaymentCircuit, error) {
+	var version uint8
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != circuitExportVersion {
+		return nil, ErrUnknownCircuitExportVersion
+	}
+
+	var numCircuits uint32
+	if err := binary.Read(r, binary.BigEndian, &numCircuits); err != nil {
+		return nil, err
+	}
+
+	var circuits []*PaymentCircuit
+	for i := uint32(0); i < numCircuits; i++ {
+		circuit := &PaymentCircuit{}
+		if _, err := io.ReadFull(r, circuit.PaymentHash[:]); err != nil {
+			return nil, err
+		}
+
+		var fields [4]uint64
+		for j := range fields {
+			err := binary.Read(r, binary.BigEndian, &fields[j])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		circuit.IncomingChanID = lnwire.NewShortChanIDFromInt(fields[0])
+		circuit.IncomingHTLCID = fields[1]
+		circuit.OutgoingChanID = lnwire.NewShortChanIDFromInt(fields[2])
+		circuit.OutgoingHTLCID = fields[3]
+
+		circuits = append(circuits, circuit)
+	}
+
+	return circuits, nil
+}
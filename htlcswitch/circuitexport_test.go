@@ -0,0 +1,222 @@
+package htlcswitch
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// newCircuitExportSwitch creates and starts a switch with links for Alice's
+// and Bob's channels.
+func newCircuitExportSwitch(t *testing.T) (*Switch, *mockChannelLink,
+	*mockChannelLink) {
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	return s, aliceChannelLink, bobChannelLink
+}
+
+// sortedCircuits returns the pending circuits of the switch, sorted by their
+// outgoing HTLC, with their error encrypters stripped.
+func sortedCircuits(s *Switch) []PaymentCircuit {
+	var circuits []PaymentCircuit
+	for _, circuit := range s.circuits.circuitList() {
+		c := *circuit
+		c.ErrorEncrypter = nil
+		circuits = append(circuits, c)
+	}
+
+	sort.Slice(circuits, func(i, j int) bool {
+		if circuits[i].OutgoingChanID != circuits[j].OutgoingChanID {
+			return circuits[i].OutgoingChanID.ToUint64() <
+				circuits[j].OutgoingChanID.ToUint64()
+		}
+		return circuits[i].OutgoingHTLCID < circuits[j].OutgoingHTLCID
+	})
+
+	return circuits
+}
+
+// TestSwitchCircuitExportRoundTrip ensures that circuits exported from one
+// switch can be imported into another with the same set of channels, and
+// that the imported circuits are used to resolve HTLC's.
+func TestSwitchCircuitExportRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := newCircuitExportSwitch(t)
+	defer s.Stop()
+
+	// Add a forwarded circuit from Alice to Bob, and a locally initiated
+	// one to Alice.
+	s.addCircuit(&PaymentCircuit{
+		PaymentHash:    [32]byte{1},
+		IncomingChanID: aliceChanID,
+		IncomingHTLCID: 5,
+		OutgoingChanID: bobChanID,
+		OutgoingHTLCID: 7,
+		ErrorEncrypter: newMockObfuscator(),
+	})
+	s.addCircuit(&PaymentCircuit{
+		PaymentHash:    [32]byte{2},
+		OutgoingChanID: aliceChanID,
+		OutgoingHTLCID: 3,
+	})
+
+	data, err := s.ExportCircuits()
+	if err != nil {
+		t.Fatalf("unable to export circuits: %v", err)
+	}
+
+	s2, aliceChannelLink, _ := newCircuitExportSwitch(t)
+	defer s2.Stop()
+
+	if err := s2.ImportCircuits(data); err != nil {
+		t.Fatalf("unable to import circuits: %v", err)
+	}
+
+	expected := sortedCircuits(s)
+	imported := sortedCircuits(s2)
+	if !reflect.DeepEqual(expected, imported) {
+		t.Fatalf("imported circuits don't match: expected %v, got %v",
+			expected, imported)
+	}
+
+	// An empty set of circuits should round trip as well.
+	empty := New(Config{})
+	data, err = empty.ExportCircuits()
+	if err != nil {
+		t.Fatalf("unable to export circuits: %v", err)
+	}
+	if err := s2.ImportCircuits(data); err != nil {
+		t.Fatalf("unable to import empty circuits: %v", err)
+	}
+	if s2.circuits.pending() != 2 {
+		t.Fatalf("expected 2 circuits, got %v", s2.circuits.pending())
+	}
+
+	// Settling the forwarded HTLC over Bob's channel should be routed back
+	// to Alice through the imported circuit.
+	err = s2.forward(&htlcPacket{
+		outgoingChanID: bobChanID,
+		outgoingHTLCID: 7,
+		amount:         1,
+		htlc: &lnwire.UpdateFulfillHTLC{
+			PaymentPreimage: [32]byte{1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to forward settle: %v", err)
+	}
+
+	select {
+	case packet := <-aliceChannelLink.packets:
+		if packet.incomingHTLCID != 5 {
+			t.Fatalf("settle sent to wrong htlc: expected 5, "+
+				"got %v", packet.incomingHTLCID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("settle was not propagated to source")
+	}
+
+	if s2.circuits.pending() != 1 {
+		t.Fatalf("expected 1 circuit, got %v", s2.circuits.pending())
+	}
+}
+
+// TestSwitchCircuitImportInvalid ensures that imports containing stale or
+// conflicting circuits, or serialized in an unknown format, are rejected
+// without adding any circuits.
+func TestSwitchCircuitImportInvalid(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := newCircuitExportSwitch(t)
+	defer s.Stop()
+
+	s.addCircuit(&PaymentCircuit{
+		IncomingChanID: aliceChanID,
+		IncomingHTLCID: 0,
+		OutgoingChanID: bobChanID,
+		OutgoingHTLCID: 0,
+	})
+	s.addCircuit(&PaymentCircuit{
+		IncomingChanID: aliceChanID,
+		IncomingHTLCID: 1,
+		OutgoingChanID: bobChanID,
+		OutgoingHTLCID: 1,
+	})
+
+	data, err := s.ExportCircuits()
+	if err != nil {
+		t.Fatalf("unable to export circuits: %v", err)
+	}
+
+	// Importing the circuits back into the same switch conflicts with
+	// the pending circuits.
+	if err := s.ImportCircuits(data); err == nil {
+		t.Fatalf("conflicting circuits should be rejected")
+	}
+	if s.circuits.pending() != 2 {
+		t.Fatalf("expected 2 circuits, got %v", s.circuits.pending())
+	}
+
+	// A switch without Bob's channel considers the circuits stale.
+	stale := New(Config{})
+	if err := stale.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer stale.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		stale, chanID1, aliceChanID, newMockServer(t, "alice"), true,
+	)
+	if err := stale.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := stale.ImportCircuits(data); err == nil {
+		t.Fatalf("stale circuits should be rejected")
+	}
+	if stale.circuits.pending() != 0 {
+		t.Fatalf("expected no circuits, got %v",
+			stale.circuits.pending())
+	}
+
+	// Unknown versions and truncated data should be rejected.
+	s2, _, _ := newCircuitExportSwitch(t)
+	defer s2.Stop()
+
+	badVersion := append([]byte{}, data...)
+	badVersion[0] = circuitExportVersion + 1
+	err = s2.ImportCircuits(badVersion)
+	if err != ErrUnknownCircuitExportVersion {
+		t.Fatalf("expected ErrUnknownCircuitExportVersion, got %v", err)
+	}
+
+	if err := s2.ImportCircuits(data[:len(data)-1]); err == nil {
+		t.Fatalf("truncated circuits should be rejected")
+	}
+	if s2.circuits.pending() != 0 {
+		t.Fatalf("expected no circuits, got %v", s2.circuits.pending())
+	}
+}
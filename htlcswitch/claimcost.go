@@ -0,0 +1,35 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/roasbeef/btcd/blockchain"
+	"github.com/roasbeef/btcutil"
+)
+
+// secondLevelSweepWeight is the estimated weight of a transaction sweeping the
+// output of a second-level HTLC success transaction to a P2WKH output.
+const secondLevelSweepWeight = blockchain.WitnessScaleFactor*(lnwallet.BaseTxSize+
+	1+lnwallet.InputSize+1+lnwallet.P2WKHOutputSize) +
+	lnwallet.WitnessHeaderSize + lnwallet.SecondLevelHtlcSuccessWitnessSize
+
+// exitHopClaimWeight is the estimated weight of the transactions required to
+// claim an HTLC paying to us from our own commitment transaction once it has
+// been force closed: the second-level HTLC success transaction, along with the
+// sweep of its output.
+const exitHopClaimWeight = lnwallet.HtlcSuccessWeight + secondLevelSweepWeight
+
+// uneconomicThreshold returns the smallest HTLC paying to us that we'll
+// accept, which is the configured multiple of the estimated cost of claiming
+// it on-chain at the current commitment fee rate. Any smaller HTLC would cost
+// more to enforce than it's worth. If the check is disabled, then zero is
+// returned.
+func (l *channelLink) uneconomicThreshold() btcutil.Amount {
+	if l.cfg.MinClaimCostMultiple <= 0 {
+		return 0
+	}
+
+	feePerKw := l.channel.CommitFeeRate()
+	claimCost := feePerKw.FeeForWeight(exitHopClaimWeight)
+
+	return btcutil.Amount(float64(claimCost) * l.cfg.MinClaimCostMultiple)
+}
@@ -0,0 +1,97 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkUneconomicHTLC tests that an HTLC paying to us below the
+// configured multiple of its estimated claim cost is rejected, and that the
+// threshold is surfaced through the link's commitment state.
+func TestChannelLinkUneconomicHTLC(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// The check is disabled by default.
+	state := aliceLink.CommitmentState()
+	if state.UneconomicThreshold != 0 {
+		t.Fatalf("expected no threshold, got %v",
+			state.UneconomicThreshold)
+	}
+
+	aliceLink.cfg.MinClaimCostMultiple = 1
+	claimCost := aliceLink.CommitmentState().UneconomicThreshold
+	if claimCost == 0 {
+		t.Fatalf("expected non-zero claim cost")
+	}
+
+	// We'll require HTLC's to be worth twice their claim cost, and send
+	// one that's only worth a little more than the claim cost.
+	aliceLink.cfg.MinClaimCostMultiple = 2
+	threshold := aliceLink.CommitmentState().UneconomicThreshold
+	if threshold != 2*claimCost {
+		t.Fatalf("expected threshold %v, got %v", 2*claimCost,
+			threshold)
+	}
+
+	htlcAmt, totalTimelock, hops := generateHops(
+		lnwire.NewMSatFromSatoshis(claimCost+1), testStartingHeight,
+		aliceLink,
+	)
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatalf("unable to gen route: %v", err)
+	}
+	invoice, htlc, err := generatePayment(
+		htlcAmt, htlcAmt, totalTimelock, blob,
+	)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	registry := aliceLink.cfg.Registry.(*mockInvoiceRegistry)
+	if err := registry.AddInvoice(*invoice); err != nil {
+		t.Fatalf("unable to add invoice: %v", err)
+	}
+
+	if _, err := bobChannel.AddHTLC(htlc); err != nil {
+		t.Fatalf("unable to add htlc: %v", err)
+	}
+	aliceLink.HandleChannelUpdate(htlc)
+	if err := updateState(batchTick, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// Despite the invoice being known, the HTLC should be failed back.
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive fail")
+	}
+	fail, ok := msg.(*lnwire.UpdateFailHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateFailHTLC, got %T", msg)
+	}
+	failure, err := lnwire.DecodeFailure(bytes.NewReader(fail.Reason), 0)
+	if err != nil {
+		t.Fatalf("unable to decode failure: %v", err)
+	}
+	if _, ok := failure.(*lnwire.FailIncorrectPaymentAmount); !ok {
+		t.Fatalf("expected FailIncorrectPaymentAmount, got %T",
+			failure)
+	}
+}
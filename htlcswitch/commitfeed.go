@@ -0,0 +1,194 @@
+package htlcswitch
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// commitSubscriberBuffer is the number of commitment snapshots buffered for
+// each subscriber. Once a subscriber's buffer is full, further snapshots are
+// dropped rather than blocking the link.
+const commitSubscriberBuffer = 50
+
+// CommitmentHTLC is an HTLC within a CommitmentSnapshot. Its onion blob and
+// signatures are omitted.
+type CommitmentHTLC struct {
+	// HtlcIndex is the index of the HTLC within the update log of the
+	// party which offered it.
+	HtlcIndex uint64
+
+	// RHash is the payment hash of the HTLC.
+	RHash [32]byte
+
+	// Amt is the value of the HTLC.
+	Amt lnwire.MilliSatoshi
+
+	// RefundTimeout is the absolute height at which the HTLC times out.
+	RefundTimeout uint32
+
+	// Incoming is true if the HTLC was offered to us.
+	Incoming bool
+
+	// OutputIndex is the index of the HTLC's output within the commitment
+	// transaction, or -1 if it was trimmed as dust.
+	OutputIndex int32
+}
+
+// CommitmentSnapshot is a view of a commitment transaction of a link's
+// channel, as of the moment it was established. It only describes the state
+// of the commitment, and never includes any secret material, such as
+// revocation secrets.
+type CommitmentSnapshot struct {
+	// ChanID and ShortChanID identify the channel of the link.
+	ChanID      lnwire.ChannelID
+	ShortChanID lnwire.ShortChannelID
+
+	// Local is true if this is our commitment transaction, which was
+	// established once we revoked our prior commitment. Otherwise, it's
+	// the remote party's commitment transaction, which was established
+	// once they revoked their prior commitment.
+	Local bool
+
+	// Height is the height of the commitment within its chain.
+	Height uint64
+
+	// LocalBalance and RemoteBalance are our balance and that of the
+	// remote party within the commitment.
+	LocalBalance  lnwire.MilliSatoshi
+	RemoteBalance lnwire.MilliSatoshi
+
+	// CommitFee is the fee paid by the commitment transaction, at the
+	// rate of FeePerKw.
+	CommitFee btcutil.Amount
+	FeePerKw  btcutil.Amount
+
+	// Htlcs is the set of HTLC's on the commitment.
+	Htlcs []CommitmentHTLC
+}
+
+// CommitmentSubscription is returned by SubscribeCommitments, and delivers a
+// CommitmentSnapshot each time a new commitment is established.
+type CommitmentSubscription struct {
+	// Updates is the channel over which snapshots are sent. The channel
+	// is closed once the subscription has been cancelled.
+	Updates <-chan *CommitmentSnapshot
+
+	// Cancel cancels the subscription.
+	Cancel func()
+
+	dropped *uint64
+}
+
+// Dropped returns the number of snapshots dropped as the subscriber fell
+// behind.
+func (c *CommitmentSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(c.dropped)
+}
+
+// commitSubscriber is a single subscriber of a commitFeed.
+type commitSubscriber struct {
+	updates chan *CommitmentSnapshot
+	dropped uint64
+}
+
+// commitFeed delivers the snapshots of a link's newly established
+// commitments to all active subscribers.
+type commitFeed struct {
+	sync.Mutex
+
+	subscribers map[uint64]*commitSubscriber
+	nextSubID   uint64
+}
+
+// SubscribeCommitments returns a new subscription which delivers a snapshot
+// of each commitment established from this point onwards, both ours and that
+// of the remote party. Subscribers which fall behind miss snapshots, rather
+// than blocking the link, which are counted by the subscription.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) SubscribeCommitments() *CommitmentSubscription {
+	f := &l.commitFeed
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.subscribers == nil {
+		f.subscribers = make(map[uint64]*commitSubscriber)
+	}
+
+	id := f.nextSubID
+	f.nextSubID++
+
+	sub := &commitSubscriber{
+		updates: make(chan *CommitmentSnapshot, commitSubscriberBuffer),
+	}
+	f.subscribers[id] = sub
+
+	var once sync.Once
+	return &CommitmentSubscription{
+		Updates: sub.updates,
+		Cancel: func() {
+			once.Do(func() {
+				f.Lock()
+				delete(f.subscribers, id)
+				close(sub.updates)
+				f.Unlock()
+			})
+		},
+		dropped: &sub.dropped,
+	}
+}
+
+// notifyCommitment delivers a snapshot of the passed, newly established
+// commitment to all subscribers.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) notifyCommitment(commit *channeldb.ChannelCommitment,
+	local bool) {
+
+	f := &l.commitFeed
+
+	f.Lock()
+	defer f.Unlock()
+
+	if len(f.subscribers) == 0 {
+		return
+	}
+
+	snapshot := &CommitmentSnapshot{
+		ChanID:        l.ChanID(),
+		ShortChanID:   l.ShortChanID(),
+		Local:         local,
+		Height:        commit.CommitHeight,
+		LocalBalance:  commit.LocalBalance,
+		RemoteBalance: commit.RemoteBalance,
+		CommitFee:     commit.CommitFee,
+		FeePerKw:      commit.FeePerKw,
+		Htlcs:         make([]CommitmentHTLC, 0, len(commit.Htlcs)),
+	}
+	for _, htlc := range commit.Htlcs {
+		snapshot.Htlcs = append(snapshot.Htlcs, CommitmentHTLC{
+			HtlcIndex:     htlc.HtlcIndex,
+			RHash:         htlc.RHash,
+			Amt:           htlc.Amt,
+			RefundTimeout: htlc.RefundTimeout,
+			Incoming:      htlc.Incoming,
+			OutputIndex:   htlc.OutputIndex,
+		})
+	}
+
+	for id, sub := range f.subscribers {
+		select {
+		case sub.updates <- snapshot:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+			log.Warnf("ChannelLink(%v) dropping commitment snapshot "+
+				"at height %v, subscriber %v is falling behind",
+				l, snapshot.Height, id)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkSubscribeCommitments tests that a link delivers a snapshot of
+// both its own and the remote party's commitment once each is established,
+// and that snapshots are dropped and counted for a subscriber which falls
+// behind.
+func TestChannelLinkSubscribeCommitments(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	sub := aliceLink.SubscribeCommitments()
+	defer sub.Cancel()
+
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	var mockBlob [lnwire.OnionPacketSize]byte
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("htlc was not offered")
+	}
+	addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	if _, err := bobChannel.ReceiveHTLC(addHtlc); err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+	if err := updateState(batchTick, aliceLink, bobChannel, true); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// Bob's revocation establishes his commitment, after which Alice's
+	// revocation establishes her own.
+	for _, local := range []bool{false, true} {
+		var snapshot *CommitmentSnapshot
+		select {
+		case snapshot = <-sub.Updates:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("commitment snapshot not received")
+		}
+
+		if snapshot.Local != local {
+			t.Fatalf("expected local=%v snapshot, got local=%v",
+				local, snapshot.Local)
+		}
+		if snapshot.ChanID != aliceLink.ChanID() {
+			t.Fatalf("wrong channel: expected %v, got %v",
+				aliceLink.ChanID(), snapshot.ChanID)
+		}
+		if len(snapshot.Htlcs) != 1 {
+			t.Fatalf("expected 1 htlc, got %v",
+				len(snapshot.Htlcs))
+		}
+		snapshotHtlc := snapshot.Htlcs[0]
+		if snapshotHtlc.Amt != htlcAmt || snapshotHtlc.Incoming ||
+			snapshotHtlc.RHash != htlc.PaymentHash {
+
+			t.Fatalf("unexpected htlc: %v", snapshotHtlc)
+		}
+	}
+
+	// A subscriber which never reads its snapshots should have them
+	// dropped once its buffer is full, without blocking the link.
+	slowSub := aliceLink.SubscribeCommitments()
+	defer slowSub.Cancel()
+
+	commit := &aliceLink.channel.State().LocalCommitment
+	for i := 0; i < commitSubscriberBuffer+2; i++ {
+		aliceLink.notifyCommitment(commit, true)
+	}
+	if dropped := slowSub.Dropped(); dropped != 2 {
+		t.Fatalf("expected 2 dropped snapshots, got %v", dropped)
+	}
+
+	// Once cancelled, the subscription's channel should be closed.
+	sub.Cancel()
+	for range sub.Updates {
+	}
+}
@@ -0,0 +1,129 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// CommitmentType denotes the format of a channel's commitment transactions,
+// which determines how its HTLC's are trimmed as dust, how much of the
+// initiator's balance is set aside for anchor outputs, and how high the
+// commitment fee rate may be raised.
+type CommitmentType uint8
+
+const (
+	// CommitmentTypeLegacy denotes commitments without anchor outputs,
+	// whose fee rate must be high enough to confirm on its own.
+	CommitmentTypeLegacy CommitmentType = iota
+
+	// CommitmentTypeAnchors denotes commitments with anchor outputs, which
+	// allow the commitment to be fee bumped through CPFP.
+	CommitmentTypeAnchors
+
+	// CommitmentTypeAnchorsZeroFeeHtlc denotes anchor commitments whose
+	// second-level HTLC transactions pay no fee, as they're fee bumped by
+	// attaching additional inputs.
+	CommitmentTypeAnchorsZeroFeeHtlc
+
+	// CommitmentTypeTaproot denotes taproot commitments, which have anchor
+	// outputs and zero fee second-level HTLC transactions.
+	CommitmentTypeTaproot
+)
+
+const (
+	// AnchorSize is the value of each of the two anchor outputs of a
+	// commitment transaction, which is paid by the channel initiator.
+	AnchorSize btcutil.Amount = 330
+
+	// MaxAnchorsCommitFeeRate is the highest commitment fee rate we'll
+	// propose for channels with anchor outputs. Since their commitments
+	// can be fee bumped through CPFP, the commitment fee rate only needs
+	// to get them into the mempool.
+	MaxAnchorsCommitFeeRate lnwallet.SatPerVByte = 10
+)
+
+// String returns the human readable name of the commitment type.
+func (c CommitmentType) String() string {
+	switch c {
+	case CommitmentTypeLegacy:
+		return "Legacy"
+	case CommitmentTypeAnchors:
+		return "Anchors"
+	case CommitmentTypeAnchorsZeroFeeHtlc:
+		return "AnchorsZeroFeeHtlc"
+	case CommitmentTypeTaproot:
+		return "Taproot"
+	default:
+		return "Unknown"
+	}
+}
+
+// HasAnchors returns true if commitments of this type have anchor outputs.
+func (c CommitmentType) HasAnchors() bool {
+	return c != CommitmentTypeLegacy
+}
+
+// ZeroHtlcTxFee returns true if the second-level HTLC transactions of this
+// commitment type pay no fee.
+func (c CommitmentType) ZeroHtlcTxFee() bool {
+	return c == CommitmentTypeAnchorsZeroFeeHtlc ||
+		c == CommitmentTypeTaproot
+}
+
+// CommitmentType returns the format of the link's commitment transactions.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) CommitmentType() CommitmentType {
+	return l.cfg.CommitmentType
+}
+
+// htlcDustThreshold returns the smallest incoming HTLC which wouldn't be
+// trimmed as dust from either commitment at the passed fee rate. If the
+// second-level HTLC transactions pay no fee, then this is simply the larger of
+// the two dust limits, as the fee rate doesn't affect it.
+func (l *channelLink) htlcDustThreshold(
+	feePerKw lnwallet.SatPerKWeight) btcutil.Amount {
+
+	if !l.CommitmentType().ZeroHtlcTxFee() {
+		return l.channel.HtlcDustThreshold(true, feePerKw)
+	}
+
+	chanState := l.channel.State()
+	localDustLimit := chanState.LocalChanCfg.DustLimit
+	remoteDustLimit := chanState.RemoteChanCfg.DustLimit
+	if localDustLimit > remoteDustLimit {
+		return localDustLimit
+	}
+
+	return remoteDustLimit
+}
+
+// anchorReserve returns the part of our balance which is set aside for the
+// anchor outputs of the commitment transactions, and so can't be forwarded.
+// Only the channel initiator pays for the anchors.
+func (l *channelLink) anchorReserve() lnwire.MilliSatoshi {
+	if !l.CommitmentType().HasAnchors() || !l.channel.IsInitiator() {
+		return 0
+	}
+
+	return lnwire.NewMSatFromSatoshis(2 * AnchorSize)
+}
+
+// commitFeeCeiling caps the passed network fee rate to the highest fee rate
+// we'll propose for the link's commitment transactions. Commitments without
+// anchor outputs can't be fee bumped, so their fee rate is left as is.
+func (l *channelLink) commitFeeCeiling(
+	netFee lnwallet.SatPerKWeight) lnwallet.SatPerKWeight {
+
+	if !l.CommitmentType().HasAnchors() {
+		return netFee
+	}
+
+	ceiling := MaxAnchorsCommitFeeRate.FeePerKWeight()
+	if netFee > ceiling {
+		return ceiling
+	}
+
+	return netFee
+}
@@ -0,0 +1,126 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+var commitmentTypes = []CommitmentType{
+	CommitmentTypeLegacy,
+	CommitmentTypeAnchors,
+	CommitmentTypeAnchorsZeroFeeHtlc,
+	CommitmentTypeTaproot,
+}
+
+// TestChannelLinkCommitmentTypeDust tests that the dust threshold of a link
+// only depends on the commitment fee rate if its second-level HTLC
+// transactions pay a fee.
+func TestChannelLinkCommitmentTypeDust(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+
+	for _, commitType := range commitmentTypes {
+		aliceLink.cfg.CommitmentType = commitType
+		state := aliceLink.CommitmentState()
+		if state.CommitmentType != commitType {
+			t.Fatalf("expected commitment type %v, got %v",
+				commitType, state.CommitmentType)
+		}
+
+		dustLimit := state.LocalDustLimit
+		if state.RemoteDustLimit > dustLimit {
+			dustLimit = state.RemoteDustLimit
+		}
+
+		if commitType.ZeroHtlcTxFee() {
+			if state.DustThreshold != dustLimit ||
+				state.StressDustThreshold != dustLimit {
+
+				t.Fatalf("%v: expected dust thresholds to "+
+					"equal dust limit %v, got %v and %v",
+					commitType, dustLimit,
+					state.DustThreshold,
+					state.StressDustThreshold)
+			}
+			continue
+		}
+
+		if state.DustThreshold <= dustLimit {
+			t.Fatalf("%v: expected dust threshold above dust "+
+				"limit %v, got %v", commitType, dustLimit,
+				state.DustThreshold)
+		}
+		if state.StressDustThreshold <= state.DustThreshold {
+			t.Fatalf("%v: stress dust threshold should exceed "+
+				"dust threshold", commitType)
+		}
+	}
+}
+
+// TestChannelLinkCommitmentTypeReserve tests that the initiator of a channel
+// with anchor outputs sets aside their value from the link's bandwidth, and
+// that the commitment fee rate of such channels is capped.
+func TestChannelLinkCommitmentTypeReserve(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	if !aliceLink.channel.IsInitiator() {
+		t.Fatalf("expected alice to be the channel initiator")
+	}
+
+	legacyBandwidth := aliceLink.Bandwidth()
+	highFee := (MaxAnchorsCommitFeeRate * 2).FeePerKWeight()
+
+	for _, commitType := range commitmentTypes {
+		aliceLink.cfg.CommitmentType = commitType
+
+		var (
+			expectedReserve lnwire.MilliSatoshi
+			expectedFee     = highFee
+		)
+		if commitType.HasAnchors() {
+			expectedReserve = lnwire.NewMSatFromSatoshis(
+				2 * AnchorSize,
+			)
+			expectedFee = MaxAnchorsCommitFeeRate.FeePerKWeight()
+		}
+
+		bandwidth := aliceLink.Bandwidth()
+		if bandwidth != legacyBandwidth-expectedReserve {
+			t.Fatalf("%v: expected bandwidth %v, got %v",
+				commitType, legacyBandwidth-expectedReserve,
+				bandwidth)
+		}
+
+		fee := aliceLink.commitFeeCeiling(highFee)
+		if fee != expectedFee {
+			t.Fatalf("%v: expected fee rate %v, got %v",
+				commitType, expectedFee, fee)
+		}
+
+		// A fee rate below the ceiling is never capped.
+		lowFee := lnwallet.SatPerKWeight(253)
+		if fee := aliceLink.commitFeeCeiling(lowFee); fee != lowFee {
+			t.Fatalf("%v: expected fee rate %v, got %v",
+				commitType, lowFee, fee)
+		}
+	}
+}
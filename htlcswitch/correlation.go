@@ -0,0 +1,14 @@
+package htlcswitch
+
+import (
+	"sync/atomic"
+)
+
+// newCorrelationID returns a new ID used to correlate the decisions and trace
+// of a single forward. The IDs are unique across the lifetime of the switch,
+// and as they're seeded with the time the switch was created, are unlikely
+// to repeat across restarts. They're only used locally, and are never sent
+// to other nodes.
+func (s *Switch) newCorrelationID() uint64 {
+	return atomic.AddUint64(&s.nextCorrelationID, 1)
+}
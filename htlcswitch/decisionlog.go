@@ -0,0 +1,256 @@
+package htlcswitch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// DefaultDecisionHistorySize is the default number of forwarding
+	// decisions retained by the switch for debugging.
+	DefaultDecisionHistorySize = 1000
+
+	// MaxDecisionHistorySize is the maximum number of forwarding decisions
+	// retained by the switch, bounding the memory used by the history.
+	MaxDecisionHistorySize = 100000
+
+	// decisionHashPrefixLen is the number of leading bytes of the payment
+	// hash retained within each recorded decision.
+	decisionHashPrefixLen = 4
+)
+
+// DecisionOutcome is the outcome of a forwarding decision.
+type DecisionOutcome uint8
+
+const (
+	// DecisionForwarded denotes that the HTLC was handed to an outgoing
+	// link.
+	DecisionForwarded DecisionOutcome = iota
+
+	// DecisionRejected denotes that the HTLC was failed back by the
+	// incoming link, as it didn't satisfy our forwarding policy.
+	DecisionRejected
+
+	// DecisionFailed denotes that the HTLC was failed back by the switch,
+	// e.g. as the next hop is unknown or lacks bandwidth.
+	DecisionFailed
+)
+
+// String returns a human readable representation of the DecisionOutcome.
+func (o DecisionOutcome) String() string {
+	switch o {
+	case DecisionForwarded:
+		return "Forwarded"
+
+	case DecisionRejected:
+		return "Rejected"
+
+	case DecisionFailed:
+		return "Failed"
+
+	default:
+		return "unknown outcome"
+	}
+}
+
+// DecisionRecord describes a single forwarding decision, such that the
+// reasoning behind recent decisions can be reconstructed when debugging
+// failing forwards.
+type DecisionRecord struct {
+	// PaymentHashPrefix holds the leading bytes of the payment hash of the
+	// HTLC. The full payment hash isn't retained, in order to avoid
+	// leaking it to anyone inspecting the history.
+	PaymentHashPrefix [decisionHashPrefixLen]byte
+
+	// CorrelationID is the locally assigned ID of the forward, shared by
+	// all decisions made for it and its CircuitTrace.
+	CorrelationID uint64
+
+	// IncomingChanID is the channel over which the HTLC was received.
+	IncomingChanID lnwire.ShortChannelID
+
+	// OutgoingChanID is the channel requested by the onion for the next
+	// hop.
+	OutgoingChanID lnwire.ShortChannelID
+
+	// IncomingAmount is the amount of the incoming HTLC.
+	IncomingAmount lnwire.MilliSatoshi
+
+	// OutgoingAmount is the amount to be forwarded to the next hop.
+	OutgoingAmount lnwire.MilliSatoshi
+
+	// IncomingExpiry is the expiry height of the incoming HTLC.
+	IncomingExpiry uint32
+
+	// OutgoingExpiry is the expiry height of the outgoing HTLC.
+	OutgoingExpiry uint32
+
+	// RequiredFee is the fee our forwarding policy required the HTLC to
+	// pay at the time of the decision.
+	RequiredFee lnwire.MilliSatoshi
+
+	// Outcome is the outcome of the decision.
+	Outcome DecisionOutcome
+
+	// FailureCode is the code of the failure the HTLC was failed back
+	// with. It's zero if the HTLC was forwarded.
+	FailureCode lnwire.FailCode
+
+	// Timestamp is the time the decision was made.
+	Timestamp time.Time
+}
+
+// decisionLog retains the most recent forwarding decisions within a ring
+// buffer of fixed size.
+type decisionLog struct {
+	sync.Mutex
+
+	records []DecisionRecord
+
+	// next is the index within records the next decision is written to.
+	next int
+
+	// full is true once the buffer has wrapped around.
+	full bool
+}
+
+// newDecisionLog creates a new decisionLog retaining up to size decisions. If
+// size is zero, then DefaultDecisionHistorySize is used.
+func newDecisionLog(size int) *decisionLog {
+	switch {
+	case size <= 0:
+		size = DefaultDecisionHistorySize
+	case size > MaxDecisionHistorySize:
+		size = MaxDecisionHistorySize
+	}
+
+	return &decisionLog{
+		records: make([]DecisionRecord, size),
+	}
+}
+
+// record adds a new decision, overwriting the oldest one if the buffer is
+// full.
+func (d *decisionLog) record(record DecisionRecord) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.records[d.next] = record
+	d.next++
+	if d.next == len(d.records) {
+		d.next = 0
+		d.full = true
+	}
+}
+
+// recent returns a copy of up to the n most recent decisions, ordered from
+// oldest to newest.
+func (d *decisionLog) recent(n int) []DecisionRecord {
+	d.Lock()
+	defer d.Unlock()
+
+	size := d.next
+	if d.full {
+		size = len(d.records)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	records := make([]DecisionRecord, n)
+	start := d.next - n
+	if start < 0 {
+		start += len(d.records)
+	}
+	for i := range records {
+		records[i] = d.records[(start+i)%len(d.records)]
+	}
+
+	return records
+}
+
+// newDecisionRecord creates the record of a decision made for the passed
+// forward. A nil failure denotes that the HTLC was forwarded.
+func newDecisionRecord(packet *htlcPacket, outcome DecisionOutcome,
+	failure lnwire.FailureMessage) DecisionRecord {
+
+	record := DecisionRecord{
+		CorrelationID:  packet.correlationID,
+		IncomingChanID: packet.incomingChanID,
+		OutgoingChanID: packet.outgoingChanID,
+		IncomingAmount: packet.incomingAmount,
+		IncomingExpiry: packet.incomingTimeout,
+		RequiredFee:    packet.requiredFee,
+		Outcome:        outcome,
+		Timestamp:      time.Now(),
+	}
+	if htlc, ok := packet.htlc.(*lnwire.UpdateAddHTLC); ok {
+		copy(record.PaymentHashPrefix[:], htlc.PaymentHash[:])
+		record.OutgoingAmount = htlc.Amount
+		record.OutgoingExpiry = htlc.Expiry
+	}
+	if failure != nil {
+		record.FailureCode = failure.Code()
+	}
+
+	return record
+}
+
+// recordDecision records the switch's decision for the passed forward. A nil
+// failure denotes that the HTLC was forwarded.
+func (s *Switch) recordDecision(packet *htlcPacket,
+	failure lnwire.FailureMessage) {
+
+	outcome := DecisionForwarded
+	if failure != nil {
+		outcome = DecisionFailed
+	}
+
+	s.decisions.record(newDecisionRecord(packet, outcome, failure))
+}
+
+// RecentDecisions returns up to the n most recent forwarding decisions made
+// by the switch and its links, ordered from oldest to newest. If n is zero,
+// then all retained decisions are returned. Locally initiated payments aren't
+// recorded.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) RecentDecisions(n int) []DecisionRecord {
+	return s.decisions.recent(n)
+}
+
+// rejectForward fails back the passed incoming HTLC, which doesn't satisfy
+// our forwarding policy, recording the rejection within the switch's decision
+// history.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) rejectForward(pd *lnwallet.PaymentDescriptor,
+	fwdInfo ForwardingInfo, correlationID uint64,
+	failure lnwire.FailureMessage, e ErrorEncrypter) {
+
+	policy := l.currentPolicy()
+	packet := &htlcPacket{
+		correlationID:   correlationID,
+		incomingChanID:  l.ShortChanID(),
+		incomingHTLCID:  pd.HtlcIndex,
+		outgoingChanID:  fwdInfo.NextHop,
+		incomingAmount:  pd.Amount,
+		incomingTimeout: pd.Timeout,
+		requiredFee: policy.expectedForwardFee(
+			fwdInfo.AmountToForward,
+		),
+		htlc: &lnwire.UpdateAddHTLC{
+			PaymentHash: pd.RHash,
+			Amount:      fwdInfo.AmountToForward,
+			Expiry:      fwdInfo.OutgoingCTLV,
+		},
+	}
+	l.cfg.Switch.decisions.record(
+		newDecisionRecord(packet, DecisionRejected, failure),
+	)
+
+	l.sendHTLCError(pd.HtlcIndex, failure, e)
+}
@@ -0,0 +1,164 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestDecisionLogWrap tests that the decision log retains only the most
+// recent decisions once full, returning them from oldest to newest.
+func TestDecisionLogWrap(t *testing.T) {
+	t.Parallel()
+
+	decisions := newDecisionLog(3)
+	if len(decisions.recent(0)) != 0 {
+		t.Fatalf("expected no decisions")
+	}
+
+	for i := 1; i <= 5; i++ {
+		decisions.record(DecisionRecord{
+			IncomingAmount: lnwire.MilliSatoshi(i),
+		})
+	}
+
+	assertRecent := func(n int, expected ...lnwire.MilliSatoshi) {
+		records := decisions.recent(n)
+		if len(records) != len(expected) {
+			t.Fatalf("expected %v decisions, got %v",
+				len(expected), len(records))
+		}
+		for i, record := range records {
+			if record.IncomingAmount != expected[i] {
+				t.Fatalf("expected decision %v to be for %v, "+
+					"got %v", i, expected[i],
+					record.IncomingAmount)
+			}
+		}
+	}
+
+	assertRecent(0, 3, 4, 5)
+	assertRecent(10, 3, 4, 5)
+	assertRecent(2, 4, 5)
+
+	if len(newDecisionLog(0).records) != DefaultDecisionHistorySize {
+		t.Fatalf("expected default history size")
+	}
+	if len(newDecisionLog(MaxDecisionHistorySize+1).records) !=
+		MaxDecisionHistorySize {
+
+		t.Fatalf("expected history size to be capped")
+	}
+}
+
+// TestSwitchRecentDecisions tests that the switch records both the forwards
+// it hands to an outgoing link and those it fails back.
+func TestSwitchRecentDecisions(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	// The first HTLC is forwarded to Bob, while the second requests an
+	// unknown channel, and is failed back.
+	s.forward(&htlcPacket{
+		incomingChanID:  aliceChanID,
+		incomingHTLCID:  0,
+		outgoingChanID:  bobChanID,
+		incomingAmount:  1100,
+		incomingTimeout: 150,
+		requiredFee:     100,
+		obfuscator:      newMockObfuscator(),
+		htlc: &lnwire.UpdateAddHTLC{
+			PaymentHash: rhash,
+			Amount:      1000,
+			Expiry:      100,
+		},
+	})
+	select {
+	case <-bobChannelLink.packets:
+	case <-time.After(time.Second):
+		t.Fatalf("htlc wasn't forwarded")
+	}
+
+	unknownChanID := lnwire.NewShortChanIDFromInt(99)
+	s.forward(&htlcPacket{
+		incomingChanID: aliceChanID,
+		incomingHTLCID: 1,
+		outgoingChanID: unknownChanID,
+		obfuscator:     newMockObfuscator(),
+		htlc: &lnwire.UpdateAddHTLC{
+			PaymentHash: rhash,
+			Amount:      1000,
+		},
+	})
+	select {
+	case <-aliceChannelLink.packets:
+	case <-time.After(time.Second):
+		t.Fatalf("htlc wasn't failed back")
+	}
+
+	records := s.RecentDecisions(0)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 decisions, got %v", len(records))
+	}
+
+	forwarded := records[0]
+	if forwarded.Outcome != DecisionForwarded ||
+		forwarded.IncomingChanID != aliceChanID ||
+		forwarded.OutgoingChanID != bobChanID ||
+		forwarded.IncomingAmount != 1100 ||
+		forwarded.OutgoingAmount != 1000 ||
+		forwarded.IncomingExpiry != 150 ||
+		forwarded.OutgoingExpiry != 100 ||
+		forwarded.RequiredFee != 100 ||
+		forwarded.FailureCode != 0 {
+
+		t.Fatalf("unexpected forwarded decision: %v", forwarded)
+	}
+
+	// Only a prefix of the payment hash is retained.
+	var prefix [decisionHashPrefixLen]byte
+	copy(prefix[:], rhash[:])
+	if forwarded.PaymentHashPrefix != prefix {
+		t.Fatalf("expected payment hash prefix %x, got %x", prefix,
+			forwarded.PaymentHashPrefix)
+	}
+
+	failed := records[1]
+	if failed.Outcome != DecisionFailed ||
+		failed.OutgoingChanID != unknownChanID ||
+		failed.FailureCode != lnwire.CodeUnknownNextPeer {
+
+		t.Fatalf("unexpected failed decision: %v", failed)
+	}
+
+	records = s.RecentDecisions(1)
+	if len(records) != 1 || records[0].Outcome != DecisionFailed {
+		t.Fatalf("expected only the latest decision, got %v", records)
+	}
+}
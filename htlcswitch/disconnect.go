@@ -0,0 +1,216 @@
+package htlcswitch
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecentDisconnects is the maximum number of disconnect events the
+	// switch will retain for each peer. Once exceeded, the oldest events
+	// are discarded.
+	maxRecentDisconnects = 20
+
+	// disconnectRetention is the duration for which the disconnect events
+	// of a peer are retained after its most recent disconnect. Peers which
+	// haven't disconnected since are evicted, such that the history of
+	// peers we no longer connect to doesn't accumulate.
+	disconnectRetention = 24 * time.Hour
+)
+
+// DisconnectReason is an enum which describes the cause of a disconnection
+// from a remote peer.
+type DisconnectReason uint8
+
+const (
+	// DisconnectUnknown indicates that the cause of the disconnection
+	// isn't known.
+	DisconnectUnknown DisconnectReason = iota
+
+	// DisconnectProtocolViolation indicates that the remote peer sent us
+	// a message which violates the protocol.
+	DisconnectProtocolViolation
+
+	// DisconnectCommitmentError indicates that an irrecoverable error was
+	// encountered while updating the commitment state of a channel with
+	// the remote peer.
+	DisconnectCommitmentError
+
+	// DisconnectReadFailure indicates that we were unable to read the
+	// next message from the connection.
+	DisconnectReadFailure
+
+	// DisconnectWriteTimeout indicates that writing a message to the
+	// connection timed out.
+	DisconnectWriteTimeout
+
+	// DisconnectIdleTimeout indicates that the remote peer didn't send us
+	// any messages within the idle timeout.
+	DisconnectIdleTimeout
+
+	// DisconnectStartupFailure indicates that we were unable to start the
+	// peer after the connection was established.
+	DisconnectStartupFailure
+
+	// DisconnectOperatorRequested indicates that the operator explicitly
+	// requested to disconnect from the remote peer.
+	DisconnectOperatorRequested
+
+	// DisconnectShutdown indicates that the peer was disconnected as we
+	// are shutting down, or as the peer itself is exiting.
+	DisconnectShutdown
+
+	// DisconnectQuiescenceTimeout indicates that we were unable to
+	// quiesce a channel with the remote peer before the deadline.
+	DisconnectQuiescenceTimeout
+
+	// DisconnectLinkUnresponsive indicates that a link with the remote
+	// peer stopped processing requests, and was restarted by the switch's
+	// link watchdog.
+	DisconnectLinkUnresponsive
+
+	// DisconnectWriteFailure indicates that we were unable to write a
+	// message to the connection for a reason other than a timeout, e.g.
+	// as it was closed by the remote peer.
+	DisconnectWriteFailure
+)
+
+// String returns a human readable string describing the DisconnectReason.
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectUnknown:
+		return "Unknown"
+
+	case DisconnectProtocolViolation:
+		return "ProtocolViolation"
+
+	case DisconnectCommitmentError:
+		return "CommitmentError"
+
+	case DisconnectReadFailure:
+		return "ReadFailure"
+
+	case DisconnectWriteTimeout:
+		return "WriteTimeout"
+
+	case DisconnectIdleTimeout:
+		return "IdleTimeout"
+
+	case DisconnectStartupFailure:
+		return "StartupFailure"
+
+	case DisconnectOperatorRequested:
+		return "OperatorRequested"
+
+	case DisconnectShutdown:
+		return "Shutdown"
+
+	case DisconnectQuiescenceTimeout:
+		return "QuiescenceTimeout"
+
+	case DisconnectLinkUnresponsive:
+		return "LinkUnresponsive"
+
+	case DisconnectWriteFailure:
+		return "WriteFailure"
+
+	default:
+		return "unknown reason"
+	}
+}
+
+// WriteErrorReason returns the DisconnectReason describing the passed error
+// encountered while writing a message to a peer's connection.
+func WriteErrorReason(err error) DisconnectReason {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return DisconnectWriteTimeout
+	}
+
+	return DisconnectWriteFailure
+}
+
+// DisconnectEvent records a single disconnection from a remote peer.
+type DisconnectEvent struct {
+	// Reason is the cause of the disconnection.
+	Reason DisconnectReason
+
+	// Err is the error which triggered the disconnection, if any.
+	Err error
+
+	// Timestamp is the time at which the disconnection occurred.
+	Timestamp time.Time
+}
+
+// disconnectRegistry retains the most recent disconnect events for each peer.
+type disconnectRegistry struct {
+	sync.Mutex
+
+	events map[[33]byte][]DisconnectEvent
+}
+
+// newDisconnectRegistry creates a new, empty disconnectRegistry.
+func newDisconnectRegistry() *disconnectRegistry {
+	return &disconnectRegistry{
+		events: make(map[[33]byte][]DisconnectEvent),
+	}
+}
+
+// record adds a new disconnect event for the target peer, discarding the
+// oldest event if the peer's history is full. Any peers whose most recent
+// disconnect is at least disconnectRetention old are evicted.
+func (d *disconnectRegistry) record(peer [33]byte, event DisconnectEvent) {
+	d.Lock()
+	defer d.Unlock()
+
+	cutoff := event.Timestamp.Add(-disconnectRetention)
+	for otherPeer, events := range d.events {
+		if !events[len(events)-1].Timestamp.After(cutoff) {
+			delete(d.events, otherPeer)
+		}
+	}
+
+	events := append(d.events[peer], event)
+	if len(events) > maxRecentDisconnects {
+		events = events[len(events)-maxRecentDisconnects:]
+	}
+	d.events[peer] = events
+}
+
+// recent returns a copy of the retained disconnect events for the target
+// peer, ordered from oldest to newest.
+func (d *disconnectRegistry) recent(peer [33]byte) []DisconnectEvent {
+	d.Lock()
+	defer d.Unlock()
+
+	events := make([]DisconnectEvent, len(d.events[peer]))
+	copy(events, d.events[peer])
+
+	return events
+}
+
+// RecordDisconnect records that we've disconnected from the peer identified
+// by the passed public key, along with the cause of the disconnection.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) RecordDisconnect(peer [33]byte, reason DisconnectReason,
+	err error) {
+
+	log.Debugf("Recording disconnect from peer %x, reason=%v: %v",
+		peer[:], reason, err)
+
+	s.disconnects.record(peer, DisconnectEvent{
+		Reason:    reason,
+		Err:       err,
+		Timestamp: time.Now(),
+	})
+}
+
+// RecentDisconnects returns the most recent disconnect events recorded for
+// the peer identified by the passed public key, ordered from oldest to
+// newest.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) RecentDisconnects(peer [33]byte) []DisconnectEvent {
+	return s.disconnects.recent(peer)
+}
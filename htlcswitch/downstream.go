@@ -0,0 +1,127 @@
+package htlcswitch
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaxDownstreamFailLatency is the default time the downstream peer of a
+// link may take to resolve an HTLC we've forwarded to it before the link is
+// considered unhealthy. It's chosen to be generous, while remaining well
+// below the time-lock delta of any forward.
+const DefaultMaxDownstreamFailLatency = 10 * time.Minute
+
+// downstreamLatency tracks the forwarded HTLC's sent over a link until they're
+// resolved by the downstream peer, recording the time each of them took.
+type downstreamLatency struct {
+	sync.Mutex
+
+	// pending maps the index of each unresolved forwarded HTLC within
+	// our update log to the time it was sent.
+	pending map[uint64]time.Time
+
+	latency *waitTimeRecorder
+
+	// consecutiveFailures is the number of forwarded HTLC's the
+	// downstream peer has failed since it last settled one, or since the
+	// counter was last reset.
+	consecutiveFailures int
+}
+
+// newDownstreamLatency creates a new downstreamLatency tracker.
+func newDownstreamLatency() *downstreamLatency {
+	return &downstreamLatency{
+		pending: make(map[uint64]time.Time),
+		latency: newWaitTimeRecorder(),
+	}
+}
+
+// sent records that the forwarded HTLC with the passed index has just been
+// sent to the downstream peer.
+func (d *downstreamLatency) sent(htlcIndex uint64) {
+	d.Lock()
+	d.pending[htlcIndex] = time.Now()
+	d.Unlock()
+}
+
+// resolved records that the downstream peer has settled or failed the HTLC
+// with the passed index. HTLC's which aren't tracked, such as locally
+// initiated ones, are ignored, in which case false is returned.
+func (d *downstreamLatency) resolved(htlcIndex uint64, settled bool) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	sentAt, ok := d.pending[htlcIndex]
+	if !ok {
+		return false
+	}
+	delete(d.pending, htlcIndex)
+
+	d.latency.Observe(time.Since(sentAt))
+
+	if settled {
+		d.consecutiveFailures = 0
+	} else {
+		d.consecutiveFailures++
+	}
+
+	return true
+}
+
+// overdue returns true if any forwarded HTLC has been waiting on the
+// downstream peer for longer than the passed latency.
+func (d *downstreamLatency) overdue(maxLatency time.Duration) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, sentAt := range d.pending {
+		if time.Since(sentAt) > maxLatency {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxDownstreamFailLatency returns the time the downstream peer may take to
+// resolve an HTLC forwarded over the link before the link is considered
+// unhealthy.
+func (l *channelLink) maxDownstreamFailLatency() time.Duration {
+	if l.cfg.MaxDownstreamFailLatency == 0 {
+		return DefaultMaxDownstreamFailLatency
+	}
+
+	return l.cfg.MaxDownstreamFailLatency
+}
+
+// isDownstreamSlow returns true if the downstream peer has yet to resolve an
+// HTLC forwarded over the link within the maximum downstream failure latency.
+// While this is the case, the link trips its circuit breaker, refusing to
+// forward any new HTLC's. As the incoming leg of a committed forward can't be
+// safely failed until the outgoing one is resolved, the overdue HTLC itself
+// is left to the downstream peer, or to the chain.
+func (l *channelLink) isDownstreamSlow() bool {
+	return l.fwdLatency.overdue(l.maxDownstreamFailLatency())
+}
+
+// ConsecutiveFailures returns the number of HTLC's forwarded over the link
+// which the downstream peer has failed since it last settled one, or since
+// the counter was last reset by ResetFailureCounter.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) ConsecutiveFailures() int {
+	l.fwdLatency.Lock()
+	defer l.fwdLatency.Unlock()
+
+	return l.fwdLatency.consecutiveFailures
+}
+
+// ResetFailureCounter resets the link's count of consecutive downstream
+// failures, e.g. once an operator has addressed the cause of the failures.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) ResetFailureCounter() {
+	l.fwdLatency.Lock()
+	l.fwdLatency.consecutiveFailures = 0
+	l.fwdLatency.Unlock()
+}
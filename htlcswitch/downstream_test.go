@@ -0,0 +1,213 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkSlowDownstream tests that a link stops forwarding once the
+// remote peer fails to resolve a forwarded HTLC within the maximum downstream
+// failure latency, and that it resumes once the HTLC is resolved, recording
+// the latency.
+func TestChannelLinkSlowDownstream(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	const maxLatency = 200 * time.Millisecond
+	aliceLink := link.(*channelLink)
+	aliceLink.cfg.MaxDownstreamFailLatency = maxLatency
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// We'll forward an HTLC arriving over another channel to Bob.
+	var mockBlob [lnwire.OnionPacketSize]byte
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{
+		incomingChanID: lnwire.NewShortChanIDFromInt(1),
+		htlc:           htlc,
+		amount:         htlcAmt,
+	})
+
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive add")
+	}
+	addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	bobIndex, err := bobChannel.ReceiveHTLC(addHtlc)
+	if err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+	if err := updateState(batchTick, aliceLink, bobChannel, true); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	if reason := aliceLink.IneligibleReason(); reason != IneligibleNone {
+		t.Fatalf("expected link to be eligible, got %v", reason)
+	}
+
+	// Once Bob has held on to the HTLC for longer than the maximum
+	// latency, the link should no longer be eligible to forward.
+	time.Sleep(maxLatency + 100*time.Millisecond)
+	reason := aliceLink.IneligibleReason()
+	if reason != IneligibleSlowDownstream {
+		t.Fatalf("expected link to be ineligible due to slow "+
+			"downstream, got %v", reason)
+	}
+
+	// After Bob fails the HTLC, the link should be eligible again, with
+	// the latency of the HTLC recorded.
+	if err := bobChannel.FailHTLC(bobIndex, []byte("nop")); err != nil {
+		t.Fatalf("unable to fail htlc: %v", err)
+	}
+	aliceLink.HandleChannelUpdate(&lnwire.UpdateFailHTLC{
+		ID:     addHtlc.ID,
+		Reason: []byte("nop"),
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	if reason := aliceLink.IneligibleReason(); reason != IneligibleNone {
+		t.Fatalf("expected link to be eligible, got %v", reason)
+	}
+
+	latency := aliceLink.StatsDetail().DownstreamLatency
+	if latency.Count != 1 {
+		t.Fatalf("expected 1 latency sample, got %v", latency.Count)
+	}
+	if latency.Max < maxLatency {
+		t.Fatalf("expected latency of at least %v, got %v", maxLatency,
+			latency.Max)
+	}
+}
+
+// TestChannelLinkConsecutiveFailures tests that a link counts the forwarded
+// HTLC's failed by the downstream peer, and that the counter is reset both
+// manually and once the downstream peer settles an HTLC.
+func TestChannelLinkConsecutiveFailures(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// forward forwards an HTLC arriving over another channel to Bob, and
+	// locks it in.
+	var mockBlob [lnwire.OnionPacketSize]byte
+	forward := func(htlcID uint64) (*lnwire.UpdateAddHTLC, uint64,
+		[32]byte) {
+
+		htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+		invoice, htlc, err := generatePayment(
+			htlcAmt, htlcAmt, 5, mockBlob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		aliceLink.HandleSwitchPacket(&htlcPacket{
+			incomingChanID: lnwire.NewShortChanIDFromInt(1),
+			incomingHTLCID: htlcID,
+			htlc:           htlc,
+			amount:         htlcAmt,
+		})
+
+		var msg lnwire.Message
+		select {
+		case msg = <-aliceMsgs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive add")
+		}
+		addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+		if !ok {
+			t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+		}
+		bobIndex, err := bobChannel.ReceiveHTLC(addHtlc)
+		if err != nil {
+			t.Fatalf("bob failed receiving htlc: %v", err)
+		}
+		err = updateState(batchTick, aliceLink, bobChannel, true)
+		if err != nil {
+			t.Fatalf("unable to update state: %v", err)
+		}
+
+		return addHtlc, bobIndex, invoice.Terms.PaymentPreimage
+	}
+
+	// fail has Bob fail the HTLC, and locks in the failure.
+	fail := func(addHtlc *lnwire.UpdateAddHTLC, bobIndex uint64) {
+		err := bobChannel.FailHTLC(bobIndex, []byte("nop"))
+		if err != nil {
+			t.Fatalf("unable to fail htlc: %v", err)
+		}
+		aliceLink.HandleChannelUpdate(&lnwire.UpdateFailHTLC{
+			ID:     addHtlc.ID,
+			Reason: []byte("nop"),
+		})
+		err = updateState(batchTick, aliceLink, bobChannel, false)
+		if err != nil {
+			t.Fatalf("unable to update state: %v", err)
+		}
+	}
+
+	assertFailures := func(expected int) {
+		failures := aliceLink.ConsecutiveFailures()
+		if failures != expected {
+			t.Fatalf("expected %v consecutive failures, got %v",
+				expected, failures)
+		}
+	}
+
+	assertFailures(0)
+
+	addHtlc, bobIndex, _ := forward(0)
+	fail(addHtlc, bobIndex)
+	addHtlc, bobIndex, _ = forward(1)
+	fail(addHtlc, bobIndex)
+	assertFailures(2)
+
+	// Resetting the counter manually should clear it.
+	aliceLink.ResetFailureCounter()
+	assertFailures(0)
+
+	addHtlc, bobIndex, _ = forward(2)
+	fail(addHtlc, bobIndex)
+	assertFailures(1)
+
+	// Once Bob settles an HTLC, the counter should be cleared as well.
+	addHtlc, bobIndex, preimage := forward(3)
+	if err := bobChannel.SettleHTLC(preimage, bobIndex); err != nil {
+		t.Fatalf("unable to settle htlc: %v", err)
+	}
+	aliceLink.HandleChannelUpdate(&lnwire.UpdateFulfillHTLC{
+		ID:              addHtlc.ID,
+		PaymentPreimage: preimage,
+	})
+	err = updateState(batchTick, aliceLink, bobChannel, false)
+	if err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+	assertFailures(0)
+}
@@ -0,0 +1,64 @@
+package htlcswitch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// DrainTimeoutError is returned by DrainLink if the link's HTLC's weren't all
+// resolved before the passed context expired.
+type DrainTimeoutError struct {
+	// ChanID is the channel ID of the link being drained.
+	ChanID lnwire.ChannelID
+
+	// Unresolved is the number of HTLC's which remained on the link's
+	// commitment transactions as of its last state transition.
+	Unresolved int
+}
+
+// Error returns a human readable description of the DrainTimeoutError.
+func (e *DrainTimeoutError) Error() string {
+	return fmt.Sprintf("timed out draining link %v with %v htlcs "+
+		"unresolved", e.ChanID, e.Unresolved)
+}
+
+// DrainLink stops the target link from accepting any new HTLC's, in either
+// direction, and waits until all of its outstanding HTLC's have been
+// resolved, while all other links keep forwarding as normal. Locally
+// initiated HTLC's still waiting within the link's overflow queue are failed
+// back. Once drained, nil is returned, and the channel is ready for
+// maintenance or a cooperative close. If the passed context expires first,
+// then a DrainTimeoutError reporting the number of unresolved HTLC's is
+// returned, and the link remains draining.
+//
+// NOTE: A drained link doesn't resume accepting HTLC's until it's restarted,
+// e.g. once the connection to its peer is re-established.
+func (s *Switch) DrainLink(ctx context.Context, chanID lnwire.ChannelID) error {
+	link, err := s.GetLink(chanID)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Draining ChannelPoint(%v)", link)
+
+	err = link.ClearHTLCsForClose(ctx)
+	if err == ErrLinkClearTimeout {
+		drainErr := &DrainTimeoutError{
+			ChanID:     chanID,
+			Unresolved: link.StatsSnapshot().PendingHTLCs,
+		}
+		log.Warnf("Unable to drain ChannelPoint(%v): %v", link,
+			drainErr)
+
+		return drainErr
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("ChannelPoint(%v) drained", link)
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package htlcswitch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchDrainLink tests that draining one of Bob's links stops it from
+// carrying new HTLC's until its outstanding HTLC's are resolved, while his
+// other link keeps carrying payments as normal.
+func TestSwitchDrainLink(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	// We'll send a payment from Alice to Carol through Bob, which Carol
+	// parks with a hold resolver, leaving an HTLC outstanding on Bob's
+	// link with Carol.
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatalf("unable to generate route: %v", err)
+	}
+	invoice, htlc, err := generatePayment(amount, htlcAmt, totalTimelock,
+		blob)
+	if err != nil {
+		t.Fatalf("unable to generate payment: %v", err)
+	}
+	preimage := invoice.Terms.PaymentPreimage
+	resolutions := n.carolServer.htlcSwitch.RegisterHoldResolver(
+		fastsha256.Sum256(preimage[:]),
+	)
+
+	paymentErr := make(chan error, 1)
+	go func() {
+		_, err := n.aliceServer.htlcSwitch.SendHTLC(
+			n.bobServer.PubKey(), htlc, newMockDeobfuscator(),
+		)
+		paymentErr <- err
+	}()
+
+	var resolution *HoldResolution
+	select {
+	case resolution = <-resolutions:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("hold resolution not received")
+	}
+
+	// As the HTLC remains parked, draining Bob's link with Carol should
+	// time out, reporting it as unresolved.
+	bobSwitch := n.bobServer.htlcSwitch
+	drainedChan := n.secondBobChannelLink.ChanID()
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 500*time.Millisecond,
+	)
+	err = bobSwitch.DrainLink(ctx, drainedChan)
+	cancel()
+	drainErr, ok := err.(*DrainTimeoutError)
+	if !ok {
+		t.Fatalf("expected DrainTimeoutError, got %v", err)
+	}
+	if drainErr.Unresolved != 1 {
+		t.Fatalf("expected 1 unresolved htlc, got %v",
+			drainErr.Unresolved)
+	}
+
+	// New payments over the draining link should fail.
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(5 * time.Second)
+	if err == nil {
+		t.Fatalf("payment over draining link should fail")
+	}
+
+	// Bob's link with Alice isn't affected, so Alice should still be
+	// able to pay Bob.
+	bobAmt, bobTimelock, bobHops := generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink)
+	_, err = n.makePayment(n.aliceServer, n.bobServer,
+		n.bobServer.PubKey(), bobHops, amount, bobAmt,
+		bobTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to pay bob: %v", err)
+	}
+
+	// Once Carol settles the parked HTLC, the link should be drained.
+	if err := resolution.Settle(preimage); err != nil {
+		t.Fatalf("unable to settle held htlc: %v", err)
+	}
+	select {
+	case err := <-paymentErr:
+		if err != nil {
+			t.Fatalf("unable to send payment: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("payment wasn't settled")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := bobSwitch.DrainLink(ctx, drainedChan); err != nil {
+		t.Fatalf("unable to drain link: %v", err)
+	}
+
+	// Draining an unknown link should fail.
+	if err := bobSwitch.DrainLink(ctx, lnwire.ChannelID{}); err == nil {
+		t.Fatalf("expected error draining unknown link")
+	}
+}
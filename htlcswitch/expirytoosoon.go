@@ -0,0 +1,184 @@
+package htlcswitch
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultExpiryTooSoonWindow is the default window within which
+	// forwards failed for expiry_too_soon are counted towards the
+	// ExpiryTooSoonPolicy's threshold.
+	DefaultExpiryTooSoonWindow = time.Hour
+
+	// DefaultTimeLockDeltaStep is the default number of blocks a
+	// recommended TimeLockDelta exceeds the current one by.
+	DefaultTimeLockDeltaStep = 6
+
+	// DefaultMaxTimeLockDelta is the default bound of the TimeLockDelta
+	// recommended due to forwards failed for expiry_too_soon.
+	DefaultMaxTimeLockDelta = 144
+)
+
+// ExpiryTooSoonPolicy governs how a link reacts to repeatedly failing
+// forwards as their expiry is too soon. Frequent such failures suggest that
+// the advertised TimeLockDelta is too aggressive, so once Threshold of them
+// occur within a Window, a larger TimeLockDelta is recommended. The zero value
+// only counts the failures.
+type ExpiryTooSoonPolicy struct {
+	// Threshold is the number of forwards failed for expiry_too_soon
+	// within the Window past which a larger TimeLockDelta is recommended.
+	// The failures counted towards a recommendation don't count towards
+	// the next one. If zero, then no recommendation is made.
+	Threshold uint32
+
+	// Window is the duration within which failures are counted towards
+	// the Threshold. If zero, then DefaultExpiryTooSoonWindow is used.
+	Window time.Duration
+
+	// Step is the number of blocks the recommended TimeLockDelta exceeds
+	// the current one by. If zero, then DefaultTimeLockDeltaStep is
+	// used.
+	Step uint32
+
+	// MaxTimeLockDelta bounds the recommended TimeLockDelta. If zero,
+	// then DefaultMaxTimeLockDelta is used.
+	MaxTimeLockDelta uint32
+
+	// AutoAdjust, if true, applies the recommended TimeLockDelta to the
+	// link, and gossips it via GossipPolicyUpdate, such that the
+	// gossiper's rate limit applies to it. Otherwise, the recommendation
+	// is only logged, and exposed by the link's StatsSnapshot.
+	AutoAdjust bool
+}
+
+// window returns the window within which failures are counted.
+func (p *ExpiryTooSoonPolicy) window() time.Duration {
+	if p.Window == 0 {
+		return DefaultExpiryTooSoonWindow
+	}
+
+	return p.Window
+}
+
+// recommend returns the TimeLockDelta recommended in place of the passed one,
+// or zero if it can't be raised any further.
+func (p *ExpiryTooSoonPolicy) recommend(current uint32) uint32 {
+	step := p.Step
+	if step == 0 {
+		step = DefaultTimeLockDeltaStep
+	}
+	maxDelta := p.MaxTimeLockDelta
+	if maxDelta == 0 {
+		maxDelta = DefaultMaxTimeLockDelta
+	}
+
+	if current >= maxDelta {
+		return 0
+	}
+	if current+step > maxDelta {
+		return maxDelta
+	}
+
+	return current + step
+}
+
+// expiryTooSoonTracker tracks the forwards a link has failed for
+// expiry_too_soon, along with the TimeLockDelta last recommended as a result.
+type expiryTooSoonTracker struct {
+	sync.Mutex
+
+	// recent are the times of the failures within the current window
+	// which haven't yet counted towards a recommendation.
+	recent []time.Time
+
+	total       uint64
+	recommended uint32
+}
+
+// failed records a failure, returning the number of failures within the
+// passed window which haven't yet counted towards a recommendation.
+func (e *expiryTooSoonTracker) failed(window time.Duration) int {
+	e.Lock()
+	defer e.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-window)
+	recent := e.recent[:0]
+	for _, t := range e.recent {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	e.recent = append(recent, now)
+	e.total++
+
+	return len(e.recent)
+}
+
+// recommend records a recommended TimeLockDelta, such that the failures
+// leading to it don't count towards the next recommendation.
+func (e *expiryTooSoonTracker) recommend(delta uint32) {
+	e.Lock()
+	e.recent = nil
+	e.recommended = delta
+	e.Unlock()
+}
+
+// snapshot returns the total number of failures, and the TimeLockDelta last
+// recommended, or zero if none has been.
+func (e *expiryTooSoonTracker) snapshot() (uint64, uint32) {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.total, e.recommended
+}
+
+// handleExpiryTooSoon records that a forward has been failed as its expiry is
+// too soon, and recommends, or applies, a larger TimeLockDelta once the
+// failures exceed the link's ExpiryTooSoonPolicy.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleExpiryTooSoon() {
+	policy := &l.cfg.ExpiryTooSoon
+	numRecent := l.expiryTooSoon.failed(policy.window())
+	if policy.Threshold == 0 || numRecent < int(policy.Threshold) {
+		return
+	}
+
+	current := l.cfg.FwrdingPolicy.TimeLockDelta
+	delta := policy.recommend(current)
+	if delta == 0 {
+		log.Warnf("ChannelLink(%v) failed %v forwards for "+
+			"expiry_too_soon within %v, but time_lock_delta=%v "+
+			"can't be raised any further", l, numRecent,
+			policy.window(), current)
+		return
+	}
+	l.expiryTooSoon.recommend(delta)
+
+	if !policy.AutoAdjust {
+		log.Warnf("ChannelLink(%v) failed %v forwards for "+
+			"expiry_too_soon within %v, consider raising "+
+			"time_lock_delta from %v to %v", l, numRecent,
+			policy.window(), current, delta)
+		return
+	}
+
+	log.Infof("ChannelLink(%v) failed %v forwards for expiry_too_soon "+
+		"within %v, raising time_lock_delta from %v to %v", l,
+		numRecent, policy.window(), current, delta)
+
+	err := l.handlePolicyUpdate(DirectionalPolicy{
+		Outbound: ForwardingPolicy{TimeLockDelta: delta},
+	})
+	if err != nil {
+		log.Errorf("ChannelLink(%v) unable to raise time_lock_delta: "+
+			"%v", l, err)
+		return
+	}
+
+	if l.cfg.GossipPolicyUpdate != nil {
+		l.gossipPolicy(l.cfg.FwrdingPolicy)
+	}
+}
@@ -0,0 +1,127 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkExpiryTooSoonPolicy tests that once Bob has failed enough
+// forwards for expiry_too_soon, a larger TimeLockDelta is recommended, and
+// only applied and gossiped if auto-adjusting, up to the configured bound.
+func TestChannelLinkExpiryTooSoonPolicy(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	const startingHeight = 200
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, startingHeight)
+
+	bobLink := n.firstBobChannelLink
+	delta := bobLink.cfg.FwrdingPolicy.TimeLockDelta
+	bobLink.cfg.ExpiryTooSoon = ExpiryTooSoonPolicy{
+		Threshold:        2,
+		Step:             6,
+		MaxTimeLockDelta: delta + 8,
+	}
+	gossiped := make(chan ForwardingPolicy, 2)
+	bobLink.cfg.GossipPolicyUpdate = func(policy ForwardingPolicy) error {
+		gossiped <- policy
+		return nil
+	}
+
+	if err := n.start(); err != nil {
+		t.Fatalf("unable to start three hop network: %v", err)
+	}
+	defer n.stop()
+
+	// payTooSoon sends a payment from Alice to Carol whose time lock is
+	// too early to be forwarded by Bob.
+	amount := lnwire.NewMSatFromSatoshis(10000)
+	payTooSoon := func() {
+		htlcAmt, totalTimelock, hops := generateHops(amount,
+			startingHeight-10, bobLink, n.carolChannelLink)
+
+		_, err := n.makePayment(n.aliceServer, n.carolServer,
+			n.bobServer.PubKey(), hops, amount, htlcAmt,
+			totalTimelock).Wait(30 * time.Second)
+		ferr, ok := err.(*ForwardingError)
+		if !ok {
+			t.Fatalf("expected a ForwardingError, got: %T: %v",
+				err, err)
+		}
+		_, ok = ferr.FailureMessage.(*lnwire.FailExpiryTooSoon)
+		if !ok {
+			t.Fatalf("expected FailExpiryTooSoon, got %T",
+				ferr.FailureMessage)
+		}
+	}
+
+	assertStats := func(failures uint64, recommended, applied uint32) {
+		snapshot := bobLink.StatsSnapshot()
+		if snapshot.ExpiryTooSoonFailures != failures {
+			t.Fatalf("expected %v failures, got %v", failures,
+				snapshot.ExpiryTooSoonFailures)
+		}
+		if snapshot.RecommendedTimeLockDelta != recommended {
+			t.Fatalf("expected recommended delta %v, got %v",
+				recommended, snapshot.RecommendedTimeLockDelta)
+		}
+		policy := bobLink.CurrentForwardingPolicy().Outbound
+		if policy.TimeLockDelta != applied {
+			t.Fatalf("expected applied delta %v, got %v", applied,
+				policy.TimeLockDelta)
+		}
+	}
+
+	assertGossiped := func(expected uint32) {
+		select {
+		case policy := <-gossiped:
+			if policy.TimeLockDelta != expected {
+				t.Fatalf("expected gossiped delta %v, got %v",
+					expected, policy.TimeLockDelta)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy wasn't gossiped")
+		}
+	}
+
+	// A single failure doesn't reach the threshold.
+	payTooSoon()
+	assertStats(1, 0, delta)
+
+	// The second one does, but by default the larger delta is only
+	// recommended.
+	payTooSoon()
+	assertStats(2, delta+6, delta)
+	select {
+	case <-gossiped:
+		t.Fatalf("policy gossiped while recommending only")
+	default:
+	}
+
+	// Once auto-adjusting, the failures leading to the recommendation
+	// don't count towards the next one, so it takes another two failures
+	// for the delta to be raised.
+	bobLink.cfg.ExpiryTooSoon.AutoAdjust = true
+	payTooSoon()
+	assertStats(3, delta+6, delta)
+	payTooSoon()
+	assertStats(4, delta+6, delta+6)
+	assertGossiped(delta + 6)
+
+	// The delta is raised no further than the configured bound.
+	payTooSoon()
+	payTooSoon()
+	assertStats(6, delta+8, delta+8)
+	assertGossiped(delta + 8)
+}
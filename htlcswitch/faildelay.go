@@ -0,0 +1,127 @@
+package htlcswitch
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// MaxFailureDelay is the upper bound on the delay applied to privacy sensitive
+// failures by a FailureDelayPolicy. The delayed HTLC's remain on our
+// commitment in the meantime, so the delay must stay small.
+const MaxFailureDelay = 2 * time.Second
+
+// FailureDelayPolicy governs the randomized delay applied before failing back
+// HTLC's for which we're the exit hop with a privacy sensitive failure, such as
+// an unknown payment hash or an incorrect amount. Without it, a prober is able
+// to distinguish between these failures by timing how long the invoice lookup
+// takes. Settles and all other failures are never delayed. The zero value
+// disables the delay.
+type FailureDelayPolicy struct {
+	// MinDelay is the lower bound of the delay.
+	MinDelay time.Duration
+
+	// MaxDelay is the upper bound of the delay. Values above
+	// MaxFailureDelay are capped. If zero, then failures aren't delayed.
+	MaxDelay time.Duration
+}
+
+// delay returns a random delay within the range of the policy.
+func (p *FailureDelayPolicy) delay() time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay > MaxFailureDelay {
+		maxDelay = MaxFailureDelay
+	}
+
+	return randomDelay(p.MinDelay, maxDelay)
+}
+
+// randomDelay returns a delay drawn uniformly from the range [minDelay,
+// maxDelay]. If the range is empty, then maxDelay is returned.
+func randomDelay(minDelay, maxDelay time.Duration) time.Duration {
+	if minDelay >= maxDelay {
+		return maxDelay
+	}
+
+	// A predictable delay would be of little use against a prober, so
+	// we'll draw it from crypto/rand.
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return maxDelay
+	}
+	jitter := binary.BigEndian.Uint64(b[:]) % uint64(maxDelay-minDelay+1)
+
+	return minDelay + time.Duration(jitter)
+}
+
+// isPrivacySensitive returns true if the passed failure of an HTLC for which
+// we're the exit hop could reveal whether we know the payment hash.
+func isPrivacySensitive(failure lnwire.FailureMessage) bool {
+	switch failure.Code() {
+	case lnwire.CodeUnknownPaymentHash,
+		lnwire.CodeIncorrectPaymentAmount,
+		lnwire.CodeFinalIncorrectCltvExpiry:
+
+		return true
+
+	default:
+		return false
+	}
+}
+
+// delayedFailure is sent to a channel link once the delay of a privacy
+// sensitive failure has elapsed, in order to fail back the HTLC.
+type delayedFailure struct {
+	htlcIndex  uint64
+	failure    lnwire.FailureMessage
+	obfuscator ErrorEncrypter
+}
+
+// failExitHop fails back the passed HTLC for which we're the exit hop. If the
+// failure is privacy sensitive, then it's sent once the delay drawn from the
+// link's FailureDelayPolicy has elapsed. True is returned if the failure was
+// sent right away, and so must be covered by a new commitment.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) failExitHop(htlcIndex uint64,
+	failure lnwire.FailureMessage, obfuscator ErrorEncrypter) bool {
+
+	policy := &l.cfg.FailureDelay
+	if policy.MaxDelay == 0 || !isPrivacySensitive(failure) {
+		l.sendHTLCError(htlcIndex, failure, obfuscator)
+		return true
+	}
+
+	delay := policy.delay()
+	log.Debugf("ChannelLink(%v) delaying failure of htlc=%v by %v", l,
+		htlcIndex, delay)
+
+	req := &delayedFailure{
+		htlcIndex:  htlcIndex,
+		failure:    failure,
+		obfuscator: obfuscator,
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case l.linkControl <- req:
+		case <-l.quit:
+		}
+	})
+
+	return false
+}
+
+// handleDelayedFailure fails back an HTLC once its failure delay has elapsed,
+// and signs a commitment covering the failure.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleDelayedFailure(req *delayedFailure) {
+	l.sendHTLCError(req.htlcIndex, req.failure, req.obfuscator)
+
+	if err := l.updateCommitTx(); err != nil {
+		l.fail(DisconnectCommitmentError,
+			"unable to update commitment: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestFailureDelayPolicyBounds ensures that the delays drawn from a failure
+// delay policy fall within its range, and never exceed MaxFailureDelay.
+func TestFailureDelayPolicyBounds(t *testing.T) {
+	t.Parallel()
+
+	policy := FailureDelayPolicy{
+		MinDelay: 100 * time.Millisecond,
+		MaxDelay: 200 * time.Millisecond,
+	}
+	for i := 0; i < 100; i++ {
+		delay := policy.delay()
+		if delay < policy.MinDelay || delay > policy.MaxDelay {
+			t.Fatalf("delay %v outside of [%v, %v]", delay,
+				policy.MinDelay, policy.MaxDelay)
+		}
+	}
+
+	policy = FailureDelayPolicy{
+		MinDelay: time.Hour,
+		MaxDelay: 2 * time.Hour,
+	}
+	if delay := policy.delay(); delay != MaxFailureDelay {
+		t.Fatalf("expected delay to be capped to %v, got %v",
+			MaxFailureDelay, delay)
+	}
+
+	// Only failures which could reveal whether we know the payment hash
+	// should be delayed.
+	if !isPrivacySensitive(lnwire.FailUnknownPaymentHash{}) {
+		t.Fatalf("unknown payment hash should be privacy sensitive")
+	}
+	if isPrivacySensitive(lnwire.NewTemporaryChannelFailure(nil)) {
+		t.Fatalf("temporary channel failure shouldn't be privacy " +
+			"sensitive")
+	}
+}
+
+// TestChannelLinkFailureDelay tests that a link with a failure delay policy
+// delays failing back an HTLC paying to an unknown payment hash, while an HTLC
+// paying to a known invoice is settled right away.
+func TestChannelLinkFailureDelay(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	// The minimum delay exceeds the time it takes to lock in the HTLC's,
+	// so an undelayed failure would arrive well before it.
+	aliceLink := link.(*channelLink)
+	aliceLink.cfg.FailureDelay = FailureDelayPolicy{
+		MinDelay: time.Second,
+		MaxDelay: 1500 * time.Millisecond,
+	}
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// Bob sends Alice two HTLC's, only the first of which pays to an
+	// invoice known to Alice.
+	for i := 0; i < 2; i++ {
+		htlcAmt, totalTimelock, hops := generateHops(
+			lnwire.NewMSatFromSatoshis(10000), testStartingHeight,
+			aliceLink,
+		)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to gen route: %v", err)
+		}
+		invoice, htlc, err := generatePayment(
+			htlcAmt, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+
+		if i == 0 {
+			registry := aliceLink.cfg.Registry.(*mockInvoiceRegistry)
+			if err := registry.AddInvoice(*invoice); err != nil {
+				t.Fatalf("unable to add invoice: %v", err)
+			}
+		}
+
+		htlc.ID = uint64(i)
+		if _, err := bobChannel.AddHTLC(htlc); err != nil {
+			t.Fatalf("unable to add htlc: %v", err)
+		}
+		aliceLink.HandleChannelUpdate(htlc)
+	}
+
+	start := time.Now()
+	if err := updateState(batchTick, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// The settle shouldn't be delayed, so it should be the first message
+	// sent once the HTLC's have been locked in.
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(time.Second):
+		t.Fatalf("did not receive settle")
+	}
+	settle, ok := msg.(*lnwire.UpdateFulfillHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateFulfillHTLC, got %T", msg)
+	}
+	if settle.ID != 0 {
+		t.Fatalf("expected htlc 0 to be settled, got %v", settle.ID)
+	}
+	err = bobChannel.ReceiveHTLCSettle(settle.PaymentPreimage, settle.ID)
+	if err != nil {
+		t.Fatalf("unable to receive settle: %v", err)
+	}
+	if err := handleStateUpdate(aliceLink, bobChannel); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// The failure should only arrive once the delay has elapsed.
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive fail")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("failure wasn't delayed: %v", elapsed)
+	}
+	fail, ok := msg.(*lnwire.UpdateFailHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateFailHTLC, got %T", msg)
+	}
+	if fail.ID != 1 {
+		t.Fatalf("expected htlc 1 to be failed, got %v", fail.ID)
+	}
+	if err := bobChannel.ReceiveFailHTLC(fail.ID, fail.Reason); err != nil {
+		t.Fatalf("unable to receive fail: %v", err)
+	}
+	if err := handleStateUpdate(aliceLink, bobChannel); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	if htlcs := bobChannel.ActiveHtlcs(); len(htlcs) != 0 {
+		t.Fatalf("expected all htlcs to be resolved, %v remain",
+			len(htlcs))
+	}
+}
@@ -0,0 +1,97 @@
+package htlcswitch
+
+import (
+	"sync/atomic"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// usableForLocalDispatch returns true if the passed link may carry a locally
+// initiated payment. Links which are still warming up are only used if the
+// switch has been configured to bypass the warm-up period for local
+// payments.
+func (s *Switch) usableForLocalDispatch(link ChannelLink) bool {
+	reason := link.IneligibleReason()
+	bypass := reason == IneligibleWarmingUp &&
+		s.cfg.LocalPaymentsBypassWarmUp
+
+	return usableForLocalPayment(reason) || bypass
+}
+
+// failoverLocalPayment attempts to resend a local payment which failed at the
+// link it was dispatched over, before ever leaving our node, over another
+// channel to the same peer. As the payment never reached the first hop, the
+// rest of its route is unaffected. True is returned if the payment was
+// resent. Payments pinned to an outgoing channel aren't failed over, nor are
+// those which have used up the switch's LocalPaymentFailovers.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) failoverLocalPayment(paymentID uint64,
+	payment *pendingPayment) bool {
+
+	if payment.pinned || payment.failovers >= s.cfg.LocalPaymentFailovers {
+		return false
+	}
+
+	links, err := s.getLinks(payment.destNode)
+	if err != nil {
+		return false
+	}
+
+	for _, link := range links {
+		if _, ok := payment.tried[link.ShortChanID()]; ok {
+			continue
+		}
+		if !s.usableForLocalDispatch(link) ||
+			link.LocalBandwidth() < payment.amount {
+
+			continue
+		}
+
+		payment.failovers++
+		payment.tried[link.ShortChanID()] = struct{}{}
+		atomic.AddUint64(&s.localFailovers, 1)
+
+		log.Infof("Failing over payment(%x) to %v after it failed "+
+			"at the first hop", payment.paymentHash[:],
+			link.ShortChanID())
+
+		htlc := payment.htlc
+		link.HandleSwitchPacket(&htlcPacket{
+			incomingHTLCID: paymentID,
+			outgoingChanID: link.ShortChanID(),
+			destNode:       payment.destNode,
+			amount:         htlc.Amount,
+			htlc:           &htlc,
+		})
+
+		return true
+	}
+
+	return false
+}
+
+// LocalPaymentFailovers returns the number of times a local payment which
+// failed at its first hop has been resent over another channel to the same
+// peer.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) LocalPaymentFailovers() uint64 {
+	return atomic.LoadUint64(&s.localFailovers)
+}
+
+// recordDispatch notes the link a local payment has been dispatched over,
+// along with the HTLC itself, such that the payment can be failed over to
+// another link should it fail at the first hop.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (p *pendingPayment) recordDispatch(packet *htlcPacket,
+	htlc *lnwire.UpdateAddHTLC, link ChannelLink, pinned bool) {
+
+	p.tried = map[lnwire.ShortChannelID]struct{}{
+		link.ShortChanID(): {},
+	}
+	p.destNode = packet.destNode
+	p.htlc = *htlc
+	p.pinned = pinned
+}
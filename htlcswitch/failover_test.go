@@ -0,0 +1,181 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestSwitchLocalPaymentFailover tests that a local payment which fails at the
+// link it was dispatched over is resent over another channel to the same
+// peer, while failures from further along the route are returned as is.
+func TestSwitchLocalPaymentFailover(t *testing.T) {
+	t.Parallel()
+
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{
+		LocalPaymentFailovers: 1,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	links := []*mockChannelLink{
+		newMockChannelLink(s, chanID1, aliceChanID, bobPeer, true),
+		newMockChannelLink(s, chanID2, bobChanID, bobPeer, true),
+	}
+	for _, link := range links {
+		if err := s.AddLink(link); err != nil {
+			t.Fatalf("unable to add link: %v", err)
+		}
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	type result struct {
+		preimage [sha256.Size]byte
+		err      error
+	}
+
+	// send dispatches a payment to Bob, returning the channel its result
+	// is delivered over.
+	send := func() chan result {
+		results := make(chan result, 1)
+		htlc := &lnwire.UpdateAddHTLC{
+			PaymentHash: rhash,
+			Amount:      1000,
+		}
+		go func() {
+			p, err := s.SendHTLC(
+				bobPeer.PubKey(), htlc, newMockDeobfuscator(),
+			)
+			results <- result{p, err}
+		}()
+
+		return results
+	}
+
+	// dispatched waits for the payment to be dispatched over one of the
+	// links, returning the link and its packet.
+	dispatched := func() (*mockChannelLink, *htlcPacket) {
+		select {
+		case pkt := <-links[0].packets:
+			return links[0], pkt
+		case pkt := <-links[1].packets:
+			return links[1], pkt
+		case <-time.After(time.Second):
+			t.Fatalf("payment wasn't dispatched")
+		}
+		return nil, nil
+	}
+
+	// fail fails back the payment from the passed link, either as though
+	// the link itself failed it, or as though it was failed further along
+	// the route.
+	fail := func(pkt *htlcPacket, local bool) {
+		var b bytes.Buffer
+		failure := lnwire.NewTemporaryChannelFailure(nil)
+		if err := lnwire.EncodeFailure(&b, failure, 0); err != nil {
+			t.Fatalf("unable to encode failure: %v", err)
+		}
+
+		err := s.forward(&htlcPacket{
+			incomingHTLCID: pkt.incomingHTLCID,
+			isRouted:       true,
+			localFailure:   local,
+			htlc: &lnwire.UpdateFailHTLC{
+				Reason: b.Bytes(),
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to fail payment: %v", err)
+		}
+	}
+
+	// The primary channel fails the payment before it reaches Bob, so it
+	// should be failed over to the backup channel.
+	results := send()
+	primary, pkt := dispatched()
+	fail(pkt, true)
+
+	backup, pkt := dispatched()
+	if backup == primary {
+		t.Fatalf("payment failed over to the channel it failed at")
+	}
+	if pkt.outgoingChanID != backup.ShortChanID() {
+		t.Fatalf("expected payment over %v, got %v",
+			backup.ShortChanID(), pkt.outgoingChanID)
+	}
+
+	// Once Bob settles the payment over the backup channel, the payment
+	// should succeed.
+	err := s.forward(&htlcPacket{
+		outgoingChanID: backup.ShortChanID(),
+		outgoingHTLCID: backup.htlcID - 1,
+		htlc: &lnwire.UpdateFulfillHTLC{
+			PaymentPreimage: preimage,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to settle payment: %v", err)
+	}
+
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatalf("payment failed: %v", res.err)
+		}
+		if res.preimage != preimage {
+			t.Fatalf("wrong preimage: expected %x, got %x",
+				preimage, res.preimage)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("payment result not received")
+	}
+
+	if failovers := s.LocalPaymentFailovers(); failovers != 1 {
+		t.Fatalf("expected 1 failover, got %v", failovers)
+	}
+
+	// A failure from further along the route calls for a new route, so
+	// it should be returned rather than failed over.
+	results = send()
+	_, pkt = dispatched()
+	fail(pkt, false)
+
+	select {
+	case res := <-results:
+		if res.err == nil {
+			t.Fatalf("payment should have failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("payment result not received")
+	}
+
+	// Once the failover budget of a payment has been used up, its local
+	// failure should be returned as well.
+	results = send()
+	_, pkt = dispatched()
+	fail(pkt, true)
+	_, pkt = dispatched()
+	fail(pkt, true)
+
+	select {
+	case res := <-results:
+		if res.err == nil {
+			t.Fatalf("payment should have failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("payment result not received")
+	}
+
+	if failovers := s.LocalPaymentFailovers(); failovers != 2 {
+		t.Fatalf("expected 2 failovers, got %v", failovers)
+	}
+}
@@ -134,3 +134,24 @@ func (s *SphinxErrorDecrypter) DecryptError(reason lnwire.OpaqueReason) (*Forwar
 // A compile time check to ensure ErrorDecrypter implements the Deobfuscator
 // interface.
 var _ ErrorDecrypter = (*SphinxErrorDecrypter)(nil)
+
+// HTLCError is returned when processing a single HTLC within a batch fails in
+// a manner which doesn't compromise the integrity of the commitment state. In
+// this case, only the offending HTLC is failed back to its sender using the
+// enclosed failure message, while the remainder of the batch is processed as
+// normal. Any other error encountered while processing a batch is considered
+// fatal to the channel's state machine.
+type HTLCError struct {
+	// HtlcIndex is the index of the offending HTLC.
+	HtlcIndex uint64
+
+	// Err is the underlying cause of the failure.
+	Err error
+
+	lnwire.FailureMessage
+}
+
+// Error implements the built-in error interface.
+func (e *HTLCError) Error() string {
+	return fmt.Sprintf("htlc(%v) failed: %v", e.HtlcIndex, e.Err)
+}
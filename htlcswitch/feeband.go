@@ -0,0 +1,113 @@
+package htlcswitch
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+)
+
+// DefaultFeeUpdateTolerance is the default percentage by which the commitment
+// fee rate may differ from our own estimate before it's adjusted.
+const DefaultFeeUpdateTolerance uint32 = 10
+
+// feeUpdateVerdict describes how a fee rate proposed by the remote party
+// compares to our own estimate of the network fee rate.
+type feeUpdateVerdict uint8
+
+const (
+	// feeUpdateWithinBand indicates that the proposed fee rate is within
+	// the tolerance band, and should be accepted.
+	feeUpdateWithinBand feeUpdateVerdict = iota
+
+	// feeUpdateOutsideBand indicates that the proposed fee rate is
+	// outside the tolerance band, but within the hard limit. It's
+	// accepted, but recorded as a disagreement.
+	feeUpdateOutsideBand
+
+	// feeUpdateBeyondLimit indicates that the proposed fee rate exceeds
+	// the hard limit, and should be treated as fatal.
+	feeUpdateBeyondLimit
+)
+
+// classifyFeeUpdate compares the proposed fee rate to our estimate, given the
+// tolerance band and hard limit as percentages of the estimate. A proposal
+// differing from the estimate by exactly the tolerance is outside the band,
+// mirroring when we'd propose a new fee rate ourselves, while one differing
+// by exactly the hard limit is still accepted. A zero hard limit disables the
+// limit.
+func classifyFeeUpdate(proposed, estimate lnwallet.SatPerKWeight, tolerance,
+	hardLimit uint32) feeUpdateVerdict {
+
+	diff := proposed - estimate
+	if diff < 0 {
+		diff = -diff
+	}
+
+	switch {
+	case hardLimit != 0 &&
+		diff*100 > estimate*lnwallet.SatPerKWeight(hardLimit):
+
+		return feeUpdateBeyondLimit
+
+	case diff != 0 &&
+		diff*100 >= estimate*lnwallet.SatPerKWeight(tolerance):
+
+		return feeUpdateOutsideBand
+
+	default:
+		return feeUpdateWithinBand
+	}
+}
+
+// feeUpdateTolerance returns the configured fee update tolerance band, or
+// DefaultFeeUpdateTolerance if none is set.
+func (l *channelLink) feeUpdateTolerance() uint32 {
+	if l.cfg.FeeUpdateTolerance == 0 {
+		return DefaultFeeUpdateTolerance
+	}
+
+	return l.cfg.FeeUpdateTolerance
+}
+
+// checkRemoteFeeUpdate compares a fee rate proposed by the remote party to our
+// own estimate of the network fee rate, returning an error if it's beyond the
+// configured hard limit. Only the channel initiator may send update_fee, so
+// we can't counter a proposal we disagree with, but as long as it's within
+// the hard limit we'd rather accept it than force close over it. If no hard
+// limit is set, or our estimate is unavailable, the proposal is accepted.
+func (l *channelLink) checkRemoteFeeUpdate(fee lnwallet.SatPerKWeight) error {
+	if l.cfg.FeeUpdateHardLimit == 0 {
+		return nil
+	}
+
+	estimate, err := l.sampleNetworkFee()
+	if err != nil {
+		log.Warnf("ChannelLink(%v): unable to sample network fee, "+
+			"accepting fee update of %v sat/kw: %v", l, int64(fee),
+			err)
+		return nil
+	}
+	estimate = l.commitFeeCeiling(estimate)
+
+	verdict := classifyFeeUpdate(
+		fee, estimate, l.feeUpdateTolerance(),
+		l.cfg.FeeUpdateHardLimit,
+	)
+	switch verdict {
+	case feeUpdateBeyondLimit:
+		return fmt.Errorf("fee rate of %v sat/kw differs from our "+
+			"estimate of %v sat/kw by more than %v%%", int64(fee),
+			int64(estimate), l.cfg.FeeUpdateHardLimit)
+
+	case feeUpdateOutsideBand:
+		atomic.AddUint64(&l.feeDisagreements, 1)
+
+		log.Warnf("ChannelLink(%v): accepting fee update of %v "+
+			"sat/kw outside of %v%% band around our estimate of "+
+			"%v sat/kw", l, int64(fee), l.feeUpdateTolerance(),
+			int64(estimate))
+	}
+
+	return nil
+}
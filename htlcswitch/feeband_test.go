@@ -0,0 +1,145 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkFeeUpdateBand tests that fee rates proposed by the remote
+// party are accepted within the tolerance band, accepted but recorded as a
+// disagreement outside of it, and rejected beyond the hard limit.
+func TestChannelLinkFeeUpdateBand(t *testing.T) {
+	t.Parallel()
+
+	const (
+		estimate  lnwallet.SatPerKWeight = 1000
+		tolerance uint32                 = 10
+		hardLimit uint32                 = 50
+	)
+
+	tests := []struct {
+		name      string
+		proposed  lnwallet.SatPerKWeight
+		hardLimit uint32
+		verdict   feeUpdateVerdict
+	}{
+		{
+			name:      "matches estimate",
+			proposed:  1000,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateWithinBand,
+		},
+		{
+			name:      "just inside upper band edge",
+			proposed:  1099,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateWithinBand,
+		},
+		{
+			name:      "at upper band edge",
+			proposed:  1100,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateOutsideBand,
+		},
+		{
+			name:      "just inside lower band edge",
+			proposed:  901,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateWithinBand,
+		},
+		{
+			name:      "at lower band edge",
+			proposed:  900,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateOutsideBand,
+		},
+		{
+			name:      "at upper hard limit",
+			proposed:  1500,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateOutsideBand,
+		},
+		{
+			name:      "beyond upper hard limit",
+			proposed:  1501,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateBeyondLimit,
+		},
+		{
+			name:      "at lower hard limit",
+			proposed:  500,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateOutsideBand,
+		},
+		{
+			name:      "beyond lower hard limit",
+			proposed:  499,
+			hardLimit: hardLimit,
+			verdict:   feeUpdateBeyondLimit,
+		},
+		{
+			name:      "no hard limit",
+			proposed:  10000,
+			hardLimit: 0,
+			verdict:   feeUpdateOutsideBand,
+		},
+	}
+
+	for _, test := range tests {
+		verdict := classifyFeeUpdate(
+			test.proposed, estimate, tolerance, test.hardLimit,
+		)
+		if verdict != test.verdict {
+			t.Fatalf("%v: expected verdict %v, got %v", test.name,
+				test.verdict, verdict)
+		}
+	}
+
+	// Now we'll check the proposals against a live link, whose estimator
+	// returns a fee rate of 1000 sat/kw.
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceLink.cfg.FeeEstimator = &lnwallet.StaticFeeEstimator{
+		FeeRate: 4,
+	}
+
+	// Without a hard limit, proposals aren't checked at all.
+	if err := aliceLink.checkRemoteFeeUpdate(100000); err != nil {
+		t.Fatalf("unchecked fee update rejected: %v", err)
+	}
+
+	aliceLink.cfg.FeeUpdateTolerance = tolerance
+	aliceLink.cfg.FeeUpdateHardLimit = hardLimit
+
+	if err := aliceLink.checkRemoteFeeUpdate(1050); err != nil {
+		t.Fatalf("fee update within band rejected: %v", err)
+	}
+	if err := aliceLink.checkRemoteFeeUpdate(1200); err != nil {
+		t.Fatalf("fee update within hard limit rejected: %v", err)
+	}
+	if err := aliceLink.checkRemoteFeeUpdate(2000); err == nil {
+		t.Fatalf("fee update beyond hard limit accepted")
+	}
+
+	state := aliceLink.CommitmentState()
+	if state.FeeUpdateTolerance != tolerance {
+		t.Fatalf("expected tolerance %v, got %v", tolerance,
+			state.FeeUpdateTolerance)
+	}
+	if state.FeeUpdateHardLimit != hardLimit {
+		t.Fatalf("expected hard limit %v, got %v", hardLimit,
+			state.FeeUpdateHardLimit)
+	}
+	if state.FeeDisagreements != 1 {
+		t.Fatalf("expected 1 disagreement, got %v",
+			state.FeeDisagreements)
+	}
+}
@@ -0,0 +1,87 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// feeInsufficientUpdate returns the channel update to attach to the
+// FeeInsufficient failure of an HTLC forwarding the passed amount, which was
+// rejected under the passed policy. The latest announced update may not yet
+// reflect the fee the policy requires, e.g. if the policy has since been
+// raised, or includes an inbound fee, in which case a sender retrying with the
+// announced fee would be rejected once again. So if a signer is configured,
+// then the announced update is adjusted to the fee the policy requires, and
+// re-signed.
+func (l *channelLink) feeInsufficientUpdate(policy DirectionalPolicy,
+	amt lnwire.MilliSatoshi) (*lnwire.ChannelUpdate, error) {
+
+	update, err := l.cfg.GetLastChannelUpdate()
+	if err != nil {
+		return nil, err
+	}
+	if l.cfg.SignChannelUpdate == nil {
+		return update, nil
+	}
+
+	baseFee, feeRate := policy.feeSchedule(amt)
+	if update.BaseFee == baseFee && update.FeeRate == feeRate {
+		return update, nil
+	}
+
+	// We'll copy the update rather than modifying it in place, as it may
+	// be shared.
+	adjusted := *update
+	adjusted.BaseFee = baseFee
+	adjusted.FeeRate = feeRate
+	if err := l.resignChannelUpdate(update, &adjusted); err != nil {
+		return nil, err
+	}
+
+	log.Debugf("ChannelLink(%v) attaching channel_update with "+
+		"base_fee=%v, fee_rate=%v in place of announced base_fee=%v, "+
+		"fee_rate=%v", l, baseFee, feeRate, update.BaseFee,
+		update.FeeRate)
+
+	return &adjusted, nil
+}
+
+// DisabledChannelUpdate returns the link's latest channel update with the
+// disabled flag set. If a signer is configured, then the update is re-signed,
+// otherwise it's returned with the signature of the announced update.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) DisabledChannelUpdate() (*lnwire.ChannelUpdate, error) {
+	update, err := l.cfg.GetLastChannelUpdate()
+	if err != nil {
+		return nil, err
+	}
+
+	disabled := *update
+	disabled.Flags |= lnwire.ChanUpdateDisabled
+	if l.cfg.SignChannelUpdate == nil || disabled.Flags == update.Flags {
+		return &disabled, nil
+	}
+
+	if err := l.resignChannelUpdate(update, &disabled); err != nil {
+		return nil, err
+	}
+
+	return &disabled, nil
+}
+
+// resignChannelUpdate signs the passed adjusted copy of the announced update,
+// bumping its timestamp past that of the announced update such that the
+// sender prefers it.
+func (l *channelLink) resignChannelUpdate(update,
+	adjusted *lnwire.ChannelUpdate) error {
+
+	timestamp := uint32(time.Now().Unix())
+	if timestamp <= update.Timestamp {
+		timestamp = update.Timestamp + 1
+	}
+	adjusted.Timestamp = timestamp
+
+	return l.cfg.SignChannelUpdate(adjusted)
+}
@@ -0,0 +1,101 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkFeeInsufficientUpdate tests that once Bob raises his fee,
+// the channel update attached to the FeeInsufficient failure of a payment
+// paying his prior fee reflects the raised fee, such that a retry paying the
+// fee it implies succeeds.
+func TestChannelLinkFeeInsufficientUpdate(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	// The announced update returned by the mock doesn't carry any fee, so
+	// without re-signing, the sender would learn nothing from it.
+	var numSigned int
+	n.firstBobChannelLink.cfg.SignChannelUpdate = func(
+		update *lnwire.ChannelUpdate) error {
+
+		numSigned++
+		update.Signature = wireSig
+		return nil
+	}
+
+	// We'll build the route under Bob's current fee, then have Bob raise
+	// his fee before the payment is sent.
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+
+	raised := n.globalPolicy
+	raised.BaseFee *= 10
+	raised.FeeRate += 1000
+	n.firstBobChannelLink.UpdateForwardingPolicy(raised)
+
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err == nil {
+		t.Fatalf("payment should've been rejected")
+	}
+
+	ferr, ok := err.(*ForwardingError)
+	if !ok {
+		t.Fatalf("expected a ForwardingError, instead got: %T", err)
+	}
+	failure, ok := ferr.FailureMessage.(*lnwire.FailFeeInsufficient)
+	if !ok {
+		t.Fatalf("expected FailFeeInsufficient instead got: %v", err)
+	}
+	if numSigned != 1 {
+		t.Fatalf("expected update to be signed once, got %v",
+			numSigned)
+	}
+
+	update := failure.Update
+	if update.BaseFee != uint32(raised.BaseFee) ||
+		update.FeeRate != uint32(raised.FeeRate) {
+
+		t.Fatalf("expected update with base_fee=%v, fee_rate=%v, "+
+			"got base_fee=%v, fee_rate=%v", raised.BaseFee,
+			raised.FeeRate, update.BaseFee, update.FeeRate)
+	}
+
+	// The fee implied by the update should be the one Bob now requires.
+	impliedFee := lnwire.MilliSatoshi(update.BaseFee) +
+		amount*lnwire.MilliSatoshi(update.FeeRate)/1000000
+	if impliedFee != ExpectedFee(raised, amount) {
+		t.Fatalf("expected implied fee of %v, got %v",
+			ExpectedFee(raised, amount), impliedFee)
+	}
+
+	// Retrying under the raised fee should now succeed.
+	htlcAmt, totalTimelock, hops = generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package htlcswitch
+
+import (
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// allLinksCmd is a message sent to the switch in order to retrieve a snapshot
+// of all active links.
+type allLinksCmd struct {
+	done chan []ChannelLink
+}
+
+// FirstHopCandidates returns the short channel IDs of all links which are
+// currently able to carry a locally initiated HTLC of the passed amount: the
+// link must be eligible to forward, exempting the minimum channel age and
+// maintenance windows which don't apply to local payments, have a free slot
+// within its commitment transaction, and have at least amt of bandwidth
+// available. As a link's bandwidth excludes its channel reserve and any HTLC's
+// within its overflow queue, both are accounted for, while its soft reserve
+// may be used for local HTLC's. The candidates are sorted by available
+// bandwidth in descending order.
+//
+// NOTE: The returned set is only a snapshot, and links may no longer be able
+// to carry the HTLC by the time it's sent. If the switch is shutting down,
+// then no candidates are returned.
+func (s *Switch) FirstHopCandidates(
+	amt lnwire.MilliSatoshi) []lnwire.ShortChannelID {
+
+	links, err := s.fetchAllLinks()
+	if err != nil {
+		return nil
+	}
+
+	type candidate struct {
+		chanID    lnwire.ShortChannelID
+		bandwidth lnwire.MilliSatoshi
+	}
+
+	candidates := make([]candidate, 0, len(links))
+	for _, link := range links {
+		if !usableForLocalPayment(link.IneligibleReason()) ||
+			!link.HasFreeSlot() {
+
+			continue
+		}
+
+		bandwidth := link.LocalBandwidth()
+		if bandwidth < amt {
+			continue
+		}
+
+		candidates = append(candidates, candidate{
+			chanID:    link.ShortChanID(),
+			bandwidth: bandwidth,
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].bandwidth > candidates[j].bandwidth
+	})
+
+	chanIDs := make([]lnwire.ShortChannelID, 0, len(candidates))
+	for _, c := range candidates {
+		chanIDs = append(chanIDs, c.chanID)
+	}
+
+	return chanIDs
+}
+
+// fetchAllLinks returns a snapshot of all active links. The set of links is
+// retrieved from within the htlcForwarder goroutine, so callers should query
+// each of them from their own goroutine, such that forwarding isn't held up.
+func (s *Switch) fetchAllLinks() ([]ChannelLink, error) {
+	cmd := &allLinksCmd{
+		done: make(chan []ChannelLink, 1),
+	}
+
+	select {
+	case s.linkControl <- cmd:
+	case <-s.quit:
+		return nil, errors.New("htlc switch was stopped")
+	}
+
+	select {
+	case links := <-cmd.done:
+		return links, nil
+	case <-s.quit:
+		return nil, errors.New("htlc switch was stopped")
+	}
+}
+
+// allLinks returns a snapshot of all active links.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) allLinks() []ChannelLink {
+	links := make([]ChannelLink, 0, len(s.linkIndex))
+	for _, link := range s.linkIndex {
+		links = append(links, link)
+	}
+
+	return links
+}
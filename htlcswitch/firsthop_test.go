@@ -0,0 +1,77 @@
+package htlcswitch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/wire"
+)
+
+// TestSwitchFirstHopCandidates ensures that only links which are eligible to
+// forward, have a free slot, and have sufficient bandwidth are returned as
+// first hop candidates, sorted by bandwidth in descending order.
+func TestSwitchFirstHopCandidates(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	links := []struct {
+		peer        Peer
+		eligible    bool
+		overflowing bool
+		bandwidth   lnwire.MilliSatoshi
+	}{
+		// Eligible links with sufficient bandwidth.
+		{alicePeer, true, false, 2000},
+		{bobPeer, true, false, 5000},
+		{alicePeer, true, false, 1000},
+
+		// Insufficient bandwidth.
+		{bobPeer, true, false, 999},
+
+		// Ineligible to forward.
+		{alicePeer, false, false, 10000},
+
+		// No free slot.
+		{bobPeer, true, true, 10000},
+	}
+
+	for i, l := range links {
+		chanPoint := wire.NewOutPoint(hash1, uint32(i))
+		link := newMockChannelLink(
+			s, lnwire.NewChanIDFromOutPoint(chanPoint),
+			lnwire.NewShortChanIDFromInt(uint64(i+1)), l.peer,
+			l.eligible,
+		)
+		link.overflowing = l.overflowing
+		link.bandwidth = l.bandwidth
+
+		if err := s.AddLink(link); err != nil {
+			t.Fatalf("unable to add link: %v", err)
+		}
+	}
+
+	candidates := s.FirstHopCandidates(1000)
+	expected := []lnwire.ShortChannelID{
+		lnwire.NewShortChanIDFromInt(2),
+		lnwire.NewShortChanIDFromInt(1),
+		lnwire.NewShortChanIDFromInt(3),
+	}
+	if !reflect.DeepEqual(candidates, expected) {
+		t.Fatalf("expected candidates %v, got %v", expected,
+			candidates)
+	}
+
+	// If no link has sufficient bandwidth, then there are no candidates.
+	if candidates := s.FirstHopCandidates(5001); len(candidates) != 0 {
+		t.Fatalf("expected no candidates, got %v", candidates)
+	}
+}
@@ -0,0 +1,237 @@
+package htlcswitch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// DefaultFlowBucketSize is the default duration of the buckets the
+	// HTLC flow over each channel is accumulated within.
+	DefaultFlowBucketSize = 10 * time.Minute
+
+	// DefaultFlowBuckets is the default number of buckets of HTLC flow
+	// retained for each channel, amounting to a day with the
+	// DefaultFlowBucketSize.
+	DefaultFlowBuckets = 144
+)
+
+// ErrInvalidFlowBucketSize is returned by FlowTimeSeries if the requested
+// bucket size isn't a positive multiple of the switch's FlowBucketSize.
+var ErrInvalidFlowBucketSize = errors.New("flow bucket size must be a " +
+	"positive multiple of the flow resolution")
+
+// FlowCounts counts a single kind of HTLC flow over a channel.
+type FlowCounts struct {
+	// Count is the number of HTLC's.
+	Count uint64
+
+	// Volume is the total value of the HTLC's.
+	Volume lnwire.MilliSatoshi
+}
+
+// add accounts for a single HTLC of the passed amount.
+func (f *FlowCounts) add(amt lnwire.MilliSatoshi) {
+	f.Count++
+	f.Volume += amt
+}
+
+// merge adds the passed counts to those of f.
+func (f *FlowCounts) merge(o FlowCounts) {
+	f.Count += o.Count
+	f.Volume += o.Volume
+}
+
+// FlowBucket is the HTLC flow settled over a channel within a single bucket
+// of time.
+type FlowBucket struct {
+	// Start is the time the bucket began.
+	Start time.Time
+
+	// ForwardedIn is the flow of HTLC's received over the channel and
+	// forwarded over another one.
+	ForwardedIn FlowCounts
+
+	// ForwardedOut is the flow of HTLC's received over another channel
+	// and forwarded over the channel.
+	ForwardedOut FlowCounts
+
+	// LocalIn is the flow of HTLC's received over the channel which were
+	// paying to us.
+	LocalIn FlowCounts
+
+	// LocalOut is the flow of locally initiated payments sent over the
+	// channel.
+	LocalOut FlowCounts
+}
+
+// merge adds the flow of the passed bucket to that of b.
+func (b *FlowBucket) merge(o *FlowBucket) {
+	b.ForwardedIn.merge(o.ForwardedIn)
+	b.ForwardedOut.merge(o.ForwardedOut)
+	b.LocalIn.merge(o.LocalIn)
+	b.LocalOut.merge(o.LocalOut)
+}
+
+// flowKind identifies the kind of HTLC flow over a channel.
+type flowKind uint8
+
+const (
+	flowForwardedIn flowKind = iota
+	flowForwardedOut
+	flowLocalIn
+	flowLocalOut
+)
+
+// flowRecorder accumulates the HTLC flow settled over each channel within
+// buckets of time. The buckets of each channel form a ring buffer, such that
+// only the most recent ones are retained.
+type flowRecorder struct {
+	sync.Mutex
+
+	bucketSize time.Duration
+	numBuckets int
+
+	// now returns the current time. It's overridden within tests in
+	// order to control the rolling forward of buckets.
+	now func() time.Time
+
+	series map[lnwire.ShortChannelID][]FlowBucket
+}
+
+// newFlowRecorder creates a new flowRecorder, retaining the passed number of
+// buckets of the passed size for each channel. Zero values are replaced by
+// DefaultFlowBucketSize and DefaultFlowBuckets.
+func newFlowRecorder(bucketSize time.Duration, numBuckets int) *flowRecorder {
+	if bucketSize <= 0 {
+		bucketSize = DefaultFlowBucketSize
+	}
+	if numBuckets <= 0 {
+		numBuckets = DefaultFlowBuckets
+	}
+
+	return &flowRecorder{
+		bucketSize: bucketSize,
+		numBuckets: numBuckets,
+		now:        time.Now,
+		series:     make(map[lnwire.ShortChannelID][]FlowBucket),
+	}
+}
+
+// slot returns the position within a channel's ring buffer of the bucket
+// beginning at the passed time.
+func (f *flowRecorder) slot(start time.Time) int {
+	n := start.UnixNano() / int64(f.bucketSize)
+	return int(n % int64(f.numBuckets))
+}
+
+// record accounts for an HTLC of the passed amount and kind settled over the
+// passed channel within the current bucket.
+func (f *flowRecorder) record(chanID lnwire.ShortChannelID, kind flowKind,
+	amt lnwire.MilliSatoshi) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	buckets, ok := f.series[chanID]
+	if !ok {
+		buckets = make([]FlowBucket, f.numBuckets)
+		f.series[chanID] = buckets
+	}
+
+	// If the slot still holds a bucket which has since rolled out of the
+	// retained window, then it's reset before being reused.
+	start := f.now().Truncate(f.bucketSize)
+	bucket := &buckets[f.slot(start)]
+	if !bucket.Start.Equal(start) {
+		*bucket = FlowBucket{Start: start}
+	}
+
+	switch kind {
+	case flowForwardedIn:
+		bucket.ForwardedIn.add(amt)
+	case flowForwardedOut:
+		bucket.ForwardedOut.add(amt)
+	case flowLocalIn:
+		bucket.LocalIn.add(amt)
+	case flowLocalOut:
+		bucket.LocalOut.add(amt)
+	}
+}
+
+// timeSeries returns the flow over the passed channel within the passed
+// window up to the current time, in buckets of the passed size.
+func (f *flowRecorder) timeSeries(chanID lnwire.ShortChannelID,
+	bucketSize, window time.Duration) ([]FlowBucket, error) {
+
+	if bucketSize <= 0 || bucketSize%f.bucketSize != 0 {
+		return nil, ErrInvalidFlowBucketSize
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	numBuckets := int((window + bucketSize - 1) / bucketSize)
+	if numBuckets < 1 {
+		numBuckets = 1
+	}
+
+	now := f.now()
+	last := now.Truncate(bucketSize)
+	first := last.Add(-time.Duration(numBuckets-1) * bucketSize)
+
+	// Slots which haven't been reused since their bucket rolled out of
+	// the retained buckets are ignored.
+	oldest := now.Truncate(f.bucketSize).Add(
+		-time.Duration(f.numBuckets-1) * f.bucketSize,
+	)
+
+	series := make([]FlowBucket, numBuckets)
+	for i := range series {
+		series[i].Start = first.Add(time.Duration(i) * bucketSize)
+	}
+
+	end := last.Add(bucketSize)
+	for i := range f.series[chanID] {
+		bucket := &f.series[chanID][i]
+		if bucket.Start.Before(first) || bucket.Start.Before(oldest) ||
+			!bucket.Start.Before(end) {
+
+			continue
+		}
+
+		idx := int(bucket.Start.Sub(first) / bucketSize)
+		series[idx].merge(bucket)
+	}
+
+	return series, nil
+}
+
+// FlowTimeSeries returns the HTLC flow settled over the channel with the
+// passed short channel ID within the passed window up to now, oldest first,
+// in buckets of the passed size, which must be a multiple of the switch's
+// FlowBucketSize. Only the switch's most recent FlowBuckets are retained for
+// each channel, so any older parts of the window are empty.
+func (s *Switch) FlowTimeSeries(chanID lnwire.ShortChannelID,
+	bucketSize, window time.Duration) ([]FlowBucket, error) {
+
+	return s.flows.timeSeries(chanID, bucketSize, window)
+}
+
+// recordIncomingFlow accounts for the settle of the HTLC with the passed
+// index received over the link, either as a forward or a payment to us.
+func (l *channelLink) recordIncomingFlow(htlcIndex uint64, forwarded bool) {
+	htlc, ok := l.channel.LookupRemoteHtlc(htlcIndex)
+	if !ok {
+		return
+	}
+
+	kind := flowLocalIn
+	if forwarded {
+		kind = flowForwardedIn
+	}
+	l.cfg.Switch.flows.record(l.ShortChanID(), kind, htlc.Amt)
+}
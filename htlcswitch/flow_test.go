@@ -0,0 +1,193 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestSwitchFlowTimeSeries tests that settled forwards and local payments
+// are attributed to the buckets of their outgoing channel as the clock
+// advances, that buckets are aggregated into larger ones on request, and
+// that only the most recent buckets are retained.
+func TestSwitchFlowTimeSeries(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{
+		FlowBucketSize: time.Minute,
+		FlowBuckets:    3,
+	})
+	clock := &mockClock{now: time.Unix(6000, 0)}
+	s.flows.now = clock.Now
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	waitForBob := func() {
+		select {
+		case <-bobChannelLink.packets:
+		case <-time.After(time.Second):
+			t.Fatalf("htlc wasn't sent to bob")
+		}
+	}
+
+	settle := func(outgoingID uint64, amt lnwire.MilliSatoshi) {
+		err := s.forward(&htlcPacket{
+			outgoingChanID: bobChanID,
+			outgoingHTLCID: outgoingID,
+			amount:         amt,
+			htlc: &lnwire.UpdateFulfillHTLC{
+				PaymentPreimage: preimage,
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to settle htlc: %v", err)
+		}
+	}
+
+	// forward sends an HTLC of the passed amount from Alice to Bob, and
+	// settles it.
+	var outgoingID uint64
+	forward := func(amt lnwire.MilliSatoshi) {
+		s.forward(&htlcPacket{
+			incomingChanID: aliceChanID,
+			incomingHTLCID: outgoingID,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      amt,
+			},
+		})
+		waitForBob()
+
+		settle(outgoingID, amt)
+		select {
+		case <-aliceChannelLink.packets:
+		case <-time.After(time.Second):
+			t.Fatalf("settle wasn't propagated to alice")
+		}
+		outgoingID++
+	}
+
+	// pay sends a local payment of the passed amount to Bob, and settles
+	// it.
+	pay := func(amt lnwire.MilliSatoshi) {
+		errChan := make(chan error, 1)
+		go func() {
+			_, err := s.SendHTLC(
+				bobPeer.PubKey(), &lnwire.UpdateAddHTLC{
+					PaymentHash: rhash,
+					Amount:      amt,
+				}, newMockDeobfuscator(),
+			)
+			errChan <- err
+		}()
+		waitForBob()
+
+		settle(outgoingID, amt)
+		select {
+		case err := <-errChan:
+			if err != nil {
+				t.Fatalf("payment failed: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("payment result not received")
+		}
+		outgoingID++
+	}
+
+	assertSeries := func(chanID lnwire.ShortChannelID,
+		bucketSize, window time.Duration, expected []FlowBucket) {
+
+		series, err := s.FlowTimeSeries(chanID, bucketSize, window)
+		if err != nil {
+			t.Fatalf("unable to fetch flow time series: %v", err)
+		}
+		if len(series) != len(expected) {
+			t.Fatalf("expected %v buckets, got %v", len(expected),
+				len(series))
+		}
+		for i := range expected {
+			if !series[i].Start.Equal(expected[i].Start) {
+				t.Fatalf("bucket %v: expected start %v, got %v",
+					i, expected[i].Start, series[i].Start)
+			}
+			series[i].Start = expected[i].Start
+			if series[i] != expected[i] {
+				t.Fatalf("bucket %v: expected %v, got %v", i,
+					expected[i], series[i])
+			}
+		}
+	}
+
+	// A forward settled within the first bucket, followed by a local
+	// payment settled within the second, are both attributed to Bob's
+	// channel.
+	t0 := clock.Now()
+	forward(1000)
+	clock.advance(time.Minute)
+	t1 := clock.Now()
+	pay(500)
+
+	fwd := FlowCounts{Count: 1, Volume: 1000}
+	local := FlowCounts{Count: 1, Volume: 500}
+	assertSeries(bobChanID, time.Minute, 3*time.Minute, []FlowBucket{
+		{Start: t0.Add(-time.Minute)},
+		{Start: t0, ForwardedOut: fwd},
+		{Start: t1, LocalOut: local},
+	})
+
+	// Larger buckets aggregate the flow of the buckets within them.
+	assertSeries(bobChanID, 2*time.Minute, 2*time.Minute, []FlowBucket{
+		{Start: t0, ForwardedOut: fwd, LocalOut: local},
+	})
+
+	// Nothing was settled outgoing over Alice's channel.
+	assertSeries(aliceChanID, time.Minute, time.Minute, []FlowBucket{
+		{Start: t1},
+	})
+
+	// Once the clock has advanced past the retained buckets, the oldest
+	// ones are reused for the new flow.
+	clock.advance(3 * time.Minute)
+	t4 := clock.Now()
+	forward(2000)
+
+	assertSeries(bobChanID, time.Minute, 5*time.Minute, []FlowBucket{
+		{Start: t0},
+		{Start: t1},
+		{Start: t1.Add(time.Minute)},
+		{Start: t1.Add(2 * time.Minute)},
+		{Start: t4, ForwardedOut: FlowCounts{Count: 1, Volume: 2000}},
+	})
+
+	// The bucket size must be a multiple of the switch's bucket size.
+	_, err := s.FlowTimeSeries(bobChanID, 90*time.Second, time.Hour)
+	if err != ErrInvalidFlowBucketSize {
+		t.Fatalf("expected ErrInvalidFlowBucketSize, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// ExpectedFee returns the fee that a forward of the passed amount arriving
+// over the incoming channel and leaving over the outgoing channel must
+// currently carry in order to be accepted. The fee is the one enforced by the
+// incoming link, under its current forwarding policy, including its probe
+// policy and its inbound fee. As the fee depends on the link's policy at the
+// time the HTLC is received, the returned value is only a snapshot, which
+// changes once a new policy is applied to the link. ErrChannelLinkNotFound is
+// returned if no active link exists for either channel.
+func (s *Switch) ExpectedFee(incomingScid, outgoingScid lnwire.ShortChannelID,
+	amt lnwire.MilliSatoshi) (lnwire.MilliSatoshi, error) {
+
+	links, err := s.fetchAllLinks()
+	if err != nil {
+		return 0, err
+	}
+
+	var incoming, outgoing ChannelLink
+	for _, link := range links {
+		if link.ShortChanID() == incomingScid {
+			incoming = link
+		}
+		if link.ShortChanID() == outgoingScid {
+			outgoing = link
+		}
+	}
+	if incoming == nil || outgoing == nil {
+		return 0, ErrChannelLinkNotFound
+	}
+
+	// The policy is retrieved from within the link's htlcManager
+	// goroutine, such that we compute the fee in the same way as when the
+	// link accepts a forward.
+	policy := incoming.CurrentForwardingPolicy()
+
+	return policy.expectedForwardFee(amt), nil
+}
@@ -0,0 +1,74 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchExpectedFee tests that the switch reports the fee enforced by the
+// incoming link of a forward, including its inbound fee, and that unknown
+// channels are rejected.
+func TestSwitchExpectedFee(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*5,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	htlcSwitch := n.bobServer.htlcSwitch
+	incoming := n.firstBobChannelLink.ShortChanID()
+	outgoing := n.secondBobChannelLink.ShortChanID()
+	amt := lnwire.NewMSatFromSatoshis(100000)
+
+	fee, err := htlcSwitch.ExpectedFee(incoming, outgoing, amt)
+	if err != nil {
+		t.Fatalf("unable to get expected fee: %v", err)
+	}
+	if fee != ExpectedFee(n.globalPolicy, amt) {
+		t.Fatalf("expected fee %v, got %v",
+			ExpectedFee(n.globalPolicy, amt), fee)
+	}
+
+	// An inbound fee applied to the incoming link should be included.
+	inbound := InboundFee{BaseFee: 1000, FeeRate: 10}
+	err = n.firstBobChannelLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Inbound: &inbound,
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+
+	fee, err = htlcSwitch.ExpectedFee(incoming, outgoing, amt)
+	if err != nil {
+		t.Fatalf("unable to get expected fee: %v", err)
+	}
+	expectedFee := ExpectedFee(n.globalPolicy, amt) +
+		lnwire.MilliSatoshi(inbound.fee(amt))
+	if fee != expectedFee {
+		t.Fatalf("expected fee %v, got %v", expectedFee, fee)
+	}
+
+	// Either channel being unknown should result in an error.
+	unknown := lnwire.NewShortChanIDFromInt(0xffffff)
+	_, err = htlcSwitch.ExpectedFee(unknown, outgoing, amt)
+	if err != ErrChannelLinkNotFound {
+		t.Fatalf("expected ErrChannelLinkNotFound, got %v", err)
+	}
+	_, err = htlcSwitch.ExpectedFee(incoming, unknown, amt)
+	if err != ErrChannelLinkNotFound {
+		t.Fatalf("expected ErrChannelLinkNotFound, got %v", err)
+	}
+}
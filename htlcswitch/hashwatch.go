@@ -0,0 +1,257 @@
+package htlcswitch
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+const (
+	// maxHashWatches is the maximum number of concurrent hash watches the
+	// switch will accept. This bounds the memory used by watches, along
+	// with the HTLC's they track.
+	maxHashWatches = 1000
+
+	// hashWatchBuffer is the number of events buffered for each watch. If
+	// a watcher falls further behind, then events are dropped rather than
+	// blocking the links.
+	hashWatchBuffer = 50
+)
+
+// ErrTooManyHashWatches is returned by RegisterHashWatch if the maximum number
+// of concurrent hash watches has been reached.
+var ErrTooManyHashWatches = errors.New("too many concurrent hash watches")
+
+// HashEventType denotes the stage of an HTLC's lifetime a HashEvent reports.
+type HashEventType uint8
+
+const (
+	// HashEventAdd is emitted once an incoming HTLC has been locked in.
+	HashEventAdd HashEventType = iota
+
+	// HashEventSettle is emitted once an incoming HTLC has been settled.
+	HashEventSettle
+
+	// HashEventFail is emitted once an incoming HTLC has been failed.
+	HashEventFail
+)
+
+// String returns a human readable string describing the HashEventType.
+func (h HashEventType) String() string {
+	switch h {
+	case HashEventAdd:
+		return "Add"
+
+	case HashEventSettle:
+		return "Settle"
+
+	case HashEventFail:
+		return "Fail"
+
+	default:
+		return "unknown event"
+	}
+}
+
+// HashEvent reports an HTLC with a watched payment hash. Events are emitted
+// for each HTLC received over any of our links, whether it's to be forwarded
+// or we're its exit hop, so a multi-path payment results in events for each
+// of its parts.
+type HashEvent struct {
+	// PaymentHash is the payment hash of the HTLC.
+	PaymentHash chainhash.Hash
+
+	// Type is the stage of the HTLC's lifetime reported by the event.
+	Type HashEventType
+
+	// ChanID and HTLCID identify the HTLC on the channel it was received
+	// over.
+	ChanID lnwire.ShortChannelID
+	HTLCID uint64
+
+	// Amount is the value of the HTLC.
+	Amount lnwire.MilliSatoshi
+
+	// Timestamp is the time at which the event occurred.
+	Timestamp time.Time
+}
+
+// trackedHTLC is an incoming HTLC with a watched payment hash which has yet to
+// be resolved.
+type trackedHTLC struct {
+	paymentHash chainhash.Hash
+	amount      lnwire.MilliSatoshi
+}
+
+// hashWatcher delivers HashEvents for the HTLC's of all watched payment
+// hashes. The HTLC's are tracked by their incoming circuit from the time
+// they're added until they're resolved.
+type hashWatcher struct {
+	// numWatches is accessed atomically, allowing links to skip all
+	// locking while there are no watches.
+	numWatches int32
+
+	sync.Mutex
+
+	watches map[chainhash.Hash]map[uint64]chan HashEvent
+	nextID  uint64
+
+	tracked map[circuitKey]trackedHTLC
+}
+
+// newHashWatcher creates a new hashWatcher without any watches.
+func newHashWatcher() *hashWatcher {
+	return &hashWatcher{
+		watches: make(map[chainhash.Hash]map[uint64]chan HashEvent),
+		tracked: make(map[circuitKey]trackedHTLC),
+	}
+}
+
+// isActive returns true if any payment hash is currently watched.
+func (h *hashWatcher) isActive() bool {
+	return atomic.LoadInt32(&h.numWatches) > 0
+}
+
+// added emits a HashEventAdd for the passed incoming HTLC if its payment hash
+// is watched, and tracks it until it's resolved.
+func (h *hashWatcher) added(paymentHash [32]byte,
+	chanID lnwire.ShortChannelID, htlcID uint64,
+	amount lnwire.MilliSatoshi) {
+
+	if !h.isActive() {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	hash := chainhash.Hash(paymentHash)
+	if _, ok := h.watches[hash]; !ok {
+		return
+	}
+
+	key := circuitKey{chanID: chanID, htlcID: htlcID}
+	h.tracked[key] = trackedHTLC{
+		paymentHash: hash,
+		amount:      amount,
+	}
+
+	h.notify(HashEvent{
+		PaymentHash: hash,
+		Type:        HashEventAdd,
+		ChanID:      chanID,
+		HTLCID:      htlcID,
+		Amount:      amount,
+		Timestamp:   time.Now(),
+	})
+}
+
+// resolved emits a HashEventSettle or HashEventFail for the passed incoming
+// HTLC if it's being tracked, and stops tracking it.
+func (h *hashWatcher) resolved(chanID lnwire.ShortChannelID, htlcID uint64,
+	settled bool) {
+
+	if !h.isActive() {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	key := circuitKey{chanID: chanID, htlcID: htlcID}
+	htlc, ok := h.tracked[key]
+	if !ok {
+		return
+	}
+	delete(h.tracked, key)
+
+	eventType := HashEventFail
+	if settled {
+		eventType = HashEventSettle
+	}
+
+	h.notify(HashEvent{
+		PaymentHash: htlc.paymentHash,
+		Type:        eventType,
+		ChanID:      chanID,
+		HTLCID:      htlcID,
+		Amount:      htlc.amount,
+		Timestamp:   time.Now(),
+	})
+}
+
+// notify delivers the passed event to all watches of its payment hash.
+//
+// NOTE: This MUST be called with the hashWatcher's mutex held.
+func (h *hashWatcher) notify(event HashEvent) {
+	for id, events := range h.watches[event.PaymentHash] {
+		select {
+		case events <- event:
+		default:
+			log.Warnf("Dropping %v event for htlc(%x), hash watch "+
+				"%v is falling behind", event.Type,
+				event.PaymentHash[:], id)
+		}
+	}
+}
+
+// RegisterHashWatch returns a channel over which HashEvents are delivered for
+// each HTLC with the passed payment hash which is received over any of our
+// links, along with a function which cancels the watch. The channel is closed
+// once the watch has been cancelled. Watches are read-only, and have no effect
+// on how the HTLC's are handled. If the maximum number of concurrent watches
+// has been reached, then ErrTooManyHashWatches is returned.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) RegisterHashWatch(hash chainhash.Hash) (<-chan HashEvent,
+	func(), error) {
+
+	h := s.hashWatcher
+
+	h.Lock()
+	defer h.Unlock()
+
+	if atomic.LoadInt32(&h.numWatches) >= maxHashWatches {
+		return nil, nil, ErrTooManyHashWatches
+	}
+
+	id := h.nextID
+	h.nextID++
+
+	if _, ok := h.watches[hash]; !ok {
+		h.watches[hash] = make(map[uint64]chan HashEvent)
+	}
+	events := make(chan HashEvent, hashWatchBuffer)
+	h.watches[hash][id] = events
+	atomic.AddInt32(&h.numWatches, 1)
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.Lock()
+			defer h.Unlock()
+
+			delete(h.watches[hash], id)
+			close(events)
+			atomic.AddInt32(&h.numWatches, -1)
+
+			// Once the last watch of the payment hash has been
+			// cancelled, its HTLC's no longer need to be tracked.
+			if len(h.watches[hash]) > 0 {
+				return
+			}
+			delete(h.watches, hash)
+			for key, htlc := range h.tracked {
+				if htlc.paymentHash == hash {
+					delete(h.tracked, key)
+				}
+			}
+		})
+	}
+
+	return events, cancel, nil
+}
@@ -0,0 +1,164 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkHashWatch tests that hash watches receive an add event along
+// with a settle or fail event for each incoming HTLC paying to the watched
+// payment hash, including each part of a multi-path payment.
+func TestChannelLinkHashWatch(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	registry := aliceLink.cfg.Registry.(*mockInvoiceRegistry)
+
+	// Bob sends Alice an HTLC paying to a known invoice, followed by two
+	// parts of a payment to an unknown payment hash.
+	var settleHash, failHash chainhash.Hash
+	for i := 0; i < 3; i++ {
+		htlcAmt, totalTimelock, hops := generateHops(
+			lnwire.NewMSatFromSatoshis(10000), testStartingHeight,
+			aliceLink,
+		)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to gen route: %v", err)
+		}
+		invoice, htlc, err := generatePayment(
+			htlcAmt, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+
+		switch i {
+		case 0:
+			settleHash = chainhash.Hash(htlc.PaymentHash)
+			if err := registry.AddInvoice(*invoice); err != nil {
+				t.Fatalf("unable to add invoice: %v", err)
+			}
+		case 1:
+			failHash = chainhash.Hash(htlc.PaymentHash)
+		default:
+			htlc.PaymentHash = failHash
+		}
+
+		htlc.ID = uint64(i)
+		if _, err := bobChannel.AddHTLC(htlc); err != nil {
+			t.Fatalf("unable to add htlc: %v", err)
+		}
+		aliceLink.HandleChannelUpdate(htlc)
+	}
+
+	htlcSwitch := aliceLink.cfg.Switch
+	settleEvents, cancelSettle, err := htlcSwitch.RegisterHashWatch(
+		settleHash,
+	)
+	if err != nil {
+		t.Fatalf("unable to register hash watch: %v", err)
+	}
+	defer cancelSettle()
+	failEvents, cancelFail, err := htlcSwitch.RegisterHashWatch(failHash)
+	if err != nil {
+		t.Fatalf("unable to register hash watch: %v", err)
+	}
+
+	if err := updateState(batchTick, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	assertEvent := func(events <-chan HashEvent, hash chainhash.Hash,
+		eventType HashEventType, htlcID uint64) {
+
+		select {
+		case event := <-events:
+			if event.PaymentHash != hash || event.Type != eventType ||
+				event.HTLCID != htlcID {
+
+				t.Fatalf("expected %v event for htlc %v with "+
+					"hash %v, got %v event for htlc %v "+
+					"with hash %v", eventType, htlcID, hash,
+					event.Type, event.HTLCID,
+					event.PaymentHash)
+			}
+			if event.ChanID != aliceLink.ShortChanID() {
+				t.Fatalf("expected event on channel %v, got %v",
+					aliceLink.ShortChanID(), event.ChanID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive %v event for htlc %v",
+				eventType, htlcID)
+		}
+	}
+
+	assertEvent(settleEvents, settleHash, HashEventAdd, 0)
+	assertEvent(settleEvents, settleHash, HashEventSettle, 0)
+
+	// Each part of the multi-path payment is reported separately.
+	assertEvent(failEvents, failHash, HashEventAdd, 1)
+	assertEvent(failEvents, failHash, HashEventFail, 1)
+	assertEvent(failEvents, failHash, HashEventAdd, 2)
+	assertEvent(failEvents, failHash, HashEventFail, 2)
+
+	// Once cancelled, the watch's channel should be closed, and it should
+	// no longer track any HTLC's.
+	cancelFail()
+	if _, ok := <-failEvents; ok {
+		t.Fatalf("expected cancelled watch to be closed")
+	}
+
+	htlcSwitch.hashWatcher.Lock()
+	numTracked := len(htlcSwitch.hashWatcher.tracked)
+	htlcSwitch.hashWatcher.Unlock()
+	if numTracked != 0 {
+		t.Fatalf("expected no tracked htlcs, got %v", numTracked)
+	}
+}
+
+// TestSwitchHashWatchLimit tests that the number of concurrent hash watches
+// is bounded, and that cancelling a watch frees up a slot.
+func TestSwitchHashWatchLimit(t *testing.T) {
+	t.Parallel()
+
+	s := New(Config{})
+
+	var cancels []func()
+	for i := 0; i < maxHashWatches; i++ {
+		hash := chainhash.Hash{byte(i), byte(i >> 8)}
+		_, cancel, err := s.RegisterHashWatch(hash)
+		if err != nil {
+			t.Fatalf("unable to register hash watch %v: %v", i, err)
+		}
+		cancels = append(cancels, cancel)
+	}
+
+	_, _, err := s.RegisterHashWatch(chainhash.Hash{})
+	if err != ErrTooManyHashWatches {
+		t.Fatalf("expected ErrTooManyHashWatches, got %v", err)
+	}
+
+	// Cancelling a watch twice should only free up a single slot.
+	cancels[0]()
+	cancels[0]()
+	if _, _, err := s.RegisterHashWatch(chainhash.Hash{}); err != nil {
+		t.Fatalf("unable to register hash watch: %v", err)
+	}
+	_, _, err = s.RegisterHashWatch(chainhash.Hash{})
+	if err != ErrTooManyHashWatches {
+		t.Fatalf("expected ErrTooManyHashWatches, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package htlcswitch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+// HoldResolverKind denotes what resolves the HTLC's parked for a payment hash.
+type HoldResolverKind uint8
+
+const (
+	// HoldResolverApp indicates that the parked HTLC's are resolved by
+	// the application through the HoldResolution returned by
+	// RegisterHoldResolver.
+	HoldResolverApp HoldResolverKind = iota
+
+	// HoldResolverOracle indicates that the parked HTLC's are resolved
+	// according to the decision of an OracleClient.
+	HoldResolverOracle
+
+	// HoldResolverReview indicates that the parked HTLC's pay to an
+	// invoice requiring review, and are resolved once an operator
+	// approves or rejects the payment.
+	HoldResolverReview
+)
+
+// String returns a human readable name of the HoldResolverKind.
+func (k HoldResolverKind) String() string {
+	switch k {
+	case HoldResolverApp:
+		return "App"
+	case HoldResolverOracle:
+		return "Oracle"
+	case HoldResolverReview:
+		return "Review"
+	default:
+		return "Unknown"
+	}
+}
+
+// HeldHTLC describes a single HTLC parked within the switch awaiting an
+// external resolution.
+type HeldHTLC struct {
+	// ChanID is the short channel ID of the channel the HTLC arrived on.
+	ChanID lnwire.ShortChannelID
+
+	// HTLCIndex is the index of the HTLC within the channel.
+	HTLCIndex uint64
+
+	// Amount is the amount of the HTLC.
+	Amount lnwire.MilliSatoshi
+
+	// Expiry is the absolute expiry height of the HTLC.
+	Expiry uint32
+
+	// AcceptTime is the time at which the HTLC was parked.
+	AcceptTime time.Time
+
+	// RemainingTime is an estimate of the time remaining until the HTLC
+	// is automatically cancelled back due to nearing its expiry.
+	RemainingTime time.Duration
+}
+
+// HeldHTLCSet groups the HTLC's parked for a single payment hash, which are
+// settled or cancelled together.
+type HeldHTLCSet struct {
+	// PaymentHash is the payment hash the HTLC's pay to.
+	PaymentHash chainhash.Hash
+
+	// Resolver denotes what resolves the set.
+	Resolver HoldResolverKind
+
+	// HTLCs are the parked HTLC's of the set.
+	HTLCs []HeldHTLC
+}
+
+// RemainingTime returns the remaining time of the HTLC within the set which is
+// closest to being automatically cancelled back.
+func (h *HeldHTLCSet) RemainingTime() time.Duration {
+	var remaining time.Duration
+	for i, htlc := range h.HTLCs {
+		if i == 0 || htlc.RemainingTime < remaining {
+			remaining = htlc.RemainingTime
+		}
+	}
+
+	return remaining
+}
+
+// setHoldResolverKind records what resolves the HTLC's parked for the passed
+// payment hash.
+func (s *Switch) setHoldResolverKind(hash chainhash.Hash,
+	kind HoldResolverKind) {
+
+	s.holdMtx.Lock()
+	resolver, ok := s.holdResolvers[hash]
+	s.holdMtx.Unlock()
+	if !ok {
+		return
+	}
+
+	resolver.Lock()
+	resolver.kind = kind
+	resolver.Unlock()
+}
+
+// HeldHTLCs returns each set of HTLC's currently parked within the switch
+// awaiting an external resolution, grouped by payment hash. The sets are
+// ordered by their remaining time, such that those closest to being
+// automatically cancelled back are returned first. Registered resolvers for
+// which no HTLC's have yet arrived are omitted.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) HeldHTLCs() []HeldHTLCSet {
+	s.holdMtx.Lock()
+	resolvers := make([]*holdResolver, 0, len(s.holdResolvers))
+	for _, resolver := range s.holdResolvers {
+		resolvers = append(resolvers, resolver)
+	}
+	s.holdMtx.Unlock()
+
+	var sets []HeldHTLCSet
+	for _, resolver := range resolvers {
+		resolver.Lock()
+		if len(resolver.htlcs) == 0 {
+			resolver.Unlock()
+			continue
+		}
+
+		set := HeldHTLCSet{
+			PaymentHash: resolver.hash,
+			Resolver:    resolver.kind,
+			HTLCs:       make([]HeldHTLC, 0, len(resolver.htlcs)),
+		}
+		for _, htlc := range resolver.htlcs {
+			set.HTLCs = append(set.HTLCs, HeldHTLC{
+				ChanID:        htlc.link.ShortChanID(),
+				HTLCIndex:     htlc.htlcIndex,
+				Amount:        htlc.amount,
+				Expiry:        htlc.expiry,
+				AcceptTime:    htlc.parkTime,
+				RemainingTime: htlc.remainingTime(),
+			})
+		}
+		resolver.Unlock()
+
+		sets = append(sets, set)
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].RemainingTime() < sets[j].RemainingTime()
+	})
+
+	return sets
+}
@@ -0,0 +1,110 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchHeldHTLCs tests that the HTLC's parked within the switch are
+// enumerated by payment hash, along with what resolves them, and that they're
+// no longer listed once resolved.
+func TestSwitchHeldHTLCs(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	bobSwitch := n.bobServer.htlcSwitch
+	if sets := bobSwitch.HeldHTLCs(); len(sets) != 0 {
+		t.Fatalf("expected no held htlcs, got %v", len(sets))
+	}
+
+	// We'll park one payment for the application to resolve, and another
+	// for an oracle which remains undecided.
+	before := time.Now()
+	appPayment := sendHoldPayment(t, n)
+	resolution := waitHoldResolution(t, appPayment)
+
+	oracle := &mockOracle{err: ErrOracleUndecided}
+	oracleResolver, oraclePayment := sendOraclePayment(t, n, oracle)
+
+	var sets []HeldHTLCSet
+	for i := 0; i < 100; i++ {
+		sets = bobSwitch.HeldHTLCs()
+		if len(sets) == 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(sets) != 2 {
+		t.Fatalf("expected 2 held htlc sets, got %v", len(sets))
+	}
+
+	expectedKinds := map[chainhash.Hash]HoldResolverKind{
+		appPayment.rhash:    HoldResolverApp,
+		oraclePayment.rhash: HoldResolverOracle,
+	}
+	for _, set := range sets {
+		kind, ok := expectedKinds[set.PaymentHash]
+		if !ok {
+			t.Fatalf("unexpected held hash %v", set.PaymentHash)
+		}
+		if set.Resolver != kind {
+			t.Fatalf("expected resolver %v for %v, got %v", kind,
+				set.PaymentHash, set.Resolver)
+		}
+		if len(set.HTLCs) != 1 {
+			t.Fatalf("expected 1 held htlc for %v, got %v",
+				set.PaymentHash, len(set.HTLCs))
+		}
+
+		htlc := set.HTLCs[0]
+		if htlc.Amount != appPayment.htlcAmt {
+			t.Fatalf("expected amount %v, got %v",
+				appPayment.htlcAmt, htlc.Amount)
+		}
+		if htlc.ChanID != n.firstBobChannelLink.ShortChanID() {
+			t.Fatalf("expected channel %v, got %v",
+				n.firstBobChannelLink.ShortChanID(), htlc.ChanID)
+		}
+		if htlc.AcceptTime.Before(before) {
+			t.Fatalf("accept time %v precedes payment at %v",
+				htlc.AcceptTime, before)
+		}
+		if htlc.RemainingTime <= 0 {
+			t.Fatalf("expected positive remaining time, got %v",
+				htlc.RemainingTime)
+		}
+	}
+
+	// Once both sets are resolved, nothing should be held.
+	if err := resolution.Cancel(); err != nil {
+		t.Fatalf("unable to cancel hold resolution: %v", err)
+	}
+	oracle.decide(false, [32]byte{}, nil)
+	oracleResolver.Check()
+	select {
+	case <-oracleResolver.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("oracle resolver didn't resolve")
+	}
+
+	if sets := bobSwitch.HeldHTLCs(); len(sets) != 0 {
+		t.Fatalf("expected no held htlcs, got %v", len(sets))
+	}
+}
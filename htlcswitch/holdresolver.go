@@ -0,0 +1,278 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+var (
+	// ErrHoldResolved is returned when attempting to settle or cancel a
+	// hold resolution which has already been resolved, either by the
+	// application or due to the held HTLC's nearing their expiry.
+	ErrHoldResolved = errors.New("hold resolution already resolved")
+
+	// ErrInvalidHoldPreimage is returned when attempting to settle a hold
+	// resolution with a preimage that doesn't match its payment hash.
+	ErrInvalidHoldPreimage = errors.New("preimage doesn't match payment " +
+		"hash of hold resolution")
+
+	// ErrNoHeldHTLCs is returned by RemainingHoldTime if no HTLC's are
+	// currently parked for the passed payment hash.
+	ErrNoHeldHTLCs = errors.New("no htlcs held for payment hash")
+)
+
+// expectedBlockInterval is the average time between blocks, used to convert
+// the number of blocks until a parked HTLC is cancelled into a duration.
+const expectedBlockInterval = 10 * time.Minute
+
+// HoldResolution is delivered to the application over the channel returned by
+// RegisterHoldResolver once HTLC's paying to the registered payment hash have
+// been parked within the switch. The application resolves all parked HTLC's
+// at once by either calling Settle or Cancel. HTLC's paying to the same hash
+// which arrive after the resolution has been delivered, but before it has
+// been resolved, are added to the same set.
+type HoldResolution struct {
+	// PaymentHash is the payment hash that the parked HTLC's pay to.
+	PaymentHash chainhash.Hash
+
+	resolver *holdResolver
+}
+
+// HeldAmount returns the total amount of all HTLC's currently parked for the
+// payment hash.
+func (h *HoldResolution) HeldAmount() lnwire.MilliSatoshi {
+	h.resolver.Lock()
+	defer h.resolver.Unlock()
+
+	var total lnwire.MilliSatoshi
+	for _, htlc := range h.resolver.htlcs {
+		total += htlc.amount
+	}
+
+	return total
+}
+
+// NumHTLCs returns the number of HTLC's currently parked for the payment hash.
+func (h *HoldResolution) NumHTLCs() int {
+	h.resolver.Lock()
+	defer h.resolver.Unlock()
+
+	return len(h.resolver.htlcs)
+}
+
+// Settle settles all parked HTLC's using the passed preimage.
+func (h *HoldResolution) Settle(preimage [32]byte) error {
+	if sha256.Sum256(preimage[:]) != h.PaymentHash {
+		return ErrInvalidHoldPreimage
+	}
+
+	return h.resolver.s.resolveHold(h.PaymentHash, &preimage)
+}
+
+// Cancel fails back all parked HTLC's.
+func (h *HoldResolution) Cancel() error {
+	return h.resolver.s.resolveHold(h.PaymentHash, nil)
+}
+
+// heldHTLC is an incoming HTLC that has been parked within the switch awaiting
+// an external resolution.
+type heldHTLC struct {
+	link *channelLink
+
+	htlcIndex   uint64
+	amount      lnwire.MilliSatoshi
+	expiry      uint32
+	paymentHash chainhash.Hash
+	obfuscator  ErrorEncrypter
+
+	// parkHeight and parkTime are the best known height of the link, and
+	// the time, at which the HTLC was parked.
+	parkHeight uint32
+	parkTime   time.Time
+}
+
+// remainingTime returns an estimate of the time remaining until the HTLC is
+// automatically cancelled back due to nearing its expiry.
+func (h *heldHTLC) remainingTime() time.Duration {
+	cancelHeight := h.expiry - expiryGraceDelta
+	if cancelHeight <= h.parkHeight {
+		return 0
+	}
+
+	blocks := time.Duration(cancelHeight - h.parkHeight)
+	remaining := blocks*expectedBlockInterval - time.Since(h.parkTime)
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// heldHTLCResolution is sent to a channel link in order to settle or cancel
+// one of its parked HTLC's. A nil preimage signals a cancellation.
+type heldHTLCResolution struct {
+	htlcIndex uint64
+	preimage  *[32]byte
+}
+
+// holdResolver tracks the set of HTLC's parked for a single payment hash.
+type holdResolver struct {
+	sync.Mutex
+
+	s *Switch
+
+	hash  chainhash.Hash
+	htlcs []*heldHTLC
+
+	// kind denotes what resolves the parked HTLC's.
+	kind HoldResolverKind
+
+	// resolutions is the channel returned to the application. It's
+	// buffered by a single item so the switch never blocks on an
+	// application which has abandoned it, and is closed once the resolver
+	// has been resolved.
+	resolutions chan *HoldResolution
+	notified    bool
+}
+
+// RegisterHoldResolver registers the passed payment hash with the switch,
+// such that any HTLC's paying to it for which we're the exit hop are parked
+// rather than being settled against the invoice registry. Once the first HTLC
+// has been parked, a HoldResolution is sent over the returned channel which
+// the application uses to settle or cancel the parked HTLC's. If the HTLC's
+// near their expiry before being resolved, then they're cancelled back
+// automatically. In all cases, the returned channel is closed once the parked
+// HTLC's have been resolved. If the hash has already been registered, then the
+// existing channel is returned.
+func (s *Switch) RegisterHoldResolver(hash chainhash.Hash) <-chan *HoldResolution {
+	s.holdMtx.Lock()
+	defer s.holdMtx.Unlock()
+
+	if resolver, ok := s.holdResolvers[hash]; ok {
+		return resolver.resolutions
+	}
+
+	resolver := &holdResolver{
+		s:           s,
+		hash:        hash,
+		resolutions: make(chan *HoldResolution, 1),
+	}
+	s.holdResolvers[hash] = resolver
+
+	return resolver.resolutions
+}
+
+// holdHTLC parks the passed HTLC if a hold resolver has been registered for
+// its payment hash, or settles it if it's a late shard of a set which has
+// already been settled. Otherwise, false is returned, and the HTLC should be
+// processed as normal.
+func (s *Switch) holdHTLC(htlc *heldHTLC) bool {
+	s.holdMtx.Lock()
+	defer s.holdMtx.Unlock()
+
+	resolver, ok := s.holdResolvers[htlc.paymentHash]
+	if !ok {
+		return s.settleLateShard(htlc)
+	}
+
+	resolver.Lock()
+	defer resolver.Unlock()
+
+	htlc.parkTime = time.Now()
+	resolver.htlcs = append(resolver.htlcs, htlc)
+
+	// If this is the first HTLC for this hash, then we'll hand the
+	// resolution to the application. The channel is buffered, so this
+	// will never block.
+	if !resolver.notified {
+		resolver.notified = true
+		resolver.resolutions <- &HoldResolution{
+			PaymentHash: resolver.hash,
+			resolver:    resolver,
+		}
+	}
+
+	log.Infof("Parked htlc(%x) with index %v on ChannelPoint(%v) "+
+		"awaiting external resolution", htlc.paymentHash[:],
+		htlc.htlcIndex, htlc.link)
+
+	return true
+}
+
+// RemainingHoldTime returns an estimate of the time remaining before the HTLC's
+// parked for the passed payment hash are automatically cancelled back due to
+// nearing their expiry. If multiple HTLC's are parked, then the estimate
+// reflects the one closest to expiry. ErrNoHeldHTLCs is returned if no HTLC's
+// are currently parked for the hash.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) RemainingHoldTime(hash chainhash.Hash) (time.Duration, error) {
+	s.holdMtx.Lock()
+	resolver, ok := s.holdResolvers[hash]
+	s.holdMtx.Unlock()
+	if !ok {
+		return 0, ErrNoHeldHTLCs
+	}
+
+	resolver.Lock()
+	defer resolver.Unlock()
+
+	if len(resolver.htlcs) == 0 {
+		return 0, ErrNoHeldHTLCs
+	}
+
+	remaining := resolver.htlcs[0].remainingTime()
+	for _, htlc := range resolver.htlcs[1:] {
+		if r := htlc.remainingTime(); r < remaining {
+			remaining = r
+		}
+	}
+
+	return remaining, nil
+}
+
+// resolveHold removes the hold resolver for the passed payment hash, and
+// dispatches a settle or cancel (if the preimage is nil) to each of the links
+// owning a parked HTLC.
+func (s *Switch) resolveHold(hash chainhash.Hash, preimage *[32]byte) error {
+	s.holdMtx.Lock()
+	resolver, ok := s.holdResolvers[hash]
+	if ok {
+		delete(s.holdResolvers, hash)
+
+		// Any shards of a settled set which arrive late are settled
+		// using the same preimage.
+		if preimage != nil {
+			s.cacheSettledSet(hash, *preimage)
+		}
+	}
+	s.holdMtx.Unlock()
+	if !ok {
+		return ErrHoldResolved
+	}
+
+	resolver.Lock()
+	htlcs := resolver.htlcs
+	resolver.htlcs = nil
+	close(resolver.resolutions)
+	resolver.Unlock()
+
+	log.Infof("Resolving %v htlc(s) parked for hash(%x), settle=%v",
+		len(htlcs), hash[:], preimage != nil)
+
+	// We'll dispatch the resolutions asynchronously, as this may be
+	// called from within a link's goroutine.
+	for _, htlc := range htlcs {
+		go htlc.link.resolveHeldHTLC(&heldHTLCResolution{
+			htlcIndex: htlc.htlcIndex,
+			preimage:  preimage,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,380 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/lightningnetwork/lnd/chainntnfs"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
+)
+
+// holdTestPayment is a payment from Alice to Bob whose payment hash has been
+// registered with a hold resolver on Bob's switch.
+type holdTestPayment struct {
+	preimage    [32]byte
+	rhash       chainhash.Hash
+	htlcAmt     lnwire.MilliSatoshi
+	timelock    uint32
+	resolutions <-chan *HoldResolution
+	paymentErr  chan error
+}
+
+// sendHoldPayment registers a hold resolver on Bob's switch, then sends a
+// payment from Alice to Bob paying to the registered hash.
+func sendHoldPayment(t *testing.T, n *threeHopNetwork) *holdTestPayment {
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink)
+
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatalf("unable to generate route: %v", err)
+	}
+	invoice, htlc, err := generatePayment(amount, htlcAmt, totalTimelock,
+		blob)
+	if err != nil {
+		t.Fatalf("unable to generate payment: %v", err)
+	}
+
+	p := &holdTestPayment{
+		preimage:   invoice.Terms.PaymentPreimage,
+		rhash:      fastsha256.Sum256(invoice.Terms.PaymentPreimage[:]),
+		htlcAmt:    htlcAmt,
+		timelock:   totalTimelock,
+		paymentErr: make(chan error, 1),
+	}
+	p.resolutions = n.bobServer.htlcSwitch.RegisterHoldResolver(p.rhash)
+
+	go func() {
+		_, err := n.aliceServer.htlcSwitch.SendHTLC(
+			n.bobServer.PubKey(), htlc, newMockDeobfuscator(),
+		)
+		p.paymentErr <- err
+	}()
+
+	return p
+}
+
+// waitHoldResolution waits for the hold resolution to be delivered.
+func waitHoldResolution(t *testing.T, p *holdTestPayment) *HoldResolution {
+	select {
+	case resolution, ok := <-p.resolutions:
+		if !ok {
+			t.Fatalf("resolutions channel closed")
+		}
+		return resolution
+	case <-time.After(10 * time.Second):
+		t.Fatalf("hold resolution not received")
+	}
+
+	return nil
+}
+
+// assertResolutionsClosed asserts that the resolutions channel of the payment
+// has been closed.
+func assertResolutionsClosed(t *testing.T, p *holdTestPayment) {
+	select {
+	case _, ok := <-p.resolutions:
+		if ok {
+			t.Fatalf("expected resolutions channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("resolutions channel not closed")
+	}
+}
+
+// TestHoldResolverSettle ensures that an HTLC paying to a hash registered
+// with a hold resolver is parked until the application settles it.
+func TestHoldResolverSettle(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	p := sendHoldPayment(t, n)
+	resolution := waitHoldResolution(t, p)
+
+	if resolution.PaymentHash != p.rhash {
+		t.Fatalf("wrong payment hash: expected %v, got %v", p.rhash,
+			resolution.PaymentHash)
+	}
+	if resolution.NumHTLCs() != 1 {
+		t.Fatalf("expected 1 parked htlc, got %v",
+			resolution.NumHTLCs())
+	}
+	if resolution.HeldAmount() != p.htlcAmt {
+		t.Fatalf("wrong held amount: expected %v, got %v", p.htlcAmt,
+			resolution.HeldAmount())
+	}
+
+	// The payment shouldn't complete while the HTLC is parked.
+	select {
+	case err := <-p.paymentErr:
+		t.Fatalf("payment completed while htlc parked: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// An invalid preimage should be rejected.
+	if err := resolution.Settle([32]byte{}); err != ErrInvalidHoldPreimage {
+		t.Fatalf("expected ErrInvalidHoldPreimage, got %v", err)
+	}
+
+	if err := resolution.Settle(p.preimage); err != nil {
+		t.Fatalf("unable to settle: %v", err)
+	}
+	assertResolutionsClosed(t, p)
+
+	select {
+	case err := <-p.paymentErr:
+		if err != nil {
+			t.Fatalf("payment failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("payment not settled")
+	}
+
+	// Once resolved, the resolution can't be used again.
+	if err := resolution.Cancel(); err != ErrHoldResolved {
+		t.Fatalf("expected ErrHoldResolved, got %v", err)
+	}
+}
+
+// TestHoldResolverCancel ensures that a parked HTLC is failed back once the
+// application cancels its hold resolution.
+func TestHoldResolverCancel(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	p := sendHoldPayment(t, n)
+	resolution := waitHoldResolution(t, p)
+
+	if err := resolution.Cancel(); err != nil {
+		t.Fatalf("unable to cancel: %v", err)
+	}
+	assertResolutionsClosed(t, p)
+
+	select {
+	case err := <-p.paymentErr:
+		if err == nil {
+			t.Fatalf("payment should have failed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("payment not failed")
+	}
+}
+
+// TestHoldResolverExpiry ensures that a parked HTLC is cancelled back
+// automatically once it nears its expiry, even if the application never
+// resolves it.
+func TestHoldResolverExpiry(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	p := sendHoldPayment(t, n)
+	waitHoldResolution(t, p)
+
+	// We'll now notify Bob of a block that brings the parked HTLC within
+	// the expiry grace delta, which should cause it to be cancelled.
+	select {
+	case n.bobFirstBlockEpoch <- &chainntnfs.BlockEpoch{
+		Height: int32(p.timelock - expiryGraceDelta),
+	}:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("unable to notify block")
+	}
+
+	assertResolutionsClosed(t, p)
+
+	select {
+	case err := <-p.paymentErr:
+		if err == nil {
+			t.Fatalf("payment should have failed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("payment not failed")
+	}
+}
+
+// TestHoldResolverRemainingTime ensures that the remaining hold time of a
+// parked HTLC reflects the number of blocks until it's cancelled back, and
+// that an error is returned if no HTLC is parked for the hash.
+func TestHoldResolverRemainingTime(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	bobSwitch := n.bobServer.htlcSwitch
+
+	var unknownHash chainhash.Hash
+	_, err = bobSwitch.RemainingHoldTime(unknownHash)
+	if err != ErrNoHeldHTLCs {
+		t.Fatalf("expected ErrNoHeldHTLCs, got %v", err)
+	}
+
+	p := sendHoldPayment(t, n)
+	resolution := waitHoldResolution(t, p)
+
+	remaining, err := bobSwitch.RemainingHoldTime(p.rhash)
+	if err != nil {
+		t.Fatalf("unable to get remaining hold time: %v", err)
+	}
+
+	blocks := p.timelock - expiryGraceDelta - testStartingHeight
+	expected := time.Duration(blocks) * expectedBlockInterval
+	if remaining > expected || remaining < expected-time.Minute {
+		t.Fatalf("expected remaining hold time of ~%v, got %v",
+			expected, remaining)
+	}
+
+	// Once the HTLC has been resolved, it's no longer held.
+	if err := resolution.Cancel(); err != nil {
+		t.Fatalf("unable to cancel hold resolution: %v", err)
+	}
+	_, err = bobSwitch.RemainingHoldTime(p.rhash)
+	if err != ErrNoHeldHTLCs {
+		t.Fatalf("expected ErrNoHeldHTLCs, got %v", err)
+	}
+}
+
+// TestHoldResolverInvalidPayload ensures that an HTLC paying to a hash
+// registered with a hold resolver is failed back, rather than parked, if its
+// hop-payload doesn't match the HTLC.
+func TestHoldResolverInvalidPayload(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	tests := []struct {
+		name     string
+		corrupt  func(*ForwardingInfo)
+		expected lnwire.FailCode
+	}{
+		{
+			name: "amount",
+			corrupt: func(hop *ForwardingInfo) {
+				hop.AmountToForward *= 2
+			},
+			expected: lnwire.CodeFinalIncorrectHtlcAmount,
+		},
+		{
+			name: "time-lock",
+			corrupt: func(hop *ForwardingInfo) {
+				hop.OutgoingCTLV = 500
+			},
+			expected: lnwire.CodeFinalIncorrectCltvExpiry,
+		},
+	}
+
+	for i, test := range tests {
+		amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+		htlcAmt, totalTimelock, hops := generateHops(amount,
+			testStartingHeight, n.firstBobChannelLink)
+		test.corrupt(&hops[0])
+
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("%v: unable to generate route: %v", test.name,
+				err)
+		}
+		_, htlc, err := generatePayment(amount, htlcAmt,
+			totalTimelock, blob)
+		if err != nil {
+			t.Fatalf("%v: unable to generate payment: %v",
+				test.name, err)
+		}
+		htlc.PaymentHash = fastsha256.Sum256([]byte{byte(i)})
+
+		resolutions := n.bobServer.htlcSwitch.RegisterHoldResolver(
+			htlc.PaymentHash,
+		)
+
+		_, err = n.aliceServer.htlcSwitch.SendHTLC(
+			n.bobServer.PubKey(), htlc, newMockDeobfuscator(),
+		)
+		ferr, ok := err.(*ForwardingError)
+		if !ok {
+			t.Fatalf("%v: expected a ForwardingError, got: %T: %v",
+				test.name, err, err)
+		}
+		if ferr.FailureMessage.Code() != test.expected {
+			t.Fatalf("%v: expected %v, got %v", test.name,
+				test.expected, ferr.FailureMessage.Code())
+		}
+
+		select {
+		case <-resolutions:
+			t.Fatalf("%v: htlc with invalid payload was parked",
+				test.name)
+		default:
+		}
+	}
+}
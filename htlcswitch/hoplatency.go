@@ -0,0 +1,108 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// spanTime returns the time of the first span of the passed stage within the
+// trace, and whether such a span was recorded.
+func (c *CircuitTrace) spanTime(stage TraceStage) (time.Time, bool) {
+	for _, span := range c.Spans {
+		if span.Stage == stage {
+			return span.Timestamp, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// ForwardLatency returns the time our node took to forward the HTLC, from
+// the time the incoming HTLC was locked in until the outgoing add was sent.
+// False is returned if the HTLC wasn't forwarded.
+func (c *CircuitTrace) ForwardLatency() (time.Duration, bool) {
+	received, ok := c.spanTime(TraceReceived)
+	if !ok {
+		return 0, false
+	}
+	sent, ok := c.spanTime(TraceOutgoingAddSent)
+	if !ok {
+		return 0, false
+	}
+
+	return sent.Sub(received), true
+}
+
+// ResolutionLatency returns the time our node took to propagate the
+// resolution of the HTLC, from the time the settle or fail was received from
+// the downstream peer until it was handed to the incoming link. False is
+// returned if the HTLC wasn't resolved by the downstream peer.
+func (c *CircuitTrace) ResolutionLatency() (time.Duration, bool) {
+	downstream, ok := c.spanTime(TraceDownstreamResolved)
+	if !ok {
+		return 0, false
+	}
+	resolved, ok := c.spanTime(TraceResolved)
+	if !ok {
+		return 0, false
+	}
+
+	return resolved.Sub(downstream), true
+}
+
+// AddedLatency returns the total latency our node added to the HTLC, being
+// the sum of its ForwardLatency and ResolutionLatency. This excludes the time
+// spent waiting on either peer, or on the network. False is returned unless
+// both latencies are known.
+func (c *CircuitTrace) AddedLatency() (time.Duration, bool) {
+	forward, ok := c.ForwardLatency()
+	if !ok {
+		return 0, false
+	}
+	resolution, ok := c.ResolutionLatency()
+	if !ok {
+		return 0, false
+	}
+
+	return forward + resolution, true
+}
+
+// hopLatencySnapshot returns the distribution of the latency our node added
+// to the completed traces of HTLC's forwarded from the passed incoming
+// channel.
+func (c *circuitTracer) hopLatencySnapshot(
+	chanID lnwire.ShortChannelID) WaitTimeHistogram {
+
+	c.Lock()
+	recorder, ok := c.hopLatency[chanID]
+	c.Unlock()
+
+	if !ok {
+		return newWaitTimeRecorder().Snapshot()
+	}
+
+	return recorder.Snapshot()
+}
+
+// traceDownstreamResolved records a TraceDownstreamResolved span for the
+// forwarded HTLC with the passed index, once the downstream peer has settled
+// or failed it.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) traceDownstreamResolved(htlcIndex uint64) {
+	tracer := l.cfg.Switch.tracer
+	if !tracer.isEnabled() {
+		return
+	}
+
+	circuit := l.cfg.Switch.circuits.LookupByHTLC(l.ShortChanID(), htlcIndex)
+	if circuit == nil {
+		return
+	}
+
+	tracer.record(
+		circuit.IncomingChanID, circuit.IncomingHTLCID,
+		TraceDownstreamResolved,
+	)
+}
@@ -0,0 +1,84 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchHopLatency tests that the trace of an HTLC forwarded by Bob from
+// Alice to Carol attributes the latency Bob added to the payment, and that it
+// is aggregated into the stats of Bob's incoming link.
+func TestSwitchHopLatency(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	bobSwitch := n.bobServer.htlcSwitch
+	sub := bobSwitch.SubscribeTraces()
+	defer sub.Cancel()
+
+	bobSwitch.EnableTracing()
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	var trace *CircuitTrace
+	select {
+	case trace = <-sub.Traces:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("trace not received")
+	}
+
+	forward, ok := trace.ForwardLatency()
+	if !ok || forward <= 0 {
+		t.Fatalf("expected positive forward latency, got %v (ok=%v)",
+			forward, ok)
+	}
+	resolution, ok := trace.ResolutionLatency()
+	if !ok || resolution <= 0 {
+		t.Fatalf("expected positive resolution latency, got %v "+
+			"(ok=%v)", resolution, ok)
+	}
+	added, ok := trace.AddedLatency()
+	if !ok || added != forward+resolution {
+		t.Fatalf("expected added latency of %v, got %v (ok=%v)",
+			forward+resolution, added, ok)
+	}
+
+	// The latency should be attributed to Bob's link with Alice, over
+	// which the HTLC was received, and not to his link with Carol.
+	hopLatency := n.firstBobChannelLink.StatsDetail().HopLatency
+	if hopLatency.Count != 1 {
+		t.Fatalf("expected 1 hop latency sample, got %v",
+			hopLatency.Count)
+	}
+	hopLatency = n.secondBobChannelLink.StatsDetail().HopLatency
+	if hopLatency.Count != 0 {
+		t.Fatalf("expected no hop latency samples, got %v",
+			hopLatency.Count)
+	}
+}
@@ -0,0 +1,43 @@
+package htlcswitch
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// exceedsMaxValueInFlight returns true if offering an HTLC of the passed
+// amount would push the total value of the HTLC's we have in flight past the
+// MaxValueInFlight of the link's forwarding policy.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) exceedsMaxValueInFlight(amt lnwire.MilliSatoshi) bool {
+	maxInFlight := l.cfg.FwrdingPolicy.MaxValueInFlight
+	if maxInFlight == 0 {
+		return false
+	}
+
+	inFlight := l.channel.OutgoingValueInFlight()
+	if inFlight+amt <= maxInFlight {
+		return false
+	}
+
+	log.Infof("ChannelLink(%v) rejecting downstream htlc of %v, value "+
+		"in flight of %v would exceed cap of %v", l, amt, inFlight,
+		maxInFlight)
+
+	return true
+}
+
+// validateMaxValueInFlight ensures that the MaxValueInFlight of the passed
+// policy doesn't exceed the maximum negotiated for the link's channel, which
+// is enforced regardless.
+func (l *channelLink) validateMaxValueInFlight(policy ForwardingPolicy) error {
+	negotiated := l.channel.State().LocalChanCfg.MaxPendingAmount
+	if policy.MaxValueInFlight > negotiated {
+		return errors.Errorf("max value in flight of %v exceeds "+
+			"negotiated maximum of %v", policy.MaxValueInFlight,
+			negotiated)
+	}
+
+	return nil
+}
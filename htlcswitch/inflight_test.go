@@ -0,0 +1,83 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkMaxValueInFlight tests that a link doesn't offer HTLC's which
+// would push its value in flight past the cap of its forwarding policy, and
+// that caps exceeding the channel's negotiated maximum are rejected.
+func TestChannelLinkMaxValueInFlight(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// A cap above the negotiated maximum should be rejected.
+	negotiated := aliceLink.channel.State().LocalChanCfg.MaxPendingAmount
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: ForwardingPolicy{MaxValueInFlight: negotiated + 1},
+	})
+	if err == nil {
+		t.Fatalf("expected cap above negotiated maximum to be rejected")
+	}
+
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: ForwardingPolicy{MaxValueInFlight: htlcAmt * 3 / 2},
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+
+	var mockBlob [lnwire.OnionPacketSize]byte
+	sendHtlc := func() {
+		_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+	}
+
+	// The first HTLC fits within the cap, and should be offered to Bob.
+	sendHtlc()
+	select {
+	case msg := <-aliceMsgs:
+		if _, ok := msg.(*lnwire.UpdateAddHTLC); !ok {
+			t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("htlc was not offered")
+	}
+
+	state := aliceLink.CommitmentState()
+	if state.ValueInFlight != htlcAmt {
+		t.Fatalf("expected value in flight %v, got %v", htlcAmt,
+			state.ValueInFlight)
+	}
+
+	// The second would exceed the cap, so it shouldn't be offered.
+	sendHtlc()
+	select {
+	case msg := <-aliceMsgs:
+		t.Fatalf("expected no message, got %T", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	state = aliceLink.CommitmentState()
+	if state.ValueInFlight != htlcAmt {
+		t.Fatalf("expected value in flight %v, got %v", htlcAmt,
+			state.ValueInFlight)
+	}
+}
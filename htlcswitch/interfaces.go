@@ -1,6 +1,9 @@
 package htlcswitch
 
 import (
+	"context"
+	"time"
+
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/roasbeef/btcd/chaincfg/chainhash"
@@ -17,6 +20,20 @@ type InvoiceDatabase interface {
 	// SettleInvoice attempts to mark an invoice corresponding to the
 	// passed payment hash as fully settled.
 	SettleInvoice(chainhash.Hash) error
+
+	// BatchSettleInvoice attempts to mark each of the invoices
+	// corresponding to the passed settle requests as fully settled. The
+	// returned slice holds the outcome of each request, in the order they
+	// were passed, such that a single failure doesn't abort the batch.
+	// Settling an invoice which has already been settled is a no-op.
+	BatchSettleInvoice([]SettleRequest) []error
+}
+
+// SettleRequest is a request to settle the invoice paid to by an exit hop
+// HTLC, which is submitted to the InvoiceDatabase as part of a batch.
+type SettleRequest struct {
+	// PaymentHash is the payment hash of the invoice to be settled.
+	PaymentHash chainhash.Hash
 }
 
 // ChannelLink is an interface which represents the subsystem for managing the
@@ -76,6 +93,28 @@ type ChannelLink interface {
 	// policy to govern if it an incoming HTLC should be forwarded or not.
 	UpdateForwardingPolicy(ForwardingPolicy)
 
+	// UpdateDirectionalPolicy updates both the forwarding policy and the
+	// inbound fee of the target ChannelLink. An error is returned if the
+	// resulting inbound discount could render a forward unprofitable.
+	UpdateDirectionalPolicy(DirectionalPolicy) error
+
+	// CurrentForwardingPolicy returns the forwarding policy currently
+	// applied by the link, along with its inbound fee.
+	CurrentForwardingPolicy() DirectionalPolicy
+
+	// LastGossipedPolicy returns the forwarding policy last gossiped for
+	// the link's channel, along with the time it was gossiped.
+	LastGossipedPolicy() GossipedPolicy
+
+	// DisabledChannelUpdate returns the link's latest channel update with
+	// the disabled flag set, to be attached to the failures of forwards
+	// declined as though the channel were disabled.
+	DisabledChannelUpdate() (*lnwire.ChannelUpdate, error)
+
+	// ChannelRole returns the role of the channel, which restricts the
+	// direction in which it may be used by forwarded HTLC's.
+	ChannelRole() ChannelRole
+
 	// Bandwidth returns the amount of milli-satoshis which current link
 	// might pass through channel link. The value returned from this method
 	// represents the up to date available flow through the channel. This
@@ -83,10 +122,86 @@ type ChannelLink interface {
 	// HTLC's which have been set to the over flow queue.
 	Bandwidth() lnwire.MilliSatoshi
 
+	// LocalBandwidth returns the amount of milli-satoshis which current
+	// link might pass through channel link for locally initiated HTLC's.
+	// Unlike Bandwidth, this includes the link's soft reserve.
+	LocalBandwidth() lnwire.MilliSatoshi
+
+	// BandwidthDetail returns the liquidity currently available in either
+	// direction of the link, net of the reserves of both parties.
+	BandwidthDetail() BandwidthDetail
+
+	// ReserveInfo returns the channel reserves of both parties, along
+	// with how much of our balance is currently locked by our reserve.
+	ReserveInfo() ReserveInfo
+
+	// CanAddHTLC returns true if a locally initiated HTLC of the passed
+	// amount could currently be added to the link. Otherwise, an error
+	// describing the first constraint the HTLC would violate is returned.
+	CanAddHTLC(amt lnwire.MilliSatoshi) (bool, error)
+
+	// HasFreeSlot returns true if the link's commitment transaction is
+	// able to accommodate another outgoing HTLC.
+	HasFreeSlot() bool
+
+	// MaxForwardableHTLC returns the largest single HTLC the link would
+	// currently accept as a forward.
+	MaxForwardableHTLC() lnwire.MilliSatoshi
+
+	// QuoteFirstHop returns the link's bandwidth, maximum HTLC, fee and
+	// CLTV delta for an HTLC of the passed amount, along with whether
+	// such an HTLC could currently be sent over it, all taken from a
+	// single snapshot of the link's state.
+	QuoteFirstHop(amt lnwire.MilliSatoshi) FirstHopQuote
+
 	// Stats return the statistics of channel link. Number of updates,
 	// total sent/received milli-satoshis.
 	Stats() (uint64, lnwire.MilliSatoshi, lnwire.MilliSatoshi)
 
+	// CommitmentState returns the current fee and dust parameters of the
+	// link's commitment transactions, including the dust threshold under
+	// the configured stress test fee rate.
+	CommitmentState() CommitmentState
+
+	// AtRiskExposure returns the total value of the non-dust HTLC's on
+	// our current commitment transaction, which would each need to be
+	// resolved on-chain were the channel force closed now.
+	AtRiskExposure() lnwire.MilliSatoshi
+
+	// SubscribeCommitments returns a new subscription which delivers a
+	// snapshot of each commitment of the link's channel as it's
+	// established, both ours and that of the remote party.
+	SubscribeCommitments() *CommitmentSubscription
+
+	// CommitmentType returns the format of the link's commitment
+	// transactions.
+	CommitmentType() CommitmentType
+
+	// CommitHeights returns the current height of the local and remote
+	// commitment chains, along with the height of the next remote
+	// commitment we're awaiting a revocation for. If the two chains are in
+	// sync, then this is the height of the current remote commitment.
+	CommitHeights() (local, remote, pendingRevocation uint64)
+
+	// StatsDetail returns a detailed set of statistics gathered by the
+	// link over its lifetime, including the distribution of the time
+	// HTLC's spent waiting within the overflow queue.
+	StatsDetail() *LinkStatsDetail
+
+	// StatsSnapshot returns an operational snapshot of the link's
+	// activity, including the time it was started, its last forward and
+	// settle, and its number of pending HTLC's and failures.
+	StatsSnapshot() *LinkStatsSnapshot
+
+	// ConsecutiveFailures returns the number of HTLC's forwarded over the
+	// link which the downstream peer has failed since it last settled
+	// one, or since the counter was last reset.
+	ConsecutiveFailures() int
+
+	// ResetFailureCounter resets the link's count of consecutive
+	// downstream failures.
+	ResetFailureCounter()
+
 	// Peer returns the representation of remote peer with which we have
 	// the channel link opened.
 	Peer() Peer
@@ -98,6 +213,56 @@ type ChannelLink interface {
 	// will use this function in forwarding decisions accordingly.
 	EligibleToForward() bool
 
+	// IneligibleReason returns the reason the channel isn't currently
+	// eligible to forward HTLC's, or IneligibleNone if it is.
+	IneligibleReason() IneligibleReason
+
+	// WarmedUp returns a channel which is closed once the link has ended
+	// its warm-up period following a reconnection.
+	WarmedUp() <-chan struct{}
+
+	// ClearHTLCsForClose instructs the link to stop accepting any new
+	// HTLC's, and to wait until all outstanding HTLC's on the channel have
+	// been resolved. Once the commitment transactions are free of HTLC's,
+	// the method returns nil, signalling that the channel is ready to
+	// begin cooperative close negotiation. If the passed context expires
+	// before the channel has been cleared, then ErrLinkClearTimeout is
+	// returned, and the channel should be force closed instead.
+	ClearHTLCsForClose(ctx context.Context) error
+
+	// CancelClearHTLCs has the link resume accepting new HTLC's after an
+	// attempt to clear it for close has been abandoned.
+	CancelClearHTLCs()
+
+	// MarkPendingClose marks the channel as pending force close, rendering
+	// the link ineligible to forward. Any new HTLC's are failed back with
+	// a permanent channel failure, while outstanding HTLC's are left to
+	// be resolved on-chain.
+	MarkPendingClose()
+
+	// Quiesce negotiates quiescence (stfu) with the remote peer, stopping
+	// the link from accepting any new HTLC's. Once neither party has any
+	// pending updates and both have sent stfu, a token is returned which
+	// must later be passed to Resume. If the channel fails to quiesce
+	// before the passed context expires, then ErrQuiescenceTimeout is
+	// returned.
+	Quiesce(ctx context.Context) (QuiescenceToken, error)
+
+	// Resume exits the quiescent session identified by the passed token,
+	// allowing the link to once again send and accept updates.
+	Resume(token QuiescenceToken) error
+
+	// SignCommitmentNow signs and sends a new commitment covering all
+	// pending updates right away, rather than waiting for the link's
+	// batching heuristics. If a commitment is already awaiting its
+	// revocation, then the updates are signed once it's received.
+	SignCommitmentNow() error
+
+	// Heartbeat asks the link's main goroutine to acknowledge that it's
+	// still processing requests, closing the returned channel once it
+	// has.
+	Heartbeat() <-chan struct{}
+
 	// Start/Stop are used to initiate the start/stop of the channel link
 	// functioning.
 	Start() error
@@ -118,6 +283,12 @@ type Peer interface {
 	PubKey() [33]byte
 
 	// Disconnect disconnects with peer if we have error which we can't
-	// properly handle.
-	Disconnect(reason error)
+	// properly handle. The reason describes the cause of the
+	// disconnection, while the error carries the details.
+	Disconnect(reason DisconnectReason, err error)
+
+	// PingLatency returns the round trip time of the most recent ping to
+	// the peer, or the time our latest ping has been outstanding for if
+	// that's longer.
+	PingLatency() time.Duration
 }
@@ -0,0 +1,148 @@
+package htlcswitch
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// MaxForwardResolutionJitter is the upper bound on the jitter applied before
+// propagating the settle or fail of a forwarded HTLC back to its incoming
+// channel. The incoming HTLC remains on our commitment in the meantime, so the
+// jitter must stay small.
+const MaxForwardResolutionJitter = 2 * time.Second
+
+// jitterBudgetDivisor bounds the jitter applied to a resolution to this
+// fraction of the time remaining until the incoming HTLC's expiry, net of the
+// grace delta, such that the jitter never erodes the margin we need to
+// resolve the HTLC before the remote peer is able to time it out.
+const jitterBudgetDivisor = 100
+
+// resolutionJitter accumulates the jitter applied to the resolutions
+// propagated over a link, such that its mean can be reported.
+type resolutionJitter struct {
+	// total is the sum of the jitter applied, in nanoseconds.
+	//
+	// NOTE: This MUST be used atomically.
+	total int64
+
+	// count is the number of resolutions the jitter was drawn for.
+	//
+	// NOTE: This MUST be used atomically.
+	count uint64
+}
+
+// record accounts for the jitter applied to a single resolution.
+func (j *resolutionJitter) record(jitter time.Duration) {
+	atomic.AddInt64(&j.total, int64(jitter))
+	atomic.AddUint64(&j.count, 1)
+}
+
+// mean returns the mean jitter applied to resolutions, or zero if none have
+// been jittered.
+func (j *resolutionJitter) mean() time.Duration {
+	count := atomic.LoadUint64(&j.count)
+	if count == 0 {
+		return 0
+	}
+
+	return time.Duration(atomic.LoadInt64(&j.total) / int64(count))
+}
+
+// jitteredResolution is sent to a channel link once the jitter of a settle or
+// fail has elapsed, in order to propagate it to the remote peer.
+type jitteredResolution struct {
+	pkt *htlcPacket
+}
+
+// maxResolutionJitter returns the upper bound on the jitter to apply to the
+// resolution of an incoming HTLC with the passed expiry at the passed height.
+// The passed maxJitter is capped to MaxForwardResolutionJitter, and to a
+// fraction of the time remaining until the HTLC's expiry, net of the grace
+// delta. Zero is returned once the HTLC is within its grace period.
+func maxResolutionJitter(maxJitter time.Duration, expiry,
+	height uint32) time.Duration {
+
+	if maxJitter > MaxForwardResolutionJitter {
+		maxJitter = MaxForwardResolutionJitter
+	}
+
+	if expiry <= height+expiryGraceDelta {
+		return 0
+	}
+	blocks := time.Duration(expiry - height - expiryGraceDelta)
+	budget := blocks * expectedBlockInterval / jitterBudgetDivisor
+	if budget < maxJitter {
+		maxJitter = budget
+	}
+
+	return maxJitter
+}
+
+// incomingExpiry returns the expiry of the active incoming HTLC with the
+// passed index. False is returned if no such HTLC is found.
+func (l *channelLink) incomingExpiry(htlcIndex uint64) (uint32, bool) {
+	for _, htlc := range l.channel.ActiveHtlcs() {
+		if htlc.Incoming && htlc.HtlcIndex == htlcIndex {
+			return htlc.RefundTimeout, true
+		}
+	}
+
+	return 0, false
+}
+
+// resolutionJitter draws the jitter to apply to the passed settle or fail.
+// If the incoming HTLC can't be found, then no jitter is applied.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) resolutionJitter(pkt *htlcPacket) time.Duration {
+	expiry, ok := l.incomingExpiry(pkt.incomingHTLCID)
+	if !ok {
+		return 0
+	}
+
+	return randomDelay(0, maxResolutionJitter(
+		l.cfg.ForwardResolutionJitter, expiry, l.bestHeight,
+	))
+}
+
+// jitterResolution delays the propagation of the passed settle or fail by the
+// jitter drawn for it, after which it's handed back to the htlcManager. True
+// is returned if the packet was delayed, in which case the caller must not
+// handle it any further. Replayed packets are never delayed, and packets lost
+// to a shutdown while waiting are replayed by the switch upon restart.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) jitterResolution(pkt *htlcPacket) bool {
+	if l.cfg.ForwardResolutionJitter == 0 || pkt.isReplay || pkt.jittered {
+		return false
+	}
+
+	switch pkt.htlc.(type) {
+	case *lnwire.UpdateFulfillHTLC, *lnwire.UpdateFailHTLC:
+	default:
+		return false
+	}
+
+	pkt.jittered = true
+
+	jitter := l.resolutionJitter(pkt)
+	l.fwdJitter.record(jitter)
+	if jitter == 0 {
+		return false
+	}
+
+	log.Debugf("ChannelLink(%v) delaying resolution of htlc=%v by %v", l,
+		pkt.incomingHTLCID, jitter)
+
+	req := &jitteredResolution{pkt: pkt}
+	time.AfterFunc(jitter, func() {
+		select {
+		case l.linkControl <- req:
+		case <-l.quit:
+		}
+	})
+
+	return true
+}
@@ -0,0 +1,101 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMaxResolutionJitter tests that the jitter applied to the resolution of
+// a forwarded HTLC is bounded by the link's ForwardResolutionJitter and
+// MaxForwardResolutionJitter, and never exceeds the fraction of the time
+// remaining until the incoming HTLC's expiry set aside for it.
+func TestMaxResolutionJitter(t *testing.T) {
+	t.Parallel()
+
+	const height = 1000
+
+	tests := []struct {
+		name      string
+		maxJitter time.Duration
+		expiry    uint32
+		expected  time.Duration
+	}{
+		{
+			name:      "disabled",
+			maxJitter: 0,
+			expiry:    height + 144,
+			expected:  0,
+		},
+		{
+			name:      "configured bound",
+			maxJitter: 500 * time.Millisecond,
+			expiry:    height + 144,
+			expected:  500 * time.Millisecond,
+		},
+		{
+			name:      "capped bound",
+			maxJitter: time.Minute,
+			expiry:    height + 144,
+			expected:  MaxForwardResolutionJitter,
+		},
+		{
+			name:      "within grace period",
+			maxJitter: time.Second,
+			expiry:    height + expiryGraceDelta,
+			expected:  0,
+		},
+		{
+			name:      "expired",
+			maxJitter: time.Second,
+			expiry:    height - 1,
+			expected:  0,
+		},
+	}
+
+	for _, test := range tests {
+		bound := maxResolutionJitter(test.maxJitter, test.expiry, height)
+		if bound != test.expected {
+			t.Fatalf("%v: expected bound %v, got %v", test.name,
+				test.expected, bound)
+		}
+
+		// Every jitter drawn must lie within the bound.
+		for i := 0; i < 100; i++ {
+			jitter := randomDelay(0, bound)
+			if jitter < 0 || jitter > bound {
+				t.Fatalf("%v: jitter %v outside of [0, %v]",
+					test.name, jitter, bound)
+			}
+		}
+	}
+
+	// Regardless of the configured jitter, the bound must never exceed
+	// the fraction of the time remaining until expiry, net of the grace
+	// delta, which is set aside for it.
+	for expiry := uint32(height); expiry < height+50; expiry++ {
+		bound := maxResolutionJitter(time.Hour, expiry, height)
+
+		var budget time.Duration
+		if expiry > height+expiryGraceDelta {
+			blocks := time.Duration(expiry - height - expiryGraceDelta)
+			budget = blocks * expectedBlockInterval /
+				jitterBudgetDivisor
+		}
+		if bound > budget {
+			t.Fatalf("bound %v at expiry %v exceeds budget %v",
+				bound, expiry, budget)
+		}
+	}
+
+	// Finally, the mean jitter should reflect the jitter recorded.
+	var jitter resolutionJitter
+	if jitter.mean() != 0 {
+		t.Fatalf("expected no mean jitter, got %v", jitter.mean())
+	}
+	jitter.record(100 * time.Millisecond)
+	jitter.record(300 * time.Millisecond)
+	if jitter.mean() != 200*time.Millisecond {
+		t.Fatalf("expected mean jitter of %v, got %v",
+			200*time.Millisecond, jitter.mean())
+	}
+}
@@ -0,0 +1,79 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+// DefaultSettledSetLifetime is the default duration for which the preimage of
+// a set of parked HTLC's is retained once the set has been settled, in order
+// to settle any late arriving shards of the set.
+const DefaultSettledSetLifetime = 10 * time.Minute
+
+// settledSet is the preimage of a set of parked HTLC's which has been
+// settled, retained until its expiry in order to settle late shards.
+type settledSet struct {
+	preimage [32]byte
+	expiry   time.Time
+}
+
+// settledSetLifetime returns the duration for which the preimage of a settled
+// set is retained.
+func (s *Switch) settledSetLifetime() time.Duration {
+	if s.cfg.SettledSetLifetime == 0 {
+		return DefaultSettledSetLifetime
+	}
+
+	return s.cfg.SettledSetLifetime
+}
+
+// cacheSettledSet retains the preimage of the settled set of HTLC's paying to
+// the passed hash, pruning those of any sets which have since expired.
+//
+// NOTE: The holdMtx MUST be held when calling this method.
+func (s *Switch) cacheSettledSet(hash chainhash.Hash, preimage [32]byte) {
+	now := time.Now()
+	for setHash, set := range s.settledSets {
+		if !now.Before(set.expiry) {
+			delete(s.settledSets, setHash)
+		}
+	}
+
+	s.settledSets[hash] = &settledSet{
+		preimage: preimage,
+		expiry:   now.Add(s.settledSetLifetime()),
+	}
+}
+
+// settleLateShard settles the passed HTLC right away if it's a late shard of
+// a set which has already been settled, using the set's retained preimage.
+// The HTLC is parked with its link for the settle to be delivered, as if it
+// had arrived before the set was settled. If no unexpired preimage is
+// retained for its payment hash, then false is returned, and the HTLC should
+// be processed as normal.
+//
+// NOTE: The holdMtx MUST be held when calling this method.
+func (s *Switch) settleLateShard(htlc *heldHTLC) bool {
+	set, ok := s.settledSets[htlc.paymentHash]
+	if !ok {
+		return false
+	}
+	if !time.Now().Before(set.expiry) {
+		delete(s.settledSets, htlc.paymentHash)
+		return false
+	}
+
+	log.Infof("Settling late htlc(%x) with index %v on ChannelPoint(%v) "+
+		"of an already settled set", htlc.paymentHash[:],
+		htlc.htlcIndex, htlc.link)
+
+	htlc.parkTime = time.Now()
+	preimage := set.preimage
+	go htlc.link.resolveHeldHTLC(&heldHTLCResolution{
+		htlcIndex: htlc.htlcIndex,
+		preimage:  &preimage,
+	})
+
+	return true
+}
@@ -0,0 +1,119 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestHoldResolverLateShard tests that a shard of a set of parked HTLC's which
+// arrives after the set has been settled is settled right away using the
+// set's preimage, and that the preimage is only retained for the
+// SettledSetLifetime.
+func TestHoldResolverLateShard(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	bobSwitch := n.bobServer.htlcSwitch
+
+	// The first shard is parked, and settled by the application.
+	p := sendHoldPayment(t, n)
+	resolution := waitHoldResolution(t, p)
+	if err := resolution.Settle(p.preimage); err != nil {
+		t.Fatalf("unable to settle: %v", err)
+	}
+	select {
+	case err := <-p.paymentErr:
+		if err != nil {
+			t.Fatalf("payment failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("payment not settled")
+	}
+
+	// sendLateShard sends another shard paying to the hash of the settled
+	// set, returning the preimage it's settled with.
+	sendLateShard := func() ([32]byte, error) {
+		amount := lnwire.NewMSatFromSatoshis(10000)
+		htlcAmt, totalTimelock, hops := generateHops(amount,
+			testStartingHeight, n.firstBobChannelLink)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to generate route: %v", err)
+		}
+		_, htlc, err := generatePayment(amount, htlcAmt,
+			totalTimelock, blob)
+		if err != nil {
+			t.Fatalf("unable to generate payment: %v", err)
+		}
+		htlc.PaymentHash = p.rhash
+
+		type result struct {
+			preimage [32]byte
+			err      error
+		}
+		results := make(chan result, 1)
+		go func() {
+			preimage, err := n.aliceServer.htlcSwitch.SendHTLC(
+				n.bobServer.PubKey(), htlc,
+				newMockDeobfuscator(),
+			)
+			results <- result{preimage, err}
+		}()
+
+		select {
+		case res := <-results:
+			return res.preimage, res.err
+		case <-time.After(10 * time.Second):
+			t.Fatalf("late shard not resolved")
+		}
+		return [32]byte{}, nil
+	}
+
+	// The late shard is settled with the set's preimage, without a new
+	// resolution being handed to the application.
+	preimage, err := sendLateShard()
+	if err != nil {
+		t.Fatalf("late shard failed: %v", err)
+	}
+	if preimage != p.preimage {
+		t.Fatalf("expected preimage %x, got %x", p.preimage[:],
+			preimage[:])
+	}
+	if sets := bobSwitch.HeldHTLCs(); len(sets) != 0 {
+		t.Fatalf("expected no held htlc sets, got %v", len(sets))
+	}
+
+	// Once the preimage has expired, a late shard is processed as
+	// normal, and as Bob has no invoice for the hash, it's failed.
+	bobSwitch.holdMtx.Lock()
+	bobSwitch.settledSets[p.rhash].expiry = time.Now()
+	bobSwitch.holdMtx.Unlock()
+
+	if _, err := sendLateShard(); err == nil {
+		t.Fatalf("late shard settled after preimage expired")
+	}
+
+	bobSwitch.holdMtx.Lock()
+	_, ok := bobSwitch.settledSets[p.rhash]
+	bobSwitch.holdMtx.Unlock()
+	if ok {
+		t.Fatalf("expired preimage wasn't pruned")
+	}
+}
@@ -2,6 +2,7 @@ package htlcswitch
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -18,6 +19,7 @@ import (
 	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
 )
 
 const (
@@ -30,8 +32,138 @@ const (
 	//
 	// TODO(roasbeef): must be < default delta
 	expiryGraceDelta = 2
+
+	// DefaultDustStressHeadroom is the default increase in the commitment
+	// fee rate, expressed in sat/vbyte, under which forwarded HTLC's are
+	// checked to remain above the dust threshold when RejectStressDust is
+	// set.
+	DefaultDustStressHeadroom lnwallet.SatPerVByte = 50
+
+	// DefaultMaxExtraCltv is the default number of blocks by which the
+	// outgoing time-lock of a forwarded HTLC may exceed the current height
+	// plus our time-lock delta. It's roughly two weeks' worth of blocks,
+	// well beyond what any reasonable route requires.
+	DefaultMaxExtraCltv = 2016
+)
+
+var (
+	// ErrLinkClearTimeout is returned by ClearHTLCsForClose if the link
+	// was unable to resolve all of its outstanding HTLC's before the
+	// passed deadline expired.
+	ErrLinkClearTimeout = errors.New("unable to clear htlcs before " +
+		"deadline, channel should be force closed")
+)
+
+// IneligibleReason describes why a link isn't currently eligible to forward
+// HTLC's.
+type IneligibleReason uint8
+
+const (
+	// IneligibleNone indicates that the link is eligible to forward.
+	IneligibleNone IneligibleReason = iota
+
+	// IneligibleNoRevocation indicates that we don't yet have the remote
+	// party's next revocation point, so are unable to extend their
+	// commitment chain.
+	IneligibleNoRevocation
+
+	// IneligibleClearing indicates that the link is clearing its HTLC's
+	// in preparation for a cooperative close.
+	IneligibleClearing
+
+	// IneligibleQuiescing indicates that the link is negotiating
+	// quiescence, or is quiescent.
+	IneligibleQuiescing
+
+	// IneligibleWarmingUp indicates that the link has recently started,
+	// and has yet to confirm that its state is in sync with the remote
+	// peer. A link which is only warming up is otherwise able to forward.
+	IneligibleWarmingUp
+
+	// IneligibleStateMismatch indicates that the commitment state reported
+	// by the remote peer upon reestablishment diverged from our own, so
+	// the link refuses to forward or sign any further updates.
+	IneligibleStateMismatch
+
+	// IneligibleClosing indicates that a force close of the channel has
+	// been initiated, so no new HTLC's are accepted, while outstanding
+	// ones are resolved on-chain.
+	IneligibleClosing
+
+	// IneligibleSlowDownstream indicates that the remote peer has yet to
+	// resolve an HTLC we forwarded to it within the link's maximum
+	// downstream failure latency.
+	IneligibleSlowDownstream
+
+	// IneligibleTooYoung indicates that the link's channel hasn't yet
+	// reached the minimum channel age required to forward HTLC's.
+	IneligibleTooYoung
+
+	// IneligibleMaintenance indicates that the switch is within one of
+	// its maintenance windows, during which no HTLC's are forwarded.
+	IneligibleMaintenance
+
+	// IneligibleChainNotSynced indicates that the node isn't synced to the
+	// tip of the chain, so no new HTLC's are forwarded until it is.
+	IneligibleChainNotSynced
 )
 
+// String returns a human readable string describing the IneligibleReason.
+func (r IneligibleReason) String() string {
+	switch r {
+	case IneligibleNone:
+		return "None"
+
+	case IneligibleNoRevocation:
+		return "NoRevocation"
+
+	case IneligibleClearing:
+		return "Clearing"
+
+	case IneligibleQuiescing:
+		return "Quiescing"
+
+	case IneligibleWarmingUp:
+		return "WarmingUp"
+
+	case IneligibleStateMismatch:
+		return "StateMismatch"
+
+	case IneligibleClosing:
+		return "Closing"
+
+	case IneligibleSlowDownstream:
+		return "SlowDownstream"
+
+	case IneligibleTooYoung:
+		return "TooYoung"
+
+	case IneligibleMaintenance:
+		return "Maintenance"
+
+	case IneligibleChainNotSynced:
+		return "ChainNotSynced"
+
+	default:
+		return "unknown reason"
+	}
+}
+
+// usableForLocalPayment returns true if a link which is ineligible to forward
+// for the passed reason may still carry locally initiated payments. Both the
+// minimum channel age and maintenance windows only guard against forwards, so
+// local payments are exempt. The warm-up period is handled separately, as
+// bypassing it is optional.
+func usableForLocalPayment(reason IneligibleReason) bool {
+	switch reason {
+	case IneligibleNone, IneligibleTooYoung, IneligibleMaintenance:
+		return true
+
+	default:
+		return false
+	}
+}
+
 // ForwardingPolicy describes the set of constraints that a given ChannelLink
 // is to adhere to when forwarding HTLC's. For each incoming HTLC, this set of
 // constraints will be consulted in order to ensure that adequate fees are
@@ -64,21 +196,113 @@ type ForwardingPolicy struct {
 	//
 	//    where fwdInfo is the forwarding information extracted from the
 	//    per-hop payload of the incoming HTLC's onion packet.
+	//
+	// This is the delta advertised within our channel updates. Unless an
+	// EnforcedTimeLockDelta is set, it's also the delta enforced.
 	TimeLockDelta uint32
 
+	// EnforcedTimeLockDelta is the time-lock delta actually required of
+	// forwarded HTLC's. It may be set below the advertised TimeLockDelta,
+	// such that senders build in a margin, while forwards from senders
+	// computing their time-locks tightly are still accepted. It must not
+	// exceed TimeLockDelta. If zero, then TimeLockDelta is enforced.
+	EnforcedTimeLockDelta uint32
+
+	// ProbeThreshold is the amount below which forwarded HTLC's are
+	// considered probes, and charged the fee of the ProbePolicy rather
+	// than the regular fee. Probes must still satisfy MinHTLC. If zero,
+	// then no HTLC's are treated as probes.
+	ProbeThreshold lnwire.MilliSatoshi
+
+	// ProbePolicy is the fee schedule applied to HTLC's forwarding less
+	// than ProbeThreshold. It isn't advertised within our channel
+	// updates, which continue to reflect the regular fee. A zero policy
+	// forwards probes free of charge.
+	ProbePolicy ProbeFeePolicy
+
+	// MaxValueInFlight caps the total value of the HTLC's we offer over
+	// the channel below the maximum negotiated for the channel. HTLC's
+	// which would exceed it are failed with a temporary channel failure.
+	// It must not exceed the negotiated maximum. If zero, then only the
+	// negotiated maximum applies.
+	MaxValueInFlight lnwire.MilliSatoshi
+
+	// MaxAtRiskExposure caps the total value of the non-dust HTLC's on
+	// our commitment transaction, which would each need to be resolved
+	// on-chain were the channel force closed. HTLC's we'd offer beyond it
+	// are failed with a temporary channel failure. If zero, then the
+	// exposure is unlimited.
+	MaxAtRiskExposure lnwire.MilliSatoshi
+
+	// MaxExtraCltv caps the time for which a forward may lock up the
+	// outgoing channel's capital. Forwards whose outgoing time-lock
+	// exceeds the current height plus TimeLockDelta by more than
+	// MaxExtraCltv blocks are failed with an expiry too far failure. If
+	// zero, then DefaultMaxExtraCltv is used.
+	MaxExtraCltv uint32
+
 	// TODO(roasbeef): add fee module inside of switch
 }
 
+// ProbeFeePolicy is the fee schedule applied to forwards of small,
+// probe-sized HTLC's, allowing them to be forwarded at a flat fee, or for
+// free, independent of the regular fee schedule.
+type ProbeFeePolicy struct {
+	// BaseFee is the base fee, expressed in milli-satoshi, that must be
+	// paid for each forwarded probe.
+	BaseFee lnwire.MilliSatoshi
+
+	// FeeRate is the fee rate, expressed in millionths of the forwarded
+	// amount, that must be paid for each forwarded probe.
+	FeeRate lnwire.MilliSatoshi
+}
+
+// isProbe returns true if an HTLC forwarding the passed amount is considered
+// a probe under the policy.
+func (f ForwardingPolicy) isProbe(htlcAmt lnwire.MilliSatoshi) bool {
+	return f.ProbeThreshold != 0 && htlcAmt < f.ProbeThreshold
+}
+
+// enforcedTimeLockDelta returns the time-lock delta required of forwarded
+// HTLC's under the policy. An enforced delta exceeding the advertised one is
+// ignored, as senders can't be expected to meet it.
+func (f ForwardingPolicy) enforcedTimeLockDelta() uint32 {
+	if f.EnforcedTimeLockDelta == 0 ||
+		f.EnforcedTimeLockDelta > f.TimeLockDelta {
+
+		return f.TimeLockDelta
+	}
+
+	return f.EnforcedTimeLockDelta
+}
+
+// maxOutgoingExpiry returns the latest outgoing time-lock of a forward
+// accepted under the policy at the passed height.
+func (f ForwardingPolicy) maxOutgoingExpiry(heightNow uint32) uint32 {
+	maxExtra := f.MaxExtraCltv
+	if maxExtra == 0 {
+		maxExtra = DefaultMaxExtraCltv
+	}
+
+	return heightNow + f.TimeLockDelta + maxExtra
+}
+
 // ExpectedFee computes the expected fee for a given htlc amount. The value
 // returned from this function is to be used as a sanity check when forwarding
 // HTLC's to ensure that an incoming HTLC properly adheres to our propagated
-// forwarding policy.
+// forwarding policy. HTLC's forwarding less than the policy's probe threshold
+// are charged the fee of its probe policy instead.
 //
 // TODO(roasbeef): also add in current available channel bandwidth, inverse
 // func
 func ExpectedFee(f ForwardingPolicy, htlcAmt lnwire.MilliSatoshi) lnwire.MilliSatoshi {
 
 	// TODO(roasbeef): write some basic table driven tests
+	if f.isProbe(htlcAmt) {
+		probe := f.ProbePolicy
+		return probe.BaseFee + (htlcAmt*probe.FeeRate)/1000000
+	}
+
 	return f.BaseFee + (htlcAmt*f.FeeRate)/1000000
 }
 
@@ -141,6 +365,14 @@ type ChannelLinkConfig struct {
 	// latest policy when sending encrypted error messages.
 	GetLastChannelUpdate func() (*lnwire.ChannelUpdate, error)
 
+	// SignChannelUpdate signs the passed channel update with our node's
+	// key, replacing its signature. It's used to attach a channel update
+	// reflecting the fee we currently require to the FeeInsufficient
+	// failures we return, as the latest announced update may lag behind
+	// it. If nil, then the update returned by GetLastChannelUpdate is
+	// attached as is.
+	SignChannelUpdate func(*lnwire.ChannelUpdate) error
+
 	// Peer is a lightning network node with which we have the channel link
 	// opened.
 	Peer Peer
@@ -155,6 +387,13 @@ type ChannelLinkConfig struct {
 	// subscribed to new events.
 	PreimageCache contractcourt.WitnessBeacon
 
+	// PreimageDeriver, if non-nil, derives the preimages with which HTLC's
+	// paying to our invoices are settled, rather than them being read from
+	// the invoices. HTLC's for which derivation fails, or yields a
+	// preimage not matching their payment hash, are failed back with
+	// incorrect payment details.
+	PreimageDeriver PreimageDeriver
+
 	// UpdateContractSignals is a function closure that we'll use to update
 	// outside sub-systems with the latest signals for our inner Lightning
 	// channel. These signals will notify the caller when the channel has
@@ -205,6 +444,162 @@ type ChannelLinkConfig struct {
 	// BatchSize is the max size of a batch of updates done to the link
 	// before we do a state update.
 	BatchSize uint32
+
+	// RejectStressDust, if true, causes the link to reject any incoming
+	// HTLC's to be forwarded which would be trimmed as dust if the
+	// commitment fee rate were to rise by DustStressHeadroom. This guards
+	// against a fee spike turning forwarded value into unenforceable dust.
+	RejectStressDust bool
+
+	// DustStressHeadroom is the increase in the commitment fee rate
+	// under which HTLC's are checked when RejectStressDust is set. If
+	// zero, then DefaultDustStressHeadroom is used.
+	DustStressHeadroom lnwallet.SatPerVByte
+
+	// FeeUpdateTolerance is the band, as a percentage, by which the
+	// commitment fee rate may differ from our own estimate of the
+	// network fee rate. As the channel initiator, we'll only propose a
+	// new fee rate once the current one falls outside the band, while
+	// fee rates proposed by the remote party within the band are
+	// accepted silently. If zero, then DefaultFeeUpdateTolerance is used.
+	FeeUpdateTolerance uint32
+
+	// FeeUpdateHardLimit is the percentage by which a fee rate proposed
+	// by the remote party may differ from our own estimate before the
+	// update is treated as fatal to the link. Proposals outside of
+	// FeeUpdateTolerance but within the hard limit are accepted, and
+	// recorded as a disagreement. If zero, then proposals from the
+	// remote party aren't checked against our estimate.
+	FeeUpdateHardLimit uint32
+
+	// MinFreeSlots is the number of HTLC slots of the channel reserved
+	// for locally initiated payments. Forwards are rejected once the
+	// number of free slots drops to the reserve, such that the channel
+	// is never filled by forwards alone. If zero, then
+	// DefaultMinFreeSlots is used.
+	MinFreeSlots uint16
+
+	// CommitmentType is the format of the channel's commitment
+	// transactions, which the link's dust, anchor reserve and fee
+	// ceiling calculations adapt to. The channel state machine currently
+	// only constructs legacy commitments, so the zero value,
+	// CommitmentTypeLegacy, should be used unless the channel was
+	// negotiated otherwise.
+	CommitmentType CommitmentType
+
+	// SoftReserve is the part of our balance, on top of the channel
+	// reserve, which is withheld from forwarded HTLC's, such that it
+	// remains available for our own payments. If zero, then our entire
+	// balance above the channel reserve may be forwarded.
+	SoftReserve lnwire.MilliSatoshi
+
+	// MinClaimCostMultiple, if positive, causes the link to reject any
+	// HTLC paying to us whose amount is below this multiple of the
+	// estimated cost of claiming it on-chain at the current commitment
+	// fee rate, as such HTLC's can't be economically enforced. If zero,
+	// then the check is skipped.
+	MinClaimCostMultiple float64
+
+	// Role is the role of the channel upon startup, restricting the
+	// direction in which it may be used by forwarded HTLC's. It can be
+	// changed later on via UpdateDirectionalPolicy.
+	Role ChannelRole
+
+	// StoreRole, if non-nil, is called to persist the role of the channel
+	// each time it's changed, before the new role is applied.
+	StoreRole func(ChannelRole) error
+
+	// MaxOverflowWait is the maximum time an add may wait within the
+	// overflow queue for a slot within the commitment transaction to
+	// become available before it's failed back. The wait is further
+	// bounded to a fraction of the time remaining until the HTLC's
+	// expiry. If zero, then adds wait until a slot becomes available.
+	MaxOverflowWait time.Duration
+
+	// WarmUpPeriod is the maximum duration after a link which needs to
+	// synchronize its state with the remote peer has started during which
+	// it won't be eligible to forward HTLC's. The warm-up ends early once
+	// the first commitment round-trip with the remote peer completes. If
+	// zero, then links are eligible to forward as soon as they start.
+	WarmUpPeriod time.Duration
+
+	// Pacing governs how outgoing HTLC adds are spaced out, and how often
+	// intermediate commitments covering them are signed. The zero value
+	// disables pacing.
+	Pacing PacingPolicy
+
+	// ResolutionBatching governs how settles and fails of incoming HTLC's
+	// are aggregated before a commitment covering them is signed. The zero
+	// value signs a commitment for each of them.
+	ResolutionBatching ResolutionBatchPolicy
+
+	// FailureDelay governs the randomized delay applied before failing
+	// back HTLC's for which we're the exit hop with a privacy sensitive
+	// failure. The zero value fails them back right away.
+	FailureDelay FailureDelayPolicy
+
+	// ForwardResolutionJitter is the upper bound on the randomized delay
+	// applied before propagating the settle or fail of a forwarded HTLC
+	// back over this link, obscuring how far downstream it was resolved.
+	// The delay is further capped to MaxForwardResolutionJitter and to a
+	// small fraction of the time remaining until the incoming HTLC's
+	// expiry. If zero, then resolutions aren't delayed.
+	ForwardResolutionJitter time.Duration
+
+	// ExtraHTLCPolicy determines how HTLC's added by the remote peer which
+	// don't follow on from the remote update log, such as duplicates
+	// retransmitted after the channel is re-established, are handled. The
+	// zero value drops duplicates, and admits borderline extras with a
+	// warning.
+	ExtraHTLCPolicy ExtraHTLCPolicy
+
+	// PolicyDrift governs when the link gossips a new channel update once
+	// its forwarding policy has drifted from the policy last gossiped. The
+	// zero value leaves gossiping policy changes to the caller.
+	PolicyDrift PolicyDriftPolicy
+
+	// GossipPolicyUpdate, if non-nil, is called to gossip a new channel
+	// update for the link's channel carrying the passed forwarding policy
+	// once it has drifted past the PolicyDrift threshold.
+	GossipPolicyUpdate func(ForwardingPolicy) error
+
+	// ExpiryTooSoon governs how the link reacts to repeatedly failing
+	// forwards as their expiry is too soon. The zero value only counts
+	// the failures.
+	ExpiryTooSoon ExpiryTooSoonPolicy
+
+	// MaxDownstreamFailLatency is the time the remote peer may take to
+	// settle or fail an HTLC we've forwarded to it before the link is
+	// considered unhealthy, and stops forwarding new HTLC's until the
+	// overdue HTLC's are resolved. If zero, then
+	// DefaultMaxDownstreamFailLatency is used.
+	MaxDownstreamFailLatency time.Duration
+
+	// MinChannelAge is the minimum time since the channel's funding
+	// transaction confirmed before the link is eligible to carry
+	// forwards. Locally initiated payments are exempt. If zero, then
+	// channels may forward as soon as the link is active.
+	MinChannelAge time.Duration
+
+	// UnderpaymentGrace is the amount by which an HTLC paying one of our
+	// invoices may fall short of the invoice's amount while still being
+	// settled, to accommodate senders whose fee estimation differs
+	// slightly from ours. If zero, then the HTLC must pay at least the
+	// invoice's amount.
+	UnderpaymentGrace lnwire.MilliSatoshi
+
+	// QuiescenceSupported should be true if the remote peer signalled
+	// support for quiescence within its Init message. If false, then
+	// Quiesce returns ErrQuiescenceUnsupported, and the link is failed
+	// should the remote peer send stfu.
+	QuiescenceSupported bool
+
+	// OnStateMismatch, if non-nil, is called once the link detects that
+	// the commitment state reported by the remote peer upon reestablishment
+	// diverges from our own, e.g. in order to initiate data loss
+	// protection recovery. The link won't forward or sign any further
+	// updates regardless.
+	OnStateMismatch func(StateMismatch, error)
 }
 
 // channelLink is the service which drives a channel's commitment update
@@ -213,10 +608,49 @@ type ChannelLinkConfig struct {
 // switch. Additionally, the link encapsulate logic of commitment protocol
 // message ordering and updates.
 type channelLink struct {
+	// feeDisagreements is the number of fee rates proposed by the remote
+	// party which fell outside of the fee update tolerance band. It's
+	// placed first to keep it 64-bit aligned for atomic access.
+	feeDisagreements uint64
+
 	// The following fields are only meant to be used *atomically*
 	started  int32
 	shutdown int32
 
+	// clearingForClose is set to 1 once the link has been instructed to
+	// clear all of its HTLC's in preparation for a cooperative close.
+	// While set, all new HTLC's are rejected.
+	clearingForClose int32
+
+	// role is the ChannelRole of the link's channel.
+	role uint32
+
+	// quiescing is set to 1 while a quiescence negotiation is underway,
+	// or the channel is quiescent. While set, all new HTLC's are
+	// rejected.
+	quiescing int32
+
+	// warmingUp is set to 1 from the time the link starts until it has
+	// confirmed that its state is in sync with the remote peer, or the
+	// warm-up period has elapsed. warmedUp is closed once it's cleared.
+	warmingUp int32
+	warmedUp  chan struct{}
+
+	// reestablishing is set to 1 from the time the link starts until it
+	// receives the first commitment signed by the remote peer, during
+	// which HTLC's mirroring those already received are deemed extras.
+	reestablishing int32
+
+	// stateMismatch is set to 1 once the link has detected that its
+	// commitment state diverges from that of the remote peer. Once set,
+	// it's never cleared.
+	stateMismatch int32
+
+	// pendingClose is set to 1 once a force close of the channel has
+	// been initiated. While set, all new HTLC's are failed back with a
+	// permanent channel failure. Once set, it's never cleared.
+	pendingClose int32
+
 	// batchCounter is the number of updates which we received from remote
 	// side, but not include in commitment transaction yet and plus the
 	// current number of settles that have been sent, but not yet committed
@@ -227,7 +661,9 @@ type channelLink struct {
 	batchCounter uint32
 
 	// bestHeight is the best known height of the main chain. The link will
-	// use this information to govern decisions based on HTLC timeouts.
+	// use this information to govern decisions based on HTLC timeouts. It's
+	// only written from within the htlcManager goroutine, and must be read
+	// atomically from outside of it.
 	bestHeight uint32
 
 	// channel is a lightning network channel to which we apply htlc
@@ -269,6 +705,91 @@ type channelLink struct {
 	// sub-systems with the latest set of active HTLC's on our channel.
 	htlcUpdates chan []channeldb.HTLC
 
+	// htlcsClearedSignals is the set of channels which are to be closed
+	// once all HTLC's on the channel have been resolved. This is only
+	// accessed from within the htlcManager goroutine.
+	htlcsClearedSignals []chan struct{}
+
+	// heldHtlcs is the set of incoming HTLC's, keyed by their HTLC index,
+	// which have been parked within the switch awaiting an external
+	// resolution. This is only accessed from within the htlcManager
+	// goroutine.
+	//
+	// TODO(roasbeef): persist so they survive restarts
+	heldHtlcs map[uint64]*heldHTLC
+
+	// uncommittedTraces is the set of incoming circuits of traced HTLC's
+	// which we've added to the remote party's log, but have yet to sign
+	// a commitment for. This is only accessed from within the htlcManager
+	// goroutine.
+	uncommittedTraces []circuitKey
+
+	// signOnRevocation is set if an immediate signature was requested
+	// via SignCommitmentNow while our last commitment was awaiting its
+	// revocation. This is only accessed from within the htlcManager
+	// goroutine.
+	signOnRevocation bool
+
+	// uncommittedResolutions is the set of incoming HTLC's which we've
+	// settled or failed within the remote party's log, but have yet to
+	// sign a commitment for. Once signed, their resolutions no longer
+	// need to be retained by the switch. This is only accessed from
+	// within the htlcManager goroutine.
+	uncommittedResolutions []uint64
+
+	// quiescence tracks the state of the stfu negotiation with the remote
+	// peer. This is only accessed from within the htlcManager goroutine.
+	quiescence quiescenceState
+
+	// pacer holds the outgoing adds queued by the link's pacing policy.
+	// This is only accessed from within the htlcManager goroutine.
+	pacer pacingState
+
+	// resolutionBatch holds the settles and fails awaiting a commitment as
+	// per the link's resolution batching policy. This is only accessed
+	// from within the htlcManager goroutine.
+	resolutionBatch resolutionBatchState
+
+	// inboundFee is the fee, possibly a discount, applied in addition to
+	// the forwarding policy to HTLC's arriving over the link. This is
+	// only accessed from within the htlcManager goroutine.
+	inboundFee InboundFee
+
+	// policyDrift tracks the forwarding policy last gossiped for the
+	// link's channel.
+	policyDrift policyDriftState
+
+	// expiryTooSoon tracks the forwards failed as their expiry was too
+	// soon, as governed by the link's ExpiryTooSoon policy.
+	expiryTooSoon expiryTooSoonTracker
+
+	// activity records the link's activity for StatsSnapshot.
+	activity linkActivity
+
+	// fwdLatency tracks the HTLC's forwarded over the link until the
+	// remote peer resolves them.
+	fwdLatency *downstreamLatency
+
+	// fwdOutcomes counts the outcomes of the HTLC's forwarded over the
+	// link for StatsDetail.
+	fwdOutcomes forwardOutcomes
+
+	// fwdSizes counts the outcomes of the HTLC's forwarded over the link
+	// within the amount bucket of each, for StatsDetail.
+	fwdSizes forwardSizeOutcomes
+
+	// fwdJitter accumulates the jitter applied to the settles and fails
+	// propagated back over the link.
+	fwdJitter resolutionJitter
+
+	// extraHTLCs counts the HTLC's added by the remote peer which didn't
+	// follow on from the remote update log as expected.
+	extraHTLCs extraHTLCCounters
+
+	// commitFeed delivers snapshots of newly established commitments to
+	// the subscribers registered via SubscribeCommitments.
+	commitFeed commitFeed
+
 	// logCommitTimer is a timer which is sent upon if we go an interval
 	// without receiving/sending a commitment update. It's role is to
 	// ensure both chains converge to identical state in a timely manner.
@@ -299,6 +820,10 @@ func NewChannelLink(cfg ChannelLinkConfig, channel *lnwallet.LightningChannel,
 		overflowQueue:  newPacketQueue(lnwallet.MaxHTLCNumber / 2),
 		bestHeight:     currentHeight,
 		htlcUpdates:    make(chan []channeldb.HTLC),
+		heldHtlcs:      make(map[uint64]*heldHTLC),
+		warmedUp:       make(chan struct{}),
+		fwdLatency:     newDownstreamLatency(),
+		role:           uint32(cfg.Role),
 		quit:           make(chan struct{}),
 	}
 
@@ -325,6 +850,28 @@ func (l *channelLink) Start() error {
 
 	log.Infof("ChannelLink(%v) is starting", l)
 
+	l.activity.start()
+	l.activity.setPendingHTLCs(len(l.channel.ActiveHtlcs()))
+
+	// The policy we're started with is the one already gossiped for the
+	// channel.
+	l.policyDrift.last = GossipedPolicy{Policy: l.cfg.FwrdingPolicy}
+
+	// The soft reserve applies to all of our channels, so a channel
+	// without enough balance to cover it is still started, but won't
+	// forward any HTLC's until its balance grows.
+	if err := l.validateSoftReserve(); err != nil {
+		log.Warnf("ChannelLink(%v): %v", l, err)
+	}
+
+	// If we need to synchronize our state with the remote peer, then
+	// we'll hold off forwarding until the state has been confirmed.
+	if l.cfg.SyncStates && l.cfg.WarmUpPeriod > 0 {
+		atomic.StoreInt32(&l.warmingUp, 1)
+	} else {
+		close(l.warmedUp)
+	}
+
 	// Before we start the link, we'll update the ChainArbitrator with the
 	// set of new channel signals for this channel.
 	//
@@ -361,6 +908,10 @@ func (l *channelLink) Stop() {
 
 	log.Infof("ChannelLink(%v) is stopping", l)
 
+	// Any local payments waiting for the link to warm up should no longer
+	// do so.
+	l.endWarmUp("link stopped")
+
 	if l.cfg.ChainEvents.Cancel != nil {
 		l.cfg.ChainEvents.Cancel()
 	}
@@ -379,7 +930,97 @@ func (l *channelLink) Stop() {
 // we know the remote party's next revocation point. Otherwise, we can't
 // initiate new channel state.
 func (l *channelLink) EligibleToForward() bool {
-	return l.channel.RemoteNextRevocation() != nil
+	return l.IneligibleReason() == IneligibleNone
+}
+
+// IneligibleReason returns the reason the link isn't currently eligible to
+// forward HTLC's, or IneligibleNone if it is. IneligibleWarmingUp is only
+// returned if the link is otherwise eligible, and IneligibleChainNotSynced,
+// IneligibleMaintenance and IneligibleTooYoung only once the link has also
+// warmed up.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) IneligibleReason() IneligibleReason {
+	switch {
+	case l.inStateMismatch():
+		return IneligibleStateMismatch
+
+	case l.isPendingClose():
+		return IneligibleClosing
+
+	case l.isClearingForClose():
+		return IneligibleClearing
+
+	case l.isQuiescing():
+		return IneligibleQuiescing
+
+	case l.channel.RemoteNextRevocation() == nil:
+		return IneligibleNoRevocation
+
+	case l.isDownstreamSlow():
+		return IneligibleSlowDownstream
+
+	case atomic.LoadInt32(&l.warmingUp) == 1:
+		return IneligibleWarmingUp
+
+	case !l.cfg.Switch.chainSynced():
+		return IneligibleChainNotSynced
+
+	case l.cfg.Switch.InMaintenance():
+		return IneligibleMaintenance
+
+	case l.isTooYoung():
+		return IneligibleTooYoung
+
+	default:
+		return IneligibleNone
+	}
+}
+
+// WarmedUp returns a channel which is closed once the link's warm-up period
+// has ended.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) WarmedUp() <-chan struct{} {
+	return l.warmedUp
+}
+
+// endWarmUp ends the link's warm-up period if it's still underway.
+func (l *channelLink) endWarmUp(reason string) {
+	if !atomic.CompareAndSwapInt32(&l.warmingUp, 1, 0) {
+		return
+	}
+
+	log.Infof("ChannelLink(%v) has warmed up: %v", l, reason)
+
+	close(l.warmedUp)
+}
+
+// isClearingForClose returns true if the link has been instructed to clear
+// its HTLC's in preparation for a cooperative close.
+func (l *channelLink) isClearingForClose() bool {
+	return atomic.LoadInt32(&l.clearingForClose) == 1
+}
+
+// isPendingClose returns true if a force close of the channel has been
+// initiated.
+func (l *channelLink) isPendingClose() bool {
+	return atomic.LoadInt32(&l.pendingClose) == 1
+}
+
+// MarkPendingClose marks the channel as pending force close. From then on, the
+// link is ineligible to forward, and any new HTLC's, whether sent by the
+// switch or the remote peer, are failed back with a permanent channel
+// failure. Outstanding HTLC's are left to be resolved on-chain.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) MarkPendingClose() {
+	if !atomic.CompareAndSwapInt32(&l.pendingClose, 0, 1) {
+		return
+	}
+
+	log.Infof("ChannelLink(%v) is pending force close, rejecting new "+
+		"htlcs", l)
 }
 
 // sampleNetworkFee samples the current fee rate on the network to get into the
@@ -405,17 +1046,23 @@ func (l *channelLink) sampleNetworkFee() (lnwallet.SatPerKWeight, error) {
 
 // shouldAdjustCommitFee returns true if we should update our commitment fee to
 // match that of the network fee. We'll only update our commitment fee if the
-// network fee is +/- 10% to our network fee.
-func shouldAdjustCommitFee(netFee, chanFee lnwallet.SatPerKWeight) bool {
+// network fee is +/- the tolerance percentage to our network fee.
+func shouldAdjustCommitFee(netFee, chanFee lnwallet.SatPerKWeight,
+	tolerance uint32) bool {
+
+	band := chanFee * lnwallet.SatPerKWeight(tolerance) / 100
+
 	switch {
 	// If the network fee is greater than the commitment fee, then we'll
-	// switch to it if it's at least 10% greater than the commit fee.
-	case netFee > chanFee && netFee >= (chanFee+(chanFee*10)/100):
+	// switch to it if it's at least tolerance% greater than the commit
+	// fee.
+	case netFee > chanFee && netFee >= chanFee+band:
 		return true
 
 	// If the network fee is less than our commitment fee, then we'll
-	// switch to it if it's at least 10% less than the commitment fee.
-	case netFee < chanFee && netFee <= (chanFee-(chanFee*10)/100):
+	// switch to it if it's at least tolerance% less than the commitment
+	// fee.
+	case netFee < chanFee && netFee <= chanFee-band:
 		return true
 
 	// Otherwise, we won't modify our fee.
@@ -460,6 +1107,36 @@ func (l *channelLink) syncChanStates() error {
 				"received: %T", msg)
 		}
 
+		// We'll process their ChanSync message before sending
+		// anything else, as should it reveal that our states have
+		// diverged, then we mustn't send the remote party any further
+		// messages.
+		log.Infof("Received re-establishment message from remote side "+
+			"for channel(%v)", l.channel.ChannelPoint())
+
+		// We've just received a ChnSync message from the remote party,
+		// so we'll process the message  in order to determine if we
+		// need to re-transmit any messages to the remote party.
+		msgsToReSend, err = l.channel.ProcessChanSyncMsg(remoteChanSyncMsg)
+		if err != nil {
+			// If the error signals that our states have diverged,
+			// then we'll note how, so the link can refrain from
+			// any further updates rather than risk using a revoked
+			// state.
+			mismatch, ok := classifyStateMismatch(
+				localChanSyncMsg, remoteChanSyncMsg, err,
+			)
+			if ok {
+				return &stateMismatchError{
+					mismatch: mismatch,
+					err:      err,
+				}
+			}
+
+			return fmt.Errorf("unable to handle upstream reestablish "+
+				"message: %v", err)
+		}
+
 		// If the remote party indicates that they think we haven't
 		// done any state updates yet, then we'll retransmit the
 		// funding locked message first. We do this, as at this point
@@ -489,21 +1166,6 @@ func (l *channelLink) syncChanStates() error {
 			}
 		}
 
-		// In any case, we'll then process their ChanSync message.
-		log.Infof("Received re-establishment message from remote side "+
-			"for channel(%v)", l.channel.ChannelPoint())
-
-		// We've just received a ChnSync message from the remote party,
-		// so we'll process the message  in order to determine if we
-		// need to re-transmit any messages to the remote party.
-		msgsToReSend, err = l.channel.ProcessChanSyncMsg(remoteChanSyncMsg)
-		if err != nil {
-			// TODO(roasbeef): check concrete type of error, act
-			// accordingly
-			return fmt.Errorf("unable to handle upstream reestablish "+
-				"message: %v", err)
-		}
-
 		if len(msgsToReSend) > 0 {
 			log.Infof("Sending %v updates to synchronize the "+
 				"state for ChannelPoint(%v)", len(msgsToReSend),
@@ -517,6 +1179,14 @@ func (l *channelLink) syncChanStates() error {
 			l.cfg.Peer.SendMessage(msg)
 		}
 
+		// If there was nothing to retransmit, and our commitment
+		// chains are in sync, then the remote party's view of the
+		// channel already matches our own, so there's no need to
+		// wait for a commitment round-trip to end the warm-up.
+		if len(msgsToReSend) == 0 && l.channel.FullySynced() {
+			l.endWarmUp("channel state in sync after reestablish")
+		}
+
 	case <-l.quit:
 		return fmt.Errorf("shutting down")
 
@@ -573,9 +1243,15 @@ func (l *channelLink) syncChanStates() error {
 		// remote party.
 		var p [32]byte
 		copy(p[:], preimage)
-		err := l.channel.SettleHTLC(p, htlc.HtlcIndex)
+		err := l.settleHTLC(p, htlc.HtlcIndex, htlc.RHash)
+		if htlcErr, ok := err.(*HTLCError); ok {
+			log.Errorf("ChannelLink(%v) unable to settle htlc: %v",
+				l, htlcErr)
+			continue
+		}
 		if err != nil {
-			l.fail("unable to settle htlc: %v", err)
+			l.fail(DisconnectCommitmentError,
+				"unable to settle htlc: %v", err)
 			return err
 		}
 
@@ -583,7 +1259,8 @@ func (l *channelLink) syncChanStates() error {
 		// then send the settle message to the remote party.
 		err = l.cfg.Registry.SettleInvoice(htlc.RHash)
 		if err != nil {
-			l.fail("unable to settle invoice: %v", err)
+			l.fail(DisconnectCommitmentError,
+				"unable to settle invoice: %v", err)
 			return err
 		}
 		l.batchCounter++
@@ -620,18 +1297,33 @@ func (l *channelLink) htlcManager() {
 
 	// TODO(roasbeef): need to call wipe chan whenever D/C?
 
+	// If we're warming up, then we'll only wait for the state to be
+	// confirmed for so long before becoming eligible to forward.
+	var warmUpTimeout <-chan time.Time
+	if atomic.LoadInt32(&l.warmingUp) == 1 {
+		warmUpTimeout = time.After(l.cfg.WarmUpPeriod)
+	}
+
 	// If this isn't the first time that this channel link has been
 	// created, then we'll need to check to see if we need to
 	// re-synchronize state with the remote peer. settledHtlcs is a map of
 	// HTLC's that we re-settled as part of the channel state sync.
 	if l.cfg.SyncStates {
 		// TODO(roasbeef): need to ensure haven't already settled?
-		if err := l.syncChanStates(); err != nil {
-			l.fail(err.Error())
+		err := l.syncChanStates()
+		if mErr, ok := err.(*stateMismatchError); ok {
+			l.enterStateMismatch(mErr)
+			l.stateMismatchHandler()
+			return
+		}
+		if err != nil {
+			l.fail(DisconnectCommitmentError, err.Error())
 			return
 		}
 	}
 
+	atomic.StoreInt32(&l.reestablishing, 1)
+
 	batchTick := l.cfg.BatchTicker.Start()
 	defer l.cfg.BatchTicker.Stop()
 
@@ -648,11 +1340,27 @@ out:
 				break out
 			}
 
-			l.bestHeight = uint32(blockEpoch.Height)
+			atomic.StoreUint32(&l.bestHeight, uint32(blockEpoch.Height))
+
+			// Any paced adds which are now close to expiry can't
+			// wait any longer.
+			l.releaseUrgentAdds()
+
+			// If we've sent stfu, then we can't send any updates
+			// until the channel resumes, so we'll defer any
+			// expiries and fee updates until a later block.
+			if l.quiescence.localSent {
+				continue
+			}
+
+			// Cancel back any parked HTLC's which are now too
+			// close to their expiry to be held any longer.
+			l.expireHeldHTLCs()
 
 			// If we're not the initiator of the channel, don't we
-			// don't control the fees, so we can ignore this.
-			if !l.channel.IsInitiator() {
+			// don't control the fees, so we can ignore this. We'll
+			// also hold off while negotiating quiescence.
+			if !l.channel.IsInitiator() || l.quiescence.isActive() {
 				continue
 			}
 
@@ -665,10 +1373,17 @@ out:
 				continue
 			}
 
+			// Channels with anchor outputs can be fee bumped, so
+			// their fee rate is capped.
+			feePerKw = l.commitFeeCeiling(feePerKw)
+
 			// We'll check to see if we should update the fee rate
 			// based on our current set fee rate.
 			commitFee := l.channel.CommitFeeRate()
-			if !shouldAdjustCommitFee(feePerKw, commitFee) {
+			if !shouldAdjustCommitFee(
+				feePerKw, commitFee, l.feeUpdateTolerance(),
+			) {
+
 				continue
 			}
 
@@ -697,6 +1412,9 @@ out:
 
 			break out
 
+		case <-warmUpTimeout:
+			l.endWarmUp("warm-up period elapsed")
+
 		case <-l.logCommitTick:
 			// If we haven't sent or received a new commitment
 			// update in some time, check to see if we have any
@@ -707,11 +1425,50 @@ out:
 			}
 
 			if err := l.updateCommitTx(); err != nil {
-				l.fail("unable to update commitment: %v", err)
+				l.fail(DisconnectCommitmentError,
+					"unable to update commitment: %v", err)
+				break out
+			}
+
+		case <-l.pacer.addTick:
+			l.releasePacedAdds()
+
+		case <-l.pacer.commitTick:
+			// An outgoing add has gone uncommitted for the pacing
+			// interval, so we'll sign a commitment covering it.
+			l.pacer.commitTick = nil
+			if l.batchCounter == 0 {
+				continue
+			}
+
+			if err := l.updateCommitTx(); err != nil {
+				l.fail(DisconnectCommitmentError,
+					"unable to update commitment: %v", err)
+				break out
+			}
+
+		case <-l.resolutionBatch.flushTick:
+			// A settle or fail has gone uncommitted for the
+			// batching delay, so we'll sign a commitment covering
+			// it.
+			l.resolutionBatch.flushTick = nil
+			if l.batchCounter == 0 {
+				continue
+			}
+
+			if err := l.updateCommitTx(); err != nil {
+				l.fail(DisconnectCommitmentError,
+					"unable to update commitment: %v", err)
 				break out
 			}
 
 		case <-batchTick:
+			// If we're clearing the channel for a cooperative
+			// close, then we'll use this tick to check whether all
+			// HTLC's have been resolved in the meantime.
+			l.checkHtlcsCleared()
+			l.checkQuiescence()
+
 			// If the current batch is empty, then we have no work
 			// here.
 			if l.batchCounter == 0 {
@@ -724,7 +1481,8 @@ out:
 			// update, waiting for the revocation window to open
 			// up.
 			if err := l.updateCommitTx(); err != nil {
-				l.fail("unable to update commitment: %v", err)
+				l.fail(DisconnectCommitmentError,
+					"unable to update commitment: %v", err)
 				break out
 			}
 
@@ -739,6 +1497,11 @@ out:
 
 			l.handleDownStreamPkt(packet, true)
 
+		// A packet waited within the overflow queue past its
+		// deadline, so we'll fail it back.
+		case packet := <-l.overflowQueue.expiredPkts:
+			l.handleExpiredOverflow(packet)
+
 		// A message from the switch was just received. This indicates
 		// that the link is an intermediate hop in a multi-hop HTLC
 		// circuit.
@@ -755,7 +1518,14 @@ out:
 					htlc.PaymentHash[:],
 					l.batchCounter)
 
-				l.overflowQueue.AddPkt(pkt)
+				l.parkAdd(pkt, htlc)
+				continue
+			}
+
+			// If the link is paced, then the add may need to wait
+			// for its turn.
+			if l.shouldPace(pkt) {
+				l.paceAdd(pkt)
 				continue
 			}
 			l.handleDownStreamPkt(pkt, false)
@@ -770,24 +1540,92 @@ out:
 		case cmd := <-l.linkControl:
 
 			switch req := cmd.(type) {
-			case *policyUpdate:
-				// In order to avoid overriding a valid policy
-				// with a "null" field in the new policy, we'll
-				// only update to the set sub policy if the new
-				// value isn't uninitialized.
-				if req.policy.BaseFee != 0 {
-					l.cfg.FwrdingPolicy.BaseFee = req.policy.BaseFee
-				}
-				if req.policy.FeeRate != 0 {
-					l.cfg.FwrdingPolicy.FeeRate = req.policy.FeeRate
+			case *heldHTLCResolution:
+				// Settling or cancelling the HTLC is an
+				// update, so we'll defer it if we've sent
+				// stfu.
+				if l.quiescence.localSent {
+					l.quiescence.deferredResolutions = append(
+						l.quiescence.deferredResolutions, req,
+					)
+					continue
 				}
-				if req.policy.TimeLockDelta != 0 {
-					l.cfg.FwrdingPolicy.TimeLockDelta = req.policy.TimeLockDelta
+
+				l.handleHeldHTLCResolution(req)
+
+			case *delayedFailure:
+				// Likewise, the failure must wait until the
+				// link resumes if we've sent stfu.
+				if l.quiescence.localSent {
+					l.quiescence.deferredFailures = append(
+						l.quiescence.deferredFailures, req,
+					)
+					continue
 				}
 
-				if req.done != nil {
-					close(req.done)
+				l.handleDelayedFailure(req)
+
+			case *jitteredResolution:
+				l.handleDownStreamPkt(req.pkt, false)
+
+			case *switchTransferReq:
+				l.handleSwitchTransfer(req)
+
+			case *quiesceReq:
+				l.handleQuiesceReq(req)
+
+			case *quiesceAbort:
+				l.handleQuiesceAbort(req)
+
+			case *resumeReq:
+				l.handleResumeReq(req)
+
+			case *signCommitReq:
+				l.handleSignCommitReq(req)
+
+			case *heartbeatReq:
+				close(req.ack)
+
+			case *clearHtlcsReq:
+				atomic.StoreInt32(&l.clearingForClose, 1)
+
+				l.htlcsClearedSignals = append(
+					l.htlcsClearedSignals, req.cleared,
+				)
+
+				// Any packets residing within the overflow
+				// queue will never make it into the
+				// commitment, so we'll kick the queue in order
+				// to have them delivered, then failed back.
+				l.overflowQueue.SignalFreeSlot()
+
+				l.checkHtlcsCleared()
+
+			case *policyUpdate:
+				err := l.handlePolicyUpdate(req.policy)
+				req.err <- err
+
+				switch {
+				case err != nil:
+
+				case req.policy.Gossiped:
+					l.recordGossipedPolicy(
+						l.cfg.FwrdingPolicy,
+					)
+
+				default:
+					l.checkPolicyDrift()
 				}
+
+			case *policyDriftCheck:
+				l.policyDrift.checkPending = false
+				l.checkPolicyDrift()
+
+			case *policyQuery:
+				req.resp <- l.currentPolicy()
+
+			case *firstHopQuoteReq:
+				req.resp <- l.quoteFirstHop(req.amt)
 			}
 
 		case <-l.quit:
@@ -803,12 +1641,88 @@ out:
 //
 // TODO(roasbeef): add sync ntfn to ensure switch always has consistent view?
 func (l *channelLink) handleDownStreamPkt(pkt *htlcPacket, isReProcess bool) {
-	var isSettle bool
+	// Settles and fails of forwarded HTLC's may be jittered before being
+	// propagated to the remote peer.
+	if l.jitterResolution(pkt) {
+		return
+	}
+
+	// If we've sent stfu, then we can't send any updates to the remote
+	// peer, so we'll hold onto any settles and fails until the channel
+	// resumes. New HTLC's are rejected below.
+	_, isAdd := pkt.htlc.(*lnwire.UpdateAddHTLC)
+	if !isAdd && l.quiescence.localSent {
+		l.quiescence.deferredPkts = append(
+			l.quiescence.deferredPkts, pkt,
+		)
+		return
+	}
+
+	var isSettle, pacedCommit, settleCommit bool
 	switch htlc := pkt.htlc.(type) {
 	case *lnwire.UpdateAddHTLC:
 		// A new payment has been initiated via the downstream channel,
 		// so we add the new HTLC to our local log, then update the
 		// commitment chains.
+		// If we're clearing the channel in preparation for a
+		// cooperative close, quiescing it, or it's pending force
+		// close, then no new HTLC's can be added, so we'll cancel this
+		// one back to the switch.
+		if l.isClearingForClose() || l.isQuiescing() ||
+			l.isPendingClose() {
+
+			log.Debugf("ChannelLink(%v) is closing or quiescing, "+
+				"rejecting downstream htlc with payment "+
+				"hash(%x)", l, htlc.PaymentHash[:])
+
+			l.failDownstreamAdd(pkt, htlc)
+
+			// If this packet came from the overflow queue, then it
+			// never took up the slot it was released for, so
+			// we'll hand the slot back in order to also drain the
+			// remainder of the queue.
+			if isReProcess {
+				l.overflowQueue.SignalFreeSlot()
+				l.checkHtlcsCleared()
+			}
+			return
+		}
+
+		// If the HTLC would push the value we have in flight past the
+		// cap of our forwarding policy, then we'll cancel it back as
+		// well.
+		if l.exceedsMaxValueInFlight(htlc.Amount) {
+			l.failDownstreamAdd(pkt, htlc)
+
+			if isReProcess {
+				l.overflowQueue.SignalFreeSlot()
+			}
+			return
+		}
+
+		// Likewise if it would push the value we'd need to resolve
+		// on-chain past the cap.
+		if l.exceedsMaxAtRiskExposure(htlc.Amount) {
+			l.failDownstreamAdd(pkt, htlc)
+
+			if isReProcess {
+				l.overflowQueue.SignalFreeSlot()
+			}
+			return
+		}
+
+		// Forwards may not use the HTLC slots reserved for locally
+		// initiated payments.
+		isForward := pkt.incomingChanID != (lnwire.ShortChannelID{})
+		if isForward && l.exceedsSlotReserve() {
+			l.failDownstreamAdd(pkt, htlc)
+
+			if isReProcess {
+				l.overflowQueue.SignalFreeSlot()
+			}
+			return
+		}
+
 		htlc.ChanID = l.ChanID()
 		index, err := l.channel.AddHTLC(htlc)
 		if err != nil {
@@ -823,7 +1737,7 @@ func (l *channelLink) handleDownStreamPkt(pkt *htlcPacket, isReProcess bool) {
 					htlc.PaymentHash[:],
 					l.batchCounter)
 
-				l.overflowQueue.AddPkt(pkt)
+				l.parkAdd(pkt, htlc)
 				return
 
 			// The HTLC was unable to be added to the state
@@ -832,47 +1746,7 @@ func (l *channelLink) handleDownStreamPkt(pkt *htlcPacket, isReProcess bool) {
 			default:
 				log.Warnf("Unable to handle downstream add HTLC: %v", err)
 
-				var (
-					localFailure = false
-					reason       lnwire.OpaqueReason
-				)
-
-				failure := lnwire.NewTemporaryChannelFailure(nil)
-
-				// Encrypt the error back to the source unless the payment was
-				// generated locally.
-				if pkt.obfuscator == nil {
-					var b bytes.Buffer
-					err := lnwire.EncodeFailure(&b, failure, 0)
-					if err != nil {
-						log.Errorf("unable to encode failure: %v", err)
-						return
-					}
-					reason = lnwire.OpaqueReason(b.Bytes())
-					localFailure = true
-				} else {
-					var err error
-					reason, err = pkt.obfuscator.EncryptFirstHop(failure)
-					if err != nil {
-						log.Errorf("unable to obfuscate error: %v", err)
-						return
-					}
-				}
-
-				failPkt := &htlcPacket{
-					incomingChanID: pkt.incomingChanID,
-					incomingHTLCID: pkt.incomingHTLCID,
-					amount:         htlc.Amount,
-					isRouted:       true,
-					localFailure:   localFailure,
-					htlc: &lnwire.UpdateFailHTLC{
-						Reason: reason,
-					},
-				}
-
-				// TODO(roasbeef): need to identify if sent
-				// from switch so don't need to obfuscate
-				go l.cfg.Switch.forward(failPkt)
+				l.failDownstreamAdd(pkt, htlc)
 				return
 			}
 		}
@@ -894,15 +1768,57 @@ func (l *channelLink) handleDownStreamPkt(pkt *htlcPacket, isReProcess bool) {
 
 		htlc.ID = index
 		l.cfg.Peer.SendMessage(htlc)
+		l.activity.forwarded()
+
+		// Locally initiated HTLC's have a blank incoming channel, and
+		// aren't held to the downstream failure latency.
+		if pkt.incomingChanID != (lnwire.ShortChannelID{}) {
+			l.fwdLatency.sent(index)
+			l.fwdOutcomes.sent()
+			l.fwdSizes.sent(
+				l.cfg.Switch.forwardSizeBuckets(), index,
+				htlc.Amount,
+			)
+		}
+		pacedCommit = l.pacedAddSent()
+
+		// If tracing is enabled, then we'll note the incoming circuit
+		// of this HTLC so we can mark it as committed once we sign a
+		// commitment which covers it.
+		tracer := l.cfg.Switch.tracer
+		if tracer.isEnabled() {
+			tracer.recordOutgoing(
+				pkt.incomingChanID, pkt.incomingHTLCID,
+				l.ShortChanID(), index,
+			)
+			l.uncommittedTraces = append(l.uncommittedTraces,
+				circuitKey{
+					chanID: pkt.incomingChanID,
+					htlcID: pkt.incomingHTLCID,
+				},
+			)
+		}
 
 	case *lnwire.UpdateFulfillHTLC:
 		// An HTLC we forward to the switch has just settled somewhere
 		// upstream. Therefore we settle the HTLC within the our local
 		// state machine.
 		err := l.channel.SettleHTLC(htlc.PaymentPreimage, pkt.incomingHTLCID)
+		if err != nil && pkt.isReplay {
+			// If this settle was replayed after a restart, then
+			// we most likely committed to it before the restart,
+			// so there's nothing left to do.
+			log.Debugf("ChannelLink(%v) ignoring replayed settle "+
+				"of htlc=%v: %v", l, pkt.incomingHTLCID, err)
+			l.cfg.Switch.ackResolutions(
+				l.ShortChanID(), []uint64{pkt.incomingHTLCID},
+			)
+			return
+		}
 		if err != nil {
 			// TODO(roasbeef): broadcast on-chain
-			l.fail("unable to settle incoming HTLC: %v", err)
+			l.fail(DisconnectCommitmentError,
+				"unable to settle incoming HTLC: %v", err)
 			return
 		}
 
@@ -915,13 +1831,26 @@ func (l *channelLink) handleDownStreamPkt(pkt *htlcPacket, isReProcess bool) {
 		// Then we send the HTLC settle message to the connected peer
 		// so we can continue the propagation of the settle message.
 		l.cfg.Peer.SendMessage(htlc)
+		l.activity.settled()
+		l.recordIncomingFlow(pkt.incomingHTLCID, true)
+		l.cfg.Switch.hashWatcher.resolved(
+			l.ShortChanID(), pkt.incomingHTLCID, true,
+		)
 		isSettle = true
 
 	case *lnwire.UpdateFailHTLC:
 		// An HTLC cancellation has been triggered somewhere upstream,
 		// we'll remove then HTLC from our local state machine.
 		err := l.channel.FailHTLC(pkt.incomingHTLCID, htlc.Reason)
-		if err != nil {
+		if err != nil && pkt.isReplay {
+			log.Debugf("ChannelLink(%v) ignoring replayed fail "+
+				"of htlc=%v: %v", l, pkt.incomingHTLCID, err)
+			l.cfg.Switch.ackResolutions(
+				l.ShortChanID(), []uint64{pkt.incomingHTLCID},
+			)
+			return
+		}
+		if err != nil {
 			log.Errorf("unable to cancel HTLC: %v", err)
 			return
 		}
@@ -936,34 +1865,144 @@ func (l *channelLink) handleDownStreamPkt(pkt *htlcPacket, isReProcess bool) {
 		// Finally, we send the HTLC message to the peer which
 		// initially created the HTLC.
 		l.cfg.Peer.SendMessage(htlc)
+		l.activity.failed()
+		l.cfg.Switch.hashWatcher.resolved(
+			l.ShortChanID(), pkt.incomingHTLCID, false,
+		)
 		isSettle = true
 	}
 
+	// Settles and fails must be retained by the switch until we've
+	// signed a commitment covering them.
+	if isSettle {
+		l.uncommittedResolutions = append(
+			l.uncommittedResolutions, pkt.incomingHTLCID,
+		)
+		settleCommit = l.batchResolution()
+	}
+
 	l.batchCounter++
 
 	// If this newly added update exceeds the min batch size for adds, or
-	// this is a settle request, then initiate an update.
-	if l.batchCounter >= l.cfg.BatchSize || isSettle {
+	// this is a settle request which isn't batched, or the pacing policy
+	// calls for an intermediate commitment, then initiate an update.
+	if l.batchCounter >= l.cfg.BatchSize || settleCommit || pacedCommit {
 		if err := l.updateCommitTx(); err != nil {
-			l.fail("unable to update commitment: %v", err)
+			l.fail(DisconnectCommitmentError,
+				"unable to update commitment: %v", err)
+			return
+		}
+	}
+}
+
+// failDownstreamAdd cancels back a downstream add which we were unable to
+// include within the channel's state machine with a temporary channel failure.
+func (l *channelLink) failDownstreamAdd(pkt *htlcPacket,
+	htlc *lnwire.UpdateAddHTLC) {
+
+	// If the channel is pending force close, then it won't be able to
+	// carry the HTLC again, so we'll signal that the failure is
+	// permanent.
+	var failure lnwire.FailureMessage
+	if l.isPendingClose() {
+		failure = &lnwire.FailPermanentChannelFailure{}
+	} else {
+		failure = lnwire.NewTemporaryChannelFailure(nil)
+	}
+
+	l.failDownstreamAddWith(pkt, htlc, failure)
+}
+
+// failDownstreamAddWith cancels back a downstream add with the passed
+// failure.
+func (l *channelLink) failDownstreamAddWith(pkt *htlcPacket,
+	htlc *lnwire.UpdateAddHTLC, failure lnwire.FailureMessage) {
+
+	var (
+		localFailure = false
+		reason       lnwire.OpaqueReason
+	)
+
+	if pkt.incomingChanID != (lnwire.ShortChannelID{}) {
+		l.fwdOutcomes.localFailure(failure.Code())
+		l.fwdSizes.localFailure(
+			l.cfg.Switch.forwardSizeBuckets(), htlc.Amount,
+			failure.Code(),
+		)
+	}
+
+	// Encrypt the error back to the source unless the payment was
+	// generated locally.
+	if pkt.obfuscator == nil {
+		var b bytes.Buffer
+		err := lnwire.EncodeFailure(&b, failure, 0)
+		if err != nil {
+			log.Errorf("unable to encode failure: %v", err)
+			return
+		}
+		reason = lnwire.OpaqueReason(b.Bytes())
+		localFailure = true
+	} else {
+		var err error
+		reason, err = pkt.obfuscator.EncryptFirstHop(failure)
+		if err != nil {
+			log.Errorf("unable to obfuscate error: %v", err)
 			return
 		}
 	}
+
+	failPkt := &htlcPacket{
+		incomingChanID: pkt.incomingChanID,
+		incomingHTLCID: pkt.incomingHTLCID,
+		amount:         htlc.Amount,
+		isRouted:       true,
+		localFailure:   localFailure,
+		htlc: &lnwire.UpdateFailHTLC{
+			Reason: reason,
+		},
+	}
+
+	// TODO(roasbeef): need to identify if sent
+	// from switch so don't need to obfuscate
+	go l.cfg.Switch.forward(failPkt)
+	l.activity.failed()
 }
 
 // handleUpstreamMsg processes wire messages related to commitment state
 // updates from the upstream peer. The upstream peer is the peer whom we have a
 // direct channel with, updating our respective commitment chains.
 func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
+	// Once the remote peer has sent stfu, it must not send any further
+	// updates until the channel resumes.
+	switch msg.(type) {
+	case *lnwire.UpdateAddHTLC, *lnwire.UpdateFulfillHTLC,
+		*lnwire.UpdateFailHTLC, *lnwire.UpdateFailMalformedHTLC,
+		*lnwire.UpdateFee:
+
+		if l.quiescence.remoteReceived {
+			l.fail(DisconnectProtocolViolation,
+				"received %T after stfu", msg)
+			return
+		}
+	}
+
 	switch msg := msg.(type) {
 
 	case *lnwire.UpdateAddHTLC:
+		// Duplicates and extras, e.g. those retransmitted once the
+		// channel has been re-established, are handled as per our
+		// ExtraHTLCPolicy.
+		if !l.admitUpstreamAdd(msg) {
+			return
+		}
+
 		// We just received an add request from an upstream peer, so we
 		// add it to our state machine, then add the HTLC to our
 		// "settle" list in the event that we know the preimage.
 		index, err := l.channel.ReceiveHTLC(msg)
 		if err != nil {
-			l.fail("unable to handle upstream add HTLC: %v", err)
+			l.fail(DisconnectProtocolViolation,
+				"unable to handle upstream add HTLC: %v", err)
 			return
 		}
 
@@ -975,10 +2014,18 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 		idx := msg.ID
 		if err := l.channel.ReceiveHTLCSettle(pre, idx); err != nil {
 			// TODO(roasbeef): broadcast on-chain
-			l.fail("unable to handle upstream settle HTLC: %v", err)
+			l.fail(DisconnectProtocolViolation,
+				"unable to handle upstream settle HTLC: %v", err)
 			return
 		}
 
+		l.activity.settled()
+		if l.fwdLatency.resolved(idx, true) {
+			l.fwdOutcomes.resolved(true)
+		}
+		l.fwdSizes.resolved(idx, true)
+		l.traceDownstreamResolved(idx)
+
 		// TODO(roasbeef): pipeline to switch
 
 		// As we've learned of a new preimage for the first time, we'll
@@ -1029,19 +2076,37 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 		// message to the usual HTLC fail message.
 		err := l.channel.ReceiveFailHTLC(msg.ID, b.Bytes())
 		if err != nil {
-			l.fail("unable to handle upstream fail HTLC: %v", err)
+			l.fail(DisconnectProtocolViolation,
+				"unable to handle upstream fail HTLC: %v", err)
 			return
 		}
+		l.activity.failed()
+		if l.fwdLatency.resolved(msg.ID, false) {
+			l.fwdOutcomes.resolved(false)
+		}
+		l.fwdSizes.resolved(msg.ID, false)
+		l.traceDownstreamResolved(msg.ID)
 
 	case *lnwire.UpdateFailHTLC:
 		idx := msg.ID
 		err := l.channel.ReceiveFailHTLC(idx, msg.Reason[:])
 		if err != nil {
-			l.fail("unable to handle upstream fail HTLC: %v", err)
+			l.fail(DisconnectProtocolViolation,
+				"unable to handle upstream fail HTLC: %v", err)
 			return
 		}
+		l.activity.failed()
+		if l.fwdLatency.resolved(idx, false) {
+			l.fwdOutcomes.resolved(false)
+		}
+		l.fwdSizes.resolved(idx, false)
+		l.traceDownstreamResolved(idx)
 
 	case *lnwire.CommitSig:
+		// Any retransmissions precede the first commitment signed by
+		// the remote peer.
+		atomic.StoreInt32(&l.reestablishing, 0)
+
 		// We just received a new updates to our local commitment
 		// chain, validate this new commitment, closing the link if
 		// invalid.
@@ -1060,8 +2125,10 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 				})
 			}
 
-			l.fail("ChannelPoint(%v): unable to accept new "+
-				"commitment: %v", l.channel.ChannelPoint(), err)
+			l.fail(DisconnectProtocolViolation,
+				"ChannelPoint(%v): unable to accept new "+
+					"commitment: %v",
+				l.channel.ChannelPoint(), err)
 			return
 		}
 
@@ -1074,6 +2141,8 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 			return
 		}
 		l.cfg.Peer.SendMessage(nextRevocation)
+		l.activity.setPendingHTLCs(len(l.channel.ActiveHtlcs()))
+		l.notifyCommitment(&l.channel.State().LocalCommitment, true)
 
 		// Since we just revoked our commitment, we may have a new set
 		// of HTLC's on our commitment, so we'll send them over our
@@ -1103,6 +2172,8 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 		// then we don't need to reply with a signature as both sides
 		// already have a commitment with the latest accepted l.
 		if l.channel.FullySynced() {
+			l.checkHtlcsCleared()
+			l.checkQuiescence()
 			return
 		}
 
@@ -1110,7 +2181,8 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 		// so we'll reply with a signature to provide them with their
 		// version of the latest commitment.
 		if err := l.updateCommitTx(); err != nil {
-			l.fail("unable to update commitment: %v", err)
+			l.fail(DisconnectCommitmentError,
+				"unable to update commitment: %v", err)
 			return
 		}
 
@@ -1120,20 +2192,42 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 		// revocation window.
 		htlcs, err := l.channel.ReceiveRevocation(msg)
 		if err != nil {
-			l.fail("unable to accept revocation: %v", err)
+			l.fail(DisconnectProtocolViolation,
+				"unable to accept revocation: %v", err)
 			return
 		}
 
+		// A valid revocation completes a commitment round-trip, which
+		// confirms that the remote peer's view of the channel matches
+		// our own.
+		l.endWarmUp("commitment round-trip completed")
+		l.activity.setPendingHTLCs(len(l.channel.ActiveHtlcs()))
+		l.notifyCommitment(&l.channel.State().RemoteCommitment, false)
+
 		// After we treat HTLCs as included in both remote/local
 		// commitment transactions they might be safely propagated over
 		// htlc switch or settled if our node was last node in htlc
 		// path.
 		htlcsToForward := l.processLockedInHtlcs(htlcs)
+		for _, packet := range htlcsToForward {
+			if _, ok := packet.htlc.(*lnwire.UpdateAddHTLC); ok {
+				l.activity.forwarded()
+				break
+			}
+		}
+		l.checkHtlcsCleared()
+		l.checkQuiescence()
+		l.signAfterRevocation()
+
+		// The link may be transferred to another switch while the
+		// packets are being forwarded, so we'll hand them to the
+		// switch we're currently attached to.
+		htlcSwitch := l.cfg.Switch
 		go func() {
 			log.Debugf("ChannelPoint(%v) forwarding %v HTLC's",
 				l.channel.ChannelPoint(), len(htlcsToForward))
 			for _, packet := range htlcsToForward {
-				if err := l.cfg.Switch.forward(packet); err != nil {
+				if err := htlcSwitch.forward(packet); err != nil {
 					// TODO(roasbeef): cancel back htlc
 					// under certain conditions?
 					log.Errorf("channel link(%v): "+
@@ -1148,10 +2242,19 @@ func (l *channelLink) handleUpstreamMsg(msg lnwire.Message) {
 		// We received fee update from peer. If we are the initiator we
 		// will fail the channel, if not we will apply the update.
 		fee := lnwallet.SatPerKWeight(msg.FeePerKw)
+		if err := l.checkRemoteFeeUpdate(fee); err != nil {
+			l.fail(DisconnectProtocolViolation,
+				"rejecting fee update: %v", err)
+			return
+		}
 		if err := l.channel.ReceiveUpdateFee(fee); err != nil {
-			l.fail("error receiving fee update: %v", err)
+			l.fail(DisconnectProtocolViolation,
+				"error receiving fee update: %v", err)
 			return
 		}
+
+	case *lnwire.Stfu:
+		l.handleStfu(msg)
 	}
 }
 
@@ -1175,6 +2278,22 @@ func (l *channelLink) updateCommitTx() error {
 	}
 	l.cfg.Peer.SendMessage(commitSig)
 
+	// All traced HTLC's added since our last signature are now covered
+	// by the remote party's new commitment.
+	for _, key := range l.uncommittedTraces {
+		l.cfg.Switch.tracer.record(key.chanID, key.htlcID, TraceCommitted)
+	}
+	l.uncommittedTraces = nil
+
+	// The resolutions covered by this commitment are now persisted
+	// within the channel's state, so the switch can release them.
+	if len(l.uncommittedResolutions) > 0 {
+		l.cfg.Switch.ackResolutions(
+			l.ShortChanID(), l.uncommittedResolutions,
+		)
+		l.uncommittedResolutions = nil
+	}
+
 	// We've just initiated a state transition, attempt to stop the
 	// logCommitTimer. If the timer already ticked, then we'll consume the
 	// value, dropping
@@ -1189,6 +2308,10 @@ func (l *channelLink) updateCommitTx() error {
 	// Finally, clear our the current batch, so we can accurately make
 	// further batch flushing decisions.
 	l.batchCounter = 0
+	l.pacer.uncommittedAdds = 0
+	l.pacer.commitTick = nil
+	l.resolutionBatch.pending = 0
+	l.resolutionBatch.flushTick = nil
 
 	return nil
 }
@@ -1256,14 +2379,30 @@ type getBandwidthCmd struct {
 // Bandwidth returns the total amount that can flow through the channel link at
 // this given instance. The value returned is expressed in millisatoshi and can
 // be used by callers when making forwarding decisions to determine if a link
-// can accept an HTLC.
+// can accept an HTLC. As forwarded HTLC's can't dip into the link's soft
+// reserve, it's excluded.
 //
 // NOTE: Part of the ChannelLink interface.
 func (l *channelLink) Bandwidth() lnwire.MilliSatoshi {
+	bandwidth := l.LocalBandwidth()
+	if bandwidth < l.cfg.SoftReserve {
+		return 0
+	}
+
+	return bandwidth - l.cfg.SoftReserve
+}
+
+// LocalBandwidth returns the total amount that can flow through the channel
+// link for HTLC's initiated by us. Unlike Bandwidth, it includes the link's
+// soft reserve.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) LocalBandwidth() lnwire.MilliSatoshi {
 	channelBandwidth := l.channel.AvailableBalance()
 	overflowBandwidth := l.overflowQueue.TotalHtlcAmount()
 	linkBandwidth := channelBandwidth - overflowBandwidth
 	reserve := lnwire.NewMSatFromSatoshis(l.channel.LocalChanReserve())
+	reserve += l.anchorReserve()
 
 	// If the channel reserve is greater than the total available
 	// balance of the link, just return 0.
@@ -1273,16 +2412,217 @@ func (l *channelLink) Bandwidth() lnwire.MilliSatoshi {
 
 	// Else the amount that is available to flow through the link at
 	// this point is the available balance minus the reserve amount
-	// we are required to keep as collateral.
+	// we are required to keep as collateral, along with the value of any
+	// anchor outputs we pay for.
 	return linkBandwidth - reserve
 }
 
+// validateSoftReserve returns an error if the link's soft reserve together
+// with the channel reserve exceeds our total balance, in which case nothing
+// can be forwarded over the link.
+func (l *channelLink) validateSoftReserve() error {
+	if l.cfg.SoftReserve == 0 {
+		return nil
+	}
+
+	localBalance := l.channel.StateSnapshot().LocalBalance
+	chanReserve := lnwire.NewMSatFromSatoshis(l.channel.LocalChanReserve())
+	if l.cfg.SoftReserve+chanReserve > localBalance {
+		return errors.Errorf("soft reserve of %v and channel reserve "+
+			"of %v exceed local balance of %v", l.cfg.SoftReserve,
+			chanReserve, localBalance)
+	}
+
+	return nil
+}
+
+// HasFreeSlot returns true if the link's commitment transaction is able to
+// accommodate another outgoing HTLC, as our active outgoing HTLC's haven't yet
+// reached the remote party's MaxAcceptedHtlcs.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) HasFreeSlot() bool {
+	return l.freeSlots() > 0
+}
+
+// clearHtlcsReq is a message sent to a channel link in order to have it stop
+// accepting new HTLC's, and notify the caller once all existing HTLC's have
+// been resolved.
+type clearHtlcsReq struct {
+	cleared chan struct{}
+}
+
+// ClearHTLCsForClose instructs the link to stop accepting any new HTLC's, and
+// blocks until all outstanding HTLC's on the channel have been resolved. Any
+// locally initiated HTLC's still residing within the overflow queue are failed
+// back. Once the channel is free of HTLC's, nil is returned signalling that
+// the channel is ready for cooperative close negotiation. If the passed
+// context expires first, then ErrLinkClearTimeout is returned and the channel
+// should instead be force closed.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) ClearHTLCsForClose(ctx context.Context) error {
+	cmd := &clearHtlcsReq{
+		cleared: make(chan struct{}),
+	}
+
+	select {
+	case l.linkControl <- cmd:
+	case <-ctx.Done():
+		return ErrLinkClearTimeout
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+
+	select {
+	case <-cmd.cleared:
+		return nil
+	case <-ctx.Done():
+		return ErrLinkClearTimeout
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+}
+
+// CancelClearHTLCs has the link resume accepting new HTLC's after an attempt
+// to clear it for close has been abandoned, e.g. as ClearHTLCsForClose timed
+// out and the close request failed.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) CancelClearHTLCs() {
+	if atomic.CompareAndSwapInt32(&l.clearingForClose, 1, 0) {
+		log.Infof("ChannelLink(%v) no longer clearing htlcs for close, "+
+			"accepting new htlcs", l)
+	}
+}
+
+// checkHtlcsCleared notifies all callers of ClearHTLCsForClose once both
+// commitment transactions are fully synced, free of HTLC's, and no HTLC's
+// remain within the overflow queue.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) checkHtlcsCleared() {
+	if len(l.htlcsClearedSignals) == 0 {
+		return
+	}
+
+	if !l.channel.FullySynced() || len(l.channel.ActiveHtlcs()) != 0 ||
+		l.overflowQueue.Length() != 0 {
+
+		return
+	}
+
+	log.Infof("ChannelLink(%v) has cleared all htlcs, ready for "+
+		"cooperative close", l)
+
+	for _, cleared := range l.htlcsClearedSignals {
+		close(cleared)
+	}
+	l.htlcsClearedSignals = nil
+}
+
+// resolveHeldHTLC delivers the resolution of a parked HTLC to the link.
+func (l *channelLink) resolveHeldHTLC(resolution *heldHTLCResolution) {
+	select {
+	case l.linkControl <- resolution:
+	case <-l.quit:
+	}
+}
+
+// handleHeldHTLCResolution settles or cancels a parked HTLC according to the
+// resolution received from the switch.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleHeldHTLCResolution(req *heldHTLCResolution) {
+	htlc, ok := l.heldHtlcs[req.htlcIndex]
+	if !ok {
+		return
+	}
+	delete(l.heldHtlcs, req.htlcIndex)
+
+	if req.preimage == nil {
+		log.Infof("ChannelLink(%v) cancelling parked htlc(%x)", l,
+			htlc.paymentHash[:])
+
+		failure := lnwire.FailUnknownPaymentHash{}
+		l.sendHTLCError(htlc.htlcIndex, failure, htlc.obfuscator)
+	} else {
+		log.Infof("ChannelLink(%v) settling parked htlc(%x)", l,
+			htlc.paymentHash[:])
+
+		preimage := *req.preimage
+		err := l.channel.SettleHTLC(preimage, htlc.htlcIndex)
+		if err != nil {
+			l.fail(DisconnectCommitmentError,
+				"unable to settle htlc: %v", err)
+			return
+		}
+		l.recordIncomingFlow(htlc.htlcIndex, false)
+		l.cfg.Switch.hashWatcher.resolved(
+			l.ShortChanID(), htlc.htlcIndex, true,
+		)
+
+		// As the preimage was learned externally, we'll add it to
+		// the preimage cache so any contested contracts can be swept
+		// on-chain.
+		go func() {
+			err := l.cfg.PreimageCache.AddPreimage(preimage[:])
+			if err != nil {
+				log.Errorf("unable to add preimage=%x to "+
+					"cache", preimage[:])
+			}
+		}()
+
+		l.cfg.Peer.SendMessage(&lnwire.UpdateFulfillHTLC{
+			ChanID:          l.ChanID(),
+			ID:              htlc.htlcIndex,
+			PaymentPreimage: preimage,
+		})
+	}
+
+	if err := l.updateCommitTx(); err != nil {
+		l.fail(DisconnectCommitmentError,
+			"unable to update commitment: %v", err)
+	}
+}
+
+// expireHeldHTLCs cancels the hold resolution of any parked HTLC's which are
+// now too close to their expiry to be held any longer. This cancels all other
+// HTLC's parked for the same payment hash as well.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) expireHeldHTLCs() {
+	for _, htlc := range l.heldHtlcs {
+		if htlc.expiry-expiryGraceDelta > l.bestHeight {
+			continue
+		}
+
+		log.Warnf("ChannelLink(%v) parked htlc(%x) is about to "+
+			"expire: expiry=%v, best_height=%v, cancelling", l,
+			htlc.paymentHash[:], htlc.expiry, l.bestHeight)
+
+		err := l.cfg.Switch.resolveHold(htlc.paymentHash, nil)
+		switch {
+		case err == nil:
+			l.cfg.Switch.expireReview(htlc.paymentHash)
+		case err != ErrHoldResolved:
+			log.Errorf("unable to cancel parked htlc: %v", err)
+		}
+	}
+}
+
 // policyUpdate is a message sent to a channel link when an outside sub-system
 // wishes to update the current forwarding policy.
 type policyUpdate struct {
-	policy ForwardingPolicy
+	policy DirectionalPolicy
 
-	done chan struct{}
+	err chan error
+}
+
+// policyQuery is a message sent to a channel link in order to retrieve its
+// current forwarding policy.
+type policyQuery struct {
+	resp chan DirectionalPolicy
 }
 
 // UpdateForwardingPolicy updates the forwarding policy for the target
@@ -1294,22 +2634,149 @@ type policyUpdate struct {
 //
 // NOTE: Part of the ChannelLink interface.
 func (l *channelLink) UpdateForwardingPolicy(newPolicy ForwardingPolicy) {
+	err := l.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: newPolicy,
+	})
+	if err != nil {
+		log.Errorf("ChannelLink(%v) unable to update forwarding "+
+			"policy: %v", l, err)
+	}
+}
+
+// UpdateDirectionalPolicy updates both the forwarding policy and the inbound
+// fee of the target ChannelLink. Uninitialized fields of the outbound policy
+// won't override those of the current policy, and a nil inbound fee leaves
+// the current inbound fee unchanged. The update is rejected if the resulting
+// inbound discount could render a forward unprofitable.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) UpdateDirectionalPolicy(newPolicy DirectionalPolicy) error {
 	cmd := &policyUpdate{
 		policy: newPolicy,
-		done:   make(chan struct{}),
+		err:    make(chan error, 1),
 	}
 
 	select {
 	case l.linkControl <- cmd:
 	case <-l.quit:
+		return fmt.Errorf("link shutting down")
 	}
 
 	select {
-	case <-cmd.done:
+	case err := <-cmd.err:
+		return err
 	case <-l.quit:
+		return fmt.Errorf("link shutting down")
 	}
 }
 
+// CurrentForwardingPolicy returns the forwarding policy currently applied by
+// the link, along with its inbound fee.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) CurrentForwardingPolicy() DirectionalPolicy {
+	query := &policyQuery{
+		resp: make(chan DirectionalPolicy, 1),
+	}
+
+	select {
+	case l.linkControl <- query:
+	case <-l.quit:
+		return DirectionalPolicy{}
+	}
+
+	select {
+	case policy := <-query.resp:
+		return policy
+	case <-l.quit:
+		return DirectionalPolicy{}
+	}
+}
+
+// currentPolicy returns the link's current forwarding policy and inbound fee.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) currentPolicy() DirectionalPolicy {
+	inbound := l.inboundFee
+	role := l.ChannelRole()
+	return DirectionalPolicy{
+		Outbound: l.cfg.FwrdingPolicy,
+		Inbound:  &inbound,
+		Role:     &role,
+	}
+}
+
+// handlePolicyUpdate merges the passed policy into the link's current policy,
+// applying the result only if it's valid.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handlePolicyUpdate(req DirectionalPolicy) error {
+	// In order to avoid overriding a valid policy with a "null" field in
+	// the new policy, we'll only update to the set sub policy if the new
+	// value isn't uninitialized.
+	policy := l.currentPolicy()
+	if req.Outbound.BaseFee != 0 {
+		policy.Outbound.BaseFee = req.Outbound.BaseFee
+	}
+	if req.Outbound.FeeRate != 0 {
+		policy.Outbound.FeeRate = req.Outbound.FeeRate
+	}
+	if req.Outbound.TimeLockDelta != 0 {
+		policy.Outbound.TimeLockDelta = req.Outbound.TimeLockDelta
+	}
+	if req.Outbound.EnforcedTimeLockDelta != 0 {
+		policy.Outbound.EnforcedTimeLockDelta =
+			req.Outbound.EnforcedTimeLockDelta
+	}
+	switch {
+	case req.DisableProbeFee:
+		policy.Outbound.ProbeThreshold = 0
+		policy.Outbound.ProbePolicy = ProbeFeePolicy{}
+
+	case req.Outbound.ProbeThreshold != 0:
+		policy.Outbound.ProbeThreshold = req.Outbound.ProbeThreshold
+		policy.Outbound.ProbePolicy = req.Outbound.ProbePolicy
+	}
+	if req.Outbound.MaxValueInFlight != 0 {
+		policy.Outbound.MaxValueInFlight = req.Outbound.MaxValueInFlight
+	}
+	if req.Outbound.MaxAtRiskExposure != 0 {
+		policy.Outbound.MaxAtRiskExposure = req.Outbound.MaxAtRiskExposure
+	}
+	if req.Outbound.MaxExtraCltv != 0 {
+		policy.Outbound.MaxExtraCltv = req.Outbound.MaxExtraCltv
+	}
+	if req.Inbound != nil {
+		inbound := *req.Inbound
+		policy.Inbound = &inbound
+	}
+	if req.Role != nil {
+		role := *req.Role
+		policy.Role = &role
+	}
+
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	if err := l.validateMaxValueInFlight(policy.Outbound); err != nil {
+		return err
+	}
+
+	// A changed role is persisted before it's applied, such that it
+	// survives restarts.
+	if *policy.Role != l.ChannelRole() && l.cfg.StoreRole != nil {
+		if err := l.cfg.StoreRole(*policy.Role); err != nil {
+			return err
+		}
+	}
+
+	l.cfg.FwrdingPolicy = policy.Outbound
+	l.inboundFee = *policy.Inbound
+	atomic.StoreUint32(&l.role, uint32(*policy.Role))
+
+	return nil
+}
+
 // Stats returns the statistics of channel link.
 //
 // NOTE: Part of the ChannelLink interface.
@@ -1321,6 +2788,158 @@ func (l *channelLink) Stats() (uint64, lnwire.MilliSatoshi, lnwire.MilliSatoshi)
 		snapshot.TotalMSatReceived
 }
 
+// CommitmentState describes the current fee and dust parameters of a link's
+// commitment transactions.
+type CommitmentState struct {
+	// CommitmentType is the format of the commitment transactions.
+	CommitmentType CommitmentType
+
+	// FeePerKw is the current commitment fee rate.
+	FeePerKw lnwallet.SatPerKWeight
+
+	// LocalDustLimit is the dust limit of our commitment transaction.
+	LocalDustLimit btcutil.Amount
+
+	// RemoteDustLimit is the dust limit of the remote party's commitment
+	// transaction.
+	RemoteDustLimit btcutil.Amount
+
+	// DustThreshold is the smallest incoming HTLC which wouldn't be
+	// trimmed as dust from either commitment at the current fee rate.
+	DustThreshold btcutil.Amount
+
+	// StressFeePerKw is the fee rate used to stress test incoming HTLC's,
+	// which is the current fee rate plus the configured headroom.
+	StressFeePerKw lnwallet.SatPerKWeight
+
+	// StressDustThreshold is the smallest incoming HTLC which wouldn't be
+	// trimmed as dust from either commitment at the stress fee rate.
+	StressDustThreshold btcutil.Amount
+
+	// UneconomicThreshold is the smallest HTLC paying to us which is
+	// accepted, given the configured multiple of its estimated on-chain
+	// claim cost. If zero, then the check is disabled.
+	UneconomicThreshold btcutil.Amount
+
+	// ValueInFlight is the total value of the HTLC's we've offered which
+	// haven't yet been settled or failed.
+	ValueInFlight lnwire.MilliSatoshi
+
+	// AtRiskExposure is the total value of the non-dust HTLC's on our
+	// current commitment transaction, in either direction.
+	AtRiskExposure lnwire.MilliSatoshi
+
+	// FeeUpdateTolerance is the percentage band around our own fee
+	// estimate within which the commitment fee rate is left as is.
+	FeeUpdateTolerance uint32
+
+	// FeeUpdateHardLimit is the percentage beyond which a fee rate
+	// proposed by the remote party is treated as fatal. If zero, then
+	// proposals aren't checked.
+	FeeUpdateHardLimit uint32
+
+	// FeeDisagreements is the number of fee rates proposed by the remote
+	// party which fell outside of the tolerance band, but were accepted.
+	FeeDisagreements uint64
+}
+
+// CommitmentState returns the current fee and dust parameters of the link's
+// commitment transactions, including the dust threshold under the stress test
+// fee rate.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) CommitmentState() CommitmentState {
+	headroom := l.cfg.DustStressHeadroom
+	if headroom == 0 {
+		headroom = DefaultDustStressHeadroom
+	}
+
+	chanState := l.channel.State()
+	feePerKw := l.channel.CommitFeeRate()
+	stressFeePerKw := feePerKw + headroom.FeePerKWeight()
+
+	return CommitmentState{
+		CommitmentType:      l.CommitmentType(),
+		FeePerKw:            feePerKw,
+		LocalDustLimit:      chanState.LocalChanCfg.DustLimit,
+		RemoteDustLimit:     chanState.RemoteChanCfg.DustLimit,
+		DustThreshold:       l.htlcDustThreshold(feePerKw),
+		StressFeePerKw:      stressFeePerKw,
+		StressDustThreshold: l.htlcDustThreshold(stressFeePerKw),
+		UneconomicThreshold: l.uneconomicThreshold(),
+		ValueInFlight:       l.channel.OutgoingValueInFlight(),
+		AtRiskExposure:      l.AtRiskExposure(),
+		FeeUpdateTolerance:  l.feeUpdateTolerance(),
+		FeeUpdateHardLimit:  l.cfg.FeeUpdateHardLimit,
+		FeeDisagreements:    atomic.LoadUint64(&l.feeDisagreements),
+	}
+}
+
+// isStressDust returns true if the passed incoming HTLC would be trimmed as
+// dust from either commitment transaction under the stress test fee rate.
+func (l *channelLink) isStressDust(pd *lnwallet.PaymentDescriptor) bool {
+	state := l.CommitmentState()
+	threshold := lnwire.NewMSatFromSatoshis(state.StressDustThreshold)
+	if pd.Amount >= threshold {
+		return false
+	}
+
+	log.Errorf("Incoming htlc(%x) would be dust under stress fee rate "+
+		"%v: threshold=%v, htlc_value=%v", pd.RHash[:],
+		state.StressFeePerKw, threshold, pd.Amount)
+
+	return true
+}
+
+// CommitHeights returns the current height of the local and remote commitment
+// chains, along with the height of the next remote commitment we're awaiting a
+// revocation for. These values reflect the in-memory state of the channel.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) CommitHeights() (uint64, uint64, uint64) {
+	return l.channel.CommitHeights()
+}
+
+// StatsDetail returns a detailed set of statistics gathered by the channel
+// link over its lifetime.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) StatsDetail() *LinkStatsDetail {
+	snapshot := l.channel.StateSnapshot()
+	localHeight, remoteHeight, pendingRevocation := l.CommitHeights()
+
+	return &LinkStatsDetail{
+		NumUpdates:              snapshot.ChannelCommitment.CommitHeight,
+		TotalSent:               snapshot.TotalMSatSent,
+		TotalReceived:           snapshot.TotalMSatReceived,
+		LocalCommitHeight:       localHeight,
+		RemoteCommitHeight:      remoteHeight,
+		PendingRevocationHeight: pendingRevocation,
+		OverflowWaitTime:        l.overflowQueue.WaitTimes(),
+		DownstreamLatency:       l.fwdLatency.latency.Snapshot(),
+		HopLatency:              l.cfg.Switch.tracer.hopLatencySnapshot(l.ShortChanID()),
+		OverflowParked:          l.overflowQueue.NumParked(),
+		OverflowExpired:         l.overflowQueue.NumExpired(),
+		ForwardOutcomes:         l.fwdOutcomes.snapshot(),
+		SizeBuckets:             l.fwdSizes.snapshot(l.cfg.Switch.forwardSizeBuckets()),
+		MeanResolutionJitter:    l.fwdJitter.mean(),
+		ExtraHTLCs:              l.extraHTLCs.snapshot(),
+	}
+}
+
+// StatsSnapshot returns an operational snapshot of the link's activity,
+// describing the link by its peer and short channel ID.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) StatsSnapshot() *LinkStatsSnapshot {
+	snapshot := l.activity.snapshot(l.cfg.Peer, l.ShortChanID())
+	snapshot.ReserveLocked = l.ReserveInfo().Locked
+	snapshot.ExpiryTooSoonFailures, snapshot.RecommendedTimeLockDelta =
+		l.expiryTooSoon.snapshot()
+
+	return snapshot
+}
+
 // String returns the string representation of channel link.
 //
 // NOTE: Part of the ChannelLink interface.
@@ -1334,6 +2953,12 @@ func (l *channelLink) String() string {
 //
 // NOTE: Part of the ChannelLink interface.
 func (l *channelLink) HandleSwitchPacket(packet *htlcPacket) {
+	// Adds of an impossibly large amount are failed right away, before
+	// they can reach the channel's state machine.
+	if l.failInsaneAdd(packet) {
+		return
+	}
+
 	l.mailBox.AddPacket(packet)
 }
 
@@ -1374,6 +2999,50 @@ func (l *channelLink) updateChannelFee(feePerKw lnwallet.SatPerKWeight) error {
 	return l.updateCommitTx()
 }
 
+// validateExitHopPayload double checks the hop-payload of an HTLC for which
+// we're the exit hop, to ensure that it was crafted correctly by the sender
+// and matches the HTLC we were extended. These checks don't depend on an
+// invoice, so they're applied before the HTLC is settled, or parked with the
+// switch. A nil failure is returned if the payload is valid.
+func (l *channelLink) validateExitHopPayload(pd *lnwallet.PaymentDescriptor,
+	fwdInfo ForwardingInfo, heightNow uint32) lnwire.FailureMessage {
+
+	if l.cfg.DebugHTLC {
+		return nil
+	}
+
+	// The sender can't have us receive more than the HTLC carries.
+	if fwdInfo.AmountToForward > pd.Amount {
+		log.Errorf("Onion payload of incoming htlc(%x) has incorrect "+
+			"value: expected at most %v, got %v", pd.RHash[:],
+			pd.Amount, fwdInfo.AmountToForward)
+
+		return lnwire.NewFinalIncorrectHtlcAmount(pd.Amount)
+	}
+
+	// We'll also ensure that our time-lock value has been computed
+	// correctly.
+	//
+	// TODO(roasbeef): also accept global default?
+	expectedHeight := heightNow + l.cfg.FwrdingPolicy.TimeLockDelta
+	switch {
+	case fwdInfo.OutgoingCTLV < expectedHeight:
+		log.Errorf("Onion payload of incoming htlc(%x) has incorrect "+
+			"time-lock: expected %v, got %v", pd.RHash[:],
+			expectedHeight, fwdInfo.OutgoingCTLV)
+
+		return lnwire.NewFinalIncorrectCltvExpiry(fwdInfo.OutgoingCTLV)
+
+	case pd.Timeout != fwdInfo.OutgoingCTLV:
+		log.Errorf("HTLC(%x) has incorrect time-lock: expected %v, "+
+			"got %v", pd.RHash[:], pd.Timeout, fwdInfo.OutgoingCTLV)
+
+		return lnwire.NewFinalIncorrectCltvExpiry(fwdInfo.OutgoingCTLV)
+	}
+
+	return nil
+}
+
 // processLockedInHtlcs serially processes each of the log updates which have
 // been "locked-in". An HTLC is considered locked-in once it has been fully
 // committed to in both the remote and local commitment state. Once a channel
@@ -1385,6 +3054,13 @@ func (l *channelLink) processLockedInHtlcs(
 	var (
 		needUpdate       bool
 		packetsToForward []*htlcPacket
+
+		// invoicesToSettle accumulates the invoices paid to by the
+		// exit hop HTLC's settled within this batch, such that they
+		// can be settled together once all HTLC's have been processed.
+		invoicesToSettle []chainhash.Hash
+		fulfillMsgs      []*lnwire.UpdateFulfillHTLC
+		settledInvoices  = make(map[chainhash.Hash]struct{})
 	)
 
 	for _, pd := range paymentDescriptors {
@@ -1439,6 +3115,11 @@ func (l *channelLink) processLockedInHtlcs(
 		// or are able to settle it (and it adheres to our fee related
 		// constraints).
 		case lnwallet.Add:
+			l.cfg.Switch.hashWatcher.added(
+				pd.RHash, l.ShortChanID(), pd.HtlcIndex,
+				pd.Amount,
+			)
+
 			// Fetch the onion blob that was included within this
 			// processed payment descriptor.
 			var onionBlob [lnwire.OnionPacketSize]byte
@@ -1464,6 +3145,35 @@ func (l *channelLink) processLockedInHtlcs(
 				continue
 			}
 
+			// If we're clearing the channel in preparation for a
+			// cooperative close, then we won't accept any new
+			// HTLC's, so we'll cancel this one back immediately.
+			if l.isClearingForClose() {
+				log.Debugf("ChannelLink(%v) is clearing htlcs "+
+					"for close, rejecting incoming htlc "+
+					"with payment hash(%x)", l, pd.RHash[:])
+
+				failure := lnwire.NewTemporaryChannelFailure(nil)
+				l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+				needUpdate = true
+				continue
+			}
+
+			// Likewise, if the channel is pending force close, then
+			// any new HTLC would only be added to a commitment
+			// that's bound to be superseded on-chain, so we'll
+			// reject it permanently.
+			if l.isPendingClose() {
+				log.Debugf("ChannelLink(%v) is pending force "+
+					"close, rejecting incoming htlc with "+
+					"payment hash(%x)", l, pd.RHash[:])
+
+				failure := &lnwire.FailPermanentChannelFailure{}
+				l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+				needUpdate = true
+				continue
+			}
+
 			// Before adding the new htlc to the state machine,
 			// parse the onion object in order to obtain the
 			// routing information with DecodeHopIterator function
@@ -1515,43 +3225,155 @@ func (l *channelLink) processLockedInHtlcs(
 						pd.Timeout, heightNow)
 
 					failure := lnwire.FailFinalIncorrectCltvExpiry{}
-					l.sendHTLCError(pd.HtlcIndex, &failure, obfuscator)
+					if l.failExitHop(pd.HtlcIndex, &failure, obfuscator) {
+						needUpdate = true
+					}
+					continue
+				}
+
+				// Before the HTLC is settled, or parked with
+				// the switch, we'll check that its hop-payload
+				// matches the HTLC we were extended.
+				failure := l.validateExitHopPayload(
+					pd, fwdInfo, heightNow,
+				)
+				if failure != nil {
+					if l.failExitHop(
+						pd.HtlcIndex, failure, obfuscator,
+					) {
+						needUpdate = true
+					}
+					continue
+				}
+
+				// If this HTLC is returning to us over this
+				// link as part of a rebalance we initiated,
+				// then we already know its preimage, so we'll
+				// settle it directly rather than against an
+				// invoice.
+				invoiceHash := chainhash.Hash(pd.RHash)
+				if preimage, ok := l.cfg.Switch.rebalancePreimage(
+					invoiceHash, l.ShortChanID(),
+				); ok {
+					log.Infof("ChannelLink(%v) settling "+
+						"rebalance htlc(%x)", l,
+						pd.RHash[:])
+
+					err := l.channel.SettleHTLC(
+						preimage, pd.HtlcIndex,
+					)
+					if err != nil {
+						l.fail(DisconnectCommitmentError,
+							"unable to settle htlc: %v",
+							err)
+						return nil
+					}
+					l.recordIncomingFlow(
+						pd.HtlcIndex, false,
+					)
+					l.cfg.Switch.hashWatcher.resolved(
+						l.ShortChanID(), pd.HtlcIndex,
+						true,
+					)
+
+					// There's no invoice backing the
+					// preimage, so we'll add it to the
+					// preimage cache so any contested
+					// contracts can be swept on-chain.
+					go func(preimage [32]byte) {
+						err := l.cfg.PreimageCache.AddPreimage(
+							preimage[:],
+						)
+						if err != nil {
+							log.Errorf("unable to add "+
+								"preimage=%x to cache",
+								preimage[:])
+						}
+					}(preimage)
+
+					fulfillMsgs = append(fulfillMsgs,
+						&lnwire.UpdateFulfillHTLC{
+							ChanID:          l.ChanID(),
+							ID:              pd.HtlcIndex,
+							PaymentPreimage: preimage,
+						},
+					)
 					needUpdate = true
 					continue
 				}
 
+				// If the application has registered a hold
+				// resolver for this payment hash, then we'll
+				// park the HTLC within the switch until it
+				// has been resolved externally.
+				held := &heldHTLC{
+					link:        l,
+					htlcIndex:   pd.HtlcIndex,
+					amount:      pd.Amount,
+					expiry:      pd.Timeout,
+					paymentHash: invoiceHash,
+					obfuscator:  obfuscator,
+					parkHeight:  heightNow,
+				}
+				if l.cfg.Switch.holdHTLC(held) {
+					l.heldHtlcs[pd.HtlcIndex] = held
+					continue
+				}
+
 				// We're the designated payment destination.
 				// Therefore we attempt to see if we have an
 				// invoice locally which'll allow us to settle
 				// this htlc.
-				invoiceHash := chainhash.Hash(pd.RHash)
 				invoice, err := l.cfg.Registry.LookupInvoice(invoiceHash)
 				if err != nil {
 					log.Errorf("unable to query invoice registry: "+
 						" %v", err)
 					failure := lnwire.FailUnknownPaymentHash{}
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
-					needUpdate = true
+					if l.failExitHop(pd.HtlcIndex, failure, obfuscator) {
+						needUpdate = true
+					}
 					continue
 				}
 
 				// If this invoice has already been settled,
+				// either on disk or earlier within this batch,
 				// then we'll reject it as we don't allow an
 				// invoice to be paid twice.
-				if invoice.Terms.Settled == true {
+				_, settledInBatch := settledInvoices[invoiceHash]
+				if invoice.Terms.Settled == true || settledInBatch {
 					log.Warnf("Rejecting duplicate "+
 						"payment for hash=%x", pd.RHash[:])
 					failure := lnwire.FailUnknownPaymentHash{}
-					l.sendHTLCError(
+					if l.failExitHop(
 						pd.HtlcIndex, failure, obfuscator,
-					)
-					needUpdate = true
+					) {
+						needUpdate = true
+					}
+					continue
+				}
+
+				// If the htlc is worth less than it would cost
+				// us to claim it on-chain, then we won't be
+				// able to enforce it, so we'll reject it.
+				threshold := lnwire.NewMSatFromSatoshis(
+					l.uneconomicThreshold(),
+				)
+				if !l.cfg.DebugHTLC && pd.Amount < threshold {
+					log.Errorf("Rejecting uneconomic htlc(%x): "+
+						"amount %v below claim cost "+
+						"threshold %v", pd.RHash[:],
+						pd.Amount, threshold)
+					failure := lnwire.FailIncorrectPaymentAmount{}
+					if l.failExitHop(pd.HtlcIndex, failure, obfuscator) {
+						needUpdate = true
+					}
 					continue
 				}
 
 				// If we're not currently in debug mode, and
 				// the extended htlc doesn't meet the value
-				// requested, then we'll fail the htlc.
+				// requested, less the UnderpaymentGrace, then
+				// we'll fail the htlc.
 				// Otherwise, we settle this htlc within our
 				// local state update log, then send the update
 				// entry to the remote party.
@@ -1563,13 +3385,14 @@ func (l *channelLink) processLockedInHtlcs(
 				// So since we expect the htlc to have a
 				// different amount, we should not fail.
 				if !l.cfg.DebugHTLC && invoice.Terms.Value > 0 &&
-					pd.Amount < invoice.Terms.Value {
+					!l.paysInvoice(pd.Amount, invoice.Terms.Value) {
 					log.Errorf("rejecting htlc due to incorrect "+
 						"amount: expected %v, received %v",
 						invoice.Terms.Value, pd.Amount)
 					failure := lnwire.FailIncorrectPaymentAmount{}
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
-					needUpdate = true
+					if l.failExitHop(pd.HtlcIndex, failure, obfuscator) {
+						needUpdate = true
+					}
 					continue
 				}
 
@@ -1586,7 +3409,9 @@ func (l *channelLink) processLockedInHtlcs(
 				// So since we expect the htlc to have a
 				// different amount, we should not fail.
 				if !l.cfg.DebugHTLC && invoice.Terms.Value > 0 &&
-					fwdInfo.AmountToForward != invoice.Terms.Value {
+					(fwdInfo.AmountToForward > invoice.Terms.Value ||
+						!l.paysInvoice(fwdInfo.AmountToForward,
+							invoice.Terms.Value)) {
 
 					log.Errorf("Onion payload of incoming "+
 						"htlc(%x) has incorrect value: "+
@@ -1595,69 +3420,101 @@ func (l *channelLink) processLockedInHtlcs(
 						fwdInfo.AmountToForward)
 
 					failure := lnwire.FailIncorrectPaymentAmount{}
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
-					needUpdate = true
+					if l.failExitHop(pd.HtlcIndex, failure, obfuscator) {
+						needUpdate = true
+					}
 					continue
 				}
 
-				// We'll also ensure that our time-lock value
-				// has been computed correctly.
-				//
-				// TODO(roasbeef): also accept global default?
-				expectedHeight := heightNow + l.cfg.FwrdingPolicy.TimeLockDelta
-				if !l.cfg.DebugHTLC {
-					switch {
-					case fwdInfo.OutgoingCTLV < expectedHeight:
-						log.Errorf("Onion payload of incoming "+
-							"htlc(%x) has incorrect time-lock: "+
-							"expected %v, got %v",
-							pd.RHash[:], expectedHeight,
-							fwdInfo.OutgoingCTLV)
-
-						failure := lnwire.NewFinalIncorrectCltvExpiry(
-							fwdInfo.OutgoingCTLV,
-						)
-						l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+				// If the preimage can't be derived, or the
+				// derived preimage doesn't match, then the
+				// payment details are incorrect.
+				preimage, err := l.exitHopPreimage(
+					invoiceHash, &invoice,
+				)
+				if err != nil {
+					log.Errorf("ChannelLink(%v) rejecting "+
+						"htlc(%x): %v", l, pd.RHash[:],
+						err)
+
+					failure := lnwire.FailUnknownPaymentHash{}
+					if l.failExitHop(
+						pd.HtlcIndex, failure, obfuscator,
+					) {
 						needUpdate = true
+					}
+					continue
+				}
+
+				// If the invoice requires review, then we'll
+				// hold the HTLC until an operator approves or
+				// rejects the payment.
+				if invoice.ReviewRequired {
+					held := &heldHTLC{
+						link:        l,
+						htlcIndex:   pd.HtlcIndex,
+						amount:      pd.Amount,
+						expiry:      pd.Timeout,
+						paymentHash: invoiceHash,
+						obfuscator:  obfuscator,
+						parkHeight:  heightNow,
+					}
+					if l.cfg.Switch.holdForReview(
+						held, preimage, l.cfg.Registry,
+					) {
+						l.heldHtlcs[pd.HtlcIndex] = held
 						continue
-					case pd.Timeout != fwdInfo.OutgoingCTLV:
-						log.Errorf("HTLC(%x) has incorrect "+
-							"time-lock: expected %v, got %v",
-							pd.RHash[:], pd.Timeout,
-							fwdInfo.OutgoingCTLV)
-
-						failure := lnwire.NewFinalIncorrectCltvExpiry(
-							fwdInfo.OutgoingCTLV,
-						)
-						l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+					}
+
+					failure := lnwire.NewTemporaryChannelFailure(nil)
+					if l.failExitHop(
+						pd.HtlcIndex, failure, obfuscator,
+					) {
 						needUpdate = true
-						continue
 					}
+					continue
 				}
 
-				preimage := invoice.Terms.PaymentPreimage
-				err = l.channel.SettleHTLC(preimage, pd.HtlcIndex)
-				if err != nil {
-					l.fail("unable to settle htlc: %v", err)
-					return nil
+				// With the payment checked, we'll settle the
+				// HTLC. If the preimage is unusable, then only
+				// this HTLC is failed back, and its invoice is
+				// left unsettled.
+				err = l.settleHTLC(
+					preimage, pd.HtlcIndex, pd.RHash,
+				)
+				if htlcErr, ok := err.(*HTLCError); ok {
+					log.Errorf("ChannelLink(%v) unable to "+
+						"settle htlc: %v", l, htlcErr)
+					if l.failExitHop(
+						pd.HtlcIndex,
+						htlcErr.FailureMessage,
+						obfuscator,
+					) {
+						needUpdate = true
+					}
+					continue
 				}
-
-				// Notify the invoiceRegistry of the invoices
-				// we just settled with this latest commitment
-				// update.
-				err = l.cfg.Registry.SettleInvoice(invoiceHash)
 				if err != nil {
-					l.fail("unable to settle invoice: %v", err)
+					l.fail(DisconnectCommitmentError,
+						"unable to settle htlc: %v",
+						err)
 					return nil
 				}
 
-				// HTLC was successfully settled locally send
-				// notification about it remote peer.
-				l.cfg.Peer.SendMessage(&lnwire.UpdateFulfillHTLC{
-					ChanID:          l.ChanID(),
-					ID:              pd.HtlcIndex,
-					PaymentPreimage: preimage,
-				})
+				// The invoice will be settled along with the
+				// rest of the batch, after which the remote
+				// peer is notified.
+				settledInvoices[invoiceHash] = struct{}{}
+				invoicesToSettle = append(
+					invoicesToSettle, invoiceHash,
+				)
+				fulfillMsgs = append(
+					fulfillMsgs, &lnwire.UpdateFulfillHTLC{
+						ChanID:          l.ChanID(),
+						ID:              pd.HtlcIndex,
+						PaymentPreimage: preimage,
+					},
+				)
 				needUpdate = true
 
 			// There are additional channels left within this
@@ -1665,11 +3522,19 @@ func (l *channelLink) processLockedInHtlcs(
 			// constraints have been properly met by by this
 			// incoming HTLC.
 			default:
+				// We'll assign the forward an ID with which
+				// its decisions and trace can be correlated.
+				correlationID := l.cfg.Switch.newCorrelationID()
+				l.cfg.Switch.tracer.start(
+					l.ShortChanID(), pd.HtlcIndex, pd.RHash,
+					correlationID,
+				)
+
 				// We want to avoid forwarding an HTLC which
 				// will expire in the near future, so we'll
 				// reject an HTLC if its expiration time is too
 				// close to the current height.
-				timeDelta := l.cfg.FwrdingPolicy.TimeLockDelta
+				timeDelta := l.cfg.FwrdingPolicy.enforcedTimeLockDelta()
 				if pd.Timeout-timeDelta <= heightNow {
 					log.Errorf("htlc(%x) has an expiry "+
 						"that's too soon: outgoing_expiry=%v, "+
@@ -1684,7 +3549,11 @@ func (l *channelLink) processLockedInHtlcs(
 						failure = lnwire.NewExpiryTooSoon(*update)
 					}
 
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+					l.rejectForward(
+						pd, fwdInfo, correlationID,
+						failure, obfuscator,
+					)
+					l.handleExpiryTooSoon()
 					needUpdate = true
 					continue
 				}
@@ -1711,7 +3580,31 @@ func (l *channelLink) processLockedInHtlcs(
 							pd.Amount, *update)
 					}
 
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+					l.rejectForward(
+						pd, fwdInfo, correlationID,
+						failure, obfuscator,
+					)
+					needUpdate = true
+					continue
+				}
+
+				// If enabled, we'll also ensure that the HTLC
+				// won't become dust were the commitment fee
+				// rate to spike, as we'd then be unable to
+				// enforce it on-chain.
+				if l.cfg.RejectStressDust && l.isStressDust(pd) {
+					failure := lnwire.NewTemporaryChannelFailure(nil)
+					update, err := l.cfg.GetLastChannelUpdate()
+					if err == nil {
+						failure = lnwire.NewTemporaryChannelFailure(
+							update,
+						)
+					}
+
+					l.rejectForward(
+						pd, fwdInfo, correlationID,
+						failure, obfuscator,
+					)
 					needUpdate = true
 					continue
 				}
@@ -1719,9 +3612,9 @@ func (l *channelLink) processLockedInHtlcs(
 				// Next, using the amount of the incoming HTLC,
 				// we'll calculate the expected fee this
 				// incoming HTLC must carry in order to be
-				// accepted.
-				expectedFee := ExpectedFee(
-					l.cfg.FwrdingPolicy,
+				// accepted, including our inbound fee.
+				policy := l.currentPolicy()
+				expectedFee := policy.expectedForwardFee(
 					fwdInfo.AmountToForward,
 				)
 
@@ -1745,7 +3638,9 @@ func (l *channelLink) processLockedInHtlcs(
 					// the sending node obtains the most up
 					// to date data.
 					var failure lnwire.FailureMessage
-					update, err := l.cfg.GetLastChannelUpdate()
+					update, err := l.feeInsufficientUpdate(
+						policy, fwdInfo.AmountToForward,
+					)
 					if err != nil {
 						failure = lnwire.NewTemporaryChannelFailure(nil)
 					} else {
@@ -1753,7 +3648,10 @@ func (l *channelLink) processLockedInHtlcs(
 							*update)
 					}
 
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+					l.rejectForward(
+						pd, fwdInfo, correlationID,
+						failure, obfuscator,
+					)
 					needUpdate = true
 					continue
 				}
@@ -1776,21 +3674,45 @@ func (l *channelLink) processLockedInHtlcs(
 					// Grab the latest routing policy so
 					// the sending node is up to date with
 					// our current policy.
+					var failure lnwire.FailureMessage
 					update, err := l.cfg.GetLastChannelUpdate()
 					if err != nil {
-						l.fail("unable to create channel update "+
-							"while handling the error: %v", err)
-						return nil
+						failure = lnwire.NewTemporaryChannelFailure(nil)
+					} else {
+						failure = lnwire.NewIncorrectCltvExpiry(
+							pd.Timeout, *update)
 					}
-
-					failure := lnwire.NewIncorrectCltvExpiry(
-						pd.Timeout, *update)
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+					l.rejectForward(
+						pd, fwdInfo, correlationID,
+						failure, obfuscator,
+					)
 					needUpdate = true
 					continue
 				}
 
-				// TODO(roasbeef): also add max timeout value
+				// We'll also ensure that the forward won't lock
+				// up the outgoing channel for much longer than
+				// the next hop could reasonably need. We can't
+				// shorten the time-lock ourselves, so the
+				// sender must build a new route.
+				maxExpiry := l.cfg.FwrdingPolicy.maxOutgoingExpiry(
+					heightNow,
+				)
+				if fwdInfo.OutgoingCTLV > maxExpiry {
+					log.Errorf("Incoming htlc(%x) has an "+
+						"outgoing time-lock that's too far "+
+						"out: outgoing_expiry=%v, "+
+						"max_expiry=%v", pd.RHash[:],
+						fwdInfo.OutgoingCTLV, maxExpiry)
+
+					failure := &lnwire.FailExpiryTooFar{}
+					l.rejectForward(
+						pd, fwdInfo, correlationID,
+						failure, obfuscator,
+					)
+					needUpdate = true
+					continue
+				}
 
 				// With all our forwarding constraints met,
 				// we'll create the outgoing HTLC using the
@@ -1812,30 +3734,68 @@ func (l *channelLink) processLockedInHtlcs(
 						"remaining route %v", err)
 
 					failure := lnwire.NewTemporaryChannelFailure(nil)
-					l.sendHTLCError(pd.HtlcIndex, failure, obfuscator)
+					l.rejectForward(
+						pd, fwdInfo, correlationID,
+						failure, obfuscator,
+					)
 					needUpdate = true
 					continue
 				}
 
+				l.cfg.Switch.tracer.record(
+					l.ShortChanID(), pd.HtlcIndex,
+					TracePolicyChecked,
+				)
+
 				updatePacket := &htlcPacket{
-					incomingChanID: l.ShortChanID(),
-					incomingHTLCID: pd.HtlcIndex,
-					outgoingChanID: fwdInfo.NextHop,
-					amount:         addMsg.Amount,
-					htlc:           addMsg,
-					obfuscator:     obfuscator,
+					correlationID:   correlationID,
+					incomingChanID:  l.ShortChanID(),
+					incomingHTLCID:  pd.HtlcIndex,
+					outgoingChanID:  fwdInfo.NextHop,
+					amount:          addMsg.Amount,
+					incomingAmount:  pd.Amount,
+					incomingTimeout: pd.Timeout,
+					requiredFee:     expectedFee,
+					htlc:            addMsg,
+					obfuscator:      obfuscator,
 				}
 				packetsToForward = append(packetsToForward, updatePacket)
 			}
 		}
 	}
 
+	// Notify the invoiceRegistry of the invoices paid to with this latest
+	// commitment update, all within a single batch. Their HTLC's have
+	// already been settled, so an invoice which can't be settled only
+	// affects its own record, and the rest of the batch carries on.
+	if len(invoicesToSettle) > 0 {
+		reqs := make([]SettleRequest, 0, len(invoicesToSettle))
+		for _, hash := range invoicesToSettle {
+			reqs = append(reqs, SettleRequest{PaymentHash: hash})
+		}
+		errs := l.cfg.Registry.BatchSettleInvoice(reqs)
+
+		for i, hash := range invoicesToSettle {
+			if errs[i] != nil {
+				log.Errorf("ChannelLink(%v) unable to settle "+
+					"invoice(%x): %v", l, hash[:], errs[i])
+			}
+		}
+	}
+
+	// HTLC's were successfully settled locally, so we'll send
+	// notification about them to the remote peer.
+	for _, msg := range fulfillMsgs {
+		l.cfg.Peer.SendMessage(msg)
+	}
+
 	if needUpdate {
 		// With all the settle/cancel updates added to the local and
 		// remote HTLC logs, initiate a state transition by updating
 		// the remote commitment chain.
 		if err := l.updateCommitTx(); err != nil {
-			l.fail("unable to update commitment: %v", err)
+			l.fail(DisconnectCommitmentError,
+				"unable to update commitment: %v", err)
 			return nil
 		}
 	}
@@ -1843,6 +3803,32 @@ func (l *channelLink) processLockedInHtlcs(
 	return packetsToForward
 }
 
+// settleHTLC settles the incoming HTLC at the target index using the passed
+// preimage. If the preimage doesn't match the HTLC's payment hash, then an
+// *HTLCError is returned, as only this HTLC is affected and it can be failed
+// back instead. Any other error indicates that our state machine is
+// inconsistent, and is fatal to the link.
+func (l *channelLink) settleHTLC(preimage [32]byte, htlcIndex uint64,
+	rHash lnwallet.PaymentHash) error {
+
+	if sha256.Sum256(preimage[:]) != [32]byte(rHash) {
+		return &HTLCError{
+			HtlcIndex: htlcIndex,
+			Err: fmt.Errorf("preimage %x doesn't match payment "+
+				"hash %x", preimage[:], rHash[:]),
+			FailureMessage: lnwire.FailUnknownPaymentHash{},
+		}
+	}
+
+	if err := l.channel.SettleHTLC(preimage, htlcIndex); err != nil {
+		return err
+	}
+	l.recordIncomingFlow(htlcIndex, false)
+	l.cfg.Switch.hashWatcher.resolved(l.ShortChanID(), htlcIndex, true)
+
+	return nil
+}
+
 // sendHTLCError functions cancels HTLC and send cancel message back to the
 // peer from which HTLC was received.
 func (l *channelLink) sendHTLCError(htlcIndex uint64,
@@ -1865,6 +3851,10 @@ func (l *channelLink) sendHTLCError(htlcIndex uint64,
 		ID:     htlcIndex,
 		Reason: reason,
 	})
+	l.activity.failed()
+
+	l.cfg.Switch.tracer.complete(l.ShortChanID(), htlcIndex, false)
+	l.cfg.Switch.hashWatcher.resolved(l.ShortChanID(), htlcIndex, false)
 }
 
 // sendMalformedHTLCError helper function which sends the malformed HTLC update
@@ -1885,12 +3875,16 @@ func (l *channelLink) sendMalformedHTLCError(htlcIndex uint64,
 		ShaOnionBlob: shaOnionBlob,
 		FailureCode:  code,
 	})
+	l.activity.failed()
+	l.cfg.Switch.hashWatcher.resolved(l.ShortChanID(), htlcIndex, false)
 }
 
 // fail helper function which is used to encapsulate the action necessary for
 // proper disconnect.
-func (l *channelLink) fail(format string, a ...interface{}) {
-	reason := errors.Errorf(format, a...)
-	log.Error(reason)
-	go l.cfg.Peer.Disconnect(reason)
+func (l *channelLink) fail(reason DisconnectReason, format string,
+	a ...interface{}) {
+
+	err := errors.Errorf(format, a...)
+	log.Error(err)
+	go l.cfg.Peer.Disconnect(reason, err)
 }
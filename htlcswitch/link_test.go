@@ -2,6 +2,7 @@ package htlcswitch
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"runtime"
 	"strings"
@@ -633,6 +634,66 @@ func TestLinkForwardTimelockPolicyMismatch(t *testing.T) {
 	}
 }
 
+// TestLinkForwardEnforcedTimelockDelta tests that an intermediate node
+// enforcing a time-lock delta below the one it advertises accepts HTLC's
+// meeting only the enforced delta, while still rejecting those falling short
+// of it.
+func TestLinkForwardEnforcedTimelockDelta(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*5,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+
+	// Bob will enforce a time-lock delta two blocks below the one he
+	// advertises.
+	policy := &n.firstBobChannelLink.cfg.FwrdingPolicy
+	policy.EnforcedTimeLockDelta = policy.TimeLockDelta - 2
+
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+
+	// A sender leaving Bob only the enforced delta should succeed.
+	htlcAmt, htlcExpiry, hops := generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink, n.carolChannelLink)
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		htlcExpiry-2).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	// Falling short of the enforced delta should still be rejected.
+	htlcAmt, htlcExpiry, hops = generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink, n.carolChannelLink)
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		htlcExpiry-3).Wait(30 * time.Second)
+	if err == nil {
+		t.Fatalf("payment should have failed but didn't")
+	}
+
+	ferr, ok := err.(*ForwardingError)
+	if !ok {
+		t.Fatalf("expected a ForwardingError, instead got: %T", err)
+	}
+	if _, ok := ferr.FailureMessage.(*lnwire.FailIncorrectCltvExpiry); !ok {
+		t.Fatalf("incorrect error, expected incorrect cltv expiry, "+
+			"instead have: %v", err)
+	}
+}
+
 // TestLinkForwardTimelockPolicyMismatch tests that if a node is an
 // intermediate node in a multi-hop payment and receives an HTLC that violates
 // its current fee policy, then the HTLC is rejected with the proper error.
@@ -847,6 +908,201 @@ func TestUpdateForwardingPolicy(t *testing.T) {
 	}
 }
 
+// TestUpdateDirectionalPolicy tests that the inbound fee of a link is applied
+// to HTLC's arriving over it, and that policies whose inbound discount could
+// render a forward unprofitable are rejected.
+func TestUpdateDirectionalPolicy(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*5,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	// A discount exceeding Bob's outbound base fee should be rejected,
+	// leaving his current policy untouched.
+	err = n.firstBobChannelLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Inbound: &InboundFee{
+			BaseFee: -int64(n.globalPolicy.BaseFee) - 1,
+		},
+	})
+	if err == nil {
+		t.Fatalf("excessive inbound discount should be rejected")
+	}
+
+	policy := n.firstBobChannelLink.CurrentForwardingPolicy()
+	if policy.Outbound != n.globalPolicy {
+		t.Fatalf("outbound policy mismatch: expected %v, got %v",
+			n.globalPolicy, policy.Outbound)
+	}
+	if policy.Inbound == nil || *policy.Inbound != (InboundFee{}) {
+		t.Fatalf("inbound fee should be unset, got %v", policy.Inbound)
+	}
+
+	// Next, we'll add an inbound surcharge to HTLC's arriving at Bob from
+	// Alice, and ensure both directions are surfaced by the link.
+	inbound := InboundFee{BaseFee: int64(n.globalPolicy.BaseFee)}
+	err = n.firstBobChannelLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Inbound: &inbound,
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+
+	policy = n.firstBobChannelLink.CurrentForwardingPolicy()
+	if policy.Outbound != n.globalPolicy {
+		t.Fatalf("outbound policy mismatch: expected %v, got %v",
+			n.globalPolicy, policy.Outbound)
+	}
+	if policy.Inbound == nil || *policy.Inbound != inbound {
+		t.Fatalf("inbound fee mismatch: expected %v, got %v",
+			inbound, policy.Inbound)
+	}
+
+	// A payment which only pays Bob's outbound fee should now be rejected,
+	// as it doesn't factor in his inbound surcharge.
+	amountNoFee := lnwire.NewMSatFromSatoshis(10)
+	htlcAmt, htlcExpiry, hops := generateHops(amountNoFee,
+		testStartingHeight,
+		n.firstBobChannelLink, n.carolChannelLink)
+
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amountNoFee, htlcAmt,
+		htlcExpiry).Wait(30 * time.Second)
+	if err == nil {
+		t.Fatalf("payment should've been rejected")
+	}
+
+	ferr, ok := err.(*ForwardingError)
+	if !ok {
+		t.Fatalf("expected a ForwardingError, instead got: %T", err)
+	}
+	switch ferr.FailureMessage.(type) {
+	case *lnwire.FailFeeInsufficient:
+	default:
+		t.Fatalf("expected FailFeeInsufficient instead got: %v", err)
+	}
+}
+
+// TestChannelLinkProbeFee tests that HTLC's forwarding less than the probe
+// threshold of a link are charged its probe fee, while still being subject to
+// the link's minimum HTLC size.
+func TestChannelLinkProbeFee(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*5,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	// We'll have Bob forward HTLC's of less than 1000 satoshis free of
+	// charge.
+	newPolicy := n.globalPolicy
+	newPolicy.ProbeThreshold = lnwire.NewMSatFromSatoshis(1000)
+	n.firstBobChannelLink.UpdateForwardingPolicy(newPolicy)
+
+	policy := n.firstBobChannelLink.CurrentForwardingPolicy()
+	if policy.Outbound.ProbeThreshold != newPolicy.ProbeThreshold {
+		t.Fatalf("probe threshold mismatch: expected %v, got %v",
+			newPolicy.ProbeThreshold, policy.Outbound.ProbeThreshold)
+	}
+
+	firstBobBandwidthBefore := n.firstBobChannelLink.Bandwidth()
+	secondBobBandwidthBefore := n.secondBobChannelLink.Bandwidth()
+
+	// A probe-sized payment should be forwarded by Bob without a fee.
+	amount := lnwire.NewMSatFromSatoshis(10)
+	htlcAmt, htlcExpiry, hops := generateHops(amount,
+		testStartingHeight,
+		n.firstBobChannelLink, n.carolChannelLink)
+	if htlcAmt != amount {
+		t.Fatalf("probe shouldn't carry a fee: amount=%v, htlc=%v",
+			amount, htlcAmt)
+	}
+
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		htlcExpiry).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	if n.firstBobChannelLink.Bandwidth() != firstBobBandwidthBefore+amount {
+		t.Fatalf("channel bandwidth incorrect: expected %v, got %v",
+			firstBobBandwidthBefore+amount,
+			n.firstBobChannelLink.Bandwidth())
+	}
+	if n.secondBobChannelLink.Bandwidth() != secondBobBandwidthBefore-amount {
+		t.Fatalf("channel bandwidth incorrect: expected %v, got %v",
+			secondBobBandwidthBefore-amount,
+			n.secondBobChannelLink.Bandwidth())
+	}
+
+	// A probe below Bob's minimum HTLC size should still be rejected.
+	amount = lnwire.NewMSatFromSatoshis(1)
+	htlcAmt, htlcExpiry, hops = generateHops(amount,
+		testStartingHeight,
+		n.firstBobChannelLink, n.carolChannelLink)
+
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		htlcExpiry).Wait(30 * time.Second)
+	if err == nil {
+		t.Fatalf("payment should've been rejected")
+	}
+
+	ferr, ok := err.(*ForwardingError)
+	if !ok {
+		t.Fatalf("expected a ForwardingError, instead got: %T", err)
+	}
+	switch ferr.FailureMessage.(type) {
+	case *lnwire.FailAmountBelowMinimum:
+	default:
+		t.Fatalf("expected FailAmountBelowMinimum instead got: %v", err)
+	}
+
+	// A zero probe threshold leaves the current one unchanged, so the
+	// probe fee must be disabled explicitly.
+	n.firstBobChannelLink.UpdateForwardingPolicy(n.globalPolicy)
+	policy = n.firstBobChannelLink.CurrentForwardingPolicy()
+	if policy.Outbound.ProbeThreshold != newPolicy.ProbeThreshold {
+		t.Fatalf("probe threshold mismatch: expected %v, got %v",
+			newPolicy.ProbeThreshold, policy.Outbound.ProbeThreshold)
+	}
+
+	err = n.firstBobChannelLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		DisableProbeFee: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+	policy = n.firstBobChannelLink.CurrentForwardingPolicy()
+	if policy.Outbound.ProbeThreshold != 0 {
+		t.Fatalf("probe threshold should be cleared, got %v",
+			policy.Outbound.ProbeThreshold)
+	}
+}
+
 // TestChannelLinkMultiHopInsufficientPayment checks that we receive error if
 // bob<->alice channel has insufficient BTC capacity/bandwidth. In this test we
 // send the payment from Carol to Alice over Bob peer. (Carol -> Bob -> Alice)
@@ -1382,8 +1638,9 @@ func TestChannelLinkSingleHopMessageOrdering(t *testing.T) {
 
 type mockPeer struct {
 	sync.Mutex
-	sentMsgs chan lnwire.Message
-	quit     chan struct{}
+	sentMsgs    chan lnwire.Message
+	quit        chan struct{}
+	pingLatency time.Duration
 }
 
 func (m *mockPeer) SendMessage(msg lnwire.Message) error {
@@ -1400,7 +1657,12 @@ func (m *mockPeer) WipeChannel(*wire.OutPoint) error {
 func (m *mockPeer) PubKey() [33]byte {
 	return [33]byte{}
 }
-func (m *mockPeer) Disconnect(reason error) {
+func (m *mockPeer) Disconnect(reason DisconnectReason, err error) {
+}
+func (m *mockPeer) PingLatency() time.Duration {
+	m.Lock()
+	defer m.Unlock()
+	return m.pingLatency
 }
 
 var _ Peer = (*mockPeer)(nil)
@@ -1464,7 +1726,8 @@ func newSingleLinkTestHarness(chanAmt, chanReserve btcutil.Amount) (
 		BatchTicker: ticker,
 		// Make the BatchSize large enough to not
 		// trigger commit update automatically during tests.
-		BatchSize: 10000,
+		BatchSize:           10000,
+		QuiescenceSupported: true,
 	}
 
 	const startingHeight = 100
@@ -2676,7 +2939,7 @@ func TestShouldAdjustCommitFee(t *testing.T) {
 
 	for i, test := range tests {
 		adjustedFee := shouldAdjustCommitFee(
-			test.netFee, test.chanFee,
+			test.netFee, test.chanFee, DefaultFeeUpdateTolerance,
 		)
 
 		if adjustedFee && !test.shouldAdjust {
@@ -2897,4 +3160,1153 @@ func TestChannelLinkRejectDuplicatePayment(t *testing.T) {
 	}
 }
 
-// TODO(roasbeef): add test for re-sending after hodl mode, to settle any lingering
+// TestChannelLinkClearHTLCsForClose ensures that once a link has been
+// instructed to clear its HTLC's for a cooperative close, it rejects any new
+// HTLC's, and only signals readiness once all outstanding HTLC's have been
+// resolved.
+func TestChannelLinkClearHTLCsForClose(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, tmr, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	var (
+		mockBlob  [lnwire.OnionPacketSize]byte
+		aliceLink = link.(*channelLink)
+		aliceMsgs = aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+	)
+
+	// First, we'll add an HTLC from Alice to Bob, and lock it in.
+	htlcAmt := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	invoice, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive message")
+	}
+	addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	bobIndex, err := bobChannel.ReceiveHTLC(addHtlc)
+	if err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+	if err := updateState(tmr, aliceLink, bobChannel, true); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// With the HTLC locked in, we'll instruct the link to clear its
+	// HTLC's. This shouldn't complete as long as the HTLC is outstanding.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	clearErr := make(chan error, 1)
+	go func() {
+		clearErr <- aliceLink.ClearHTLCsForClose(ctx)
+	}()
+	time.Sleep(time.Millisecond * 500)
+
+	select {
+	case err := <-clearErr:
+		t.Fatalf("link cleared with outstanding htlc: %v", err)
+	default:
+	}
+
+	// The link should no longer be eligible to forward, and any new
+	// HTLC's sent by the switch should not be offered to Bob.
+	if aliceLink.EligibleToForward() {
+		t.Fatalf("link should not be eligible to forward while " +
+			"clearing htlcs")
+	}
+
+	_, htlc, err = generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	select {
+	case msg = <-aliceMsgs:
+		t.Fatalf("expected no message, got %T", msg)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	// Bob now settles the outstanding HTLC. Once the settle has been
+	// locked in, the link should signal that it has been cleared.
+	err = bobChannel.SettleHTLC(invoice.Terms.PaymentPreimage, bobIndex)
+	if err != nil {
+		t.Fatalf("unable to settle htlc: %v", err)
+	}
+	aliceLink.HandleChannelUpdate(&lnwire.UpdateFulfillHTLC{
+		ID:              bobIndex,
+		PaymentPreimage: invoice.Terms.PaymentPreimage,
+	})
+	if err := updateState(tmr, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	select {
+	case err := <-clearErr:
+		if err != nil {
+			t.Fatalf("unable to clear htlcs: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("link wasn't cleared after htlc was settled")
+	}
+}
+
+// TestChannelLinkClearHTLCsForCloseTimeout ensures that ClearHTLCsForClose
+// returns ErrLinkClearTimeout if the outstanding HTLC's aren't resolved before
+// the deadline, and that the link accepts new HTLC's again once the clearing
+// is cancelled.
+func TestChannelLinkClearHTLCsForCloseTimeout(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, tmr, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	var (
+		mockBlob  [lnwire.OnionPacketSize]byte
+		aliceLink = link.(*channelLink)
+		aliceMsgs = aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+	)
+
+	htlcAmt := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive message")
+	}
+	addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	if _, err := bobChannel.ReceiveHTLC(addHtlc); err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+	if err := updateState(tmr, aliceLink, bobChannel, true); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// As Bob never resolves the HTLC, the attempt to clear the link
+	// should time out.
+	ctx, cancel := context.WithTimeout(
+		context.Background(), time.Millisecond*500,
+	)
+	defer cancel()
+
+	err = aliceLink.ClearHTLCsForClose(ctx)
+	if err != ErrLinkClearTimeout {
+		t.Fatalf("expected ErrLinkClearTimeout, got %v", err)
+	}
+	amt := lnwire.NewMSatFromSatoshis(10000)
+	if _, err := aliceLink.CanAddHTLC(amt); err != ErrLinkNotAccepting {
+		t.Fatalf("expected ErrLinkNotAccepting, got %v", err)
+	}
+
+	aliceLink.CancelClearHTLCs()
+	if _, err := aliceLink.CanAddHTLC(amt); err != nil {
+		t.Fatalf("expected link to accept htlcs, got %v", err)
+	}
+}
+
+// TestChannelLinkSoftReserve tests that a link's soft reserve is excluded from
+// the bandwidth available to forwarded HTLC's, but not from the bandwidth
+// available to local HTLC's, and that a soft reserve which together with the
+// channel reserve exceeds our balance is detected.
+func TestChannelLinkSoftReserve(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	const chanReserve = btcutil.SatoshiPerBitcoin * 1
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(
+		chanAmt, chanReserve,
+	)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	bandwidth := aliceLink.Bandwidth()
+	if aliceLink.LocalBandwidth() != bandwidth {
+		t.Fatalf("expected local bandwidth %v without soft reserve, "+
+			"got %v", bandwidth, aliceLink.LocalBandwidth())
+	}
+
+	softReserve := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	aliceLink.cfg.SoftReserve = softReserve
+	if err := aliceLink.validateSoftReserve(); err != nil {
+		t.Fatalf("unexpected soft reserve error: %v", err)
+	}
+	if aliceLink.Bandwidth() != bandwidth-softReserve {
+		t.Fatalf("expected bandwidth %v, got %v",
+			bandwidth-softReserve, aliceLink.Bandwidth())
+	}
+	if aliceLink.LocalBandwidth() != bandwidth {
+		t.Fatalf("expected local bandwidth %v, got %v", bandwidth,
+			aliceLink.LocalBandwidth())
+	}
+
+	// A soft reserve which, together with the channel reserve, exceeds
+	// our balance leaves nothing to forward.
+	localBalance := aliceLink.channel.StateSnapshot().LocalBalance
+	aliceLink.cfg.SoftReserve = localBalance
+	if err := aliceLink.validateSoftReserve(); err == nil {
+		t.Fatalf("expected soft reserve exceeding balance to be " +
+			"rejected")
+	}
+	if aliceLink.Bandwidth() != 0 {
+		t.Fatalf("expected no bandwidth, got %v",
+			aliceLink.Bandwidth())
+	}
+}
+
+// TestChannelLinkPendingClose ensures that once a link has been marked as
+// pending force close, it's no longer eligible to forward, and that any new
+// HTLC's, whether sent by the switch or the remote peer, are rejected.
+func TestChannelLinkPendingClose(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, tmr, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	var (
+		mockBlob  [lnwire.OnionPacketSize]byte
+		aliceLink = link.(*channelLink)
+		aliceMsgs = aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+	)
+
+	aliceLink.MarkPendingClose()
+
+	if aliceLink.EligibleToForward() {
+		t.Fatalf("link should not be eligible to forward while " +
+			"pending close")
+	}
+	if reason := aliceLink.IneligibleReason(); reason != IneligibleClosing {
+		t.Fatalf("expected reason %v, got %v", IneligibleClosing,
+			reason)
+	}
+
+	// An HTLC sent by the switch should not be offered to Bob.
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	select {
+	case msg := <-aliceMsgs:
+		t.Fatalf("expected no message, got %T", msg)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	// An HTLC offered by Bob should be failed back once it's locked in,
+	// with a permanent channel failure.
+	_, htlc, err = generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	if _, err := bobChannel.AddHTLC(htlc); err != nil {
+		t.Fatalf("unable to add htlc: %v", err)
+	}
+	aliceLink.HandleChannelUpdate(htlc)
+	if err := updateState(tmr, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive message")
+	}
+	failMsg, ok := msg.(*lnwire.UpdateFailHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateFailHTLC, got %T", msg)
+	}
+
+	failure, err := lnwire.DecodeFailure(bytes.NewReader(failMsg.Reason), 0)
+	if err != nil {
+		t.Fatalf("unable to decode failure: %v", err)
+	}
+	if _, ok := failure.(*lnwire.FailPermanentChannelFailure); !ok {
+		t.Fatalf("expected permanent channel failure, got %T", failure)
+	}
+}
+
+// TestChannelLinkCommitHeights ensures that the commitment heights reported by
+// the link track the state of both commitment chains.
+func TestChannelLinkCommitHeights(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, tmr, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	var (
+		mockBlob  [lnwire.OnionPacketSize]byte
+		aliceLink = link.(*channelLink)
+		aliceMsgs = aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+	)
+
+	assertHeights := func(local, remote, pendingRevocation uint64) {
+		_, _, line, _ := runtime.Caller(1)
+
+		l, r, p := aliceLink.CommitHeights()
+		if l != local || r != remote || p != pendingRevocation {
+			t.Fatalf("line %v: wrong commit heights: expected "+
+				"(%v, %v, %v), got (%v, %v, %v)", line, local,
+				remote, pendingRevocation, l, r, p)
+		}
+
+		stats := aliceLink.StatsDetail()
+		if stats.LocalCommitHeight != local ||
+			stats.RemoteCommitHeight != remote ||
+			stats.PendingRevocationHeight != pendingRevocation {
+
+			t.Fatalf("line %v: stats don't match commit heights: "+
+				"%v", line, spew.Sdump(stats))
+		}
+	}
+
+	// Before any updates, both chains should be at their initial height.
+	assertHeights(0, 0, 0)
+
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive message")
+	}
+	addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	if _, err := bobChannel.ReceiveHTLC(addHtlc); err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+
+	// After a full state transition, both chains should have advanced by
+	// one, and Bob should have revoked his prior commitment.
+	if err := updateState(tmr, aliceLink, bobChannel, true); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	assertHeights(1, 1, 1)
+}
+
+// TestChannelLinkStatsSnapshot ensures that the snapshot of a link's activity
+// tracks its forwards, pending HTLC's and failures.
+func TestChannelLinkStatsSnapshot(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, tmr, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	var (
+		mockBlob  [lnwire.OnionPacketSize]byte
+		aliceLink = link.(*channelLink)
+		aliceMsgs = aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+	)
+
+	stats := aliceLink.StatsSnapshot()
+	if stats.ShortChanID != aliceLink.ShortChanID() {
+		t.Fatalf("expected short chan id %v, got %v",
+			aliceLink.ShortChanID(), stats.ShortChanID)
+	}
+	if stats.StartTime.IsZero() {
+		t.Fatalf("start time not recorded")
+	}
+	if !stats.LastForward.IsZero() || !stats.LastSettle.IsZero() {
+		t.Fatalf("expected no activity, got %v", spew.Sdump(stats))
+	}
+	startTime := stats.StartTime
+
+	// The snapshot should carry the latest ping latency of the peer.
+	peer := aliceLink.cfg.Peer.(*mockPeer)
+	peer.Lock()
+	peer.pingLatency = 250 * time.Millisecond
+	peer.Unlock()
+	stats = aliceLink.StatsSnapshot()
+	if stats.PingLatency != 250*time.Millisecond {
+		t.Fatalf("expected ping latency of 250ms, got %v",
+			stats.PingLatency)
+	}
+
+	// We'll add an HTLC from Alice to Bob, and lock it in.
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	var msg lnwire.Message
+	select {
+	case msg = <-aliceMsgs:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive message")
+	}
+	addHtlc, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	bobIndex, err := bobChannel.ReceiveHTLC(addHtlc)
+	if err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+	if err := updateState(tmr, aliceLink, bobChannel, true); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	stats = aliceLink.StatsSnapshot()
+	if stats.LastForward.Before(startTime) {
+		t.Fatalf("forward not recorded: %v", spew.Sdump(stats))
+	}
+	if stats.PendingHTLCs != 1 {
+		t.Fatalf("expected 1 pending htlc, got %v", stats.PendingHTLCs)
+	}
+
+	// Bob now fails the HTLC. Once the fail has been locked in, the link
+	// should report it, along with no pending HTLC's.
+	if err := bobChannel.FailHTLC(bobIndex, []byte("nop")); err != nil {
+		t.Fatalf("unable to fail htlc: %v", err)
+	}
+	aliceLink.HandleChannelUpdate(&lnwire.UpdateFailHTLC{
+		ID:     bobIndex,
+		Reason: lnwire.OpaqueReason([]byte("nop")),
+	})
+	if err := updateState(tmr, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	stats = aliceLink.StatsSnapshot()
+	if stats.PendingHTLCs != 0 {
+		t.Fatalf("expected no pending htlcs, got %v",
+			stats.PendingHTLCs)
+	}
+	if stats.NumFailures != 1 {
+		t.Fatalf("expected 1 failure, got %v", stats.NumFailures)
+	}
+	if !stats.LastSettle.IsZero() {
+		t.Fatalf("expected no settles, got %v", stats.LastSettle)
+	}
+	if !stats.StartTime.Equal(startTime) {
+		t.Fatalf("start time changed from %v to %v", startTime,
+			stats.StartTime)
+	}
+}
+
+// TestChannelLinkCommitmentState tests that the link reports a stress dust
+// threshold above its current dust threshold, and that HTLC's falling between
+// the two are deemed stress dust.
+func TestChannelLinkCommitmentState(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+
+	state := aliceLink.CommitmentState()
+	expectedStressFee := state.FeePerKw +
+		DefaultDustStressHeadroom.FeePerKWeight()
+	if state.StressFeePerKw != expectedStressFee {
+		t.Fatalf("expected stress fee rate %v, got %v",
+			expectedStressFee, state.StressFeePerKw)
+	}
+	if state.DustThreshold < state.LocalDustLimit ||
+		state.DustThreshold < state.RemoteDustLimit {
+
+		t.Fatalf("dust threshold below dust limit: %v",
+			spew.Sdump(state))
+	}
+	if state.StressDustThreshold <= state.DustThreshold {
+		t.Fatalf("stress dust threshold should exceed dust "+
+			"threshold: %v", spew.Sdump(state))
+	}
+
+	// An HTLC sitting between the two thresholds is above dust at the
+	// current fee rate, but would be trimmed under stress.
+	between := lnwire.NewMSatFromSatoshis(
+		(state.DustThreshold + state.StressDustThreshold) / 2,
+	)
+	if !aliceLink.isStressDust(&lnwallet.PaymentDescriptor{
+		Amount: between,
+	}) {
+		t.Fatalf("htlc of %v should be stress dust", between)
+	}
+
+	above := lnwire.NewMSatFromSatoshis(state.StressDustThreshold)
+	if aliceLink.isStressDust(&lnwallet.PaymentDescriptor{Amount: above}) {
+		t.Fatalf("htlc of %v shouldn't be stress dust", above)
+	}
+
+	// Raising the headroom should raise the stress threshold.
+	aliceLink.cfg.DustStressHeadroom = DefaultDustStressHeadroom * 2
+	if aliceLink.CommitmentState().StressDustThreshold <=
+		state.StressDustThreshold {
+
+		t.Fatalf("larger headroom should raise stress threshold")
+	}
+}
+
+// restartWarmUpLink stops the passed link, then restarts it as though the
+// remote peer has reconnected, with the given warm-up period. Once the
+// restarted link has sent its reestablish message, the remote party's is
+// delivered to it.
+func restartWarmUpLink(t *testing.T, link *channelLink,
+	bobChannel *lnwallet.LightningChannel,
+	warmUpPeriod time.Duration) *channelLink {
+
+	link.Stop()
+
+	cfg := link.cfg
+	cfg.SyncStates = true
+	cfg.WarmUpPeriod = warmUpPeriod
+
+	newLink := NewChannelLink(cfg, link.channel, link.bestHeight).(*channelLink)
+	if err := newLink.Start(); err != nil {
+		t.Fatalf("unable to start link: %v", err)
+	}
+	go func() {
+		for {
+			select {
+			case <-newLink.htlcUpdates:
+			case <-newLink.quit:
+				return
+			}
+		}
+	}()
+
+	if reason := newLink.IneligibleReason(); reason != IneligibleWarmingUp {
+		t.Fatalf("expected reason %v, got %v", IneligibleWarmingUp,
+			reason)
+	}
+
+	select {
+	case msg := <-cfg.Peer.(*mockPeer).sentMsgs:
+		if _, ok := msg.(*lnwire.ChannelReestablish); !ok {
+			t.Fatalf("expected ChannelReestablish, got %T", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reestablish not sent")
+	}
+
+	bobSyncMsg, err := bobChannel.ChanSyncMsg()
+	if err != nil {
+		t.Fatalf("unable to create reestablish message: %v", err)
+	}
+	newLink.HandleChannelUpdate(bobSyncMsg)
+
+	return newLink
+}
+
+// addUnrevokedHtlc adds an HTLC from Alice to Bob, and has Bob receive
+// Alice's signature for it. Bob's revocation is returned, leaving the
+// commitment round-trip incomplete from Alice's PoV.
+func addUnrevokedHtlc(t *testing.T, link *channelLink,
+	bobChannel *lnwallet.LightningChannel) *lnwire.RevokeAndAck {
+
+	var mockBlob [lnwire.OnionPacketSize]byte
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+
+	aliceChannel := link.channel
+	index, err := aliceChannel.AddHTLC(htlc)
+	if err != nil {
+		t.Fatalf("unable to add htlc: %v", err)
+	}
+	htlc.ID = index
+	if _, err := bobChannel.ReceiveHTLC(htlc); err != nil {
+		t.Fatalf("unable to receive htlc: %v", err)
+	}
+
+	sig, htlcSigs, err := aliceChannel.SignNextCommitment()
+	if err != nil {
+		t.Fatalf("unable to sign commitment: %v", err)
+	}
+	err = bobChannel.ReceiveNewCommitment(sig, htlcSigs)
+	if err != nil {
+		t.Fatalf("unable to receive commitment: %v", err)
+	}
+	revocation, _, err := bobChannel.RevokeCurrentCommitment()
+	if err != nil {
+		t.Fatalf("unable to revoke commitment: %v", err)
+	}
+
+	return revocation
+}
+
+// assertWarmedUp asserts that the link ends its warm-up period within the
+// passed timeout, after which it should be eligible to forward.
+func assertWarmedUp(t *testing.T, link *channelLink, timeout time.Duration) {
+	select {
+	case <-link.WarmedUp():
+	case <-time.After(timeout):
+		t.Fatalf("link didn't warm up, reason=%v",
+			link.IneligibleReason())
+	}
+
+	if reason := link.IneligibleReason(); reason != IneligibleNone {
+		t.Fatalf("link should be eligible once warmed up, reason=%v",
+			reason)
+	}
+}
+
+// TestChannelLinkWarmUpInSync tests that the warm-up of a restarted link ends
+// as soon as the reestablish messages show both parties to be in sync,
+// rather than after the full warm-up period.
+func TestChannelLinkWarmUpInSync(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := restartWarmUpLink(
+		t, link.(*channelLink), bobChannel, time.Hour,
+	)
+	defer aliceLink.Stop()
+
+	assertWarmedUp(t, aliceLink, 5*time.Second)
+}
+
+// TestChannelLinkWarmUpRoundTrip tests that if a restarted link isn't in sync
+// with the remote party after reestablishing, then its warm-up ends once the
+// first commitment round-trip completes.
+func TestChannelLinkWarmUpRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	revocation := addUnrevokedHtlc(t, link.(*channelLink), bobChannel)
+
+	aliceLink := restartWarmUpLink(
+		t, link.(*channelLink), bobChannel, time.Hour,
+	)
+	defer aliceLink.Stop()
+
+	// Without Bob's revocation, Alice's link shouldn't warm up.
+	select {
+	case <-aliceLink.WarmedUp():
+		t.Fatalf("link warmed up before round-trip completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if aliceLink.EligibleToForward() {
+		t.Fatalf("link shouldn't be eligible while warming up")
+	}
+
+	aliceLink.HandleChannelUpdate(revocation)
+
+	assertWarmedUp(t, aliceLink, 5*time.Second)
+}
+
+// TestChannelLinkWarmUpElapsed tests that a restarted link which is unable to
+// confirm that it's in sync becomes eligible to forward once its warm-up
+// period has elapsed.
+func TestChannelLinkWarmUpElapsed(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	addUnrevokedHtlc(t, link.(*channelLink), bobChannel)
+
+	const warmUpPeriod = 200 * time.Millisecond
+	start := time.Now()
+	aliceLink := restartWarmUpLink(
+		t, link.(*channelLink), bobChannel, warmUpPeriod,
+	)
+	defer aliceLink.Stop()
+
+	assertWarmedUp(t, aliceLink, 5*time.Second)
+
+	if time.Since(start) < warmUpPeriod {
+		t.Fatalf("link warmed up before warm-up period elapsed")
+	}
+}
+
+// TestChannelLinkWarmUpStopped tests that stopping a link which is still
+// warming up ends its warm-up, so that nothing waits on it indefinitely.
+func TestChannelLinkWarmUpStopped(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	addUnrevokedHtlc(t, link.(*channelLink), bobChannel)
+
+	aliceLink := restartWarmUpLink(
+		t, link.(*channelLink), bobChannel, time.Hour,
+	)
+	aliceLink.Stop()
+
+	select {
+	case <-aliceLink.WarmedUp():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("warm-up not ended by stopping link")
+	}
+}
+
+// TestChannelLinkBatchSettleInvoices tests that the invoices of all exit hop
+// HTLC's locked in within the same commitment update are settled within a
+// single batch, and that a second HTLC paying to an invoice settled earlier in
+// the batch is rejected.
+func TestChannelLinkBatchSettleInvoices(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	var (
+		aliceLink = link.(*channelLink)
+		aliceMsgs = aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+		registry  = aliceLink.cfg.Registry.(*mockInvoiceRegistry)
+	)
+
+	// Bob will send Alice two HTLC's paying to distinct invoices, followed
+	// by a third paying to the same invoice as the first.
+	const numInvoices = 2
+	var htlcs []*lnwire.UpdateAddHTLC
+	for i := 0; i < numInvoices; i++ {
+		htlcAmt, totalTimelock, hops := generateHops(
+			lnwire.NewMSatFromSatoshis(10000), testStartingHeight,
+			aliceLink,
+		)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to gen route: %v", err)
+		}
+		invoice, htlc, err := generatePayment(
+			htlcAmt, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		if err := registry.AddInvoice(*invoice); err != nil {
+			t.Fatalf("unable to add invoice to registry: %v", err)
+		}
+
+		htlcs = append(htlcs, htlc)
+	}
+	duplicate := *htlcs[0]
+	htlcs = append(htlcs, &duplicate)
+
+	for i, htlc := range htlcs {
+		htlc.ID = uint64(i)
+		if _, err := bobChannel.AddHTLC(htlc); err != nil {
+			t.Fatalf("unable to add htlc: %v", err)
+		}
+		aliceLink.HandleChannelUpdate(htlc)
+	}
+
+	// Once the HTLC's are locked in, Alice will settle the invoices.
+	if err := updateState(batchTick, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	var (
+		numFulfills int
+		numFails    int
+	)
+	for i := 0; i < len(htlcs); i++ {
+		var msg lnwire.Message
+		select {
+		case msg = <-aliceMsgs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive message")
+		}
+
+		switch m := msg.(type) {
+		case *lnwire.UpdateFulfillHTLC:
+			numFulfills++
+		case *lnwire.UpdateFailHTLC:
+			if m.ID != duplicate.ID {
+				t.Fatalf("expected htlc %v to be failed, "+
+					"instead %v was", duplicate.ID, m.ID)
+			}
+			numFails++
+		default:
+			t.Fatalf("unexpected message %T", msg)
+		}
+	}
+	if numFulfills != numInvoices || numFails != 1 {
+		t.Fatalf("expected %v fulfills and 1 fail, got %v and %v",
+			numInvoices, numFulfills, numFails)
+	}
+
+	registry.Lock()
+	batchSettles := registry.batchSettles
+	registry.Unlock()
+	if batchSettles != 1 {
+		t.Fatalf("expected invoices to be settled in a single batch, "+
+			"got %v", batchSettles)
+	}
+
+	for _, htlc := range htlcs[:numInvoices] {
+		rhash := htlc.PaymentHash
+		invoice, err := registry.LookupInvoice(rhash)
+		if err != nil {
+			t.Fatalf("unable to get invoice: %v", err)
+		}
+		if !invoice.Terms.Settled {
+			t.Fatalf("invoice %x wasn't settled", rhash[:])
+		}
+	}
+}
+
+// TestChannelLinkBatchSettlePartialFailure tests that if some of the invoices
+// within a batch can't be settled, then the remainder are still settled
+// rather than the link being failed. The HTLC's themselves are settled before
+// their invoices, so all of them are fulfilled.
+func TestChannelLinkBatchSettlePartialFailure(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	var (
+		aliceLink = link.(*channelLink)
+		aliceMsgs = aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+		registry  = aliceLink.cfg.Registry.(*mockInvoiceRegistry)
+	)
+
+	// Bob will send Alice two HTLC's paying to distinct invoices, the
+	// second of which can't be settled within the registry.
+	var htlcs []*lnwire.UpdateAddHTLC
+	for i := 0; i < 2; i++ {
+		htlcAmt, totalTimelock, hops := generateHops(
+			lnwire.NewMSatFromSatoshis(10000), testStartingHeight,
+			aliceLink,
+		)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to gen route: %v", err)
+		}
+		invoice, htlc, err := generatePayment(
+			htlcAmt, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		if err := registry.AddInvoice(*invoice); err != nil {
+			t.Fatalf("unable to add invoice to registry: %v", err)
+		}
+
+		htlcs = append(htlcs, htlc)
+	}
+
+	registry.Lock()
+	registry.settleErrs = map[chainhash.Hash]error{
+		htlcs[1].PaymentHash: fmt.Errorf("unable to settle"),
+	}
+	registry.Unlock()
+
+	for i, htlc := range htlcs {
+		htlc.ID = uint64(i)
+		if _, err := bobChannel.AddHTLC(htlc); err != nil {
+			t.Fatalf("unable to add htlc: %v", err)
+		}
+		aliceLink.HandleChannelUpdate(htlc)
+	}
+
+	if err := updateState(batchTick, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// As the HTLC's are settled before their invoices, Alice should
+	// fulfill both of them.
+	for _, htlc := range htlcs {
+		var msg lnwire.Message
+		select {
+		case msg = <-aliceMsgs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive message")
+		}
+
+		if _, ok := msg.(*lnwire.UpdateFulfillHTLC); !ok {
+			t.Fatalf("unexpected message %T for htlc %v", msg,
+				htlc.ID)
+		}
+	}
+
+	// The first invoice should have been settled, even though the second
+	// one couldn't be.
+	invoice, err := registry.LookupInvoice(htlcs[0].PaymentHash)
+	if err != nil {
+		t.Fatalf("unable to get invoice: %v", err)
+	}
+	if !invoice.Terms.Settled {
+		t.Fatalf("first invoice wasn't settled")
+	}
+	invoice, err = registry.LookupInvoice(htlcs[1].PaymentHash)
+	if err != nil {
+		t.Fatalf("unable to get invoice: %v", err)
+	}
+	if invoice.Terms.Settled {
+		t.Fatalf("second invoice shouldn't have been settled")
+	}
+}
+
+// TODO(roasbeef): add test for re-sending after hodl mode, to settle any lingering
+
+// TestChannelLinkIsolateInvalidHTLC ensures that if a single HTLC within a
+// batch can't be settled, then only that HTLC is failed back and its invoice
+// left unsettled, while the remainder of the batch is settled and the link
+// remains active.
+func TestChannelLinkIsolateInvalidHTLC(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatalf("unable to start three hop network: %v", err)
+	}
+	defer n.stop()
+
+	const (
+		numPayments = 4
+		badPayment  = 2
+	)
+
+	amount := lnwire.NewMSatFromSatoshis(10000)
+	htlcAmt, totalTimelock, hops := generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink)
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// We'll register an invoice with Bob for each payment. The invoice
+	// for the bad payment is stored under the payment's hash, but with a
+	// preimage that doesn't match it.
+	htlcs := make([]*lnwire.UpdateAddHTLC, numPayments)
+	for i := 0; i < numPayments; i++ {
+		invoice, htlc, err := generatePayment(
+			amount, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		htlcs[i] = htlc
+
+		if i == badPayment {
+			invoice.Terms.PaymentPreimage[0] ^= 1
+
+			registry := n.bobServer.registry
+			registry.Lock()
+			hash := chainhash.Hash(htlc.PaymentHash)
+			registry.invoices[hash] = *invoice
+			registry.Unlock()
+			continue
+		}
+
+		if err := n.bobServer.registry.AddInvoice(*invoice); err != nil {
+			t.Fatalf("unable to add invoice: %v", err)
+		}
+	}
+
+	// Send all payments concurrently, so they're processed within the
+	// same batches.
+	errChans := make([]chan error, numPayments)
+	for i, htlc := range htlcs {
+		errChans[i] = make(chan error, 1)
+		go func(htlc *lnwire.UpdateAddHTLC, errChan chan error) {
+			_, err := n.aliceServer.htlcSwitch.SendHTLC(
+				n.bobServer.PubKey(), htlc,
+				newMockDeobfuscator(),
+			)
+			errChan <- err
+		}(htlc, errChans[i])
+	}
+
+	for i, errChan := range errChans {
+		select {
+		case err := <-errChan:
+			switch {
+			case i == badPayment && err == nil:
+				t.Fatalf("payment %v should have failed", i)
+			case i != badPayment && err != nil:
+				t.Fatalf("payment %v failed: %v", i, err)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatalf("payment %v not resolved", i)
+		}
+	}
+
+	// Only the invoices of the HTLC's which were settled should have been
+	// settled, leaving that of the bad payment unsettled.
+	for i, htlc := range htlcs {
+		invoice, err := n.bobServer.registry.LookupInvoice(
+			htlc.PaymentHash,
+		)
+		if err != nil {
+			t.Fatalf("unable to get invoice: %v", err)
+		}
+		if invoice.Terms.Settled != (i != badPayment) {
+			t.Fatalf("expected invoice %v settled=%v, got %v", i,
+				i != badPayment, invoice.Terms.Settled)
+		}
+	}
+
+	// Bob's link should still be active, so a subsequent payment should
+	// succeed.
+	_, err = n.makePayment(n.aliceServer, n.bobServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment after invalid htlc: %v", err)
+	}
+}
+
+// TestChannelLinkMaxExtraCltv tests that forwards whose outgoing time-lock
+// exceeds the current height plus the time-lock delta by more than the
+// policy's MaxExtraCltv are failed with an expiry too far failure, while
+// those right at the limit are forwarded.
+func TestChannelLinkMaxExtraCltv(t *testing.T) {
+	t.Parallel()
+
+	// Without a MaxExtraCltv, the default applies.
+	policy := ForwardingPolicy{TimeLockDelta: 6}
+	if policy.maxOutgoingExpiry(100) != 106+DefaultMaxExtraCltv {
+		t.Fatalf("expected max expiry of %v, got %v",
+			106+DefaultMaxExtraCltv, policy.maxOutgoingExpiry(100))
+	}
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*5,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	const maxExtraCltv = 10
+	err = n.firstBobChannelLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: ForwardingPolicy{MaxExtraCltv: maxExtraCltv},
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+
+	// Shifting the route's starting height by some number of blocks
+	// raises Bob's outgoing time-lock beyond the current height plus his
+	// delta by that same number of blocks.
+	sendPayment := func(extraBlocks uint32) error {
+		amount := lnwire.NewMSatFromSatoshis(1000)
+		htlcAmt, totalTimelock, hops := generateHops(amount,
+			testStartingHeight+extraBlocks, n.firstBobChannelLink,
+			n.carolChannelLink)
+
+		_, err := n.makePayment(n.aliceServer, n.carolServer,
+			n.bobServer.PubKey(), hops, amount, htlcAmt,
+			totalTimelock).Wait(30 * time.Second)
+		return err
+	}
+
+	// A forward right at the limit should go through.
+	if err := sendPayment(maxExtraCltv); err != nil {
+		t.Fatalf("unable to send payment at the limit: %v", err)
+	}
+
+	// A single block beyond it should be rejected.
+	err = sendPayment(maxExtraCltv + 1)
+	if err == nil {
+		t.Fatalf("payment beyond the limit should fail")
+	}
+	if err.Error() != lnwire.CodeExpiryTooFar.String() {
+		t.Fatalf("expected %v, got %v", lnwire.CodeExpiryTooFar, err)
+	}
+}
@@ -0,0 +1,191 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// BandwidthDetail describes the liquidity available in either direction of a
+// channel link.
+type BandwidthDetail struct {
+	// Outbound is the amount which can currently be forwarded over the
+	// link, as returned by Bandwidth. It excludes our channel reserve, the
+	// link's soft reserve, any anchor outputs we pay for, and HTLC's
+	// waiting within the overflow queue.
+	Outbound lnwire.MilliSatoshi
+
+	// Inbound is the amount the remote peer can currently send to us over
+	// the link. It excludes the remote peer's channel reserve, along with
+	// any anchor outputs paid for by the remote peer.
+	Inbound lnwire.MilliSatoshi
+
+	// LocalReserve is our channel reserve. The portion of our balance it
+	// currently locks is given by ReserveInfo.
+	LocalReserve lnwire.MilliSatoshi
+
+	// RemoteReserve is the remote peer's channel reserve.
+	RemoteReserve lnwire.MilliSatoshi
+
+	// FreeSlots is the number of HTLC's we can currently offer over the
+	// link before reaching the channel's limit, including the slots
+	// reserved for locally initiated payments.
+	FreeSlots uint16
+}
+
+// BandwidthDetail returns the liquidity currently available in either
+// direction of the link, net of the reserves of both parties. The inbound
+// liquidity is derived from the remote balance of the latest fully committed
+// state, so it doesn't account for updates which are yet to be committed.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) BandwidthDetail() BandwidthDetail {
+	chanState := l.channel.State()
+	localReserve := lnwire.NewMSatFromSatoshis(
+		chanState.LocalChanCfg.ChanReserve,
+	)
+	remoteReserve := lnwire.NewMSatFromSatoshis(
+		chanState.RemoteChanCfg.ChanReserve,
+	)
+
+	// The remote peer's unavailable balance includes its reserve, along
+	// with the anchor outputs if it's the channel initiator.
+	remoteUnavailable := remoteReserve
+	if l.CommitmentType().HasAnchors() && !l.channel.IsInitiator() {
+		remoteUnavailable += lnwire.NewMSatFromSatoshis(2 * AnchorSize)
+	}
+
+	var inbound lnwire.MilliSatoshi
+	remoteBalance := l.channel.StateSnapshot().RemoteBalance
+	if remoteBalance > remoteUnavailable {
+		inbound = remoteBalance - remoteUnavailable
+	}
+
+	return BandwidthDetail{
+		Outbound:      l.Bandwidth(),
+		Inbound:       inbound,
+		LocalReserve:  localReserve,
+		RemoteReserve: remoteReserve,
+		FreeSlots:     l.freeSlots(),
+	}
+}
+
+// ReserveInfo describes the channel reserves of a link, and the extent to which
+// they constrain its bandwidth.
+type ReserveInfo struct {
+	// LocalReserve is the reserve the remote peer requires us to retain
+	// within the channel.
+	LocalReserve lnwire.MilliSatoshi
+
+	// RemoteReserve is the reserve we require the remote peer to retain
+	// within the channel.
+	RemoteReserve lnwire.MilliSatoshi
+
+	// Locked is the portion of our available balance which is currently
+	// locked by our reserve, and so is excluded from the link's
+	// bandwidth. It's less than LocalReserve if our balance has yet to
+	// reach the reserve.
+	Locked lnwire.MilliSatoshi
+}
+
+// ReserveInfo returns the channel reserves of both parties, along with how
+// much of our balance is currently locked by our reserve. HTLC's which would
+// dip our balance into the locked portion are rejected by the channel state
+// machine.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) ReserveInfo() ReserveInfo {
+	chanState := l.channel.State()
+	localReserve := lnwire.NewMSatFromSatoshis(
+		chanState.LocalChanCfg.ChanReserve,
+	)
+
+	locked := l.channel.AvailableBalance()
+	if locked > localReserve {
+		locked = localReserve
+	}
+
+	return ReserveInfo{
+		LocalReserve: localReserve,
+		RemoteReserve: lnwire.NewMSatFromSatoshis(
+			chanState.RemoteChanCfg.ChanReserve,
+		),
+		Locked: locked,
+	}
+}
+
+// Liquidity is the aggregate liquidity of a set of channels.
+type Liquidity struct {
+	// Outbound is the total amount which can currently be forwarded over
+	// the channels.
+	Outbound lnwire.MilliSatoshi
+
+	// Inbound is the total amount which can currently be received over
+	// the channels.
+	Inbound lnwire.MilliSatoshi
+
+	// NumOutbound is the number of channels with non-zero outbound
+	// liquidity.
+	NumOutbound int
+
+	// NumInbound is the number of channels with non-zero inbound
+	// liquidity.
+	NumInbound int
+}
+
+// add accounts for the liquidity of a single channel.
+func (l *Liquidity) add(detail BandwidthDetail) {
+	if detail.Outbound > 0 {
+		l.Outbound += detail.Outbound
+		l.NumOutbound++
+	}
+	if detail.Inbound > 0 {
+		l.Inbound += detail.Inbound
+		l.NumInbound++
+	}
+}
+
+// LiquiditySnapshot describes the liquidity available across all channels
+// which are eligible to forward.
+type LiquiditySnapshot struct {
+	// Liquidity is the node-wide liquidity.
+	Liquidity
+
+	// Peers breaks down the liquidity by the compressed public key of each
+	// peer with at least one channel eligible to forward.
+	Peers map[[33]byte]Liquidity
+}
+
+// LiquiditySnapshot returns the total inbound and outbound liquidity across
+// all channels which are currently eligible to forward, along with its
+// breakdown per peer, as derived from the BandwidthDetail of each link.
+// Ineligible channels, e.g. those which are closing or warming up, are
+// excluded. If the switch is shutting down, then an empty snapshot is
+// returned.
+//
+// NOTE: The snapshot of each link is taken independently, so the totals may
+// not reflect a single consistent point in time.
+func (s *Switch) LiquiditySnapshot() *LiquiditySnapshot {
+	snapshot := &LiquiditySnapshot{
+		Peers: make(map[[33]byte]Liquidity),
+	}
+
+	links, err := s.fetchAllLinks()
+	if err != nil {
+		return snapshot
+	}
+
+	for _, link := range links {
+		if !link.EligibleToForward() {
+			continue
+		}
+
+		detail := link.BandwidthDetail()
+		snapshot.add(detail)
+
+		peer := link.Peer().PubKey()
+		peerLiquidity := snapshot.Peers[peer]
+		peerLiquidity.add(detail)
+		snapshot.Peers[peer] = peerLiquidity
+	}
+
+	return snapshot
+}
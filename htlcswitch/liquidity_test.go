@@ -0,0 +1,177 @@
+package htlcswitch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/wire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchLiquiditySnapshot tests that the liquidity snapshot sums the
+// inbound and outbound liquidity of all links which are eligible to forward,
+// both node-wide and per peer.
+func TestSwitchLiquiditySnapshot(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	links := []struct {
+		peer        Peer
+		eligible    bool
+		bandwidth   lnwire.MilliSatoshi
+		softReserve lnwire.MilliSatoshi
+		inbound     lnwire.MilliSatoshi
+	}{
+		{alicePeer, true, 2000, 500, 1000},
+		{alicePeer, true, 0, 0, 3000},
+		{bobPeer, true, 5000, 0, 0},
+
+		// Ineligible links are excluded.
+		{bobPeer, false, 10000, 0, 10000},
+	}
+
+	for i, l := range links {
+		chanPoint := wire.NewOutPoint(hash1, uint32(i))
+		link := newMockChannelLink(
+			s, lnwire.NewChanIDFromOutPoint(chanPoint),
+			lnwire.NewShortChanIDFromInt(uint64(i+1)), l.peer,
+			l.eligible,
+		)
+		link.bandwidth = l.bandwidth
+		link.softReserve = l.softReserve
+		link.inbound = l.inbound
+
+		if err := s.AddLink(link); err != nil {
+			t.Fatalf("unable to add link: %v", err)
+		}
+	}
+
+	snapshot := s.LiquiditySnapshot()
+	expected := &LiquiditySnapshot{
+		Liquidity: Liquidity{
+			Outbound:    6500,
+			Inbound:     4000,
+			NumOutbound: 2,
+			NumInbound:  2,
+		},
+		Peers: map[[33]byte]Liquidity{
+			alicePeer.PubKey(): {
+				Outbound:    1500,
+				Inbound:     4000,
+				NumOutbound: 1,
+				NumInbound:  2,
+			},
+			bobPeer.PubKey(): {
+				Outbound:    5000,
+				NumOutbound: 1,
+			},
+		},
+	}
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Fatalf("expected snapshot %v, got %v", expected, snapshot)
+	}
+}
+
+// TestChannelLinkBandwidthDetail tests that the inbound liquidity of a link
+// excludes the remote peer's channel reserve, while its outbound liquidity
+// matches its bandwidth.
+func TestChannelLinkBandwidthDetail(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	const chanReserve = btcutil.SatoshiPerBitcoin * 1
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(
+		chanAmt, chanReserve,
+	)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	detail := aliceLink.BandwidthDetail()
+
+	if detail.Outbound != aliceLink.Bandwidth() {
+		t.Fatalf("expected outbound %v, got %v",
+			aliceLink.Bandwidth(), detail.Outbound)
+	}
+
+	reserve := lnwire.NewMSatFromSatoshis(chanReserve)
+	if detail.LocalReserve != reserve || detail.RemoteReserve != reserve {
+		t.Fatalf("expected reserves of %v, got local=%v, remote=%v",
+			reserve, detail.LocalReserve, detail.RemoteReserve)
+	}
+
+	remoteBalance := aliceLink.channel.StateSnapshot().RemoteBalance
+	if detail.Inbound != remoteBalance-reserve {
+		t.Fatalf("expected inbound %v, got %v",
+			remoteBalance-reserve, detail.Inbound)
+	}
+}
+
+// TestChannelLinkReserveInfo tests that the reserve info of a link reports the
+// reserves of both parties, that the balance it reports as locked accounts
+// for the difference between the link's bandwidth and its available balance,
+// and that the channel state machine rejects HTLC's dipping into it.
+func TestChannelLinkReserveInfo(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	const chanReserve = btcutil.SatoshiPerBitcoin * 1
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(
+		chanAmt, chanReserve,
+	)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	info := aliceLink.ReserveInfo()
+
+	reserve := lnwire.NewMSatFromSatoshis(chanReserve)
+	if info.LocalReserve != reserve || info.RemoteReserve != reserve {
+		t.Fatalf("expected reserves of %v, got local=%v, remote=%v",
+			reserve, info.LocalReserve, info.RemoteReserve)
+	}
+
+	// Our balance exceeds the reserve, so all of it is locked.
+	if info.Locked != reserve {
+		t.Fatalf("expected %v locked, got %v", reserve, info.Locked)
+	}
+
+	// The locked balance, along with any anchors we pay for, accounts
+	// for the difference between our available balance and the link's
+	// local bandwidth.
+	available := aliceLink.channel.AvailableBalance()
+	expected := available - info.Locked - aliceLink.anchorReserve()
+	if aliceLink.LocalBandwidth() != expected {
+		t.Fatalf("expected local bandwidth of %v, got %v", expected,
+			aliceLink.LocalBandwidth())
+	}
+
+	// An HTLC dipping into the locked balance must be rejected by the
+	// channel state machine.
+	err = aliceLink.channel.ValidateAddHTLC(available - info.Locked + 1)
+	if err != lnwallet.ErrBelowChanReserve {
+		t.Fatalf("expected ErrBelowChanReserve, got %v", err)
+	}
+
+	// The locked balance should also be surfaced within the link's stats
+	// snapshot.
+	snapshot := aliceLink.StatsSnapshot()
+	if snapshot.ReserveLocked != info.Locked {
+		t.Fatalf("expected %v locked within snapshot, got %v",
+			info.Locked, snapshot.ReserveLocked)
+	}
+}
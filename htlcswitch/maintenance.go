@@ -0,0 +1,206 @@
+package htlcswitch
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// week is the duration of a week, over which maintenance windows recur.
+const week = 7 * 24 * time.Hour
+
+// MaintenanceWindow is a weekly recurring window during which the switch
+// declines all new forwards. Times are interpreted in UTC.
+type MaintenanceWindow struct {
+	// Weekday is the day of the week the window starts on.
+	Weekday time.Weekday
+
+	// Start is the time of day, as an offset from midnight, the window
+	// starts at. It must be less than a day.
+	Start time.Duration
+
+	// Duration is the length of the window. It may extend into the
+	// following days, but mustn't exceed a week.
+	Duration time.Duration
+}
+
+// String returns a human readable representation of the MaintenanceWindow,
+// in the format accepted by ParseMaintenanceWindow.
+func (w MaintenanceWindow) String() string {
+	start := time.Time{}.Add(w.Start)
+	return fmt.Sprintf("%s %s %v", w.Weekday.String()[:3],
+		start.Format("15:04"), w.Duration)
+}
+
+// validate returns an error if the window is malformed.
+func (w MaintenanceWindow) validate() error {
+	switch {
+	case w.Weekday < time.Sunday || w.Weekday > time.Saturday:
+		return fmt.Errorf("invalid weekday: %v", w.Weekday)
+
+	case w.Start < 0 || w.Start >= 24*time.Hour:
+		return fmt.Errorf("start %v isn't within a day", w.Start)
+
+	case w.Duration <= 0 || w.Duration > week:
+		return fmt.Errorf("duration %v must be positive and at "+
+			"most a week", w.Duration)
+	}
+
+	return nil
+}
+
+// weekOffset returns the offset of the window's start from the start of the
+// week.
+func (w MaintenanceWindow) weekOffset() time.Duration {
+	return time.Duration(w.Weekday)*24*time.Hour + w.Start
+}
+
+// sinceStart returns the time elapsed between the most recent start of the
+// window and the passed time.
+func (w MaintenanceWindow) sinceStart(t time.Time) time.Duration {
+	t = t.UTC()
+	midnight := time.Date(
+		t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC,
+	)
+	offset := time.Duration(t.Weekday())*24*time.Hour + t.Sub(midnight)
+
+	since := (offset - w.weekOffset()) % week
+	if since < 0 {
+		since += week
+	}
+
+	return since
+}
+
+// ParseMaintenanceWindow parses a maintenance window of the form
+// "<weekday> <HH:MM> <duration>", e.g. "Sat 02:00 2h", where the weekday is
+// given by its three letter abbreviation and the start time is in UTC.
+func ParseMaintenanceWindow(s string) (MaintenanceWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q "+
+			"must be of the form \"<weekday> <HH:MM> <duration>\"",
+			s)
+	}
+
+	var window MaintenanceWindow
+
+	weekday := -1
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(fields[0], day.String()[:3]) {
+			weekday = int(day)
+			break
+		}
+	}
+	if weekday == -1 {
+		return window, fmt.Errorf("invalid weekday %q in maintenance "+
+			"window", fields[0])
+	}
+	window.Weekday = time.Weekday(weekday)
+
+	start, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return window, fmt.Errorf("invalid start %q in maintenance "+
+			"window: %v", fields[1], err)
+	}
+	window.Start = time.Duration(start.Hour())*time.Hour +
+		time.Duration(start.Minute())*time.Minute
+
+	window.Duration, err = time.ParseDuration(fields[2])
+	if err != nil {
+		return window, fmt.Errorf("invalid duration %q in maintenance "+
+			"window: %v", fields[2], err)
+	}
+
+	if err := window.validate(); err != nil {
+		return window, err
+	}
+
+	return window, nil
+}
+
+// maintenanceSchedule determines whether the switch is within one of its
+// configured maintenance windows.
+type maintenanceSchedule struct {
+	sync.Mutex
+
+	windows []MaintenanceWindow
+
+	// now returns the current time. It's overridden within tests in
+	// order to move between windows.
+	now func() time.Time
+}
+
+// newMaintenanceSchedule creates a new maintenanceSchedule for the passed
+// windows. Malformed windows are ignored.
+func newMaintenanceSchedule(windows []MaintenanceWindow) *maintenanceSchedule {
+	valid := make([]MaintenanceWindow, 0, len(windows))
+	for _, window := range windows {
+		if err := window.validate(); err != nil {
+			log.Errorf("Ignoring maintenance window %v: %v", window,
+				err)
+			continue
+		}
+		valid = append(valid, window)
+	}
+
+	return &maintenanceSchedule{
+		windows: valid,
+		now:     time.Now,
+	}
+}
+
+// active returns true if the current time is within a maintenance window.
+func (m *maintenanceSchedule) active() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	now := m.now()
+	for _, window := range m.windows {
+		if window.sinceStart(now) < window.Duration {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextStart returns the earliest start of a maintenance window after the
+// current time, or false if no windows are scheduled.
+func (m *maintenanceSchedule) nextStart() (time.Time, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.windows) == 0 {
+		return time.Time{}, false
+	}
+
+	now := m.now()
+	next := week
+	for _, window := range m.windows {
+		untilStart := week - window.sinceStart(now)
+		if untilStart < next {
+			next = untilStart
+		}
+	}
+
+	return now.Add(next), true
+}
+
+// InMaintenance returns true if the switch is currently within one of its
+// maintenance windows, during which all new forwards are declined.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) InMaintenance() bool {
+	return s.maintenance.active()
+}
+
+// NextMaintenanceWindow returns the start of the next maintenance window, or
+// false if none are scheduled. If the switch is currently within a window,
+// then the start of the following one is returned.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) NextMaintenanceWindow() (time.Time, bool) {
+	return s.maintenance.nextStart()
+}
@@ -0,0 +1,215 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestParseMaintenanceWindow tests that maintenance windows are parsed from
+// their string representation, and that malformed ones are rejected.
+func TestParseMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	window, err := ParseMaintenanceWindow("sat 02:30 2h")
+	if err != nil {
+		t.Fatalf("unable to parse window: %v", err)
+	}
+	expected := MaintenanceWindow{
+		Weekday:  time.Saturday,
+		Start:    2*time.Hour + 30*time.Minute,
+		Duration: 2 * time.Hour,
+	}
+	if window != expected {
+		t.Fatalf("expected window %v, got %v", expected, window)
+	}
+	if window.String() != "Sat 02:30 2h0m0s" {
+		t.Fatalf("unexpected string representation: %v", window)
+	}
+
+	invalid := []string{
+		"",
+		"sat 02:30",
+		"someday 02:30 2h",
+		"sat 25:00 2h",
+		"sat 02:30 soon",
+		"sat 02:30 0s",
+		"sat 02:30 200h",
+	}
+	for _, s := range invalid {
+		if _, err := ParseMaintenanceWindow(s); err == nil {
+			t.Fatalf("expected window %q to be rejected", s)
+		}
+	}
+}
+
+// TestMaintenanceSchedule tests that the schedule reports whether the current
+// time is within a window, including windows wrapping around the end of the
+// week, along with the start of the next window.
+func TestMaintenanceSchedule(t *testing.T) {
+	t.Parallel()
+
+	// 2024-01-06 is a Saturday.
+	clock := &mockClock{now: time.Date(2024, 1, 6, 1, 0, 0, 0, time.UTC)}
+	schedule := newMaintenanceSchedule([]MaintenanceWindow{
+		{
+			Weekday:  time.Saturday,
+			Start:    2 * time.Hour,
+			Duration: time.Hour,
+		},
+		{
+			Weekday:  time.Saturday,
+			Start:    23 * time.Hour,
+			Duration: 2 * time.Hour,
+		},
+		{
+			Weekday: time.Monday,
+		},
+	})
+	schedule.now = clock.Now
+
+	if len(schedule.windows) != 2 {
+		t.Fatalf("expected malformed window to be ignored")
+	}
+
+	assertSchedule := func(active bool, next time.Time) {
+		if schedule.active() != active {
+			t.Fatalf("expected active=%v at %v", active, clock.Now())
+		}
+		nextStart, ok := schedule.nextStart()
+		if !ok || !nextStart.Equal(next) {
+			t.Fatalf("expected next window at %v, got %v", next,
+				nextStart)
+		}
+	}
+
+	assertSchedule(false, time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC))
+
+	clock.advance(90 * time.Minute)
+	assertSchedule(true, time.Date(2024, 1, 6, 23, 0, 0, 0, time.UTC))
+
+	clock.advance(time.Hour)
+	assertSchedule(false, time.Date(2024, 1, 6, 23, 0, 0, 0, time.UTC))
+
+	// The late window extends past midnight into the following week.
+	clock.advance(21 * time.Hour)
+	assertSchedule(true, time.Date(2024, 1, 13, 2, 0, 0, 0, time.UTC))
+
+	clock.advance(time.Hour)
+	assertSchedule(false, time.Date(2024, 1, 13, 2, 0, 0, 0, time.UTC))
+
+	if _, ok := newMaintenanceSchedule(nil).nextStart(); ok {
+		t.Fatalf("expected no window without a schedule")
+	}
+}
+
+// TestSwitchMaintenanceWindow tests that forwards are declined with a channel
+// disabled failure during a maintenance window, and forwarded as normal
+// outside of it.
+func TestSwitchMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{
+		MaintenanceWindows: []MaintenanceWindow{{
+			Weekday:  time.Saturday,
+			Start:    2 * time.Hour,
+			Duration: time.Hour,
+		}},
+	})
+	clock := &mockClock{now: time.Date(2024, 1, 6, 2, 30, 0, 0, time.UTC)}
+	s.maintenance.now = clock.Now
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+	forward := func(htlcID uint64) {
+		s.forward(&htlcPacket{
+			incomingChanID: aliceChanID,
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1,
+			},
+		})
+	}
+
+	if !s.InMaintenance() {
+		t.Fatalf("expected switch to be in maintenance")
+	}
+
+	forward(0)
+	select {
+	case pkt := <-aliceChannelLink.packets:
+		fail, ok := pkt.htlc.(*lnwire.UpdateFailHTLC)
+		if !ok {
+			t.Fatalf("expected fail, got %T", pkt.htlc)
+		}
+		failure, err := lnwire.DecodeFailure(
+			bytes.NewReader(fail.Reason), 0,
+		)
+		if err != nil {
+			t.Fatalf("unable to decode failure: %v", err)
+		}
+		disabled, ok := failure.(*lnwire.FailChannelDisabled)
+		if !ok {
+			t.Fatalf("expected FailChannelDisabled, got %T",
+				failure)
+		}
+
+		// The failure should carry the outgoing link's signed update,
+		// with the disabled flag set.
+		update := disabled.Update
+		if update.ShortChannelID != bobChanID ||
+			update.Flags&lnwire.ChanUpdateDisabled == 0 ||
+			update.Signature != wireSig {
+
+			t.Fatalf("unexpected channel update: %v",
+				spew.Sdump(update))
+		}
+	case <-bobChannelLink.packets:
+		t.Fatalf("htlc forwarded during maintenance")
+	case <-time.After(time.Second):
+		t.Fatalf("htlc was neither forwarded nor failed")
+	}
+
+	// Once the window has passed, forwards resume.
+	clock.advance(time.Hour)
+	if s.InMaintenance() {
+		t.Fatalf("expected switch to be out of maintenance")
+	}
+
+	forward(1)
+	select {
+	case <-bobChannelLink.packets:
+	case <-aliceChannelLink.packets:
+		t.Fatalf("htlc failed outside of maintenance")
+	case <-time.After(time.Second):
+		t.Fatalf("htlc was neither forwarded nor failed")
+	}
+}
@@ -0,0 +1,135 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// MaxForwardableHTLC returns the largest single HTLC the link would currently
+// accept as a forward, and as such the largest shard which may be routed
+// through this hop. It accounts for the link's eligibility and its free HTLC
+// slots net of MinFreeSlots, its bandwidth net of the soft reserve, the
+// MaxValueInFlight and MaxAtRiskExposure of its forwarding policy, and the
+// constraints of the channel itself, including the commitment fee of the
+// HTLC's output should it not be trimmed as dust. Zero is returned if no
+// forward would be accepted.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) MaxForwardableHTLC() lnwire.MilliSatoshi {
+	if l.IneligibleReason() != IneligibleNone || !l.HasFreeSlot() ||
+		l.freeSlots() <= l.minFreeSlots() {
+
+		return 0
+	}
+
+	return l.maxForwardable(l.CurrentForwardingPolicy().Outbound)
+}
+
+// maxForwardable returns the largest single HTLC which fits within the link's
+// bandwidth, the limits of the passed forwarding policy, and the constraints
+// of the channel, disregarding the link's eligibility and free slots.
+func (l *channelLink) maxForwardable(
+	policy ForwardingPolicy) lnwire.MilliSatoshi {
+
+	// First, we'll bound the HTLC by the link level checks, which the
+	// channel is unaware of.
+	bound := l.Bandwidth()
+	if policy.MaxValueInFlight != 0 {
+		bound = remainingCap(
+			bound, policy.MaxValueInFlight,
+			l.channel.OutgoingValueInFlight(),
+		)
+	}
+	if policy.MaxAtRiskExposure != 0 {
+		bound = remainingCap(
+			bound, policy.MaxAtRiskExposure, l.AtRiskExposure(),
+		)
+	}
+	if bound == 0 {
+		return 0
+	}
+
+	// An HTLC at or above the dust threshold adds an output to the
+	// commitment, which the channel initiator pays the fee of, so the
+	// channel may accept a dust HTLC even if a slightly larger one
+	// doesn't fit. Within each of the two ranges the channel's
+	// constraints are monotonic, so we'll search for the largest HTLC it
+	// accepts within the non-dust range first.
+	feePerKw := l.channel.CommitFeeRate()
+	dustThreshold := l.htlcDustThreshold(feePerKw)
+	if !l.CommitmentType().ZeroHtlcTxFee() {
+		dustThreshold = l.channel.HtlcDustThreshold(false, feePerKw)
+	}
+	threshold := lnwire.NewMSatFromSatoshis(dustThreshold)
+
+	if bound >= threshold {
+		if amt := l.maxValidHTLC(threshold, bound); amt != 0 {
+			return amt
+		}
+		bound = threshold - 1
+	}
+
+	minHTLC := l.channel.State().LocalChanCfg.MinHTLC
+	if minHTLC == 0 {
+		minHTLC = 1
+	}
+	if bound < minHTLC {
+		return 0
+	}
+
+	return l.maxValidHTLC(minHTLC, bound)
+}
+
+// maxValidHTLC returns the largest amount within [lo, hi] which the channel
+// currently accepts as an HTLC we offer, assuming that any amount below an
+// accepted one is accepted as well. Zero is returned if lo isn't accepted.
+func (l *channelLink) maxValidHTLC(lo,
+	hi lnwire.MilliSatoshi) lnwire.MilliSatoshi {
+
+	if l.channel.ValidateAddHTLC(lo) != nil {
+		return 0
+	}
+
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if l.channel.ValidateAddHTLC(mid) == nil {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo
+}
+
+// remainingCap returns the smaller of amt and the room left below limit given
+// the amount already used.
+func remainingCap(amt, limit, used lnwire.MilliSatoshi) lnwire.MilliSatoshi {
+	if used >= limit {
+		return 0
+	}
+	if limit-used < amt {
+		return limit - used
+	}
+
+	return amt
+}
+
+// MaxForwardableHTLC returns the largest single HTLC which any of the active
+// links would currently accept as a forward, as returned by the
+// MaxForwardableHTLC of each link. Zero is returned if no link would accept a
+// forward, or the switch is shutting down.
+func (s *Switch) MaxForwardableHTLC() lnwire.MilliSatoshi {
+	links, err := s.fetchAllLinks()
+	if err != nil {
+		return 0
+	}
+
+	var maxAmt lnwire.MilliSatoshi
+	for _, link := range links {
+		if amt := link.MaxForwardableHTLC(); amt > maxAmt {
+			maxAmt = amt
+		}
+	}
+
+	return maxAmt
+}
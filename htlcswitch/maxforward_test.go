@@ -0,0 +1,120 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkMaxForwardableHTLC tests that the largest forwardable HTLC of
+// a link is bound by whichever of its constraints is binding, and that it
+// matches what the channel would accept.
+func TestChannelLinkMaxForwardableHTLC(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+
+	// Without any caps, the bandwidth is binding. The HTLC must fit
+	// along with the fee of its output, so it should be accepted by the
+	// channel, while the full bandwidth shouldn't.
+	maxAmt := aliceLink.MaxForwardableHTLC()
+	bandwidth := aliceLink.Bandwidth()
+	if maxAmt == 0 || maxAmt > bandwidth {
+		t.Fatalf("expected max forwardable htlc within bandwidth %v, "+
+			"got %v", bandwidth, maxAmt)
+	}
+	if err := aliceLink.channel.ValidateAddHTLC(maxAmt); err != nil {
+		t.Fatalf("max forwardable htlc of %v not accepted: %v",
+			maxAmt, err)
+	}
+	if maxAmt < bandwidth {
+		err := aliceLink.channel.ValidateAddHTLC(maxAmt + 1)
+		if err == nil {
+			t.Fatalf("htlc of %v above max forwardable htlc "+
+				"accepted", maxAmt+1)
+		}
+	}
+
+	// A cap on the value in flight below the bandwidth should be binding.
+	maxInFlight := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: ForwardingPolicy{MaxValueInFlight: maxInFlight},
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+	if maxAmt := aliceLink.MaxForwardableHTLC(); maxAmt != maxInFlight {
+		t.Fatalf("expected max forwardable htlc %v, got %v",
+			maxInFlight, maxAmt)
+	}
+
+	// A lower cap on the at-risk exposure should take over.
+	maxExposure := maxInFlight / 2
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: ForwardingPolicy{MaxAtRiskExposure: maxExposure},
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+	if maxAmt := aliceLink.MaxForwardableHTLC(); maxAmt != maxExposure {
+		t.Fatalf("expected max forwardable htlc %v, got %v",
+			maxExposure, maxAmt)
+	}
+
+	// Once all free slots are reserved, no forward should be accepted.
+	maxSlots := aliceLink.channel.State().LocalChanCfg.MaxAcceptedHtlcs
+	aliceLink.cfg.MinFreeSlots = maxSlots
+	if maxAmt := aliceLink.MaxForwardableHTLC(); maxAmt != 0 {
+		t.Fatalf("expected no forwardable htlc, got %v", maxAmt)
+	}
+}
+
+// TestSwitchMaxForwardableHTLC tests that the switch reports the largest
+// forwardable HTLC across all of its links.
+func TestSwitchMaxForwardableHTLC(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	if maxAmt := s.MaxForwardableHTLC(); maxAmt != 0 {
+		t.Fatalf("expected no forwardable htlc, got %v", maxAmt)
+	}
+
+	aliceLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobLink := newMockChannelLink(s, chanID2, bobChanID, bobPeer, true)
+	aliceLink.bandwidth = 1000
+	bobLink.bandwidth = 3000
+	for _, link := range []*mockChannelLink{aliceLink, bobLink} {
+		if err := s.AddLink(link); err != nil {
+			t.Fatalf("unable to add link: %v", err)
+		}
+	}
+
+	if maxAmt := s.MaxForwardableHTLC(); maxAmt != 3000 {
+		t.Fatalf("expected max forwardable htlc 3000, got %v", maxAmt)
+	}
+
+	// Once the larger link becomes ineligible, the smaller one should be
+	// binding.
+	bobLink.pendingClose = true
+	if maxAmt := s.MaxForwardableHTLC(); maxAmt != 1000 {
+		t.Fatalf("expected max forwardable htlc 1000, got %v", maxAmt)
+	}
+}
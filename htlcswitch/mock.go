@@ -1,6 +1,7 @@
 package htlcswitch
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
@@ -55,6 +56,52 @@ func (m *mockPreimageCache) SubscribeUpdates() *contractcourt.WitnessSubscriptio
 	return nil
 }
 
+type mockResolutionStore struct {
+	sync.Mutex
+	resolutions map[circuitKey]*ForwardResolution
+}
+
+func newMockResolutionStore() *mockResolutionStore {
+	return &mockResolutionStore{
+		resolutions: make(map[circuitKey]*ForwardResolution),
+	}
+}
+
+func (m *mockResolutionStore) AddResolution(r *ForwardResolution) error {
+	m.Lock()
+	defer m.Unlock()
+
+	key := circuitKey{chanID: r.IncomingChanID, htlcID: r.IncomingHTLCID}
+	m.resolutions[key] = r
+
+	return nil
+}
+
+func (m *mockResolutionStore) RemoveResolution(chanID lnwire.ShortChannelID,
+	htlcID uint64) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.resolutions, circuitKey{chanID: chanID, htlcID: htlcID})
+
+	return nil
+}
+
+func (m *mockResolutionStore) FetchResolutions() ([]*ForwardResolution, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	resolutions := make([]*ForwardResolution, 0, len(m.resolutions))
+	for _, r := range m.resolutions {
+		resolutions = append(resolutions, r)
+	}
+
+	return resolutions, nil
+}
+
+var _ ResolutionStore = (*mockResolutionStore)(nil)
+
 type mockFeeEstimator struct {
 	byteFeeIn chan lnwallet.SatPerVByte
 
@@ -362,6 +409,8 @@ func (s *mockServer) readHandler(message lnwire.Message) error {
 		targetChan = msg.ChanID
 	case *lnwire.UpdateFee:
 		targetChan = msg.ChanID
+	case *lnwire.Stfu:
+		targetChan = msg.ChanID
 	default:
 		return fmt.Errorf("unknown message type: %T", msg)
 	}
@@ -384,10 +433,15 @@ func (s *mockServer) PubKey() [33]byte {
 	return s.id
 }
 
-func (s *mockServer) Disconnect(reason error) {
-	fmt.Printf("server %v disconnected due to %v\n", s.name, reason)
+func (s *mockServer) Disconnect(reason DisconnectReason, err error) {
+	fmt.Printf("server %v disconnected due to %v: %v\n", s.name, reason,
+		err)
 
-	s.t.Fatalf("server %v was disconnected: %v", s.name, reason)
+	s.t.Fatalf("server %v was disconnected (%v): %v", s.name, reason, err)
+}
+
+func (s *mockServer) PingLatency() time.Duration {
+	return 0
 }
 
 func (s *mockServer) WipeChannel(*wire.OutPoint) error {
@@ -422,6 +476,30 @@ type mockChannelLink struct {
 
 	eligible bool
 
+	bandwidth lnwire.MilliSatoshi
+
+	softReserve lnwire.MilliSatoshi
+
+	inbound lnwire.MilliSatoshi
+
+	freeSlots uint16
+
+	overflowing bool
+
+	pendingClose bool
+
+	role ChannelRole
+
+	wedged bool
+
+	clearTimeout bool
+
+	clearCancelled bool
+
+	policyErr error
+
+	policyUpdates int
+
 	htlcID uint64
 }
 
@@ -436,6 +514,8 @@ func newMockChannelLink(htlcSwitch *Switch, chanID lnwire.ChannelID,
 		packets:     make(chan *htlcPacket, 1),
 		peer:        peer,
 		eligible:    eligible,
+		bandwidth:   99999999,
+		freeSlots:   lnwallet.MaxHTLCNumber / 2,
 	}
 }
 
@@ -462,24 +542,230 @@ func (f *mockChannelLink) HandleChannelUpdate(lnwire.Message) {
 func (f *mockChannelLink) UpdateForwardingPolicy(_ ForwardingPolicy) {
 }
 
+func (f *mockChannelLink) UpdateDirectionalPolicy(_ DirectionalPolicy) error {
+	if f.policyErr != nil {
+		return f.policyErr
+	}
+	f.policyUpdates++
+	return nil
+}
+
+func (f *mockChannelLink) CurrentForwardingPolicy() DirectionalPolicy {
+	return DirectionalPolicy{}
+}
+
+func (f *mockChannelLink) LastGossipedPolicy() GossipedPolicy {
+	return GossipedPolicy{}
+}
+
+func (f *mockChannelLink) DisabledChannelUpdate() (*lnwire.ChannelUpdate, error) {
+	update, err := mockGetChanUpdateMessage()
+	if err != nil {
+		return nil, err
+	}
+	update.ShortChannelID = f.shortChanID
+	update.Flags |= lnwire.ChanUpdateDisabled
+
+	return update, nil
+}
+
+func (f *mockChannelLink) ChannelRole() ChannelRole {
+	return f.role
+}
+
+func (f *mockChannelLink) ClearHTLCsForClose(_ context.Context) error {
+	if f.clearTimeout {
+		return ErrLinkClearTimeout
+	}
+	return nil
+}
+
+func (f *mockChannelLink) CancelClearHTLCs() {
+	f.clearCancelled = true
+}
+
+func (f *mockChannelLink) MarkPendingClose() {
+	f.pendingClose = true
+}
+
+func (f *mockChannelLink) transferSwitch(to *Switch,
+	transfer func() error) error {
+
+	if err := transfer(); err != nil {
+		return err
+	}
+	f.htlcSwitch = to
+	return nil
+}
+
+func (f *mockChannelLink) Quiesce(_ context.Context) (QuiescenceToken, error) {
+	return QuiescenceToken{}, nil
+}
+
+func (f *mockChannelLink) Resume(_ QuiescenceToken) error {
+	return nil
+}
+
+func (f *mockChannelLink) SignCommitmentNow() error {
+	return nil
+}
+
+func (f *mockChannelLink) Heartbeat() <-chan struct{} {
+	ack := make(chan struct{})
+	if !f.wedged {
+		close(ack)
+	}
+	return ack
+}
+
 func (f *mockChannelLink) Stats() (uint64, lnwire.MilliSatoshi, lnwire.MilliSatoshi) {
 	return 0, 0, 0
 }
 
+func (f *mockChannelLink) CommitmentState() CommitmentState {
+	return CommitmentState{}
+}
+
+func (f *mockChannelLink) AtRiskExposure() lnwire.MilliSatoshi {
+	return 0
+}
+
+func (f *mockChannelLink) SubscribeCommitments() *CommitmentSubscription {
+	updates := make(chan *CommitmentSnapshot)
+	var dropped uint64
+	return &CommitmentSubscription{
+		Updates: updates,
+		Cancel:  func() {},
+		dropped: &dropped,
+	}
+}
+
+func (f *mockChannelLink) CommitmentType() CommitmentType {
+	return CommitmentTypeLegacy
+}
+
+func (f *mockChannelLink) CommitHeights() (uint64, uint64, uint64) {
+	return 0, 0, 0
+}
+
+func (f *mockChannelLink) StatsDetail() *LinkStatsDetail {
+	return &LinkStatsDetail{}
+}
+
+func (f *mockChannelLink) ConsecutiveFailures() int {
+	return 0
+}
+
+func (f *mockChannelLink) ResetFailureCounter() {
+}
+
+func (f *mockChannelLink) StatsSnapshot() *LinkStatsSnapshot {
+	return &LinkStatsSnapshot{
+		PubKey:      f.peer.PubKey(),
+		ShortChanID: f.shortChanID,
+		PingLatency: f.peer.PingLatency(),
+	}
+}
+
 func (f *mockChannelLink) ChanID() lnwire.ChannelID                    { return f.chanID }
 func (f *mockChannelLink) ShortChanID() lnwire.ShortChannelID          { return f.shortChanID }
 func (f *mockChannelLink) UpdateShortChanID(sid lnwire.ShortChannelID) { f.shortChanID = sid }
-func (f *mockChannelLink) Bandwidth() lnwire.MilliSatoshi              { return 99999999 }
+func (f *mockChannelLink) HasFreeSlot() bool                           { return !f.overflowing }
 func (f *mockChannelLink) Peer() Peer                                  { return f.peer }
 func (f *mockChannelLink) Start() error                                { return nil }
 func (f *mockChannelLink) Stop()                                       {}
-func (f *mockChannelLink) EligibleToForward() bool                     { return f.eligible }
+
+func (f *mockChannelLink) Bandwidth() lnwire.MilliSatoshi {
+	if f.bandwidth < f.softReserve {
+		return 0
+	}
+	return f.bandwidth - f.softReserve
+}
+
+func (f *mockChannelLink) LocalBandwidth() lnwire.MilliSatoshi {
+	return f.bandwidth
+}
+
+func (f *mockChannelLink) BandwidthDetail() BandwidthDetail {
+	return BandwidthDetail{
+		Outbound:  f.Bandwidth(),
+		Inbound:   f.inbound,
+		FreeSlots: f.freeSlots,
+	}
+}
+
+func (f *mockChannelLink) ReserveInfo() ReserveInfo {
+	return ReserveInfo{}
+}
+
+func (f *mockChannelLink) MaxForwardableHTLC() lnwire.MilliSatoshi {
+	if f.IneligibleReason() != IneligibleNone || f.overflowing {
+		return 0
+	}
+	return f.Bandwidth()
+}
+
+func (f *mockChannelLink) QuoteFirstHop(
+	amt lnwire.MilliSatoshi) FirstHopQuote {
+
+	quote := FirstHopQuote{
+		IneligibleReason: f.IneligibleReason(),
+		Bandwidth:        f.Bandwidth(),
+		MaxHTLC:          f.MaxForwardableHTLC(),
+	}
+
+	switch {
+	case quote.IneligibleReason != IneligibleNone:
+		quote.Unusable = FirstHopIneligible
+	case f.overflowing:
+		quote.Unusable = FirstHopNoFreeSlot
+	case amt > quote.Bandwidth:
+		quote.Unusable = FirstHopInsufficientBandwidth
+	}
+
+	return quote
+}
+
+func (f *mockChannelLink) CanAddHTLC(amt lnwire.MilliSatoshi) (bool, error) {
+	if amt > f.bandwidth {
+		return false, ErrInsufficientBandwidth
+	}
+	return true, nil
+}
+
+func (f *mockChannelLink) EligibleToForward() bool {
+	return f.eligible && !f.pendingClose
+}
+
+func (f *mockChannelLink) IneligibleReason() IneligibleReason {
+	switch {
+	case f.pendingClose:
+		return IneligibleClosing
+	case f.eligible:
+		return IneligibleNone
+	default:
+		return IneligibleNoRevocation
+	}
+}
+
+func (f *mockChannelLink) WarmedUp() <-chan struct{} {
+	warmedUp := make(chan struct{})
+	close(warmedUp)
+	return warmedUp
+}
 
 var _ ChannelLink = (*mockChannelLink)(nil)
 
 type mockInvoiceRegistry struct {
 	sync.Mutex
 	invoices map[chainhash.Hash]channeldb.Invoice
+
+	// batchSettles is the number of calls made to BatchSettleInvoice.
+	batchSettles int
+
+	// settleErrs holds the errors to be returned by BatchSettleInvoice
+	// when settling the invoices with the given payment hashes.
+	settleErrs map[chainhash.Hash]error
 }
 
 func newMockRegistry() *mockInvoiceRegistry {
@@ -515,6 +801,33 @@ func (i *mockInvoiceRegistry) SettleInvoice(rhash chainhash.Hash) error {
 	return nil
 }
 
+func (i *mockInvoiceRegistry) BatchSettleInvoice(reqs []SettleRequest) []error {
+	i.Lock()
+	defer i.Unlock()
+
+	i.batchSettles++
+
+	errs := make([]error, len(reqs))
+	for j, req := range reqs {
+		if err, ok := i.settleErrs[req.PaymentHash]; ok {
+			errs[j] = err
+			continue
+		}
+
+		invoice, ok := i.invoices[req.PaymentHash]
+		if !ok {
+			errs[j] = fmt.Errorf("can't find mock invoice: %x",
+				req.PaymentHash[:])
+			continue
+		}
+
+		invoice.Terms.Settled = true
+		i.invoices[req.PaymentHash] = invoice
+	}
+
+	return errs
+}
+
 func (i *mockInvoiceRegistry) AddInvoice(invoice channeldb.Invoice) error {
 	i.Lock()
 	defer i.Unlock()
@@ -0,0 +1,214 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+// DefaultOraclePollInterval is the default interval at which an
+// OracleResolver consults its oracle while HTLC's are parked.
+const DefaultOraclePollInterval = 30 * time.Second
+
+// ErrOracleUndecided is returned by an OracleClient which has yet to decide
+// whether the payment to a hash should be settled or cancelled.
+var ErrOracleUndecided = errors.New("oracle hasn't decided yet")
+
+// OracleClient is an external oracle which decides whether HTLC's parked for a
+// payment hash should be settled, e.g. once the outcome of a contract or an
+// escrow is known.
+type OracleClient interface {
+	// Confirm returns true along with the preimage of the passed hash if
+	// the oracle has signed off on the payment, and false if it has
+	// rejected it. ErrOracleUndecided is returned if the oracle has yet
+	// to decide. Any error is treated as undecided, and the oracle is
+	// consulted again later.
+	Confirm(hash chainhash.Hash) (bool, [32]byte, error)
+}
+
+// oracleDecision is the result of a single call to OracleClient.Confirm.
+type oracleDecision struct {
+	confirmed bool
+	preimage  [32]byte
+	err       error
+}
+
+// OracleResolver automatically resolves the HTLC's parked by a hold resolver
+// according to the decision of an external oracle. Once HTLC's have been
+// parked, the oracle is consulted immediately, then at every
+// OraclePollInterval, or whenever Check is called, until it either confirms
+// the payment, at which point the HTLC's are settled, or rejects it, at which
+// point they're cancelled. As with any hold resolution, the parked HTLC's are
+// cancelled once they near their expiry, regardless of the oracle.
+type OracleResolver struct {
+	// PaymentHash is the payment hash the resolver settles.
+	PaymentHash chainhash.Hash
+
+	s           *Switch
+	oracle      OracleClient
+	resolutions <-chan *HoldResolution
+
+	check chan struct{}
+	done  chan struct{}
+}
+
+// RegisterOracleResolver registers a hold resolver for the passed payment
+// hash, whose parked HTLC's are resolved according to the decision of the
+// passed oracle.
+func (s *Switch) RegisterOracleResolver(hash chainhash.Hash,
+	oracle OracleClient) *OracleResolver {
+
+	r := &OracleResolver{
+		PaymentHash: hash,
+		s:           s,
+		oracle:      oracle,
+		resolutions: s.RegisterHoldResolver(hash),
+		check:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	s.setHoldResolverKind(hash, HoldResolverOracle)
+
+	go r.resolve()
+
+	return r
+}
+
+// Check prompts the resolver to consult its oracle right away, e.g. once the
+// oracle is known to have published its decision, rather than waiting for
+// the next poll.
+func (r *OracleResolver) Check() {
+	select {
+	case r.check <- struct{}{}:
+	default:
+	}
+}
+
+// Done returns a channel which is closed once the parked HTLC's have been
+// resolved, either due to the oracle's decision, or due to nearing their
+// expiry.
+func (r *OracleResolver) Done() <-chan struct{} {
+	return r.done
+}
+
+// pollInterval returns the interval at which the oracle is consulted.
+func (r *OracleResolver) pollInterval() time.Duration {
+	if r.s.cfg.OraclePollInterval == 0 {
+		return DefaultOraclePollInterval
+	}
+
+	return r.s.cfg.OraclePollInterval
+}
+
+// confirm consults the oracle within its own goroutine, such that an oracle
+// which hangs never prevents the resolver from exiting.
+func (r *OracleResolver) confirm() <-chan *oracleDecision {
+	decision := make(chan *oracleDecision, 1)
+	go func() {
+		confirmed, preimage, err := r.oracle.Confirm(r.PaymentHash)
+		decision <- &oracleDecision{
+			confirmed: confirmed,
+			preimage:  preimage,
+			err:       err,
+		}
+	}()
+
+	return decision
+}
+
+// resolve waits for HTLC's to be parked for the payment hash, then consults
+// the oracle until it decides, and resolves the HTLC's accordingly. It exits
+// early if the HTLC's are resolved otherwise, e.g. once they near their
+// expiry.
+//
+// NOTE: This MUST be run as a goroutine.
+func (r *OracleResolver) resolve() {
+	defer close(r.done)
+
+	var resolution *HoldResolution
+	select {
+	case res, ok := <-r.resolutions:
+		if !ok {
+			return
+		}
+		resolution = res
+
+	case <-r.s.quit:
+		return
+	}
+
+	ticker := time.NewTicker(r.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case decision := <-r.confirm():
+			if r.handleDecision(resolution, decision) {
+				return
+			}
+
+		// The HTLC's were resolved while awaiting the oracle, most
+		// likely as they neared their expiry.
+		case <-r.resolutions:
+			log.Infof("Hold resolution for hash(%x) resolved while "+
+				"awaiting oracle", r.PaymentHash[:])
+			return
+
+		case <-r.s.quit:
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.check:
+		case <-r.resolutions:
+			log.Infof("Hold resolution for hash(%x) resolved while "+
+				"awaiting oracle", r.PaymentHash[:])
+			return
+
+		case <-r.s.quit:
+			return
+		}
+	}
+}
+
+// handleDecision settles or cancels the parked HTLC's according to the
+// oracle's decision. True is returned once the HTLC's are no longer parked.
+func (r *OracleResolver) handleDecision(resolution *HoldResolution,
+	decision *oracleDecision) bool {
+
+	var err error
+	switch {
+	case decision.err == ErrOracleUndecided:
+		return false
+
+	// We'll keep consulting an oracle which fails, as the HTLC's are
+	// cancelled once they near their expiry regardless.
+	case decision.err != nil:
+		log.Errorf("Unable to consult oracle for hash(%x): %v",
+			r.PaymentHash[:], decision.err)
+		return false
+
+	case decision.confirmed:
+		log.Infof("Oracle confirmed hash(%x), settling parked htlcs",
+			r.PaymentHash[:])
+		err = resolution.Settle(decision.preimage)
+
+	default:
+		log.Infof("Oracle rejected hash(%x), cancelling parked htlcs",
+			r.PaymentHash[:])
+		err = resolution.Cancel()
+	}
+
+	switch err {
+	case nil, ErrHoldResolved:
+		return true
+
+	// If the oracle confirmed the payment with the wrong preimage, then
+	// we'll treat it as an oracle failure.
+	default:
+		log.Errorf("Unable to resolve parked htlcs for hash(%x): %v",
+			r.PaymentHash[:], err)
+		return false
+	}
+}
@@ -0,0 +1,205 @@
+package htlcswitch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/chainntnfs"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
+)
+
+// mockOracle is an OracleClient whose decision is set by the test.
+type mockOracle struct {
+	sync.Mutex
+
+	confirmed bool
+	preimage  [32]byte
+	err       error
+
+	calls int
+}
+
+func (m *mockOracle) Confirm(hash chainhash.Hash) (bool, [32]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.calls++
+	return m.confirmed, m.preimage, m.err
+}
+
+func (m *mockOracle) decide(confirmed bool, preimage [32]byte, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.confirmed = confirmed
+	m.preimage = preimage
+	m.err = err
+}
+
+func (m *mockOracle) numCalls() int {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.calls
+}
+
+// sendOraclePayment registers an oracle resolver on Bob's switch, then sends
+// a payment from Alice to Bob paying to the registered hash.
+func sendOraclePayment(t *testing.T, n *threeHopNetwork,
+	oracle OracleClient) (*OracleResolver, *holdTestPayment) {
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink)
+
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatalf("unable to generate route: %v", err)
+	}
+	invoice, htlc, err := generatePayment(amount, htlcAmt, totalTimelock,
+		blob)
+	if err != nil {
+		t.Fatalf("unable to generate payment: %v", err)
+	}
+
+	p := &holdTestPayment{
+		preimage:   invoice.Terms.PaymentPreimage,
+		rhash:      fastsha256.Sum256(invoice.Terms.PaymentPreimage[:]),
+		htlcAmt:    htlcAmt,
+		timelock:   totalTimelock,
+		paymentErr: make(chan error, 1),
+	}
+	resolver := n.bobServer.htlcSwitch.RegisterOracleResolver(
+		p.rhash, oracle,
+	)
+
+	go func() {
+		_, err := n.aliceServer.htlcSwitch.SendHTLC(
+			n.bobServer.PubKey(), htlc, newMockDeobfuscator(),
+		)
+		p.paymentErr <- err
+	}()
+
+	return resolver, p
+}
+
+// TestOracleResolver ensures that HTLC's parked for an oracle resolver are
+// held while the oracle is undecided or failing, then settled or cancelled
+// according to its decision, and cancelled once they near their expiry if the
+// oracle never decides.
+func TestOracleResolver(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		// resolve resolves the parked HTLC by way of the oracle, or
+		// otherwise.
+		resolve func(*threeHopNetwork, *mockOracle, *holdTestPayment)
+
+		settled bool
+	}{
+		{
+			name: "confirmed",
+			resolve: func(_ *threeHopNetwork, oracle *mockOracle,
+				p *holdTestPayment) {
+
+				oracle.decide(true, p.preimage, nil)
+			},
+			settled: true,
+		},
+		{
+			name: "rejected",
+			resolve: func(_ *threeHopNetwork, oracle *mockOracle,
+				_ *holdTestPayment) {
+
+				oracle.decide(false, [32]byte{}, nil)
+			},
+		},
+		{
+			name: "expired",
+			resolve: func(n *threeHopNetwork, oracle *mockOracle,
+				p *holdTestPayment) {
+
+				n.bobFirstBlockEpoch <- &chainntnfs.BlockEpoch{
+					Height: int32(p.timelock - expiryGraceDelta),
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		channels, cleanUp, _, err := createClusterChannels(
+			btcutil.SatoshiPerBitcoin*3,
+			btcutil.SatoshiPerBitcoin*5)
+		if err != nil {
+			t.Fatalf("unable to create channel: %v", err)
+		}
+
+		n := newThreeHopNetwork(t, channels.aliceToBob,
+			channels.bobToAlice, channels.bobToCarol,
+			channels.carolToBob, testStartingHeight)
+		if err := n.start(); err != nil {
+			cleanUp()
+			t.Fatal(err)
+		}
+		n.bobServer.htlcSwitch.cfg.OraclePollInterval = time.Hour
+
+		// The oracle starts off failing, which shouldn't resolve the
+		// parked HTLC.
+		oracle := &mockOracle{err: errors.New("oracle unreachable")}
+		resolver, p := sendOraclePayment(t, n, oracle)
+
+		for i := 0; oracle.numCalls() == 0; i++ {
+			if i == 100 {
+				t.Fatalf("%v: oracle not consulted", test.name)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		// An undecided oracle shouldn't resolve it either.
+		oracle.decide(false, [32]byte{}, ErrOracleUndecided)
+		resolver.Check()
+
+		select {
+		case err := <-p.paymentErr:
+			t.Fatalf("%v: payment completed while htlc parked: %v",
+				test.name, err)
+		case <-resolver.Done():
+			t.Fatalf("%v: resolved while oracle undecided",
+				test.name)
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		test.resolve(n, oracle, p)
+		resolver.Check()
+
+		select {
+		case <-resolver.Done():
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%v: oracle resolver not done", test.name)
+		}
+
+		select {
+		case err := <-p.paymentErr:
+			if test.settled && err != nil {
+				t.Fatalf("%v: payment failed: %v", test.name,
+					err)
+			}
+			if !test.settled && err == nil {
+				t.Fatalf("%v: payment should have failed",
+					test.name)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%v: payment not resolved", test.name)
+		}
+
+		n.stop()
+		cleanUp()
+	}
+}
@@ -0,0 +1,61 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// overflowBudgetDivisor bounds the time an add may wait within the overflow
+// queue to this fraction of the time remaining until its expiry, such that
+// ample time remains to forward the HTLC, or fail it back, once it leaves the
+// queue.
+const overflowBudgetDivisor = 10
+
+// overflowDeadline returns the time by which the passed add must leave the
+// overflow queue before it's failed back, as per the link's MaxOverflowWait.
+// The wait is further bounded to a fraction of the time remaining until the
+// HTLC's expiry. If MaxOverflowWait is zero, then the zero time is returned,
+// and the add waits until a slot becomes available.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) overflowDeadline(htlc *lnwire.UpdateAddHTLC) time.Time {
+	maxWait := l.cfg.MaxOverflowWait
+	if maxWait == 0 {
+		return time.Time{}
+	}
+
+	var budget time.Duration
+	if htlc.Expiry > l.bestHeight {
+		blocks := time.Duration(htlc.Expiry - l.bestHeight)
+		budget = blocks * expectedBlockInterval / overflowBudgetDivisor
+	}
+	if budget < maxWait {
+		maxWait = budget
+	}
+
+	return time.Now().Add(maxWait)
+}
+
+// parkAdd places the passed add within the overflow queue, where it waits
+// for a slot within the commitment transaction to become available until its
+// overflow deadline.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) parkAdd(pkt *htlcPacket, htlc *lnwire.UpdateAddHTLC) {
+	l.overflowQueue.AddPktWithDeadline(pkt, l.overflowDeadline(htlc))
+}
+
+// handleExpiredOverflow fails back an add which didn't leave the overflow
+// queue by its deadline.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleExpiredOverflow(pkt *htlcPacket) {
+	htlc := pkt.htlc.(*lnwire.UpdateAddHTLC)
+	log.Infof("ChannelLink(%v) failing downstream htlc with payment "+
+		"hash(%x) as no slot became available within %v", l,
+		htlc.PaymentHash[:], l.cfg.MaxOverflowWait)
+
+	l.failDownstreamAdd(pkt, htlc)
+	l.checkHtlcsCleared()
+}
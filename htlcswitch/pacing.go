@@ -0,0 +1,227 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// DefaultPacingMaxAddDelay is the default maximum duration an outgoing
+	// HTLC will be held back by pacing before being added to the channel.
+	DefaultPacingMaxAddDelay = time.Second
+
+	// DefaultPacingUrgentDelta is the default number of blocks from
+	// expiry within which an outgoing HTLC is deemed time-critical, and
+	// is added to the channel without any pacing delay.
+	DefaultPacingUrgentDelta = 40
+)
+
+// PacingPolicy governs the rate at which a link adds outgoing HTLC's to its
+// channel, and how often it signs commitments covering them. Rather than
+// bursting many HTLC's followed by a single large commitment, a paced link
+// spaces out its adds and signs intermediate commitments, trading a bit of
+// latency for smaller commitments and smoother signing. The zero value
+// disables pacing.
+type PacingPolicy struct {
+	// AddInterval is the minimum duration between two consecutive
+	// outgoing HTLC adds. Adds arriving sooner are queued in order. If
+	// zero, then adds aren't spaced out.
+	AddInterval time.Duration
+
+	// MaxAddDelay is the maximum duration an add will be queued for
+	// before it's sent regardless of AddInterval. If zero, then
+	// DefaultPacingMaxAddDelay is used.
+	MaxAddDelay time.Duration
+
+	// UrgentDelta is the number of blocks from expiry within which an
+	// outgoing HTLC is deemed time-critical, in which case it bypasses
+	// the queue entirely. If zero, then DefaultPacingUrgentDelta is used.
+	UrgentDelta uint32
+
+	// CommitAfterAdds, if non-zero, causes the link to sign a new
+	// commitment after every CommitAfterAdds outgoing adds, rather than
+	// waiting for the batch to fill.
+	CommitAfterAdds uint32
+
+	// CommitInterval, if non-zero, is the maximum duration an outgoing
+	// add will remain uncommitted before the link signs a new commitment
+	// covering it.
+	CommitInterval time.Duration
+}
+
+// maxAddDelay returns the maximum duration an add may be queued for.
+func (p *PacingPolicy) maxAddDelay() time.Duration {
+	if p.MaxAddDelay == 0 {
+		return DefaultPacingMaxAddDelay
+	}
+	return p.MaxAddDelay
+}
+
+// urgentDelta returns the number of blocks from expiry within which an add
+// isn't paced.
+func (p *PacingPolicy) urgentDelta() uint32 {
+	if p.UrgentDelta == 0 {
+		return DefaultPacingUrgentDelta
+	}
+	return p.UrgentDelta
+}
+
+// pacedPkt is an outgoing add which has been queued by the link's pacer.
+type pacedPkt struct {
+	pkt *htlcPacket
+
+	// deadline is the time by which the add must be sent.
+	deadline time.Time
+}
+
+// pacingState tracks the outgoing adds held back by pacing, along with the
+// adds which have yet to be committed. It's only accessed from within the
+// htlcManager goroutine.
+type pacingState struct {
+	// queue is the set of adds awaiting their turn, in the order they
+	// were received from the switch.
+	queue []*pacedPkt
+
+	// lastAdd is the time the last outgoing add was sent.
+	lastAdd time.Time
+
+	// addTick fires once the next queued add is due to be sent. It's nil
+	// while the queue is empty.
+	addTick <-chan time.Time
+
+	// uncommittedAdds is the number of outgoing adds sent since we last
+	// signed a commitment.
+	uncommittedAdds uint32
+
+	// commitTick fires once the oldest uncommitted add has been
+	// uncommitted for CommitInterval. It's nil while there are no such
+	// adds.
+	commitTick <-chan time.Time
+}
+
+// shouldPace returns true if the passed downstream packet should be queued by
+// the pacer, rather than being handled immediately.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) shouldPace(pkt *htlcPacket) bool {
+	policy := &l.cfg.Pacing
+	if policy.AddInterval == 0 {
+		return false
+	}
+
+	htlc, ok := pkt.htlc.(*lnwire.UpdateAddHTLC)
+	if !ok || l.isUrgentAdd(htlc) {
+		return false
+	}
+
+	// If no other adds are waiting and the interval has already elapsed
+	// since the last one, then there's no need to delay this add.
+	return len(l.pacer.queue) != 0 ||
+		time.Since(l.pacer.lastAdd) < policy.AddInterval
+}
+
+// isUrgentAdd returns true if the passed HTLC is close enough to its expiry
+// that it mustn't be delayed by pacing.
+func (l *channelLink) isUrgentAdd(htlc *lnwire.UpdateAddHTLC) bool {
+	return htlc.Expiry <= l.bestHeight+l.cfg.Pacing.urgentDelta()
+}
+
+// paceAdd queues the passed add to be sent once the pacing interval since
+// the previous add has elapsed.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) paceAdd(pkt *htlcPacket) {
+	l.pacer.queue = append(l.pacer.queue, &pacedPkt{
+		pkt:      pkt,
+		deadline: time.Now().Add(l.cfg.Pacing.maxAddDelay()),
+	})
+
+	if l.pacer.addTick == nil {
+		l.armAddTick(time.Now())
+	}
+}
+
+// armAddTick arms the pacer's add ticker such that it fires once the head of
+// the queue is due, either because the add interval has elapsed or it has
+// reached its deadline.
+func (l *channelLink) armAddTick(now time.Time) {
+	if len(l.pacer.queue) == 0 {
+		l.pacer.addTick = nil
+		return
+	}
+
+	next := l.pacer.lastAdd.Add(l.cfg.Pacing.AddInterval)
+	if deadline := l.pacer.queue[0].deadline; deadline.Before(next) {
+		next = deadline
+	}
+
+	l.pacer.addTick = time.After(next.Sub(now))
+}
+
+// releasePacedAdds sends the add at the head of the pacing queue, along with
+// any others which have reached their deadline.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) releasePacedAdds() {
+	now := time.Now()
+	for i := 0; len(l.pacer.queue) > 0; i++ {
+		next := l.pacer.queue[0]
+		if i > 0 && now.Before(next.deadline) {
+			break
+		}
+
+		l.pacer.queue[0] = nil
+		l.pacer.queue = l.pacer.queue[1:]
+		l.handleDownStreamPkt(next.pkt, false)
+	}
+
+	l.armAddTick(now)
+}
+
+// releaseUrgentAdds sends any queued adds which have become time-critical
+// following the arrival of a new block.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) releaseUrgentAdds() {
+	var (
+		queue  []*pacedPkt
+		urgent []*htlcPacket
+	)
+	for _, paced := range l.pacer.queue {
+		htlc := paced.pkt.htlc.(*lnwire.UpdateAddHTLC)
+		if l.isUrgentAdd(htlc) {
+			urgent = append(urgent, paced.pkt)
+			continue
+		}
+		queue = append(queue, paced)
+	}
+	if len(urgent) == 0 {
+		return
+	}
+
+	l.pacer.queue = queue
+	for _, pkt := range urgent {
+		l.handleDownStreamPkt(pkt, false)
+	}
+
+	l.armAddTick(time.Now())
+}
+
+// pacedAddSent notes that an outgoing add has just been sent, and returns
+// true if a new commitment should be signed as per the pacing policy.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) pacedAddSent() bool {
+	policy := &l.cfg.Pacing
+
+	l.pacer.lastAdd = time.Now()
+	l.pacer.uncommittedAdds++
+
+	if policy.CommitInterval != 0 && l.pacer.commitTick == nil {
+		l.pacer.commitTick = time.After(policy.CommitInterval)
+	}
+
+	return policy.CommitAfterAdds != 0 &&
+		l.pacer.uncommittedAdds >= policy.CommitAfterAdds
+}
@@ -0,0 +1,158 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// setPacingPolicy sets the pacing policy of the passed link. This must be
+// done before any packets are handed to the link, which orders the write
+// before any reads made by the link's htlcManager goroutine.
+func setPacingPolicy(link ChannelLink, policy PacingPolicy) *channelLink {
+	coreLink := link.(*channelLink)
+	coreLink.cfg.Pacing = policy
+	return coreLink
+}
+
+// sendPacedAdd hands a new outgoing add with the given expiry to the link.
+func sendPacedAdd(t *testing.T, link *channelLink, expiry uint32) {
+	var mockBlob [lnwire.OnionPacketSize]byte
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, expiry, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+
+	link.HandleSwitchPacket(&htlcPacket{
+		htlc: htlc,
+	})
+}
+
+// receivePacedMsg waits for the next message sent by the link, returning it
+// along with the time it was received.
+func receivePacedMsg(t *testing.T, msgs chan lnwire.Message) (lnwire.Message,
+	time.Time) {
+
+	select {
+	case msg := <-msgs:
+		return msg, time.Now()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive message")
+		return nil, time.Time{}
+	}
+}
+
+// TestChannelLinkPacing tests that a paced link spaces out its outgoing adds
+// by the add interval, and signs a commitment after every CommitAfterAdds
+// adds.
+func TestChannelLinkPacing(t *testing.T) {
+	t.Parallel()
+
+	const (
+		chanAmt     = btcutil.SatoshiPerBitcoin * 5
+		addInterval = 300 * time.Millisecond
+	)
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := setPacingPolicy(link, PacingPolicy{
+		AddInterval:     addInterval,
+		CommitAfterAdds: 2,
+	})
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// We'll send three adds in quick succession, none of which are close
+	// to expiry.
+	for i := 0; i < 3; i++ {
+		sendPacedAdd(t, aliceLink, 1000)
+	}
+
+	// The first add should be sent right away, the second only once the
+	// add interval has elapsed, followed by a commitment covering both.
+	msg, firstAdd := receivePacedMsg(t, aliceMsgs)
+	if _, ok := msg.(*lnwire.UpdateAddHTLC); !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+
+	msg, secondAdd := receivePacedMsg(t, aliceMsgs)
+	if _, ok := msg.(*lnwire.UpdateAddHTLC); !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	if gap := secondAdd.Sub(firstAdd); gap < addInterval*2/3 {
+		t.Fatalf("adds weren't paced: sent %v apart", gap)
+	}
+
+	msg, _ = receivePacedMsg(t, aliceMsgs)
+	if _, ok := msg.(*lnwire.CommitSig); !ok {
+		t.Fatalf("expected CommitSig, got %T", msg)
+	}
+
+	msg, thirdAdd := receivePacedMsg(t, aliceMsgs)
+	if _, ok := msg.(*lnwire.UpdateAddHTLC); !ok {
+		t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+	}
+	if gap := thirdAdd.Sub(secondAdd); gap < addInterval*2/3 {
+		t.Fatalf("adds weren't paced: sent %v apart", gap)
+	}
+}
+
+// TestChannelLinkPacingUrgent tests that an add which is close to expiry
+// bypasses the pacing queue, and that no add is queued for longer than
+// MaxAddDelay.
+func TestChannelLinkPacingUrgent(t *testing.T) {
+	t.Parallel()
+
+	const (
+		chanAmt     = btcutil.SatoshiPerBitcoin * 5
+		maxAddDelay = 500 * time.Millisecond
+	)
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := setPacingPolicy(link, PacingPolicy{
+		AddInterval: time.Hour,
+		MaxAddDelay: maxAddDelay,
+	})
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// The first add is sent right away, while the second is queued behind
+	// it, as the add interval is far in the future.
+	start := time.Now()
+	sendPacedAdd(t, aliceLink, 1000)
+	sendPacedAdd(t, aliceLink, 1001)
+
+	msg, _ := receivePacedMsg(t, aliceMsgs)
+	add, ok := msg.(*lnwire.UpdateAddHTLC)
+	if !ok || add.Expiry != 1000 {
+		t.Fatalf("expected first add to be sent, got %v", msg)
+	}
+
+	// An add which expires within the urgent delta should jump the queue.
+	sendPacedAdd(t, aliceLink, DefaultPacingUrgentDelta)
+
+	msg, _ = receivePacedMsg(t, aliceMsgs)
+	add, ok = msg.(*lnwire.UpdateAddHTLC)
+	if !ok || add.Expiry != DefaultPacingUrgentDelta {
+		t.Fatalf("expected urgent add to be sent, got %v", msg)
+	}
+
+	// The queued add should be sent once it reaches its deadline, despite
+	// the add interval not having elapsed.
+	msg, sent := receivePacedMsg(t, aliceMsgs)
+	add, ok = msg.(*lnwire.UpdateAddHTLC)
+	if !ok || add.Expiry != 1001 {
+		t.Fatalf("expected queued add to be sent, got %v", msg)
+	}
+	if delay := sent.Sub(start); delay < maxAddDelay*2/3 {
+		t.Fatalf("queued add sent too early: %v", delay)
+	}
+}
@@ -30,6 +30,21 @@ type htlcPacket struct {
 	// amount is the value of the HTLC that is being created or modified.
 	amount lnwire.MilliSatoshi
 
+	// incomingAmount is the value of the incoming HTLC of a forwarded add.
+	incomingAmount lnwire.MilliSatoshi
+
+	// incomingTimeout is the expiry height of the incoming HTLC of a
+	// forwarded add.
+	incomingTimeout uint32
+
+	// requiredFee is the fee our forwarding policy required a forwarded
+	// add to pay at the time it was accepted by the incoming link.
+	requiredFee lnwire.MilliSatoshi
+
+	// correlationID is the locally assigned ID correlating the decisions
+	// and trace of a forwarded add.
+	correlationID uint64
+
 	// htlc lnwire message type of which depends on switch request type.
 	htlc lnwire.Message
 
@@ -53,4 +68,15 @@ type htlcPacket struct {
 	// encrypt all errors related to this packet as if we were the first
 	// hop.
 	isResolution bool
+
+	// isReplay is set to true if this settle/fail packet was replayed
+	// from the switch's resolution store after a restart. As the
+	// incoming link may have already committed to it prior to the
+	// restart, it must be handled idempotently.
+	isReplay bool
+
+	// jittered is set to true once the incoming link has drawn the jitter
+	// to apply to this settle/fail packet, such that it isn't delayed
+	// again once the jitter elapses.
+	jittered bool
 }
@@ -0,0 +1,155 @@
+package htlcswitch
+
+import (
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// ForwardOutcomes counts the outcomes of the HTLC's we've attempted to forward
+// over one or more links.
+type ForwardOutcomes struct {
+	// Attempts is the number of HTLC's received over another channel
+	// which we've attempted to forward. This includes those which have
+	// yet to be resolved.
+	Attempts uint64
+
+	// Settles is the number of forwarded HTLC's settled by the downstream
+	// peer.
+	Settles uint64
+
+	// RelayedFailures is the number of forwarded HTLC's failed by the
+	// downstream peer, or further along the route.
+	RelayedFailures uint64
+
+	// LocalFailures is the number of HTLC's we failed ourselves rather
+	// than forwarding them to the downstream peer, keyed by the failure
+	// code they were failed with.
+	LocalFailures map[lnwire.FailCode]uint64
+}
+
+// NumLocalFailures returns the total number of HTLC's we failed ourselves.
+func (f *ForwardOutcomes) NumLocalFailures() uint64 {
+	var total uint64
+	for _, count := range f.LocalFailures {
+		total += count
+	}
+
+	return total
+}
+
+// Failures returns the total number of failed forwards, whether they were
+// failed by ourselves or relayed back from the downstream peer.
+func (f *ForwardOutcomes) Failures() uint64 {
+	return f.NumLocalFailures() + f.RelayedFailures
+}
+
+// SuccessRate returns the fraction of resolved forwards which were settled,
+// within the range [0, 1]. Forwards which have yet to be resolved aren't
+// included. Zero is returned if no forwards have been resolved.
+func (f *ForwardOutcomes) SuccessRate() float64 {
+	resolved := f.Settles + f.Failures()
+	if resolved == 0 {
+		return 0
+	}
+
+	return float64(f.Settles) / float64(resolved)
+}
+
+// add adds the passed outcomes to the receiver.
+func (f *ForwardOutcomes) add(other *ForwardOutcomes) {
+	f.Attempts += other.Attempts
+	f.Settles += other.Settles
+	f.RelayedFailures += other.RelayedFailures
+
+	for code, count := range other.LocalFailures {
+		if f.LocalFailures == nil {
+			f.LocalFailures = make(map[lnwire.FailCode]uint64)
+		}
+		f.LocalFailures[code] += count
+	}
+}
+
+// forwardOutcomes counts the outcomes of the HTLC's forwarded over a link.
+type forwardOutcomes struct {
+	sync.Mutex
+
+	outcomes ForwardOutcomes
+}
+
+// sent records that a forwarded HTLC has been sent to the downstream peer.
+func (f *forwardOutcomes) sent() {
+	f.Lock()
+	f.outcomes.Attempts++
+	f.Unlock()
+}
+
+// localFailure records that we've failed an HTLC we attempted to forward with
+// the passed failure code, rather than sending it to the downstream peer.
+func (f *forwardOutcomes) localFailure(code lnwire.FailCode) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.outcomes.LocalFailures == nil {
+		f.outcomes.LocalFailures = make(map[lnwire.FailCode]uint64)
+	}
+	f.outcomes.Attempts++
+	f.outcomes.LocalFailures[code]++
+}
+
+// resolved records that the downstream peer has settled or failed a
+// forwarded HTLC.
+func (f *forwardOutcomes) resolved(settled bool) {
+	f.Lock()
+	defer f.Unlock()
+
+	if settled {
+		f.outcomes.Settles++
+	} else {
+		f.outcomes.RelayedFailures++
+	}
+}
+
+// snapshot returns a copy of the outcomes counted so far.
+func (f *forwardOutcomes) snapshot() ForwardOutcomes {
+	f.Lock()
+	defer f.Unlock()
+
+	var outcomes ForwardOutcomes
+	outcomes.add(&f.outcomes)
+
+	return outcomes
+}
+
+// PeerForwardingStats summarises the outcomes of the HTLC's forwarded to a
+// peer, summed across all of our current links with it.
+type PeerForwardingStats struct {
+	// NumLinks is the number of links the outcomes were summed across.
+	NumLinks int
+
+	ForwardOutcomes
+}
+
+// PeerForwardingStats returns the outcomes of the HTLC's forwarded to the
+// peer identified by the serialized compressed form of its public key,
+// summed across all current links with it over their lifetimes. As the
+// outcomes are counted by each link, those of links which have since been
+// removed aren't included.
+func (s *Switch) PeerForwardingStats(peer [33]byte) (*PeerForwardingStats,
+	error) {
+
+	links, err := s.GetLinksByInterface(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &PeerForwardingStats{
+		NumLinks: len(links),
+	}
+	for _, link := range links {
+		outcomes := link.StatsDetail().ForwardOutcomes
+		stats.add(&outcomes)
+	}
+
+	return stats, nil
+}
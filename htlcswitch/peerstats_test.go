@@ -0,0 +1,111 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchPeerForwardingStats tests that the outcomes of the HTLC's Bob
+// forwards to Carol are counted, telling apart failures relayed back by Carol
+// from those Bob generated himself.
+func TestSwitchPeerForwardingStats(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	bobSwitch := n.bobServer.htlcSwitch
+
+	var unknownPeer [33]byte
+	if _, err := bobSwitch.PeerForwardingStats(unknownPeer); err == nil {
+		t.Fatalf("expected error for unknown peer")
+	}
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	pay := func(hops []ForwardingInfo, htlcAmt lnwire.MilliSatoshi,
+		timelock uint32) error {
+
+		_, err := n.makePayment(n.aliceServer, n.carolServer,
+			n.bobServer.PubKey(), hops, amount, htlcAmt,
+			timelock).Wait(30 * time.Second)
+		return err
+	}
+
+	// The first payment is settled by Carol.
+	htlcAmt, totalTimelock, hops := generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+	if err := pay(hops, htlcAmt, totalTimelock); err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	// The second is failed by Carol, as the amount within her payload
+	// doesn't match that of the HTLC.
+	htlcAmt, totalTimelock, hops = generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+	hops[1].AmountToForward = 1
+	if err := pay(hops, htlcAmt, totalTimelock); err == nil {
+		t.Fatalf("payment should have failed")
+	}
+
+	// The third is failed by Bob himself, as his link with Carol won't
+	// carry any more value in flight.
+	n.secondBobChannelLink.UpdateForwardingPolicy(ForwardingPolicy{
+		MaxValueInFlight: 1,
+	})
+	htlcAmt, totalTimelock, hops = generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+	if err := pay(hops, htlcAmt, totalTimelock); err == nil {
+		t.Fatalf("payment should have failed")
+	}
+
+	stats, err := bobSwitch.PeerForwardingStats(n.carolServer.PubKey())
+	if err != nil {
+		t.Fatalf("unable to fetch forwarding stats: %v", err)
+	}
+	if stats.NumLinks != 1 {
+		t.Fatalf("expected 1 link, got %v", stats.NumLinks)
+	}
+	if stats.Attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %v", stats.Attempts)
+	}
+	if stats.Settles != 1 {
+		t.Fatalf("expected 1 settle, got %v", stats.Settles)
+	}
+	if stats.RelayedFailures != 1 {
+		t.Fatalf("expected 1 relayed failure, got %v",
+			stats.RelayedFailures)
+	}
+	code := lnwire.CodeTemporaryChannelFailure
+	if stats.NumLocalFailures() != 1 || stats.LocalFailures[code] != 1 {
+		t.Fatalf("expected 1 local failure with code %v, got %v",
+			code, stats.LocalFailures)
+	}
+	if rate := stats.SuccessRate(); rate != 1.0/3 {
+		t.Fatalf("expected success rate of 1/3, got %v", rate)
+	}
+
+	// Bob hasn't forwarded anything to Alice.
+	stats, err = bobSwitch.PeerForwardingStats(n.aliceServer.PubKey())
+	if err != nil {
+		t.Fatalf("unable to fetch forwarding stats: %v", err)
+	}
+	if stats.Attempts != 0 || stats.SuccessRate() != 0 {
+		t.Fatalf("expected no forwards to alice, got %v attempts",
+			stats.Attempts)
+	}
+}
@@ -0,0 +1,208 @@
+package htlcswitch
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// DefaultPluginTimeout is the default time each forward policy plugin is
+// given to decide upon a forward. As plugins are consulted from within the
+// switch's main event loop, it's kept short.
+const DefaultPluginTimeout = 100 * time.Millisecond
+
+// PluginAction is the action a ForwardPolicyPlugin decides upon for a
+// forward.
+type PluginAction uint8
+
+const (
+	// PluginAccept lets the forward proceed.
+	PluginAccept PluginAction = iota
+
+	// PluginFail fails the forward back with the failure code of the
+	// decision.
+	PluginFail
+)
+
+// String returns a human readable representation of the PluginAction.
+func (a PluginAction) String() string {
+	switch a {
+	case PluginAccept:
+		return "accept"
+	case PluginFail:
+		return "fail"
+	default:
+		return "unknown"
+	}
+}
+
+// PluginDecision is returned by a ForwardPolicyPlugin to accept or fail a
+// forward.
+type PluginDecision struct {
+	// Action is the action to take.
+	Action PluginAction
+
+	// FailCode is the failure code the forward is failed back with if
+	// Action is PluginFail. Only failures without any further data are
+	// supported: temporary or permanent channel and node failures,
+	// missing required channel or node features, and an unknown next
+	// peer. Any other code is replaced with a temporary channel failure.
+	FailCode lnwire.FailCode
+}
+
+// ForwardRequest describes a forward to be decided upon by a
+// ForwardPolicyPlugin.
+type ForwardRequest struct {
+	// IncomingChanID is the channel over which the HTLC was received.
+	IncomingChanID lnwire.ShortChannelID
+
+	// IncomingHTLCID is the ID of the HTLC within the incoming channel.
+	IncomingHTLCID uint64
+
+	// OutgoingChanID is the channel requested by the onion for the next
+	// hop.
+	OutgoingChanID lnwire.ShortChannelID
+
+	// PaymentHash is the payment hash of the HTLC.
+	PaymentHash [32]byte
+
+	// IncomingAmount is the amount of the incoming HTLC.
+	IncomingAmount lnwire.MilliSatoshi
+
+	// OutgoingAmount is the amount to be forwarded to the next hop.
+	OutgoingAmount lnwire.MilliSatoshi
+
+	// IncomingExpiry is the expiry height of the incoming HTLC.
+	IncomingExpiry uint32
+
+	// OutgoingExpiry is the expiry height of the outgoing HTLC.
+	OutgoingExpiry uint32
+}
+
+// ForwardPolicyPlugin is an operator-defined policy consulted by the switch
+// for each forward which has passed its built-in checks. A plugin may only
+// accept or fail a forward, and can't alter it.
+type ForwardPolicyPlugin interface {
+	// Name returns a human readable name of the plugin, used for logging.
+	Name() string
+
+	// Decide decides whether the passed forward is accepted or failed
+	// back. The passed context is cancelled once the plugin's time to
+	// decide has elapsed, after which its decision is disregarded.
+	Decide(ctx context.Context, req *ForwardRequest) PluginDecision
+}
+
+// pluginFailure returns the failure message for the passed failure code of a
+// plugin decision.
+func pluginFailure(code lnwire.FailCode) lnwire.FailureMessage {
+	switch code {
+	case lnwire.CodePermanentChannelFailure:
+		return &lnwire.FailPermanentChannelFailure{}
+	case lnwire.CodeRequiredChannelFeatureMissing:
+		return &lnwire.FailRequiredChannelFeatureMissing{}
+	case lnwire.CodeUnknownNextPeer:
+		return &lnwire.FailUnknownNextPeer{}
+	case lnwire.CodeTemporaryNodeFailure:
+		return &lnwire.FailTemporaryNodeFailure{}
+	case lnwire.CodePermanentNodeFailure:
+		return &lnwire.FailPermanentNodeFailure{}
+	case lnwire.CodeRequiredNodeFeatureMissing:
+		return &lnwire.FailRequiredNodeFeatureMissing{}
+	default:
+		return lnwire.NewTemporaryChannelFailure(nil)
+	}
+}
+
+// pluginTimeout returns the time each plugin is given to decide.
+func (s *Switch) pluginTimeout() time.Duration {
+	if s.cfg.PluginTimeout == 0 {
+		return DefaultPluginTimeout
+	}
+
+	return s.cfg.PluginTimeout
+}
+
+// runPlugin consults the passed plugin, isolating the switch from it. If the
+// plugin panics, or fails to decide within the plugin timeout, then the
+// configured PluginFallback is returned instead.
+func (s *Switch) runPlugin(plugin ForwardPolicyPlugin,
+	req *ForwardRequest) PluginDecision {
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), s.pluginTimeout(),
+	)
+	defer cancel()
+
+	// The decision channel is buffered, such that a plugin returning after
+	// its timeout doesn't leak the goroutine.
+	decisionChan := make(chan PluginDecision, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("Forward policy plugin %v panicked: "+
+					"%v", plugin.Name(), r)
+				decisionChan <- s.cfg.PluginFallback
+			}
+		}()
+
+		decisionChan <- plugin.Decide(ctx, req)
+	}()
+
+	select {
+	case decision := <-decisionChan:
+		return decision
+
+	case <-ctx.Done():
+		log.Errorf("Forward policy plugin %v timed out deciding on "+
+			"htlc=%v from %v", plugin.Name(), req.IncomingHTLCID,
+			req.IncomingChanID)
+		return s.cfg.PluginFallback
+
+	case <-s.quit:
+		return s.cfg.PluginFallback
+	}
+}
+
+// consultPlugins hands the passed forward to each of the ForwardPolicyPlugins
+// in turn, failing it back to the source link as soon as one of them decides
+// to fail it. If true is returned, then the forward has been failed, and must
+// not be forwarded.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) consultPlugins(source ChannelLink,
+	packet *htlcPacket) (bool, error) {
+
+	if len(s.cfg.ForwardPolicyPlugins) == 0 {
+		return false, nil
+	}
+
+	htlc := packet.htlc.(*lnwire.UpdateAddHTLC)
+	req := &ForwardRequest{
+		IncomingChanID: packet.incomingChanID,
+		IncomingHTLCID: packet.incomingHTLCID,
+		OutgoingChanID: packet.outgoingChanID,
+		PaymentHash:    htlc.PaymentHash,
+		IncomingAmount: packet.incomingAmount,
+		OutgoingAmount: htlc.Amount,
+		IncomingExpiry: packet.incomingTimeout,
+		OutgoingExpiry: htlc.Expiry,
+	}
+
+	for _, plugin := range s.cfg.ForwardPolicyPlugins {
+		decision := s.runPlugin(plugin, req)
+		if decision.Action == PluginAccept {
+			continue
+		}
+
+		failure := pluginFailure(decision.FailCode)
+		return true, s.failForwardWith(source, packet, failure,
+			errors.Errorf("forward of htlc=%v from %v failed by "+
+				"plugin %v with %v", packet.incomingHTLCID,
+				packet.incomingChanID, plugin.Name(),
+				failure.Code()))
+	}
+
+	return false, nil
+}
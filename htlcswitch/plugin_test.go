@@ -0,0 +1,167 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// maxAmountPlugin is an example forward policy plugin which fails forwards
+// above a maximum amount with a permanent channel failure.
+type maxAmountPlugin struct {
+	maxAmount lnwire.MilliSatoshi
+}
+
+func (p *maxAmountPlugin) Name() string {
+	return "max-amount"
+}
+
+func (p *maxAmountPlugin) Decide(_ context.Context,
+	req *ForwardRequest) PluginDecision {
+
+	if req.OutgoingAmount > p.maxAmount {
+		return PluginDecision{
+			Action:   PluginFail,
+			FailCode: lnwire.CodePermanentChannelFailure,
+		}
+	}
+
+	return PluginDecision{Action: PluginAccept}
+}
+
+// misbehavingPlugin is a forward policy plugin which panics for some amounts,
+// and blocks until its context is cancelled for others.
+type misbehavingPlugin struct {
+	panicAmount lnwire.MilliSatoshi
+	slowAmount  lnwire.MilliSatoshi
+}
+
+func (p *misbehavingPlugin) Name() string {
+	return "misbehaving"
+}
+
+func (p *misbehavingPlugin) Decide(ctx context.Context,
+	req *ForwardRequest) PluginDecision {
+
+	switch req.OutgoingAmount {
+	case p.panicAmount:
+		panic("misbehaving plugin")
+
+	case p.slowAmount:
+		<-ctx.Done()
+	}
+
+	return PluginDecision{Action: PluginAccept}
+}
+
+// TestSwitchForwardPolicyPlugins tests that forwards are failed back with the
+// failure code of the first plugin deciding to fail them, and that plugins
+// which panic or time out are isolated from the switch, with the configured
+// fallback being applied.
+func TestSwitchForwardPolicyPlugins(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{
+		ForwardPolicyPlugins: []ForwardPolicyPlugin{
+			&maxAmountPlugin{maxAmount: 1000},
+			&misbehavingPlugin{panicAmount: 10, slowAmount: 20},
+		},
+		PluginTimeout: 50 * time.Millisecond,
+		PluginFallback: PluginDecision{
+			Action:   PluginFail,
+			FailCode: lnwire.CodeTemporaryNodeFailure,
+		},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	// forward sends an HTLC of the passed amount from Alice to Bob, and
+	// returns the failure code it was failed back with, or CodeNone if it
+	// reached Bob.
+	forward := func(htlcID uint64,
+		amt lnwire.MilliSatoshi) lnwire.FailCode {
+
+		s.forward(&htlcPacket{
+			incomingChanID: aliceChanID,
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      amt,
+			},
+		})
+
+		select {
+		case <-bobChannelLink.packets:
+			return lnwire.CodeNone
+
+		case pkt := <-aliceChannelLink.packets:
+			fail, ok := pkt.htlc.(*lnwire.UpdateFailHTLC)
+			if !ok {
+				t.Fatalf("expected fail, got %T", pkt.htlc)
+			}
+			failure, err := lnwire.DecodeFailure(
+				bytes.NewReader(fail.Reason), 0,
+			)
+			if err != nil {
+				t.Fatalf("unable to decode failure: %v", err)
+			}
+			return failure.Code()
+
+		case <-time.After(time.Second):
+			t.Fatalf("htlc %v was neither forwarded nor failed",
+				htlcID)
+		}
+
+		return lnwire.CodeNone
+	}
+
+	if code := forward(0, 500); code != lnwire.CodeNone {
+		t.Fatalf("expected forward to be accepted, got %v", code)
+	}
+
+	code := forward(1, 1500)
+	if code != lnwire.CodePermanentChannelFailure {
+		t.Fatalf("expected permanent channel failure, got %v", code)
+	}
+
+	// Both a panicking and a slow plugin result in the fallback decision.
+	if code := forward(2, 10); code != lnwire.CodeTemporaryNodeFailure {
+		t.Fatalf("expected fallback for panicking plugin, got %v",
+			code)
+	}
+	if code := forward(3, 20); code != lnwire.CodeTemporaryNodeFailure {
+		t.Fatalf("expected fallback for slow plugin, got %v", code)
+	}
+
+	// The switch remains operational afterwards.
+	if code := forward(4, 30); code != lnwire.CodeNone {
+		t.Fatalf("expected forward to be accepted, got %v", code)
+	}
+}
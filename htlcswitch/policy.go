@@ -0,0 +1,147 @@
+package htlcswitch
+
+import (
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// InboundFee is a fee charged, in addition to the outbound fee of the
+// forwarding policy, on HTLC's which arrive over a link to be forwarded. Both
+// components may be negative, in which case the inbound fee acts as a
+// discount on the outbound fee, allowing an operator to incentivize flow into
+// a particular channel.
+type InboundFee struct {
+	// BaseFee is the base fee, expressed in milli-satoshi, that's added
+	// to the fee of each forwarded HTLC.
+	BaseFee int64
+
+	// FeeRate is the fee rate, expressed in millionths of the forwarded
+	// amount, that's added to the fee of each forwarded HTLC.
+	FeeRate int64
+}
+
+// fee computes the inbound fee for an HTLC forwarding the passed amount. The
+// returned fee is negative if the inbound fee is a discount.
+func (f InboundFee) fee(amt lnwire.MilliSatoshi) int64 {
+	return f.BaseFee + (int64(amt)*f.FeeRate)/1000000
+}
+
+// DirectionalPolicy bundles the forwarding policy a link applies to forwarded
+// HTLC's with the inbound fee applied to HTLC's arriving over it.
+type DirectionalPolicy struct {
+	// Outbound is the forwarding policy of the link. As with
+	// UpdateForwardingPolicy, uninitialized fields don't override the
+	// link's current values.
+	Outbound ForwardingPolicy
+
+	// Inbound is the inbound fee of the link. If nil, then the link's
+	// current inbound fee is left unchanged.
+	Inbound *InboundFee
+
+	// Role is the role of the link's channel. If nil, then the link's
+	// current role is left unchanged.
+	Role *ChannelRole
+
+	// DisableProbeFee, if true, clears the link's ProbeThreshold and
+	// ProbePolicy, such that no HTLC's are treated as probes. As a zero
+	// ProbeThreshold within Outbound leaves the current one unchanged,
+	// this is the only way to stop charging the probe fee.
+	DisableProbeFee bool
+
+	// Gossiped should be true if the caller has already gossiped a
+	// channel update carrying the new policy, such as when the policy is
+	// updated via RPC. The link then records the new policy as the last
+	// gossiped one, rather than gossiping it again once it drifts.
+	Gossiped bool
+}
+
+// validate ensures that the inbound fee of the policy can't reduce the total
+// fee of a forward below zero, which would render it unprofitable. This holds
+// as long as neither component of the inbound discount exceeds the
+// corresponding component of the outbound fee. The enforced time-lock delta
+// must also not exceed the advertised one.
+func (p *DirectionalPolicy) validate() error {
+	if p.Role != nil && *p.Role > ChannelRoleReceiveOnly {
+		return fmt.Errorf("unknown channel role %v", uint8(*p.Role))
+	}
+
+	if p.Outbound.EnforcedTimeLockDelta > p.Outbound.TimeLockDelta {
+		return fmt.Errorf("enforced time-lock delta of %v exceeds "+
+			"advertised time-lock delta of %v",
+			p.Outbound.EnforcedTimeLockDelta,
+			p.Outbound.TimeLockDelta)
+	}
+
+	if p.Inbound == nil {
+		return nil
+	}
+
+	if p.Inbound.BaseFee < -int64(p.Outbound.BaseFee) {
+		return fmt.Errorf("inbound base fee discount of %v msat "+
+			"exceeds outbound base fee of %v", -p.Inbound.BaseFee,
+			p.Outbound.BaseFee)
+	}
+	if p.Inbound.FeeRate < -int64(p.Outbound.FeeRate) {
+		return fmt.Errorf("inbound fee rate discount of %v ppm "+
+			"exceeds outbound fee rate of %v", -p.Inbound.FeeRate,
+			int64(p.Outbound.FeeRate))
+	}
+
+	return nil
+}
+
+// expectedForwardFee computes the total fee that an HTLC forwarding the passed
+// amount must carry under the policy, taking into account both the outbound
+// and inbound fees. Probes are only charged the fee of the probe policy.
+func (p *DirectionalPolicy) expectedForwardFee(
+	amt lnwire.MilliSatoshi) lnwire.MilliSatoshi {
+
+	fee := int64(ExpectedFee(p.Outbound, amt))
+	if p.Inbound != nil && !p.Outbound.isProbe(amt) {
+		fee += p.Inbound.fee(amt)
+	}
+
+	// A validated policy can't produce a negative fee, but we'll guard
+	// against it regardless.
+	if fee < 0 {
+		return 0
+	}
+
+	return lnwire.MilliSatoshi(fee)
+}
+
+// feeSchedule returns the base fee and fee rate to advertise to the sender of
+// an HTLC forwarding the passed amount, such that a retry paying the fee
+// they imply is accepted under the policy. As a channel update can't convey
+// an inbound fee, it's folded into the outbound one, and the base fee is
+// topped up to cover any rounding in the combined fee.
+func (p *DirectionalPolicy) feeSchedule(
+	amt lnwire.MilliSatoshi) (uint32, uint32) {
+
+	base := int64(p.Outbound.BaseFee)
+	rate := int64(p.Outbound.FeeRate)
+	switch {
+	case p.Outbound.isProbe(amt):
+		base = int64(p.Outbound.ProbePolicy.BaseFee)
+		rate = int64(p.Outbound.ProbePolicy.FeeRate)
+
+	case p.Inbound != nil:
+		base += p.Inbound.BaseFee
+		rate += p.Inbound.FeeRate
+	}
+
+	if base < 0 {
+		base = 0
+	}
+	if rate < 0 {
+		rate = 0
+	}
+
+	required := int64(p.expectedForwardFee(amt))
+	if charged := base + (int64(amt)*rate)/1000000; charged < required {
+		base += required - charged
+	}
+
+	return uint32(base), uint32(rate)
+}
@@ -0,0 +1,216 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestDirectionalPolicyValidate ensures that inbound discounts exceeding the
+// outbound fee of a policy are rejected, while surcharges are always allowed.
+func TestDirectionalPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	outbound := ForwardingPolicy{
+		BaseFee: 1000,
+		FeeRate: 100,
+	}
+
+	tests := []struct {
+		name    string
+		inbound *InboundFee
+		valid   bool
+	}{
+		{
+			name:  "no inbound fee",
+			valid: true,
+		},
+		{
+			name:    "surcharge",
+			inbound: &InboundFee{BaseFee: 5000, FeeRate: 500},
+			valid:   true,
+		},
+		{
+			name:    "full discount",
+			inbound: &InboundFee{BaseFee: -1000, FeeRate: -100},
+			valid:   true,
+		},
+		{
+			name:    "excessive base fee discount",
+			inbound: &InboundFee{BaseFee: -1001},
+			valid:   false,
+		},
+		{
+			name:    "excessive fee rate discount",
+			inbound: &InboundFee{FeeRate: -101},
+			valid:   false,
+		},
+	}
+
+	for _, test := range tests {
+		policy := DirectionalPolicy{
+			Outbound: outbound,
+			Inbound:  test.inbound,
+		}
+		err := policy.validate()
+		if test.valid && err != nil {
+			t.Fatalf("%v: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%v: expected policy to be rejected", test.name)
+		}
+	}
+}
+
+// TestDirectionalPolicyExpectedFee ensures that the inbound fee of a policy is
+// added to, or discounted from, the outbound fee of a forward.
+func TestDirectionalPolicyExpectedFee(t *testing.T) {
+	t.Parallel()
+
+	amt := lnwire.MilliSatoshi(1000000)
+	outbound := ForwardingPolicy{
+		BaseFee: 1000,
+		FeeRate: 100,
+	}
+
+	tests := []struct {
+		name    string
+		inbound *InboundFee
+		fee     lnwire.MilliSatoshi
+	}{
+		{
+			name: "no inbound fee",
+			fee:  1100,
+		},
+		{
+			name:    "surcharge",
+			inbound: &InboundFee{BaseFee: 500, FeeRate: 10},
+			fee:     1610,
+		},
+		{
+			name:    "discount",
+			inbound: &InboundFee{BaseFee: -500, FeeRate: -50},
+			fee:     550,
+		},
+		{
+			name:    "full discount",
+			inbound: &InboundFee{BaseFee: -1000, FeeRate: -100},
+			fee:     0,
+		},
+	}
+
+	for _, test := range tests {
+		policy := DirectionalPolicy{
+			Outbound: outbound,
+			Inbound:  test.inbound,
+		}
+		fee := policy.expectedForwardFee(amt)
+		if fee != test.fee {
+			t.Fatalf("%v: expected fee %v, got %v", test.name,
+				test.fee, fee)
+		}
+	}
+}
+
+// TestExpectedFeeProbe ensures that HTLC's forwarding less than the probe
+// threshold of a policy are charged the fee of its probe policy, without any
+// inbound fee, while larger HTLC's are charged the regular fee.
+func TestExpectedFeeProbe(t *testing.T) {
+	t.Parallel()
+
+	policy := DirectionalPolicy{
+		Outbound: ForwardingPolicy{
+			BaseFee:        1000,
+			FeeRate:        100,
+			ProbeThreshold: 10000,
+			ProbePolicy: ProbeFeePolicy{
+				BaseFee: 1,
+			},
+		},
+		Inbound: &InboundFee{BaseFee: 500},
+	}
+
+	tests := []struct {
+		amt lnwire.MilliSatoshi
+		fee lnwire.MilliSatoshi
+	}{
+		{amt: 9999, fee: 1},
+		{amt: 10000, fee: 1501},
+		{amt: 1000000, fee: 1600},
+	}
+
+	for _, test := range tests {
+		fee := policy.expectedForwardFee(test.amt)
+		if fee != test.fee {
+			t.Fatalf("amt=%v: expected fee %v, got %v", test.amt,
+				test.fee, fee)
+		}
+	}
+
+	// Without a threshold, no HTLC's should be treated as probes.
+	policy.Outbound.ProbeThreshold = 0
+	if fee := ExpectedFee(policy.Outbound, 9999); fee != 1000 {
+		t.Fatalf("expected fee 1000, got %v", fee)
+	}
+}
+
+// TestForwardingPolicyEnforcedTimeLockDelta ensures that policies enforcing a
+// time-lock delta above the advertised one are rejected, and that the
+// advertised delta is enforced unless a valid enforced delta is set.
+func TestForwardingPolicyEnforcedTimeLockDelta(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		enforced uint32
+		valid    bool
+		expected uint32
+	}{
+		{
+			name:     "advertised delta by default",
+			enforced: 0,
+			valid:    true,
+			expected: 40,
+		},
+		{
+			name:     "looser enforced delta",
+			enforced: 30,
+			valid:    true,
+			expected: 30,
+		},
+		{
+			name:     "equal enforced delta",
+			enforced: 40,
+			valid:    true,
+			expected: 40,
+		},
+		{
+			name:     "stricter enforced delta",
+			enforced: 41,
+			valid:    false,
+			expected: 40,
+		},
+	}
+
+	for _, test := range tests {
+		policy := DirectionalPolicy{
+			Outbound: ForwardingPolicy{
+				TimeLockDelta:         40,
+				EnforcedTimeLockDelta: test.enforced,
+			},
+		}
+		err := policy.validate()
+		if test.valid && err != nil {
+			t.Fatalf("%v: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%v: expected policy to be rejected", test.name)
+		}
+
+		delta := policy.Outbound.enforcedTimeLockDelta()
+		if delta != test.expected {
+			t.Fatalf("%v: expected enforced delta %v, got %v",
+				test.name, test.expected, delta)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package htlcswitch
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// PolicyDriftPolicy governs when a link gossips a new channel update once its
+// forwarding policy has drifted from the policy last gossiped for the
+// channel.
+type PolicyDriftPolicy struct {
+	// FeeChangeThreshold is the change, in percent, of either the base fee
+	// or the fee rate relative to the last gossiped policy, past which a
+	// new channel update is gossiped. If zero, then policy changes aren't
+	// gossiped by the link.
+	FeeChangeThreshold float64
+
+	// MinInterval is the minimum duration between two channel updates
+	// gossiped by the link. A drift detected before the interval has
+	// elapsed is gossiped once it has.
+	MinInterval time.Duration
+}
+
+// GossipedPolicy is the forwarding policy last gossiped for a link's channel,
+// along with the time it was gossiped. A zero timestamp denotes the policy the
+// link was started with, which was gossiped before the link was created.
+type GossipedPolicy struct {
+	// Policy is the gossiped forwarding policy.
+	Policy ForwardingPolicy
+
+	// Timestamp is the time the policy was gossiped by the link.
+	Timestamp time.Time
+}
+
+// policyDriftState tracks the policy last gossiped for the link's channel.
+type policyDriftState struct {
+	sync.Mutex
+
+	last GossipedPolicy
+
+	// checkPending is true while a re-check of the drift is scheduled.
+	// This is only accessed from within the htlcManager goroutine.
+	checkPending bool
+}
+
+// policyDriftCheck is sent to a channel link once the minimum interval
+// between gossiped channel updates has elapsed, in order to re-check whether
+// its forwarding policy has drifted.
+type policyDriftCheck struct{}
+
+// feeDrift returns the largest relative change, in percent, of the base fee
+// and fee rate of the passed policies.
+func feeDrift(from, to ForwardingPolicy) float64 {
+	relChange := func(from, to float64) float64 {
+		switch {
+		case from == to:
+			return 0
+		case from == 0:
+			return math.Inf(1)
+		default:
+			return math.Abs(to-from) / from * 100
+		}
+	}
+
+	return math.Max(
+		relChange(float64(from.BaseFee), float64(to.BaseFee)),
+		relChange(float64(from.FeeRate), float64(to.FeeRate)),
+	)
+}
+
+// LastGossipedPolicy returns the forwarding policy last gossiped for the
+// link's channel, such that its drift from the policy currently applied can
+// be inspected.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) LastGossipedPolicy() GossipedPolicy {
+	l.policyDrift.Lock()
+	defer l.policyDrift.Unlock()
+
+	return l.policyDrift.last
+}
+
+// checkPolicyDrift gossips the link's current forwarding policy if it has
+// drifted from the last gossiped policy past the configured threshold. If the
+// minimum interval since the last channel update hasn't yet elapsed, then a
+// re-check is scheduled for once it has. The drift is checked each time a new
+// policy is applied to the link, so a fee policy adjusting the link's fees,
+// e.g. as its balance shifts, acts as the trigger. Policy updates which were
+// already gossiped by the caller, such as those made via RPC, aren't checked.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) checkPolicyDrift() {
+	threshold := l.cfg.PolicyDrift.FeeChangeThreshold
+	if threshold <= 0 || l.cfg.GossipPolicyUpdate == nil {
+		return
+	}
+
+	policy := l.cfg.FwrdingPolicy
+	last := l.LastGossipedPolicy()
+	if feeDrift(last.Policy, policy) < threshold {
+		return
+	}
+
+	if !last.Timestamp.IsZero() {
+		elapsed := time.Since(last.Timestamp)
+		if elapsed < l.cfg.PolicyDrift.MinInterval {
+			if l.policyDrift.checkPending {
+				return
+			}
+			l.policyDrift.checkPending = true
+
+			time.AfterFunc(l.cfg.PolicyDrift.MinInterval-elapsed,
+				func() {
+					select {
+					case l.linkControl <- &policyDriftCheck{}:
+					case <-l.quit:
+					}
+				})
+			return
+		}
+	}
+
+	log.Infof("ChannelLink(%v) forwarding policy drifted from "+
+		"base_fee=%v, fee_rate=%v to base_fee=%v, fee_rate=%v, gossiping "+
+		"channel update", l, last.Policy.BaseFee, last.Policy.FeeRate,
+		policy.BaseFee, policy.FeeRate)
+
+	l.gossipPolicy(policy)
+}
+
+// gossipPolicy gossips a new channel update carrying the passed forwarding
+// policy via GossipPolicyUpdate, recording it as the last gossiped policy.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) gossipPolicy(policy ForwardingPolicy) {
+	l.recordGossipedPolicy(policy)
+
+	// The gossiper may block on the router, so we'll gossip the update
+	// without holding up the link.
+	go func() {
+		if err := l.cfg.GossipPolicyUpdate(policy); err != nil {
+			log.Errorf("ChannelLink(%v) unable to gossip channel "+
+				"update: %v", l, err)
+		}
+	}()
+}
+
+// recordGossipedPolicy records the passed forwarding policy as the policy
+// last gossiped for the link's channel.
+func (l *channelLink) recordGossipedPolicy(policy ForwardingPolicy) {
+	l.policyDrift.Lock()
+	l.policyDrift.last = GossipedPolicy{
+		Policy:    policy,
+		Timestamp: time.Now(),
+	}
+	l.policyDrift.Unlock()
+}
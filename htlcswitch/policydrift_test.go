@@ -0,0 +1,119 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkPolicyDrift tests that a link gossips its forwarding policy
+// once it drifts past the configured threshold, and that drifts within the
+// minimum interval are gossiped only once it has elapsed.
+func TestChannelLinkPolicyDrift(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	startPolicy := aliceLink.cfg.FwrdingPolicy
+
+	const minInterval = 500 * time.Millisecond
+	gossiped := make(chan ForwardingPolicy, 10)
+	aliceLink.cfg.PolicyDrift = PolicyDriftPolicy{
+		FeeChangeThreshold: 10,
+		MinInterval:        minInterval,
+	}
+	aliceLink.cfg.GossipPolicyUpdate = func(policy ForwardingPolicy) error {
+		gossiped <- policy
+		return nil
+	}
+
+	last := aliceLink.LastGossipedPolicy()
+	if last.Policy != startPolicy || !last.Timestamp.IsZero() {
+		t.Fatalf("expected starting policy to be gossiped, got %v",
+			last)
+	}
+
+	updateBaseFee := func(percent lnwire.MilliSatoshi) ForwardingPolicy {
+		policy := aliceLink.cfg.FwrdingPolicy
+		policy.BaseFee = startPolicy.BaseFee * (100 + percent) / 100
+		err := aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+			Outbound: policy,
+		})
+		if err != nil {
+			t.Fatalf("unable to update policy: %v", err)
+		}
+		return policy
+	}
+
+	assertGossiped := func(expected ForwardingPolicy,
+		timeout time.Duration) {
+
+		select {
+		case policy := <-gossiped:
+			if policy != expected {
+				t.Fatalf("expected policy %v to be gossiped, "+
+					"got %v", expected, policy)
+			}
+		case <-time.After(timeout):
+			t.Fatalf("policy %v wasn't gossiped", expected)
+		}
+	}
+
+	assertNotGossiped := func(wait time.Duration) {
+		select {
+		case policy := <-gossiped:
+			t.Fatalf("unexpected policy %v gossiped", policy)
+		case <-time.After(wait):
+		}
+	}
+
+	// A change below the threshold isn't gossiped.
+	updateBaseFee(5)
+	assertNotGossiped(100 * time.Millisecond)
+
+	// Once the threshold is exceeded, the policy is gossiped right away,
+	// as no update has been gossiped by the link yet.
+	policy := updateBaseFee(50)
+	assertGossiped(policy, time.Second)
+
+	last = aliceLink.LastGossipedPolicy()
+	if last.Policy != policy || last.Timestamp.IsZero() {
+		t.Fatalf("expected last gossiped policy %v, got %v", policy,
+			last)
+	}
+
+	// Further drifts within the minimum interval are held back, with
+	// only the latest policy being gossiped once the interval elapses.
+	updateBaseFee(100)
+	policy = updateBaseFee(200)
+	assertNotGossiped(minInterval / 4)
+	assertGossiped(policy, 2*minInterval)
+	assertNotGossiped(minInterval)
+
+	// A policy which was already gossiped by the caller isn't gossiped
+	// again, but is recorded as the last gossiped policy.
+	policy = aliceLink.cfg.FwrdingPolicy
+	policy.BaseFee = startPolicy.BaseFee * 10
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: policy,
+		Gossiped: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+	assertNotGossiped(2 * minInterval)
+
+	last = aliceLink.LastGossipedPolicy()
+	if last.Policy != policy {
+		t.Fatalf("expected last gossiped policy %v, got %v", policy,
+			last)
+	}
+}
@@ -0,0 +1,79 @@
+package htlcswitch
+
+import (
+	"sort"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// PolicyMismatch describes a channel whose live forwarding policy differs
+// from the policy it was expected to have.
+type PolicyMismatch struct {
+	// ShortChanID is the short channel ID of the channel.
+	ShortChanID lnwire.ShortChannelID
+
+	// Expected is the policy the channel was expected to have.
+	Expected ForwardingPolicy
+
+	// Actual is the policy currently applied by the channel's link. It's
+	// nil if the switch doesn't have an active link for the channel.
+	Actual *ForwardingPolicy
+}
+
+// ExportPolicies returns the forwarding policy currently applied by each
+// active link, indexed by its short channel ID. The policies are those last
+// applied to the links, rather than any fee they'd charge for a particular
+// HTLC, so a probe policy is exported as configured, and inbound fees are
+// left out. If the switch is shutting down, then nil is returned.
+func (s *Switch) ExportPolicies() map[lnwire.ShortChannelID]ForwardingPolicy {
+	links, err := s.fetchAllLinks()
+	if err != nil {
+		return nil
+	}
+
+	policies := make(map[lnwire.ShortChannelID]ForwardingPolicy, len(links))
+	for _, link := range links {
+		policy := link.CurrentForwardingPolicy()
+		policies[link.ShortChanID()] = policy.Outbound
+	}
+
+	return policies
+}
+
+// VerifyPolicies compares the forwarding policies currently applied by the
+// active links against the passed baseline, such as a set of policies
+// previously returned by ExportPolicies, returning a mismatch for each
+// channel whose policy differs, or which no longer has an active link. Links
+// without an expected policy are ignored. The mismatches are ordered by short
+// channel ID.
+func (s *Switch) VerifyPolicies(
+	expected map[lnwire.ShortChannelID]ForwardingPolicy) []PolicyMismatch {
+
+	live := s.ExportPolicies()
+
+	var mismatches []PolicyMismatch
+	for chanID, policy := range expected {
+		actual, ok := live[chanID]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, PolicyMismatch{
+				ShortChanID: chanID,
+				Expected:    policy,
+			})
+
+		case actual != policy:
+			mismatches = append(mismatches, PolicyMismatch{
+				ShortChanID: chanID,
+				Expected:    policy,
+				Actual:      &actual,
+			})
+		}
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].ShortChanID.ToUint64() <
+			mismatches[j].ShortChanID.ToUint64()
+	})
+
+	return mismatches
+}
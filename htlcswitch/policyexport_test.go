@@ -0,0 +1,91 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchVerifyPolicies tests that the switch exports the policies of its
+// links, and reports the channels whose live policies have drifted from an
+// exported baseline.
+func TestSwitchVerifyPolicies(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*5,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	htlcSwitch := n.bobServer.htlcSwitch
+	firstChanID := n.firstBobChannelLink.ShortChanID()
+	secondChanID := n.secondBobChannelLink.ShortChanID()
+
+	baseline := htlcSwitch.ExportPolicies()
+	if len(baseline) != 2 {
+		t.Fatalf("expected 2 policies, got %v", len(baseline))
+	}
+	chanIDs := []lnwire.ShortChannelID{firstChanID, secondChanID}
+	for _, chanID := range chanIDs {
+		if baseline[chanID] != n.globalPolicy {
+			t.Fatalf("expected policy %v for %v, got %v",
+				n.globalPolicy, chanID, baseline[chanID])
+		}
+	}
+
+	mismatches := htlcSwitch.VerifyPolicies(baseline)
+	if len(mismatches) != 0 {
+		t.Fatalf("expected no mismatches, got %v", mismatches)
+	}
+
+	// After updating the policy of one of the links, and adding a channel
+	// unknown to the switch to the baseline, both should be reported.
+	newPolicy := n.globalPolicy
+	newPolicy.BaseFee *= 2
+	n.firstBobChannelLink.UpdateForwardingPolicy(newPolicy)
+
+	unknownChanID := lnwire.NewShortChanIDFromInt(0xffffff)
+	baseline[unknownChanID] = n.globalPolicy
+
+	mismatches = htlcSwitch.VerifyPolicies(baseline)
+	if len(mismatches) != 2 {
+		t.Fatalf("expected 2 mismatches, got %v", mismatches)
+	}
+
+	for _, mismatch := range mismatches {
+		switch mismatch.ShortChanID {
+		case firstChanID:
+			actual := mismatch.Actual
+			if actual == nil || *actual != newPolicy {
+				t.Fatalf("expected actual policy %v, got %v",
+					newPolicy, mismatch.Actual)
+			}
+
+		case unknownChanID:
+			if mismatch.Actual != nil {
+				t.Fatalf("expected no actual policy, got %v",
+					mismatch.Actual)
+			}
+
+		default:
+			t.Fatalf("unexpected mismatch for %v",
+				mismatch.ShortChanID)
+		}
+
+		if mismatch.Expected != n.globalPolicy {
+			t.Fatalf("expected policy %v, got %v", n.globalPolicy,
+				mismatch.Expected)
+		}
+	}
+}
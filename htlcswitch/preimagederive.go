@@ -0,0 +1,51 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+// PreimageDeriver derives the preimage of the invoice with the passed payment
+// hash, rather than it being read from the invoice. This allows preimages to
+// be derived deterministically, e.g. via an HMAC keyed with a node secret over
+// an order ID carried within the invoice's memo or receipt, such that they
+// needn't be stored.
+type PreimageDeriver func(hash chainhash.Hash,
+	invoice *channeldb.Invoice) ([32]byte, error)
+
+// exitHopPreimage returns the preimage with which to settle an HTLC paying to
+// the passed invoice. If the link has a PreimageDeriver, then the preimage is
+// derived, and verified against the payment hash, rather than being read from
+// the invoice.
+func (l *channelLink) exitHopPreimage(hash chainhash.Hash,
+	invoice *channeldb.Invoice) ([32]byte, error) {
+
+	if l.cfg.PreimageDeriver == nil {
+		return invoice.Terms.PaymentPreimage, nil
+	}
+
+	preimage, err := l.cfg.PreimageDeriver(hash, invoice)
+	if err != nil {
+		return preimage, fmt.Errorf("unable to derive preimage: %v",
+			err)
+	}
+	if sha256.Sum256(preimage[:]) != [32]byte(hash) {
+		return preimage, fmt.Errorf("derived preimage %x doesn't "+
+			"match payment hash %x", preimage[:], hash[:])
+	}
+
+	// As the derived preimage isn't stored alongside the invoice, we'll
+	// add it to the preimage cache so any contested contracts can be
+	// swept on-chain. This is done before the HTLC is settled, as
+	// otherwise we may be unable to claim it should the channel be
+	// force closed.
+	if err := l.cfg.PreimageCache.AddPreimage(preimage[:]); err != nil {
+		return preimage, fmt.Errorf("unable to add preimage=%x to "+
+			"cache: %v", preimage[:], err)
+	}
+
+	return preimage, nil
+}
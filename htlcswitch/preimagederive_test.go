@@ -0,0 +1,151 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkPreimageDeriver tests that an HTLC paying to an invoice
+// without a stored preimage is settled with the preimage derived from the
+// invoice, and that it's failed back with incorrect payment details if the
+// derived preimage doesn't match its payment hash.
+func TestChannelLinkPreimageDeriver(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+	registry := aliceLink.cfg.Registry.(*mockInvoiceRegistry)
+
+	// Preimages are derived via an HMAC over the order ID carried within
+	// the invoice's memo.
+	secret := []byte("node secret")
+	derive := func(orderID []byte) [32]byte {
+		var preimage [32]byte
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(orderID)
+		copy(preimage[:], mac.Sum(nil))
+		return preimage
+	}
+
+	var derivedHash chainhash.Hash
+	aliceLink.cfg.PreimageDeriver = func(hash chainhash.Hash,
+		invoice *channeldb.Invoice) ([32]byte, error) {
+
+		derivedHash = hash
+		return derive(invoice.Memo), nil
+	}
+
+	// pay sends an HTLC paying to an invoice for the passed order ID with
+	// the passed preimage, and returns the message Alice responds with.
+	pay := func(orderID []byte, preimage [32]byte) lnwire.Message {
+		amount := lnwire.NewMSatFromSatoshis(10000)
+		htlcAmt, totalTimelock, hops := generateHops(
+			amount, testStartingHeight, aliceLink,
+		)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to gen route: %v", err)
+		}
+		invoice, htlc, err := generatePayment(
+			htlcAmt, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+
+		// The invoice is stored without a preimage.
+		htlc.PaymentHash = sha256.Sum256(preimage[:])
+		invoice.Memo = orderID
+		invoice.Terms.PaymentPreimage = [32]byte{}
+
+		registry.Lock()
+		registry.invoices[chainhash.Hash(htlc.PaymentHash)] = *invoice
+		registry.Unlock()
+
+		// As we're not using a link for Bob, the HTLC's index must be
+		// set to the one his channel assigns it.
+		bobIndex, err := bobChannel.AddHTLC(htlc)
+		if err != nil {
+			t.Fatalf("unable to add htlc: %v", err)
+		}
+		htlc.ID = bobIndex
+		aliceLink.HandleChannelUpdate(htlc)
+		err = updateState(batchTick, aliceLink, bobChannel, false)
+		if err != nil {
+			t.Fatalf("unable to update state: %v", err)
+		}
+
+		select {
+		case msg := <-aliceMsgs:
+			if derivedHash != chainhash.Hash(htlc.PaymentHash) {
+				t.Fatalf("preimage derived for hash %v, "+
+					"expected %x", derivedHash,
+					htlc.PaymentHash[:])
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive settle or fail")
+		}
+		return nil
+	}
+
+	// An HTLC paying to the hash of the correctly derived preimage is
+	// settled with it.
+	orderID := []byte("order-1")
+	preimage := derive(orderID)
+	msg := pay(orderID, preimage)
+	fulfill, ok := msg.(*lnwire.UpdateFulfillHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateFulfillHTLC, got %T", msg)
+	}
+	if fulfill.PaymentPreimage != preimage {
+		t.Fatalf("expected preimage %x, got %x", preimage[:],
+			fulfill.PaymentPreimage[:])
+	}
+
+	// The derived preimage should've been added to the preimage cache
+	// before the HTLC was settled.
+	hash := sha256.Sum256(preimage[:])
+	_, ok = aliceLink.cfg.PreimageCache.LookupPreimage(hash[:])
+	if !ok {
+		t.Fatalf("derived preimage wasn't added to the cache")
+	}
+	err = bobChannel.ReceiveHTLCSettle(preimage, fulfill.ID)
+	if err != nil {
+		t.Fatalf("unable to receive settle: %v", err)
+	}
+	if err := handleStateUpdate(aliceLink, bobChannel); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	// An HTLC paying to a hash which doesn't match the derived preimage
+	// is failed back.
+	msg = pay([]byte("order-2"), derive([]byte("order-3")))
+	fail, ok := msg.(*lnwire.UpdateFailHTLC)
+	if !ok {
+		t.Fatalf("expected UpdateFailHTLC, got %T", msg)
+	}
+	failure, err := lnwire.DecodeFailure(bytes.NewReader(fail.Reason), 0)
+	if err != nil {
+		t.Fatalf("unable to decode failure: %v", err)
+	}
+	if _, ok := failure.(*lnwire.FailUnknownPaymentHash); !ok {
+		t.Fatalf("expected FailUnknownPaymentHash, got %T", failure)
+	}
+}
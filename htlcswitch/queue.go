@@ -3,6 +3,7 @@ package htlcswitch
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lightningnetwork/lnd/lnwire"
 )
@@ -17,7 +18,7 @@ import (
 // to signal the number of slots available, and a condition variable to allow
 // the packetQueue to know when new items have been added to the queue.
 type packetQueue struct {
-	queue []*htlcPacket
+	queue []*queuedPacket
 
 	wg sync.WaitGroup
 
@@ -33,6 +34,23 @@ type packetQueue struct {
 	// commitment transaction.
 	outgoingPkts chan *htlcPacket
 
+	// expiredPkts is a channel that the channelLink will receive on in
+	// order to fail back packets which have waited within the queue past
+	// their deadline.
+	expiredPkts chan *htlcPacket
+
+	// nextDeadline is the earliest deadline of all packets currently
+	// residing within the queue, or the zero time if none of them have
+	// one. This value should only be accessed with the queueMtx held.
+	nextDeadline time.Time
+
+	// numParked and numExpired are the number of packets which have been
+	// added to the queue, and the number of those which have been removed
+	// from it due to their deadline passing. These values should only be
+	// read or modified *atomically*.
+	numParked  uint64
+	numExpired uint64
+
 	// totalHtlcAmt is the sum of the value of all pending HTLC's currently
 	// residing within the overflow queue. This value should only read or
 	// modified *atomically*.
@@ -47,6 +65,19 @@ type packetQueue struct {
 	// deadlock situation where the main goroutine is attempting a send
 	// with the lock held.
 	queueLen int32
+
+	// waitTimes records the amount of time each packet spent within the
+	// queue before being handed off to the channelLink, or expiring.
+	waitTimes *waitTimeRecorder
+}
+
+// queuedPacket is a packet residing within the packetQueue, along with the
+// time it was added to the queue, and the deadline by which it must be handed
+// off to the channelLink, if any.
+type queuedPacket struct {
+	pkt      *htlcPacket
+	addedAt  time.Time
+	deadline time.Time
 }
 
 // newPacketQueue returns a new instance of the packetQueue. The maxFreeSlots
@@ -55,8 +86,10 @@ type packetQueue struct {
 func newPacketQueue(maxFreeSlots int) *packetQueue {
 	p := &packetQueue{
 		outgoingPkts: make(chan *htlcPacket),
+		expiredPkts:  make(chan *htlcPacket),
 		freeSlots:    make(chan struct{}, maxFreeSlots),
 		quit:         make(chan struct{}),
+		waitTimes:    newWaitTimeRecorder(),
 	}
 	p.queueCond = sync.NewCond(&p.queueMtx)
 
@@ -112,7 +145,25 @@ func (p *packetQueue) packetCoordinator() {
 			}
 		}
 
-		nextPkt := p.queue[0]
+		// Before handing off the head of the queue, we'll remove any
+		// packets which have waited past their deadline, such that the
+		// channelLink can fail them back.
+		expired := p.removeExpired(time.Now())
+		if len(expired) != 0 {
+			p.queueCond.L.Unlock()
+
+			for _, pkt := range expired {
+				select {
+				case p.expiredPkts <- pkt:
+				case <-p.quit:
+					return
+				}
+			}
+			continue
+		}
+
+		nextPkt := p.queue[0].pkt
+		addedAt := p.queue[0].addedAt
 
 		p.queueCond.L.Unlock()
 
@@ -136,6 +187,8 @@ func (p *packetQueue) packetCoordinator() {
 				atomic.AddInt32(&p.queueLen, -1)
 				atomic.AddInt64(&p.totalHtlcAmt, int64(-nextPkt.amount))
 				p.queueCond.L.Unlock()
+
+				p.waitTimes.Observe(time.Since(addedAt))
 			case <-p.quit:
 				return
 			}
@@ -148,16 +201,81 @@ func (p *packetQueue) packetCoordinator() {
 	}
 }
 
+// removeExpired removes all packets whose deadline has passed as of now from
+// the queue, returning them in their original order.
+//
+// NOTE: The queueMtx MUST be held when calling this method.
+func (p *packetQueue) removeExpired(now time.Time) []*htlcPacket {
+	if p.nextDeadline.IsZero() || now.Before(p.nextDeadline) {
+		return nil
+	}
+
+	var expired []*htlcPacket
+	remaining := p.queue[:0]
+	p.nextDeadline = time.Time{}
+	for _, queued := range p.queue {
+		if queued.deadline.IsZero() || now.Before(queued.deadline) {
+			remaining = append(remaining, queued)
+			p.updateNextDeadline(queued.deadline)
+			continue
+		}
+
+		expired = append(expired, queued.pkt)
+		atomic.AddInt32(&p.queueLen, -1)
+		atomic.AddInt64(&p.totalHtlcAmt, int64(-queued.pkt.amount))
+		atomic.AddUint64(&p.numExpired, 1)
+		p.waitTimes.Observe(now.Sub(queued.addedAt))
+	}
+
+	// Clear the references left beyond the end of the remaining packets,
+	// so the expired packets can be garbage collected.
+	for i := len(remaining); i < len(p.queue); i++ {
+		p.queue[i] = nil
+	}
+	p.queue = remaining
+
+	return expired
+}
+
+// updateNextDeadline lowers the earliest deadline of the queue to the passed
+// deadline if it's earlier. A zero deadline is ignored.
+//
+// NOTE: The queueMtx MUST be held when calling this method.
+func (p *packetQueue) updateNextDeadline(deadline time.Time) {
+	if deadline.IsZero() {
+		return
+	}
+
+	if p.nextDeadline.IsZero() || deadline.Before(p.nextDeadline) {
+		p.nextDeadline = deadline
+	}
+}
+
 // AddPkt adds the referenced packet to the overflow queue, preserving ordering
-// of the existing items.
+// of the existing items. The packet remains within the queue until a slot
+// becomes available for it.
 func (p *packetQueue) AddPkt(pkt *htlcPacket) {
+	p.AddPktWithDeadline(pkt, time.Time{})
+}
+
+// AddPktWithDeadline adds the referenced packet to the overflow queue,
+// preserving ordering of the existing items. If no slot has become available
+// for the packet by the passed deadline, then it's removed from the queue and
+// delivered over expiredPkts instead. A zero deadline never expires.
+func (p *packetQueue) AddPktWithDeadline(pkt *htlcPacket, deadline time.Time) {
 	// First, we'll lock the condition, and add the message to the end of
 	// the message queue, and increment the internal atomic for tracking
 	// the queue's length.
 	p.queueCond.L.Lock()
-	p.queue = append(p.queue, pkt)
+	p.queue = append(p.queue, &queuedPacket{
+		pkt:      pkt,
+		addedAt:  time.Now(),
+		deadline: deadline,
+	})
+	p.updateNextDeadline(deadline)
 	atomic.AddInt32(&p.queueLen, 1)
 	atomic.AddInt64(&p.totalHtlcAmt, int64(pkt.amount))
+	atomic.AddUint64(&p.numParked, 1)
 	p.queueCond.L.Unlock()
 
 	// With the message added, we signal to the msgConsumer that there are
@@ -192,6 +310,24 @@ func (p *packetQueue) Length() int32 {
 	return atomic.LoadInt32(&p.queueLen)
 }
 
+// WaitTimes returns a snapshot of the distribution of the amount of time
+// packets have spent within the queue before being released to the
+// channelLink.
+func (p *packetQueue) WaitTimes() WaitTimeHistogram {
+	return p.waitTimes.Snapshot()
+}
+
+// NumParked returns the number of packets which have been added to the queue.
+func (p *packetQueue) NumParked() uint64 {
+	return atomic.LoadUint64(&p.numParked)
+}
+
+// NumExpired returns the number of packets which have been removed from the
+// queue as they waited past their deadline.
+func (p *packetQueue) NumExpired() uint64 {
+	return atomic.LoadUint64(&p.numExpired)
+}
+
 // TotalHtlcAmount is the total amount (in mSAT) of all HTLC's currently
 // residing within the overflow queue.
 func (p *packetQueue) TotalHtlcAmount() lnwire.MilliSatoshi {
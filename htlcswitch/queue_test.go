@@ -62,3 +62,144 @@ func TestWaitingQueueThreadSafety(t *testing.T) {
 		t.Fatal("wrong order of the objects")
 	}
 }
+
+// TestPacketQueueWaitTimes ensures that the queue records the amount of time
+// each packet spent waiting before being released.
+func TestPacketQueueWaitTimes(t *testing.T) {
+	t.Parallel()
+
+	const (
+		numPkts = 10
+		delay   = 100 * time.Millisecond
+	)
+
+	q := newPacketQueue(numPkts)
+	q.Start()
+	defer q.Stop()
+
+	for i := 0; i < numPkts; i++ {
+		q.AddPkt(&htlcPacket{
+			incomingHTLCID: uint64(i),
+			htlc:           &lnwire.UpdateAddHTLC{},
+		})
+	}
+
+	// Before any packets have been released, no samples should have been
+	// recorded.
+	if waitTimes := q.WaitTimes(); waitTimes.Count != 0 {
+		t.Fatalf("expected no samples, got %v", waitTimes.Count)
+	}
+
+	time.Sleep(delay)
+
+	for i := 0; i < numPkts; i++ {
+		q.SignalFreeSlot()
+
+		select {
+		case <-q.outgoingPkts:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	waitTimes := q.WaitTimes()
+	if waitTimes.Count != numPkts {
+		t.Fatalf("expected %v samples, got %v", numPkts,
+			waitTimes.Count)
+	}
+
+	var bucketTotal uint64
+	for _, bucket := range waitTimes.Buckets {
+		bucketTotal += bucket.Count
+	}
+	if bucketTotal != numPkts {
+		t.Fatalf("expected %v samples within buckets, got %v",
+			numPkts, bucketTotal)
+	}
+
+	// Every packet waited at least as long as the delay, so all of the
+	// percentiles should reflect that.
+	for _, p := range []float64{0, 50, 90, 99, 100} {
+		if waitTimes.Percentile(p) < delay {
+			t.Fatalf("p%v is %v, expected at least %v", p,
+				waitTimes.Percentile(p), delay)
+		}
+	}
+	if waitTimes.Percentile(100) != waitTimes.Max {
+		t.Fatalf("p100 should equal max wait time %v, got %v",
+			waitTimes.Max, waitTimes.Percentile(100))
+	}
+	if waitTimes.Mean() < delay {
+		t.Fatalf("mean is %v, expected at least %v", waitTimes.Mean(),
+			delay)
+	}
+}
+
+// TestPacketQueueDeadline ensures that packets which don't leave the queue by
+// their deadline are delivered as expired, while the remaining packets are
+// still released in order.
+func TestPacketQueueDeadline(t *testing.T) {
+	t.Parallel()
+
+	q := newPacketQueue(10)
+	q.Start()
+	defer q.Stop()
+
+	// We'll add a packet without a deadline, followed by one which
+	// expires shortly, and another one with a distant deadline.
+	deadlines := []time.Time{
+		{},
+		time.Now().Add(100 * time.Millisecond),
+		time.Now().Add(time.Hour),
+	}
+	for i, deadline := range deadlines {
+		q.AddPktWithDeadline(&htlcPacket{
+			incomingHTLCID: uint64(i),
+			amount:         1000,
+			htlc:           &lnwire.UpdateAddHTLC{},
+		}, deadline)
+	}
+
+	select {
+	case pkt := <-q.expiredPkts:
+		if pkt.incomingHTLCID != 1 {
+			t.Fatalf("expected packet 1 to expire, got %v",
+				pkt.incomingHTLCID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("packet didn't expire")
+	}
+
+	if q.Length() != 2 {
+		t.Fatalf("expected 2 packets, got %v", q.Length())
+	}
+	if q.TotalHtlcAmount() != 2000 {
+		t.Fatalf("expected total amount of 2000, got %v",
+			q.TotalHtlcAmount())
+	}
+	if q.NumParked() != 3 || q.NumExpired() != 1 {
+		t.Fatalf("expected 3 parked and 1 expired packets, got %v "+
+			"and %v", q.NumParked(), q.NumExpired())
+	}
+
+	// The time the expired packet spent within the queue should also be
+	// recorded.
+	if waitTimes := q.WaitTimes(); waitTimes.Count != 1 {
+		t.Fatalf("expected 1 sample, got %v", waitTimes.Count)
+	}
+
+	for _, expectedID := range []uint64{0, 2} {
+		q.SignalFreeSlot()
+
+		select {
+		case pkt := <-q.outgoingPkts:
+			if pkt.incomingHTLCID != expectedID {
+				t.Fatalf("expected packet %v, got %v",
+					expectedID, pkt.incomingHTLCID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
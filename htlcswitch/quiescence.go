@@ -0,0 +1,357 @@
+package htlcswitch
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+var (
+	// ErrQuiescenceTimeout is returned by Quiesce if the link was unable
+	// to reach a quiescent state with the remote peer before the passed
+	// context expired.
+	ErrQuiescenceTimeout = errors.New("timed out waiting for channel to " +
+		"quiesce")
+
+	// ErrQuiescenceUnsupported is returned by Quiesce if the remote peer
+	// didn't signal support for quiescence.
+	ErrQuiescenceUnsupported = errors.New("remote peer doesn't support " +
+		"quiescence")
+
+	// ErrInvalidQuiescenceToken is returned by Resume if the passed token
+	// doesn't belong to the link's current quiescent session.
+	ErrInvalidQuiescenceToken = errors.New("quiescence token doesn't " +
+		"match current session")
+)
+
+// QuiescenceToken is returned by Quiesce once a link has reached a quiescent
+// state. The token must be handed back to Resume in order for the link to
+// resume sending and accepting updates.
+type QuiescenceToken struct {
+	chanID    lnwire.ChannelID
+	sessionID uint64
+
+	// Initiator is true if we hold the initiator role for the quiescent
+	// session. If both parties requested quiescence concurrently, then
+	// the funder of the channel is deemed the initiator.
+	Initiator bool
+}
+
+// quiesceReq is sent to the htlcManager goroutine by Quiesce. The token is
+// delivered over the resp channel once the link is quiescent.
+type quiesceReq struct {
+	resp chan QuiescenceToken
+}
+
+// quiesceAbort is sent to the htlcManager goroutine by Quiesce once its
+// context has expired before the link became quiescent.
+type quiesceAbort struct {
+	req *quiesceReq
+}
+
+// resumeReq is sent to the htlcManager goroutine by Resume.
+type resumeReq struct {
+	token QuiescenceToken
+	err   chan error
+}
+
+// quiescenceState tracks the progress of the stfu negotiation with the remote
+// peer. It's only accessed from within the htlcManager goroutine.
+type quiescenceState struct {
+	// localPending is true if we need to send stfu, but are waiting for
+	// our commitment chains to sync before doing so.
+	localPending bool
+
+	// localInitiator is true if we requested quiescence, rather than
+	// replying to a request from the remote peer.
+	localInitiator bool
+
+	// localSent is true once we've sent stfu, after which we'll send no
+	// further updates for the channel.
+	localSent bool
+
+	// remoteReceived is true once we've received stfu from the remote
+	// peer, after which it must send no further updates for the channel.
+	remoteReceived bool
+
+	// remoteInitiator is true if the remote peer's stfu requested
+	// quiescence.
+	remoteInitiator bool
+
+	// sessionID identifies the current quiescent session, and is bumped
+	// each time the link resumes.
+	sessionID uint64
+
+	// waiters is the set of pending Quiesce calls awaiting a token.
+	waiters []*quiesceReq
+
+	// deferredPkts are the settles and fails received from the switch
+	// after we've sent stfu. They're processed once the link resumes.
+	deferredPkts []*htlcPacket
+
+	// deferredResolutions are the resolutions of parked HTLC's received
+	// after we've sent stfu. They're processed once the link resumes.
+	deferredResolutions []*heldHTLCResolution
+
+	// deferredFailures are the delayed failures of HTLC's for which we're
+	// the exit hop whose delay elapsed after we've sent stfu. They're
+	// processed once the link resumes.
+	deferredFailures []*delayedFailure
+}
+
+// isQuiescent returns true if both parties have sent stfu.
+func (q *quiescenceState) isQuiescent() bool {
+	return q.localSent && q.remoteReceived
+}
+
+// isActive returns true if a quiescence negotiation is underway, or the
+// channel is already quiescent.
+func (q *quiescenceState) isActive() bool {
+	return q.localPending || q.localSent || q.remoteReceived
+}
+
+// Quiesce negotiates quiescence with the remote peer. The link will stop
+// accepting new HTLC's, then send stfu once all pending updates have been
+// committed to by both parties. The returned token is delivered once the
+// remote peer has also sent stfu, at which point neither party will send any
+// further updates until Resume is called. If the remote peer initiates
+// quiescence concurrently, or the channel is already quiescent, then the
+// token for the existing session is returned. If the link fails to quiesce
+// before the passed context expires, then the connection with the peer is
+// torn down, and ErrQuiescenceTimeout is returned.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) Quiesce(ctx context.Context) (QuiescenceToken, error) {
+	if !l.cfg.QuiescenceSupported {
+		return QuiescenceToken{}, ErrQuiescenceUnsupported
+	}
+
+	req := &quiesceReq{
+		resp: make(chan QuiescenceToken, 1),
+	}
+
+	select {
+	case l.linkControl <- req:
+	case <-ctx.Done():
+		return QuiescenceToken{}, ErrQuiescenceTimeout
+	case <-l.quit:
+		return QuiescenceToken{}, errors.New("link shutting down")
+	}
+
+	select {
+	case token := <-req.resp:
+		return token, nil
+
+	case <-ctx.Done():
+		// We'll hand the abort to the link in the background, as the
+		// caller has already run out of time.
+		go func() {
+			select {
+			case l.linkControl <- &quiesceAbort{req: req}:
+			case <-l.quit:
+			}
+		}()
+
+		return QuiescenceToken{}, ErrQuiescenceTimeout
+
+	case <-l.quit:
+		return QuiescenceToken{}, errors.New("link shutting down")
+	}
+}
+
+// Resume exits the quiescent session identified by the passed token. Any
+// settles and fails deferred while the channel was quiescent are processed,
+// and the link will once again accept new HTLC's.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) Resume(token QuiescenceToken) error {
+	req := &resumeReq{
+		token: token,
+		err:   make(chan error, 1),
+	}
+
+	select {
+	case l.linkControl <- req:
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+
+	select {
+	case err := <-req.err:
+		return err
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+}
+
+// isQuiescing returns true if the link has stopped accepting new HTLC's as a
+// quiescence negotiation is underway, or the channel is quiescent.
+func (l *channelLink) isQuiescing() bool {
+	return atomic.LoadInt32(&l.quiescing) == 1
+}
+
+// handleQuiesceReq registers a new Quiesce call, beginning the stfu
+// negotiation if one isn't already underway.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleQuiesceReq(req *quiesceReq) {
+	q := &l.quiescence
+	q.waiters = append(q.waiters, req)
+
+	if !q.localPending && !q.localSent {
+		log.Infof("ChannelLink(%v) requesting quiescence", l)
+
+		q.localPending = true
+		q.localInitiator = !q.remoteReceived
+		atomic.StoreInt32(&l.quiescing, 1)
+	}
+
+	l.checkQuiescence()
+}
+
+// handleQuiesceAbort removes an expired Quiesce call. If the link still
+// hasn't become quiescent, then the connection is torn down, which resets
+// the quiescence negotiation for both parties.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleQuiesceAbort(req *quiesceAbort) {
+	q := &l.quiescence
+	for i, waiter := range q.waiters {
+		if waiter == req.req {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			break
+		}
+	}
+
+	if q.isQuiescent() {
+		return
+	}
+
+	l.fail(DisconnectQuiescenceTimeout, "unable to quiesce channel "+
+		"before deadline")
+}
+
+// handleStfu processes an stfu message from the remote peer. If we haven't
+// yet sent our own stfu, then we'll reply with one once all pending updates
+// have been committed to.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleStfu(msg *lnwire.Stfu) {
+	if !l.cfg.QuiescenceSupported {
+		l.fail(DisconnectProtocolViolation, "received stfu without "+
+			"negotiating quiescence")
+		return
+	}
+
+	q := &l.quiescence
+	if q.remoteReceived {
+		l.fail(DisconnectProtocolViolation, "received duplicate stfu")
+		return
+	}
+
+	log.Infof("ChannelLink(%v) received stfu, initiator=%v", l,
+		msg.Initiator)
+
+	q.remoteReceived = true
+	q.remoteInitiator = msg.Initiator
+	atomic.StoreInt32(&l.quiescing, 1)
+
+	if !q.localPending && !q.localSent {
+		q.localPending = true
+	}
+
+	l.checkQuiescence()
+}
+
+// checkQuiescence sends our stfu if one is pending and all updates have been
+// committed to by both parties, then delivers the quiescence token to any
+// waiting callers once both parties have sent stfu.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) checkQuiescence() {
+	q := &l.quiescence
+	if q.localPending && l.channel.FullySynced() && l.batchCounter == 0 {
+		log.Infof("ChannelLink(%v) sending stfu, initiator=%v", l,
+			q.localInitiator)
+
+		l.cfg.Peer.SendMessage(lnwire.NewStfu(
+			l.ChanID(), q.localInitiator,
+		))
+
+		q.localPending = false
+		q.localSent = true
+	}
+
+	if !q.isQuiescent() || len(q.waiters) == 0 {
+		return
+	}
+
+	token := l.quiescenceToken()
+
+	log.Infof("ChannelLink(%v) is quiescent, initiator=%v", l,
+		token.Initiator)
+
+	for _, waiter := range q.waiters {
+		waiter.resp <- token
+	}
+	q.waiters = nil
+}
+
+// quiescenceToken returns the token for the current quiescent session.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) quiescenceToken() QuiescenceToken {
+	q := &l.quiescence
+
+	// If both parties requested quiescence, then the tie is broken in
+	// favour of the funder of the channel.
+	initiator := q.localInitiator
+	if q.localInitiator && q.remoteInitiator {
+		initiator = l.channel.IsInitiator()
+	}
+
+	return QuiescenceToken{
+		chanID:    l.ChanID(),
+		sessionID: q.sessionID,
+		Initiator: initiator,
+	}
+}
+
+// handleResumeReq exits the current quiescent session if the passed token
+// matches it, processing any deferred packets and resolutions.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleResumeReq(req *resumeReq) {
+	q := &l.quiescence
+	if !q.isQuiescent() || req.token.chanID != l.ChanID() ||
+		req.token.sessionID != q.sessionID {
+
+		req.err <- ErrInvalidQuiescenceToken
+		return
+	}
+
+	log.Infof("ChannelLink(%v) resuming from quiescence", l)
+
+	deferredPkts := q.deferredPkts
+	deferredResolutions := q.deferredResolutions
+	deferredFailures := q.deferredFailures
+
+	l.quiescence = quiescenceState{
+		sessionID: q.sessionID + 1,
+	}
+	atomic.StoreInt32(&l.quiescing, 0)
+
+	for _, pkt := range deferredPkts {
+		l.handleDownStreamPkt(pkt, false)
+	}
+	for _, resolution := range deferredResolutions {
+		l.handleHeldHTLCResolution(resolution)
+	}
+	for _, failure := range deferredFailures {
+		l.handleDelayedFailure(failure)
+	}
+
+	req.err <- nil
+}
@@ -0,0 +1,230 @@
+package htlcswitch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// quiesce calls Quiesce on the passed link, failing the test if the link
+// doesn't quiesce within a reasonable amount of time.
+func quiesce(t *testing.T, link ChannelLink) QuiescenceToken {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := link.Quiesce(ctx)
+	if err != nil {
+		t.Fatalf("unable to quiesce link %v: %v", link, err)
+	}
+
+	return token
+}
+
+// TestChannelLinkQuiesce tests that once Alice quiesces her link with Bob, no
+// payments can be sent over the channel until both parties have resumed, and
+// that Alice is deemed the initiator of the quiescent session.
+func TestChannelLinkQuiesce(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	aliceToken := quiesce(t, n.aliceChannelLink)
+	if !aliceToken.Initiator {
+		t.Fatalf("alice should be the initiator")
+	}
+
+	// Bob replied to Alice's stfu, so his link should already be
+	// quiescent, with Alice as the initiator.
+	bobToken := quiesce(t, n.firstBobChannelLink)
+	if bobToken.Initiator {
+		t.Fatalf("bob shouldn't be the initiator")
+	}
+
+	if n.aliceChannelLink.EligibleToForward() {
+		t.Fatalf("alice's link shouldn't be eligible to forward " +
+			"while quiescent")
+	}
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink)
+
+	_, err = n.makePayment(n.aliceServer, n.bobServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(5 * time.Second)
+	if err == nil {
+		t.Fatalf("payment should fail while channel is quiescent")
+	}
+
+	// A token from a different link must be rejected.
+	err = n.aliceChannelLink.Resume(QuiescenceToken{})
+	if err != ErrInvalidQuiescenceToken {
+		t.Fatalf("expected ErrInvalidQuiescenceToken, got %v", err)
+	}
+
+	if err := n.aliceChannelLink.Resume(aliceToken); err != nil {
+		t.Fatalf("unable to resume alice's link: %v", err)
+	}
+	if err := n.firstBobChannelLink.Resume(bobToken); err != nil {
+		t.Fatalf("unable to resume bob's link: %v", err)
+	}
+
+	// The token can only be used once.
+	err = n.aliceChannelLink.Resume(aliceToken)
+	if err != ErrInvalidQuiescenceToken {
+		t.Fatalf("expected ErrInvalidQuiescenceToken, got %v", err)
+	}
+
+	// Now that the channel has resumed, the payment should succeed.
+	_, err = n.makePayment(n.aliceServer, n.bobServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+}
+
+// TestChannelLinkQuiesceConcurrent tests that if both parties request
+// quiescence concurrently, then both reach quiescence, with exactly one of
+// them deemed the initiator.
+func TestChannelLinkQuiesceConcurrent(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		bobToken QuiescenceToken
+		bobErr   = make(chan error, 1)
+	)
+	go func() {
+		var err error
+		bobToken, err = n.firstBobChannelLink.Quiesce(ctx)
+		bobErr <- err
+	}()
+	aliceToken := quiesce(t, n.aliceChannelLink)
+
+	if err := <-bobErr; err != nil {
+		t.Fatalf("unable to quiesce bob's link: %v", err)
+	}
+
+	if aliceToken.Initiator == bobToken.Initiator {
+		t.Fatalf("exactly one party should be the initiator: "+
+			"alice=%v, bob=%v", aliceToken.Initiator,
+			bobToken.Initiator)
+	}
+
+	if err := n.aliceChannelLink.Resume(aliceToken); err != nil {
+		t.Fatalf("unable to resume alice's link: %v", err)
+	}
+	if err := n.firstBobChannelLink.Resume(bobToken); err != nil {
+		t.Fatalf("unable to resume bob's link: %v", err)
+	}
+}
+
+// TestChannelLinkQuiesceTimeout tests that if the remote peer doesn't reply to
+// our stfu before the deadline, then Quiesce returns ErrQuiescenceTimeout.
+func TestChannelLinkQuiesceTimeout(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceMsgs := link.(*channelLink).cfg.Peer.(*mockPeer).sentMsgs
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond,
+	)
+	defer cancel()
+
+	if _, err := link.Quiesce(ctx); err != ErrQuiescenceTimeout {
+		t.Fatalf("expected ErrQuiescenceTimeout, got %v", err)
+	}
+
+	// Alice should have sent stfu as the initiator, as the channel had no
+	// pending updates.
+	select {
+	case msg := <-aliceMsgs:
+		stfu, ok := msg.(*lnwire.Stfu)
+		if !ok {
+			t.Fatalf("expected Stfu, got %T", msg)
+		}
+		if !stfu.Initiator {
+			t.Fatalf("stfu should be sent as initiator")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("stfu not sent")
+	}
+}
+
+// TestChannelLinkQuiesceUnsupported tests that if the remote peer didn't
+// signal support for quiescence, then Quiesce fails without sending stfu, and
+// we don't reply to any stfu the remote peer sends.
+func TestChannelLinkQuiesceUnsupported(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceLink.cfg.QuiescenceSupported = false
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := link.Quiesce(ctx); err != ErrQuiescenceUnsupported {
+		t.Fatalf("expected ErrQuiescenceUnsupported, got %v", err)
+	}
+
+	link.HandleChannelUpdate(lnwire.NewStfu(link.ChanID(), true))
+
+	select {
+	case msg := <-aliceMsgs:
+		t.Fatalf("unexpected message %T", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if aliceLink.isQuiescing() {
+		t.Fatalf("link shouldn't be quiescing")
+	}
+}
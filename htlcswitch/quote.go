@@ -0,0 +1,215 @@
+package htlcswitch
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// FirstHopUnusableReason is the reason a channel can't currently carry an
+// HTLC of a quoted amount as the first hop of a route.
+type FirstHopUnusableReason uint8
+
+const (
+	// FirstHopUsable indicates that the channel can carry the HTLC.
+	FirstHopUsable FirstHopUnusableReason = iota
+
+	// FirstHopUnknownChannel indicates that the switch has no link for
+	// the channel.
+	FirstHopUnknownChannel
+
+	// FirstHopIneligible indicates that the link isn't eligible to
+	// forward, for the IneligibleReason given within the quote.
+	FirstHopIneligible
+
+	// FirstHopNoFreeSlot indicates that the channel has no HTLC slot left
+	// beyond those reserved for locally initiated payments.
+	FirstHopNoFreeSlot
+
+	// FirstHopBelowMinHTLC indicates that the amount is below the
+	// minimum HTLC of the link's forwarding policy.
+	FirstHopBelowMinHTLC
+
+	// FirstHopInsufficientBandwidth indicates that the amount exceeds the
+	// link's bandwidth.
+	FirstHopInsufficientBandwidth
+
+	// FirstHopExceedsMaxHTLC indicates that the amount fits within the
+	// link's bandwidth, but exceeds the largest HTLC the limits of its
+	// forwarding policy or the channel's constraints allow for.
+	FirstHopExceedsMaxHTLC
+)
+
+// String returns a human readable version of the reason.
+func (r FirstHopUnusableReason) String() string {
+	switch r {
+	case FirstHopUsable:
+		return "Usable"
+	case FirstHopUnknownChannel:
+		return "UnknownChannel"
+	case FirstHopIneligible:
+		return "Ineligible"
+	case FirstHopNoFreeSlot:
+		return "NoFreeSlot"
+	case FirstHopBelowMinHTLC:
+		return "BelowMinHTLC"
+	case FirstHopInsufficientBandwidth:
+		return "InsufficientBandwidth"
+	case FirstHopExceedsMaxHTLC:
+		return "ExceedsMaxHTLC"
+	default:
+		return "Unknown"
+	}
+}
+
+// FirstHopQuote describes whether a channel can carry an HTLC of a given
+// amount as the first hop of a route, and at what cost. All of its fields are
+// taken from a single snapshot of the link's state.
+type FirstHopQuote struct {
+	// Unusable is the reason the channel can't carry the HTLC, or
+	// FirstHopUsable if it can.
+	Unusable FirstHopUnusableReason
+
+	// IneligibleReason is the reason the link isn't eligible to forward,
+	// or IneligibleNone if it is.
+	IneligibleReason IneligibleReason
+
+	// Bandwidth is the amount currently available to be sent over the
+	// channel, as returned by the link's Bandwidth.
+	Bandwidth lnwire.MilliSatoshi
+
+	// MaxHTLC is the largest single HTLC the link would currently accept,
+	// as returned by its MaxForwardableHTLC.
+	MaxHTLC lnwire.MilliSatoshi
+
+	// Fee is the fee charged for the HTLC under the link's forwarding
+	// policy.
+	Fee lnwire.MilliSatoshi
+
+	// TimeLockDelta is the CLTV delta of the link's forwarding policy.
+	TimeLockDelta uint32
+}
+
+// Usable returns true if the channel can carry the quoted HTLC.
+func (q *FirstHopQuote) Usable() bool {
+	return q.Unusable == FirstHopUsable
+}
+
+// firstHopQuoteReq is a message sent to a channel link in order to have it
+// quote an HTLC of the passed amount.
+type firstHopQuoteReq struct {
+	amt lnwire.MilliSatoshi
+
+	resp chan FirstHopQuote
+}
+
+// QuoteFirstHop returns the link's bandwidth, the largest HTLC it would
+// currently forward, along with the fee and CLTV delta it charges for an HTLC
+// of the passed amount, and whether such an HTLC could currently be sent over
+// it. The quote is computed by the link's main goroutine, such that all of its
+// fields reflect the same state of the link.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) QuoteFirstHop(amt lnwire.MilliSatoshi) FirstHopQuote {
+	req := &firstHopQuoteReq{
+		amt:  amt,
+		resp: make(chan FirstHopQuote, 1),
+	}
+
+	select {
+	case l.linkControl <- req:
+	case <-l.quit:
+		return FirstHopQuote{
+			Unusable:         FirstHopIneligible,
+			IneligibleReason: IneligibleClosing,
+		}
+	}
+
+	select {
+	case quote := <-req.resp:
+		return quote
+	case <-l.quit:
+		return FirstHopQuote{
+			Unusable:         FirstHopIneligible,
+			IneligibleReason: IneligibleClosing,
+		}
+	}
+}
+
+// quoteFirstHop computes the quote returned by QuoteFirstHop.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) quoteFirstHop(amt lnwire.MilliSatoshi) FirstHopQuote {
+	policy := l.cfg.FwrdingPolicy
+	quote := FirstHopQuote{
+		IneligibleReason: l.IneligibleReason(),
+		Bandwidth:        l.Bandwidth(),
+		Fee:              ExpectedFee(policy, amt),
+		TimeLockDelta:    policy.TimeLockDelta,
+	}
+
+	hasSlot := l.HasFreeSlot() && l.freeSlots() > l.minFreeSlots()
+	if quote.IneligibleReason == IneligibleNone && hasSlot {
+		quote.MaxHTLC = l.maxForwardable(policy)
+	}
+
+	switch {
+	case quote.IneligibleReason != IneligibleNone:
+		quote.Unusable = FirstHopIneligible
+
+	case !hasSlot:
+		quote.Unusable = FirstHopNoFreeSlot
+
+	case amt < policy.MinHTLC:
+		quote.Unusable = FirstHopBelowMinHTLC
+
+	case amt > quote.Bandwidth:
+		quote.Unusable = FirstHopInsufficientBandwidth
+
+	case amt > quote.MaxHTLC:
+		quote.Unusable = FirstHopExceedsMaxHTLC
+	}
+
+	return quote
+}
+
+// getLinkByShortIDCmd is a command wrapper used to fetch the link with the
+// passed short channel ID from within the htlcForwarder goroutine.
+type getLinkByShortIDCmd struct {
+	chanID lnwire.ShortChannelID
+	done   chan ChannelLink
+}
+
+// QuoteFirstHop returns the quote of the link with the passed short channel
+// ID, or any of its aliases, for an HTLC of the passed amount, as returned by
+// the link's QuoteFirstHop. If the switch has no such link, then a quote with
+// FirstHopUnknownChannel is returned.
+func (s *Switch) QuoteFirstHop(chanID lnwire.ShortChannelID,
+	amt lnwire.MilliSatoshi) (FirstHopQuote, error) {
+
+	cmd := &getLinkByShortIDCmd{
+		chanID: chanID,
+		done:   make(chan ChannelLink, 1),
+	}
+
+	// The link is only looked up by the htlcForwarder, while the quote
+	// itself is requested from our own goroutine, as the link may in
+	// turn be waiting on the switch.
+	var link ChannelLink
+	select {
+	case s.linkControl <- cmd:
+		select {
+		case link = <-cmd.done:
+		case <-s.quit:
+			return FirstHopQuote{}, errors.New("htlc switch was " +
+				"stopped")
+		}
+	case <-s.quit:
+		return FirstHopQuote{}, errors.New("htlc switch was stopped")
+	}
+
+	if link == nil {
+		return FirstHopQuote{Unusable: FirstHopUnknownChannel}, nil
+	}
+
+	return link.QuoteFirstHop(amt), nil
+}
@@ -0,0 +1,145 @@
+package htlcswitch
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkQuoteFirstHop tests that a link's first hop quote reflects
+// its bandwidth and forwarding policy, and reports each reason the quoted
+// HTLC can't be sent over it.
+func TestChannelLinkQuoteFirstHop(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	policy := aliceLink.CurrentForwardingPolicy().Outbound
+
+	assertQuote := func(amt lnwire.MilliSatoshi,
+		expected FirstHopUnusableReason) FirstHopQuote {
+
+		quote := aliceLink.QuoteFirstHop(amt)
+		if quote.Unusable != expected {
+			t.Fatalf("expected quote of %v to be %v, got %v", amt,
+				expected, quote.Unusable)
+		}
+		if quote.Usable() != (expected == FirstHopUsable) {
+			t.Fatalf("quote of %v has usable=%v with reason %v",
+				amt, quote.Usable(), quote.Unusable)
+		}
+		return quote
+	}
+
+	// An HTLC within the link's bandwidth is usable, and quoted the fee
+	// and CLTV delta of the link's policy.
+	amt := lnwire.NewMSatFromSatoshis(10000)
+	quote := assertQuote(amt, FirstHopUsable)
+	if quote.Bandwidth != aliceLink.Bandwidth() {
+		t.Fatalf("expected bandwidth %v, got %v", aliceLink.Bandwidth(),
+			quote.Bandwidth)
+	}
+	if quote.MaxHTLC != aliceLink.MaxForwardableHTLC() {
+		t.Fatalf("expected max htlc %v, got %v",
+			aliceLink.MaxForwardableHTLC(), quote.MaxHTLC)
+	}
+	if fee := ExpectedFee(policy, amt); quote.Fee != fee {
+		t.Fatalf("expected fee %v, got %v", fee, quote.Fee)
+	}
+	if quote.TimeLockDelta != policy.TimeLockDelta {
+		t.Fatalf("expected time lock delta %v, got %v",
+			policy.TimeLockDelta, quote.TimeLockDelta)
+	}
+
+	// HTLC's below the policy's minimum or above the link's bandwidth
+	// can't be sent.
+	assertQuote(policy.MinHTLC-1, FirstHopBelowMinHTLC)
+	assertQuote(quote.Bandwidth+1, FirstHopInsufficientBandwidth)
+
+	// Nor can any HTLC once all free slots are reserved for local
+	// payments.
+	aliceLink.cfg.MinFreeSlots = ^uint16(0)
+	assertQuote(amt, FirstHopNoFreeSlot)
+	aliceLink.cfg.MinFreeSlots = 0
+
+	// An HTLC within the link's bandwidth which exceeds the limits of its
+	// policy can't be sent either.
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Outbound: ForwardingPolicy{MaxValueInFlight: amt - 1},
+	})
+	if err != nil {
+		t.Fatalf("unable to update policy: %v", err)
+	}
+	quote = assertQuote(amt, FirstHopExceedsMaxHTLC)
+	if quote.MaxHTLC != amt-1 {
+		t.Fatalf("expected max htlc %v, got %v", amt-1, quote.MaxHTLC)
+	}
+
+	// Finally, while the link is ineligible to forward, its reason is
+	// carried within the quote.
+	aliceLink.cfg.Switch.cfg.ChainSync = &mockChainSync{}
+	quote = assertQuote(amt-1, FirstHopIneligible)
+	if quote.IneligibleReason != IneligibleChainNotSynced {
+		t.Fatalf("expected ineligible reason %v, got %v",
+			IneligibleChainNotSynced, quote.IneligibleReason)
+	}
+	if quote.MaxHTLC != 0 {
+		t.Fatalf("expected zero max htlc, got %v", quote.MaxHTLC)
+	}
+}
+
+// TestSwitchQuoteFirstHop tests that the switch quotes the link with the
+// passed short channel ID, and reports unknown channels as such.
+func TestSwitchQuoteFirstHop(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	aliceChannelLink.bandwidth = 5000
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+
+	quote, err := s.QuoteFirstHop(aliceChanID, 1000)
+	if err != nil {
+		t.Fatalf("unable to quote first hop: %v", err)
+	}
+	if !quote.Usable() || quote.Bandwidth != 5000 {
+		t.Fatalf("expected usable quote with bandwidth 5000, got %v",
+			quote)
+	}
+
+	quote, err = s.QuoteFirstHop(aliceChanID, 6000)
+	if err != nil {
+		t.Fatalf("unable to quote first hop: %v", err)
+	}
+	if quote.Unusable != FirstHopInsufficientBandwidth {
+		t.Fatalf("expected %v, got %v", FirstHopInsufficientBandwidth,
+			quote.Unusable)
+	}
+
+	quote, err = s.QuoteFirstHop(bobChanID, 1000)
+	if err != nil {
+		t.Fatalf("unable to quote first hop: %v", err)
+	}
+	if quote.Unusable != FirstHopUnknownChannel {
+		t.Fatalf("expected %v, got %v", FirstHopUnknownChannel,
+			quote.Unusable)
+	}
+}
@@ -0,0 +1,111 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+var (
+	// ErrRebalanceSameLink is returned when attempting to send a
+	// rebalance whose outgoing and incoming channels are the same.
+	ErrRebalanceSameLink = errors.New("rebalance must traverse two " +
+		"distinct channels")
+
+	// ErrInvalidRebalancePreimage is returned when attempting to send a
+	// rebalance with a preimage that doesn't match the payment hash of
+	// its HTLC.
+	ErrInvalidRebalancePreimage = errors.New("preimage doesn't match " +
+		"payment hash of rebalance")
+
+	// ErrDuplicateRebalance is returned when attempting to send a
+	// rebalance whose payment hash is already used by an in-flight
+	// rebalance.
+	ErrDuplicateRebalance = errors.New("rebalance with payment hash " +
+		"already in flight")
+)
+
+// RebalanceForward describes a locally initiated circular payment, which
+// leaves the node over one of its channels and returns to it over another,
+// moving balance between the two. As the node knows the preimage, the HTLC is
+// settled directly once it returns, rather than against an invoice.
+type RebalanceForward struct {
+	// OutgoingChanID is the channel the HTLC is sent out over.
+	OutgoingChanID lnwire.ShortChannelID
+
+	// IncomingChanID is the channel the HTLC is expected to return over,
+	// with us as its exit hop.
+	IncomingChanID lnwire.ShortChannelID
+
+	// Preimage is the preimage of the HTLC's payment hash.
+	Preimage [sha256.Size]byte
+}
+
+// SendRebalance sends the passed HTLC over the rebalance's outgoing channel,
+// and settles it using the rebalance's preimage once it returns to us over
+// the incoming channel. The outgoing channel must have sufficient bandwidth to
+// carry the HTLC, as with any other local payment. Since we're both the
+// sender and the exit hop, no forwarding fees are charged by us on either
+// leg, and the HTLC's amount only needs to cover the fees of the intermediate
+// hops. HTLC's that return over any other channel are processed as regular
+// exit-hop HTLC's.
+func (s *Switch) SendRebalance(rebalance *RebalanceForward,
+	htlc *lnwire.UpdateAddHTLC, deobfuscator ErrorDecrypter) error {
+
+	if rebalance.OutgoingChanID == rebalance.IncomingChanID {
+		return ErrRebalanceSameLink
+	}
+	if sha256.Sum256(rebalance.Preimage[:]) != htlc.PaymentHash {
+		return ErrInvalidRebalancePreimage
+	}
+
+	hash := chainhash.Hash(htlc.PaymentHash)
+
+	s.rebalanceMtx.Lock()
+	if _, ok := s.rebalances[hash]; ok {
+		s.rebalanceMtx.Unlock()
+		return ErrDuplicateRebalance
+	}
+	s.rebalances[hash] = rebalance
+	s.rebalanceMtx.Unlock()
+
+	defer func() {
+		s.rebalanceMtx.Lock()
+		delete(s.rebalances, hash)
+		s.rebalanceMtx.Unlock()
+	}()
+
+	log.Infof("Sending rebalance of %v for hash(%x) from %v to %v",
+		htlc.Amount, hash[:], rebalance.OutgoingChanID,
+		rebalance.IncomingChanID)
+
+	// We'll pin the packet to the outgoing channel, rather than allowing
+	// the switch to select any link to the peer.
+	packet := &htlcPacket{
+		outgoingChanID: rebalance.OutgoingChanID,
+		htlc:           htlc,
+	}
+	_, err := s.sendLocalPacket(packet, deobfuscator)
+	return err
+}
+
+// rebalancePreimage returns the preimage of the in-flight rebalance paying to
+// the passed hash, if the rebalance is expected to return over the target
+// channel.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) rebalancePreimage(hash chainhash.Hash,
+	chanID lnwire.ShortChannelID) ([sha256.Size]byte, bool) {
+
+	s.rebalanceMtx.Lock()
+	defer s.rebalanceMtx.Unlock()
+
+	rebalance, ok := s.rebalances[hash]
+	if !ok || rebalance.IncomingChanID != chanID {
+		return zeroPreimage, false
+	}
+
+	return rebalance.Preimage, true
+}
@@ -0,0 +1,240 @@
+package htlcswitch
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/lightningnetwork/lnd/chainntnfs"
+	"github.com/lightningnetwork/lnd/contractcourt"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
+)
+
+// newRebalanceTestLink creates a channel link for the passed channel, owned
+// by the server and connected to the peer, and adds it to the server's
+// switch.
+func newRebalanceTestLink(t *testing.T, server, peer *mockServer,
+	channel *lnwallet.LightningChannel) (*channelLink, func()) {
+
+	globalPolicy := ForwardingPolicy{
+		MinHTLC:       lnwire.NewMSatFromSatoshis(5),
+		BaseFee:       lnwire.NewMSatFromSatoshis(1),
+		TimeLockDelta: 6,
+	}
+	decoder := &mockIteratorDecoder{}
+	obfuscator := newMockObfuscator()
+	ticker := time.NewTicker(50 * time.Millisecond)
+
+	link := NewChannelLink(
+		ChannelLinkConfig{
+			FwrdingPolicy:     globalPolicy,
+			Peer:              peer,
+			Switch:            server.htlcSwitch,
+			DecodeHopIterator: decoder.DecodeHopIterator,
+			DecodeOnionObfuscator: func(io.Reader) (ErrorEncrypter,
+				lnwire.FailCode) {
+				return obfuscator, lnwire.CodeNone
+			},
+			GetLastChannelUpdate: mockGetChanUpdateMessage,
+			Registry:             server.registry,
+			BlockEpochs: &chainntnfs.BlockEpochEvent{
+				Epochs: make(chan *chainntnfs.BlockEpoch),
+				Cancel: func() {},
+			},
+			FeeEstimator: &mockFeeEstimator{
+				byteFeeIn: make(chan lnwallet.SatPerVByte),
+				quit:      make(chan struct{}),
+			},
+			PreimageCache: &mockPreimageCache{
+				preimageMap: make(map[[32]byte][]byte),
+			},
+			UpdateContractSignals: func(*contractcourt.ContractSignals) error {
+				return nil
+			},
+			ChainEvents: &contractcourt.ChainEventSubscription{},
+			SyncStates:  true,
+			BatchTicker: &mockTicker{ticker.C},
+			BatchSize:   10,
+		},
+		channel,
+		testStartingHeight,
+	)
+	if err := server.htlcSwitch.addLink(link); err != nil {
+		t.Fatalf("unable to add channel link: %v", err)
+	}
+	go func() {
+		for {
+			select {
+			case <-link.(*channelLink).htlcUpdates:
+			case <-link.(*channelLink).quit:
+				return
+			}
+		}
+	}()
+
+	return link.(*channelLink), ticker.Stop
+}
+
+// TestSwitchRebalance ensures that a circular payment sent by Alice out over
+// one of her channels to Bob returns over the other, and is settled by Alice
+// without an invoice, moving balance between her two channels.
+func TestSwitchRebalance(t *testing.T) {
+	t.Parallel()
+
+	// Alice funds the first channel and Bob funds the second, so Bob is
+	// only able to forward the rebalance back over the second channel.
+	chanAmt := btcutil.Amount(btcutil.SatoshiPerBitcoin)
+	aliceChannel1, bobChannel1, cleanUp1, _, err := createTestChannel(
+		alicePrivKey, bobPrivKey, chanAmt, 0, 0, 0,
+		lnwire.NewShortChanIDFromInt(4),
+	)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp1()
+
+	bobChannel2, aliceChannel2, cleanUp2, _, err := createTestChannel(
+		bobPrivKey, alicePrivKey, chanAmt, 0, 0, 0,
+		lnwire.NewShortChanIDFromInt(5),
+	)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp2()
+
+	// Both channels are between Alice and Bob, so they're only told apart
+	// by their funding outpoints.
+	if *aliceChannel1.ChannelPoint() == *aliceChannel2.ChannelPoint() {
+		t.Fatalf("channels share funding outpoint %v",
+			aliceChannel1.ChannelPoint())
+	}
+
+	aliceServer := newMockServer(t, "alice")
+	bobServer := newMockServer(t, "bob")
+
+	aliceLink1, stop := newRebalanceTestLink(
+		t, aliceServer, bobServer, aliceChannel1,
+	)
+	defer stop()
+	aliceLink2, stop := newRebalanceTestLink(
+		t, aliceServer, bobServer, aliceChannel2,
+	)
+	defer stop()
+	bobLink1, stop := newRebalanceTestLink(
+		t, bobServer, aliceServer, bobChannel1,
+	)
+	defer stop()
+	_, stop = newRebalanceTestLink(t, bobServer, aliceServer, bobChannel2)
+	defer stop()
+
+	if err := aliceServer.Start(); err != nil {
+		t.Fatalf("unable to start alice: %v", err)
+	}
+	defer aliceServer.Stop()
+	if err := bobServer.Start(); err != nil {
+		t.Fatalf("unable to start bob: %v", err)
+	}
+	defer bobServer.Stop()
+
+	aliceBandwidth1 := aliceLink1.Bandwidth()
+	aliceBandwidth2 := aliceLink2.Bandwidth()
+
+	// The rebalance is routed through Bob, who forwards it from the first
+	// channel to the second, with Alice as the exit hop.
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin / 10)
+	htlcAmt, totalTimelock, hops := generateHops(
+		amount, testStartingHeight, bobLink1, aliceLink2,
+	)
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatalf("unable to generate route: %v", err)
+	}
+
+	preimage := [32]byte{1}
+	htlc := &lnwire.UpdateAddHTLC{
+		PaymentHash: fastsha256.Sum256(preimage[:]),
+		Amount:      htlcAmt,
+		Expiry:      totalTimelock,
+		OnionBlob:   blob,
+	}
+	rebalance := &RebalanceForward{
+		OutgoingChanID: aliceLink1.ShortChanID(),
+		IncomingChanID: aliceLink2.ShortChanID(),
+		Preimage:       preimage,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- aliceServer.htlcSwitch.SendRebalance(
+			rebalance, htlc, newMockDeobfuscator(),
+		)
+	}()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Fatalf("unable to send rebalance: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("rebalance not completed")
+	}
+
+	// Wait for the final revocations to be received.
+	time.Sleep(100 * time.Millisecond)
+
+	// Alice only pays Bob's forwarding fee, and the remainder is moved
+	// from her first channel to her second.
+	if aliceLink1.Bandwidth() != aliceBandwidth1-htlcAmt {
+		t.Fatalf("wrong bandwidth of first channel: expected %v, "+
+			"got %v", aliceBandwidth1-htlcAmt, aliceLink1.Bandwidth())
+	}
+	if aliceLink2.Bandwidth() != aliceBandwidth2+amount {
+		t.Fatalf("wrong bandwidth of second channel: expected %v, "+
+			"got %v", aliceBandwidth2+amount, aliceLink2.Bandwidth())
+	}
+
+	// With the rebalance complete, its preimage should no longer be
+	// known to the switch.
+	_, ok := aliceServer.htlcSwitch.rebalancePreimage(
+		chainhash.Hash(htlc.PaymentHash), aliceLink2.ShortChanID(),
+	)
+	if ok {
+		t.Fatalf("rebalance should have been removed")
+	}
+}
+
+// TestSwitchRebalanceInvalid ensures that rebalances over a single channel,
+// or with a preimage not matching their payment hash, are rejected.
+func TestSwitchRebalanceInvalid(t *testing.T) {
+	t.Parallel()
+
+	s := New(Config{})
+
+	preimage := [32]byte{1}
+	htlc := &lnwire.UpdateAddHTLC{
+		PaymentHash: fastsha256.Sum256(preimage[:]),
+		Amount:      1,
+	}
+
+	err := s.SendRebalance(&RebalanceForward{
+		OutgoingChanID: aliceChanID,
+		IncomingChanID: aliceChanID,
+		Preimage:       preimage,
+	}, htlc, newMockDeobfuscator())
+	if err != ErrRebalanceSameLink {
+		t.Fatalf("expected ErrRebalanceSameLink, got %v", err)
+	}
+
+	err = s.SendRebalance(&RebalanceForward{
+		OutgoingChanID: aliceChanID,
+		IncomingChanID: bobChanID,
+		Preimage:       [32]byte{2},
+	}, htlc, newMockDeobfuscator())
+	if err != ErrInvalidRebalancePreimage {
+		t.Fatalf("expected ErrInvalidRebalancePreimage, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// ExtraHTLCPolicy determines how a link handles HTLC's added by the remote
+// peer which don't follow on from the remote update log as expected, as may
+// happen when the peer retransmits its updates once the channel has been
+// re-established. HTLC's which are inconsistent with our commitment state
+// always cause the peer to be disconnected.
+type ExtraHTLCPolicy uint8
+
+const (
+	// ExtraHTLCLenient silently drops duplicates of HTLC's we've already
+	// received, and admits borderline extras with a warning. This is the
+	// default policy.
+	ExtraHTLCLenient ExtraHTLCPolicy = iota
+
+	// ExtraHTLCDedupe silently drops duplicates of HTLC's we've already
+	// received, but disconnects the peer on borderline extras.
+	ExtraHTLCDedupe
+
+	// ExtraHTLCStrict disconnects the peer on both duplicates and
+	// borderline extras.
+	ExtraHTLCStrict
+)
+
+// String returns a human readable representation of the ExtraHTLCPolicy.
+func (p ExtraHTLCPolicy) String() string {
+	switch p {
+	case ExtraHTLCLenient:
+		return "lenient"
+	case ExtraHTLCDedupe:
+		return "dedupe"
+	case ExtraHTLCStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("unknown<%d>", uint8(p))
+	}
+}
+
+// addClass is the classification of an HTLC added by the remote peer.
+type addClass uint8
+
+const (
+	// addExpected is an HTLC carrying the next expected ID, which isn't
+	// suspected to be a retransmission.
+	addExpected addClass = iota
+
+	// addDuplicate is an HTLC carrying the ID of one we've already
+	// received, which it matches.
+	addDuplicate
+
+	// addExtra is an HTLC received after the channel was re-established
+	// which carries the next expected ID, but mirrors one we've already
+	// received, and which our commitment can still accommodate.
+	addExtra
+
+	// addInvalid is an HTLC which is inconsistent with our commitment
+	// state, either as its ID skips ahead of the next expected ID, it
+	// conflicts with the HTLC we've already received with its ID, or it's
+	// an extra which our commitment can't accommodate.
+	addInvalid
+)
+
+// addHandling is the action taken upon an HTLC added by the remote peer.
+type addHandling uint8
+
+const (
+	// addAdmit adds the HTLC to the remote update log.
+	addAdmit addHandling = iota
+
+	// addDrop silently drops the HTLC.
+	addDrop
+
+	// addDisconnect disconnects the remote peer.
+	addDisconnect
+)
+
+// handling returns the action the policy takes upon an HTLC of the passed
+// classification.
+func (p ExtraHTLCPolicy) handling(class addClass) addHandling {
+	switch {
+	case class == addExpected:
+		return addAdmit
+	case class == addDuplicate && p != ExtraHTLCStrict:
+		return addDrop
+	case class == addExtra && p == ExtraHTLCLenient:
+		return addAdmit
+	default:
+		return addDisconnect
+	}
+}
+
+// ExtraHTLCCounts counts the HTLC's added by the remote peer of a link which
+// didn't follow on from the remote update log as expected, by their
+// classification.
+type ExtraHTLCCounts struct {
+	// Duplicates is the number of HTLC's received which duplicated one
+	// we'd already received.
+	Duplicates uint64
+
+	// Extras is the number of HTLC's received after the channel was
+	// re-established which mirrored one we'd already received, but which
+	// our commitment could still accommodate.
+	Extras uint64
+
+	// Invalid is the number of HTLC's received which were inconsistent
+	// with our commitment state.
+	Invalid uint64
+}
+
+// extraHTLCCounters counts the classifications of the HTLC's added by the
+// remote peer of a link.
+type extraHTLCCounters struct {
+	// NOTE: These MUST be used atomically.
+	duplicates uint64
+	extras     uint64
+	invalid    uint64
+}
+
+// record accounts for an HTLC of the passed classification.
+func (c *extraHTLCCounters) record(class addClass) {
+	switch class {
+	case addDuplicate:
+		atomic.AddUint64(&c.duplicates, 1)
+	case addExtra:
+		atomic.AddUint64(&c.extras, 1)
+	case addInvalid:
+		atomic.AddUint64(&c.invalid, 1)
+	}
+}
+
+// snapshot returns the current counts.
+func (c *extraHTLCCounters) snapshot() ExtraHTLCCounts {
+	return ExtraHTLCCounts{
+		Duplicates: atomic.LoadUint64(&c.duplicates),
+		Extras:     atomic.LoadUint64(&c.extras),
+		Invalid:    atomic.LoadUint64(&c.invalid),
+	}
+}
+
+// matchesAdd returns true if the passed HTLC within the remote update log has
+// the same terms as the passed add.
+func matchesAdd(htlc channeldb.HTLC, msg *lnwire.UpdateAddHTLC) bool {
+	return htlc.RHash == msg.PaymentHash &&
+		htlc.Amt == msg.Amount &&
+		htlc.RefundTimeout == msg.Expiry &&
+		bytes.Equal(htlc.OnionBlob, msg.OnionBlob[:])
+}
+
+// classifyUpstreamAdd classifies an HTLC added by the remote peer against the
+// remote update log, returning a description of why it's unexpected for
+// anything other than addExpected. HTLC's are only deemed extras until the
+// first commitment signed by the remote peer after the link started, as any
+// retransmissions precede it.
+func (l *channelLink) classifyUpstreamAdd(
+	msg *lnwire.UpdateAddHTLC) (addClass, string) {
+
+	next := l.channel.NextRemoteHtlcIndex()
+	switch {
+	case msg.ID > next:
+		return addInvalid, fmt.Sprintf("id skips ahead of next "+
+			"expected id %v", next)
+
+	case msg.ID < next:
+		for _, htlc := range l.channel.RemoteLogHtlcs() {
+			if htlc.HtlcIndex != msg.ID {
+				continue
+			}
+			if !matchesAdd(htlc, msg) {
+				return addInvalid, "conflicts with the htlc " +
+					"already received with its id"
+			}
+
+			return addDuplicate, "already received"
+		}
+
+		// The HTLC has since been resolved and compacted away, so it
+		// can only be a retransmission of one we've already
+		// processed.
+		return addDuplicate, "already received and resolved"
+	}
+
+	if atomic.LoadInt32(&l.reestablishing) == 0 {
+		return addExpected, ""
+	}
+
+	htlcs := l.channel.RemoteLogHtlcs()
+	var (
+		mirrored = -1
+		pending  lnwire.MilliSatoshi
+	)
+	for _, htlc := range htlcs {
+		pending += htlc.Amt
+		if matchesAdd(htlc, msg) {
+			mirrored = int(htlc.HtlcIndex)
+		}
+	}
+	if mirrored == -1 {
+		return addExpected, ""
+	}
+
+	// The extra is only admitted if our commitment can still accommodate
+	// it. We conservatively count all of the peer's HTLC's within the
+	// log against its committed balance.
+	maxHTLCs := l.channel.State().LocalChanCfg.MaxAcceptedHtlcs
+	if len(htlcs) >= int(maxHTLCs) {
+		return addInvalid, fmt.Sprintf("mirrors htlc id %v, but "+
+			"exceeds the limit of %v htlcs", mirrored, maxHTLCs)
+	}
+	if pending+msg.Amount > l.BandwidthDetail().Inbound {
+		return addInvalid, fmt.Sprintf("mirrors htlc id %v, but "+
+			"exceeds the remote balance", mirrored)
+	}
+
+	return addExtra, fmt.Sprintf("mirrors htlc id %v", mirrored)
+}
+
+// admitUpstreamAdd classifies an HTLC added by the remote peer, and handles
+// it as per the link's ExtraHTLCPolicy. True is returned if the HTLC should
+// be added to the remote update log.
+func (l *channelLink) admitUpstreamAdd(msg *lnwire.UpdateAddHTLC) bool {
+	class, reason := l.classifyUpstreamAdd(msg)
+	l.extraHTLCs.record(class)
+
+	switch l.cfg.ExtraHTLCPolicy.handling(class) {
+	case addAdmit:
+		if class == addExtra {
+			log.Warnf("ChannelLink(%v) admitting unexpected htlc "+
+				"id=%v: %v", l, msg.ID, reason)
+		}
+		return true
+
+	case addDrop:
+		log.Debugf("ChannelLink(%v) dropping duplicate htlc id=%v: %v",
+			l, msg.ID, reason)
+		return false
+
+	default:
+		l.fail(DisconnectProtocolViolation, "unexpected htlc id=%v: %v",
+			msg.ID, reason)
+		return false
+	}
+}
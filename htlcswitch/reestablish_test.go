@@ -0,0 +1,141 @@
+package htlcswitch
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestExtraHTLCPolicyHandling tests the action each ExtraHTLCPolicy takes
+// upon each classification of HTLC added by the remote peer.
+func TestExtraHTLCPolicyHandling(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		policy    ExtraHTLCPolicy
+		duplicate addHandling
+		extra     addHandling
+	}{
+		{ExtraHTLCLenient, addDrop, addAdmit},
+		{ExtraHTLCDedupe, addDrop, addDisconnect},
+		{ExtraHTLCStrict, addDisconnect, addDisconnect},
+	}
+
+	for _, test := range tests {
+		policy := test.policy
+		if h := policy.handling(addExpected); h != addAdmit {
+			t.Fatalf("%v: expected htlc handled with %v", policy, h)
+		}
+		if h := policy.handling(addDuplicate); h != test.duplicate {
+			t.Fatalf("%v: duplicate handled with %v, expected %v",
+				policy, h, test.duplicate)
+		}
+		if h := policy.handling(addExtra); h != test.extra {
+			t.Fatalf("%v: extra handled with %v, expected %v",
+				policy, h, test.extra)
+		}
+		if h := policy.handling(addInvalid); h != addDisconnect {
+			t.Fatalf("%v: invalid htlc handled with %v",
+				policy, h)
+		}
+	}
+}
+
+// TestChannelLinkClassifyUpstreamAdd tests that HTLC's added by the remote
+// peer are classified as duplicates, extras, or invalid against the remote
+// update log, and that the classifications are counted.
+func TestChannelLinkClassifyUpstreamAdd(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, _, cleanUp, err := newSingleLinkTestHarness(
+		chanAmt, 0,
+	)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+
+	amount := lnwire.NewMSatFromSatoshis(10000)
+	var blob [lnwire.OnionPacketSize]byte
+	_, htlc, err := generatePayment(amount, amount, 144, blob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+
+	// The first HTLC carries the next expected ID, so it's admitted.
+	class, _ := aliceLink.classifyUpstreamAdd(htlc)
+	if class != addExpected {
+		t.Fatalf("expected htlc classified as %v", class)
+	}
+	if _, err := bobChannel.AddHTLC(htlc); err != nil {
+		t.Fatalf("unable to add htlc: %v", err)
+	}
+	if _, err := aliceLink.channel.ReceiveHTLC(htlc); err != nil {
+		t.Fatalf("unable to receive htlc: %v", err)
+	}
+
+	assertClass := func(msg *lnwire.UpdateAddHTLC, expected addClass) {
+		class, reason := aliceLink.classifyUpstreamAdd(msg)
+		if class != expected {
+			t.Fatalf("expected class %v, got %v (%v)", expected,
+				class, reason)
+		}
+	}
+
+	// A retransmission of the HTLC is a duplicate, while an HTLC
+	// carrying its ID with different terms is invalid, as is one which
+	// skips ahead of the next expected ID.
+	duplicate := *htlc
+	assertClass(&duplicate, addDuplicate)
+
+	conflicting := *htlc
+	conflicting.Amount++
+	assertClass(&conflicting, addInvalid)
+
+	skipping := *htlc
+	skipping.ID = 2
+	assertClass(&skipping, addInvalid)
+
+	// As the remote peer hasn't signed a commitment since the link
+	// started, an HTLC mirroring the first with the next expected ID is
+	// an extra, as long as our commitment can accommodate it.
+	atomic.StoreInt32(&aliceLink.reestablishing, 1)
+	extra := *htlc
+	extra.ID = 1
+	assertClass(&extra, addExtra)
+
+	localCfg := &aliceLink.channel.State().LocalChanCfg
+	maxHtlcs := localCfg.MaxAcceptedHtlcs
+	localCfg.MaxAcceptedHtlcs = 1
+	assertClass(&extra, addInvalid)
+	localCfg.MaxAcceptedHtlcs = maxHtlcs
+
+	// Once the remote peer has signed a commitment, the window for
+	// retransmissions has passed.
+	atomic.StoreInt32(&aliceLink.reestablishing, 0)
+	assertClass(&extra, addExpected)
+	atomic.StoreInt32(&aliceLink.reestablishing, 1)
+
+	// The lenient default policy drops duplicates, and admits extras,
+	// with each classification counted.
+	if aliceLink.admitUpstreamAdd(&duplicate) {
+		t.Fatalf("duplicate htlc admitted")
+	}
+	if !aliceLink.admitUpstreamAdd(&extra) {
+		t.Fatalf("extra htlc not admitted")
+	}
+	if aliceLink.admitUpstreamAdd(&conflicting) {
+		t.Fatalf("invalid htlc admitted")
+	}
+
+	counts := aliceLink.StatsDetail().ExtraHTLCs
+	expected := ExtraHTLCCounts{Duplicates: 1, Extras: 1, Invalid: 1}
+	if counts != expected {
+		t.Fatalf("expected counts %v, got %v", expected, counts)
+	}
+}
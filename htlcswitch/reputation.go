@@ -0,0 +1,122 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// DefaultReputationSlotWatermark is the default number of free HTLC slots of
+// an outgoing link at or below which it's deemed under pressure, and forwards
+// from low reputation peers are shed.
+const DefaultReputationSlotWatermark uint16 = 20
+
+// Reputation is the standing of an incoming peer, as scored by a
+// ReputationScorer.
+type Reputation uint8
+
+const (
+	// ReputationNeutral is the reputation of peers we know nothing about.
+	// Their forwards are only shed once an outgoing link is under severe
+	// pressure.
+	ReputationNeutral Reputation = iota
+
+	// ReputationLow is the reputation of peers whose forwards are shed
+	// first once an outgoing link is under pressure.
+	ReputationLow
+
+	// ReputationHigh is the reputation of peers whose forwards are never
+	// shed due to pressure, and so are admitted preferentially.
+	ReputationHigh
+)
+
+// String returns a human readable representation of the Reputation.
+func (r Reputation) String() string {
+	switch r {
+	case ReputationNeutral:
+		return "neutral"
+	case ReputationLow:
+		return "low"
+	case ReputationHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// ReputationScorer scores the reputation of the incoming peers of forwards,
+// e.g. based upon their PeerForwardingStats.
+type ReputationScorer interface {
+	// Score returns the reputation of the peer identified by the
+	// serialized compressed form of its public key.
+	Score(peer [33]byte) Reputation
+}
+
+// ReputationPolicy governs the shedding of forwards based upon the reputation
+// of their incoming peer, in order to mitigate channel jamming. Once the
+// outgoing link is under pressure, forwards from low reputation peers are
+// failed back, leaving the remaining slots and bandwidth to those with a
+// better reputation. Forwards from neutral peers are shed once only half of
+// the watermarks remain. The zero value disables shedding.
+type ReputationPolicy struct {
+	// Scorer scores the incoming peer of each forward. If nil, then all
+	// peers are deemed neutral, and no forwards are shed.
+	Scorer ReputationScorer
+
+	// SlotWatermark is the number of free HTLC slots of the outgoing link,
+	// including those reserved for local payments, at or below which the
+	// link is under pressure. If zero, then
+	// DefaultReputationSlotWatermark is used.
+	SlotWatermark uint16
+
+	// BandwidthWatermark is the outgoing bandwidth the link must retain
+	// once the forward is offered, below which the link is under
+	// pressure. If zero, then bandwidth isn't taken into account.
+	BandwidthWatermark lnwire.MilliSatoshi
+}
+
+// underPressure returns true if offering a forward of the passed amount over
+// the passed link would breach the watermarks of the policy, scaled down by
+// the passed divisor.
+func (p *ReputationPolicy) underPressure(link ChannelLink,
+	amt lnwire.MilliSatoshi, divisor int) bool {
+
+	slotWatermark := p.SlotWatermark
+	if slotWatermark == 0 {
+		slotWatermark = DefaultReputationSlotWatermark
+	}
+
+	detail := link.BandwidthDetail()
+	if int(detail.FreeSlots) <= int(slotWatermark)/divisor {
+		return true
+	}
+
+	bandwidthWatermark := p.BandwidthWatermark /
+		lnwire.MilliSatoshi(divisor)
+	if bandwidthWatermark == 0 {
+		return false
+	}
+
+	return detail.Outbound < amt+bandwidthWatermark
+}
+
+// shedsForReputation returns true if the forward of the passed amount from
+// the passed incoming peer over the passed outgoing link should be shed as
+// per the switch's ReputationPolicy.
+func (s *Switch) shedsForReputation(peer [33]byte, link ChannelLink,
+	amt lnwire.MilliSatoshi) bool {
+
+	policy := &s.cfg.ReputationPolicy
+	if policy.Scorer == nil {
+		return false
+	}
+
+	switch policy.Scorer.Score(peer) {
+	case ReputationLow:
+		return policy.underPressure(link, amt, 1)
+
+	case ReputationNeutral:
+		return policy.underPressure(link, amt, 2)
+
+	default:
+		return false
+	}
+}
@@ -0,0 +1,146 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// mockReputationScorer scores peers from a static map, deeming all others
+// neutral.
+type mockReputationScorer struct {
+	scores map[[33]byte]Reputation
+}
+
+func (m *mockReputationScorer) Score(peer [33]byte) Reputation {
+	return m.scores[peer]
+}
+
+// TestSwitchReputationShedding ensures that once the outgoing link is under
+// pressure, forwards from low reputation peers are shed first, followed by
+// those from neutral peers, while those from high reputation peers are still
+// admitted.
+func TestSwitchReputationShedding(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	carolPeer := newMockServer(t, "carol")
+	davePeer := newMockServer(t, "dave")
+	bobPeer := newMockServer(t, "bob")
+
+	scorer := &mockReputationScorer{
+		scores: map[[33]byte]Reputation{
+			alicePeer.PubKey(): ReputationLow,
+			carolPeer.PubKey(): ReputationHigh,
+		},
+	}
+	s := New(Config{
+		ReputationPolicy: ReputationPolicy{
+			Scorer:             scorer,
+			SlotWatermark:      10,
+			BandwidthWatermark: 1000,
+		},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	carolLink := newMockChannelLink(
+		s, lnwire.ChannelID{3}, lnwire.NewShortChanIDFromInt(3),
+		carolPeer, true,
+	)
+	daveLink := newMockChannelLink(
+		s, lnwire.ChannelID{4}, lnwire.NewShortChanIDFromInt(4),
+		davePeer, true,
+	)
+	bobLink := newMockChannelLink(s, chanID2, bobChanID, bobPeer, true)
+	for _, link := range []*mockChannelLink{
+		aliceLink, carolLink, daveLink, bobLink,
+	} {
+		if err := s.AddLink(link); err != nil {
+			t.Fatalf("unable to add link: %v", err)
+		}
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	// forward sends an HTLC from the passed incoming link to Bob, and
+	// returns true if it reached Bob, or false if it was failed back.
+	var htlcID uint64
+	forward := func(source *mockChannelLink) bool {
+		htlcID++
+		s.forward(&htlcPacket{
+			incomingChanID: source.ShortChanID(),
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      100,
+			},
+		})
+
+		select {
+		case <-bobLink.packets:
+			return true
+		case pkt := <-source.packets:
+			if _, ok := pkt.htlc.(*lnwire.UpdateFailHTLC); !ok {
+				t.Fatalf("expected fail, got %T", pkt.htlc)
+			}
+			return false
+		case <-time.After(time.Second):
+			t.Fatalf("htlc %v was neither forwarded nor failed",
+				htlcID)
+		}
+		return false
+	}
+
+	assertAdmitted := func(low, neutral, high bool) {
+		if forward(aliceLink) != low {
+			t.Fatalf("low reputation forward admitted=%v, "+
+				"expected %v", !low, low)
+		}
+		if forward(daveLink) != neutral {
+			t.Fatalf("neutral reputation forward admitted=%v, "+
+				"expected %v", !neutral, neutral)
+		}
+		if forward(carolLink) != high {
+			t.Fatalf("high reputation forward admitted=%v, "+
+				"expected %v", !high, high)
+		}
+	}
+
+	// Without any pressure on Bob's link, all forwards are admitted.
+	assertAdmitted(true, true, true)
+
+	// Once Bob's free slots reach the watermark, forwards from the low
+	// reputation peer are shed.
+	bobLink.freeSlots = 10
+	assertAdmitted(false, true, true)
+
+	// Once only half of the watermark remains, those from the neutral
+	// peer are shed too, while those of the high reputation peer are
+	// still admitted.
+	bobLink.freeSlots = 5
+	assertAdmitted(false, false, true)
+
+	// The bandwidth watermark applies likewise.
+	bobLink.freeSlots = 100
+	bobLink.bandwidth = 1000
+	assertAdmitted(false, true, true)
+
+	bobLink.bandwidth = 500
+	assertAdmitted(false, false, true)
+
+	// Without a scorer, all peers are neutral, and no forwards are shed.
+	s.cfg.ReputationPolicy.Scorer = nil
+	bobLink.freeSlots = 1
+	assertAdmitted(true, true, true)
+}
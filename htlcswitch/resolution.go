@@ -0,0 +1,135 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// ForwardResolution is the settle or fail of a forwarded HTLC, received from
+// the outgoing link, which has yet to be committed to by the incoming link.
+type ForwardResolution struct {
+	// IncomingChanID is the channel over which the resolved HTLC was
+	// received.
+	IncomingChanID lnwire.ShortChannelID
+
+	// IncomingHTLCID is the ID of the resolved HTLC within the incoming
+	// channel.
+	IncomingHTLCID uint64
+
+	// Amount is the value of the resolved HTLC.
+	Amount lnwire.MilliSatoshi
+
+	// Preimage is the preimage of the HTLC's payment hash if it was
+	// settled. If nil, then the HTLC was failed.
+	Preimage *[32]byte
+
+	// FailReason is the encrypted reason for the failure of the HTLC,
+	// already wrapped with our own layer of onion encryption.
+	FailReason lnwire.OpaqueReason
+}
+
+// ResolutionStore durably stores the resolutions of forwarded HTLC's until
+// the incoming link has committed to them, allowing them to be replayed
+// should we crash before doing so.
+type ResolutionStore interface {
+	// AddResolution persists the passed resolution, replacing any prior
+	// resolution for the same incoming HTLC.
+	AddResolution(*ForwardResolution) error
+
+	// RemoveResolution removes the resolution for the target incoming
+	// HTLC, if any.
+	RemoveResolution(chanID lnwire.ShortChannelID, htlcID uint64) error
+
+	// FetchResolutions returns all persisted resolutions.
+	FetchResolutions() ([]*ForwardResolution, error)
+}
+
+// persistResolution durably stores the resolution carried by the passed
+// settle or fail packet before it's handed to the incoming link, so that
+// it's never lost, even if we crash before the incoming link has committed
+// to it.
+func (s *Switch) persistResolution(packet *htlcPacket) error {
+	if s.cfg.ResolutionStore == nil {
+		return nil
+	}
+
+	resolution := &ForwardResolution{
+		IncomingChanID: packet.incomingChanID,
+		IncomingHTLCID: packet.incomingHTLCID,
+		Amount:         packet.amount,
+	}
+	switch htlc := packet.htlc.(type) {
+	case *lnwire.UpdateFulfillHTLC:
+		preimage := htlc.PaymentPreimage
+		resolution.Preimage = &preimage
+
+	case *lnwire.UpdateFailHTLC:
+		resolution.FailReason = htlc.Reason
+	}
+
+	return s.cfg.ResolutionStore.AddResolution(resolution)
+}
+
+// replayResolutions hands any persisted resolutions for HTLC's received over
+// the passed link back to it, as we may have crashed before the link was
+// able to commit to them.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) replayResolutions(link ChannelLink) {
+	if s.cfg.ResolutionStore == nil {
+		return
+	}
+
+	resolutions, err := s.cfg.ResolutionStore.FetchResolutions()
+	if err != nil {
+		log.Errorf("unable to fetch resolutions: %v", err)
+		return
+	}
+
+	for _, resolution := range resolutions {
+		if resolution.IncomingChanID != link.ShortChanID() {
+			continue
+		}
+
+		log.Infof("Replaying resolution of htlc=%v for ChannelLink(%v)",
+			resolution.IncomingHTLCID, link.ShortChanID())
+
+		packet := &htlcPacket{
+			incomingChanID: resolution.IncomingChanID,
+			incomingHTLCID: resolution.IncomingHTLCID,
+			amount:         resolution.Amount,
+			isReplay:       true,
+		}
+		if resolution.Preimage != nil {
+			packet.htlc = &lnwire.UpdateFulfillHTLC{
+				PaymentPreimage: *resolution.Preimage,
+			}
+		} else {
+			packet.htlc = &lnwire.UpdateFailHTLC{
+				Reason: resolution.FailReason,
+			}
+		}
+
+		link.HandleSwitchPacket(packet)
+	}
+}
+
+// ackResolutions removes the persisted resolutions of the passed HTLC's
+// received over the target channel, as the incoming link has now committed to
+// them.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) ackResolutions(chanID lnwire.ShortChannelID,
+	htlcIDs []uint64) {
+
+	if s.cfg.ResolutionStore == nil {
+		return
+	}
+
+	for _, htlcID := range htlcIDs {
+		err := s.cfg.ResolutionStore.RemoveResolution(chanID, htlcID)
+		if err != nil {
+			log.Errorf("unable to remove resolution of htlc=%v "+
+				"for chan_id=%v: %v", htlcID, chanID, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchResolutionReplay ensures that the settle or fail of a forwarded
+// HTLC is persisted before being handed to the incoming link, and replayed to
+// the incoming link after a restart if it wasn't committed to beforehand.
+func TestSwitchResolutionReplay(t *testing.T) {
+	t.Parallel()
+
+	preimage := [32]byte{1}
+	failReason := lnwire.OpaqueReason([]byte("fail"))
+
+	tests := []struct {
+		name    string
+		htlc    lnwire.Message
+		settled bool
+	}{
+		{
+			name: "settle",
+			htlc: &lnwire.UpdateFulfillHTLC{
+				PaymentPreimage: preimage,
+			},
+			settled: true,
+		},
+		{
+			name: "fail",
+			htlc: &lnwire.UpdateFailHTLC{
+				Reason: failReason,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		store := newMockResolutionStore()
+
+		s, aliceLink, _ := newResolutionTestSwitch(t, store)
+
+		s.addCircuit(&PaymentCircuit{
+			IncomingChanID: aliceChanID,
+			IncomingHTLCID: 3,
+			OutgoingChanID: bobChanID,
+			OutgoingHTLCID: 0,
+		})
+
+		err := s.forward(&htlcPacket{
+			outgoingChanID: bobChanID,
+			outgoingHTLCID: 0,
+			amount:         1,
+			htlc:           test.htlc,
+		})
+		if err != nil {
+			t.Fatalf("%v: unable to forward resolution: %v",
+				test.name, err)
+		}
+
+		select {
+		case <-aliceLink.packets:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: resolution not propagated", test.name)
+		}
+
+		// We'll now crash before Alice's link commits to the
+		// resolution, so it should remain persisted.
+		s.Stop()
+
+		resolutions, _ := store.FetchResolutions()
+		if len(resolutions) != 1 {
+			t.Fatalf("%v: expected 1 resolution, got %v",
+				test.name, len(resolutions))
+		}
+
+		// Once restarted, the resolution should be replayed to Alice's
+		// link as soon as it's added.
+		s, aliceLink, _ = newResolutionTestSwitch(t, store)
+
+		var replayed *htlcPacket
+		select {
+		case replayed = <-aliceLink.packets:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: resolution not replayed", test.name)
+		}
+
+		if !replayed.isReplay {
+			t.Fatalf("%v: packet should be marked as replay",
+				test.name)
+		}
+		if replayed.incomingChanID != aliceChanID ||
+			replayed.incomingHTLCID != 3 {
+
+			t.Fatalf("%v: replayed to wrong htlc: (%v, %v)",
+				test.name, replayed.incomingChanID,
+				replayed.incomingHTLCID)
+		}
+
+		switch htlc := replayed.htlc.(type) {
+		case *lnwire.UpdateFulfillHTLC:
+			if !test.settled || htlc.PaymentPreimage != preimage {
+				t.Fatalf("%v: wrong settle replayed: %v",
+					test.name, htlc)
+			}
+		case *lnwire.UpdateFailHTLC:
+			if test.settled || !bytes.Equal(htlc.Reason, failReason) {
+				t.Fatalf("%v: wrong fail replayed: %v",
+					test.name, htlc)
+			}
+		default:
+			t.Fatalf("%v: unexpected packet replayed: %T",
+				test.name, htlc)
+		}
+
+		// After the link acknowledges the resolution, it should no
+		// longer be persisted.
+		s.ackResolutions(aliceChanID, []uint64{3})
+
+		resolutions, _ = store.FetchResolutions()
+		if len(resolutions) != 0 {
+			t.Fatalf("%v: expected no resolutions, got %v",
+				test.name, len(resolutions))
+		}
+
+		s.Stop()
+	}
+}
+
+// newResolutionTestSwitch creates and starts a switch backed by the passed
+// resolution store, with links for Bob's and Alice's channels.
+func newResolutionTestSwitch(t *testing.T, store ResolutionStore) (*Switch,
+	*mockChannelLink, *mockChannelLink) {
+
+	s := New(Config{
+		ResolutionStore: store,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+
+	aliceLink := newMockChannelLink(
+		s, chanID1, aliceChanID, newMockServer(t, "alice"), true,
+	)
+	bobLink := newMockChannelLink(
+		s, chanID2, bobChanID, newMockServer(t, "bob"), true,
+	)
+	if err := s.AddLink(bobLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	if err := s.AddLink(aliceLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+
+	return s, aliceLink, bobLink
+}
+
+// TestChannelLinkAcksResolutions ensures that the incoming link of a forward
+// acknowledges its resolution once it has signed a commitment covering it,
+// releasing it from the switch's resolution store.
+func TestChannelLinkAcksResolutions(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+
+	store := newMockResolutionStore()
+	n.bobServer.htlcSwitch.cfg.ResolutionStore = store
+
+	if err := n.start(); err != nil {
+		t.Fatalf("unable to start three hop network: %v", err)
+	}
+	defer n.stop()
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink, n.carolChannelLink)
+
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	// Wait for Bob to sign the commitment covering the settle.
+	time.Sleep(100 * time.Millisecond)
+
+	resolutions, _ := store.FetchResolutions()
+	if len(resolutions) != 0 {
+		t.Fatalf("expected no resolutions, got %v", len(resolutions))
+	}
+}
@@ -0,0 +1,82 @@
+package htlcswitch
+
+import (
+	"time"
+)
+
+// MaxResolutionBatchDelay is the upper bound on the duration a settle or fail
+// may be held back by resolution batching before a commitment covering it is
+// signed. A forwarded HTLC's incoming leg only becomes irrevocably resolved
+// once committed to, so holding it back any longer would eat into the CLTV
+// delta protecting us, racing the incoming HTLC's expiry.
+const MaxResolutionBatchDelay = time.Second
+
+// ResolutionBatchPolicy governs how a link aggregates the settles and fails
+// of incoming HTLC's before signing a commitment covering them. Rather than
+// signing a new commitment for every resolution, a batching link signs a
+// single commitment once MaxBatchSize resolutions are pending, or the oldest
+// of them has been pending for MaxDelay, whichever comes first. This reduces
+// commitment churn on busy routing nodes at the cost of a bit of latency. The
+// zero value disables batching.
+type ResolutionBatchPolicy struct {
+	// MaxBatchSize is the number of pending resolutions after which a
+	// commitment is signed without waiting for MaxDelay. If zero, then
+	// the link's BatchSize is used.
+	MaxBatchSize uint32
+
+	// MaxDelay is the maximum duration a resolution will remain
+	// uncommitted. Values above MaxResolutionBatchDelay are capped. If
+	// zero, then resolutions aren't batched, and a commitment is signed
+	// for each of them.
+	MaxDelay time.Duration
+}
+
+// maxBatchSize returns the number of pending resolutions which triggers a new
+// commitment, defaulting to the passed batch size.
+func (p *ResolutionBatchPolicy) maxBatchSize(batchSize uint32) uint32 {
+	if p.MaxBatchSize == 0 {
+		return batchSize
+	}
+	return p.MaxBatchSize
+}
+
+// maxDelay returns the maximum duration a resolution may remain uncommitted.
+func (p *ResolutionBatchPolicy) maxDelay() time.Duration {
+	if p.MaxDelay > MaxResolutionBatchDelay {
+		return MaxResolutionBatchDelay
+	}
+	return p.MaxDelay
+}
+
+// resolutionBatchState tracks the settles and fails sent since the link last
+// signed a commitment. It's only accessed from within the htlcManager
+// goroutine.
+type resolutionBatchState struct {
+	// pending is the number of resolutions sent since we last signed a
+	// commitment.
+	pending uint32
+
+	// flushTick fires once the oldest pending resolution has been
+	// uncommitted for the policy's max delay. It's nil while there are no
+	// pending resolutions.
+	flushTick <-chan time.Time
+}
+
+// batchResolution notes that a settle or fail has just been sent, and returns
+// true if a new commitment should be signed right away as per the resolution
+// batching policy.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) batchResolution() bool {
+	policy := &l.cfg.ResolutionBatching
+	if policy.MaxDelay == 0 {
+		return true
+	}
+
+	l.resolutionBatch.pending++
+	if l.resolutionBatch.flushTick == nil {
+		l.resolutionBatch.flushTick = time.After(policy.maxDelay())
+	}
+
+	return l.resolutionBatch.pending >= policy.maxBatchSize(l.cfg.BatchSize)
+}
@@ -0,0 +1,204 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestResolutionBatchPolicyDefaults ensures that the batch size of a
+// resolution batching policy defaults to the link's batch size, and that its
+// delay is capped to MaxResolutionBatchDelay.
+func TestResolutionBatchPolicyDefaults(t *testing.T) {
+	t.Parallel()
+
+	policy := ResolutionBatchPolicy{}
+	if size := policy.maxBatchSize(10); size != 10 {
+		t.Fatalf("expected default batch size of 10, got %v", size)
+	}
+
+	policy = ResolutionBatchPolicy{
+		MaxBatchSize: 3,
+		MaxDelay:     time.Hour,
+	}
+	if size := policy.maxBatchSize(10); size != 3 {
+		t.Fatalf("expected batch size of 3, got %v", size)
+	}
+	if delay := policy.maxDelay(); delay != MaxResolutionBatchDelay {
+		t.Fatalf("expected delay to be capped to %v, got %v",
+			MaxResolutionBatchDelay, delay)
+	}
+}
+
+// newResolutionBatchHarness creates a link in hodl mode with the passed
+// resolution batching policy, and locks in numHtlcs HTLC's sent to it by the
+// remote peer. The preimages of the HTLC's are returned, indexed by HTLC ID.
+func newResolutionBatchHarness(t *testing.T, numHtlcs int,
+	policy ResolutionBatchPolicy) (*channelLink, *lnwallet.LightningChannel,
+	[][32]byte, func()) {
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, batchTick, cleanUp, err :=
+		newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+
+	// We put Alice into HodlHTLC mode, such that she won't settle the
+	// incoming HTLC's herself, and set the policy before any packets are
+	// handed to the link.
+	aliceLink := link.(*channelLink)
+	aliceLink.cfg.HodlHTLC = true
+	aliceLink.cfg.DebugHTLC = true
+	aliceLink.cfg.ResolutionBatching = policy
+
+	var preimages [][32]byte
+	for i := 0; i < numHtlcs; i++ {
+		htlcAmt, totalTimelock, hops := generateHops(
+			lnwire.NewMSatFromSatoshis(10000), testStartingHeight,
+			aliceLink,
+		)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to gen route: %v", err)
+		}
+		invoice, htlc, err := generatePayment(
+			htlcAmt, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+
+		htlc.ID = uint64(i)
+		if _, err := bobChannel.AddHTLC(htlc); err != nil {
+			t.Fatalf("unable to add htlc: %v", err)
+		}
+		aliceLink.HandleChannelUpdate(htlc)
+
+		preimages = append(preimages, invoice.Terms.PaymentPreimage)
+	}
+
+	if err := updateState(batchTick, aliceLink, bobChannel, false); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+
+	return aliceLink, bobChannel, preimages, cleanUp
+}
+
+// TestChannelLinkResolutionBatchSize tests that a link batching resolutions
+// sends MaxBatchSize settles before signing a single commitment covering all
+// of them, rather than one commitment per settle.
+func TestChannelLinkResolutionBatchSize(t *testing.T) {
+	t.Parallel()
+
+	const numHtlcs = 3
+	aliceLink, bobChannel, preimages, cleanUp := newResolutionBatchHarness(
+		t, numHtlcs, ResolutionBatchPolicy{
+			MaxBatchSize: numHtlcs,
+			MaxDelay:     MaxResolutionBatchDelay,
+		},
+	)
+	defer cleanUp()
+
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	start := time.Now()
+	for i, preimage := range preimages {
+		aliceLink.HandleSwitchPacket(&htlcPacket{
+			incomingHTLCID: uint64(i),
+			htlc: &lnwire.UpdateFulfillHTLC{
+				PaymentPreimage: preimage,
+			},
+		})
+	}
+
+	// All settles should be sent before a commitment is signed.
+	for i := 0; i < numHtlcs; i++ {
+		msg, _ := receivePacedMsg(t, aliceMsgs)
+		settle, ok := msg.(*lnwire.UpdateFulfillHTLC)
+		if !ok {
+			t.Fatalf("expected UpdateFulfillHTLC, got %T", msg)
+		}
+
+		err := bobChannel.ReceiveHTLCSettle(
+			settle.PaymentPreimage, settle.ID,
+		)
+		if err != nil {
+			t.Fatalf("unable to receive settle: %v", err)
+		}
+	}
+
+	// The single commitment covering the full batch should be signed
+	// right away, without waiting for the batching delay.
+	if err := handleStateUpdate(aliceLink, bobChannel); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= MaxResolutionBatchDelay {
+		t.Fatalf("full batch wasn't committed right away: %v", elapsed)
+	}
+
+	if htlcs := bobChannel.ActiveHtlcs(); len(htlcs) != 0 {
+		t.Fatalf("expected all htlcs to be resolved, %v remain",
+			len(htlcs))
+	}
+}
+
+// TestChannelLinkResolutionBatchDelay tests that a link batching resolutions
+// signs a commitment covering a partial batch once its oldest resolution has
+// been pending for MaxDelay.
+func TestChannelLinkResolutionBatchDelay(t *testing.T) {
+	t.Parallel()
+
+	const maxDelay = 300 * time.Millisecond
+	aliceLink, bobChannel, _, cleanUp := newResolutionBatchHarness(
+		t, 2, ResolutionBatchPolicy{
+			MaxBatchSize: 10,
+			MaxDelay:     maxDelay,
+		},
+	)
+	defer cleanUp()
+
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	for i := 0; i < 2; i++ {
+		aliceLink.HandleSwitchPacket(&htlcPacket{
+			incomingHTLCID: uint64(i),
+			htlc: &lnwire.UpdateFailHTLC{
+				Reason: []byte("nop"),
+			},
+		})
+	}
+
+	var firstFail time.Time
+	for i := 0; i < 2; i++ {
+		msg, sent := receivePacedMsg(t, aliceMsgs)
+		if i == 0 {
+			firstFail = sent
+		}
+
+		fail, ok := msg.(*lnwire.UpdateFailHTLC)
+		if !ok {
+			t.Fatalf("expected UpdateFailHTLC, got %T", msg)
+		}
+		if err := bobChannel.ReceiveFailHTLC(fail.ID, fail.Reason); err != nil {
+			t.Fatalf("unable to receive fail: %v", err)
+		}
+	}
+
+	// As the batch isn't full, the commitment should only be signed once
+	// the batching delay has elapsed.
+	if err := handleStateUpdate(aliceLink, bobChannel); err != nil {
+		t.Fatalf("unable to update state: %v", err)
+	}
+	if elapsed := time.Since(firstFail); elapsed < maxDelay*2/3 {
+		t.Fatalf("partial batch committed too early: %v", elapsed)
+	}
+
+	if htlcs := bobChannel.ActiveHtlcs(); len(htlcs) != 0 {
+		t.Fatalf("expected all htlcs to be resolved, %v remain",
+			len(htlcs))
+	}
+}
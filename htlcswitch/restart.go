@@ -0,0 +1,151 @@
+package htlcswitch
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// restartPollInterval is the interval at which PrepareForRestart checks
+// whether the in-flight HTLC's have drained.
+const restartPollInterval = 100 * time.Millisecond
+
+var (
+	// ErrRestartNotReady is returned by PrepareForRestart if the node
+	// couldn't be brought into a minimal risk state before the passed
+	// context expired. The accompanying RestartSummary details what
+	// remains unresolved.
+	ErrRestartNotReady = errors.New("node not ready for restart")
+
+	// ErrPreparingRestart is returned when sending a payment once
+	// PrepareForRestart has paused forwarding.
+	ErrPreparingRestart = errors.New("payments paused while preparing " +
+		"for restart")
+)
+
+// RestartSummary describes the state of the switch once PrepareForRestart
+// returns.
+type RestartSummary struct {
+	// PendingCircuits is the number of forwarded and locally initiated
+	// HTLC's which were still awaiting their settle or fail.
+	PendingCircuits int
+
+	// HeldHTLCs are the HTLC's parked within the switch awaiting an
+	// external resolution. They remain on their commitments across the
+	// restart, so they don't prevent it.
+	HeldHTLCs []HeldHTLCSet
+
+	// QuiescedLinks are the links which reached a quiescent state.
+	QuiescedLinks []lnwire.ShortChannelID
+
+	// FailedLinks maps each link which couldn't be quiesced to the reason
+	// why.
+	FailedLinks map[lnwire.ShortChannelID]error
+}
+
+// isPreparingRestart returns true if forwarding has been paused by
+// PrepareForRestart.
+func (s *Switch) isPreparingRestart() bool {
+	return atomic.LoadInt32(&s.preparingRestart) == 1
+}
+
+// PrepareForRestart brings the node into a minimal risk state ahead of a
+// restart. All new forwards are declined, after which we wait for the HTLC's
+// in flight to be settled or failed. HTLC's parked within the switch are left
+// in place. Once drained, the pending updates of each link are signed right
+// away, and each link is quiesced with its remote peer, such that no further
+// updates are exchanged until the restart. If the passed context expires
+// before this completes, then ErrRestartNotReady is returned along with a
+// summary of what remains unresolved. Links which fail to quiesce in time
+// have their connection torn down.
+//
+// NOTE: Forwarding remains paused once this method returns, regardless of
+// its outcome, so the node must be restarted in order to resume it.
+func (s *Switch) PrepareForRestart(ctx context.Context) (*RestartSummary,
+	error) {
+
+	atomic.StoreInt32(&s.preparingRestart, 1)
+
+	log.Infof("Preparing for restart, pausing forwarding")
+
+	summary := &RestartSummary{
+		FailedLinks: make(map[lnwire.ShortChannelID]error),
+	}
+
+	// First, we'll wait for the HTLC's in flight to drain. As no new
+	// forwards are accepted, the number of pending circuits only shrinks.
+	ticker := time.NewTicker(restartPollInterval)
+	defer ticker.Stop()
+
+	for s.circuits.pending() != 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			summary.PendingCircuits = s.circuits.pending()
+			summary.HeldHTLCs = s.HeldHTLCs()
+
+			log.Warnf("Unable to drain %v pending circuits before "+
+				"restart", summary.PendingCircuits)
+
+			return summary, ErrRestartNotReady
+		case <-s.quit:
+			return summary, errors.New("htlc switch was stopped")
+		}
+	}
+	summary.HeldHTLCs = s.HeldHTLCs()
+
+	links, err := s.fetchAllLinks()
+	if err != nil {
+		return summary, err
+	}
+
+	// With the circuits drained, we'll flush any updates which aren't yet
+	// covered by a commitment, then quiesce each link in parallel. The
+	// stfu is only sent once the commitments are in sync, so the flush
+	// spares us from waiting on the link's batch ticker.
+	var (
+		wg  sync.WaitGroup
+		mtx sync.Mutex
+	)
+	for _, link := range links {
+		wg.Add(1)
+		go func(link ChannelLink) {
+			defer wg.Done()
+
+			err := link.SignCommitmentNow()
+			if err != nil && err != ErrNothingToSign {
+				log.Debugf("Unable to flush pending updates "+
+					"of %v: %v", link, err)
+			}
+
+			_, err = link.Quiesce(ctx)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			if err != nil {
+				summary.FailedLinks[link.ShortChanID()] = err
+				return
+			}
+			summary.QuiescedLinks = append(
+				summary.QuiescedLinks, link.ShortChanID(),
+			)
+		}(link)
+	}
+	wg.Wait()
+
+	if len(summary.FailedLinks) != 0 {
+		log.Warnf("Unable to quiesce %v of %v links before restart",
+			len(summary.FailedLinks), len(links))
+
+		return summary, ErrRestartNotReady
+	}
+
+	log.Infof("Ready for restart, %v links quiesced", len(links))
+
+	return summary, nil
+}
@@ -0,0 +1,142 @@
+package htlcswitch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchPrepareForRestart tests that PrepareForRestart pauses forwarding,
+// reports the forwards it's unable to drain, and once they've been resolved,
+// flushes and quiesces each of the switch's links.
+func TestSwitchPrepareForRestart(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	// We'll send a payment from Alice to Carol through Bob, which Carol
+	// parks with a hold resolver, such that Bob is left with a forward in
+	// flight.
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatalf("unable to generate route: %v", err)
+	}
+	invoice, htlc, err := generatePayment(amount, htlcAmt, totalTimelock,
+		blob)
+	if err != nil {
+		t.Fatalf("unable to generate payment: %v", err)
+	}
+	preimage := invoice.Terms.PaymentPreimage
+	resolutions := n.carolServer.htlcSwitch.RegisterHoldResolver(
+		fastsha256.Sum256(preimage[:]),
+	)
+
+	paymentErr := make(chan error, 1)
+	go func() {
+		_, err := n.aliceServer.htlcSwitch.SendHTLC(
+			n.bobServer.PubKey(), htlc, newMockDeobfuscator(),
+		)
+		paymentErr <- err
+	}()
+
+	var resolution *HoldResolution
+	select {
+	case resolution = <-resolutions:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("hold resolution not received")
+	}
+
+	// As the forward can't drain, Bob shouldn't be ready to restart
+	// before the deadline.
+	bobSwitch := n.bobServer.htlcSwitch
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 500*time.Millisecond,
+	)
+	summary, err := bobSwitch.PrepareForRestart(ctx)
+	cancel()
+	if err != ErrRestartNotReady {
+		t.Fatalf("expected ErrRestartNotReady, got %v", err)
+	}
+	if summary.PendingCircuits != 1 {
+		t.Fatalf("expected 1 pending circuit, got %v",
+			summary.PendingCircuits)
+	}
+	if len(summary.QuiescedLinks) != 0 {
+		t.Fatalf("no links should be quiesced while draining")
+	}
+
+	// Forwarding is now paused, so a new payment through Bob should be
+	// declined.
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(5 * time.Second)
+	if err == nil {
+		t.Fatalf("payment should fail while preparing for restart")
+	}
+
+	// Once Carol settles the held HTLC, the forward drains, and Bob
+	// should be able to quiesce both of his links.
+	if err := resolution.Settle(preimage); err != nil {
+		t.Fatalf("unable to settle held htlc: %v", err)
+	}
+	select {
+	case err := <-paymentErr:
+		if err != nil {
+			t.Fatalf("unable to send payment: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("payment wasn't settled")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	summary, err = bobSwitch.PrepareForRestart(ctx)
+	if err != nil {
+		t.Fatalf("unable to prepare for restart: %v, failed links: %v",
+			err, summary.FailedLinks)
+	}
+	if summary.PendingCircuits != 0 {
+		t.Fatalf("expected no pending circuits, got %v",
+			summary.PendingCircuits)
+	}
+	if len(summary.QuiescedLinks) != 2 {
+		t.Fatalf("expected 2 quiesced links, got %v",
+			len(summary.QuiescedLinks))
+	}
+	if n.firstBobChannelLink.EligibleToForward() ||
+		n.secondBobChannelLink.EligibleToForward() {
+
+		t.Fatalf("bob's links shouldn't be eligible to forward once " +
+			"quiesced")
+	}
+
+	// Local payments should be declined outright as well.
+	_, err = bobSwitch.SendHTLC(
+		n.aliceServer.PubKey(), htlc, newMockDeobfuscator(),
+	)
+	if err != ErrPreparingRestart {
+		t.Fatalf("expected ErrPreparingRestart, got %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+)
+
+// maxReviewAuditEntries is the number of the most recent review decisions
+// retained within the audit trail.
+const maxReviewAuditEntries = 1000
+
+// ErrNoPendingReview is returned when attempting to approve or reject a
+// review which doesn't exist, or has already been decided.
+var ErrNoPendingReview = errors.New("no pending review for payment hash")
+
+// ReviewOutcome is the outcome of the review of a payment to an invoice which
+// requires review.
+type ReviewOutcome uint8
+
+const (
+	// ReviewApproved indicates that an operator approved the payment, and
+	// the held HTLC's were settled.
+	ReviewApproved ReviewOutcome = iota
+
+	// ReviewRejected indicates that an operator rejected the payment, and
+	// the held HTLC's were cancelled.
+	ReviewRejected
+
+	// ReviewExpired indicates that the held HTLC's neared their expiry
+	// before a decision was made, and were automatically cancelled.
+	ReviewExpired
+)
+
+// String returns a human readable name of the ReviewOutcome.
+func (r ReviewOutcome) String() string {
+	switch r {
+	case ReviewApproved:
+		return "Approved"
+	case ReviewRejected:
+		return "Rejected"
+	case ReviewExpired:
+		return "Expired"
+	default:
+		return "Unknown"
+	}
+}
+
+// PendingReview describes a payment to an invoice requiring review whose
+// HTLC's are held by the exit hop awaiting an operator's decision.
+type PendingReview struct {
+	// PaymentHash is the payment hash of the invoice.
+	PaymentHash chainhash.Hash
+
+	// Amount is the total amount of the held HTLC's.
+	Amount lnwire.MilliSatoshi
+
+	// NumHTLCs is the number of held HTLC's.
+	NumHTLCs int
+
+	// AcceptTime is the time at which the first HTLC was held.
+	AcceptTime time.Time
+
+	// RemainingTime is an estimate of the time remaining until the held
+	// HTLC's are automatically rejected due to nearing their expiry.
+	RemainingTime time.Duration
+}
+
+// ReviewAuditEntry records the decision made for a single review.
+type ReviewAuditEntry struct {
+	// PaymentHash is the payment hash of the invoice.
+	PaymentHash chainhash.Hash
+
+	// Amount is the total amount of the HTLC's held at the time of the
+	// decision.
+	Amount lnwire.MilliSatoshi
+
+	// Outcome is the outcome of the review.
+	Outcome ReviewOutcome
+
+	// Reviewer identifies the operator who made the decision. It's empty
+	// for expired reviews.
+	Reviewer string
+
+	// AcceptTime is the time at which the first HTLC was held.
+	AcceptTime time.Time
+
+	// DecisionTime is the time at which the decision was made.
+	DecisionTime time.Time
+}
+
+// pendingReview tracks a review awaiting an operator's decision.
+type pendingReview struct {
+	preimage   [32]byte
+	registry   InvoiceDatabase
+	acceptTime time.Time
+}
+
+// holdForReview holds the passed HTLC paying to an invoice requiring review,
+// registering a hold resolver for its payment hash if needed. Once the first
+// HTLC for the invoice is held, the ReviewNotifier is notified. The preimage
+// and registry are used to settle the invoice once approved. False is
+// returned if the HTLC couldn't be held, in which case it should be failed.
+func (s *Switch) holdForReview(htlc *heldHTLC, preimage [32]byte,
+	registry InvoiceDatabase) bool {
+
+	hash := htlc.paymentHash
+
+	s.reviewMtx.Lock()
+	_, exists := s.reviews[hash]
+	if !exists {
+		s.reviews[hash] = &pendingReview{
+			preimage:   preimage,
+			registry:   registry,
+			acceptTime: time.Now(),
+		}
+	}
+	s.reviewMtx.Unlock()
+
+	s.RegisterHoldResolver(hash)
+	s.setHoldResolverKind(hash, HoldResolverReview)
+	if !s.holdHTLC(htlc) {
+		return false
+	}
+
+	if exists || s.cfg.ReviewNotifier == nil {
+		return true
+	}
+
+	for _, review := range s.PendingReviews() {
+		if review.PaymentHash == hash {
+			go s.cfg.ReviewNotifier(review)
+			break
+		}
+	}
+
+	return true
+}
+
+// PendingReviews returns the payments to invoices requiring review which are
+// awaiting an operator's decision, ordered by their remaining time, such that
+// those closest to being automatically rejected are returned first.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) PendingReviews() []PendingReview {
+	s.reviewMtx.Lock()
+	acceptTimes := make(map[chainhash.Hash]time.Time, len(s.reviews))
+	for hash, review := range s.reviews {
+		acceptTimes[hash] = review.acceptTime
+	}
+	s.reviewMtx.Unlock()
+
+	var reviews []PendingReview
+	for _, set := range s.HeldHTLCs() {
+		acceptTime, ok := acceptTimes[set.PaymentHash]
+		if !ok {
+			continue
+		}
+
+		review := PendingReview{
+			PaymentHash:   set.PaymentHash,
+			NumHTLCs:      len(set.HTLCs),
+			AcceptTime:    acceptTime,
+			RemainingTime: set.RemainingTime(),
+		}
+		for _, htlc := range set.HTLCs {
+			review.Amount += htlc.Amount
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	return reviews
+}
+
+// ApproveReview approves the payment to the invoice with the passed payment
+// hash on behalf of the passed reviewer, settling the held HTLC's and the
+// invoice. ErrNoPendingReview is returned if no review is pending for the
+// hash.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) ApproveReview(hash chainhash.Hash, reviewer string) error {
+	review, amt, err := s.decideReview(hash, ReviewApproved, reviewer)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Review of hash(%x) for %v approved by %v", hash[:], amt,
+		reviewer)
+
+	if err := review.registry.SettleInvoice(hash); err != nil {
+		log.Errorf("Unable to settle reviewed invoice(%x): %v",
+			hash[:], err)
+		return err
+	}
+
+	return nil
+}
+
+// RejectReview rejects the payment to the invoice with the passed payment
+// hash on behalf of the passed reviewer, cancelling the held HTLC's.
+// ErrNoPendingReview is returned if no review is pending for the hash.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) RejectReview(hash chainhash.Hash, reviewer string) error {
+	_, amt, err := s.decideReview(hash, ReviewRejected, reviewer)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Review of hash(%x) for %v rejected by %v", hash[:], amt,
+		reviewer)
+
+	return nil
+}
+
+// expireReview records the automatic rejection of the review for the passed
+// payment hash, if any, once its held HTLC's have been cancelled due to
+// nearing their expiry.
+func (s *Switch) expireReview(hash chainhash.Hash) {
+	s.reviewMtx.Lock()
+	review, ok := s.reviews[hash]
+	delete(s.reviews, hash)
+	s.reviewMtx.Unlock()
+	if !ok {
+		return
+	}
+
+	log.Warnf("Review of hash(%x) expired, held htlcs cancelled", hash[:])
+
+	s.recordReview(ReviewAuditEntry{
+		PaymentHash:  hash,
+		Outcome:      ReviewExpired,
+		AcceptTime:   review.acceptTime,
+		DecisionTime: time.Now(),
+	})
+}
+
+// decideReview removes the pending review for the passed payment hash, then
+// settles or cancels its held HTLC's according to the outcome, and records
+// the decision within the audit trail.
+func (s *Switch) decideReview(hash chainhash.Hash, outcome ReviewOutcome,
+	reviewer string) (*pendingReview, lnwire.MilliSatoshi, error) {
+
+	s.reviewMtx.Lock()
+	review, ok := s.reviews[hash]
+	delete(s.reviews, hash)
+	s.reviewMtx.Unlock()
+	if !ok {
+		return nil, 0, ErrNoPendingReview
+	}
+
+	var amt lnwire.MilliSatoshi
+	s.holdMtx.Lock()
+	resolver, ok := s.holdResolvers[hash]
+	s.holdMtx.Unlock()
+	if ok {
+		resolver.Lock()
+		for _, htlc := range resolver.htlcs {
+			amt += htlc.amount
+		}
+		resolver.Unlock()
+	}
+
+	var preimage *[32]byte
+	if outcome == ReviewApproved {
+		preimage = &review.preimage
+	}
+	err := s.resolveHold(hash, preimage)
+	switch {
+	// If the held HTLC's were cancelled due to nearing their expiry in
+	// the meantime, then we'll record the review as expired in place of
+	// the link, as we've already removed it.
+	case err == ErrHoldResolved:
+		s.recordReview(ReviewAuditEntry{
+			PaymentHash:  hash,
+			Amount:       amt,
+			Outcome:      ReviewExpired,
+			AcceptTime:   review.acceptTime,
+			DecisionTime: time.Now(),
+		})
+		return nil, 0, err
+
+	case err != nil:
+		return nil, 0, err
+	}
+
+	s.recordReview(ReviewAuditEntry{
+		PaymentHash:  hash,
+		Amount:       amt,
+		Outcome:      outcome,
+		Reviewer:     reviewer,
+		AcceptTime:   review.acceptTime,
+		DecisionTime: time.Now(),
+	})
+
+	return review, amt, nil
+}
+
+// recordReview appends the passed entry to the audit trail, evicting the
+// oldest entry if it's full.
+func (s *Switch) recordReview(entry ReviewAuditEntry) {
+	s.reviewMtx.Lock()
+	defer s.reviewMtx.Unlock()
+
+	if len(s.reviewAudit) == maxReviewAuditEntries {
+		s.reviewAudit = s.reviewAudit[1:]
+	}
+	s.reviewAudit = append(s.reviewAudit, entry)
+}
+
+// ReviewAudit returns the audit trail of the most recent review decisions,
+// oldest first.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) ReviewAudit() []ReviewAuditEntry {
+	s.reviewMtx.Lock()
+	defer s.reviewMtx.Unlock()
+
+	audit := make([]ReviewAuditEntry, len(s.reviewAudit))
+	copy(audit, s.reviewAudit)
+
+	return audit
+}
@@ -0,0 +1,189 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
+	"github.com/roasbeef/btcutil"
+)
+
+// sendReviewPayment adds an invoice requiring review to Bob's registry, then
+// sends a payment from Alice to Bob paying to it. The payment hash is
+// returned along with a channel over which the outcome of the payment is
+// delivered.
+func sendReviewPayment(t *testing.T,
+	n *threeHopNetwork) (chainhash.Hash, chan error) {
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount, testStartingHeight,
+		n.firstBobChannelLink)
+
+	blob, err := generateRoute(hops...)
+	if err != nil {
+		t.Fatalf("unable to generate route: %v", err)
+	}
+	invoice, htlc, err := generatePayment(amount, htlcAmt, totalTimelock,
+		blob)
+	if err != nil {
+		t.Fatalf("unable to generate payment: %v", err)
+	}
+
+	invoice.ReviewRequired = true
+	if err := n.bobServer.registry.AddInvoice(*invoice); err != nil {
+		t.Fatalf("unable to add invoice: %v", err)
+	}
+
+	paymentErr := make(chan error, 1)
+	go func() {
+		_, err := n.aliceServer.htlcSwitch.SendHTLC(
+			n.bobServer.PubKey(), htlc, newMockDeobfuscator(),
+		)
+		paymentErr <- err
+	}()
+
+	return fastsha256.Sum256(invoice.Terms.PaymentPreimage[:]), paymentErr
+}
+
+// waitPendingReview waits for the review of the passed payment hash to be
+// pending on Bob's switch.
+func waitPendingReview(t *testing.T, n *threeHopNetwork,
+	hash chainhash.Hash) PendingReview {
+
+	for i := 0; i < 100; i++ {
+		for _, review := range n.bobServer.htlcSwitch.PendingReviews() {
+			if review.PaymentHash == hash {
+				return review
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("review for hash(%x) not pending", hash[:])
+	return PendingReview{}
+}
+
+// waitPaymentResult waits for the outcome of a payment.
+func waitPaymentResult(t *testing.T, paymentErr chan error) error {
+	select {
+	case err := <-paymentErr:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("payment result not received")
+	}
+
+	return nil
+}
+
+// TestSwitchReviewWorkflow tests that HTLC's paying to an invoice requiring
+// review are held until an operator approves or rejects the payment, and
+// that each decision is recorded within the audit trail along with the
+// reviewer.
+func TestSwitchReviewWorkflow(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	bobSwitch := n.bobServer.htlcSwitch
+
+	// The first payment should be held for review, and settled along with
+	// its invoice once approved.
+	approveHash, approveErr := sendReviewPayment(t, n)
+	review := waitPendingReview(t, n, approveHash)
+	if review.NumHTLCs != 1 {
+		t.Fatalf("expected 1 held htlc, got %v", review.NumHTLCs)
+	}
+
+	select {
+	case err := <-approveErr:
+		t.Fatalf("payment completed before review: %v", err)
+	default:
+	}
+
+	invoice, err := n.bobServer.registry.LookupInvoice(approveHash)
+	if err != nil {
+		t.Fatalf("unable to lookup invoice: %v", err)
+	}
+	if invoice.Terms.Settled {
+		t.Fatalf("invoice settled before review")
+	}
+
+	if err := bobSwitch.ApproveReview(approveHash, "ops-1"); err != nil {
+		t.Fatalf("unable to approve review: %v", err)
+	}
+	if err := waitPaymentResult(t, approveErr); err != nil {
+		t.Fatalf("approved payment failed: %v", err)
+	}
+
+	invoice, err = n.bobServer.registry.LookupInvoice(approveHash)
+	if err != nil {
+		t.Fatalf("unable to lookup invoice: %v", err)
+	}
+	if !invoice.Terms.Settled {
+		t.Fatalf("invoice should be settled once approved")
+	}
+
+	// A review can only be decided once.
+	err = bobSwitch.RejectReview(approveHash, "ops-2")
+	if err != ErrNoPendingReview {
+		t.Fatalf("expected ErrNoPendingReview, got %v", err)
+	}
+
+	// The second payment should be failed back once rejected.
+	rejectHash, rejectErr := sendReviewPayment(t, n)
+	waitPendingReview(t, n, rejectHash)
+
+	if err := bobSwitch.RejectReview(rejectHash, "ops-2"); err != nil {
+		t.Fatalf("unable to reject review: %v", err)
+	}
+	if err := waitPaymentResult(t, rejectErr); err == nil {
+		t.Fatalf("rejected payment should fail")
+	}
+
+	if len(bobSwitch.PendingReviews()) != 0 {
+		t.Fatalf("no reviews should be pending")
+	}
+
+	audit := bobSwitch.ReviewAudit()
+	if len(audit) != 2 {
+		t.Fatalf("expected 2 audit entries, got %v", len(audit))
+	}
+	expected := []struct {
+		hash     chainhash.Hash
+		outcome  ReviewOutcome
+		reviewer string
+	}{
+		{approveHash, ReviewApproved, "ops-1"},
+		{rejectHash, ReviewRejected, "ops-2"},
+	}
+	for i, entry := range audit {
+		if entry.PaymentHash != expected[i].hash ||
+			entry.Outcome != expected[i].outcome ||
+			entry.Reviewer != expected[i].reviewer {
+
+			t.Fatalf("audit entry %v: expected %v by %v, got %v "+
+				"by %v", i, expected[i].outcome,
+				expected[i].reviewer, entry.Outcome,
+				entry.Reviewer)
+		}
+		if entry.Amount != review.Amount {
+			t.Fatalf("audit entry %v: expected amount %v, got %v",
+				i, review.Amount, entry.Amount)
+		}
+	}
+}
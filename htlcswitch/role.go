@@ -0,0 +1,63 @@
+package htlcswitch
+
+import (
+	"sync/atomic"
+)
+
+// ChannelRole restricts the direction in which a channel may be used by
+// forwarded HTLC's, allowing an operator to dedicate a channel to either
+// sending or receiving liquidity. Locally initiated payments, and payments
+// to us, aren't affected by the role of a channel.
+type ChannelRole uint8
+
+const (
+	// ChannelRoleBidirectional allows the channel to both source forwards
+	// and carry them out to the next hop.
+	ChannelRoleBidirectional ChannelRole = iota
+
+	// ChannelRoleSendOnly only allows the channel to carry forwards out
+	// to the next hop. Forwards arriving over it are failed back.
+	ChannelRoleSendOnly
+
+	// ChannelRoleReceiveOnly only allows the channel to source forwards,
+	// which are carried out over other channels. It's never chosen as the
+	// outgoing channel of a forward.
+	ChannelRoleReceiveOnly
+)
+
+// String returns a human readable string describing the ChannelRole.
+func (r ChannelRole) String() string {
+	switch r {
+	case ChannelRoleBidirectional:
+		return "Bidirectional"
+
+	case ChannelRoleSendOnly:
+		return "SendOnly"
+
+	case ChannelRoleReceiveOnly:
+		return "ReceiveOnly"
+
+	default:
+		return "unknown role"
+	}
+}
+
+// canSource returns true if forwards arriving over a channel with the role
+// may be accepted.
+func (r ChannelRole) canSource() bool {
+	return r != ChannelRoleSendOnly
+}
+
+// canCarry returns true if a channel with the role may be chosen as the
+// outgoing channel of a forward.
+func (r ChannelRole) canCarry() bool {
+	return r != ChannelRoleReceiveOnly
+}
+
+// ChannelRole returns the role of the link's channel, which restricts the
+// direction in which it may be used by forwarded HTLC's.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) ChannelRole() ChannelRole {
+	return ChannelRole(atomic.LoadUint32(&l.role))
+}
@@ -0,0 +1,159 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchChannelRoles tests that forwards arriving over a send-only channel
+// are failed back, and that receive-only channels aren't chosen as the
+// outgoing channel of a forward.
+func TestSwitchChannelRoles(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	s.Start()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	var htlcID uint64
+	assertForward := func(forwarded bool) {
+		preimage := [sha256.Size]byte{byte(htlcID)}
+		rhash := fastsha256.Sum256(preimage[:])
+		packet := &htlcPacket{
+			incomingChanID: aliceChannelLink.ShortChanID(),
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChannelLink.ShortChanID(),
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1,
+			},
+		}
+		htlcID++
+
+		err := s.forward(packet)
+		if forwarded && err != nil {
+			t.Fatalf("unable to forward htlc: %v", err)
+		}
+		if !forwarded && err == nil {
+			t.Fatalf("expected forward to be denied")
+		}
+
+		target := bobChannelLink.packets
+		if !forwarded {
+			target = aliceChannelLink.packets
+		}
+		select {
+		case <-target:
+		case <-time.After(time.Second):
+			t.Fatalf("packet wasn't delivered")
+		}
+	}
+
+	// A receive-only channel can't carry the forward out.
+	bobChannelLink.role = ChannelRoleReceiveOnly
+	assertForward(false)
+
+	// A send-only channel can't source the forward.
+	bobChannelLink.role = ChannelRoleBidirectional
+	aliceChannelLink.role = ChannelRoleSendOnly
+	assertForward(false)
+
+	// Once Alice's channel is receive-only and Bob's is send-only, the
+	// forward is permitted.
+	aliceChannelLink.role = ChannelRoleReceiveOnly
+	bobChannelLink.role = ChannelRoleSendOnly
+	assertForward(true)
+}
+
+// TestChannelLinkRoleUpdate tests that the role of a link can be changed via
+// its directional policy, and that changed roles are persisted.
+func TestChannelLinkRoleUpdate(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	stored := make(chan ChannelRole, 1)
+	aliceLink.cfg.StoreRole = func(role ChannelRole) error {
+		stored <- role
+		return nil
+	}
+
+	assertRole := func(expected ChannelRole) {
+		policy := aliceLink.CurrentForwardingPolicy()
+		if policy.Role == nil || *policy.Role != expected {
+			t.Fatalf("expected role %v, got %v", expected,
+				policy.Role)
+		}
+		if aliceLink.ChannelRole() != expected {
+			t.Fatalf("expected role %v, got %v", expected,
+				aliceLink.ChannelRole())
+		}
+	}
+	assertRole(ChannelRoleBidirectional)
+
+	role := ChannelRoleSendOnly
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Role: &role,
+	})
+	if err != nil {
+		t.Fatalf("unable to update role: %v", err)
+	}
+	assertRole(ChannelRoleSendOnly)
+
+	select {
+	case storedRole := <-stored:
+		if storedRole != ChannelRoleSendOnly {
+			t.Fatalf("expected stored role %v, got %v",
+				ChannelRoleSendOnly, storedRole)
+		}
+	default:
+		t.Fatalf("role wasn't stored")
+	}
+
+	// Updating the fee alone should leave the role untouched, and not
+	// store it again.
+	aliceLink.UpdateForwardingPolicy(ForwardingPolicy{BaseFee: 1000})
+	assertRole(ChannelRoleSendOnly)
+	select {
+	case storedRole := <-stored:
+		t.Fatalf("unexpected stored role %v", storedRole)
+	default:
+	}
+
+	// An unknown role should be rejected.
+	role = ChannelRoleReceiveOnly + 1
+	err = aliceLink.UpdateDirectionalPolicy(DirectionalPolicy{
+		Role: &role,
+	})
+	if err == nil {
+		t.Fatalf("expected unknown role to be rejected")
+	}
+	assertRole(ChannelRoleSendOnly)
+}
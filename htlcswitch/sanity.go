@@ -0,0 +1,47 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// DefaultMaxHTLCAmount is the default sanity ceiling on the amount of any
+// HTLC, which no HTLC could legitimately exceed as it's the total supply of
+// bitcoin.
+var DefaultMaxHTLCAmount = lnwire.NewMSatFromSatoshis(btcutil.MaxSatoshi)
+
+// maxHTLCAmount returns the sanity ceiling on the amount of any HTLC,
+// falling back to DefaultMaxHTLCAmount if none is set.
+func (s *Switch) maxHTLCAmount() lnwire.MilliSatoshi {
+	if s.cfg.MaxHTLCAmount == 0 {
+		return DefaultMaxHTLCAmount
+	}
+
+	return s.cfg.MaxHTLCAmount
+}
+
+// failInsaneAdd fails back the passed packet with a permanent channel failure
+// if it's an add whose amount exceeds the switch's MaxHTLCAmount. Such an
+// amount can only be the result of a bug or an attack, so it's logged
+// critically. True is returned if the packet was failed.
+func (l *channelLink) failInsaneAdd(packet *htlcPacket) bool {
+	htlc, ok := packet.htlc.(*lnwire.UpdateAddHTLC)
+	if !ok {
+		return false
+	}
+
+	maxAmt := l.cfg.Switch.maxHTLCAmount()
+	if htlc.Amount <= maxAmt {
+		return false
+	}
+
+	log.Criticalf("ChannelLink(%v) failing htlc(%x) of %v from "+
+		"chan_id=%v, exceeds sanity ceiling of %v", l,
+		htlc.PaymentHash[:], htlc.Amount, packet.incomingChanID, maxAmt)
+
+	l.failDownstreamAddWith(
+		packet, htlc, &lnwire.FailPermanentChannelFailure{},
+	)
+
+	return true
+}
@@ -0,0 +1,65 @@
+package htlcswitch
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkMaxHTLCAmount tests that adds exceeding the switch's sanity
+// ceiling are failed before reaching the channel, while a large add right at
+// the ceiling is still offered.
+func TestChannelLinkMaxHTLCAmount(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	maxAmt := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	aliceLink.cfg.Switch.cfg.MaxHTLCAmount = maxAmt
+
+	var mockBlob [lnwire.OnionPacketSize]byte
+	sendHtlc := func(amt lnwire.MilliSatoshi) {
+		_, htlc, err := generatePayment(amt, amt, 5, mockBlob)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		aliceLink.HandleSwitchPacket(&htlcPacket{
+			incomingChanID: lnwire.NewShortChanIDFromInt(1),
+			htlc:           htlc,
+			obfuscator:     newMockObfuscator(),
+		})
+	}
+
+	// An absurd amount, and one just above the ceiling, should both be
+	// failed without being offered to Bob.
+	for _, amt := range []lnwire.MilliSatoshi{math.MaxInt64, maxAmt + 1} {
+		sendHtlc(amt)
+		select {
+		case msg := <-aliceMsgs:
+			t.Fatalf("htlc of %v offered: %T", amt, msg)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	// A large amount right at the ceiling should be offered as normal.
+	sendHtlc(maxAmt)
+	select {
+	case msg := <-aliceMsgs:
+		if _, ok := msg.(*lnwire.UpdateAddHTLC); !ok {
+			t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("htlc was not offered")
+	}
+}
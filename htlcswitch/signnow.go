@@ -0,0 +1,112 @@
+package htlcswitch
+
+import (
+	"github.com/go-errors/errors"
+)
+
+var (
+	// ErrNothingToSign is returned by SignCommitmentNow if there are no
+	// pending updates which aren't yet covered by a commitment.
+	ErrNothingToSign = errors.New("no pending updates to sign")
+
+	// ErrLinkBusy is returned by SignCommitmentNow if the link is
+	// currently unable to sign a new commitment, e.g. as it hasn't yet
+	// received the remote party's next revocation point, or a force close
+	// is underway.
+	ErrLinkBusy = errors.New("link unable to sign commitment")
+)
+
+// signCommitReq is sent to the htlcManager goroutine by SignCommitmentNow.
+type signCommitReq struct {
+	err chan error
+}
+
+// SignCommitmentNow signs and sends a new commitment covering all pending
+// updates right away, rather than waiting for the link's batching and pacing
+// to trigger one. If a commitment is already awaiting its revocation, then
+// the pending updates are instead signed as soon as the revocation arrives,
+// and nil is returned. ErrNothingToSign is returned if we haven't sent any
+// updates since our last commitment, and ErrLinkBusy if the link can't
+// currently sign.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) SignCommitmentNow() error {
+	req := &signCommitReq{
+		err: make(chan error, 1),
+	}
+
+	select {
+	case l.linkControl <- req:
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+
+	select {
+	case err := <-req.err:
+		return err
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+}
+
+// handleSignCommitReq signs a new commitment covering all pending updates if
+// possible, or arranges for them to be signed once the commitment in flight
+// has been revoked.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleSignCommitReq(req *signCommitReq) {
+	switch {
+	case l.inStateMismatch() || l.isPendingClose() ||
+		l.channel.RemoteNextRevocation() == nil:
+
+		req.err <- ErrLinkBusy
+		return
+
+	case l.batchCounter == 0:
+		req.err <- ErrNothingToSign
+		return
+	}
+
+	// If the remote party has yet to revoke our last commitment, then we
+	// can't sign another one until it does, so we'll coalesce the pending
+	// updates into the next round.
+	_, remoteHeight, pendingRevocation := l.channel.CommitHeights()
+	if remoteHeight != pendingRevocation {
+		log.Debugf("ChannelLink(%v) awaiting revocation, signing "+
+			"pending updates once received", l)
+
+		l.signOnRevocation = true
+		req.err <- nil
+		return
+	}
+
+	if err := l.updateCommitTx(); err != nil {
+		l.fail(DisconnectCommitmentError,
+			"unable to update commitment: %v", err)
+		req.err <- err
+		return
+	}
+
+	req.err <- nil
+}
+
+// signAfterRevocation signs a new commitment covering the pending updates if
+// an immediate signature was requested while the prior commitment was
+// awaiting its revocation.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) signAfterRevocation() {
+	if !l.signOnRevocation {
+		return
+	}
+	l.signOnRevocation = false
+
+	if l.batchCounter == 0 {
+		return
+	}
+
+	if err := l.updateCommitTx(); err != nil {
+		l.fail(DisconnectCommitmentError,
+			"unable to update commitment: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkSignCommitmentNow tests that a link signs a commitment
+// covering its pending updates as soon as requested, without waiting for the
+// batch ticker, and that requests made while awaiting a revocation are
+// coalesced into the next commitment.
+func TestChannelLinkSignCommitmentNow(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, bobChannel, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// Without any pending updates, there's nothing to sign.
+	if err := aliceLink.SignCommitmentNow(); err != ErrNothingToSign {
+		t.Fatalf("expected ErrNothingToSign, got %v", err)
+	}
+
+	receiveMsg := func() lnwire.Message {
+		select {
+		case msg := <-aliceMsgs:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not receive message from alice")
+		}
+		return nil
+	}
+
+	var mockBlob [lnwire.OnionPacketSize]byte
+	sendHtlc := func(htlcID uint64) *lnwire.UpdateAddHTLC {
+		htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+		_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		aliceLink.HandleSwitchPacket(&htlcPacket{
+			incomingChanID: lnwire.NewShortChanIDFromInt(1),
+			incomingHTLCID: htlcID,
+			htlc:           htlc,
+			amount:         htlcAmt,
+		})
+
+		add, ok := receiveMsg().(*lnwire.UpdateAddHTLC)
+		if !ok {
+			t.Fatalf("expected UpdateAddHTLC")
+		}
+		return add
+	}
+
+	assertCommitSig := func() *lnwire.CommitSig {
+		msg := receiveMsg()
+		commitSig, ok := msg.(*lnwire.CommitSig)
+		if !ok {
+			t.Fatalf("expected CommitSig, got %T", msg)
+		}
+		return commitSig
+	}
+
+	// As the batch ticker never fires, the add is only signed for once
+	// requested.
+	firstAdd := sendHtlc(0)
+	if err := aliceLink.SignCommitmentNow(); err != nil {
+		t.Fatalf("unable to sign commitment: %v", err)
+	}
+	commitSig := assertCommitSig()
+
+	// While the commitment awaits its revocation, a further request is
+	// coalesced into the next commitment.
+	secondAdd := sendHtlc(1)
+	if err := aliceLink.SignCommitmentNow(); err != nil {
+		t.Fatalf("unable to coalesce signature: %v", err)
+	}
+	select {
+	case msg := <-aliceMsgs:
+		t.Fatalf("unexpected message before revocation: %T", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := bobChannel.ReceiveHTLC(firstAdd); err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+	err = bobChannel.ReceiveNewCommitment(
+		commitSig.CommitSig, commitSig.HtlcSigs,
+	)
+	if err != nil {
+		t.Fatalf("bob failed receiving commitment: %v", err)
+	}
+	revocation, _, err := bobChannel.RevokeCurrentCommitment()
+	if err != nil {
+		t.Fatalf("unable to revoke commitment: %v", err)
+	}
+	if _, err := bobChannel.ReceiveHTLC(secondAdd); err != nil {
+		t.Fatalf("bob failed receiving htlc: %v", err)
+	}
+
+	// Once the revocation arrives, the second add is signed for right
+	// away.
+	aliceLink.HandleChannelUpdate(revocation)
+	commitSig = assertCommitSig()
+	err = bobChannel.ReceiveNewCommitment(
+		commitSig.CommitSig, commitSig.HtlcSigs,
+	)
+	if err != nil {
+		t.Fatalf("bob failed receiving commitment: %v", err)
+	}
+}
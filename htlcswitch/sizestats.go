@@ -0,0 +1,177 @@
+package htlcswitch
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// DefaultForwardSizeBuckets are the default upper bounds of the amount
+// buckets the outcomes of forwarded HTLC's are counted within, splitting them
+// into HTLC's below 1k sat, below 100k sat, below 1M sat, and all larger ones.
+var DefaultForwardSizeBuckets = []lnwire.MilliSatoshi{
+	lnwire.NewMSatFromSatoshis(1000),
+	lnwire.NewMSatFromSatoshis(100000),
+	lnwire.NewMSatFromSatoshis(1000000),
+}
+
+// SizeBucket counts the outcomes of the forwarded HTLC's whose amount fell
+// within a single amount bucket.
+type SizeBucket struct {
+	// MinAmount is the inclusive lower bound of the amounts within the
+	// bucket.
+	MinAmount lnwire.MilliSatoshi
+
+	// MaxAmount is the exclusive upper bound of the amounts within the
+	// bucket, or zero if the bucket is unbounded.
+	MaxAmount lnwire.MilliSatoshi
+
+	ForwardOutcomes
+}
+
+// forwardSizeBuckets returns the upper bounds of the amount buckets the
+// outcomes of forwarded HTLC's are counted within.
+func (s *Switch) forwardSizeBuckets() []lnwire.MilliSatoshi {
+	if s.cfg.ForwardSizeBuckets == nil {
+		return DefaultForwardSizeBuckets
+	}
+
+	return s.cfg.ForwardSizeBuckets
+}
+
+// newSizeBuckets returns a set of empty buckets delimited by the passed upper
+// bounds.
+func newSizeBuckets(bounds []lnwire.MilliSatoshi) []SizeBucket {
+	buckets := make([]SizeBucket, len(bounds)+1)
+	for i, bound := range bounds {
+		buckets[i].MaxAmount = bound
+		buckets[i+1].MinAmount = bound
+	}
+
+	return buckets
+}
+
+// sizeBucketIndex returns the index of the bucket delimited by the passed
+// upper bounds which the passed amount falls within.
+func sizeBucketIndex(bounds []lnwire.MilliSatoshi,
+	amt lnwire.MilliSatoshi) int {
+
+	return sort.Search(len(bounds), func(i int) bool {
+		return amt < bounds[i]
+	})
+}
+
+// forwardSizeOutcomes counts the outcomes of the HTLC's forwarded over a link
+// within the amount bucket of each HTLC.
+type forwardSizeOutcomes struct {
+	sync.Mutex
+
+	// outcomes are the outcomes counted within each bucket, keyed by the
+	// bucket's index.
+	outcomes map[int]*forwardOutcomes
+
+	// pending is the bucket index of each forwarded HTLC yet to be
+	// resolved by the downstream peer, keyed by its HTLC index.
+	pending map[uint64]int
+}
+
+// bucket returns the outcomes of the bucket with the passed index.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (f *forwardSizeOutcomes) bucket(idx int) *forwardOutcomes {
+	if f.outcomes == nil {
+		f.outcomes = make(map[int]*forwardOutcomes)
+	}
+	outcomes, ok := f.outcomes[idx]
+	if !ok {
+		outcomes = &forwardOutcomes{}
+		f.outcomes[idx] = outcomes
+	}
+
+	return outcomes
+}
+
+// sent records that a forwarded HTLC of the passed amount has been sent to
+// the downstream peer with the passed HTLC index.
+func (f *forwardSizeOutcomes) sent(bounds []lnwire.MilliSatoshi,
+	htlcIndex uint64, amt lnwire.MilliSatoshi) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	idx := sizeBucketIndex(bounds, amt)
+	if f.pending == nil {
+		f.pending = make(map[uint64]int)
+	}
+	f.pending[htlcIndex] = idx
+	f.bucket(idx).sent()
+}
+
+// localFailure records that we've failed an HTLC of the passed amount we
+// attempted to forward with the passed failure code.
+func (f *forwardSizeOutcomes) localFailure(bounds []lnwire.MilliSatoshi,
+	amt lnwire.MilliSatoshi, code lnwire.FailCode) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.bucket(sizeBucketIndex(bounds, amt)).localFailure(code)
+}
+
+// resolved records that the downstream peer has settled or failed the
+// forwarded HTLC with the passed HTLC index.
+func (f *forwardSizeOutcomes) resolved(htlcIndex uint64, settled bool) {
+	f.Lock()
+	defer f.Unlock()
+
+	idx, ok := f.pending[htlcIndex]
+	if !ok {
+		return
+	}
+	delete(f.pending, htlcIndex)
+
+	f.bucket(idx).resolved(settled)
+}
+
+// snapshot returns a copy of the outcomes counted so far within the buckets
+// delimited by the passed upper bounds.
+func (f *forwardSizeOutcomes) snapshot(
+	bounds []lnwire.MilliSatoshi) []SizeBucket {
+
+	f.Lock()
+	defer f.Unlock()
+
+	buckets := newSizeBuckets(bounds)
+	for idx, outcomes := range f.outcomes {
+		snapshot := outcomes.snapshot()
+		buckets[idx].add(&snapshot)
+	}
+
+	return buckets
+}
+
+// SizeBucketStats returns the outcomes of the HTLC's forwarded over all
+// current links over their lifetimes, within the amount buckets delimited by
+// the switch's ForwardSizeBuckets, ordered by amount. As the outcomes are
+// counted by each link, those of links which have since been removed aren't
+// included.
+func (s *Switch) SizeBucketStats() ([]SizeBucket, error) {
+	links, err := s.fetchAllLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := newSizeBuckets(s.forwardSizeBuckets())
+	for _, link := range links {
+		linkBuckets := link.StatsDetail().SizeBuckets
+		for i := range linkBuckets {
+			if i >= len(buckets) {
+				break
+			}
+			buckets[i].add(&linkBuckets[i].ForwardOutcomes)
+		}
+	}
+
+	return buckets, nil
+}
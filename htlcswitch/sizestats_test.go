@@ -0,0 +1,109 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchSizeBucketStats tests that the outcomes of the HTLC's Bob
+// forwards to Carol are attributed to the amount bucket of each HTLC.
+func TestSwitchSizeBucketStats(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	// pay sends a payment of the passed amount from Alice to Carol via
+	// Bob. If relayFailure is true, then Carol fails it as the amount
+	// within her payload doesn't match that of the HTLC.
+	pay := func(sat btcutil.Amount, relayFailure bool) error {
+		amount := lnwire.NewMSatFromSatoshis(sat)
+		htlcAmt, totalTimelock, hops := generateHops(amount,
+			testStartingHeight, n.firstBobChannelLink,
+			n.carolChannelLink)
+		if relayFailure {
+			hops[1].AmountToForward = 1
+		}
+
+		_, err := n.makePayment(n.aliceServer, n.carolServer,
+			n.bobServer.PubKey(), hops, amount, htlcAmt,
+			totalTimelock).Wait(30 * time.Second)
+		return err
+	}
+
+	// A small and a large payment are settled, while a medium one is
+	// failed by Carol.
+	if err := pay(500, false); err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+	if err := pay(50000, true); err == nil {
+		t.Fatalf("payment should have failed")
+	}
+	if err := pay(500000, false); err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	// A very large payment is failed by Bob himself, as it exceeds the
+	// value in flight his link with Carol carries.
+	n.secondBobChannelLink.UpdateForwardingPolicy(ForwardingPolicy{
+		MaxValueInFlight: lnwire.NewMSatFromSatoshis(1000000),
+	})
+	if err := pay(2000000, false); err == nil {
+		t.Fatalf("payment should have failed")
+	}
+
+	buckets, err := n.bobServer.htlcSwitch.SizeBucketStats()
+	if err != nil {
+		t.Fatalf("unable to fetch size bucket stats: %v", err)
+	}
+	if len(buckets) != len(DefaultForwardSizeBuckets)+1 {
+		t.Fatalf("expected %v buckets, got %v",
+			len(DefaultForwardSizeBuckets)+1, len(buckets))
+	}
+
+	code := lnwire.CodeTemporaryChannelFailure
+	expected := []struct {
+		min, max                           btcutil.Amount
+		attempts, settles, relayed, locals uint64
+	}{
+		{min: 0, max: 1000, attempts: 1, settles: 1},
+		{min: 1000, max: 100000, attempts: 1, relayed: 1},
+		{min: 100000, max: 1000000, attempts: 1, settles: 1},
+		{min: 1000000, max: 0, attempts: 1, locals: 1},
+	}
+	for i, exp := range expected {
+		bucket := buckets[i]
+		minAmt := lnwire.NewMSatFromSatoshis(exp.min)
+		maxAmt := lnwire.NewMSatFromSatoshis(exp.max)
+		if bucket.MinAmount != minAmt || bucket.MaxAmount != maxAmt {
+			t.Fatalf("bucket %v: expected range [%v, %v), got "+
+				"[%v, %v)", i, minAmt, maxAmt,
+				bucket.MinAmount, bucket.MaxAmount)
+		}
+		if bucket.Attempts != exp.attempts ||
+			bucket.Settles != exp.settles ||
+			bucket.RelayedFailures != exp.relayed ||
+			bucket.LocalFailures[code] != exp.locals {
+
+			t.Fatalf("bucket %v: expected %v attempts, %v "+
+				"settles, %v relayed and %v local failures, "+
+				"got %+v", i, exp.attempts, exp.settles,
+				exp.relayed, exp.locals, bucket.ForwardOutcomes)
+		}
+	}
+}
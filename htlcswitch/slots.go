@@ -0,0 +1,45 @@
+package htlcswitch
+
+// DefaultMinFreeSlots is the default number of HTLC slots of a channel
+// reserved for locally initiated payments.
+const DefaultMinFreeSlots uint16 = 2
+
+// minFreeSlots returns the number of HTLC slots reserved for locally
+// initiated payments, falling back to DefaultMinFreeSlots if none is set.
+func (l *channelLink) minFreeSlots() uint16 {
+	if l.cfg.MinFreeSlots == 0 {
+		return DefaultMinFreeSlots
+	}
+
+	return l.cfg.MinFreeSlots
+}
+
+// freeSlots returns the number of HTLC's we can currently offer over the link
+// before reaching the limit of the channel. Our HTLC's are limited by the
+// MaxAcceptedHtlcs the remote party imposed upon us, held within our local
+// channel config, which is the limit the channel state machine enforces.
+func (l *channelLink) freeSlots() uint16 {
+	maxSlots := l.channel.State().LocalChanCfg.MaxAcceptedHtlcs
+	numHTLCs := l.channel.NumOutgoingHTLCs()
+	if numHTLCs >= maxSlots {
+		return 0
+	}
+
+	return maxSlots - numHTLCs
+}
+
+// exceedsSlotReserve returns true if offering a forwarded HTLC would use one
+// of the slots reserved for locally initiated payments. Settles and fails
+// don't take up a slot, so they're never affected.
+func (l *channelLink) exceedsSlotReserve() bool {
+	free := l.freeSlots()
+	reserve := l.minFreeSlots()
+	if free > reserve {
+		return false
+	}
+
+	log.Infof("ChannelLink(%v) rejecting forward, %v free htlc slot(s) "+
+		"left with %v reserved for local payments", l, free, reserve)
+
+	return true
+}
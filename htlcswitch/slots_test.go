@@ -0,0 +1,141 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkMinFreeSlots tests that a link rejects forwards once its
+// free HTLC slots drop to the reserve, while locally initiated payments may
+// still use the reserved slots.
+func TestChannelLinkMinFreeSlots(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// We'll reserve all but one of the channel's slots, such that a
+	// single forward may be offered before reaching the reserve.
+	maxSlots := aliceLink.channel.State().LocalChanCfg.MaxAcceptedHtlcs
+	aliceLink.cfg.MinFreeSlots = maxSlots - 1
+
+	if free := aliceLink.BandwidthDetail().FreeSlots; free != maxSlots {
+		t.Fatalf("expected %v free slots, got %v", maxSlots, free)
+	}
+
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	incomingChanID := lnwire.NewShortChanIDFromInt(1)
+
+	var (
+		mockBlob       [lnwire.OnionPacketSize]byte
+		incomingHTLCID uint64
+	)
+	sendHtlc := func(forward bool) {
+		_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+
+		pkt := &htlcPacket{htlc: htlc}
+		if forward {
+			pkt.incomingChanID = incomingChanID
+			pkt.incomingHTLCID = incomingHTLCID
+			pkt.obfuscator = newMockObfuscator()
+			incomingHTLCID++
+		}
+		aliceLink.HandleSwitchPacket(pkt)
+	}
+	assertOffered := func(offered bool) {
+		select {
+		case msg := <-aliceMsgs:
+			if !offered {
+				t.Fatalf("expected no message, got %T", msg)
+			}
+			if _, ok := msg.(*lnwire.UpdateAddHTLC); !ok {
+				t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+			}
+		case <-time.After(500 * time.Millisecond):
+			if offered {
+				t.Fatalf("htlc was not offered")
+			}
+		}
+	}
+
+	// The first forward leaves the free slots above the reserve, so it
+	// should be offered.
+	sendHtlc(true)
+	assertOffered(true)
+
+	if free := aliceLink.BandwidthDetail().FreeSlots; free != maxSlots-1 {
+		t.Fatalf("expected %v free slots, got %v", maxSlots-1, free)
+	}
+
+	// Now that the free slots have dropped to the reserve, the next
+	// forward should be rejected.
+	sendHtlc(true)
+	assertOffered(false)
+
+	// A locally initiated payment may use the reserved slots.
+	sendHtlc(false)
+	assertOffered(true)
+
+	if free := aliceLink.BandwidthDetail().FreeSlots; free != maxSlots-2 {
+		t.Fatalf("expected %v free slots, got %v", maxSlots-2, free)
+	}
+}
+
+// TestChannelLinkHasFreeSlot tests that a link only reports a free slot while
+// its outgoing HTLC's are below the limit imposed by the remote party.
+func TestChannelLinkHasFreeSlot(t *testing.T) {
+	t.Parallel()
+
+	const chanAmt = btcutil.SatoshiPerBitcoin * 5
+	link, _, _, cleanUp, err := newSingleLinkTestHarness(chanAmt, 0)
+	if err != nil {
+		t.Fatalf("unable to create link: %v", err)
+	}
+	defer cleanUp()
+
+	aliceLink := link.(*channelLink)
+	aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+	// We'll limit the channel to a single outgoing HTLC.
+	aliceLink.channel.State().LocalChanCfg.MaxAcceptedHtlcs = 1
+
+	if !aliceLink.HasFreeSlot() {
+		t.Fatalf("link should have a free slot")
+	}
+
+	var mockBlob [lnwire.OnionPacketSize]byte
+	htlcAmt := lnwire.NewMSatFromSatoshis(10000)
+	_, htlc, err := generatePayment(htlcAmt, htlcAmt, 5, mockBlob)
+	if err != nil {
+		t.Fatalf("unable to create payment: %v", err)
+	}
+	aliceLink.HandleSwitchPacket(&htlcPacket{htlc: htlc})
+
+	select {
+	case msg := <-aliceMsgs:
+		if _, ok := msg.(*lnwire.UpdateAddHTLC); !ok {
+			t.Fatalf("expected UpdateAddHTLC, got %T", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("htlc was not offered")
+	}
+
+	// With the only slot taken, the link should report that it's full,
+	// even though its overflow queue is empty.
+	if aliceLink.HasFreeSlot() {
+		t.Fatalf("link shouldn't have a free slot")
+	}
+}
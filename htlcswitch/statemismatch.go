@@ -0,0 +1,204 @@
+package htlcswitch
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// StateMismatch describes how the commitment state reported by the remote
+// peer upon channel reestablishment diverges from our own.
+type StateMismatch uint8
+
+const (
+	// StateMismatchRemoteBehind indicates that the remote peer reported
+	// commitment heights below those we know they've reached, suggesting
+	// they've lost data.
+	StateMismatchRemoteBehind StateMismatch = iota
+
+	// StateMismatchLocalBehind indicates that the remote peer reported
+	// commitment heights above our own, suggesting that we've lost data
+	// and that our latest commitment may already be revoked.
+	StateMismatchLocalBehind
+
+	// StateMismatchIrreconcilable indicates that the state reported by
+	// the remote peer can't be reconciled with our own, e.g. as it
+	// included an invalid commitment secret.
+	StateMismatchIrreconcilable
+)
+
+// String returns a human readable string describing the StateMismatch.
+func (m StateMismatch) String() string {
+	switch m {
+	case StateMismatchRemoteBehind:
+		return "RemoteBehind"
+
+	case StateMismatchLocalBehind:
+		return "LocalBehind"
+
+	case StateMismatchIrreconcilable:
+		return "Irreconcilable"
+
+	default:
+		return "unknown mismatch"
+	}
+}
+
+// stateMismatchError is returned by syncChanStates if the remote peer's
+// ChannelReestablish message reveals that our commitment states have
+// diverged.
+type stateMismatchError struct {
+	mismatch StateMismatch
+	err      error
+}
+
+// Error returns a human readable string describing the mismatch.
+func (e *stateMismatchError) Error() string {
+	return fmt.Sprintf("commitment state mismatch (%v): %v", e.mismatch,
+		e.err)
+}
+
+// classifyStateMismatch determines the kind of state mismatch signalled by
+// the passed error returned from processing the remote peer's
+// ChannelReestablish message. The heights within both our own and the remote
+// peer's message are compared to determine which party is behind. False is
+// returned if the error doesn't signal a state mismatch.
+func classifyStateMismatch(local, remote *lnwire.ChannelReestablish,
+	err error) (StateMismatch, bool) {
+
+	switch err {
+	case lnwallet.ErrCommitSyncDataLoss:
+		return StateMismatchLocalBehind, true
+
+	case lnwallet.ErrInvalidLastCommitSecret:
+		return StateMismatchIrreconcilable, true
+
+	case lnwallet.ErrCannotSyncCommitChains:
+
+	default:
+		return 0, false
+	}
+
+	// The remote peer may lag a single state behind our local commitment
+	// tip, as we may owe them a revocation, and our view of their tail
+	// may lag a single state behind their tip, as they may owe us one.
+	localTip := local.NextLocalCommitHeight - 1
+	remoteBehind := remote.RemoteCommitTailHeight+1 < localTip ||
+		remote.NextLocalCommitHeight < local.RemoteCommitTailHeight+1
+	localBehind := remote.RemoteCommitTailHeight > localTip ||
+		remote.NextLocalCommitHeight > local.RemoteCommitTailHeight+2
+
+	switch {
+	case remoteBehind && !localBehind:
+		return StateMismatchRemoteBehind, true
+
+	case localBehind && !remoteBehind:
+		return StateMismatchLocalBehind, true
+
+	default:
+		return StateMismatchIrreconcilable, true
+	}
+}
+
+// inStateMismatch returns true if the link has detected that its commitment
+// state has diverged from that of the remote peer.
+func (l *channelLink) inStateMismatch() bool {
+	return atomic.LoadInt32(&l.stateMismatch) == 1
+}
+
+// enterStateMismatch marks the link as having diverged from the remote peer's
+// commitment state, rendering it ineligible to forward, and notifies the
+// operator.
+func (l *channelLink) enterStateMismatch(mErr *stateMismatchError) {
+	atomic.StoreInt32(&l.stateMismatch, 1)
+
+	log.Criticalf("ChannelLink(%v) detected %v, refusing to forward or "+
+		"sign any further updates until the channel is recovered "+
+		"or closed", l, mErr)
+
+	if l.cfg.OnStateMismatch != nil {
+		go l.cfg.OnStateMismatch(mErr.mismatch, mErr.err)
+	}
+}
+
+// stateMismatchHandler replaces the regular htlcManager loop once the link has
+// detected a state mismatch. As our latest commitment may already be revoked,
+// the link won't process any updates from the remote peer or sign any new
+// commitments. New HTLC's from the switch are failed back, while preimages of
+// settled HTLC's are retained so they can be claimed on-chain.
+//
+// NOTE: This MUST be run as part of the htlcManager goroutine.
+func (l *channelLink) stateMismatchHandler() {
+	for {
+		select {
+		case <-l.cfg.ChainEvents.UnilateralClosure:
+			log.Warnf("Remote peer has closed ChannelPoint(%v) "+
+				"on-chain", l.channel.ChannelPoint())
+
+			go func() {
+				chanPoint := l.channel.ChannelPoint()
+				err := l.cfg.Peer.WipeChannel(chanPoint)
+				if err != nil {
+					log.Errorf("unable to wipe channel %v",
+						err)
+				}
+			}()
+
+			return
+
+		case pkt := <-l.overflowQueue.outgoingPkts:
+			l.failDownstreamAdd(
+				pkt, pkt.htlc.(*lnwire.UpdateAddHTLC),
+			)
+
+		case pkt := <-l.downstream:
+			switch htlc := pkt.htlc.(type) {
+			case *lnwire.UpdateAddHTLC:
+				l.failDownstreamAdd(pkt, htlc)
+
+			case *lnwire.UpdateFulfillHTLC:
+				err := l.cfg.PreimageCache.AddPreimage(
+					htlc.PaymentPreimage[:],
+				)
+				if err != nil {
+					log.Errorf("unable to add preimage=%x "+
+						"to cache: %v",
+						htlc.PaymentPreimage[:], err)
+				}
+
+			default:
+				log.Warnf("ChannelLink(%v) dropping %T for "+
+					"htlc index %v due to state mismatch",
+					l, htlc, pkt.incomingHTLCID)
+			}
+
+		case msg := <-l.upstream:
+			log.Warnf("ChannelLink(%v) ignoring %T from peer due "+
+				"to state mismatch", l, msg)
+
+		case cmd := <-l.linkControl:
+			switch req := cmd.(type) {
+			case *policyUpdate:
+				req.err <- l.handlePolicyUpdate(req.policy)
+
+			case *policyQuery:
+				req.resp <- l.currentPolicy()
+
+			case *firstHopQuoteReq:
+				req.resp <- l.quoteFirstHop(req.amt)
+
+			case *resumeReq:
+				req.err <- ErrInvalidQuiescenceToken
+
+			default:
+				log.Warnf("ChannelLink(%v) ignoring %T due "+
+					"to state mismatch", l, req)
+			}
+
+		case <-l.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package htlcswitch
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/chainntnfs"
+	"github.com/lightningnetwork/lnd/contractcourt"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestClassifyStateMismatch ensures that errors returned when processing the
+// remote peer's ChannelReestablish message are mapped to the correct kind of
+// state mismatch.
+func TestClassifyStateMismatch(t *testing.T) {
+	t.Parallel()
+
+	// Our local commitment tip is at height 10, and the remote peer's
+	// commitment tail, from our point of view, is at height 10.
+	local := &lnwire.ChannelReestablish{
+		NextLocalCommitHeight:  11,
+		RemoteCommitTailHeight: 10,
+	}
+
+	tests := []struct {
+		name     string
+		remote   *lnwire.ChannelReestablish
+		err      error
+		mismatch StateMismatch
+		ok       bool
+	}{
+		{
+			name: "unrelated error",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  11,
+				RemoteCommitTailHeight: 10,
+			},
+			err: io.EOF,
+			ok:  false,
+		},
+		{
+			name: "data loss",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  11,
+				RemoteCommitTailHeight: 20,
+			},
+			err:      lnwallet.ErrCommitSyncDataLoss,
+			mismatch: StateMismatchLocalBehind,
+			ok:       true,
+		},
+		{
+			name: "invalid commit secret",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  11,
+				RemoteCommitTailHeight: 10,
+			},
+			err:      lnwallet.ErrInvalidLastCommitSecret,
+			mismatch: StateMismatchIrreconcilable,
+			ok:       true,
+		},
+		{
+			name: "remote behind on our chain",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  11,
+				RemoteCommitTailHeight: 5,
+			},
+			err:      lnwallet.ErrCannotSyncCommitChains,
+			mismatch: StateMismatchRemoteBehind,
+			ok:       true,
+		},
+		{
+			name: "remote behind on their chain",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  6,
+				RemoteCommitTailHeight: 10,
+			},
+			err:      lnwallet.ErrCannotSyncCommitChains,
+			mismatch: StateMismatchRemoteBehind,
+			ok:       true,
+		},
+		{
+			name: "local behind",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  20,
+				RemoteCommitTailHeight: 10,
+			},
+			err:      lnwallet.ErrCannotSyncCommitChains,
+			mismatch: StateMismatchLocalBehind,
+			ok:       true,
+		},
+		{
+			name: "both behind",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  20,
+				RemoteCommitTailHeight: 5,
+			},
+			err:      lnwallet.ErrCannotSyncCommitChains,
+			mismatch: StateMismatchIrreconcilable,
+			ok:       true,
+		},
+	}
+
+	for _, test := range tests {
+		mismatch, ok := classifyStateMismatch(local, test.remote, test.err)
+		if ok != test.ok {
+			t.Fatalf("%v: expected ok=%v, got %v", test.name,
+				test.ok, ok)
+		}
+		if ok && mismatch != test.mismatch {
+			t.Fatalf("%v: expected %v, got %v", test.name,
+				test.mismatch, mismatch)
+		}
+	}
+}
+
+// stateMismatchEvent is a notification delivered by a link's OnStateMismatch
+// callback.
+type stateMismatchEvent struct {
+	mismatch StateMismatch
+	err      error
+}
+
+// TestChannelLinkStateMismatch ensures that a link which receives a
+// ChannelReestablish message revealing a state mismatch becomes ineligible to
+// forward, notifies the operator, and refrains from sending any further
+// messages to the remote peer.
+func TestChannelLinkStateMismatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		remote   *lnwire.ChannelReestablish
+		mismatch StateMismatch
+	}{
+		{
+			name: "remote behind",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  0,
+				RemoteCommitTailHeight: 0,
+			},
+			mismatch: StateMismatchRemoteBehind,
+		},
+		{
+			name: "local behind",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:  1,
+				RemoteCommitTailHeight: 5,
+			},
+			mismatch: StateMismatchLocalBehind,
+		},
+		{
+			name: "irreconcilable",
+			remote: &lnwire.ChannelReestablish{
+				NextLocalCommitHeight:     1,
+				RemoteCommitTailHeight:    1,
+				LocalUnrevokedCommitPoint: testPubKey,
+			},
+			mismatch: StateMismatchIrreconcilable,
+		},
+	}
+
+	for _, test := range tests {
+		testChannelLinkStateMismatch(t, test.name, test.remote,
+			test.mismatch)
+	}
+}
+
+func testChannelLinkStateMismatch(t *testing.T, name string,
+	remoteSyncMsg *lnwire.ChannelReestablish, expected StateMismatch) {
+
+	chanAmt := btcutil.Amount(btcutil.SatoshiPerBitcoin)
+	aliceChannel, _, cleanUp, _, err := createTestChannel(
+		alicePrivKey, bobPrivKey, chanAmt, chanAmt, 0, 0,
+		lnwire.NewShortChanIDFromInt(6),
+	)
+	if err != nil {
+		t.Fatalf("%v: unable to create channel: %v", name, err)
+	}
+	defer cleanUp()
+
+	aliceServer := newMockServer(t, "alice")
+	bobServer := newMockServer(t, "bob")
+
+	mismatches := make(chan stateMismatchEvent, 1)
+	decoder := &mockIteratorDecoder{}
+	obfuscator := newMockObfuscator()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	link := NewChannelLink(
+		ChannelLinkConfig{
+			FwrdingPolicy:     ForwardingPolicy{TimeLockDelta: 6},
+			Peer:              bobServer,
+			Switch:            aliceServer.htlcSwitch,
+			DecodeHopIterator: decoder.DecodeHopIterator,
+			DecodeOnionObfuscator: func(io.Reader) (ErrorEncrypter,
+				lnwire.FailCode) {
+				return obfuscator, lnwire.CodeNone
+			},
+			GetLastChannelUpdate: mockGetChanUpdateMessage,
+			Registry:             aliceServer.registry,
+			BlockEpochs: &chainntnfs.BlockEpochEvent{
+				Epochs: make(chan *chainntnfs.BlockEpoch),
+				Cancel: func() {},
+			},
+			FeeEstimator: &mockFeeEstimator{
+				byteFeeIn: make(chan lnwallet.SatPerVByte),
+				quit:      make(chan struct{}),
+			},
+			PreimageCache: &mockPreimageCache{
+				preimageMap: make(map[[32]byte][]byte),
+			},
+			UpdateContractSignals: func(*contractcourt.ContractSignals) error {
+				return nil
+			},
+			ChainEvents: &contractcourt.ChainEventSubscription{},
+			SyncStates:  true,
+			BatchTicker: &mockTicker{ticker.C},
+			BatchSize:   10,
+			OnStateMismatch: func(m StateMismatch, err error) {
+				mismatches <- stateMismatchEvent{m, err}
+			},
+		},
+		aliceChannel,
+		testStartingHeight,
+	)
+	if err := link.Start(); err != nil {
+		t.Fatalf("%v: unable to start link: %v", name, err)
+	}
+	defer link.Stop()
+
+	// The link should first send its own ChannelReestablish message.
+	select {
+	case msg := <-bobServer.messages:
+		if _, ok := msg.(*lnwire.ChannelReestablish); !ok {
+			t.Fatalf("%v: expected ChannelReestablish, got %T",
+				name, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%v: ChannelReestablish not sent", name)
+	}
+
+	remoteSyncMsg.ChanID = link.ChanID()
+	link.HandleChannelUpdate(remoteSyncMsg)
+
+	select {
+	case event := <-mismatches:
+		if event.mismatch != expected {
+			t.Fatalf("%v: expected %v, got %v", name, expected,
+				event.mismatch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%v: state mismatch not detected", name)
+	}
+
+	if link.EligibleToForward() {
+		t.Fatalf("%v: link shouldn't be eligible to forward", name)
+	}
+	if link.IneligibleReason() != IneligibleStateMismatch {
+		t.Fatalf("%v: expected %v, got %v", name,
+			IneligibleStateMismatch, link.IneligibleReason())
+	}
+
+	// Any further updates from the remote peer should be ignored, without
+	// the link sending anything in response.
+	link.HandleChannelUpdate(&lnwire.CommitSig{
+		ChanID: link.ChanID(),
+	})
+
+	select {
+	case msg := <-bobServer.messages:
+		t.Fatalf("%v: unexpected message sent to peer: %T", name, msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
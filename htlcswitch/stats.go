@@ -0,0 +1,337 @@
+package htlcswitch
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// overflowWaitBuckets are the upper bounds of the buckets used to aggregate
+// the amount of time HTLC's spend waiting within the overflow queue. The final
+// bucket is unbounded, and catches all samples which exceed the prior bucket.
+var overflowWaitBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	time.Duration(math.MaxInt64),
+}
+
+// HistogramBucket is a single bucket within a WaitTimeHistogram.
+type HistogramBucket struct {
+	// UpperBound is the inclusive upper bound of all samples within this
+	// bucket.
+	UpperBound time.Duration
+
+	// Count is the number of samples which fell within this bucket.
+	Count uint64
+}
+
+// WaitTimeHistogram is a snapshot of the distribution of the amount of time
+// HTLC's waited for before being processed.
+type WaitTimeHistogram struct {
+	// Buckets is the set of buckets making up the histogram, ordered by
+	// their upper bound.
+	Buckets []HistogramBucket
+
+	// Count is the total number of samples recorded.
+	Count uint64
+
+	// Total is the sum of all recorded samples.
+	Total time.Duration
+
+	// Max is the largest sample recorded.
+	Max time.Duration
+}
+
+// Mean returns the average wait time across all samples, or zero if no
+// samples have been recorded.
+func (h *WaitTimeHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	return h.Total / time.Duration(h.Count)
+}
+
+// Percentile returns an estimate of the wait time of the given percentile,
+// expressed within the range [0, 100]. As samples are aggregated into buckets,
+// the upper bound of the bucket containing the percentile is returned, capped
+// at the largest sample seen.
+func (h *WaitTimeHistogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(float64(h.Count) * p / 100))
+	if target == 0 {
+		target = 1
+	}
+
+	var seen uint64
+	for _, bucket := range h.Buckets {
+		seen += bucket.Count
+		if seen < target {
+			continue
+		}
+
+		if bucket.UpperBound > h.Max {
+			return h.Max
+		}
+		return bucket.UpperBound
+	}
+
+	return h.Max
+}
+
+// waitTimeRecorder is a goroutine-safe accumulator of wait time samples which
+// produces WaitTimeHistogram snapshots.
+type waitTimeRecorder struct {
+	sync.Mutex
+
+	counts []uint64
+	count  uint64
+	total  time.Duration
+	max    time.Duration
+}
+
+// newWaitTimeRecorder creates a new waitTimeRecorder using the overflow queue
+// bucket layout.
+func newWaitTimeRecorder() *waitTimeRecorder {
+	return &waitTimeRecorder{
+		counts: make([]uint64, len(overflowWaitBuckets)),
+	}
+}
+
+// Observe records a new wait time sample.
+func (r *waitTimeRecorder) Observe(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	for i, upperBound := range overflowWaitBuckets {
+		if d <= upperBound {
+			r.counts[i]++
+			break
+		}
+	}
+
+	r.count++
+	r.total += d
+	if d > r.max {
+		r.max = d
+	}
+}
+
+// Snapshot returns the current state of the recorded samples.
+func (r *waitTimeRecorder) Snapshot() WaitTimeHistogram {
+	r.Lock()
+	defer r.Unlock()
+
+	buckets := make([]HistogramBucket, len(overflowWaitBuckets))
+	for i, upperBound := range overflowWaitBuckets {
+		buckets[i] = HistogramBucket{
+			UpperBound: upperBound,
+			Count:      r.counts[i],
+		}
+	}
+
+	return WaitTimeHistogram{
+		Buckets: buckets,
+		Count:   r.count,
+		Total:   r.total,
+		Max:     r.max,
+	}
+}
+
+// LinkStatsDetail is a detailed set of statistics gathered by a channel link
+// over its lifetime.
+type LinkStatsDetail struct {
+	// NumUpdates is the number of updates to the commitment state.
+	NumUpdates uint64
+
+	// TotalSent is the total amount of milli-satoshis sent over the link.
+	TotalSent lnwire.MilliSatoshi
+
+	// TotalReceived is the total amount of milli-satoshis received over
+	// the link.
+	TotalReceived lnwire.MilliSatoshi
+
+	// LocalCommitHeight is the height of the tip of our commitment chain.
+	LocalCommitHeight uint64
+
+	// RemoteCommitHeight is the height of the tip of the remote party's
+	// commitment chain.
+	RemoteCommitHeight uint64
+
+	// PendingRevocationHeight is the height of the next remote commitment
+	// we're awaiting a revocation for.
+	PendingRevocationHeight uint64
+
+	// OverflowWaitTime is the distribution of the amount of time HTLC's
+	// spent within the overflow queue before being either committed or
+	// failed.
+	OverflowWaitTime WaitTimeHistogram
+
+	// OverflowParked is the number of HTLC's which have been placed
+	// within the overflow queue to wait for a free slot.
+	OverflowParked uint64
+
+	// OverflowExpired is the number of HTLC's which have been failed back
+	// as no slot became available within the link's MaxOverflowWait.
+	OverflowExpired uint64
+
+	// DownstreamLatency is the distribution of the amount of time the
+	// remote peer took to settle or fail the HTLC's we forwarded to it.
+	DownstreamLatency WaitTimeHistogram
+
+	// HopLatency is the distribution of the latency our node added to
+	// HTLC's received over the link and forwarded, as measured by the
+	// circuit traces completed while tracing was enabled, e.g. its p50
+	// and p99 are given by Percentile(50) and Percentile(99). See
+	// CircuitTrace.AddedLatency.
+	HopLatency WaitTimeHistogram
+
+	// ForwardOutcomes counts the outcomes of the HTLC's received over
+	// other channels and forwarded over the link.
+	ForwardOutcomes ForwardOutcomes
+
+	// SizeBuckets counts the outcomes of the HTLC's received over other
+	// channels and forwarded over the link within the amount bucket of
+	// each, as delimited by the switch's ForwardSizeBuckets.
+	SizeBuckets []SizeBucket
+
+	// MeanResolutionJitter is the mean jitter applied before propagating
+	// the settles and fails of HTLC's received over the link, as per its
+	// ForwardResolutionJitter.
+	MeanResolutionJitter time.Duration
+
+	// ExtraHTLCs counts the HTLC's added by the remote peer which didn't
+	// follow on from the remote update log as expected, by their
+	// classification.
+	ExtraHTLCs ExtraHTLCCounts
+}
+
+// LinkStatsSnapshot is an operational snapshot of a channel link's activity.
+// All activity fields are read at once, so they're consistent with each
+// other.
+type LinkStatsSnapshot struct {
+	// PubKey is the public key of the remote peer of the link.
+	PubKey [33]byte
+
+	// ShortChanID is the short channel ID of the link.
+	ShortChanID lnwire.ShortChannelID
+
+	// StartTime is the time the link was started.
+	StartTime time.Time
+
+	// LastForward is the last time an HTLC was forwarded over the link,
+	// in either direction. It's the zero time if none have been.
+	LastForward time.Time
+
+	// LastSettle is the last time an HTLC was settled over the link, in
+	// either direction. It's the zero time if none have been.
+	LastSettle time.Time
+
+	// PendingHTLCs is the number of HTLC's on the link's commitment
+	// transactions as of the last state transition.
+	PendingHTLCs int
+
+	// NumFailures is the number of HTLC's failed over the link, in either
+	// direction, since it was started.
+	NumFailures uint64
+
+	// PingLatency is the round trip time of the most recent ping to the
+	// link's peer, allowing a slow peer to be told apart from a slow
+	// channel.
+	PingLatency time.Duration
+
+	// ReserveLocked is the portion of our balance currently locked by our
+	// channel reserve, as given by ReserveInfo.
+	ReserveLocked lnwire.MilliSatoshi
+
+	// ExpiryTooSoonFailures is the number of forwards failed over the
+	// link as their expiry was too soon since it was started.
+	ExpiryTooSoonFailures uint64
+
+	// RecommendedTimeLockDelta is the TimeLockDelta last recommended for
+	// the link due to such failures, as per its ExpiryTooSoon policy, or
+	// zero if none has been.
+	RecommendedTimeLockDelta uint32
+}
+
+// linkActivity is a goroutine-safe record of a link's activity, from which
+// LinkStatsSnapshots are produced.
+type linkActivity struct {
+	sync.Mutex
+
+	startTime    time.Time
+	lastForward  time.Time
+	lastSettle   time.Time
+	pendingHTLCs int
+	numFailures  uint64
+}
+
+// start records the time the link was started.
+func (a *linkActivity) start() {
+	a.Lock()
+	a.startTime = time.Now()
+	a.Unlock()
+}
+
+// forwarded records that an HTLC has just been forwarded over the link.
+func (a *linkActivity) forwarded() {
+	a.Lock()
+	a.lastForward = time.Now()
+	a.Unlock()
+}
+
+// settled records that an HTLC has just been settled over the link.
+func (a *linkActivity) settled() {
+	a.Lock()
+	a.lastSettle = time.Now()
+	a.Unlock()
+}
+
+// failed records that an HTLC has just been failed over the link.
+func (a *linkActivity) failed() {
+	a.Lock()
+	a.numFailures++
+	a.Unlock()
+}
+
+// setPendingHTLCs records the number of HTLC's on the link's commitment
+// transactions following a state transition.
+func (a *linkActivity) setPendingHTLCs(n int) {
+	a.Lock()
+	a.pendingHTLCs = n
+	a.Unlock()
+}
+
+// snapshot returns the recorded activity within a LinkStatsSnapshot for the
+// passed peer and channel.
+func (a *linkActivity) snapshot(peer Peer,
+	shortChanID lnwire.ShortChannelID) *LinkStatsSnapshot {
+
+	a.Lock()
+	defer a.Unlock()
+
+	return &LinkStatsSnapshot{
+		PubKey:       peer.PubKey(),
+		ShortChanID:  shortChanID,
+		StartTime:    a.startTime,
+		LastForward:  a.lastForward,
+		LastSettle:   a.lastSettle,
+		PendingHTLCs: a.pendingHTLCs,
+		NumFailures:  a.numFailures,
+		PingLatency:  peer.PingLatency(),
+	}
+}
@@ -2,7 +2,9 @@ package htlcswitch
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/roasbeef/btcd/btcec"
+	"github.com/roasbeef/btcd/chaincfg/chainhash"
 
 	"github.com/go-errors/errors"
 	"github.com/lightningnetwork/lnd/contractcourt"
@@ -25,6 +28,17 @@ var (
 	// ErrChannelLinkNotFound is used when channel link hasn't been found.
 	ErrChannelLinkNotFound = errors.New("channel link not found")
 
+	// ErrMaxLinksPerPeer is returned when attempting to add a link for a
+	// peer which already has the maximum number of links permitted by the
+	// switch's MaxLinksPerPeer config value.
+	ErrMaxLinksPerPeer = errors.New("peer has reached the maximum " +
+		"number of channel links")
+
+	// DefaultHTLCClearTimeout is the default amount of time we'll wait
+	// for a link to resolve all of its outstanding HTLC's before handing
+	// it off for cooperative closure.
+	DefaultHTLCClearTimeout = time.Minute
+
 	// zeroPreimage is the empty preimage which is returned when we have
 	// some errors.
 	zeroPreimage [sha256.Size]byte
@@ -44,6 +58,17 @@ type pendingPayment struct {
 	// an error, it deobfuscates the onion failure blob, and extracts the
 	// exact error from it.
 	deobfuscator ErrorDecrypter
+
+	// destNode, htlc and pinned describe the payment as it was
+	// dispatched, such that it can be failed over to another link should
+	// it fail at the first hop. tried is the set of links it has been
+	// dispatched over, and failovers the number of times it has been
+	// failed over.
+	destNode  [33]byte
+	htlc      lnwire.UpdateAddHTLC
+	pinned    bool
+	tried     map[lnwire.ShortChannelID]struct{}
+	failovers int
 }
 
 // plexPacket encapsulates switch packet and adds error channel to receive
@@ -103,6 +128,173 @@ type Config struct {
 	// forced unilateral closure of the channel initiated by a local
 	// subsystem.
 	LocalChannelClose func(pubKey []byte, request *ChanClose)
+
+	// HTLCClearTimeout is the maximum amount of time we'll wait for a
+	// link to resolve all of its outstanding HTLC's before a cooperative
+	// close is initiated. If the link can't be cleared in time, then the
+	// close request fails and the link resumes accepting HTLC's, and the
+	// channel should be force closed instead. If zero, then
+	// DefaultHTLCClearTimeout is used.
+	HTLCClearTimeout time.Duration
+
+	// MaxLinksPerPeer is the maximum number of links the switch will
+	// register for a single peer. Once reached, any attempt to add a new
+	// link for that peer fails with ErrMaxLinksPerPeer. If zero, then the
+	// number of links per peer is unlimited.
+	MaxLinksPerPeer uint32
+
+	// LocalPaymentsBypassWarmUp, if true, allows locally initiated
+	// payments to be sent over links which are still warming up after a
+	// reconnection. Otherwise, if all links to the first hop are warming
+	// up, then SendHTLC waits for the first of them to warm up.
+	LocalPaymentsBypassWarmUp bool
+
+	// WarmUpPeriod is the maximum duration SendHTLC waits for a link to
+	// the first hop to warm up, which should match the WarmUpPeriod of the
+	// links. If zero, then it waits until one of the links has warmed up
+	// or been stopped.
+	WarmUpPeriod time.Duration
+
+	// ChainSync, if non-nil, reports whether the node is synced to the
+	// tip of the chain. While it isn't, all links are ineligible to
+	// forward, and new forwards are failed back, while HTLC's already in
+	// flight are still resolved.
+	ChainSync ChainSyncSource
+
+	// FailLocalPaymentsUnsynced, if true, fails locally initiated
+	// payments with ErrChainNotSynced while the node isn't synced to the
+	// chain. Otherwise, SendHTLC waits until it's synced.
+	FailLocalPaymentsUnsynced bool
+
+	// ResolutionStore, if non-nil, durably stores the settles and fails
+	// of forwarded HTLC's received from their outgoing link until the
+	// incoming link has committed to them. Any resolutions remaining
+	// after a restart are replayed to the incoming link once it's added.
+	ResolutionStore ResolutionStore
+
+	// MaxCircuitsPerIncomingChannel is the maximum number of pending
+	// circuits a single incoming channel may source. Once reached, any
+	// further forwards arriving over that channel are failed back with a
+	// temporary channel failure until some of its circuits are resolved.
+	// This limit is independent of the number of HTLC slots of the
+	// outgoing links. If zero, then the number of circuits per incoming
+	// channel is unlimited.
+	MaxCircuitsPerIncomingChannel uint32
+
+	// ReissueChannelUpdate, if non-nil, is called once the short channel
+	// ID of a link has been migrated following a re-org, in order to sign
+	// and broadcast a channel_update referencing the new short channel
+	// ID.
+	ReissueChannelUpdate func(lnwire.ChannelID, lnwire.ShortChannelID)
+
+	// ForwardVolumeLimit caps the total value of settled forwards across
+	// all channels within each window. Forwards which would exceed it are
+	// failed back with a temporary channel failure until the window rolls
+	// over. The zero value disables the limit.
+	ForwardVolumeLimit VolumeLimit
+
+	// DecisionHistorySize is the number of the most recent forwarding
+	// decisions retained for debugging, which can be inspected via
+	// RecentDecisions. If zero, then DefaultDecisionHistorySize is used.
+	// It's capped at MaxDecisionHistorySize.
+	DecisionHistorySize int
+
+	// MaintenanceWindows are the weekly recurring windows during which
+	// all new forwards are declined with a channel disabled failure,
+	// while in-flight HTLC's are left to resolve. Locally initiated
+	// payments are exempt.
+	MaintenanceWindows []MaintenanceWindow
+
+	// FlowBucketSize is the duration of the buckets the HTLC flow over
+	// each channel is accumulated within, as exposed by FlowTimeSeries.
+	// If zero, then DefaultFlowBucketSize is used.
+	FlowBucketSize time.Duration
+
+	// FlowBuckets is the number of buckets of HTLC flow retained for each
+	// channel. If zero, then DefaultFlowBuckets is used.
+	FlowBuckets int
+
+	// ForwardSizeBuckets are the upper bounds of the amount buckets the
+	// outcomes of forwarded HTLC's are counted within, as exposed by
+	// SizeBucketStats. They MUST be strictly increasing, and the final
+	// bucket is unbounded. If nil, then DefaultForwardSizeBuckets are
+	// used.
+	ForwardSizeBuckets []lnwire.MilliSatoshi
+
+	// SettledSetLifetime is the duration for which the preimage of a set
+	// of HTLC's parked by a hold resolver is retained once the set has
+	// been settled, such that any shards of the set arriving late are
+	// settled rather than failed. If zero, then DefaultSettledSetLifetime
+	// is used.
+	SettledSetLifetime time.Duration
+
+	// ForwardPolicyPlugins are consulted in turn for every HTLC to be
+	// forwarded once it has passed the switch's own checks, each of them
+	// being able to fail it back.
+	ForwardPolicyPlugins []ForwardPolicyPlugin
+
+	// PluginTimeout is the time each of the ForwardPolicyPlugins is given
+	// to decide upon a forward. As plugins are consulted from within the
+	// switch's main event loop, it should be short. If zero, then
+	// DefaultPluginTimeout is used.
+	PluginTimeout time.Duration
+
+	// PluginFallback is the decision applied in place of that of a
+	// ForwardPolicyPlugin which panics or times out. The zero value
+	// accepts the forward.
+	PluginFallback PluginDecision
+
+	// WatchdogInterval is the interval at which the switch's link
+	// watchdog sends a heartbeat to each link, checking that its main
+	// goroutine is still processing requests. If zero, then the watchdog
+	// is disabled.
+	WatchdogInterval time.Duration
+
+	// WatchdogTimeout is the time a link has to acknowledge a heartbeat
+	// before it's considered wedged, and the WatchdogAction is taken. If
+	// zero, then DefaultWatchdogTimeout is used.
+	WatchdogTimeout time.Duration
+
+	// WatchdogAction is the action taken once a link fails to acknowledge
+	// a heartbeat in time. The zero value only logs the link.
+	WatchdogAction WatchdogAction
+
+	// BandwidthBuffer is the amount by which the bandwidth of a link to
+	// the next peer should exceed a forward for the link to be preferred.
+	// Links within the buffer are only used if no other link to the peer
+	// can carry the forward. If zero, then the first link with enough
+	// bandwidth is used.
+	BandwidthBuffer lnwire.MilliSatoshi
+
+	// OraclePollInterval is the interval at which an OracleResolver
+	// consults its oracle while HTLC's are parked awaiting its decision.
+	// If zero, then DefaultOraclePollInterval is used.
+	OraclePollInterval time.Duration
+
+	// ReviewNotifier, if non-nil, is called once the first HTLC paying
+	// to an invoice requiring review has been held, such that an
+	// operator can approve or reject the payment.
+	ReviewNotifier func(PendingReview)
+
+	// MaxHTLCAmount is the sanity ceiling on the amount of any HTLC
+	// offered over our links, whether forwarded or locally initiated.
+	// HTLC's exceeding it are failed back with a permanent channel
+	// failure before reaching the channel's state machine. If zero, then
+	// DefaultMaxHTLCAmount is used.
+	MaxHTLCAmount lnwire.MilliSatoshi
+
+	// LocalPaymentFailovers is the number of times a local payment which
+	// fails at the link it was dispatched over, before reaching the first
+	// hop, is resent over another channel to the same peer before its
+	// failure is returned. Failures further along the route aren't failed
+	// over, as they call for a new route. If zero, then local payments
+	// aren't failed over.
+	LocalPaymentFailovers int
+
+	// ReputationPolicy governs the shedding of forwards from low
+	// reputation peers once their outgoing link is under pressure. The
+	// zero value disables shedding.
+	ReputationPolicy ReputationPolicy
 }
 
 // Switch is the central messaging bus for all incoming/outgoing HTLCs.
@@ -114,6 +306,19 @@ type Config struct {
 // HTLCs, forwarding HTLCs initiated from within the daemon, and finally
 // notifies users local-systems concerning their outstanding payment requests.
 type Switch struct {
+	// nextCorrelationID is the last correlation ID assigned to a forward.
+	// It's accessed atomically, and so must remain 64-bit aligned.
+	nextCorrelationID uint64
+
+	// localFailovers is the number of times a local payment has been
+	// failed over to another link. It's accessed atomically, and so must
+	// remain 64-bit aligned.
+	localFailovers uint64
+
+	// preparingRestart is set to 1 once PrepareForRestart has paused
+	// forwarding. It's accessed atomically.
+	preparingRestart int32
+
 	started  int32
 	shutdown int32
 	wg       sync.WaitGroup
@@ -169,11 +374,73 @@ type Switch struct {
 	// linkControl is a channel used to propagate add/remove/get htlc
 	// switch handler commands.
 	linkControl chan interface{}
+
+	// holdResolvers maps a payment hash to the resolver tracking all HTLC's
+	// paying to it which are currently parked awaiting an external
+	// resolution.
+	holdResolvers map[chainhash.Hash]*holdResolver
+	holdMtx       sync.Mutex
+
+	// settledSets maps the payment hash of each set of parked HTLC's
+	// which has been settled within the SettledSetLifetime to its
+	// preimage, in order to settle late shards of the set. It's guarded
+	// by the holdMtx.
+	settledSets map[chainhash.Hash]*settledSet
+
+	// reviews maps the payment hash of each invoice requiring review
+	// whose HTLC's are held to its pending review, and reviewAudit
+	// retains the most recent review decisions.
+	reviews     map[chainhash.Hash]*pendingReview
+	reviewAudit []ReviewAuditEntry
+	reviewMtx   sync.Mutex
+
+	// disconnects retains the most recent disconnect events for each
+	// peer, allowing operators to inspect why peers were dropped.
+	disconnects *disconnectRegistry
+
+	// tracer accumulates the spans of forwarded HTLC's while circuit
+	// tracing is enabled.
+	tracer *circuitTracer
+
+	// hashWatcher delivers events for the incoming HTLC's of all payment
+	// hashes registered via RegisterHashWatch.
+	hashWatcher *hashWatcher
+
+	// forwardingACL restricts the peers that HTLC's may be forwarded
+	// to/from.
+	forwardingACL *forwardingACL
+
+	// rebalances maps the payment hash of each in-flight rebalance to its
+	// description.
+	rebalances   map[chainhash.Hash]*RebalanceForward
+	rebalanceMtx sync.Mutex
+
+	// chanIDAliases maps the prior short channel ID of each link
+	// migrated by a re-org to its current one, such that packets stamped
+	// with the prior ID before the migration are still delivered. This is
+	// only accessed from within the htlcForwarder goroutine.
+	chanIDAliases map[lnwire.ShortChannelID]lnwire.ShortChannelID
+
+	// volumeLimiter enforces the ForwardVolumeLimit.
+	volumeLimiter *volumeLimiter
+
+	// decisions retains the most recent forwarding decisions made by the
+	// switch and its links.
+	decisions *decisionLog
+
+	// maintenance determines whether we're within one of the
+	// MaintenanceWindows.
+	maintenance *maintenanceSchedule
+
+	// flows accumulates the HTLC flow settled over each channel for
+	// FlowTimeSeries.
+	flows *flowRecorder
 }
 
 // New creates the new instance of htlc switch.
 func New(cfg Config) *Switch {
 	return &Switch{
+		nextCorrelationID: uint64(time.Now().UnixNano()),
 		cfg:               &cfg,
 		circuits:          NewCircuitMap(),
 		linkIndex:         make(map[lnwire.ChannelID]ChannelLink),
@@ -184,6 +451,19 @@ func New(cfg Config) *Switch {
 		chanCloseRequests: make(chan *ChanClose),
 		resolutionMsgs:    make(chan *resolutionMsg),
 		linkControl:       make(chan interface{}),
+		holdResolvers:     make(map[chainhash.Hash]*holdResolver),
+		settledSets:       make(map[chainhash.Hash]*settledSet),
+		reviews:           make(map[chainhash.Hash]*pendingReview),
+		disconnects:       newDisconnectRegistry(),
+		tracer:            newCircuitTracer(),
+		hashWatcher:       newHashWatcher(),
+		forwardingACL:     newForwardingACL(),
+		rebalances:        make(map[chainhash.Hash]*RebalanceForward),
+		chanIDAliases:     make(map[lnwire.ShortChannelID]lnwire.ShortChannelID),
+		volumeLimiter:     newVolumeLimiter(cfg.ForwardVolumeLimit),
+		decisions:         newDecisionLog(cfg.DecisionHistorySize),
+		maintenance:       newMaintenanceSchedule(cfg.MaintenanceWindows),
+		flows:             newFlowRecorder(cfg.FlowBucketSize, cfg.FlowBuckets),
 		quit:              make(chan struct{}),
 	}
 }
@@ -229,6 +509,33 @@ func (s *Switch) ProcessContractResolution(msg contractcourt.ResolutionMsg) erro
 func (s *Switch) SendHTLC(nextNode [33]byte, htlc *lnwire.UpdateAddHTLC,
 	deobfuscator ErrorDecrypter) ([sha256.Size]byte, error) {
 
+	packet := &htlcPacket{
+		destNode: nextNode,
+		htlc:     htlc,
+	}
+
+	return s.sendLocalPacket(packet, deobfuscator)
+}
+
+// sendLocalPacket dispatches a locally initiated HTLC add packet, and blocks
+// until the payment has either been settled or failed.
+func (s *Switch) sendLocalPacket(packet *htlcPacket,
+	deobfuscator ErrorDecrypter) ([sha256.Size]byte, error) {
+
+	htlc := packet.htlc.(*lnwire.UpdateAddHTLC)
+
+	// No new payments may be sent once we're preparing for a restart, as
+	// they'd prevent the in-flight HTLC's from draining.
+	if s.isPreparingRestart() {
+		return zeroPreimage, ErrPreparingRestart
+	}
+
+	// Our notion of the block height is stale until we've synced to the
+	// chain, so we'll either wait until we have, or fail the payment.
+	if err := s.waitForChainSync(); err != nil {
+		return zeroPreimage, err
+	}
+
 	// Create payment and add to the map of payment in order later to be
 	// able to retrieve it and return response to the user.
 	payment := &pendingPayment{
@@ -245,14 +552,17 @@ func (s *Switch) SendHTLC(nextNode [33]byte, htlc *lnwire.UpdateAddHTLC,
 	s.pendingPayments[paymentID] = payment
 	s.pendingMutex.Unlock()
 
-	// Generate and send new update packet, if error will be received on
-	// this stage it means that packet haven't left boundaries of our
-	// system and something wrong happened.
-	packet := &htlcPacket{
-		incomingHTLCID: paymentID,
-		destNode:       nextNode,
-		htlc:           htlc,
+	// If the links to the first hop have only just started, then we may
+	// need to wait for one of them to warm up before it'll carry the
+	// payment.
+	if !s.cfg.LocalPaymentsBypassWarmUp {
+		s.waitForWarmUp(packet.destNode)
 	}
+
+	// Send the new update packet, if error will be received on this stage
+	// it means that packet haven't left boundaries of our system and
+	// something wrong happened.
+	packet.incomingHTLCID = paymentID
 	if err := s.forward(packet); err != nil {
 		s.removePendingPayment(paymentID)
 		return zeroPreimage, err
@@ -293,6 +603,24 @@ func (s *Switch) SendHTLC(nextNode [33]byte, htlc *lnwire.UpdateAddHTLC,
 func (s *Switch) UpdateForwardingPolicies(newPolicy ForwardingPolicy,
 	targetChans ...wire.OutPoint) error {
 
+	return s.UpdateDirectionalPolicies(
+		DirectionalPolicy{Outbound: newPolicy}, targetChans...,
+	)
+}
+
+// UpdateDirectionalPolicies sends a message to the switch to update both the
+// forwarding policies and inbound fees for the set of target channels. If the
+// set of targeted channels is nil, then the policies for all active channels
+// will be updated. If the policy of any link can't be updated, e.g. as the
+// resulting inbound discount could render a forward unprofitable, then the
+// policies of the remaining links are still updated, and an error describing
+// each link which failed is returned.
+//
+// NOTE: This function is synchronous and will block until either the
+// policies for all links have been updated, or the switch shuts down.
+func (s *Switch) UpdateDirectionalPolicies(newPolicy DirectionalPolicy,
+	targetChans ...wire.OutPoint) error {
+
 	errChan := make(chan error, 1)
 	select {
 	case s.linkControl <- &updatePoliciesCmd{
@@ -315,7 +643,7 @@ func (s *Switch) UpdateForwardingPolicies(newPolicy ForwardingPolicy,
 // updatePoliciesCmd is a message sent to the switch to update the forwarding
 // policies of a set of target links.
 type updatePoliciesCmd struct {
-	newPolicy   ForwardingPolicy
+	newPolicy   DirectionalPolicy
 	targetChans []wire.OutPoint
 
 	err chan error
@@ -324,15 +652,24 @@ type updatePoliciesCmd struct {
 // updateLinkPolicies attempts to update the forwarding policies for the set of
 // passed links identified by their channel points. If a nil set of channel
 // points is passed, then the forwarding policies for all active links will be
-// updated.k
+// updated. A link whose policy can't be updated doesn't prevent the policies
+// of the others from being updated, though an error describing every failed
+// link is returned.
 func (s *Switch) updateLinkPolicies(c *updatePoliciesCmd) error {
 	log.Debugf("Updating link policies: %v", spew.Sdump(c))
 
+	var failures []string
+
 	// If no channels have been targeted, then we'll update the link policies
 	// for all active channels
 	if len(c.targetChans) == 0 {
 		for _, link := range s.linkIndex {
-			link.UpdateForwardingPolicy(c.newPolicy)
+			err := link.UpdateDirectionalPolicy(c.newPolicy)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf(
+					"chan_id=%v: %v", link.ChanID(), err,
+				))
+			}
 		}
 	}
 
@@ -342,14 +679,28 @@ func (s *Switch) updateLinkPolicies(c *updatePoliciesCmd) error {
 		cid := lnwire.NewChanIDFromOutPoint(&targetLink)
 
 		// If we can't locate a link by its converted channel ID, then we'll
-		// return an error back to the caller.
+		// report it back to the caller.
 		link, ok := s.linkIndex[cid]
 		if !ok {
-			return fmt.Errorf("unable to find ChannelPoint(%v) to "+
-				"update link policy", targetLink)
+			failures = append(failures, fmt.Sprintf(
+				"ChannelPoint(%v): link not found", targetLink,
+			))
+			continue
+		}
+
+		err := link.UpdateDirectionalPolicy(c.newPolicy)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf(
+				"ChannelPoint(%v): %v", targetLink, err,
+			))
 		}
+	}
 
-		link.UpdateForwardingPolicy(c.newPolicy)
+	if len(failures) != 0 {
+		err := fmt.Errorf("unable to update policy of %v link(s): %v",
+			len(failures), strings.Join(failures, "; "))
+		log.Error(err)
+		return err
 	}
 
 	return nil
@@ -407,8 +758,18 @@ func (s *Switch) handleLocalDispatch(packet *htlcPacket) error {
 	// User have created the htlc update therefore we should find the
 	// appropriate channel link and send the payment over this link.
 	case *lnwire.UpdateAddHTLC:
-		// Try to find links by node destination.
-		links, err := s.getLinks(packet.destNode)
+		// If the packet has been pinned to an outgoing channel, as is
+		// the case for rebalances, then we'll only consider its link.
+		// Otherwise, we'll try to find links by node destination.
+		var links []ChannelLink
+		pinned := packet.outgoingChanID != (lnwire.ShortChannelID{})
+		if pinned {
+			var link ChannelLink
+			link, err = s.getLinkByShortID(packet.outgoingChanID)
+			links = []ChannelLink{link}
+		} else {
+			links, err = s.getLinks(packet.destNode)
+		}
 		if err != nil {
 			log.Errorf("unable to find links by destination %v", err)
 			return &ForwardingError{
@@ -425,12 +786,15 @@ func (s *Switch) handleLocalDispatch(packet *htlcPacket) error {
 		)
 		for _, link := range links {
 			// We'll skip any links that aren't yet eligible for
-			// forwarding.
-			if !link.EligibleToForward() {
+			// forwarding, unless we've been configured to bypass
+			// the warm-up period for local payments.
+			if !s.usableForLocalDispatch(link) {
 				continue
 			}
 
-			bandwidth := link.Bandwidth()
+			// As this is our own payment, it may also make use
+			// of the link's soft reserve.
+			bandwidth := link.LocalBandwidth()
 			if bandwidth > largestBandwidth {
 
 				largestBandwidth = bandwidth
@@ -463,6 +827,7 @@ func (s *Switch) handleLocalDispatch(packet *htlcPacket) error {
 		// manages then channel.
 		//
 		// TODO(roasbeef): should return with an error
+		payment.recordDispatch(packet, htlc, destination, pinned)
 		packet.outgoingChanID = destination.ShortChanID()
 		destination.HandleSwitchPacket(packet)
 		return nil
@@ -470,6 +835,10 @@ func (s *Switch) handleLocalDispatch(packet *htlcPacket) error {
 	// We've just received a settle update which means we can finalize the
 	// user payment and return successful response.
 	case *lnwire.UpdateFulfillHTLC:
+		s.flows.record(
+			packet.outgoingChanID, flowLocalOut, payment.amount,
+		)
+
 		// Notify the user that his payment was successfully proceed.
 		payment.err <- nil
 		payment.preimage <- htlc.PaymentPreimage
@@ -478,6 +847,15 @@ func (s *Switch) handleLocalDispatch(packet *htlcPacket) error {
 	// We've just received a fail update which means we can finalize the
 	// user payment and return fail response.
 	case *lnwire.UpdateFailHTLC:
+		// If the payment failed at our own link, then it never
+		// reached the first hop, so we may be able to resend it over
+		// another channel to the same peer.
+		if packet.localFailure &&
+			s.failoverLocalPayment(packet.incomingHTLCID, payment) {
+
+			return nil
+		}
+
 		var failure *ForwardingError
 		switch {
 
@@ -546,6 +924,12 @@ func (s *Switch) handleLocalDispatch(packet *htlcPacket) error {
 // from one channel link to another and be able to propagate the settle/fail
 // updates back. This behaviour is achieved by creation of payment circuits.
 func (s *Switch) handlePacketForward(packet *htlcPacket) error {
+	// If the packet was stamped with the prior short channel ID of a link
+	// which has since been migrated, then we'll point it to the current
+	// one.
+	packet.incomingChanID = s.currentShortChanID(packet.incomingChanID)
+	packet.outgoingChanID = s.currentShortChanID(packet.outgoingChanID)
+
 	switch htlc := packet.htlc.(type) {
 
 	// Channel link forwarded us a new htlc, therefore we initiate the
@@ -588,30 +972,110 @@ func (s *Switch) handlePacketForward(packet *htlcPacket) error {
 					Reason: reason,
 				},
 			})
+			s.recordDecision(packet, failure)
+			s.tracer.complete(
+				packet.incomingChanID, packet.incomingHTLCID,
+				false,
+			)
 			err = errors.Errorf("unable to find link with "+
 				"destination %v", packet.outgoingChanID)
 			log.Error(err)
 			return err
 		}
-		interfaceLinks, _ := s.getLinks(targetLink.Peer().PubKey())
 
-		// Try to find destination channel link with appropriate
-		// bandwidth.
-		var destination ChannelLink
-		for _, link := range interfaceLinks {
-			// We'll skip any links that aren't yet eligible for
-			// forwarding.
-			if !link.EligibleToForward() {
-				continue
-			}
+		// If either the incoming or outgoing peer isn't permitted to
+		// forward, then we'll fail the HTLC back as if the outgoing
+		// channel were disabled.
+		sourcePeer := source.Peer().PubKey()
+		targetPeer := targetLink.Peer().PubKey()
+		if !s.forwardingACL.permitted(sourcePeer) ||
+			!s.forwardingACL.permitted(targetPeer) {
+
+			failure := channelDisabledFailure(targetLink)
+			return s.failForwardWith(source, packet, failure,
+				errors.Errorf("forward from peer %x to peer "+
+					"%x denied by forwarding ACL",
+					sourcePeer[:], targetPeer[:]))
+		}
 
-			if link.Bandwidth() >= htlc.Amount {
+		// During a maintenance window, we'll decline all new forwards
+		// as if the outgoing channel were disabled.
+		if s.maintenance.active() {
+			failure := channelDisabledFailure(targetLink)
+			return s.failForwardWith(source, packet, failure,
+				errors.Errorf("forward of htlc=%v from %v "+
+					"declined during maintenance",
+					packet.incomingHTLCID,
+					packet.incomingChanID))
+		}
 
-				destination = link
-				break
-			}
+		// Until we've synced to the chain, our notion of the block
+		// height is stale, so we're unable to enforce the CLTV safety
+		// margins of new forwards.
+		if !s.chainSynced() {
+			return s.failForward(source, packet, errors.Errorf(
+				"forward of htlc=%v from %v declined as node "+
+					"isn't synced to chain",
+				packet.incomingHTLCID, packet.incomingChanID,
+			))
+		}
+
+		// While preparing for a restart, we'll decline all new forwards
+		// such that those in flight are able to drain.
+		if s.isPreparingRestart() {
+			return s.failForward(source, packet, errors.Errorf(
+				"forward of htlc=%v from %v declined while "+
+					"preparing for restart",
+				packet.incomingHTLCID, packet.incomingChanID,
+			))
+		}
+
+		// A send-only channel may not source any forwards, so we'll
+		// fail the HTLC back.
+		if !source.ChannelRole().canSource() {
+			return s.failForward(source, packet, errors.Errorf(
+				"forward of htlc=%v from send-only channel %v "+
+					"denied", packet.incomingHTLCID,
+				packet.incomingChanID,
+			))
+		}
+
+		// If the incoming channel has already sourced the maximum
+		// number of pending circuits, then we'll fail the HTLC back
+		// until some of them have been resolved.
+		maxCircuits := s.cfg.MaxCircuitsPerIncomingChannel
+		numCircuits := s.circuits.NumIncoming(packet.incomingChanID)
+		if maxCircuits != 0 && numCircuits >= int(maxCircuits) {
+			return s.failForward(source, packet, errors.Errorf(
+				"incoming channel %v has reached the maximum "+
+					"of %v pending circuits",
+				packet.incomingChanID, maxCircuits,
+			))
 		}
 
+		// If this forward would take the volume forwarded within the
+		// current window above the limit, then we'll fail it back
+		// until the window rolls over.
+		if !s.volumeLimiter.permits(htlc.Amount) {
+			return s.failForward(source, packet, errors.Errorf(
+				"forward of htlc=%v from %v exceeds the "+
+					"forwarding volume limit",
+				packet.incomingHTLCID, packet.incomingChanID,
+			))
+		}
+
+		// Consult the forward policy plugins, if any, each of which
+		// may fail the HTLC back.
+		if failed, err := s.consultPlugins(source, packet); failed {
+			return err
+		}
+
+		interfaceLinks, _ := s.getLinks(targetPeer)
+
+		// Try to find destination channel link with appropriate
+		// bandwidth.
+		destination := s.selectDestination(interfaceLinks, htlc.Amount)
+
 		// If the channel link we're attempting to forward the update
 		// over has insufficient capacity, then we'll cancel the htlc
 		// as the payment cannot succeed.
@@ -636,6 +1100,11 @@ func (s *Switch) handlePacketForward(packet *htlcPacket) error {
 					Reason: reason,
 				},
 			})
+			s.recordDecision(packet, failure)
+			s.tracer.complete(
+				packet.incomingChanID, packet.incomingHTLCID,
+				false,
+			)
 
 			err = errors.Errorf("unable to find appropriate "+
 				"channel link insufficient capacity, need "+
@@ -644,6 +1113,25 @@ func (s *Switch) handlePacketForward(packet *htlcPacket) error {
 			return err
 		}
 
+		// If the chosen link is under pressure, then forwards from
+		// peers with a poor reputation are shed, leaving the link's
+		// remaining capacity to those with a better one.
+		if s.shedsForReputation(sourcePeer, destination, htlc.Amount) {
+			return s.failForward(source, packet, errors.Errorf(
+				"forward of htlc=%v from %v shed due to the "+
+					"reputation of peer %x",
+				packet.incomingHTLCID, packet.incomingChanID,
+				sourcePeer[:],
+			))
+		}
+
+		s.tracer.record(
+			packet.incomingChanID, packet.incomingHTLCID,
+			TraceBandwidthChecked,
+		)
+
+		s.recordDecision(packet, nil)
+
 		// Send the packet to the destination channel link which
 		// manages the channel.
 		destination.HandleSwitchPacket(packet)
@@ -721,6 +1209,35 @@ func (s *Switch) handlePacketForward(packet *htlcPacket) error {
 			return s.handleLocalDispatch(packet)
 		}
 
+		// Settled forwards count towards the volume forwarded within
+		// the current window.
+		_, isSettle := htlc.(*lnwire.UpdateFulfillHTLC)
+		if isSettle && !packet.isRouted {
+			s.volumeLimiter.settled(packet.amount)
+			s.flows.record(
+				packet.outgoingChanID, flowForwardedOut,
+				packet.amount,
+			)
+		}
+
+		_, settled := packet.htlc.(*lnwire.UpdateFulfillHTLC)
+		s.tracer.complete(
+			packet.incomingChanID, packet.incomingHTLCID, settled,
+		)
+
+		// If this resolution was received from the outgoing link of a
+		// circuit, then we'll persist it before acknowledging it, so
+		// it can be replayed to the incoming link should we crash
+		// before the link commits to it.
+		if !packet.isRouted {
+			if err := s.persistResolution(packet); err != nil {
+				err := errors.Errorf("unable to persist "+
+					"resolution: %v", err)
+				log.Error(err)
+				return err
+			}
+		}
+
 		source, err := s.getLinkByShortID(packet.incomingChanID)
 		if err != nil {
 			err := errors.Errorf("Unable to get source channel "+
@@ -737,6 +1254,32 @@ func (s *Switch) handlePacketForward(packet *htlcPacket) error {
 	}
 }
 
+// clearLinkForClose instructs the target link to stop accepting new HTLC's and
+// waits for all of its outstanding HTLC's to be resolved, bounded by the
+// configured HTLCClearTimeout. If the link can't be cleared in time, then it
+// resumes accepting HTLC's, as the cooperative close won't go ahead.
+func (s *Switch) clearLinkForClose(link ChannelLink) error {
+	timeout := s.cfg.HTLCClearTimeout
+	if timeout == 0 {
+		timeout = DefaultHTLCClearTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := link.ClearHTLCsForClose(ctx)
+	if err == ErrLinkClearTimeout {
+		link.CancelClearHTLCs()
+	}
+	if err != nil {
+		log.Errorf("Unable to clear htlcs of ChannelPoint(%v) for "+
+			"close: %v", link, err)
+		return err
+	}
+
+	return nil
+}
+
 // CloseLink creates and sends the close channel command to the target link
 // directing the specified closure type. If the closure type if CloseRegular,
 // then the last parameter should be the ideal fee-per-kw that will be used as
@@ -819,7 +1362,20 @@ func (s *Switch) htlcForwarder() {
 			log.Debugf("Requesting local channel close: peer=%v, "+
 				"chan_id=%x", link.Peer(), chanID[:])
 
-			go s.cfg.LocalChannelClose(peerPub[:], req)
+			go func() {
+				// Before a cooperative close can be negotiated,
+				// the channel must be free of any HTLC's, so
+				// we'll instruct the link to clear them first.
+				if req.CloseType == CloseRegular {
+					err := s.clearLinkForClose(link)
+					if err != nil {
+						req.Err <- err
+						return
+					}
+				}
+
+				s.cfg.LocalChannelClose(peerPub[:], req)
+			}()
 
 		case resolutionMsg := <-s.resolutionMsgs:
 			pkt := &htlcPacket{
@@ -941,10 +1497,29 @@ func (s *Switch) htlcForwarder() {
 				links, err := s.getLinks(cmd.peer)
 				cmd.done <- links
 				cmd.err <- err
+			case *linkCountCmd:
+				cmd.done <- len(s.interfaceIndex[cmd.peer])
 			case *updateForwardingIndexCmd:
 				cmd.err <- s.updateShortChanID(
 					cmd.chanID, cmd.shortChanID,
 				)
+			case *importCircuitsCmd:
+				cmd.err <- s.importCircuits(cmd.circuits)
+			case *detachLinkCmd:
+				circuits, err := s.detachLink(cmd.chanID)
+				cmd.done <- circuits
+				cmd.err <- err
+			case *attachLinkCmd:
+				cmd.err <- s.attachLink(cmd.link, cmd.circuits)
+			case *allLinksCmd:
+				cmd.done <- s.allLinks()
+			case *getLinkByShortIDCmd:
+				link, _ := s.getLinkByShortID(
+					s.currentShortChanID(cmd.chanID),
+				)
+				cmd.done <- link
+			case *abandonChannelCmd:
+				cmd.err <- s.abandonChannel(cmd.chanID)
 			}
 
 		case <-s.quit:
@@ -965,6 +1540,11 @@ func (s *Switch) Start() error {
 	s.wg.Add(1)
 	go s.htlcForwarder()
 
+	if s.cfg.WatchdogInterval != 0 {
+		s.wg.Add(1)
+		go s.linkWatchdog()
+	}
+
 	return nil
 }
 
@@ -1017,6 +1597,22 @@ func (s *Switch) AddLink(link ChannelLink) error {
 func (s *Switch) addLink(link ChannelLink) error {
 	// TODO(roasbeef): reject if link already tehre?
 
+	// If a limit on the number of links per peer has been set, then we'll
+	// ensure this new link doesn't exceed it. A link replacing one we
+	// already know of for the same channel isn't counted a second time.
+	peerPub := link.Peer().PubKey()
+	numLinks := uint32(len(s.interfaceIndex[peerPub]))
+	_, isKnown := s.linkIndex[link.ChanID()]
+	if s.cfg.MaxLinksPerPeer != 0 && !isKnown &&
+		numLinks >= s.cfg.MaxLinksPerPeer {
+
+		log.Warnf("Rejecting channel link with chan_id=%v, peer=%x "+
+			"already has %v links (max=%v)", link.ChanID(),
+			peerPub[:], numLinks, s.cfg.MaxLinksPerPeer)
+
+		return ErrMaxLinksPerPeer
+	}
+
 	// First we'll add the link to the linkIndex which lets us quickly look
 	// up a channel when we need to close or register it, and the
 	// forwarding index which'll be used when forwarding HTLC's in the
@@ -1026,7 +1622,6 @@ func (s *Switch) addLink(link ChannelLink) error {
 
 	// Next we'll add the link to the interface index so we can quickly
 	// look up all the channels for a particular node.
-	peerPub := link.Peer().PubKey()
 	if _, ok := s.interfaceIndex[peerPub]; !ok {
 		s.interfaceIndex[peerPub] = make(map[ChannelLink]struct{})
 	}
@@ -1040,6 +1635,10 @@ func (s *Switch) addLink(link ChannelLink) error {
 	log.Infof("Added channel link with chan_id=%v, short_chan_id=(%v)",
 		link.ChanID(), spew.Sdump(link.ShortChanID()))
 
+	// Finally, we'll hand the link any resolutions it may not have
+	// committed to before we last shut down.
+	s.replayResolutions(link)
+
 	return nil
 }
 
@@ -1103,6 +1702,23 @@ func (s *Switch) getLinkByShortID(chanID lnwire.ShortChannelID) (ChannelLink, er
 	return link, nil
 }
 
+// MarkLinkPendingClose marks the link of the target channel as pending force
+// close. The link remains within the switch, such that its outstanding HTLC's
+// can still be resolved, but as it's no longer eligible to forward, no new
+// HTLC's will be routed over it.
+func (s *Switch) MarkLinkPendingClose(chanID lnwire.ChannelID) error {
+	link, err := s.GetLink(chanID)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Marking ChannelLink(%v) as pending force close", link)
+
+	link.MarkPendingClose()
+
+	return nil
+}
+
 // removeLinkCmd is a get link command wrapper, it is used to propagate handler
 // parameters and return handler error.
 type removeLinkCmd struct {
@@ -1200,19 +1816,67 @@ func (s *Switch) updateShortChanID(chanID lnwire.ChannelID,
 		return fmt.Errorf("link %v not found", chanID)
 	}
 
+	oldShortChanID := link.ShortChanID()
 	log.Infof("Updating short_chan_id for ChannelLink(%v): old=%v, new=%v",
-		chanID, link.ShortChanID(), shortChanID)
+		chanID, oldShortChanID, shortChanID)
 
 	// At this point the link is actually active, so we'll update the
 	// forwarding index with the next short channel ID.
 	s.forwardingIndex[shortChanID] = link
 
-	// Finally, we'll notify the link of its new short channel ID.
+	// Next, we'll notify the link of its new short channel ID.
 	link.UpdateShortChanID(shortChanID)
 
+	// If the link was added before its short channel ID was known, then
+	// there's nothing to migrate.
+	if oldShortChanID == (lnwire.ShortChannelID{}) ||
+		oldShortChanID == shortChanID {
+
+		return nil
+	}
+
+	// Otherwise, a re-org has relocated the funding output, so we'll
+	// migrate the circuits of any in-flight HTLC's over the link, and
+	// alias the prior short channel ID to the new one. As packets are
+	// only handled by the htlcForwarder goroutine, and the circuit map is
+	// updated atomically, no forward is resolved against a partially
+	// updated mapping.
+	numCircuits := s.circuits.UpdateShortChanID(
+		oldShortChanID, shortChanID,
+	)
+	for alias, current := range s.chanIDAliases {
+		if current == oldShortChanID {
+			s.chanIDAliases[alias] = shortChanID
+		}
+	}
+	s.chanIDAliases[oldShortChanID] = shortChanID
+	delete(s.chanIDAliases, shortChanID)
+
+	log.Infof("Migrated %v circuits of ChannelLink(%v) from "+
+		"short_chan_id=%v to %v", numCircuits, chanID, oldShortChanID,
+		shortChanID)
+
+	if s.cfg.ReissueChannelUpdate != nil {
+		go s.cfg.ReissueChannelUpdate(chanID, shortChanID)
+	}
+
 	return nil
 }
 
+// currentShortChanID returns the current short channel ID of the link
+// previously known by the passed short channel ID, if it has since been
+// migrated. Otherwise, the passed short channel ID is returned.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) currentShortChanID(
+	sid lnwire.ShortChannelID) lnwire.ShortChannelID {
+
+	if current, ok := s.chanIDAliases[sid]; ok {
+		return current
+	}
+	return sid
+}
+
 // getLinksCmd is a get links command wrapper, it is used to propagate handler
 // parameters and return handler error.
 type getLinksCmd struct {
@@ -1269,6 +1933,93 @@ func (s *Switch) getLinks(destination [33]byte) ([]ChannelLink, error) {
 	return channelLinks, nil
 }
 
+// waitForWarmUp blocks until at least one of the links to the target peer is
+// no longer warming up, or the WarmUpPeriod has elapsed. If any link is
+// already eligible to forward, or none are warming up, then it returns
+// immediately.
+func (s *Switch) waitForWarmUp(peer [33]byte) {
+	links, err := s.GetLinksByInterface(peer)
+	if err != nil {
+		return
+	}
+
+	var warmingUp []ChannelLink
+	for _, link := range links {
+		switch link.IneligibleReason() {
+		case IneligibleNone, IneligibleTooYoung, IneligibleMaintenance:
+			return
+
+		case IneligibleWarmingUp:
+			warmingUp = append(warmingUp, link)
+		}
+	}
+
+	if len(warmingUp) == 0 {
+		return
+	}
+
+	log.Debugf("Waiting for one of %v link(s) to peer %x to warm up",
+		len(warmingUp), peer[:])
+
+	warmedUp := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+
+	var once sync.Once
+	for _, link := range warmingUp {
+		go func(link ChannelLink) {
+			select {
+			case <-link.WarmedUp():
+				once.Do(func() { close(warmedUp) })
+			case <-done:
+			}
+		}(link)
+	}
+
+	var timeout <-chan time.Time
+	if s.cfg.WarmUpPeriod > 0 {
+		timeout = time.After(s.cfg.WarmUpPeriod)
+	}
+
+	select {
+	case <-warmedUp:
+	case <-timeout:
+		log.Debugf("Links to peer %x didn't warm up within %v",
+			peer[:], s.cfg.WarmUpPeriod)
+	case <-s.quit:
+	}
+}
+
+// linkCountCmd is a link count command wrapper, it is used to query the
+// number of links the switch maintains with a particular peer.
+type linkCountCmd struct {
+	peer [33]byte
+	done chan int
+}
+
+// NumLinksForPeer returns the number of links the switch currently maintains
+// with the peer identified by the serialized compressed form of its public
+// key.
+func (s *Switch) NumLinksForPeer(peer [33]byte) (int, error) {
+	command := &linkCountCmd{
+		peer: peer,
+		done: make(chan int, 1),
+	}
+
+	select {
+	case s.linkControl <- command:
+		select {
+		case numLinks := <-command.done:
+			return numLinks, nil
+		case <-s.quit:
+		}
+	case <-s.quit:
+	}
+
+	return 0, errors.New("unable to get link count htlc switch was " +
+		"stopped")
+}
+
 // removePendingPayment is the helper function which removes the pending user
 // payment.
 func (s *Switch) removePendingPayment(paymentID uint64) error {
@@ -1303,7 +2054,70 @@ func (s *Switch) numPendingPayments() int {
 	return len(s.pendingPayments)
 }
 
+// IncomingCircuitCounts returns the number of pending circuits sourced by
+// each incoming channel, allowing operators to monitor the share of
+// forwarding capacity consumed by each channel. Channels without any pending
+// circuits are omitted.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) IncomingCircuitCounts() map[lnwire.ShortChannelID]int {
+	return s.circuits.IncomingCounts()
+}
+
 // addCircuit adds a circuit to the switch's in-memory mapping.
 func (s *Switch) addCircuit(circuit *PaymentCircuit) {
 	s.circuits.Add(circuit)
 }
+
+// failForward fails the passed forward back to its source link with a
+// temporary channel failure, returning the passed error once done.
+func (s *Switch) failForward(source ChannelLink, packet *htlcPacket,
+	fwdErr error) error {
+
+	failure := lnwire.NewTemporaryChannelFailure(nil)
+	return s.failForwardWith(source, packet, failure, fwdErr)
+}
+
+// failForwardWith fails the passed forward back to its source link with the
+// given failure, returning the passed error once done.
+func (s *Switch) failForwardWith(source ChannelLink, packet *htlcPacket,
+	failure lnwire.FailureMessage, fwdErr error) error {
+
+	reason, err := packet.obfuscator.EncryptFirstHop(failure)
+	if err != nil {
+		err := errors.Errorf("unable to obfuscate error: %v", err)
+		log.Error(err)
+		return err
+	}
+
+	source.HandleSwitchPacket(&htlcPacket{
+		incomingChanID: packet.incomingChanID,
+		incomingHTLCID: packet.incomingHTLCID,
+		isRouted:       true,
+		htlc: &lnwire.UpdateFailHTLC{
+			Reason: reason,
+		},
+	})
+	s.recordDecision(packet, failure)
+	s.tracer.complete(
+		packet.incomingChanID, packet.incomingHTLCID, false,
+	)
+
+	log.Error(fwdErr)
+	return fwdErr
+}
+
+// channelDisabledFailure returns the failure with which forwards over the
+// passed outgoing link are declined as though its channel were disabled. The
+// failure carries the link's latest channel update with the disabled flag
+// set, or is a temporary channel failure if the update can't be retrieved.
+func channelDisabledFailure(link ChannelLink) lnwire.FailureMessage {
+	update, err := link.DisabledChannelUpdate()
+	if err != nil {
+		log.Errorf("unable to fetch channel update for %v: %v",
+			link.ShortChanID(), err)
+		return lnwire.NewTemporaryChannelFailure(nil)
+	}
+
+	return lnwire.NewChannelDisabled(uint16(update.Flags), *update)
+}
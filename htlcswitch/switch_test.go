@@ -3,6 +3,8 @@ package htlcswitch
 import (
 	"bytes"
 	"crypto/sha256"
+	"fmt"
+	"net"
 	"testing"
 	"time"
 
@@ -542,3 +544,737 @@ func TestSwitchSendPayment(t *testing.T) {
 		t.Fatal("wrong amount of pending payments")
 	}
 }
+
+// TestSwitchMaxLinksPerPeer ensures that the switch refuses to add a new link
+// for a peer which already has the configured maximum number of links, while
+// leaving the existing links untouched.
+func TestSwitchMaxLinksPerPeer(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{
+		MaxLinksPerPeer: 1,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+
+	// Re-adding a link for a channel we already know of shouldn't count
+	// against the limit.
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to re-add alice link: %v", err)
+	}
+
+	// A second channel with Alice should be rejected.
+	secondAliceLink := newMockChannelLink(
+		s, chanID2, bobChanID, alicePeer, true,
+	)
+	if err := s.AddLink(secondAliceLink); err != ErrMaxLinksPerPeer {
+		t.Fatalf("expected ErrMaxLinksPerPeer, got %v", err)
+	}
+
+	// The existing link should remain registered.
+	if _, err := s.GetLink(chanID1); err != nil {
+		t.Fatalf("existing link should remain: %v", err)
+	}
+	numLinks, err := s.NumLinksForPeer(alicePeer.PubKey())
+	if err != nil {
+		t.Fatalf("unable to get link count: %v", err)
+	}
+	if numLinks != 1 {
+		t.Fatalf("expected 1 link for alice, got %v", numLinks)
+	}
+
+	// Another peer should be unaffected by Alice's limit.
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	// Once Alice's link is removed, a new one can be added in its place.
+	if err := s.RemoveLink(chanID1); err != nil {
+		t.Fatalf("unable to remove alice link: %v", err)
+	}
+	numLinks, err = s.NumLinksForPeer(alicePeer.PubKey())
+	if err != nil {
+		t.Fatalf("unable to get link count: %v", err)
+	}
+	if numLinks != 0 {
+		t.Fatalf("expected 0 links for alice, got %v", numLinks)
+	}
+}
+
+// TestSwitchRecentDisconnects ensures that the switch retains the most recent
+// disconnect events for each peer, discarding the oldest once full.
+func TestSwitchRecentDisconnects(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+
+	if events := s.RecentDisconnects(alicePeer.PubKey()); len(events) != 0 {
+		t.Fatalf("expected no disconnects, got %v", len(events))
+	}
+
+	// We'll record more events than the switch retains for Alice,
+	// alternating between the reasons.
+	const numEvents = maxRecentDisconnects + 5
+	for i := 0; i < numEvents; i++ {
+		reason := DisconnectProtocolViolation
+		if i%2 == 1 {
+			reason = DisconnectWriteTimeout
+		}
+
+		s.RecordDisconnect(
+			alicePeer.PubKey(), reason, errors.Errorf("event %v", i),
+		)
+	}
+	s.RecordDisconnect(
+		bobPeer.PubKey(), DisconnectOperatorRequested, nil,
+	)
+
+	// Only the most recent events for Alice should be retained, ordered
+	// from oldest to newest.
+	events := s.RecentDisconnects(alicePeer.PubKey())
+	if len(events) != maxRecentDisconnects {
+		t.Fatalf("expected %v disconnects, got %v",
+			maxRecentDisconnects, len(events))
+	}
+	for i, event := range events {
+		n := i + numEvents - maxRecentDisconnects
+		expectedReason := DisconnectProtocolViolation
+		if n%2 == 1 {
+			expectedReason = DisconnectWriteTimeout
+		}
+
+		if event.Reason != expectedReason {
+			t.Fatalf("event %v: expected reason %v, got %v", i,
+				expectedReason, event.Reason)
+		}
+		if event.Err.Error() != fmt.Sprintf("event %v", n) {
+			t.Fatalf("event %v: wrong error: %v", i, event.Err)
+		}
+		if i > 0 && event.Timestamp.Before(events[i-1].Timestamp) {
+			t.Fatalf("event %v: events not ordered", i)
+		}
+	}
+
+	// Bob's history should be unaffected by Alice's.
+	events = s.RecentDisconnects(bobPeer.PubKey())
+	if len(events) != 1 {
+		t.Fatalf("expected 1 disconnect, got %v", len(events))
+	}
+	if events[0].Reason != DisconnectOperatorRequested {
+		t.Fatalf("expected reason %v, got %v",
+			DisconnectOperatorRequested, events[0].Reason)
+	}
+}
+
+// TestDisconnectRegistryEviction ensures that the disconnect history of peers
+// which haven't disconnected within the retention period is evicted.
+func TestDisconnectRegistryEviction(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	registry := newDisconnectRegistry()
+
+	now := time.Now()
+	registry.record(bobPeer.PubKey(), DisconnectEvent{
+		Reason:    DisconnectOperatorRequested,
+		Timestamp: now.Add(-disconnectRetention - time.Minute),
+	})
+	registry.record(alicePeer.PubKey(), DisconnectEvent{
+		Reason:    DisconnectWriteFailure,
+		Timestamp: now.Add(-time.Minute),
+	})
+
+	// Bob's history should have been evicted once Alice's disconnect was
+	// recorded, as his last disconnect is older than the retention.
+	if events := registry.recent(bobPeer.PubKey()); len(events) != 0 {
+		t.Fatalf("expected bob to be evicted, got %v events",
+			len(events))
+	}
+	if events := registry.recent(alicePeer.PubKey()); len(events) != 1 {
+		t.Fatalf("expected 1 disconnect, got %v", len(events))
+	}
+
+	// Alice's recent disconnect should survive a later one of Bob's.
+	registry.record(bobPeer.PubKey(), DisconnectEvent{
+		Reason:    DisconnectOperatorRequested,
+		Timestamp: now,
+	})
+	if events := registry.recent(alicePeer.PubKey()); len(events) != 1 {
+		t.Fatalf("expected 1 disconnect, got %v", len(events))
+	}
+}
+
+// timeoutError is a net.Error which reports a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// TestWriteErrorReason ensures that write errors are classified as timeouts
+// only if they were caused by one.
+func TestWriteErrorReason(t *testing.T) {
+	t.Parallel()
+
+	var netErr net.Error = timeoutError{}
+	if reason := WriteErrorReason(netErr); reason != DisconnectWriteTimeout {
+		t.Fatalf("expected %v, got %v", DisconnectWriteTimeout, reason)
+	}
+
+	err := errors.New("connection reset by peer")
+	if reason := WriteErrorReason(err); reason != DisconnectWriteFailure {
+		t.Fatalf("expected %v, got %v", DisconnectWriteFailure, reason)
+	}
+}
+
+// TestSwitchForwardingACL ensures that forwards are only permitted between
+// peers which are allowed by the switch's forwarding ACL, in allow-only,
+// deny-only and combined modes.
+func TestSwitchForwardingACL(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+	carolPeer := newMockServer(t, "carol")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	carolChanPoint := wire.NewOutPoint(hash1, 1)
+	carolChannelLink := newMockChannelLink(
+		s, lnwire.NewChanIDFromOutPoint(carolChanPoint),
+		lnwire.NewShortChanIDFromInt(3), carolPeer, true,
+	)
+	for _, link := range []*mockChannelLink{
+		aliceChannelLink, bobChannelLink, carolChannelLink,
+	} {
+		if err := s.AddLink(link); err != nil {
+			t.Fatalf("unable to add link: %v", err)
+		}
+	}
+
+	type forward struct {
+		from, to *mockChannelLink
+		allowed  bool
+	}
+
+	tests := []struct {
+		name     string
+		allow    [][33]byte
+		deny     [][33]byte
+		forwards []forward
+	}{
+		{
+			name: "no lists",
+			forwards: []forward{
+				{aliceChannelLink, bobChannelLink, true},
+				{carolChannelLink, aliceChannelLink, true},
+			},
+		},
+		{
+			name:  "allow only",
+			allow: [][33]byte{alicePeer.PubKey(), bobPeer.PubKey()},
+			forwards: []forward{
+				{aliceChannelLink, bobChannelLink, true},
+				{bobChannelLink, aliceChannelLink, true},
+				{aliceChannelLink, carolChannelLink, false},
+				{carolChannelLink, bobChannelLink, false},
+			},
+		},
+		{
+			name: "deny only",
+			deny: [][33]byte{carolPeer.PubKey()},
+			forwards: []forward{
+				{aliceChannelLink, bobChannelLink, true},
+				{aliceChannelLink, carolChannelLink, false},
+				{carolChannelLink, bobChannelLink, false},
+			},
+		},
+		{
+			name: "combined",
+			allow: [][33]byte{
+				alicePeer.PubKey(), bobPeer.PubKey(),
+				carolPeer.PubKey(),
+			},
+			deny: [][33]byte{bobPeer.PubKey()},
+			forwards: []forward{
+				{aliceChannelLink, carolChannelLink, true},
+				{aliceChannelLink, bobChannelLink, false},
+				{bobChannelLink, carolChannelLink, false},
+			},
+		},
+	}
+
+	var htlcID uint64
+	for _, test := range tests {
+		s.SetForwardingACL(test.allow, test.deny)
+
+		for i, fwd := range test.forwards {
+			preimage := [sha256.Size]byte{byte(htlcID)}
+			packet := &htlcPacket{
+				incomingChanID: fwd.from.ShortChanID(),
+				incomingHTLCID: htlcID,
+				outgoingChanID: fwd.to.ShortChanID(),
+				obfuscator:     newMockObfuscator(),
+				htlc: &lnwire.UpdateAddHTLC{
+					PaymentHash: fastsha256.Sum256(
+						preimage[:],
+					),
+					Amount: 1,
+				},
+			}
+			htlcID++
+
+			err := s.forward(packet)
+			if fwd.allowed && err != nil {
+				t.Fatalf("%v: forward %v should have been "+
+					"allowed: %v", test.name, i, err)
+			}
+			if !fwd.allowed && err == nil {
+				t.Fatalf("%v: forward %v should have been "+
+					"denied", test.name, i)
+			}
+
+			// An allowed forward should be delivered to the
+			// outgoing link, while a denied forward should be
+			// failed back to the incoming link.
+			recipient := fwd.to
+			if !fwd.allowed {
+				recipient = fwd.from
+			}
+
+			select {
+			case pkt := <-recipient.packets:
+				_, failed := pkt.htlc.(*lnwire.UpdateFailHTLC)
+				if failed == fwd.allowed {
+					t.Fatalf("%v: forward %v: unexpected "+
+						"packet %T", test.name, i,
+						pkt.htlc)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%v: forward %v: packet not "+
+					"delivered", test.name, i)
+			}
+		}
+	}
+}
+
+// TestSwitchMaxCircuitsPerIncomingChannel ensures that forwards arriving over
+// a channel which has already sourced the maximum number of pending circuits
+// are failed back, and are accepted again once its circuits are resolved.
+func TestSwitchMaxCircuitsPerIncomingChannel(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{
+		MaxCircuitsPerIncomingChannel: 1,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := fastsha256.Sum256(preimage[:])
+	newAdd := func(htlcID uint64) *htlcPacket {
+		return &htlcPacket{
+			incomingChanID: aliceChannelLink.ShortChanID(),
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChannelLink.ShortChanID(),
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1,
+			},
+		}
+	}
+
+	// The first forward should be accepted, opening a circuit sourced by
+	// Alice's channel.
+	if err := s.forward(newAdd(0)); err != nil {
+		t.Fatalf("unable to forward htlc: %v", err)
+	}
+	select {
+	case <-bobChannelLink.packets:
+	case <-time.After(time.Second):
+		t.Fatal("request was not propagated to destination")
+	}
+
+	counts := s.IncomingCircuitCounts()
+	if counts[aliceChanID] != 1 {
+		t.Fatalf("expected 1 circuit for alice's channel, got %v",
+			counts[aliceChanID])
+	}
+
+	// As Alice's channel has reached its limit, the second forward should
+	// be failed back with a temporary channel failure.
+	if err := s.forward(newAdd(1)); err == nil {
+		t.Fatalf("forward beyond circuit limit should be rejected")
+	}
+	select {
+	case packet := <-aliceChannelLink.packets:
+		if _, ok := packet.htlc.(*lnwire.UpdateFailHTLC); !ok {
+			t.Fatalf("expected fail htlc, got %T", packet.htlc)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("htlc wasn't failed back")
+	}
+
+	// Once the first circuit is resolved, Alice's channel should be able
+	// to source a new one.
+	err := s.forward(&htlcPacket{
+		outgoingChanID: bobChannelLink.ShortChanID(),
+		outgoingHTLCID: 0,
+		amount:         1,
+		htlc: &lnwire.UpdateFulfillHTLC{
+			PaymentPreimage: preimage,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to forward settle: %v", err)
+	}
+	select {
+	case <-aliceChannelLink.packets:
+	case <-time.After(time.Second):
+		t.Fatal("settle was not propagated to source")
+	}
+
+	if len(s.IncomingCircuitCounts()) != 0 {
+		t.Fatalf("expected no pending circuits, got %v",
+			s.IncomingCircuitCounts())
+	}
+
+	if err := s.forward(newAdd(2)); err != nil {
+		t.Fatalf("unable to forward htlc: %v", err)
+	}
+	select {
+	case <-bobChannelLink.packets:
+	case <-time.After(time.Second):
+		t.Fatal("request was not propagated to destination")
+	}
+}
+
+// TestSwitchUpdateShortChanIDInFlight simulates a re-org which changes the
+// short channel ID of a link while an HTLC is in flight over it, and ensures
+// that its resolution is still delivered to the incoming link, whether it's
+// stamped with the new or the prior short channel ID.
+func TestSwitchUpdateShortChanIDInFlight(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	reissued := make(chan lnwire.ShortChannelID, 1)
+	s := New(Config{
+		ReissueChannelUpdate: func(_ lnwire.ChannelID,
+			sid lnwire.ShortChannelID) {
+
+			reissued <- sid
+		},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := fastsha256.Sum256(preimage[:])
+	for i := uint64(0); i < 2; i++ {
+		err := s.forward(&htlcPacket{
+			incomingChanID: aliceChanID,
+			incomingHTLCID: i,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1,
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to forward htlc: %v", err)
+		}
+
+		select {
+		case <-bobChannelLink.packets:
+		case <-time.After(time.Second):
+			t.Fatal("request was not propagated to destination")
+		}
+	}
+
+	// A re-org now relocates the funding output of Bob's channel.
+	newChanID := lnwire.NewShortChanIDFromInt(10)
+	if err := s.UpdateShortChanID(chanID2, newChanID); err != nil {
+		t.Fatalf("unable to update short chan id: %v", err)
+	}
+
+	select {
+	case sid := <-reissued:
+		if sid != newChanID {
+			t.Fatalf("expected channel update for %v, got %v",
+				newChanID, sid)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel update wasn't reissued")
+	}
+
+	// The first HTLC is settled using the new short channel ID, while the
+	// settle of the second was stamped with the prior one before the
+	// re-org. Both should reach Alice.
+	for i, sid := range []lnwire.ShortChannelID{newChanID, bobChanID} {
+		err := s.forward(&htlcPacket{
+			outgoingChanID: sid,
+			outgoingHTLCID: uint64(i),
+			amount:         1,
+			htlc: &lnwire.UpdateFulfillHTLC{
+				PaymentPreimage: preimage,
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to forward settle over %v: %v", sid,
+				err)
+		}
+
+		select {
+		case packet := <-aliceChannelLink.packets:
+			if packet.incomingHTLCID != uint64(i) {
+				t.Fatalf("expected htlc %v to be settled, got %v",
+					i, packet.incomingHTLCID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("settle over %v was not propagated to source",
+				sid)
+		}
+	}
+
+	if s.circuits.pending() != 0 {
+		t.Fatalf("expected no pending circuits, got %v",
+			s.circuits.pending())
+	}
+}
+
+// TestSwitchSoftReserve tests that forwarded HTLC's can't make use of a link's
+// soft reserve, while locally initiated HTLC's can.
+func TestSwitchSoftReserve(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	bobChannelLink.bandwidth = 1000
+	bobChannelLink.softReserve = 500
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := fastsha256.Sum256(preimage[:])
+
+	// An HTLC forwarded from Alice which would dip into Bob's soft
+	// reserve should be failed back.
+	s.forward(&htlcPacket{
+		incomingChanID: aliceChanID,
+		incomingHTLCID: 0,
+		outgoingChanID: bobChanID,
+		obfuscator:     newMockObfuscator(),
+		htlc: &lnwire.UpdateAddHTLC{
+			PaymentHash: rhash,
+			Amount:      700,
+		},
+	})
+	select {
+	case pkt := <-aliceChannelLink.packets:
+		if _, ok := pkt.htlc.(*lnwire.UpdateFailHTLC); !ok {
+			t.Fatalf("expected fail, got %T", pkt.htlc)
+		}
+	case <-bobChannelLink.packets:
+		t.Fatalf("forwarded htlc used soft reserve")
+	case <-time.After(time.Second):
+		t.Fatalf("forwarded htlc wasn't failed back")
+	}
+
+	// Our own payment of the same amount may use the soft reserve, so it
+	// should reach Bob.
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := s.SendHTLC(bobPeer.PubKey(), &lnwire.UpdateAddHTLC{
+			PaymentHash: rhash,
+			Amount:      700,
+		}, newMockDeobfuscator())
+		errChan <- err
+	}()
+	select {
+	case <-bobChannelLink.packets:
+	case err := <-errChan:
+		t.Fatalf("unable to send payment: %v", err)
+	case <-time.After(time.Second):
+		t.Fatalf("local htlc wasn't sent over soft reserve")
+	}
+}
+
+// TestSwitchCloseLinkClearTimeout ensures that if a link can't be cleared of
+// its HTLC's before a cooperative close, then the close request fails and the
+// link is instructed to resume accepting HTLC's.
+func TestSwitchCloseLinkClearTimeout(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+
+	closeRequested := make(chan struct{}, 1)
+	s := New(Config{
+		LocalChannelClose: func([]byte, *ChanClose) {
+			closeRequested <- struct{}{}
+		},
+	})
+	s.Start()
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	aliceChannelLink.clearTimeout = true
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+
+	_, errChan := s.CloseLink(chanPoint1, CloseRegular, 0)
+	select {
+	case err := <-errChan:
+		if err != ErrLinkClearTimeout {
+			t.Fatalf("expected ErrLinkClearTimeout, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("close request wasn't failed")
+	}
+
+	if !aliceChannelLink.clearCancelled {
+		t.Fatalf("link wasn't instructed to resume accepting htlcs")
+	}
+
+	select {
+	case <-closeRequested:
+		t.Fatalf("cooperative close shouldn't have been requested")
+	default:
+	}
+}
+
+// TestSwitchUpdatePoliciesPartialFailure ensures that if the policy of one
+// link can't be updated, then the policies of the remaining links are still
+// updated, and the failure is reported.
+func TestSwitchUpdatePoliciesPartialFailure(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	s := New(Config{})
+	s.Start()
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	aliceChannelLink.policyErr = fmt.Errorf("unprofitable inbound fee")
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	// Both when targeting the links explicitly, and when updating all
+	// links, Alice's failure shouldn't prevent Bob's policy from being
+	// updated.
+	err := s.UpdateDirectionalPolicies(
+		DirectionalPolicy{}, *chanPoint1, *chanPoint2,
+	)
+	if err == nil {
+		t.Fatalf("expected alice's policy update to fail")
+	}
+	if bobChannelLink.policyUpdates != 1 {
+		t.Fatalf("expected bob's policy to be updated once, got %v",
+			bobChannelLink.policyUpdates)
+	}
+
+	if err := s.UpdateDirectionalPolicies(DirectionalPolicy{}); err == nil {
+		t.Fatalf("expected alice's policy update to fail")
+	}
+	if bobChannelLink.policyUpdates != 2 {
+		t.Fatalf("expected bob's policy to be updated twice, got %v",
+			bobChannelLink.policyUpdates)
+	}
+}
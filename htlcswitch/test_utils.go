@@ -125,9 +125,12 @@ func createTestChannel(alicePrivKey, bobPrivKey []byte,
 	}
 	copy(hash[:], randomSeed)
 
+	// The funding output's index is taken from the short channel ID, as it
+	// would be on-chain, so that channels created with distinct short
+	// channel IDs always have distinct funding outpoints.
 	prevOut := &wire.OutPoint{
 		Hash:  chainhash.Hash(hash),
-		Index: 0,
+		Index: uint32(chanID.TxPosition),
 	}
 	fundingTxIn := wire.NewTxIn(prevOut, nil, nil)
 
@@ -785,10 +788,11 @@ func newThreeHopNetwork(t testing.TB, aliceChannel, firstBobChannel,
 			UpdateContractSignals: func(*contractcourt.ContractSignals) error {
 				return nil
 			},
-			ChainEvents: &contractcourt.ChainEventSubscription{},
-			SyncStates:  true,
-			BatchTicker: &mockTicker{aliceTicker.C},
-			BatchSize:   10,
+			ChainEvents:         &contractcourt.ChainEventSubscription{},
+			SyncStates:          true,
+			BatchTicker:         &mockTicker{aliceTicker.C},
+			BatchSize:           10,
+			QuiescenceSupported: true,
 		},
 		aliceChannel,
 		startingHeight,
@@ -831,10 +835,11 @@ func newThreeHopNetwork(t testing.TB, aliceChannel, firstBobChannel,
 			UpdateContractSignals: func(*contractcourt.ContractSignals) error {
 				return nil
 			},
-			ChainEvents: &contractcourt.ChainEventSubscription{},
-			SyncStates:  true,
-			BatchTicker: &mockTicker{firstBobTicker.C},
-			BatchSize:   10,
+			ChainEvents:         &contractcourt.ChainEventSubscription{},
+			SyncStates:          true,
+			BatchTicker:         &mockTicker{firstBobTicker.C},
+			BatchSize:           10,
+			QuiescenceSupported: true,
 		},
 		firstBobChannel,
 		startingHeight,
@@ -877,10 +882,11 @@ func newThreeHopNetwork(t testing.TB, aliceChannel, firstBobChannel,
 			UpdateContractSignals: func(*contractcourt.ContractSignals) error {
 				return nil
 			},
-			ChainEvents: &contractcourt.ChainEventSubscription{},
-			SyncStates:  true,
-			BatchTicker: &mockTicker{secondBobTicker.C},
-			BatchSize:   10,
+			ChainEvents:         &contractcourt.ChainEventSubscription{},
+			SyncStates:          true,
+			BatchTicker:         &mockTicker{secondBobTicker.C},
+			BatchSize:           10,
+			QuiescenceSupported: true,
 		},
 		secondBobChannel,
 		startingHeight,
@@ -923,10 +929,11 @@ func newThreeHopNetwork(t testing.TB, aliceChannel, firstBobChannel,
 			UpdateContractSignals: func(*contractcourt.ContractSignals) error {
 				return nil
 			},
-			ChainEvents: &contractcourt.ChainEventSubscription{},
-			SyncStates:  true,
-			BatchTicker: &mockTicker{carolTicker.C},
-			BatchSize:   10,
+			ChainEvents:         &contractcourt.ChainEventSubscription{},
+			SyncStates:          true,
+			BatchTicker:         &mockTicker{carolTicker.C},
+			BatchSize:           10,
+			QuiescenceSupported: true,
 		},
 		carolChannel,
 		startingHeight,
@@ -0,0 +1,368 @@
+package htlcswitch
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+const (
+	// maxActiveTraces is the maximum number of in-flight circuit traces
+	// the switch will accumulate. Once reached, no new traces will be
+	// started until existing ones complete. This bounds the memory used
+	// by traces for HTLC's which are never resolved off-chain.
+	maxActiveTraces = 10000
+
+	// traceSubscriberBuffer is the number of completed traces buffered
+	// for each subscriber. If a subscriber falls further behind, then
+	// traces are dropped rather than blocking the switch.
+	traceSubscriberBuffer = 100
+)
+
+// TraceStage denotes a point within the lifetime of a forwarded HTLC at which
+// a span is recorded.
+type TraceStage uint8
+
+const (
+	// TraceReceived is recorded once an incoming HTLC has been locked in
+	// and decoded as one to be forwarded.
+	TraceReceived TraceStage = iota
+
+	// TracePolicyChecked is recorded once the incoming HTLC has satisfied
+	// our forwarding policy.
+	TracePolicyChecked
+
+	// TraceBandwidthChecked is recorded once the switch has located an
+	// outgoing link with sufficient bandwidth to carry the HTLC.
+	TraceBandwidthChecked
+
+	// TraceOutgoingAddSent is recorded once the outgoing link has added
+	// the HTLC to its log and sent it to the remote peer.
+	TraceOutgoingAddSent
+
+	// TraceCommitted is recorded once the outgoing link has sent a
+	// commitment signature covering the outgoing HTLC.
+	TraceCommitted
+
+	// TraceResolved is recorded once the HTLC has been settled or failed
+	// back towards the incoming link.
+	TraceResolved
+
+	// TraceDownstreamResolved is recorded once the outgoing link has
+	// received a settle or fail of the outgoing HTLC from the remote
+	// peer. It precedes TraceResolved.
+	TraceDownstreamResolved
+)
+
+// String returns a human readable string describing the TraceStage.
+func (t TraceStage) String() string {
+	switch t {
+	case TraceReceived:
+		return "Received"
+
+	case TracePolicyChecked:
+		return "PolicyChecked"
+
+	case TraceBandwidthChecked:
+		return "BandwidthChecked"
+
+	case TraceOutgoingAddSent:
+		return "OutgoingAddSent"
+
+	case TraceCommitted:
+		return "Committed"
+
+	case TraceResolved:
+		return "Resolved"
+
+	case TraceDownstreamResolved:
+		return "DownstreamResolved"
+
+	default:
+		return "unknown stage"
+	}
+}
+
+// TraceSpan is a single timestamped stage within a CircuitTrace.
+type TraceSpan struct {
+	// Stage is the stage of the HTLC's lifetime the span marks.
+	Stage TraceStage
+
+	// Timestamp is the time at which the stage was reached.
+	Timestamp time.Time
+}
+
+// CircuitTrace is the set of spans accumulated by a single forwarded HTLC,
+// from the time it was received until the time it was resolved.
+type CircuitTrace struct {
+	// PaymentHash is the payment hash of the forwarded HTLC.
+	PaymentHash [32]byte
+
+	// CorrelationID is the locally assigned ID of the forward, shared by
+	// all DecisionRecords made for it.
+	CorrelationID uint64
+
+	// IncomingChanID and IncomingHTLCID identify the HTLC on the incoming
+	// channel.
+	IncomingChanID lnwire.ShortChannelID
+	IncomingHTLCID uint64
+
+	// OutgoingChanID and OutgoingHTLCID identify the HTLC on the outgoing
+	// channel. These are only populated once the outgoing add has been
+	// sent.
+	OutgoingChanID lnwire.ShortChannelID
+	OutgoingHTLCID uint64
+
+	// Settled is true if the HTLC was settled, and false if it was
+	// failed.
+	Settled bool
+
+	// Spans is the set of spans recorded for the HTLC, ordered from the
+	// earliest to the latest.
+	Spans []TraceSpan
+}
+
+// Duration returns the time elapsed between the first and last span of the
+// trace.
+func (c *CircuitTrace) Duration() time.Duration {
+	if len(c.Spans) == 0 {
+		return 0
+	}
+
+	return c.Spans[len(c.Spans)-1].Timestamp.Sub(c.Spans[0].Timestamp)
+}
+
+// TraceSubscription is returned by SubscribeTraces, and delivers each
+// completed CircuitTrace to the caller.
+type TraceSubscription struct {
+	// Traces is the channel over which completed traces are sent. The
+	// channel is closed once the subscription has been cancelled.
+	Traces <-chan *CircuitTrace
+
+	// Cancel cancels the subscription.
+	Cancel func()
+}
+
+// circuitTracer accumulates the spans of forwarded HTLC's while tracing is
+// enabled, and delivers completed traces to all active subscribers. All spans
+// are keyed by the incoming circuit of the HTLC.
+type circuitTracer struct {
+	// enabled is accessed atomically, and gates all span recording such
+	// that the tracer adds near zero overhead while disabled.
+	enabled int32
+
+	sync.Mutex
+
+	active map[circuitKey]*CircuitTrace
+
+	// hopLatency maps each incoming channel to the distribution of the
+	// latency our node added to the completed traces of HTLC's forwarded
+	// from it.
+	hopLatency map[lnwire.ShortChannelID]*waitTimeRecorder
+
+	subscribers map[uint64]chan *CircuitTrace
+	nextSubID   uint64
+}
+
+// newCircuitTracer creates a new, disabled circuitTracer.
+func newCircuitTracer() *circuitTracer {
+	return &circuitTracer{
+		active:      make(map[circuitKey]*CircuitTrace),
+		hopLatency:  make(map[lnwire.ShortChannelID]*waitTimeRecorder),
+		subscribers: make(map[uint64]chan *CircuitTrace),
+	}
+}
+
+// isEnabled returns true if span recording is currently enabled.
+func (c *circuitTracer) isEnabled() bool {
+	return atomic.LoadInt32(&c.enabled) == 1
+}
+
+// start begins a new trace for the HTLC identified by the passed incoming
+// circuit, tagged with the forward's correlation ID, recording its
+// TraceReceived span.
+func (c *circuitTracer) start(chanID lnwire.ShortChannelID, htlcID uint64,
+	paymentHash [32]byte, correlationID uint64) {
+
+	if !c.isEnabled() {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.active) >= maxActiveTraces {
+		return
+	}
+
+	key := circuitKey{chanID: chanID, htlcID: htlcID}
+	c.active[key] = &CircuitTrace{
+		PaymentHash:    paymentHash,
+		CorrelationID:  correlationID,
+		IncomingChanID: chanID,
+		IncomingHTLCID: htlcID,
+		Spans: []TraceSpan{{
+			Stage:     TraceReceived,
+			Timestamp: time.Now(),
+		}},
+	}
+}
+
+// record adds a span for the passed stage to the trace of the HTLC identified
+// by the passed incoming circuit. If no such trace is active, then the span is
+// discarded.
+func (c *circuitTracer) record(chanID lnwire.ShortChannelID, htlcID uint64,
+	stage TraceStage) {
+
+	if !c.isEnabled() {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	trace, ok := c.active[circuitKey{chanID: chanID, htlcID: htlcID}]
+	if !ok {
+		return
+	}
+
+	trace.Spans = append(trace.Spans, TraceSpan{
+		Stage:     stage,
+		Timestamp: time.Now(),
+	})
+}
+
+// recordOutgoing adds a TraceOutgoingAddSent span to the trace of the HTLC
+// identified by the passed incoming circuit, along with the outgoing circuit
+// the HTLC was sent over.
+func (c *circuitTracer) recordOutgoing(chanID lnwire.ShortChannelID,
+	htlcID uint64, outChanID lnwire.ShortChannelID, outHtlcID uint64) {
+
+	if !c.isEnabled() {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	trace, ok := c.active[circuitKey{chanID: chanID, htlcID: htlcID}]
+	if !ok {
+		return
+	}
+
+	trace.OutgoingChanID = outChanID
+	trace.OutgoingHTLCID = outHtlcID
+	trace.Spans = append(trace.Spans, TraceSpan{
+		Stage:     TraceOutgoingAddSent,
+		Timestamp: time.Now(),
+	})
+}
+
+// complete records the final TraceResolved span for the HTLC identified by
+// the passed incoming circuit, and delivers its trace to all subscribers.
+func (c *circuitTracer) complete(chanID lnwire.ShortChannelID, htlcID uint64,
+	settled bool) {
+
+	if !c.isEnabled() {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	key := circuitKey{chanID: chanID, htlcID: htlcID}
+	trace, ok := c.active[key]
+	if !ok {
+		return
+	}
+	delete(c.active, key)
+
+	trace.Settled = settled
+	trace.Spans = append(trace.Spans, TraceSpan{
+		Stage:     TraceResolved,
+		Timestamp: time.Now(),
+	})
+
+	if latency, ok := trace.AddedLatency(); ok {
+		recorder, ok := c.hopLatency[chanID]
+		if !ok {
+			recorder = newWaitTimeRecorder()
+			c.hopLatency[chanID] = recorder
+		}
+		recorder.Observe(latency)
+	}
+
+	for id, sub := range c.subscribers {
+		select {
+		case sub <- trace:
+		default:
+			log.Warnf("Dropping circuit trace for htlc(%x), "+
+				"subscriber %v is falling behind",
+				trace.PaymentHash[:], id)
+		}
+	}
+}
+
+// EnableTracing enables the recording of spans for all HTLC's forwarded by
+// the switch from this point onwards. Completed traces are delivered to the
+// subscribers registered via SubscribeTraces.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) EnableTracing() {
+	log.Infof("Enabling circuit tracing")
+
+	atomic.StoreInt32(&s.tracer.enabled, 1)
+}
+
+// DisableTracing disables the recording of spans, and discards all traces
+// which have yet to complete.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) DisableTracing() {
+	log.Infof("Disabling circuit tracing")
+
+	atomic.StoreInt32(&s.tracer.enabled, 0)
+
+	s.tracer.Lock()
+	s.tracer.active = make(map[circuitKey]*CircuitTrace)
+	s.tracer.Unlock()
+}
+
+// TracingEnabled returns true if circuit tracing is currently enabled.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) TracingEnabled() bool {
+	return s.tracer.isEnabled()
+}
+
+// SubscribeTraces returns a new subscription which delivers each circuit
+// trace completed while tracing is enabled.
+//
+// NOTE: This method is safe for concurrent access.
+func (s *Switch) SubscribeTraces() *TraceSubscription {
+	c := s.tracer
+
+	c.Lock()
+	defer c.Unlock()
+
+	id := c.nextSubID
+	c.nextSubID++
+
+	traces := make(chan *CircuitTrace, traceSubscriberBuffer)
+	c.subscribers[id] = traces
+
+	var once sync.Once
+	return &TraceSubscription{
+		Traces: traces,
+		Cancel: func() {
+			once.Do(func() {
+				c.Lock()
+				delete(c.subscribers, id)
+				close(traces)
+				c.Unlock()
+			})
+		},
+	}
+}
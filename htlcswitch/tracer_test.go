@@ -0,0 +1,138 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestSwitchCircuitTracing tests that once tracing has been enabled, an HTLC
+// forwarded by Bob from Alice to Carol produces a completed trace containing
+// each stage of the forward, in order.
+func TestSwitchCircuitTracing(t *testing.T) {
+	t.Parallel()
+
+	channels, cleanUp, _, err := createClusterChannels(
+		btcutil.SatoshiPerBitcoin*3,
+		btcutil.SatoshiPerBitcoin*5)
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	defer cleanUp()
+
+	n := newThreeHopNetwork(t, channels.aliceToBob, channels.bobToAlice,
+		channels.bobToCarol, channels.carolToBob, testStartingHeight)
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.stop()
+
+	bobSwitch := n.bobServer.htlcSwitch
+	if bobSwitch.TracingEnabled() {
+		t.Fatalf("tracing shouldn't be enabled by default")
+	}
+
+	sub := bobSwitch.SubscribeTraces()
+	defer sub.Cancel()
+
+	bobSwitch.EnableTracing()
+
+	amount := lnwire.NewMSatFromSatoshis(btcutil.SatoshiPerBitcoin)
+	htlcAmt, totalTimelock, hops := generateHops(amount,
+		testStartingHeight, n.firstBobChannelLink, n.carolChannelLink)
+
+	rhash, err := n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	var trace *CircuitTrace
+	select {
+	case trace = <-sub.Traces:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("trace not received")
+	}
+
+	if trace.PaymentHash != rhash {
+		t.Fatalf("wrong payment hash: expected %x, got %x", rhash[:],
+			trace.PaymentHash[:])
+	}
+	if !trace.Settled {
+		t.Fatalf("trace should be marked as settled")
+	}
+	if trace.IncomingChanID != n.firstBobChannelLink.ShortChanID() {
+		t.Fatalf("wrong incoming channel: expected %v, got %v",
+			n.firstBobChannelLink.ShortChanID(),
+			trace.IncomingChanID)
+	}
+	if trace.OutgoingChanID != n.secondBobChannelLink.ShortChanID() {
+		t.Fatalf("wrong outgoing channel: expected %v, got %v",
+			n.secondBobChannelLink.ShortChanID(),
+			trace.OutgoingChanID)
+	}
+
+	// The trace should carry the correlation ID assigned to the forward,
+	// which is shared by the decision Bob's switch made for it.
+	if trace.CorrelationID == 0 {
+		t.Fatalf("trace should carry a correlation id")
+	}
+	decisions := bobSwitch.RecentDecisions(0)
+	if len(decisions) != 1 {
+		t.Fatalf("expected 1 decision, got %v", len(decisions))
+	}
+	if decisions[0].CorrelationID != trace.CorrelationID {
+		t.Fatalf("decision has correlation id %v, trace has %v",
+			decisions[0].CorrelationID, trace.CorrelationID)
+	}
+
+	expectedStages := []TraceStage{
+		TraceReceived,
+		TracePolicyChecked,
+		TraceBandwidthChecked,
+		TraceOutgoingAddSent,
+		TraceCommitted,
+		TraceDownstreamResolved,
+		TraceResolved,
+	}
+	if len(trace.Spans) != len(expectedStages) {
+		t.Fatalf("expected %v spans, got %v: %v", len(expectedStages),
+			len(trace.Spans), spew.Sdump(trace.Spans))
+	}
+	for i, span := range trace.Spans {
+		if span.Stage != expectedStages[i] {
+			t.Fatalf("span %v: expected stage %v, got %v", i,
+				expectedStages[i], span.Stage)
+		}
+		if i > 0 && span.Timestamp.Before(trace.Spans[i-1].Timestamp) {
+			t.Fatalf("span %v recorded before span %v", i, i-1)
+		}
+	}
+
+	// Once tracing is disabled, further payments shouldn't produce any
+	// traces.
+	bobSwitch.DisableTracing()
+
+	_, err = n.makePayment(n.aliceServer, n.carolServer,
+		n.bobServer.PubKey(), hops, amount, htlcAmt,
+		totalTimelock).Wait(30 * time.Second)
+	if err != nil {
+		t.Fatalf("unable to send payment: %v", err)
+	}
+
+	select {
+	case trace := <-sub.Traces:
+		t.Fatalf("unexpected trace: %v", spew.Sdump(trace))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Cancelling the subscription should close the traces channel.
+	sub.Cancel()
+	if _, ok := <-sub.Traces; ok {
+		t.Fatalf("traces channel should be closed")
+	}
+}
@@ -0,0 +1,257 @@
+package htlcswitch
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// transferableLink is implemented by links which can be moved from one switch
+// to another by TransferLink.
+type transferableLink interface {
+	// transferSwitch pauses the link while the passed transfer function
+	// runs. If it succeeds, then the link is attached to the passed
+	// switch, and resumes.
+	transferSwitch(to *Switch, transfer func() error) error
+}
+
+// TransferLink moves the passed link from this switch to the destination
+// switch, along with the circuits of the HTLC's it has forwarded, such that
+// their settles and fails are delivered by the destination switch. The link
+// keeps running, but doesn't process any updates until the transfer has
+// completed, and neither switch forwards HTLC's over it in the meantime. If the
+// link can't be attached to the destination switch, then it's re-attached to
+// this switch.
+//
+// Circuits of HTLC's which arrived over the link are left with this switch, as
+// they belong to their outgoing link. As a result, such HTLC's can only be
+// resolved once their outgoing link has been transferred as well.
+//
+// NOTE: This is test-support API, which allows integration tests to simulate
+// the restart or migration of a node without tearing down its links.
+func (s *Switch) TransferLink(link ChannelLink, to *Switch) error {
+	transferable, ok := link.(transferableLink)
+	if !ok {
+		return errors.Errorf("ChannelLink(%v) can't be transferred",
+			link)
+	}
+
+	return transferable.transferSwitch(to, func() error {
+		circuits, err := s.DetachLink(link.ChanID())
+		if err != nil {
+			return err
+		}
+
+		err = to.AttachLink(link, circuits)
+		if err == nil {
+			log.Infof("Transferred ChannelLink(%v) along with %v "+
+				"circuits", link, len(circuits))
+			return nil
+		}
+
+		if err := s.AttachLink(link, circuits); err != nil {
+			log.Errorf("Unable to re-attach ChannelLink(%v): %v",
+				link, err)
+		}
+		return err
+	})
+}
+
+// detachLinkCmd is a message sent to the switch in order to detach a link
+// without stopping it.
+type detachLinkCmd struct {
+	chanID lnwire.ChannelID
+
+	done chan []*PaymentCircuit
+	err  chan error
+}
+
+// DetachLink removes the link of the target channel from the switch without
+// stopping it, returning the circuits of the HTLC's it has forwarded, which
+// are removed as well.
+//
+// NOTE: This is test-support API, see TransferLink.
+func (s *Switch) DetachLink(chanID lnwire.ChannelID) ([]*PaymentCircuit, error) {
+	cmd := &detachLinkCmd{
+		chanID: chanID,
+		done:   make(chan []*PaymentCircuit, 1),
+		err:    make(chan error, 1),
+	}
+
+	select {
+	case s.linkControl <- cmd:
+	case <-s.quit:
+		return nil, errors.New("htlc switch was stopped")
+	}
+
+	select {
+	case err := <-cmd.err:
+		return <-cmd.done, err
+	case <-s.quit:
+		return nil, errors.New("htlc switch was stopped")
+	}
+}
+
+// detachLink removes the link of the target channel from the switch's
+// indexes, along with the circuits of the HTLC's it has forwarded.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) detachLink(chanID lnwire.ChannelID) ([]*PaymentCircuit,
+	error) {
+
+	link, ok := s.linkIndex[chanID]
+	if !ok {
+		return nil, ErrChannelLinkNotFound
+	}
+
+	delete(s.linkIndex, chanID)
+	delete(s.forwardingIndex, link.ShortChanID())
+
+	peerPub := link.Peer().PubKey()
+	delete(s.interfaceIndex[peerPub], link)
+	if len(s.interfaceIndex[peerPub]) == 0 {
+		delete(s.interfaceIndex, peerPub)
+	}
+
+	var circuits []*PaymentCircuit
+	for _, circuit := range s.circuits.circuitList() {
+		if circuit.OutgoingChanID != link.ShortChanID() {
+			continue
+		}
+
+		err := s.circuits.Remove(
+			circuit.OutgoingChanID, circuit.OutgoingHTLCID,
+		)
+		if err != nil {
+			log.Errorf("Unable to remove circuit of detached "+
+				"ChannelLink(%v): %v", link, err)
+			continue
+		}
+		circuits = append(circuits, circuit)
+	}
+
+	log.Infof("Detached channel link with chan_id=%v along with %v "+
+		"circuits", chanID, len(circuits))
+
+	return circuits, nil
+}
+
+// attachLinkCmd is a message sent to the switch in order to attach an already
+// running link.
+type attachLinkCmd struct {
+	link     ChannelLink
+	circuits []*PaymentCircuit
+
+	err chan error
+}
+
+// AttachLink adds an already running link to the switch, along with the
+// circuits of the HTLC's it has forwarded, as returned by DetachLink. If any
+// of the circuits conflicts with a pending circuit, then neither the link nor
+// its circuits are added.
+//
+// NOTE: This is test-support API, see TransferLink.
+func (s *Switch) AttachLink(link ChannelLink, circuits []*PaymentCircuit) error {
+	cmd := &attachLinkCmd{
+		link:     link,
+		circuits: circuits,
+		err:      make(chan error, 1),
+	}
+
+	select {
+	case s.linkControl <- cmd:
+	case <-s.quit:
+		return errors.New("htlc switch was stopped")
+	}
+
+	select {
+	case err := <-cmd.err:
+		return err
+	case <-s.quit:
+		return errors.New("htlc switch was stopped")
+	}
+}
+
+// attachLink adds the passed running link and its circuits to the switch.
+//
+// NOTE: This MUST only be called from within the htlcForwarder goroutine.
+func (s *Switch) attachLink(link ChannelLink, circuits []*PaymentCircuit) error {
+	if _, ok := s.linkIndex[link.ChanID()]; ok {
+		return errors.Errorf("channel link with chan_id=%v already "+
+			"attached", link.ChanID())
+	}
+
+	for _, circuit := range circuits {
+		existing := s.circuits.LookupByHTLC(
+			circuit.OutgoingChanID, circuit.OutgoingHTLCID,
+		)
+		if existing != nil {
+			return errors.Errorf("conflicting circuit for "+
+				"outgoing HTLC (Chan ID=%s, HTLC ID=%d)",
+				circuit.OutgoingChanID, circuit.OutgoingHTLCID)
+		}
+	}
+
+	s.linkIndex[link.ChanID()] = link
+	s.forwardingIndex[link.ShortChanID()] = link
+
+	peerPub := link.Peer().PubKey()
+	if _, ok := s.interfaceIndex[peerPub]; !ok {
+		s.interfaceIndex[peerPub] = make(map[ChannelLink]struct{})
+	}
+	s.interfaceIndex[peerPub][link] = struct{}{}
+
+	for _, circuit := range circuits {
+		s.circuits.Add(circuit)
+	}
+
+	log.Infof("Attached channel link with chan_id=%v along with %v "+
+		"circuits", link.ChanID(), len(circuits))
+
+	return nil
+}
+
+// switchTransferReq is sent to a channel link in order to move it to another
+// switch.
+type switchTransferReq struct {
+	to       *Switch
+	transfer func() error
+
+	err chan error
+}
+
+// transferSwitch pauses the link while the passed transfer function runs
+// within the htlcManager goroutine. If it succeeds, then the link hands all
+// further packets to the passed switch.
+func (l *channelLink) transferSwitch(to *Switch, transfer func() error) error {
+	req := &switchTransferReq{
+		to:       to,
+		transfer: transfer,
+		err:      make(chan error, 1),
+	}
+
+	select {
+	case l.linkControl <- req:
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+
+	select {
+	case err := <-req.err:
+		return err
+	case <-l.quit:
+		return errors.New("link shutting down")
+	}
+}
+
+// handleSwitchTransfer runs the transfer of the passed request, attaching the
+// link to the destination switch if it succeeds.
+//
+// NOTE: This MUST only be called from within the htlcManager goroutine.
+func (l *channelLink) handleSwitchTransfer(req *switchTransferReq) {
+	err := req.transfer()
+	if err == nil {
+		l.cfg.Switch = req.to
+	}
+
+	req.err <- err
+}
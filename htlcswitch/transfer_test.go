@@ -0,0 +1,145 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// TestSwitchTransferLink tests that a link transferred to another switch takes
+// the circuits of the HTLC's it has forwarded along, such that they can be
+// settled through the destination switch, and that the source switch no
+// longer forwards HTLC's over it.
+func TestSwitchTransferLink(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	source := New(Config{})
+	if err := source.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer source.Stop()
+
+	dest := New(Config{})
+	if err := dest.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer dest.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		source, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		source, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := source.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := source.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	// forward sends an HTLC from Alice to Bob through the source switch.
+	// An error is returned if Bob's link can't be found, in which case the
+	// HTLC is failed back to Alice, so we won't check it here.
+	forward := func(htlcID uint64) {
+		source.forward(&htlcPacket{
+			incomingChanID: aliceChanID,
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      1,
+			},
+		})
+	}
+
+	// Alice forwards an HTLC to Bob, opening a circuit on the source
+	// switch.
+	forward(0)
+	select {
+	case <-bobChannelLink.packets:
+	case <-time.After(time.Second):
+		t.Fatalf("htlc wasn't forwarded to bob")
+	}
+
+	// Transfer Bob's link, which should take the circuit along.
+	if err := source.TransferLink(bobChannelLink, dest); err != nil {
+		t.Fatalf("unable to transfer bob link: %v", err)
+	}
+	if bobChannelLink.htlcSwitch != dest {
+		t.Fatalf("bob link wasn't attached to the destination switch")
+	}
+	if _, err := source.GetLink(chanID2); err != ErrChannelLinkNotFound {
+		t.Fatalf("bob link wasn't removed from the source switch")
+	}
+	if _, err := dest.GetLink(chanID2); err != nil {
+		t.Fatalf("bob link wasn't added to the destination switch: %v",
+			err)
+	}
+	if source.circuits.pending() != 0 {
+		t.Fatalf("circuit wasn't removed from the source switch")
+	}
+	if dest.circuits.pending() != 1 {
+		t.Fatalf("circuit wasn't added to the destination switch")
+	}
+
+	// The source switch should no longer forward HTLC's to Bob, so another
+	// HTLC from Alice is failed back.
+	forward(1)
+	select {
+	case pkt := <-aliceChannelLink.packets:
+		if _, ok := pkt.htlc.(*lnwire.UpdateFailHTLC); !ok {
+			t.Fatalf("expected fail, got %T", pkt.htlc)
+		}
+	case <-bobChannelLink.packets:
+		t.Fatalf("htlc was forwarded to transferred link")
+	case <-time.After(time.Second):
+		t.Fatalf("htlc wasn't failed back")
+	}
+
+	// Once Alice's link has been transferred as well, Bob's settle is
+	// delivered to Alice by the destination switch.
+	if err := source.TransferLink(aliceChannelLink, dest); err != nil {
+		t.Fatalf("unable to transfer alice link: %v", err)
+	}
+	err := dest.forward(&htlcPacket{
+		outgoingChanID: bobChanID,
+		outgoingHTLCID: 0,
+		amount:         1,
+		htlc: &lnwire.UpdateFulfillHTLC{
+			PaymentPreimage: preimage,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to forward settle: %v", err)
+	}
+	select {
+	case pkt := <-aliceChannelLink.packets:
+		if _, ok := pkt.htlc.(*lnwire.UpdateFulfillHTLC); !ok {
+			t.Fatalf("expected settle, got %T", pkt.htlc)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("settle wasn't propagated to alice")
+	}
+	if dest.circuits.pending() != 0 {
+		t.Fatalf("circuit wasn't closed by the settle")
+	}
+
+	// Transferring a link which isn't attached to the switch should fail,
+	// and leave it attached to the switch it's on.
+	if err := source.TransferLink(bobChannelLink, dest); err == nil {
+		t.Fatalf("transfer of detached link should fail")
+	}
+	if bobChannelLink.htlcSwitch != dest {
+		t.Fatalf("failed transfer changed the link's switch")
+	}
+}
@@ -0,0 +1,24 @@
+package htlcswitch
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// paysInvoice returns true if the passed amount is sufficient to settle an
+// invoice of the passed value, allowing for a shortfall of up to the link's
+// UnderpaymentGrace.
+func (l *channelLink) paysInvoice(amt, invoiceValue lnwire.MilliSatoshi) bool {
+	if amt >= invoiceValue {
+		return true
+	}
+
+	shortfall := invoiceValue - amt
+	if shortfall > l.cfg.UnderpaymentGrace {
+		return false
+	}
+
+	log.Debugf("ChannelLink(%v) accepting underpayment of %v within "+
+		"grace of %v", l, shortfall, l.cfg.UnderpaymentGrace)
+
+	return true
+}
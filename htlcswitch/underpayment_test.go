@@ -0,0 +1,113 @@
+package htlcswitch
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/roasbeef/btcutil"
+)
+
+// TestChannelLinkUnderpaymentGrace tests that an HTLC paying one of our
+// invoices settles it if it falls short of the invoice's amount by no more
+// than the configured grace, and is failed with an incorrect payment amount
+// otherwise.
+func TestChannelLinkUnderpaymentGrace(t *testing.T) {
+	t.Parallel()
+
+	const (
+		chanAmt    = btcutil.SatoshiPerBitcoin * 5
+		invoiceAmt = lnwire.MilliSatoshi(100000000)
+		grace      = lnwire.MilliSatoshi(10)
+	)
+
+	tests := []struct {
+		name      string
+		grace     lnwire.MilliSatoshi
+		shortfall lnwire.MilliSatoshi
+		settled   bool
+	}{
+		{"exact amount", 0, 0, true},
+		{"strict by default", 0, 1, false},
+		{"within grace", grace, grace, true},
+		{"beyond grace", grace, grace + 1, false},
+	}
+
+	for _, test := range tests {
+		link, bobChannel, batchTick, cleanUp, err :=
+			newSingleLinkTestHarness(chanAmt, 0)
+		if err != nil {
+			t.Fatalf("unable to create link: %v", err)
+		}
+
+		aliceLink := link.(*channelLink)
+		aliceLink.cfg.UnderpaymentGrace = test.grace
+		aliceMsgs := aliceLink.cfg.Peer.(*mockPeer).sentMsgs
+
+		htlcAmt, totalTimelock, hops := generateHops(
+			invoiceAmt-test.shortfall, testStartingHeight,
+			aliceLink,
+		)
+		blob, err := generateRoute(hops...)
+		if err != nil {
+			t.Fatalf("unable to gen route: %v", err)
+		}
+		invoice, htlc, err := generatePayment(
+			invoiceAmt, htlcAmt, totalTimelock, blob,
+		)
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+		registry := aliceLink.cfg.Registry.(*mockInvoiceRegistry)
+		if err := registry.AddInvoice(*invoice); err != nil {
+			t.Fatalf("unable to add invoice: %v", err)
+		}
+
+		if _, err := bobChannel.AddHTLC(htlc); err != nil {
+			t.Fatalf("unable to add htlc: %v", err)
+		}
+		aliceLink.HandleChannelUpdate(htlc)
+		err = updateState(batchTick, aliceLink, bobChannel, false)
+		if err != nil {
+			t.Fatalf("unable to update state: %v", err)
+		}
+
+		var msg lnwire.Message
+		select {
+		case msg = <-aliceMsgs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: did not receive settle or fail", test.name)
+		}
+
+		switch msg := msg.(type) {
+		case *lnwire.UpdateFulfillHTLC:
+			if !test.settled {
+				t.Fatalf("%v: expected htlc to be failed",
+					test.name)
+			}
+
+		case *lnwire.UpdateFailHTLC:
+			if test.settled {
+				t.Fatalf("%v: expected htlc to be settled",
+					test.name)
+			}
+			failure, err := lnwire.DecodeFailure(
+				bytes.NewReader(msg.Reason), 0,
+			)
+			if err != nil {
+				t.Fatalf("unable to decode failure: %v", err)
+			}
+			_, ok := failure.(*lnwire.FailIncorrectPaymentAmount)
+			if !ok {
+				t.Fatalf("%v: expected FailIncorrectPaymentAmount, "+
+					"got %T", test.name, failure)
+			}
+
+		default:
+			t.Fatalf("%v: unexpected message %T", test.name, msg)
+		}
+
+		cleanUp()
+	}
+}
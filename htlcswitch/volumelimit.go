@@ -0,0 +1,134 @@
+package htlcswitch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// VolumeLimit caps the total value of HTLC's forwarded by the switch across
+// all channels within each window. Only forwards which have been settled count
+// towards the volume of a window, while locally initiated payments are exempt.
+// Once a forward would take the volume of the current window above MaxVolume,
+// it's failed back with a temporary channel failure until the window rolls
+// over. The zero value disables the limit.
+type VolumeLimit struct {
+	// Window is the duration of each window. Once it has elapsed, the
+	// volume is reset, and a new window begins.
+	Window time.Duration
+
+	// MaxVolume is the maximum value of settled forwards within a single
+	// window.
+	MaxVolume lnwire.MilliSatoshi
+}
+
+// enabled returns true if the limit is to be enforced.
+func (l *VolumeLimit) enabled() bool {
+	return l.Window != 0 && l.MaxVolume != 0
+}
+
+// VolumeUtilization describes the volume forwarded within the current window
+// of the switch's VolumeLimit.
+type VolumeUtilization struct {
+	// WindowStart is the time the current window began.
+	WindowStart time.Time
+
+	// Window is the duration of each window.
+	Window time.Duration
+
+	// Volume is the value of the forwards settled within the current
+	// window.
+	Volume lnwire.MilliSatoshi
+
+	// MaxVolume is the maximum value of settled forwards within a single
+	// window.
+	MaxVolume lnwire.MilliSatoshi
+}
+
+// volumeLimiter tracks the value of settled forwards within the current
+// window of a VolumeLimit.
+type volumeLimiter struct {
+	sync.Mutex
+
+	limit VolumeLimit
+
+	// now returns the current time. It's overridden within tests in
+	// order to control the rolling over of windows.
+	now func() time.Time
+
+	windowStart time.Time
+	volume      lnwire.MilliSatoshi
+}
+
+// newVolumeLimiter creates a new volumeLimiter enforcing the passed limit.
+func newVolumeLimiter(limit VolumeLimit) *volumeLimiter {
+	return &volumeLimiter{
+		limit: limit,
+		now:   time.Now,
+	}
+}
+
+// roll starts a new window if the current one has elapsed.
+//
+// NOTE: The mutex MUST be held when calling this method.
+func (v *volumeLimiter) roll() {
+	now := v.now()
+	if now.Before(v.windowStart.Add(v.limit.Window)) {
+		return
+	}
+
+	v.windowStart = now
+	v.volume = 0
+}
+
+// permits returns true if a forward of the passed amount wouldn't take the
+// volume of the current window above the limit.
+func (v *volumeLimiter) permits(amt lnwire.MilliSatoshi) bool {
+	if !v.limit.enabled() {
+		return true
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	v.roll()
+	return v.volume+amt <= v.limit.MaxVolume
+}
+
+// settled adds a settled forward of the passed amount to the volume of the
+// current window.
+func (v *volumeLimiter) settled(amt lnwire.MilliSatoshi) {
+	if !v.limit.enabled() {
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	v.roll()
+	v.volume += amt
+}
+
+// utilization returns the volume forwarded within the current window.
+func (v *volumeLimiter) utilization() VolumeUtilization {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.limit.enabled() {
+		v.roll()
+	}
+
+	return VolumeUtilization{
+		WindowStart: v.windowStart,
+		Window:      v.limit.Window,
+		Volume:      v.volume,
+		MaxVolume:   v.limit.MaxVolume,
+	}
+}
+
+// VolumeUtilization returns the volume forwarded within the current window of
+// the switch's VolumeLimit.
+func (s *Switch) VolumeUtilization() VolumeUtilization {
+	return s.volumeLimiter.utilization()
+}
@@ -0,0 +1,180 @@
+package htlcswitch
+
+import (
+	"crypto/sha256"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// mockClock is a clock whose time only moves once advanced.
+type mockClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *mockClock) advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	c.Unlock()
+}
+
+// TestSwitchForwardVolumeLimit ensures that forwards which would take the
+// value of settled forwards within the current window above the limit are
+// failed back, that failed forwards don't count towards the limit, and that
+// forwards are permitted again once the window rolls over.
+func TestSwitchForwardVolumeLimit(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := newMockServer(t, "alice")
+	bobPeer := newMockServer(t, "bob")
+
+	const window = time.Hour
+	s := New(Config{
+		ForwardVolumeLimit: VolumeLimit{
+			Window:    window,
+			MaxVolume: 1500,
+		},
+	})
+	clock := &mockClock{now: time.Unix(1000, 0)}
+	s.volumeLimiter.now = clock.Now
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	preimage := [sha256.Size]byte{1}
+	rhash := sha256.Sum256(preimage[:])
+
+	// forward sends an HTLC of the passed amount from Alice to Bob, and
+	// returns true if it reached Bob, or false if it was failed back.
+	forward := func(htlcID uint64, amt lnwire.MilliSatoshi) bool {
+		s.forward(&htlcPacket{
+			incomingChanID: aliceChanID,
+			incomingHTLCID: htlcID,
+			outgoingChanID: bobChanID,
+			obfuscator:     newMockObfuscator(),
+			htlc: &lnwire.UpdateAddHTLC{
+				PaymentHash: rhash,
+				Amount:      amt,
+			},
+		})
+
+		select {
+		case <-bobChannelLink.packets:
+			return true
+		case pkt := <-aliceChannelLink.packets:
+			if _, ok := pkt.htlc.(*lnwire.UpdateFailHTLC); !ok {
+				t.Fatalf("expected fail, got %T", pkt.htlc)
+			}
+			return false
+		case <-time.After(time.Second):
+			t.Fatalf("htlc %v was neither forwarded nor failed",
+				htlcID)
+		}
+		return false
+	}
+
+	// resolve resolves the HTLC with the passed outgoing ID at Bob, and
+	// waits for the resolution to reach Alice.
+	resolve := func(outgoingID uint64, amt lnwire.MilliSatoshi,
+		settle bool) {
+
+		var htlc lnwire.Message = &lnwire.UpdateFailHTLC{}
+		if settle {
+			htlc = &lnwire.UpdateFulfillHTLC{
+				PaymentPreimage: preimage,
+			}
+		}
+		err := s.forward(&htlcPacket{
+			outgoingChanID: bobChanID,
+			outgoingHTLCID: outgoingID,
+			amount:         amt,
+			htlc:           htlc,
+		})
+		if err != nil {
+			t.Fatalf("unable to resolve htlc: %v", err)
+		}
+
+		select {
+		case <-aliceChannelLink.packets:
+		case <-time.After(time.Second):
+			t.Fatalf("resolution wasn't propagated to alice")
+		}
+	}
+
+	assertVolume := func(expected lnwire.MilliSatoshi) {
+		if volume := s.VolumeUtilization().Volume; volume != expected {
+			t.Fatalf("expected volume of %v, got %v", expected,
+				volume)
+		}
+	}
+
+	// The first forward fits within the limit, and counts towards the
+	// volume of the window once settled.
+	if !forward(0, 1000) {
+		t.Fatalf("forward within limit was failed")
+	}
+	assertVolume(0)
+	resolve(0, 1000, true)
+	assertVolume(1000)
+
+	// A second forward of the same amount would exceed the limit.
+	if forward(1, 1000) {
+		t.Fatalf("forward exceeding limit was forwarded")
+	}
+
+	// A smaller forward still fits, but as it fails, it doesn't count
+	// towards the volume.
+	if !forward(2, 500) {
+		t.Fatalf("forward within limit was failed")
+	}
+	resolve(1, 500, false)
+	assertVolume(1000)
+
+	// Once the window has nearly elapsed, the limit is still enforced.
+	clock.advance(window - time.Second)
+	if forward(3, 1000) {
+		t.Fatalf("forward exceeding limit was forwarded")
+	}
+
+	// Once the window rolls over, the volume is reset, and the forward is
+	// permitted.
+	clock.advance(time.Second)
+	utilization := s.VolumeUtilization()
+	if utilization.Volume != 0 {
+		t.Fatalf("expected volume to be reset, got %v",
+			utilization.Volume)
+	}
+	if !utilization.WindowStart.Equal(clock.Now()) {
+		t.Fatalf("expected window to start at %v, got %v",
+			clock.Now(), utilization.WindowStart)
+	}
+	if !forward(4, 1000) {
+		t.Fatalf("forward in new window was failed")
+	}
+	resolve(2, 1000, true)
+	assertVolume(1000)
+}
@@ -0,0 +1,166 @@
+package htlcswitch
+
+import (
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// DefaultWatchdogTimeout is the default time a link's htlcManager goroutine
+// has to acknowledge a heartbeat from the watchdog before it's considered
+// wedged.
+const DefaultWatchdogTimeout = time.Minute
+
+// WatchdogAction is the action the switch's link watchdog takes once a link
+// fails to acknowledge its heartbeat in time.
+type WatchdogAction uint8
+
+const (
+	// WatchdogLog only logs the unresponsive link.
+	WatchdogLog WatchdogAction = iota
+
+	// WatchdogDisconnect logs the unresponsive link, then disconnects from
+	// its peer, such that the link is torn down, and restarted once the
+	// peer reconnects.
+	WatchdogDisconnect
+)
+
+// String returns a human readable representation of the WatchdogAction.
+func (a WatchdogAction) String() string {
+	switch a {
+	case WatchdogLog:
+		return "log"
+	case WatchdogDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// heartbeatReq is sent to the htlcManager goroutine by Heartbeat, and is
+// acknowledged by closing ack.
+type heartbeatReq struct {
+	ack chan struct{}
+}
+
+// Heartbeat asks the link's htlcManager goroutine to acknowledge that it's
+// still processing requests. The returned channel is closed once it has. If
+// the link is wedged, then the channel is never closed, and the request is
+// only abandoned once the link exits, so callers shouldn't issue another
+// heartbeat until the prior one has been acknowledged.
+//
+// NOTE: Part of the ChannelLink interface.
+func (l *channelLink) Heartbeat() <-chan struct{} {
+	req := &heartbeatReq{
+		ack: make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case l.linkControl <- req:
+		case <-l.quit:
+		}
+	}()
+
+	return req.ack
+}
+
+// pendingHeartbeat is a heartbeat awaiting its acknowledgement by a link.
+type pendingHeartbeat struct {
+	ack      <-chan struct{}
+	sent     time.Time
+	reported bool
+}
+
+// watchdogTimeout returns the time a link has to acknowledge a heartbeat.
+func (s *Switch) watchdogTimeout() time.Duration {
+	if s.cfg.WatchdogTimeout == 0 {
+		return DefaultWatchdogTimeout
+	}
+
+	return s.cfg.WatchdogTimeout
+}
+
+// linkWatchdog periodically sends a heartbeat to each link, and reports any
+// link which fails to acknowledge it within the watchdog timeout, taking the
+// configured WatchdogAction.
+//
+// NOTE: This MUST be run as a goroutine.
+func (s *Switch) linkWatchdog() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.WatchdogInterval)
+	defer ticker.Stop()
+
+	pending := make(map[ChannelLink]*pendingHeartbeat)
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			return
+		}
+
+		links, err := s.fetchAllLinks()
+		if err != nil {
+			return
+		}
+
+		s.checkHeartbeats(links, pending)
+	}
+}
+
+// checkHeartbeats checks whether the heartbeats pending for the passed links
+// have been acknowledged, reporting those which have been outstanding for
+// longer than the watchdog timeout, and sends a new heartbeat to each link
+// without one pending. Links which are no longer active are pruned.
+func (s *Switch) checkHeartbeats(links []ChannelLink,
+	pending map[ChannelLink]*pendingHeartbeat) {
+
+	active := make(map[ChannelLink]struct{}, len(links))
+	for _, link := range links {
+		active[link] = struct{}{}
+
+		heartbeat, ok := pending[link]
+		if !ok {
+			pending[link] = &pendingHeartbeat{
+				ack:  link.Heartbeat(),
+				sent: time.Now(),
+			}
+			continue
+		}
+
+		select {
+		case <-heartbeat.ack:
+			if heartbeat.reported {
+				log.Infof("ChannelLink(%v) is responsive again "+
+					"after %v", link.ShortChanID(),
+					time.Since(heartbeat.sent))
+			}
+			delete(pending, link)
+			continue
+
+		default:
+		}
+
+		waited := time.Since(heartbeat.sent)
+		if heartbeat.reported || waited < s.watchdogTimeout() {
+			continue
+		}
+		heartbeat.reported = true
+
+		err := errors.Errorf("ChannelLink(%v) hasn't acknowledged "+
+			"heartbeat for %v", link.ShortChanID(), waited)
+		log.Criticalf("%v, taking action: %v", err,
+			s.cfg.WatchdogAction)
+
+		if s.cfg.WatchdogAction == WatchdogDisconnect {
+			go link.Peer().Disconnect(DisconnectLinkUnresponsive, err)
+		}
+	}
+
+	for link := range pending {
+		if _, ok := active[link]; !ok {
+			delete(pending, link)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package htlcswitch
+
+import (
+	"testing"
+	"time"
+)
+
+// watchdogPeer is a mock peer which reports disconnects over a channel,
+// rather than failing the test.
+type watchdogPeer struct {
+	*mockServer
+
+	disconnects chan DisconnectReason
+}
+
+func (p *watchdogPeer) Disconnect(reason DisconnectReason, err error) {
+	p.disconnects <- reason
+}
+
+// TestSwitchLinkWatchdog tests that the link watchdog disconnects from the
+// peer of a link which stops acknowledging heartbeats, while leaving
+// responsive links untouched.
+func TestSwitchLinkWatchdog(t *testing.T) {
+	t.Parallel()
+
+	alicePeer := &watchdogPeer{
+		mockServer:  newMockServer(t, "alice"),
+		disconnects: make(chan DisconnectReason, 1),
+	}
+	bobPeer := &watchdogPeer{
+		mockServer:  newMockServer(t, "bob"),
+		disconnects: make(chan DisconnectReason, 1),
+	}
+
+	s := New(Config{
+		WatchdogInterval: 10 * time.Millisecond,
+		WatchdogTimeout:  50 * time.Millisecond,
+		WatchdogAction:   WatchdogDisconnect,
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("unable to start switch: %v", err)
+	}
+	defer s.Stop()
+
+	aliceChannelLink := newMockChannelLink(
+		s, chanID1, aliceChanID, alicePeer, true,
+	)
+	bobChannelLink := newMockChannelLink(
+		s, chanID2, bobChanID, bobPeer, true,
+	)
+	bobChannelLink.wedged = true
+	if err := s.AddLink(aliceChannelLink); err != nil {
+		t.Fatalf("unable to add alice link: %v", err)
+	}
+	if err := s.AddLink(bobChannelLink); err != nil {
+		t.Fatalf("unable to add bob link: %v", err)
+	}
+
+	select {
+	case reason := <-bobPeer.disconnects:
+		if reason != DisconnectLinkUnresponsive {
+			t.Fatalf("expected disconnect due to %v, got %v",
+				DisconnectLinkUnresponsive, reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wedged link wasn't detected")
+	}
+
+	// The wedged link is only reported once, and the responsive link not
+	// at all.
+	select {
+	case <-bobPeer.disconnects:
+		t.Fatalf("wedged link reported twice")
+	case <-alicePeer.disconnects:
+		t.Fatalf("responsive link reported as wedged")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/htlcswitch"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/roasbeef/btcd/chaincfg/chainhash"
 	"github.com/roasbeef/btcutil"
@@ -161,6 +162,77 @@ func (i *invoiceRegistry) SettleInvoice(rHash chainhash.Hash) error {
 	return nil
 }
 
+// BatchSettleInvoice attempts to mark each of the invoices corresponding to
+// the passed settle requests as settled, within a single database
+// transaction. Debug invoices are never settled, so requests for them always
+// succeed.
+//
+// NOTE: This method is a part of the htlcswitch.InvoiceDatabase interface.
+func (i *invoiceRegistry) BatchSettleInvoice(
+	reqs []htlcswitch.SettleRequest) []error {
+
+	errs := make([]error, len(reqs))
+
+	// We'll first filter out any debug invoices, collecting the payment
+	// hashes of the remainder to be settled on disk.
+	var (
+		paymentHashes [][32]byte
+		reqIndexes    []int
+	)
+	i.RLock()
+	for j, req := range reqs {
+		if _, ok := i.debugInvoices[req.PaymentHash]; ok {
+			continue
+		}
+
+		ltndLog.Debugf("Settling invoice %x", req.PaymentHash[:])
+
+		paymentHashes = append(paymentHashes, req.PaymentHash)
+		reqIndexes = append(reqIndexes, j)
+	}
+	i.RUnlock()
+
+	if len(paymentHashes) == 0 {
+		return errs
+	}
+
+	// If the transaction itself fails, then none of the invoices were
+	// settled, so the error applies to each of them.
+	settleErrs, err := i.cdb.BatchSettleInvoices(paymentHashes)
+	if err != nil {
+		for _, j := range reqIndexes {
+			errs[j] = err
+		}
+		return errs
+	}
+
+	var settled []chainhash.Hash
+	for k, j := range reqIndexes {
+		errs[j] = settleErrs[k]
+		if settleErrs[k] == nil {
+			settled = append(settled, paymentHashes[k])
+		}
+	}
+
+	// Launch a new goroutine to notify any/all registered invoice
+	// notification clients of the invoices we just settled.
+	go func() {
+		for _, rHash := range settled {
+			invoice, err := i.cdb.LookupInvoice(rHash)
+			if err != nil {
+				ltndLog.Errorf("unable to find invoice: %v", err)
+				continue
+			}
+
+			ltndLog.Infof("Payment received: %v", spew.Sdump(invoice))
+
+			i.notifyClients(invoice, true)
+		}
+	}()
+
+	return errs
+}
+
 // notifyClients notifies all currently registered invoice notification clients
 // of a newly added/settled invoice.
 func (i *invoiceRegistry) notifyClients(invoice *channeldb.Invoice, settle bool) {
@@ -3825,6 +3825,25 @@ func (lc *LightningChannel) AddHTLC(htlc *lnwire.UpdateAddHTLC) (uint64, error)
 	return pd.HtlcIndex, nil
 }
 
+// ValidateAddHTLC returns an error if an HTLC of the passed amount couldn't
+// currently be added to our local update log. The same constraints as within
+// AddHTLC are checked, but the update log is left untouched.
+func (lc *LightningChannel) ValidateAddHTLC(amt lnwire.MilliSatoshi) error {
+	lc.RLock()
+	defer lc.RUnlock()
+
+	pd := &PaymentDescriptor{
+		EntryType: Add,
+		Amount:    amt,
+		LogIndex:  lc.localUpdateLog.logIndex,
+		HtlcIndex: lc.localUpdateLog.htlcCounter,
+	}
+
+	remoteACKedIndex := lc.localCommitChain.tail().theirMessageIndex
+	return lc.validateCommitmentSanity(remoteACKedIndex,
+		lc.localUpdateLog.logIndex, true, pd)
+}
+
 // ReceiveHTLC adds an HTLC to the state machine's remote update log. This
 // method should be called in response to receiving a new HTLC from the remote
 // party.
@@ -5058,6 +5077,107 @@ func (lc *LightningChannel) AvailableBalance() lnwire.MilliSatoshi {
 	return bal
 }
 
+// OutgoingValueInFlight returns the total value of the HTLC's we've offered
+// which haven't yet been settled or failed, including those which aren't yet
+// covered by a commitment.
+func (lc *LightningChannel) OutgoingValueInFlight() lnwire.MilliSatoshi {
+	lc.RLock()
+	defer lc.RUnlock()
+
+	remoteACKedIndex := lc.localCommitChain.tip().theirMessageIndex
+	htlcView := lc.fetchHTLCView(remoteACKedIndex,
+		lc.localUpdateLog.logIndex)
+	_, _, _, filteredView, _ := lc.computeView(htlcView, false, false)
+
+	var inFlight lnwire.MilliSatoshi
+	for _, entry := range filteredView.ourUpdates {
+		if entry.EntryType == Add {
+			inFlight += entry.Amount
+		}
+	}
+
+	return inFlight
+}
+
+// NumOutgoingHTLCs returns the number of HTLC's we've offered which haven't
+// yet been settled or failed, and as such occupy one of the slots limited by
+// MaxAcceptedHtlcs.
+func (lc *LightningChannel) NumOutgoingHTLCs() uint16 {
+	lc.RLock()
+	defer lc.RUnlock()
+
+	remoteACKedIndex := lc.localCommitChain.tip().theirMessageIndex
+	htlcView := lc.fetchHTLCView(remoteACKedIndex,
+		lc.localUpdateLog.logIndex)
+	_, _, _, filteredView, _ := lc.computeView(htlcView, false, false)
+
+	var numHTLCs uint16
+	for _, entry := range filteredView.ourUpdates {
+		if entry.EntryType == Add {
+			numHTLCs++
+		}
+	}
+
+	return numHTLCs
+}
+
+// NextRemoteHtlcIndex returns the index the next HTLC added by the remote
+// party is expected to carry.
+func (lc *LightningChannel) NextRemoteHtlcIndex() uint64 {
+	lc.RLock()
+	defer lc.RUnlock()
+
+	return lc.remoteUpdateLog.htlcCounter
+}
+
+// RemoteLogHtlcs returns the HTLC's added by the remote party which remain
+// within the remote update log, i.e. those which haven't yet been compacted
+// away after being settled or failed.
+func (lc *LightningChannel) RemoteLogHtlcs() []channeldb.HTLC {
+	lc.RLock()
+	defer lc.RUnlock()
+
+	htlcs := make([]channeldb.HTLC, 0, len(lc.remoteUpdateLog.htlcIndex))
+	for _, entry := range lc.remoteUpdateLog.htlcIndex {
+		pd := entry.Value.(*PaymentDescriptor)
+		htlcs = append(htlcs, channeldb.HTLC{
+			RHash:         [32]byte(pd.RHash),
+			Amt:           pd.Amount,
+			RefundTimeout: pd.Timeout,
+			OnionBlob:     pd.OnionBlob,
+			HtlcIndex:     pd.HtlcIndex,
+			LogIndex:      pd.LogIndex,
+			Incoming:      true,
+		})
+	}
+
+	return htlcs
+}
+
+// LookupRemoteHtlc returns the HTLC added by the remote party with the passed
+// index, if it remains within the remote update log.
+func (lc *LightningChannel) LookupRemoteHtlc(htlcIndex uint64) (channeldb.HTLC,
+	bool) {
+
+	lc.RLock()
+	defer lc.RUnlock()
+
+	pd := lc.remoteUpdateLog.lookupHtlc(htlcIndex)
+	if pd == nil {
+		return channeldb.HTLC{}, false
+	}
+
+	return channeldb.HTLC{
+		RHash:         [32]byte(pd.RHash),
+		Amt:           pd.Amount,
+		RefundTimeout: pd.Timeout,
+		OnionBlob:     pd.OnionBlob,
+		HtlcIndex:     pd.HtlcIndex,
+		LogIndex:      pd.LogIndex,
+		Incoming:      true,
+	}, true
+}
+
 // availableBalance is the private, non mutexed version of AvailableBalance.
 // This method is provided so methods that already hold the lock can access
 // this method. Additionally, the total weight of the next to be created
@@ -5313,6 +5433,48 @@ func (lc *LightningChannel) RemoteNextRevocation() *btcec.PublicKey {
 	return lc.channelState.RemoteNextRevocation
 }
 
+// HtlcDustThreshold returns the smallest HTLC amount which wouldn't be trimmed
+// as dust from either commitment transaction at the passed fee rate. As the
+// second-level transaction used on each commitment depends on the direction of
+// the HTLC, incoming should be true if the HTLC was offered to us by the
+// remote party.
+func (lc *LightningChannel) HtlcDustThreshold(incoming bool,
+	feePerKw SatPerKWeight) btcutil.Amount {
+
+	lc.RLock()
+	defer lc.RUnlock()
+
+	// An HTLC offered to us is claimed via a success transaction on our
+	// commitment, and a timeout transaction on theirs. The reverse holds
+	// for HTLC's that we offer.
+	localFee, remoteFee := htlcTimeoutFee(feePerKw), htlcSuccessFee(feePerKw)
+	if incoming {
+		localFee, remoteFee = htlcSuccessFee(feePerKw),
+			htlcTimeoutFee(feePerKw)
+	}
+
+	localThreshold := lc.channelState.LocalChanCfg.DustLimit + localFee
+	remoteThreshold := lc.channelState.RemoteChanCfg.DustLimit + remoteFee
+	if localThreshold > remoteThreshold {
+		return localThreshold
+	}
+
+	return remoteThreshold
+}
+
+// CommitHeights returns the heights of the tips of the local and remote
+// commitment chains, along with the height of the oldest remote commitment
+// which hasn't yet been revoked. This is the next commitment that the remote
+// party is expected to revoke.
+func (lc *LightningChannel) CommitHeights() (uint64, uint64, uint64) {
+	lc.RLock()
+	defer lc.RUnlock()
+
+	return lc.localCommitChain.tip().height,
+		lc.remoteCommitChain.tip().height,
+		lc.remoteCommitChain.tail().height
+}
+
 // IsInitiator returns true if we were the ones that initiated the funding
 // workflow which led to the creation of this channel. Otherwise, it returns
 // false.
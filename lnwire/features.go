@@ -21,6 +21,11 @@ const (
 	// connection is established.
 	InitialRoutingSync FeatureBit = 3
 
+	// QuiescenceOptional is a local feature bit signalling that the
+	// sending node understands the stfu message, with which a channel can
+	// be brought to a quiescent state.
+	QuiescenceOptional FeatureBit = 35
+
 	// maxAllowedSize is a maximum allowed size of feature vector.
 	//
 	// NOTE: Within the protocol, the maximum allowed message size is 65535
@@ -43,6 +48,7 @@ const (
 // bits is provided in the BOLT-09 specification.
 var LocalFeatures = map[FeatureBit]string{
 	InitialRoutingSync: "initial-routing-sync",
+	QuiescenceOptional: "quiescence",
 }
 
 // GlobalFeatures is a mapping of known global feature bits to a descriptive
@@ -0,0 +1,76 @@
+package lnwire
+
+import (
+	"io"
+)
+
+// Stfu is sent by either party of a channel in order to quiesce it. Once a
+// party has sent Stfu, it will no longer send any updates for the channel.
+// Once both parties have sent Stfu, the channel is quiescent, and will remain
+// so until the protocol upgrade which required quiescence has completed, or
+// the parties reconnect.
+type Stfu struct {
+	// ChanID is the channel that the sender wishes to quiesce.
+	ChanID ChannelID
+
+	// Initiator is true if the sender is requesting quiescence, and false
+	// if the sender is replying to a request from the remote party.
+	Initiator bool
+}
+
+// NewStfu creates a new Stfu message.
+func NewStfu(chanID ChannelID, initiator bool) *Stfu {
+	return &Stfu{
+		ChanID:    chanID,
+		Initiator: initiator,
+	}
+}
+
+// A compile time check to ensure Stfu implements the lnwire.Message
+// interface.
+var _ Message = (*Stfu)(nil)
+
+// Decode deserializes a serialized Stfu message stored in the passed
+// io.Reader observing the specified protocol version.
+//
+// This is part of the lnwire.Message interface.
+func (s *Stfu) Decode(r io.Reader, pver uint32) error {
+	var initiator uint8
+	if err := readElements(r, &s.ChanID, &initiator); err != nil {
+		return err
+	}
+
+	s.Initiator = initiator != 0
+
+	return nil
+}
+
+// Encode serializes the target Stfu into the passed io.Writer observing the
+// protocol version specified.
+//
+// This is part of the lnwire.Message interface.
+func (s *Stfu) Encode(w io.Writer, pver uint32) error {
+	var initiator uint8
+	if s.Initiator {
+		initiator = 1
+	}
+
+	return writeElements(w, s.ChanID, initiator)
+}
+
+// MsgType returns the integer uniquely identifying this message type on the
+// wire.
+//
+// This is part of the lnwire.Message interface.
+func (s *Stfu) MsgType() MessageType {
+	return MsgStfu
+}
+
+// MaxPayloadLength returns the maximum allowed payload size for a Stfu
+// complete message observing the specified protocol version.
+//
+// This is part of the lnwire.Message interface.
+func (s *Stfu) MaxPayloadLength(uint32) uint32 {
+	// 32 + 1
+	return 33
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/lightningnetwork/lnd/brontide"
 	"github.com/lightningnetwork/lnd/contractcourt"
+	"github.com/lightningnetwork/lnd/discovery"
 
 	"bytes"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/roasbeef/btcd/connmgr"
 	"github.com/roasbeef/btcd/txscript"
 	"github.com/roasbeef/btcd/wire"
+	"github.com/roasbeef/btcutil"
 )
 
 var (
@@ -95,6 +97,10 @@ type peer struct {
 	// our last ping message.
 	pingLastSend int64
 
+	// pongLastRecv is the Unix time expressed in nanoseconds when we
+	// received the last pong message in response to our pings.
+	pongLastRecv int64
+
 	// MUST be used atomically.
 	started    int32
 	disconnect int32
@@ -399,12 +405,54 @@ func (p *peer) loadActiveChannels(chans []*channeldb.OpenChannel) error {
 			SyncStates: true,
 			BatchTicker: htlcswitch.NewBatchTicker(
 				time.NewTicker(50 * time.Millisecond)),
-			BatchSize: 10,
+			BatchSize:          10,
+			RejectStressDust:   cfg.RejectStressDust,
+			DustStressHeadroom: lnwallet.SatPerVByte(cfg.DustStressHeadroom),
+			WarmUpPeriod:       cfg.LinkWarmUp,
+			ResolutionBatching: htlcswitch.ResolutionBatchPolicy{
+				MaxBatchSize: cfg.ResolutionBatchSize,
+				MaxDelay:     cfg.ResolutionBatchDelay,
+			},
+			FailureDelay: htlcswitch.FailureDelayPolicy{
+				MinDelay: cfg.FailureDelayMin,
+				MaxDelay: cfg.FailureDelayMax,
+			},
+			ForwardResolutionJitter: cfg.ForwardJitter,
+			SoftReserve: lnwire.NewMSatFromSatoshis(
+				btcutil.Amount(cfg.SoftReserve),
+			),
+			MinClaimCostMultiple: cfg.MinClaimCostMultiple,
+			PolicyDrift: htlcswitch.PolicyDriftPolicy{
+				FeeChangeThreshold: cfg.PolicyDriftThreshold,
+				MinInterval:        cfg.PolicyDriftInterval,
+			},
+			GossipPolicyUpdate: createGossipPolicyUpdate(
+				p.server.authGossiper, *chanPoint,
+			),
+			MaxDownstreamFailLatency: cfg.MaxDownstreamFailLatency,
+			MaxOverflowWait:          cfg.MaxOverflowWait,
+			MinChannelAge:            cfg.MinChannelAge,
+			UnderpaymentGrace:        cfg.UnderpaymentGrace,
+			SignChannelUpdate:        p.server.signChannelUpdate,
+			QuiescenceSupported: p.remoteLocalFeatures.HasFeature(
+				lnwire.QuiescenceOptional,
+			),
 		}
 		link := htlcswitch.NewChannelLink(linkCfg, lnChan,
 			uint32(currentHeight))
 
-		if err := p.server.htlcSwitch.AddLink(link); err != nil {
+		err = p.server.htlcSwitch.AddLink(link)
+		switch {
+		// If this peer already has the maximum number of links
+		// permitted by the switch, then we'll skip this channel rather
+		// than failing to load the remainder.
+		case err == htlcswitch.ErrMaxLinksPerPeer:
+			peerLog.Warnf("Unable to add link for ChannelPoint(%v), "+
+				"peer %x has reached the max number of links",
+				chanPoint, p.PubKey())
+			continue
+
+		case err != nil:
 			return err
 		}
 	}
@@ -422,12 +470,16 @@ func (p *peer) WaitForDisconnect() {
 // Disconnect terminates the connection with the remote peer. Additionally, a
 // signal is sent to the server and htlcSwitch indicating the resources
 // allocated to the peer can now be cleaned up.
-func (p *peer) Disconnect(reason error) {
+func (p *peer) Disconnect(reason htlcswitch.DisconnectReason, err error) {
 	if !atomic.CompareAndSwapInt32(&p.disconnect, 0, 1) {
 		return
 	}
 
-	peerLog.Tracef("Disconnecting %s, reason: %v", p, reason)
+	peerLog.Tracef("Disconnecting %s, reason: %v: %v", p, reason, err)
+
+	// Record the cause of the disconnection so operators are able to
+	// inspect why this peer was dropped.
+	p.server.htlcSwitch.RecordDisconnect(p.PubKey(), reason, err)
 
 	// Ensure that the TCP connection is properly closed before continuing.
 	p.conn.Close()
@@ -665,7 +717,7 @@ func (p *peer) readHandler() {
 	idleTimer := time.AfterFunc(idleTimeout, func() {
 		err := fmt.Errorf("Peer %s no answer for %s -- disconnecting",
 			p, idleTimeout)
-		p.Disconnect(err)
+		p.Disconnect(htlcswitch.DisconnectIdleTimeout, err)
 	})
 
 	discStream := newDiscMsgStream(p)
@@ -709,9 +761,11 @@ out:
 			// last ping message, we'll use the time in which we
 			// sent the ping message to measure a rough estimate of
 			// round trip time.
+			now := time.Now().UnixNano()
 			pingSendTime := atomic.LoadInt64(&p.pingLastSend)
-			delay := (time.Now().UnixNano() - pingSendTime) / 1000
+			delay := (now - pingSendTime) / 1000
 			atomic.StoreInt64(&p.pingTime, delay)
+			atomic.StoreInt64(&p.pongLastRecv, now)
 
 		case *lnwire.Ping:
 			pongBytes := make([]byte, msg.NumPongBytes)
@@ -769,6 +823,9 @@ out:
 		case *lnwire.ChannelReestablish:
 			isChanUpdate = true
 			targetChan = msg.ChanID
+		case *lnwire.Stfu:
+			isChanUpdate = true
+			targetChan = msg.ChanID
 
 		case *lnwire.ChannelUpdate,
 			*lnwire.ChannelAnnouncement,
@@ -805,7 +862,10 @@ out:
 
 	p.wg.Done()
 
-	p.Disconnect(errors.New("read handler closed"))
+	p.Disconnect(
+		htlcswitch.DisconnectReadFailure,
+		errors.New("read handler closed"),
+	)
 
 	for cid, chanStream := range chanMsgStreams {
 		chanStream.Stop()
@@ -917,6 +977,10 @@ func messageSummary(msg lnwire.Message) string {
 	case *lnwire.ChannelReestablish:
 		return fmt.Sprintf("next_local_height=%v, remote_tail_height=%v",
 			msg.NextLocalCommitHeight, msg.RemoteCommitTailHeight)
+
+	case *lnwire.Stfu:
+		return fmt.Sprintf("chan_id=%v, initiator=%v", msg.ChanID,
+			msg.Initiator)
 	}
 
 	return ""
@@ -1019,7 +1083,10 @@ func (p *peer) writeMessage(msg lnwire.Message) error {
 //
 // NOTE: This method MUST be run as a goroutine.
 func (p *peer) writeHandler() {
-	var exitErr error
+	var (
+		exitErr    error
+		exitReason htlcswitch.DisconnectReason
+	)
 out:
 	for {
 		select {
@@ -1047,18 +1114,20 @@ out:
 
 			if err != nil {
 				exitErr = errors.Errorf("unable to write message: %v", err)
+				exitReason = htlcswitch.WriteErrorReason(err)
 				break out
 			}
 
 		case <-p.quit:
 			exitErr = errors.Errorf("peer exiting")
+			exitReason = htlcswitch.DisconnectShutdown
 			break out
 		}
 	}
 
 	p.wg.Done()
 
-	p.Disconnect(exitErr)
+	p.Disconnect(exitReason, exitErr)
 
 	peerLog.Tracef("writeHandler for peer %v done", p)
 }
@@ -1135,6 +1204,30 @@ func (p *peer) PingTime() int64 {
 	return atomic.LoadInt64(&p.pingTime)
 }
 
+// PingLatency returns the round trip time of the last ping to the peer. If
+// the peer has yet to answer our latest ping, and it has been outstanding for
+// longer than the last round trip time, then the time it has been outstanding
+// for is returned instead, such that an unresponsive peer isn't masked by a
+// stale measurement.
+//
+// NOTE: Part of the htlcswitch.Peer interface.
+func (p *peer) PingLatency() time.Duration {
+	latency := time.Duration(atomic.LoadInt64(&p.pingTime)) *
+		time.Microsecond
+
+	lastSend := atomic.LoadInt64(&p.pingLastSend)
+	if lastSend == 0 || lastSend <= atomic.LoadInt64(&p.pongLastRecv) {
+		return latency
+	}
+
+	outstanding := time.Since(time.Unix(0, lastSend))
+	if outstanding > latency {
+		return outstanding
+	}
+
+	return latency
+}
+
 // queueMsg queues a new lnwire.Message to be eventually sent out on the
 // wire. It returns an error if we failed to queue the message. An error
 // is sent on errChan if the message fails being sent to the peer, or
@@ -1292,7 +1385,39 @@ out:
 				SyncStates: false,
 				BatchTicker: htlcswitch.NewBatchTicker(
 					time.NewTicker(50 * time.Millisecond)),
-				BatchSize: 10,
+				BatchSize:        10,
+				RejectStressDust: cfg.RejectStressDust,
+				DustStressHeadroom: lnwallet.SatPerVByte(
+					cfg.DustStressHeadroom,
+				),
+				ResolutionBatching: htlcswitch.ResolutionBatchPolicy{
+					MaxBatchSize: cfg.ResolutionBatchSize,
+					MaxDelay:     cfg.ResolutionBatchDelay,
+				},
+				FailureDelay: htlcswitch.FailureDelayPolicy{
+					MinDelay: cfg.FailureDelayMin,
+					MaxDelay: cfg.FailureDelayMax,
+				},
+				ForwardResolutionJitter: cfg.ForwardJitter,
+				SoftReserve: lnwire.NewMSatFromSatoshis(
+					btcutil.Amount(cfg.SoftReserve),
+				),
+				MinClaimCostMultiple: cfg.MinClaimCostMultiple,
+				PolicyDrift: htlcswitch.PolicyDriftPolicy{
+					FeeChangeThreshold: cfg.PolicyDriftThreshold,
+					MinInterval:        cfg.PolicyDriftInterval,
+				},
+				GossipPolicyUpdate: createGossipPolicyUpdate(
+					p.server.authGossiper, *chanPoint,
+				),
+				MaxDownstreamFailLatency: cfg.MaxDownstreamFailLatency,
+				MaxOverflowWait:          cfg.MaxOverflowWait,
+				MinChannelAge:            cfg.MinChannelAge,
+				UnderpaymentGrace:        cfg.UnderpaymentGrace,
+				SignChannelUpdate:        p.server.signChannelUpdate,
+				QuiescenceSupported: p.remoteLocalFeatures.HasFeature(
+					lnwire.QuiescenceOptional,
+				),
 			}
 			link := htlcswitch.NewChannelLink(linkConfig, newChan,
 				uint32(currentHeight))
@@ -1302,7 +1427,8 @@ out:
 			// local payments and also passively forward payments.
 			if err := p.server.htlcSwitch.AddLink(link); err != nil {
 				peerLog.Errorf("can't register new channel "+
-					"link(%v) with NodeKey(%x)", chanPoint, p.PubKey())
+					"link(%v) with NodeKey(%x): %v", chanPoint,
+					p.PubKey(), err)
 			}
 
 			close(newChanReq.done)
@@ -1803,3 +1929,21 @@ func createGetLastUpdate(router *routing.ChannelRouter,
 		return update, nil
 	}
 }
+
+// createGossipPolicyUpdate returns the handler which gossips a new channel
+// update for the passed channel carrying a link's forwarding policy.
+func createGossipPolicyUpdate(gossiper *discovery.AuthenticatedGossiper,
+	chanPoint wire.OutPoint) func(htlcswitch.ForwardingPolicy) error {
+
+	return func(policy htlcswitch.ForwardingPolicy) error {
+		chanPolicy := routing.ChannelPolicy{
+			FeeSchema: routing.FeeSchema{
+				BaseFee: policy.BaseFee,
+				FeeRate: uint32(policy.FeeRate),
+			},
+			TimeLockDelta: policy.TimeLockDelta,
+		}
+
+		return gossiper.PropagateChanPolicyUpdate(chanPolicy, chanPoint)
+	}
+}
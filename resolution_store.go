@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/htlcswitch"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// resolutionStore is an implementation of the htlcswitch.ResolutionStore
+// interface backed by the channeldb's resolution log.
+type resolutionStore struct {
+	log *channeldb.ResolutionLog
+}
+
+// AddResolution persists the passed resolution, replacing any prior
+// resolution for the same incoming HTLC.
+//
+// NOTE: Part of the htlcswitch.ResolutionStore interface.
+func (r *resolutionStore) AddResolution(
+	resolution *htlcswitch.ForwardResolution) error {
+
+	return r.log.AddResolution(&channeldb.ForwardResolution{
+		IncomingChanID: resolution.IncomingChanID,
+		IncomingHTLCID: resolution.IncomingHTLCID,
+		Amount:         resolution.Amount,
+		Preimage:       resolution.Preimage,
+		FailReason:     resolution.FailReason,
+	})
+}
+
+// RemoveResolution removes the resolution for the target incoming HTLC, if
+// any.
+//
+// NOTE: Part of the htlcswitch.ResolutionStore interface.
+func (r *resolutionStore) RemoveResolution(chanID lnwire.ShortChannelID,
+	htlcID uint64) error {
+
+	return r.log.RemoveResolution(chanID, htlcID)
+}
+
+// FetchResolutions returns all persisted resolutions.
+//
+// NOTE: Part of the htlcswitch.ResolutionStore interface.
+func (r *resolutionStore) FetchResolutions() ([]*htlcswitch.ForwardResolution,
+	error) {
+
+	dbResolutions, err := r.log.FetchResolutions()
+	if err != nil {
+		return nil, err
+	}
+
+	resolutions := make(
+		[]*htlcswitch.ForwardResolution, 0, len(dbResolutions),
+	)
+	for _, resolution := range dbResolutions {
+		resolutions = append(resolutions, &htlcswitch.ForwardResolution{
+			IncomingChanID: resolution.IncomingChanID,
+			IncomingHTLCID: resolution.IncomingHTLCID,
+			Amount:         resolution.Amount,
+			Preimage:       resolution.Preimage,
+			FailReason:     resolution.FailReason,
+		})
+	}
+
+	return resolutions, nil
+}
+
+// A compile time check to ensure resolutionStore implements the
+// htlcswitch.ResolutionStore interface.
+var _ htlcswitch.ResolutionStore = (*resolutionStore)(nil)
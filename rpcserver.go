@@ -3152,8 +3152,9 @@ func (r *rpcServer) UpdateChannelPolicy(ctx context.Context,
 
 	// With the scope resolved, we'll now send this to the
 	// AuthenticatedGossiper so it can propagate the new policy for our
-	// target channel(s).
-	err := r.server.authGossiper.PropagateChanPolicyUpdate(
+	// target channel(s). As the user explicitly requested this change,
+	// it bypasses the channel update rate limit.
+	err := r.server.authGossiper.PropagateManualChanPolicyUpdate(
 		chanPolicy, targetChans...,
 	)
 	if err != nil {
@@ -3164,13 +3165,17 @@ func (r *rpcServer) UpdateChannelPolicy(ctx context.Context,
 	// channels.
 	//
 	// We create a partially policy as the logic won't overwrite a valid
-	// sub-policy with a "nil" one.
+	// sub-policy with a "nil" one. As the new policy has just been
+	// gossiped, the links shouldn't gossip it once again.
 	p := htlcswitch.ForwardingPolicy{
 		BaseFee:       baseFeeMsat,
 		FeeRate:       lnwire.MilliSatoshi(feeRateFixed),
 		TimeLockDelta: req.TimeLockDelta,
 	}
-	err = r.server.htlcSwitch.UpdateForwardingPolicies(p, targetChans...)
+	err = r.server.htlcSwitch.UpdateDirectionalPolicies(
+		htlcswitch.DirectionalPolicy{Outbound: p, Gossiped: true},
+		targetChans...,
+	)
 	if err != nil {
 		// If we're unable update the fees due to the links not being
 		// online, then we don't need to fail the call. We'll simply
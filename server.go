@@ -197,8 +197,43 @@ func newServer(listenAddrs []string, chanDB *channeldb.DB, cc *chainControl,
 			debugPre[:], debugHash[:])
 	}
 
+	maintenanceWindows := make(
+		[]htlcswitch.MaintenanceWindow, 0, len(cfg.MaintenanceWindows),
+	)
+	for _, w := range cfg.MaintenanceWindows {
+		window, err := htlcswitch.ParseMaintenanceWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		maintenanceWindows = append(maintenanceWindows, window)
+	}
+
+	watchdogAction := htlcswitch.WatchdogLog
+	if cfg.LinkWatchdogDisconnect {
+		watchdogAction = htlcswitch.WatchdogDisconnect
+	}
+
 	s.htlcSwitch = htlcswitch.New(htlcswitch.Config{
-		SelfKey: s.identityPriv.PubKey(),
+		SelfKey:               s.identityPriv.PubKey(),
+		MaxLinksPerPeer:       cfg.MaxLinksPerPeer,
+		DecisionHistorySize:   cfg.DecisionHistorySize,
+		MaintenanceWindows:    maintenanceWindows,
+		WatchdogInterval:      cfg.LinkWatchdogInterval,
+		WatchdogTimeout:       cfg.LinkWatchdogTimeout,
+		WatchdogAction:        watchdogAction,
+		BandwidthBuffer:       cfg.ForwardBandwidthBuffer,
+		MaxHTLCAmount:         cfg.MaxHTLCAmount,
+		LocalPaymentFailovers: cfg.LocalPaymentFailovers,
+		WarmUpPeriod:          cfg.LinkWarmUp,
+		ResolutionStore: &resolutionStore{
+			log: chanDB.NewResolutionLog(),
+		},
+		ForwardVolumeLimit: htlcswitch.VolumeLimit{
+			Window: cfg.ForwardVolumeWindow,
+			MaxVolume: lnwire.NewMSatFromSatoshis(
+				btcutil.Amount(cfg.MaxForwardVolume),
+			),
+		},
 		LocalChannelClose: func(pubKey []byte,
 			request *htlcswitch.ChanClose) {
 
@@ -341,6 +376,10 @@ func newServer(listenAddrs []string, chanDB *channeldb.DB, cc *chainControl,
 		RetransmitDelay:  time.Minute * 30,
 		DB:               chanDB,
 		AnnSigner:        s.nodeSigner,
+		UpdateRateLimit: discovery.ChannelUpdateRateLimit{
+			MinInterval: cfg.ChanUpdateInterval,
+			MaxPerDay:   cfg.MaxChanUpdatesPerDay,
+		},
 	},
 		s.identityPriv.PubKey(),
 	)
@@ -421,7 +460,7 @@ func newServer(listenAddrs []string, chanDB *channeldb.DB, cc *chainControl,
 		ChainIO:      cc.chainIO,
 		MarkLinkInactive: func(chanPoint wire.OutPoint) error {
 			chanID := lnwire.NewChanIDFromOutPoint(&chanPoint)
-			return s.htlcSwitch.RemoveLink(chanID)
+			return s.htlcSwitch.MarkLinkPendingClose(chanID)
 		},
 		IsOurAddress: func(addr btcutil.Address) bool {
 			_, err := cc.wallet.GetPrivKey(addr)
@@ -837,6 +876,20 @@ func (s *server) genNodeAnnouncement(
 	return *s.currentNodeAnn, nil
 }
 
+// signChannelUpdate signs the passed channel update with our node's identity
+// key, replacing its signature.
+func (s *server) signChannelUpdate(update *lnwire.ChannelUpdate) error {
+	sig, err := discovery.SignAnnouncement(
+		s.nodeSigner, s.identityPriv.PubKey(), update,
+	)
+	if err != nil {
+		return err
+	}
+
+	update.Signature, err = lnwire.NewSigFromSignature(sig)
+	return err
+}
+
 type nodeAddresses struct {
 	pubKey    *btcec.PublicKey
 	addresses []net.Addr
@@ -1341,7 +1394,7 @@ func (s *server) peerConnected(conn net.Conn, connReq *connmgr.ConnReq,
 
 	// With the brontide connection established, we'll now craft the local
 	// feature vector to advertise to the remote node.
-	localFeatures := lnwire.NewRawFeatureVector()
+	localFeatures := lnwire.NewRawFeatureVector(lnwire.QuiescenceOptional)
 
 	// We'll only request a full channel graph sync if we detect that that
 	// we aren't fully synced yet.
@@ -1363,7 +1416,10 @@ func (s *server) peerConnected(conn net.Conn, connReq *connmgr.ConnReq,
 	// Attempt to start the peer, if we're unable to do so, then disconnect
 	// this peer.
 	if err := p.Start(); err != nil {
-		p.Disconnect(errors.Errorf("unable to start peer: %v", err))
+		p.Disconnect(
+			htlcswitch.DisconnectStartupFailure,
+			errors.Errorf("unable to start peer: %v", err),
+		)
 		return
 	}
 
@@ -1561,7 +1617,7 @@ func (s *server) addPeer(p *peer) {
 
 	// Ignore new peers if we're shutting down.
 	if s.Stopped() {
-		p.Disconnect(ErrServerShuttingDown)
+		p.Disconnect(htlcswitch.DisconnectShutdown, ErrServerShuttingDown)
 		return
 	}
 
@@ -1614,7 +1670,10 @@ func (s *server) removePeer(p *peer) {
 
 	// As the peer is now finished, ensure that the TCP connection is
 	// closed and all of its related goroutines have exited.
-	p.Disconnect(fmt.Errorf("server: disconnecting peer %v", p))
+	p.Disconnect(
+		htlcswitch.DisconnectShutdown,
+		fmt.Errorf("server: disconnecting peer %v", p),
+	)
 
 	// If this peer had an active persistent connection request, remove it.
 	if p.connReq != nil {
@@ -1762,9 +1821,14 @@ func (s *server) DisconnectPeer(pubKey *btcec.PublicKey) error {
 	delete(s.persistentPeers, pubStr)
 	delete(s.persistentPeersBackoff, pubStr)
 
-	// Remove the current peer from the server's internal state and signal
-	// that the peer termination watcher does not need to execute for this
-	// peer.
+	// Disconnect the peer, noting that this was requested by the
+	// operator, then remove the current peer from the server's internal
+	// state and signal that the peer termination watcher does not need to
+	// execute for this peer.
+	peer.Disconnect(
+		htlcswitch.DisconnectOperatorRequested,
+		errors.New("disconnect requested by operator"),
+	)
 	s.removePeer(peer)
 	s.ignorePeerTermination[peer] = struct{}{}
 